@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Annotations mark the moments a poll's results moved for a reason: a
+// debate starting, a TV mention, a bot wave taken out. They are added with
+// POST /polls/{id}/annotations, listed with GET, and come with the results
+// with ?include=annotations for dashboards to draw on the timeline.
+
+// maxAnnotationText is the longest text an annotation takes
+const maxAnnotationText = 280
+
+// annotation is a document of the annotations collection
+type annotation struct {
+	ID   bson.ObjectId `json:"id" bson:"_id"`
+	Poll string        `json:"poll_id" bson:"poll_id"`
+	// Time is the moment annotated, when it was added unless it says
+	Time time.Time `json:"time" bson:"time"`
+	Text string    `json:"text" bson:"text"`
+	// Options are the options it is about, none when about the whole poll
+	Options []string  `json:"options,omitempty" bson:"options,omitempty"`
+	By      string    `json:"by,omitempty" bson:"by,omitempty"`
+	Created time.Time `json:"created" bson:"created"`
+}
+
+func (s *Server) annotations(session *mgo.Session) *mgo.Collection {
+	return session.DB(s.database).C("annotations")
+}
+
+// annotationsOf returns the annotations of the poll id in time order
+func (s *Server) annotationsOf(session *mgo.Session, id string) ([]annotation, error) {
+	list := []annotation{}
+	err := s.annotations(session).Find(bson.M{"poll_id": id}).Sort("time").All(&list)
+	return list, err
+}
+
+// GET lists the annotations of the poll id, POST annotates it
+func (s *Server) handlePollAnnotations(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	if r.Method == "GET" {
+		list, err := s.annotationsOf(session, id)
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load annotations", err)
+			return
+		}
+		respond(w, r, http.StatusOK, list)
+		return
+	}
+	var a annotation
+	if err := decodeBody(r, &a); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read annotation from request", err)
+		return
+	}
+	var p poll
+	if err := s.polls(session).Find(notDeleted(bson.M{"_id": bson.ObjectIdHex(id)})).One(&p); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	if err := validateAnnotation(&a, &p); err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	a.ID = bson.NewObjectId()
+	a.Poll = id
+	a.By = createdBy(r.Context())
+	a.Created = time.Now().UTC()
+	if a.Time.IsZero() {
+		a.Time = a.Created
+	}
+	if err := s.annotations(session).Insert(&a); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to save annotation", err)
+		return
+	}
+	respond(w, r, http.StatusCreated, a)
+}
+
+// validateAnnotation checks a against the options of p
+func validateAnnotation(a *annotation, p *poll) error {
+	a.Text = strings.TrimSpace(a.Text)
+	if a.Text == "" {
+		return errors.New("an annotation needs a text")
+	}
+	if len(a.Text) > maxAnnotationText {
+		return fmt.Errorf("the text is %d bytes, annotations take %d at most", len(a.Text), maxAnnotationText)
+	}
+	for _, o := range a.Options {
+		if !hasOption(p.Options, o) {
+			return fmt.Errorf("the poll has no option %q", o)
+		}
+	}
+	a.Time = a.Time.UTC()
+	return nil
+}
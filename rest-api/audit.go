@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Admin actions, every request that creates, changes or deletes polls, are
+// written to the audit log as a JSON object per line, allowed or not.
+
+// auditEntry is a line of the audit log
+type auditEntry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Role   string    `json:"role"`
+	Auth   string    `json:"auth"`   // api_key or jwt
+	Action string    `json:"action"` // the method and path, or the GraphQL mutation
+	Status int       `json:"status"`
+	Error  string    `json:"error,omitempty"`
+	Remote string    `json:"remote"`
+}
+
+// auditLog writes the entries to a file, or to stderr
+type auditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	f   *os.File // nil for stderr
+}
+
+// openAuditLog appends to the file named, stderr when name is empty
+func openAuditLog(name string) (*auditLog, error) {
+	if name == "" {
+		return &auditLog{enc: json.NewEncoder(os.Stderr)}, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{enc: json.NewEncoder(f), f: f}, nil
+}
+
+func (a *auditLog) Close() error {
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
+
+// record writes an entry for the action p took with r
+func (a *auditLog) record(r *http.Request, p principal, action string, status int, errMsg string) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(auditEntry{
+		Time:   time.Now().UTC(),
+		Actor:  p.Name,
+		Role:   p.Role,
+		Auth:   p.Auth,
+		Action: action,
+		Status: status,
+		Error:  errMsg,
+		Remote: remote,
+	}); err != nil {
+		log.Println("failed to write the audit log:", err)
+	}
+}
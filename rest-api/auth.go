@@ -0,0 +1,366 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Requests authenticate with an API key, in ?key=, X-API-Key or as a bearer
+// token, or with a JWT bearer token. Either gives the caller a role, and every
+// endpoint needs one: viewers read polls and results, poll admins also create,
+// change and delete polls, operators also run batch actions.
+
+// Roles, each can do what the ones before it can
+const (
+	roleViewer    = "viewer"
+	rolePollAdmin = "poll-admin"
+	roleOperator  = "operator"
+)
+
+var roleRanks = map[string]int{roleViewer: 1, rolePollAdmin: 2, roleOperator: 3}
+
+// devAPIKey is accepted when neither API keys nor JWTs are configured, as a
+// viewer unless -dev-key-role says otherwise
+const devAPIKey = "abc123ABC"
+
+// jwtLeeway is how far the clocks of the token issuer and the API may drift apart
+const jwtLeeway = 30 * time.Second
+
+// principal is who made a request
+type principal struct {
+	Name string // the API key's name or the token's subject
+	Role string
+	Auth string // api_key or jwt
+}
+
+// can reports whether p has role, or one above it
+func (p principal) can(role string) bool {
+	return roleRanks[p.Role] >= roleRanks[role]
+}
+
+var contextKeyPrincipal = &contextKey{"principal"}
+
+// principalFrom returns who made the request with ctx
+func principalFrom(ctx context.Context) (principal, bool) {
+	p, ok := ctx.Value(contextKeyPrincipal).(principal)
+	return p, ok
+}
+
+// createdBy returns what a poll created with ctx records as its creator: the
+// API key's name or the token's subject, never the key itself
+func createdBy(ctx context.Context) string {
+	p, _ := principalFrom(ctx)
+	return p.Name
+}
+
+// authenticator checks the credentials of requests
+type authenticator struct {
+	keys map[[sha256.Size]byte]principal // by the SHA-256 of the key, so lookups don't leak it through timing
+
+	// JWTs are signed with HS256 and secret, or RS256 and publicKey
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string // checked when set
+	audience  string // checked when set
+	now       func() time.Time
+}
+
+// authConfig is where the credentials come from, all optional
+type authConfig struct {
+	keysFile     string // lines of name, role and key
+	jwtPublicKey string // a PEM file with the RS256 public key
+	jwtIssuer    string
+	jwtAudience  string
+	devRole      string // of the development key, viewer when empty
+}
+
+// newAuthenticator loads the API keys and the JWT keys, the HS256 secret from
+// JWT_SECRET. Without any, the development key is the only credential
+// accepted, with the role cfg gives it.
+func newAuthenticator(cfg authConfig) (*authenticator, error) {
+	a := &authenticator{
+		keys:     make(map[[sha256.Size]byte]principal),
+		issuer:   cfg.jwtIssuer,
+		audience: cfg.jwtAudience,
+		now:      time.Now,
+	}
+	if cfg.keysFile != "" {
+		if err := a.loadKeys(cfg.keysFile); err != nil {
+			return nil, err
+		}
+	}
+	if secret := os.Getenv("JWT_SECRET"); secret != "" {
+		a.secret = []byte(secret)
+	}
+	if cfg.jwtPublicKey != "" {
+		b, err := ioutil.ReadFile(cfg.jwtPublicKey)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM key in %s", cfg.jwtPublicKey)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.jwtPublicKey, err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA public key", cfg.jwtPublicKey)
+		}
+		a.publicKey = rsaKey
+	}
+	role := cfg.devRole
+	if role == "" {
+		role = roleViewer
+	}
+	if roleRanks[role] == 0 {
+		return nil, fmt.Errorf("unknown development key role %q, want viewer, poll-admin or operator", role)
+	}
+	if len(a.keys) == 0 && a.secret == nil && a.publicKey == nil {
+		if role != roleViewer {
+			log.Printf("WARNING: no API keys or JWT keys, the development key %s is accepted as a %s", devAPIKey, role)
+		}
+		a.keys[sha256.Sum256([]byte(devAPIKey))] = principal{Name: "development", Role: role, Auth: "api_key"}
+	}
+	return a, nil
+}
+
+// loadKeys reads API keys, a line per key with its name, role and the key
+// itself separated by spaces. Blank lines and lines starting with # are skipped.
+func (a *authenticator) loadKeys(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: want a name, a role and a key", name, line)
+		}
+		if roleRanks[fields[1]] == 0 {
+			return fmt.Errorf("%s:%d: unknown role %q, want viewer, poll-admin or operator", name, line, fields[1])
+		}
+		a.keys[sha256.Sum256([]byte(fields[2]))] = principal{Name: fields[0], Role: fields[1], Auth: "api_key"}
+	}
+	return scanner.Err()
+}
+
+// authenticate returns who made r
+func (a *authenticator) authenticate(r *http.Request) (principal, error) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		key = r.Header.Get("X-API-Key")
+	}
+	if key == "" {
+		bearer := r.Header.Get("Authorization")
+		if !strings.HasPrefix(bearer, "Bearer ") {
+			return principal{}, errors.New("missing API key or bearer token")
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
+		if strings.Count(token, ".") == 2 {
+			return a.verifyJWT(token)
+		}
+		key = token // an API key as the bearer token
+	}
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return principal{}, errors.New("invalid API key")
+	}
+	return p, nil
+}
+
+// jwtClaims are the claims read from a token. The role is role, or the highest
+// of roles, so tokens from identity providers listing several work.
+type jwtClaims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // a string or a list
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Role      string          `json:"role"`
+	Roles     []string        `json:"roles"`
+}
+
+// verifyJWT checks the signature and the claims of a compact JWT
+func (a *authenticator) verifyJWT(token string) (principal, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return principal{}, fmt.Errorf("invalid token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return principal{}, errors.New("invalid token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && a.secret != nil:
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return principal{}, errors.New("invalid token signature")
+		}
+	case header.Alg == "RS256" && a.publicKey != nil:
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, sum[:], sig); err != nil {
+			return principal{}, errors.New("invalid token signature")
+		}
+	default:
+		return principal{}, fmt.Errorf("tokens signed with %q aren't accepted", header.Alg)
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return principal{}, fmt.Errorf("invalid token claims: %v", err)
+	}
+	now := a.now()
+	if claims.ExpiresAt == nil {
+		return principal{}, errors.New("the token has no expiry")
+	}
+	if now.After(unixTime(*claims.ExpiresAt).Add(jwtLeeway)) {
+		return principal{}, errors.New("the token expired")
+	}
+	if claims.NotBefore != nil && now.Add(jwtLeeway).Before(unixTime(*claims.NotBefore)) {
+		return principal{}, errors.New("the token isn't valid yet")
+	}
+	if a.issuer != "" && claims.Issuer != a.issuer {
+		return principal{}, errors.New("the token has the wrong issuer")
+	}
+	if a.audience != "" && !hasAudience(claims.Audience, a.audience) {
+		return principal{}, errors.New("the token isn't meant for this API")
+	}
+	p := principal{Name: claims.Subject, Auth: "jwt"}
+	for _, role := range append(claims.Roles, claims.Role) {
+		if roleRanks[role] > roleRanks[p.Role] {
+			p.Role = role
+		}
+	}
+	if p.Role == "" {
+		return principal{}, errors.New("the token has no role")
+	}
+	return p, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// hasAudience reports whether aud, a string or a list of them, has want
+func hasAudience(aud json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) != nil {
+		return false
+	}
+	for _, a := range many {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
+
+// readWrite is the role a request needs on the polls: viewer to read, poll admin to change them
+func readWrite(r *http.Request) string {
+	switch r.Method {
+	case "GET", "HEAD", "OPTIONS":
+		return roleViewer
+	}
+	return rolePollAdmin
+}
+
+// needs is the role every request to an endpoint needs
+func needs(role string) func(*http.Request) string {
+	return func(*http.Request) string {
+		return role
+	}
+}
+
+// withAuth only lets requests from callers with the role need returns through.
+// Requests that need more than a viewer are admin actions and audited.
+// Clients are rate limited by address before authenticating, so guessing keys
+// is slow too, and by API key or token subject after. CORS preflights carry
+// no credentials and pass.
+func (s *Server) withAuth(need func(*http.Request) string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "OPTIONS" {
+			fn(w, r)
+			return
+		}
+		if ok, wait := s.perIP.allow(clientIP(r, s.trustForwarded)); !ok {
+			respondTooMany(w, r, wait)
+			return
+		}
+		p, err := s.auth.authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondErr(w, r, http.StatusUnauthorized, err)
+			return
+		}
+		if ok, wait := s.perKey.allow(p.Auth + ":" + p.Name); !ok {
+			respondTooMany(w, r, wait)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyPrincipal, p))
+		role := need(r)
+		if role == roleViewer {
+			fn(w, r) // every role can view
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if p.can(role) {
+			fn(rec, r)
+		} else {
+			respondErr(rec, r, http.StatusForbidden, "needs the ", role, " role")
+		}
+		s.audit.record(r, p, r.Method+" "+r.URL.Path, rec.status, "")
+	}
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
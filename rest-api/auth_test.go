@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var authTestNow = time.Date(2024, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// jwtFor encodes header and claims as a compact JWT signed with sign
+func jwtFor(t *testing.T, header, claims map[string]interface{}, sign func(signed []byte) []byte) string {
+	t.Helper()
+	part := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := part(header) + "." + part(claims)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func hs256(secret []byte) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		mac := hmac.New(sha256.New, secret)
+		mac.Write(signed)
+		return mac.Sum(nil)
+	}
+}
+
+func rs256(t *testing.T, key *rsa.PrivateKey) func([]byte) []byte {
+	return func(signed []byte) []byte {
+		sum := sha256.Sum256(signed)
+		sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		return sig
+	}
+}
+
+// claimsWith are claims valid an hour more, with the changes given; a nil value removes the claim
+func claimsWith(changes map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{"sub": "ci", "iss": "https://id.example.com", "aud": "polls", "exp": authTestNow.Add(time.Hour).Unix(), "role": roleViewer}
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("s3cret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hs := map[string]interface{}{"alg": "HS256", "typ": "JWT"}
+	rs := map[string]interface{}{"alg": "RS256", "typ": "JWT"}
+	none := func([]byte) []byte { return nil }
+	tampered := func(token string) string {
+		parts := strings.Split(token, ".")
+		c, _ := json.Marshal(claimsWith(map[string]interface{}{"role": roleOperator}))
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(c) + "." + parts[2]
+	}
+
+	tests := []struct {
+		name  string
+		token string
+		role  string // of the principal, none when refused
+		err   string
+	}{
+		{"HS256", jwtFor(t, hs, claimsWith(nil), hs256(secret)), roleViewer, ""},
+		{"RS256", jwtFor(t, rs, claimsWith(map[string]interface{}{"role": roleOperator}), rs256(t, key)), roleOperator, ""},
+		{"alg none", jwtFor(t, map[string]interface{}{"alg": "none"}, claimsWith(map[string]interface{}{"role": roleOperator}), none), "", `tokens signed with "none" aren't accepted`},
+		{"alg None", jwtFor(t, map[string]interface{}{"alg": "None"}, claimsWith(nil), none), "", "aren't accepted"},
+		{"no alg", jwtFor(t, map[string]interface{}{}, claimsWith(nil), none), "", `tokens signed with "" aren't accepted`},
+		{"an alg it doesn't take", jwtFor(t, map[string]interface{}{"alg": "HS512"}, claimsWith(nil), hs256(secret)), "", `tokens signed with "HS512" aren't accepted`},
+		{"HS256 signed with the RSA public key", jwtFor(t, hs, claimsWith(nil), hs256(public)), "", "invalid token signature"},
+		{"HS256 with another secret", jwtFor(t, hs, claimsWith(nil), hs256([]byte("guess"))), "", "invalid token signature"},
+		{"RS256 with another key", jwtFor(t, rs, claimsWith(nil), rs256(t, other)), "", "invalid token signature"},
+		{"claims changed after signing", tampered(jwtFor(t, hs, claimsWith(nil), hs256(secret))), "", "invalid token signature"},
+		{"RS256 claims changed after signing", tampered(jwtFor(t, rs, claimsWith(nil), rs256(t, key))), "", "invalid token signature"},
+		{"a signature that isn't base64", strings.Split(jwtFor(t, hs, claimsWith(nil), hs256(secret)), ".")[0] + ".e30.!!", "", "invalid token signature"},
+		{"a header that isn't JSON", "bm90IGpzb24.e30.c2ln", "", "invalid token header"},
+		{"no exp", jwtFor(t, hs, claimsWith(map[string]interface{}{"exp": nil}), hs256(secret)), "", "the token has no expiry"},
+		{"expired", jwtFor(t, hs, claimsWith(map[string]interface{}{"exp": authTestNow.Add(-time.Minute).Unix()}), hs256(secret)), "", "the token expired"},
+		{"expired within the leeway", jwtFor(t, hs, claimsWith(map[string]interface{}{"exp": authTestNow.Add(-jwtLeeway / 2).Unix()}), hs256(secret)), roleViewer, ""},
+		{"not valid yet", jwtFor(t, hs, claimsWith(map[string]interface{}{"nbf": authTestNow.Add(time.Minute).Unix()}), hs256(secret)), "", "the token isn't valid yet"},
+		{"valid within the leeway", jwtFor(t, hs, claimsWith(map[string]interface{}{"nbf": authTestNow.Add(jwtLeeway / 2).Unix()}), hs256(secret)), roleViewer, ""},
+		{"the wrong issuer", jwtFor(t, hs, claimsWith(map[string]interface{}{"iss": "elsewhere"}), hs256(secret)), "", "the token has the wrong issuer"},
+		{"no issuer", jwtFor(t, hs, claimsWith(map[string]interface{}{"iss": nil}), hs256(secret)), "", "the token has the wrong issuer"},
+		{"the wrong audience", jwtFor(t, hs, claimsWith(map[string]interface{}{"aud": "other-api"}), hs256(secret)), "", "the token isn't meant for this API"},
+		{"the audience in a list", jwtFor(t, hs, claimsWith(map[string]interface{}{"aud": []string{"other-api", "polls"}}), hs256(secret)), roleViewer, ""},
+		{"no role", jwtFor(t, hs, claimsWith(map[string]interface{}{"role": nil}), hs256(secret)), "", "the token has no role"},
+		{"a role that isn't one", jwtFor(t, hs, claimsWith(map[string]interface{}{"role": "root"}), hs256(secret)), "", "the token has no role"},
+		{"the highest of the roles", jwtFor(t, hs, claimsWith(map[string]interface{}{"role": nil, "roles": []string{roleViewer, rolePollAdmin, "root"}}), hs256(secret)), rolePollAdmin, ""},
+	}
+	a := &authenticator{secret: secret, publicKey: &key.PublicKey, issuer: "https://id.example.com", audience: "polls", now: func() time.Time { return authTestNow }}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := a.verifyJWT(tt.token)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("verifyJWT = %v", err)
+			case tt.err == "" && p.Role != tt.role:
+				t.Errorf("role %q, want %q", p.Role, tt.role)
+			case tt.err != "" && err == nil:
+				t.Errorf("accepted as %+v, want %q", p, tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("verifyJWT = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("s3cret")
+	token := jwtFor(t, map[string]interface{}{"alg": "HS256"}, claimsWith(map[string]interface{}{"role": rolePollAdmin}), hs256(secret))
+	a := &authenticator{
+		keys:     map[[sha256.Size]byte]principal{sha256.Sum256([]byte("k-viewer")): {Name: "dashboard", Role: roleViewer, Auth: "api_key"}},
+		secret:   secret,
+		issuer:   "https://id.example.com",
+		audience: "polls",
+		now:      func() time.Time { return authTestNow },
+	}
+	tests := []struct {
+		name   string
+		query  string
+		header map[string]string
+		want   string // the principal's name, none when refused
+		err    string
+	}{
+		{"a key in the query", "?key=k-viewer", nil, "dashboard", ""},
+		{"a key in X-API-Key", "", map[string]string{"X-API-Key": "k-viewer"}, "dashboard", ""},
+		{"a key as the bearer token", "", map[string]string{"Authorization": "Bearer k-viewer"}, "dashboard", ""},
+		{"a JWT", "", map[string]string{"Authorization": "Bearer " + token}, "ci", ""},
+		{"an unknown key", "?key=k-guess", nil, "", "invalid API key"},
+		{"an unknown bearer key", "", map[string]string{"Authorization": "Bearer k-guess"}, "", "invalid API key"},
+		{"the development key once keys are configured", "?key=" + devAPIKey, nil, "", "invalid API key"},
+		{"no credentials", "", nil, "", "missing API key or bearer token"},
+		{"basic auth", "", map[string]string{"Authorization": "Basic a2V5Og=="}, "", "missing API key or bearer token"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/polls/"+tt.query, nil)
+			for k, v := range tt.header {
+				r.Header.Set(k, v)
+			}
+			p, err := a.authenticate(r)
+			switch {
+			case tt.err == "" && (err != nil || p.Name != tt.want):
+				t.Errorf("authenticate = %+v, %v, want %s", p, err, tt.want)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("authenticate = %+v, %v, want %q", p, err, tt.err)
+			}
+		})
+	}
+}
+
+func TestDevelopmentKey(t *testing.T) {
+	t.Setenv("JWT_SECRET", "")
+	keys := filepath.Join(t.TempDir(), "keys")
+	if err := ioutil.WriteFile(keys, []byte("# name role key\nci operator k-ci\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name string
+		cfg  authConfig
+		role string // of the development key, none when it isn't accepted
+		err  bool
+	}{
+		{"read-only by default", authConfig{}, roleViewer, false},
+		{"a poll admin when asked", authConfig{devRole: rolePollAdmin}, rolePollAdmin, false},
+		{"an operator when asked", authConfig{devRole: roleOperator}, roleOperator, false},
+		{"a role that isn't one", authConfig{devRole: "root"}, "", true},
+		{"not with API keys", authConfig{keysFile: keys, devRole: roleOperator}, "", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			a, err := newAuthenticator(tt.cfg)
+			if (err != nil) != tt.err {
+				t.Fatalf("newAuthenticator = %v", err)
+			}
+			if err != nil {
+				return
+			}
+			p, err := a.authenticate(httptest.NewRequest("GET", "/polls/?key="+devAPIKey, nil))
+			if tt.role == "" {
+				if err == nil {
+					t.Errorf("the development key is accepted as %+v", p)
+				}
+				return
+			}
+			if err != nil || p.Role != tt.role {
+				t.Errorf("the development key is %+v, %v, want a %s", p, err, tt.role)
+			}
+		})
+	}
+}
+
+func TestLoadKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"keys, comments and blank lines", "# name role key\n\ndashboard viewer k1\nci operator k2\n", ""},
+		{"a line without a key", "dashboard viewer\n", "keys:1: want a name, a role and a key"},
+		{"a role that isn't one", "\nci root k2\n", `keys:2: unknown role "root"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "keys")
+			if err := ioutil.WriteFile(name, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			a := &authenticator{keys: make(map[[sha256.Size]byte]principal)}
+			err := a.loadKeys(name)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("loadKeys = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("loadKeys = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestWithAuthRoles(t *testing.T) {
+	keys := make(map[[sha256.Size]byte]principal)
+	for _, role := range []string{roleViewer, rolePollAdmin, roleOperator} {
+		keys[sha256.Sum256([]byte("k-"+role))] = principal{Name: role, Role: role, Auth: "api_key"}
+	}
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		need   func(*http.Request) string
+		status int
+	}{
+		{"a viewer reads", roleViewer, "GET", readWrite, http.StatusOK},
+		{"a viewer can't change", roleViewer, "POST", readWrite, http.StatusForbidden},
+		{"a viewer can't delete", roleViewer, "DELETE", readWrite, http.StatusForbidden},
+		{"a poll admin changes", rolePollAdmin, "POST", readWrite, http.StatusOK},
+		{"a poll admin can't run operator actions", rolePollAdmin, "POST", needs(roleOperator), http.StatusForbidden},
+		{"an operator runs them", roleOperator, "POST", needs(roleOperator), http.StatusOK},
+		{"an operator changes polls", roleOperator, "DELETE", readWrite, http.StatusOK},
+		{"nobody authenticated", "", "GET", readWrite, http.StatusUnauthorized},
+		{"a CORS preflight", "", "OPTIONS", readWrite, http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			s := &Server{auth: &authenticator{keys: keys, now: time.Now}, audit: &auditLog{enc: json.NewEncoder(&audit)}}
+			var ran bool
+			h := s.withAuth(tt.need, func(w http.ResponseWriter, r *http.Request) {
+				ran = true
+				if p, _ := principalFrom(r.Context()); p.Role != tt.role {
+					t.Errorf("the handler sees a %q, want %q", p.Role, tt.role)
+				}
+			})
+			r := httptest.NewRequest(tt.method, "/polls/", nil)
+			if tt.role != "" {
+				r.Header.Set("X-API-Key", "k-"+tt.role)
+			}
+			w := httptest.NewRecorder()
+			h(w, r)
+			if w.Code != tt.status || ran != (tt.status == http.StatusOK) {
+				t.Errorf("status %d with the handler run %v, want %d", w.Code, ran, tt.status)
+			}
+			// what a viewer may do isn't an admin action, the rest is audited allowed or not
+			admin := tt.role != "" && tt.need(r) != roleViewer
+			var entry auditEntry
+			if audited := json.Unmarshal(audit.Bytes(), &entry) == nil; audited != admin {
+				t.Errorf("audited %v, want %v", audited, admin)
+			} else if admin && (entry.Status != tt.status || entry.Role != tt.role) {
+				t.Errorf("audited %+v, want the %s's %d", entry, tt.role, tt.status)
+			}
+		})
+	}
+}
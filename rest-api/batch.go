@@ -0,0 +1,179 @@
+package main
+
+import (
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Batch operations let an operator activate, close, archive, extend, pause or resume many polls in one request,
+// e.g. when an event overruns and every poll in its campaign needs another hour.
+// With dry_run set nothing is changed and the response previews what would happen.
+
+// batchRequest is the body of POST /polls/batch
+type batchRequest struct {
+	Action   string        `json:"action"` // activate, close, archive, extend, pause or resume
+	Selector batchSelector `json:"selector"`
+	ExtendBy string        `json:"extend_by,omitempty"` // duration added to ends_at for extend
+	DryRun   bool          `json:"dry_run"`
+}
+
+// batchSelector picks the polls to operate on; at least one field must be set
+// and polls must match every field that is
+type batchSelector struct {
+	IDs      []string `json:"ids,omitempty"`
+	Campaign string   `json:"campaign,omitempty"`
+	Tag      string   `json:"tag,omitempty"`
+}
+
+// batchChange previews the effect of the operation on one poll
+type batchChange struct {
+	ID        string     `json:"id"`
+	Title     string     `json:"title"`
+	Status    string     `json:"status"`
+	NewStatus string     `json:"new_status"`
+	EndsAt    *time.Time `json:"ends_at,omitempty"`
+	NewEndsAt *time.Time `json:"new_ends_at,omitempty"`
+	Skipped   string     `json:"skipped,omitempty"` // why the poll is left alone
+}
+
+// batchResponse reports what was (or would be) done
+type batchResponse struct {
+	DryRun  bool          `json:"dry_run"`
+	Matched int           `json:"matched"`
+	Updated int           `json:"updated"`
+	Changes []batchChange `json:"changes"`
+}
+
+// query builds the MongoDB selector for the polls to operate on
+func (sel batchSelector) query() (bson.M, bool) {
+	q := bson.M{}
+	if len(sel.IDs) > 0 {
+		ids := make([]bson.ObjectId, 0, len(sel.IDs))
+		for _, id := range sel.IDs {
+			if !bson.IsObjectIdHex(id) {
+				return nil, false
+			}
+			ids = append(ids, bson.ObjectIdHex(id))
+		}
+		q["_id"] = bson.M{"$in": ids}
+	}
+	if sel.Campaign != "" {
+		q["campaign"] = sel.Campaign
+	}
+	if sel.Tag != "" {
+		q["tags"] = sel.Tag
+	}
+	if len(q) == 0 {
+		return nil, false
+	}
+	return notDeleted(q), true
+}
+
+// plan works out what the action does to a single poll
+func (req batchRequest) plan(p *poll, extendBy time.Duration) batchChange {
+	change := batchChange{
+		ID:        p.ID.Hex(),
+		Title:     p.Title,
+		Status:    p.status(),
+		NewStatus: p.status(),
+		EndsAt:    p.EndsAt,
+		NewEndsAt: p.EndsAt,
+	}
+	switch req.Action {
+	case "extend":
+		if change.Status == pollStatusClosed || change.Status == pollStatusArchived {
+			change.Skipped = "poll is " + change.Status
+			return change
+		}
+		if p.EndsAt == nil {
+			change.Skipped = "poll has no end time"
+			return change
+		}
+		ends := p.EndsAt.Add(extendBy)
+		change.NewEndsAt = &ends
+	case "resume":
+		if change.Status != pollStatusPaused {
+			change.Skipped = "poll is not paused"
+			return change
+		}
+		change.NewStatus = pollStatusActive
+	default:
+		to := batchStatuses[req.Action]
+		if change.Status == to {
+			change.Skipped = "poll is already " + to
+		} else if err := canTransition(change.Status, to); err != nil {
+			change.Skipped = err.Error()
+		}
+		change.NewStatus = to
+	}
+	return change
+}
+
+// batchStatuses are the statuses the actions changing it move polls to
+var batchStatuses = map[string]string{
+	"activate": pollStatusActive,
+	"pause":    pollStatusPaused,
+	"close":    pollStatusClosed,
+	"archive":  pollStatusArchived,
+}
+
+// POST /polls/batch applies one action to every poll matching the selector
+func (s *Server) handlePollsBatch(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var req batchRequest
+	if err := decodeBody(r, &req); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read batch request", err)
+		return
+	}
+	var extendBy time.Duration
+	switch req.Action {
+	case "activate", "close", "archive", "pause", "resume":
+	case "extend":
+		var err error
+		if extendBy, err = time.ParseDuration(req.ExtendBy); err != nil || extendBy <= 0 {
+			respondErr(w, r, http.StatusBadRequest, "extend needs a positive extend_by duration")
+			return
+		}
+	default:
+		respondErr(w, r, http.StatusBadRequest, "unknown batch action ", req.Action)
+		return
+	}
+	q, ok := req.Selector.query()
+	if !ok {
+		respondErr(w, r, http.StatusBadRequest, "selector needs ids, a campaign or a tag")
+		return
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	c := s.polls(session)
+
+	var polls []*poll
+	if err := c.Find(q).All(&polls); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to find polls", err)
+		return
+	}
+	resp := batchResponse{DryRun: req.DryRun, Matched: len(polls), Changes: []batchChange{}}
+	for _, p := range polls {
+		change := req.plan(p, extendBy)
+		if change.Skipped == "" && !req.DryRun {
+			set := bson.M{"status": change.NewStatus}
+			if change.NewEndsAt != nil {
+				set["ends_at"] = *change.NewEndsAt
+			}
+			if err := c.UpdateId(p.ID, bson.M{"$set": set}); err != nil {
+				change.Skipped = "update failed: " + err.Error()
+			} else {
+				resp.Updated++
+				s.publishPollEvent(p.ID.Hex(), "updated")
+			}
+		}
+		resp.Changes = append(resp.Changes, change)
+	}
+	respond(w, r, http.StatusOK, resp)
+}
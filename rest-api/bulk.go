@@ -0,0 +1,203 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Bulk creation makes many polls from one template, e.g. a poll per match of a
+// season: the template is a poll whose title, options, campaign, tags and
+// matching options may have {{name}} placeholders, and every poll to create
+// gives their values along with its own schedule. The polls are all checked,
+// as creating them one by one would, before any is created, and none is when
+// one of them is invalid. With dry_run set nothing is created and the response
+// previews the polls.
+
+// maxBulkPolls is how many polls one request can create
+const maxBulkPolls = 500
+
+// bulkRequest is the body of POST /polls/bulk
+type bulkRequest struct {
+	Template poll        `json:"template"`
+	Polls    []bulkEntry `json:"polls"`
+	DryRun   bool        `json:"dry_run"`
+}
+
+// bulkEntry is one of the polls to create from the template
+type bulkEntry struct {
+	// Params are the values of the template's placeholders, by name
+	Params map[string]string `json:"params"`
+	// EndsAt and EmbargoUntil schedule the poll, overriding the template's
+	EndsAt       *time.Time `json:"ends_at,omitempty"`
+	EmbargoUntil *time.Time `json:"embargo_until,omitempty"`
+}
+
+// bulkResult is what became of one of the polls, in the order they were listed
+type bulkResult struct {
+	Index    int         `json:"index"`
+	ID       string      `json:"id,omitempty"` // left out of dry runs and failed requests
+	Title    string      `json:"title"`
+	Options  []string    `json:"options"`
+	EndsAt   *time.Time  `json:"ends_at,omitempty"`
+	Error    string      `json:"error,omitempty"`
+	Errors   []termIssue `json:"errors,omitempty"`
+	Warnings []termIssue `json:"warnings,omitempty"`
+}
+
+// bulkResponse reports the polls created, or that would be
+type bulkResponse struct {
+	DryRun  bool         `json:"dry_run"`
+	Created int          `json:"created"`
+	Polls   []bulkResult `json:"polls"`
+}
+
+// placeholder matches the {{name}} of a template
+var placeholder = regexp.MustCompile(`\{\{\s*([A-Za-z0-9_]+)\s*\}\}`)
+
+// fill replaces the placeholders of s with params, failing on the ones it has no value for
+func fill(s string, params map[string]string) (string, error) {
+	var missing []string
+	out := placeholder.ReplaceAllStringFunc(s, func(m string) string {
+		name := placeholder.FindStringSubmatch(m)[1]
+		v, ok := params[name]
+		if !ok {
+			missing = append(missing, name)
+		}
+		return v
+	})
+	if len(missing) > 0 {
+		return "", fmt.Errorf("no value for {{%s}}", strings.Join(missing, "}}, {{"))
+	}
+	return out, nil
+}
+
+// instantiate returns the poll e makes of the template t
+func (e bulkEntry) instantiate(t poll) (poll, error) {
+	p := t
+	var err error
+	fillAll := func(in []string) []string {
+		if in == nil {
+			return nil
+		}
+		out := make([]string, len(in))
+		for i, s := range in {
+			if err == nil {
+				out[i], err = fill(s, e.Params)
+			}
+		}
+		return out
+	}
+	if p.Title, err = fill(t.Title, e.Params); err != nil {
+		return p, err
+	}
+	if p.Campaign, err = fill(t.Campaign, e.Params); err != nil {
+		return p, err
+	}
+	p.Options, p.Tags = fillAll(t.Options), fillAll(t.Tags)
+	if t.Matching != nil {
+		p.Matching = make([]optionMatching, len(t.Matching))
+		for i, m := range t.Matching {
+			if err == nil {
+				m.Option, err = fill(m.Option, e.Params)
+			}
+			p.Matching[i] = m
+		}
+	}
+	if err != nil {
+		return p, err
+	}
+	if e.EndsAt != nil {
+		p.EndsAt = e.EndsAt
+	}
+	if t.Embargo != nil {
+		embargo := *t.Embargo
+		p.Embargo = &embargo
+	}
+	if e.EmbargoUntil != nil {
+		if p.Embargo == nil {
+			p.Embargo = &pollEmbargo{}
+		}
+		p.Embargo.Until = e.EmbargoUntil
+	}
+	if p.EndsAt != nil && !p.EndsAt.After(time.Now()) {
+		return p, fmt.Errorf("ends_at %s has passed", p.EndsAt.Format(time.RFC3339))
+	}
+	return p, nil
+}
+
+// POST /polls/bulk creates a poll for every entry of the request from its template
+func (s *Server) handlePollsBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var req bulkRequest
+	if err := decodeBody(r, &req); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read bulk request", err)
+		return
+	}
+	switch {
+	case len(req.Polls) == 0:
+		respondErr(w, r, http.StatusBadRequest, "bulk creation needs the polls to create")
+		return
+	case len(req.Polls) > maxBulkPolls:
+		respondErr(w, r, http.StatusBadRequest, fmt.Sprintf("at most %d polls are created at once", maxBulkPolls))
+		return
+	}
+	others, err := s.streamedPolls()
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to load the polls", err)
+		return
+	}
+	creator := createdBy(r.Context())
+
+	// every poll is checked against the streamed polls and the ones before it
+	resp := bulkResponse{DryRun: req.DryRun, Polls: make([]bulkResult, len(req.Polls))}
+	polls := make([]interface{}, 0, len(req.Polls))
+	valid := true
+	for i, e := range req.Polls {
+		p, err := e.instantiate(req.Template)
+		res := bulkResult{Index: i, Title: p.Title, Options: p.Options, EndsAt: p.EndsAt}
+		if err == nil {
+			err = preparePoll(&p)
+		}
+		if err != nil {
+			res.Error = err.Error()
+			valid = false
+		} else {
+			terms := validateTerms(&p, others)
+			res.Errors, res.Warnings = terms.Errors, terms.Warnings
+			valid = valid && terms.Valid
+			p.ID, p.CreatedBy = bson.NewObjectId(), creator
+			others = append(others, &p)
+			polls = append(polls, p)
+		}
+		resp.Polls[i] = res
+	}
+	if !valid {
+		respond(w, r, http.StatusBadRequest, resp)
+		return
+	}
+	if req.DryRun {
+		respond(w, r, http.StatusOK, resp)
+		return
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	if err := s.polls(session).Insert(polls...); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to insert polls", err)
+		return
+	}
+	resp.Created = len(polls)
+	for i, p := range polls {
+		resp.Polls[i].ID = p.(poll).ID.Hex()
+		s.publishPollEvent(resp.Polls[i].ID, "created")
+	}
+	respond(w, r, http.StatusCreated, resp)
+}
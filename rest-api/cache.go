@@ -0,0 +1,183 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// A viral poll has its results page, or its widget, loaded by thousands of
+// readers a second, each a read of the poll from MongoDB. The results
+// responses are cached for -results-cache-ttl, a response rendered once for
+// every request alike, and carry an ETag: a client sending it back in
+// If-None-Match is answered 304 without the body, cached or not.
+
+// maxCachedResults is how many responses are cached at most, the expired are
+// dropped first and the rest only when they are all fresh
+const maxCachedResults = 10000
+
+// cachedResponse is a response rendered for every request with the same key
+type cachedResponse struct {
+	ready  chan struct{} // closed once rendered, the requests meanwhile wait for it
+	at     time.Time
+	status int
+	header http.Header
+	body   []byte
+	etag   string
+}
+
+// resultsCache keeps the rendered results responses for ttl, nothing when 0
+type resultsCache struct {
+	ttl time.Duration
+
+	mu      sync.Mutex
+	entries map[string]*cachedResponse
+}
+
+func newResultsCache(ttl time.Duration) *resultsCache {
+	return &resultsCache{ttl: ttl, entries: make(map[string]*cachedResponse)}
+}
+
+// cacheKey is what tells r's response apart from the others': its path and
+// query, without the API key which doesn't change it
+func cacheKey(r *http.Request) string {
+	q := r.URL.Query()
+	q.Del("key")
+	return r.URL.Path + "?" + q.Encode()
+}
+
+// serve answers r with the response render gives for its key, rendering it
+// unless it was within ttl. Only the 200 responses are cached, and only they
+// get an ETag and a Cache-Control, private unless render set one.
+func (c *resultsCache) serve(w http.ResponseWriter, r *http.Request, render http.HandlerFunc) {
+	key := cacheKey(r)
+	if c == nil || c.ttl <= 0 {
+		c.answer(w, r, c.render(r, render))
+		return
+	}
+	c.mu.Lock()
+	e, ok := c.entries[key]
+	if ok && c.expired(e) {
+		ok = false
+	}
+	if !ok {
+		e = &cachedResponse{ready: make(chan struct{})}
+		c.trim()
+		c.entries[key] = e
+		c.mu.Unlock()
+		rendered := c.render(r, render)
+		c.mu.Lock()
+		e.at, e.status, e.header, e.body, e.etag = rendered.at, rendered.status, rendered.header, rendered.body, rendered.etag
+		if e.status != http.StatusOK {
+			delete(c.entries, key)
+		}
+		close(e.ready)
+		c.mu.Unlock()
+		c.answer(w, r, e)
+		return
+	}
+	c.mu.Unlock()
+	<-e.ready
+	c.answer(w, r, e)
+}
+
+// trim drops the expired responses once there are too many, and every one
+// when none expired, mu held
+func (c *resultsCache) trim() {
+	if len(c.entries) < maxCachedResults {
+		return
+	}
+	for key, e := range c.entries {
+		if c.expired(e) {
+			delete(c.entries, key)
+		}
+	}
+	if len(c.entries) >= maxCachedResults {
+		c.entries = make(map[string]*cachedResponse)
+	}
+}
+
+// expired reports whether e was rendered over ttl ago, a response still
+// rendering isn't, mu held
+func (c *resultsCache) expired(e *cachedResponse) bool {
+	select {
+	case <-e.ready:
+		return time.Since(e.at) >= c.ttl
+	default:
+		return false
+	}
+}
+
+// render runs render for r, keeping what it responded
+func (c *resultsCache) render(r *http.Request, render http.HandlerFunc) *cachedResponse {
+	rec := &responseBuffer{header: make(http.Header), status: http.StatusOK}
+	render(rec, r)
+	e := &cachedResponse{at: time.Now(), status: rec.status, header: rec.header, body: rec.body.Bytes()}
+	if e.status == http.StatusOK {
+		sum := sha256.Sum256(e.body)
+		e.etag = `"` + hex.EncodeToString(sum[:12]) + `"`
+		if e.header.Get("Cache-Control") == "" {
+			maxAge := 0
+			if c != nil {
+				maxAge = int(c.ttl / time.Second)
+			}
+			e.header.Set("Cache-Control", fmt.Sprintf("private, max-age=%d", maxAge))
+		}
+	}
+	return e
+}
+
+// answer writes e to w, or 304 when r has its ETag already
+func (c *resultsCache) answer(w http.ResponseWriter, r *http.Request, e *cachedResponse) {
+	for k, v := range e.header {
+		w.Header()[k] = v
+	}
+	if e.etag != "" {
+		w.Header().Set("ETag", e.etag)
+		if matchesETag(r.Header.Get("If-None-Match"), e.etag) {
+			w.Header().Del("Content-Type")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+	w.WriteHeader(e.status)
+	w.Write(e.body)
+}
+
+// matchesETag reports whether the If-None-Match header lists etag, or is *;
+// they are compared weakly, as If-None-Match does
+func matchesETag(header, etag string) bool {
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// responseBuffer is a ResponseWriter keeping the response, to be cached
+type responseBuffer struct {
+	header http.Header
+	status int
+	wrote  bool
+	body   bytes.Buffer
+}
+
+func (b *responseBuffer) Header() http.Header { return b.header }
+
+func (b *responseBuffer) WriteHeader(status int) {
+	if !b.wrote {
+		b.status, b.wrote = status, true
+	}
+}
+
+func (b *responseBuffer) Write(p []byte) (int, error) {
+	b.wrote = true
+	return b.body.Write(p)
+}
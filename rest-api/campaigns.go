@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Campaigns group a series of related polls, the ones whose campaign is the
+// campaign's id. Their results are reported together and the campaign's own
+// chat channels are told about the votes of all of them by the counter.
+
+// campaignsCollection is the collection campaigns are kept in, next to the polls
+const campaignsCollection = "campaigns"
+
+// maxCampaignID is how long a campaign id can be
+const maxCampaignID = 64
+
+// campaign is a campaign document
+type campaign struct {
+	ID    string `bson:"_id" json:"id"`
+	Title string `json:"title"`
+	// Notifications are the chat channels told about the milestones of the
+	// campaign's votes and their progress
+	Notifications []notification `bson:"notifications,omitempty" json:"notifications,omitempty"`
+}
+
+// campaignSettings are the campaign fields that can be changed after creation.
+// Fields left out of the request body are not touched.
+type campaignSettings struct {
+	Title *string `json:"title"`
+	// Notifications replaces the campaign's chat channels, an empty list removes them
+	Notifications *[]notification `json:"notifications"`
+}
+
+// campaignPoll is how one poll of a campaign compares with the others
+type campaignPoll struct {
+	ID     string `json:"id"`
+	Title  string `json:"title"`
+	Status string `json:"status"`
+	Total  int    `json:"total"`
+	// Share is the percentage of the campaign's votes the poll got
+	Share  float64 `json:"share"`
+	Leader string  `json:"leader,omitempty"`
+}
+
+// campaignResults is the body of GET /campaigns/{id}/results
+type campaignResults struct {
+	ID            string  `json:"id"`
+	Title         string  `json:"title"`
+	Polls         int     `json:"polls"`
+	Total         int     `json:"total"`
+	WeightedTotal float64 `json:"weighted_total,omitempty"`
+	// Sources adds up the votes of every poll per source they were cast on
+	Sources    map[string]int `json:"sources,omitempty"`
+	Comparison []campaignPoll `json:"comparison"`
+}
+
+// validateCampaign checks a campaign id, polls reference their campaign by it
+func validateCampaign(id string) error {
+	if len(id) > maxCampaignID {
+		return fmt.Errorf("campaign %q is longer than %d characters", id, maxCampaignID)
+	}
+	// it is a path segment of /campaigns/{id}
+	if strings.ContainsAny(id, "/?#% \t\n") {
+		return fmt.Errorf("campaign %q can't have slashes, spaces or any of ?#%%", id)
+	}
+	return nil
+}
+
+// campaigns returns the campaigns collection on session
+func (s *Server) campaigns(session *mgo.Session) *mgo.Collection {
+	return session.DB(s.database).C(campaignsCollection)
+}
+
+func (s *Server) handleCampaigns(w http.ResponseWriter, r *http.Request) {
+	if id, sub, ok := NewPath(r.URL.Path).SubResource(); ok {
+		if sub == "results" && r.Method == "GET" {
+			s.handleCampaignResults(w, r, id)
+			return
+		}
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	switch r.Method {
+	case "GET":
+		s.handleCampaignsGet(w, r)
+		return
+	case "POST":
+		s.handleCampaignsPost(w, r)
+		return
+	case "PATCH":
+		s.handleCampaignsPatch(w, r)
+		return
+	case "DELETE":
+		s.handleCampaignsDelete(w, r)
+		return
+	case "OPTIONS":
+		// allow delete and patch over CORS
+		w.Header().Add("Access-Control-Allow-Methods", "DELETE, PATCH")
+		respond(w, r, http.StatusOK, nil)
+		return
+	}
+	respondHTTPErr(w, r, http.StatusNotFound)
+}
+
+// Reading campaigns
+func (s *Server) handleCampaignsGet(w http.ResponseWriter, r *http.Request) {
+	session := s.db.Copy()
+	defer session.Close()
+	c := s.campaigns(session)
+
+	var q *mgo.Query
+	if p := NewPath(r.URL.Path); p.HasID() {
+		q = c.FindId(p.ID)
+	} else {
+		q = c.Find(nil).Sort("_id")
+	}
+	result := []*campaign{}
+	if err := q.All(&result); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to find campaigns", err)
+		return
+	}
+	for _, cp := range result {
+		// anyone holding a webhook can post to the channel
+		for i := range cp.Notifications {
+			cp.Notifications[i].URL = ""
+		}
+	}
+	respond(w, r, http.StatusOK, &result)
+}
+
+// Creating a campaign, its polls join it by setting their campaign to its id
+func (s *Server) handleCampaignsPost(w http.ResponseWriter, r *http.Request) {
+	var cp campaign
+	if err := decodeBody(r, &cp); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read campaign from request", err)
+		return
+	}
+	if cp.ID == "" {
+		respondErr(w, r, http.StatusBadRequest, "campaign id required")
+		return
+	}
+	if err := validateCampaign(cp.ID); err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if err := validateNotifications(cp.Notifications); err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	if err := s.campaigns(session).Insert(cp); err != nil {
+		if mgo.IsDup(err) {
+			respondErr(w, r, http.StatusConflict, "campaign ", cp.ID, " already exists")
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to insert campaign", err)
+		return
+	}
+	w.Header().Set("Location", "campaigns/"+cp.ID)
+	respond(w, r, http.StatusCreated, nil)
+}
+
+// Updating a campaign's title or chat channels
+func (s *Server) handleCampaignsPatch(w http.ResponseWriter, r *http.Request) {
+	p := NewPath(r.URL.Path)
+	if !p.HasID() {
+		respondErr(w, r, http.StatusBadRequest, "campaign id required")
+		return
+	}
+	var settings campaignSettings
+	if err := decodeBody(r, &settings); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read settings from request", err)
+		return
+	}
+	set := bson.M{}
+	if settings.Title != nil {
+		set["title"] = *settings.Title
+	}
+	if settings.Notifications != nil {
+		if err := validateNotifications(*settings.Notifications); err != nil {
+			respondErr(w, r, http.StatusBadRequest, err)
+			return
+		}
+		set["notifications"] = *settings.Notifications
+	}
+	if len(set) == 0 {
+		respondErr(w, r, http.StatusBadRequest, "nothing to update")
+		return
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	if err := s.campaigns(session).UpdateId(p.ID, bson.M{"$set": set}); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to update campaign", err)
+		return
+	}
+	respond(w, r, http.StatusOK, nil)
+}
+
+// Deleting a campaign, its polls keep their campaign and are left alone
+func (s *Server) handleCampaignsDelete(w http.ResponseWriter, r *http.Request) {
+	p := NewPath(r.URL.Path)
+	if !p.HasID() {
+		respondErr(w, r, http.StatusMethodNotAllowed, "Cannot delete all campaigns!")
+		return
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	if err := s.campaigns(session).RemoveId(p.ID); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to delete campaign", err)
+		return
+	}
+	respond(w, r, http.StatusOK, nil)
+}
+
+// GET /campaigns/{id}/results adds up the votes of the campaign's polls and
+// compares them, the polls with the most votes first
+func (s *Server) handleCampaignResults(w http.ResponseWriter, r *http.Request, id string) {
+	session := s.db.Copy()
+	defer session.Close()
+
+	var cp campaign
+	if err := s.campaigns(session).FindId(id).One(&cp); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to find campaign", err)
+		return
+	}
+	var polls []*poll
+	if err := s.polls(session).Find(notDeleted(bson.M{"campaign": id})).All(&polls); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to find polls", err)
+		return
+	}
+	respond(w, r, http.StatusOK, combineResults(&cp, polls))
+}
+
+// combineResults reports on the polls of cp together
+func combineResults(cp *campaign, polls []*poll) campaignResults {
+	res := campaignResults{ID: cp.ID, Title: cp.Title, Polls: len(polls), Comparison: []campaignPoll{}}
+	for _, p := range polls {
+		cmp := campaignPoll{ID: p.ID.Hex(), Title: p.Title, Status: p.status()}
+		var most int
+		for option, n := range p.Results {
+			cmp.Total += n
+			// ties go to the first option in order, so the leader doesn't flip between reads
+			if n > most || n == most && n > 0 && option < cmp.Leader {
+				cmp.Leader, most = option, n
+			}
+		}
+		for _, n := range p.WeightedResults {
+			res.WeightedTotal += n
+		}
+		for source, counts := range p.SourceResults {
+			if res.Sources == nil {
+				res.Sources = make(map[string]int)
+			}
+			for _, n := range counts {
+				res.Sources[source] += n
+			}
+		}
+		res.Total += cmp.Total
+		res.Comparison = append(res.Comparison, cmp)
+	}
+	for i := range res.Comparison {
+		if res.Total > 0 {
+			res.Comparison[i].Share = round(100*float64(res.Comparison[i].Total)/float64(res.Total), defaultPrecision)
+		}
+	}
+	sort.SliceStable(res.Comparison, func(i, j int) bool { return res.Comparison[i].Total > res.Comparison[j].Total })
+	return res
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// certificateDoc is a document in the certificates collection, written by the
+// counter once a poll has closed: the signed result document the poll's
+// results can be checked against with the counter's public key
+type certificateDoc struct {
+	Poll        string `bson:"_id"`
+	Certificate string `bson:"certificate"`
+}
+
+// GET /polls/{id}/certificate returns the signed certificate of a closed
+// poll's results, as the counter wrote it so the signature still matches
+func (s *Server) handlePollCertificate(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	var d certificateDoc
+	if err := session.DB(s.database).C("certificates").FindId(id).One(&d); err != nil {
+		if err == mgo.ErrNotFound {
+			respondErr(w, r, http.StatusNotFound, "the poll's results aren't certified, they are once it has closed")
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load certificate", err)
+		return
+	}
+	respond(w, r, http.StatusOK, json.RawMessage(d.Certificate))
+}
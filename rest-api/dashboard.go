@@ -0,0 +1,24 @@
+package main
+
+import (
+	"embed"
+	"io/fs"
+	"net/http"
+)
+
+// dashboardFiles is the built-in dashboard: live bars per poll from the results stream,
+// vote rates, and the health of the stream and the counter.
+// It needs no build step, so what is in dashboard/ is what is served.
+//
+//go:embed dashboard
+var dashboardFiles embed.FS
+
+// dashboardHandler serves the dashboard under /dashboard/.
+// The page asks for the API key and uses it for its own requests, so it isn't behind withAuth.
+func dashboardHandler() http.Handler {
+	files, err := fs.Sub(dashboardFiles, "dashboard")
+	if err != nil {
+		panic(err) // the directory is embedded at build time
+	}
+	return http.StripPrefix("/dashboard/", http.FileServer(http.FS(files)))
+}
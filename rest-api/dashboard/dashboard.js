@@ -0,0 +1,129 @@
+// The dashboard lists the polls, then follows each one on
+// /polls/{id}/results/stream and redraws its bars whenever the results change.
+// Vote rates are worked out here from the totals in the stream.
+(function () {
+  "use strict";
+
+  var params = new URLSearchParams(location.search);
+  var key = params.get("key") || sessionStorage.getItem("key");
+  var rateWindow = 60 * 1000; // rates are votes over the last minute
+
+  function api(path) {
+    return path + (path.indexOf("?") < 0 ? "?" : "&") + "key=" + encodeURIComponent(key);
+  }
+
+  function fetchJSON(path) {
+    return fetch(api(path)).then(function (resp) {
+      if (!resp.ok) {
+        throw new Error(resp.status + " " + resp.statusText);
+      }
+      return resp.json();
+    });
+  }
+
+  function field(el, name, value) {
+    el.querySelector('[data-field="' + name + '"]').textContent = value;
+  }
+
+  // rate keeps the totals seen in the last rateWindow and returns votes per minute
+  function rate(samples, total) {
+    var now = Date.now();
+    samples.push({ at: now, total: total });
+    while (samples.length > 1 && now - samples[0].at > rateWindow) {
+      samples.shift();
+    }
+    var first = samples[0];
+    var minutes = (now - first.at) / 60000;
+    return minutes > 0 ? Math.round((total - first.total) / minutes) : 0;
+  }
+
+  function drawBars(list, results, shares) {
+    var options = Object.keys(results).sort(function (a, b) {
+      return results[b] - results[a] || a.localeCompare(b);
+    });
+    var max = Math.max.apply(null, options.map(function (o) { return results[o]; }).concat(1));
+    list.textContent = "";
+    options.forEach(function (option) {
+      var li = document.createElement("li");
+      var label = document.createElement("div");
+      label.className = "label";
+      var name = document.createElement("span");
+      name.textContent = option;
+      var votes = document.createElement("span");
+      votes.textContent = results[option] + (shares && option in shares ? " (" + shares[option] + "%)" : "");
+      label.append(name, votes);
+      var bar = document.createElement("div");
+      bar.className = "bar";
+      bar.style.width = (results[option] / max) * 100 + "%";
+      li.append(label, bar);
+      list.append(li);
+    });
+  }
+
+  function follow(poll) {
+    var el = document.getElementById("poll").content.firstElementChild.cloneNode(true);
+    field(el, "title", poll.title);
+    document.getElementById("polls").append(el);
+    var samples = [];
+    var events = new EventSource(api("/polls/" + poll.id + "/results/stream"));
+    events.addEventListener("results", function (e) {
+      var data = JSON.parse(e.data);
+      field(el, "total", data.total);
+      field(el, "rate", rate(samples, data.total));
+      drawBars(el.querySelector(".bars"), data.results || {}, data.shares);
+    });
+  }
+
+  var counterSamples = [];
+  function checkHealth() {
+    fetchJSON("/health/stream")
+      .then(function (health) {
+        Object.keys(health).forEach(function (name) {
+          var el = document.querySelector('[data-component="' + name + '"]');
+          if (!el) {
+            return;
+          }
+          el.classList.toggle("up", health[name].up);
+          el.classList.toggle("down", !health[name].up);
+          el.title = health[name].error || JSON.stringify(health[name].metrics, null, 2);
+        });
+        var counter = health.counter;
+        if (counter.up) {
+          var votes = counter.metrics.twitterpoll_votes_total || 0;
+          document.getElementById("rate").textContent = rate(counterSamples, votes) + " votes/min";
+        }
+      })
+      .catch(function () {})
+      .then(function () {
+        setTimeout(checkHealth, 5000);
+      });
+  }
+
+  function start() {
+    fetchJSON("/polls/")
+      .then(function (polls) {
+        sessionStorage.setItem("key", key);
+        (polls || []).forEach(follow);
+        checkHealth();
+      })
+      .catch(function (err) {
+        sessionStorage.removeItem("key");
+        document.getElementById("polls").textContent = "Failed to load polls: " + err.message;
+        document.getElementById("login").hidden = false;
+      });
+  }
+
+  document.getElementById("login").addEventListener("submit", function (e) {
+    e.preventDefault();
+    key = e.target.key.value;
+    e.target.hidden = true;
+    document.getElementById("polls").textContent = "";
+    start();
+  });
+
+  if (key) {
+    start();
+  } else {
+    document.getElementById("login").hidden = false;
+  }
+})();
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A vote counted for the wrong option, or that shouldn't have counted, is
+// disputed with POST /polls/{id}/disputes, naming its tweet. The dispute is
+// the correction the counter applies to the poll's results: decrement takes
+// the tweet's votes out of its option, reassign moves them to another. Each
+// vote is corrected once, disputing it again is a conflict, and the counter
+// applies a correction once however often it looks at it.
+
+// Dispute actions and statuses, as the counter knows them
+const (
+	disputeDecrement = "decrement"
+	disputeReassign  = "reassign"
+	disputePending   = "pending"
+)
+
+// dispute is a document of the corrections collection, which the counter
+// applies and marks applied or rejected, with the problem why
+type dispute struct {
+	ID      string     `json:"id" bson:"_id"`
+	Poll    string     `json:"poll_id" bson:"poll_id"`
+	Tweet   string     `json:"tweet_id" bson:"tweet_id"`
+	Option  string     `json:"option" bson:"option"`
+	Action  string     `json:"action" bson:"action"`
+	To      string     `json:"to,omitempty" bson:"to,omitempty"`
+	Votes   int        `json:"votes" bson:"votes"`
+	Reason  string     `json:"reason,omitempty" bson:"reason,omitempty"`
+	Status  string     `json:"status" bson:"status"`
+	Problem string     `json:"problem,omitempty" bson:"problem,omitempty"`
+	Created time.Time  `json:"created" bson:"created"`
+	Applied *time.Time `json:"applied,omitempty" bson:"applied,omitempty"`
+}
+
+func (s *Server) corrections(session *mgo.Session) *mgo.Collection {
+	return session.DB(s.database).C("corrections")
+}
+
+// GET lists the disputes of the poll id, POST disputes a vote
+func (s *Server) handlePollDisputes(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	if r.Method == "GET" {
+		disputes := []dispute{}
+		if err := s.corrections(session).Find(bson.M{"poll_id": id}).Sort("created").All(&disputes); err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load disputes", err)
+			return
+		}
+		respond(w, r, http.StatusOK, disputes)
+		return
+	}
+	var d dispute
+	if err := decodeBody(r, &d); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read dispute from request", err)
+		return
+	}
+	var p poll
+	if err := s.polls(session).Find(notDeleted(bson.M{"_id": bson.ObjectIdHex(id)})).One(&p); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	if err := validateDispute(&d, &p); err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if n, err := session.DB(s.database).C("certificates").FindId(id).Count(); err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to load certificate", err)
+		return
+	} else if n > 0 {
+		respondErr(w, r, http.StatusConflict, "the poll's results are certified, they can't be corrected")
+		return
+	}
+	d.ID = id + "/" + d.Tweet + "/" + d.Option
+	d.Poll = id
+	d.Status = disputePending
+	d.Problem = ""
+	d.Created = time.Now().UTC()
+	d.Applied = nil
+	if err := s.corrections(session).Insert(&d); err != nil {
+		if mgo.IsDup(err) {
+			respondErr(w, r, http.StatusConflict, "the vote of tweet ", d.Tweet, " for ", d.Option, " is already disputed")
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to save dispute", err)
+		return
+	}
+	respond(w, r, http.StatusCreated, d)
+}
+
+// validateDispute checks d against the options of p, d counting one vote
+// when it doesn't say
+func validateDispute(d *dispute, p *poll) error {
+	d.Tweet = strings.TrimSpace(d.Tweet)
+	if d.Tweet == "" || strings.Trim(d.Tweet, "0123456789") != "" {
+		return fmt.Errorf("tweet_id %q isn't a tweet ID", d.Tweet)
+	}
+	if !hasOption(p.Options, d.Option) {
+		return fmt.Errorf("the poll has no option %q", d.Option)
+	}
+	switch d.Action {
+	case disputeDecrement:
+		if d.To != "" {
+			return fmt.Errorf("to is only for %s", disputeReassign)
+		}
+	case disputeReassign:
+		if !hasOption(p.Options, d.To) {
+			return fmt.Errorf("the poll has no option %q to reassign to", d.To)
+		}
+		if d.To == d.Option {
+			return fmt.Errorf("the votes are already for %q", d.To)
+		}
+	default:
+		return fmt.Errorf("unknown action %q, want %s or %s", d.Action, disputeDecrement, disputeReassign)
+	}
+	if d.Votes == 0 {
+		d.Votes = 1
+	}
+	if d.Votes < 0 {
+		return fmt.Errorf("votes must be positive")
+	}
+	return nil
+}
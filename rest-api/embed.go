@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"embed"
+	"errors"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The embed widget is a page partners put in an iframe to show a poll's
+// results on their sites. It is served without an API key, for public polls
+// only, and follows the results on its own stream while the poll counts
+// votes. The page carries its CSS and script, a single request draws it.
+
+//go:embed embed/widget.html
+var widgetFiles embed.FS
+
+var widgetPage = template.Must(template.ParseFS(widgetFiles, "embed/widget.html"))
+
+// widgetThemes are what ?theme= picks from, the first by default
+var widgetThemes = []string{"light", "dark", "transparent"}
+
+// defaultAccent is the colour of the bars when ?accent= doesn't say
+const defaultAccent = "#1da1f2"
+
+var hexColour = regexp.MustCompile(`^[0-9a-fA-F]{3}([0-9a-fA-F]{3})?$`)
+
+// widgetTheme is how the widget looks, from the query
+type widgetTheme struct {
+	Name      string
+	Accent    string
+	ShowTitle bool
+}
+
+// widgetData is what the widget's script starts from
+type widgetData struct {
+	Results resultsEvent `json:"results"`
+	Show    string       `json:"show"`  // shares or votes
+	Limit   int          `json:"limit"` // options drawn, every one when 0
+	Live    bool         `json:"live"`
+	Stream  string       `json:"stream"`
+}
+
+// parseWidget reads ?theme=, ?accent= (a hex colour without #), ?title=false,
+// ?show=votes and ?limit=
+func parseWidget(r *http.Request) (widgetTheme, widgetData, error) {
+	q := r.URL.Query()
+	theme := widgetTheme{Name: widgetThemes[0], Accent: defaultAccent, ShowTitle: true}
+	data := widgetData{Show: "shares"}
+	if v := q.Get("theme"); v != "" {
+		if !contains(widgetThemes, v) {
+			return theme, data, fmt.Errorf("unknown theme %q, expected one of %s", v, strings.Join(widgetThemes, ","))
+		}
+		theme.Name = v
+	}
+	if v := strings.TrimPrefix(q.Get("accent"), "#"); v != "" {
+		if !hexColour.MatchString(v) {
+			return theme, data, fmt.Errorf("accent must be a hex colour like 1da1f2, not %q", v)
+		}
+		theme.Accent = "#" + v
+	}
+	if v := q.Get("title"); v != "" {
+		show, err := strconv.ParseBool(v)
+		if err != nil {
+			return theme, data, fmt.Errorf("title must be true or false, not %q", v)
+		}
+		theme.ShowTitle = show
+	}
+	switch v := q.Get("show"); v {
+	case "":
+	case "shares", "votes":
+		data.Show = v
+	default:
+		return theme, data, fmt.Errorf("show must be shares or votes, not %q", v)
+	}
+	if v := q.Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return theme, data, fmt.Errorf("limit must be a number of options, not %q", v)
+		}
+		data.Limit = n
+	}
+	return theme, data, nil
+}
+
+// errNotEmbeddable stops the stream of a poll that can no longer be shown
+var errNotEmbeddable = errors.New("the poll can't be embedded")
+
+// embeddable reports whether p may be shown to anyone: a public poll that
+// isn't a draft or deleted
+func embeddable(p *poll) bool {
+	if p.Visibility != "" && p.Visibility != "public" {
+		return false
+	}
+	switch p.status() {
+	case pollStatusDraft, pollStatusDeleted:
+		return false
+	}
+	return true
+}
+
+// withEmbeds serves /polls/{id}/embed and /polls/{id}/embed/stream to anyone,
+// rate limited by address, and the rest of /polls/ with next
+func (s *Server) withEmbeds(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id, sub, ok := NewPath(r.URL.Path).SubResource()
+		if !ok || (sub != "embed" && sub != "embed/stream") {
+			next(w, r)
+			return
+		}
+		if r.Method != "GET" {
+			respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+			return
+		}
+		if ok, wait := s.perIP.allow(clientIP(r, s.trustForwarded)); !ok {
+			respondTooMany(w, r, wait)
+			return
+		}
+		if sub == "embed" {
+			s.results.serve(w, r, func(w http.ResponseWriter, r *http.Request) { s.handlePollEmbed(w, r, id) })
+		} else {
+			s.handlePollEmbedStream(w, r, id)
+		}
+	}
+}
+
+// embeddedPoll loads the poll id, answering 404 when there is none anyone may see
+func (s *Server) embeddedPoll(w http.ResponseWriter, r *http.Request, id string) (poll, bool) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return poll{}, false
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	p, err := s.currentPoll(s.polls(session), bson.ObjectIdHex(id))
+	if err != nil || !embeddable(&p) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return p, false
+	}
+	return p, true
+}
+
+// GET /polls/{id}/embed serves the widget of a public poll, for an iframe on a
+// site allowed by -embed-origins
+func (s *Server) handlePollEmbed(w http.ResponseWriter, r *http.Request, id string) {
+	theme, data, err := parseWidget(r)
+	if err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	p, ok := s.embeddedPoll(w, r, id)
+	if !ok {
+		return
+	}
+	data.Results = newResultsEvent(p)
+	switch p.status() {
+	case pollStatusActive, pollStatusPaused:
+		data.Live = true
+		data.Stream = "/polls/" + id + "/embed/stream"
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Content-Security-Policy", "frame-ancestors "+s.embedOrigins)
+	w.Header().Set("Cache-Control", "public, max-age=30")
+	w.WriteHeader(http.StatusOK)
+	err = widgetPage.Execute(w, struct {
+		Title  string
+		Theme  widgetTheme
+		Widget widgetData
+	}{p.Title, theme, data})
+	if err != nil {
+		log.Println("failed to render the embed widget of poll", id+":", err)
+	}
+}
+
+// GET /polls/{id}/embed/stream sends a public poll's results as server-sent
+// events every time they change, like /polls/{id}/results/stream
+func (s *Server) handlePollEmbedStream(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	p, changes, stop, err := s.watchers.subscribe(bson.ObjectIdHex(id))
+	if err != nil || !embeddable(&p) {
+		if err == nil {
+			stop()
+		}
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	defer stop()
+	events, ok := newSSEWriter(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go events.keepAlive(ctx, resultsPing)
+	followResults(ctx, p, changes, func(p poll) error {
+		if !embeddable(&p) {
+			return errNotEmbeddable // made private or deleted meanwhile
+		}
+		return events.Event("results", newResultsEvent(p))
+	})
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// pollEventsTopic carries a message every time a poll is created, updated or deleted,
+// so consumers that cache poll metadata (like the counter) know when to reload.
+const pollEventsTopic = "poll_events"
+
+// pollEvent announces a change to a single poll
+type pollEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"` // created, updated or deleted
+}
+
+// publishPollEvent tells the rest of the pipeline that a poll changed.
+// Events are best effort; consumers also refresh their caches periodically.
+func (s *Server) publishPollEvent(id, action string) {
+	if s.events == nil {
+		return
+	}
+	b, err := json.Marshal(pollEvent{ID: id, Action: action})
+	if err != nil {
+		log.Println("failed to encode poll event:", err)
+		return
+	}
+	if err := s.events.Publish(pollEventsTopic, b); err != nil {
+		log.Println("failed to publish poll event:", err)
+	}
+}
+
+// connectEvents creates the producer used for poll events, or nil when no nsqd address is configured.
+// It connects with t when set and authenticates with NSQ_AUTH_SECRET.
+func connectEvents(addr string, t *tls.Config) *nsq.Producer {
+	if addr == "" {
+		return nil
+	}
+	cfg := nsq.NewConfig()
+	if t != nil {
+		cfg.TlsV1 = true
+		cfg.TlsConfig = t
+	}
+	cfg.AuthSecret = os.Getenv("NSQ_AUTH_SECRET")
+	pub, err := nsq.NewProducer(addr, cfg)
+	if err != nil {
+		log.Println("poll events disabled:", err)
+		return nil
+	}
+	return pub
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Ways the counter can break a poll's results down by where the votes came from
+const (
+	geoByCountry = "country"
+	geoByRegion  = "region"
+)
+
+// maxLocations is the most bounding boxes Twitter accepts on one stream
+const maxLocations = 25
+
+// validateGeo checks a poll's location filters and geo aggregation.
+// Boxes are west,south,east,north longitude/latitude pairs.
+func validateGeo(locations [][4]float64, aggregation string) error {
+	if len(locations) > maxLocations {
+		return fmt.Errorf("at most %d locations are allowed", maxLocations)
+	}
+	for _, b := range locations {
+		w, s, e, n := b[0], b[1], b[2], b[3]
+		if w < -180 || e > 180 || s < -90 || n > 90 || w >= e || s >= n {
+			return fmt.Errorf("invalid location %v, expected west,south,east,north", b)
+		}
+	}
+	switch aggregation {
+	case "", geoByCountry, geoByRegion:
+	default:
+		return errors.New("geo_aggregation must be country or region")
+	}
+	return nil
+}
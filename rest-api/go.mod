@@ -1,5 +1,8 @@
 module github.com/olawolu/twitter-polls/rest-api
 
-go 1.14
+go 1.16
 
-require gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+require (
+	github.com/nsqio/go-nsq v1.0.8
+	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
+)
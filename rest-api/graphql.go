@@ -0,0 +1,531 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// The front-end speaks GraphQL. Its queries are parsed here, by hand like the
+// rest of the wire formats in this repo, into the few nodes the executor in
+// graphql_exec.go needs: operations, fields with their arguments and
+// directives, fragments and values. Type system definitions aren't accepted,
+// the schema is declared in Go.
+
+// gqlDocument is a parsed request
+type gqlDocument struct {
+	operations []*gqlOperation
+	fragments  map[string]*gqlFragment
+}
+
+// gqlOperation is a query, a mutation or a subscription
+type gqlOperation struct {
+	kind       string // query, mutation or subscription
+	name       string
+	variables  []gqlVariableDef
+	selections []*gqlSelection
+}
+
+type gqlVariableDef struct {
+	name string
+	typ  *gqlType
+	def  *gqlValue // nil without a default
+}
+
+// gqlType is a type reference such as [String!]!
+type gqlType struct {
+	name    string   // empty for a list
+	elem    *gqlType // the type of a list's elements
+	nonNull bool
+}
+
+func (t *gqlType) String() string {
+	s := t.name
+	if t.elem != nil {
+		s = "[" + t.elem.String() + "]"
+	}
+	if t.nonNull {
+		s += "!"
+	}
+	return s
+}
+
+// gqlSelection is a field, a fragment spread or an inline fragment
+type gqlSelection struct {
+	alias, name string // the field, alias is the name itself without one
+	args        map[string]*gqlValue
+	selections  []*gqlSelection
+	directives  []gqlDirective
+
+	spread string // the fragment spread, ...name
+	inline bool   // an inline fragment, ... on Type { }
+	on     string // the type condition of an inline fragment, may be empty
+}
+
+type gqlFragment struct {
+	on         string
+	selections []*gqlSelection
+}
+
+type gqlDirective struct {
+	name string
+	args map[string]*gqlValue
+}
+
+// Kinds of values
+const (
+	gqlVariable = iota
+	gqlInt
+	gqlFloat
+	gqlString
+	gqlBoolean
+	gqlNull
+	gqlEnum
+	gqlList
+	gqlObject
+)
+
+// gqlValue is a literal or a variable in a query
+type gqlValue struct {
+	kind   int
+	raw    string // the variable's name, the number or the string, boolean or enum value
+	list   []*gqlValue
+	fields map[string]*gqlValue
+}
+
+// Token kinds
+const (
+	tokEOF = iota
+	tokPunct
+	tokName
+	tokInt
+	tokFloat
+	tokString
+)
+
+type gqlToken struct {
+	kind int
+	text string
+	pos  int
+}
+
+// gqlSyntaxError reports where a query stops making sense
+type gqlSyntaxError struct {
+	line, column int
+	msg          string
+}
+
+func (e *gqlSyntaxError) Error() string {
+	return fmt.Sprintf("syntax error at %d:%d: %s", e.line, e.column, e.msg)
+}
+
+type gqlParser struct {
+	src  string
+	toks []gqlToken
+	i    int
+}
+
+// parseGraphQL parses a request's query
+func parseGraphQL(src string) (doc *gqlDocument, err error) {
+	p := &gqlParser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	// the parser panics on the first error, it is reported as the request's only one
+	defer func() {
+		if r := recover(); r != nil {
+			se, ok := r.(*gqlSyntaxError)
+			if !ok {
+				panic(r)
+			}
+			doc, err = nil, se
+		}
+	}()
+	doc = &gqlDocument{fragments: make(map[string]*gqlFragment)}
+	for p.peek().kind != tokEOF {
+		switch t := p.peek(); {
+		case t.kind == tokPunct && t.text == "{":
+			doc.operations = append(doc.operations, &gqlOperation{kind: "query", selections: p.selectionSet()})
+		case t.kind == tokName && (t.text == "query" || t.text == "mutation" || t.text == "subscription"):
+			doc.operations = append(doc.operations, p.operation())
+		case t.kind == tokName && t.text == "fragment":
+			p.next()
+			name := p.name()
+			if name == "on" {
+				p.fail(p.toks[p.i-1], "a fragment can't be named on")
+			}
+			if doc.fragments[name] != nil {
+				p.fail(p.toks[p.i-1], fmt.Sprintf("fragment %s is defined twice", name))
+			}
+			p.keyword("on")
+			f := &gqlFragment{on: p.name()}
+			p.directives()
+			f.selections = p.selectionSet()
+			doc.fragments[name] = f
+		default:
+			p.fail(t, "expected an operation or a fragment")
+		}
+	}
+	if len(doc.operations) == 0 {
+		return nil, &gqlSyntaxError{line: 1, column: 1, msg: "the document has no operation"}
+	}
+	return doc, nil
+}
+
+func (p *gqlParser) operation() *gqlOperation {
+	op := &gqlOperation{kind: p.next().text}
+	if p.peek().kind == tokName {
+		op.name = p.name()
+	}
+	if p.punct("(") {
+		for !p.punct(")") {
+			p.expect("$")
+			v := gqlVariableDef{name: p.name()}
+			p.expect(":")
+			v.typ = p.typeRef()
+			if p.punct("=") {
+				v.def = p.value(true)
+			}
+			op.variables = append(op.variables, v)
+		}
+	}
+	p.directives()
+	op.selections = p.selectionSet()
+	return op
+}
+
+func (p *gqlParser) typeRef() *gqlType {
+	var t *gqlType
+	if p.punct("[") {
+		t = &gqlType{elem: p.typeRef()}
+		p.expect("]")
+	} else {
+		t = &gqlType{name: p.name()}
+	}
+	t.nonNull = p.punct("!")
+	return t
+}
+
+func (p *gqlParser) selectionSet() []*gqlSelection {
+	p.expect("{")
+	var sels []*gqlSelection
+	for !p.punct("}") {
+		sels = append(sels, p.selection())
+	}
+	if len(sels) == 0 {
+		p.fail(p.toks[p.i-1], "a selection set can't be empty")
+	}
+	return sels
+}
+
+func (p *gqlParser) selection() *gqlSelection {
+	if p.punct("...") {
+		if t := p.peek(); t.kind == tokName && t.text != "on" {
+			return &gqlSelection{spread: p.name(), directives: p.directives()}
+		}
+		sel := &gqlSelection{inline: true}
+		if p.peek().kind == tokName {
+			p.keyword("on")
+			sel.on = p.name()
+		}
+		sel.directives = p.directives()
+		sel.selections = p.selectionSet()
+		return sel
+	}
+	sel := &gqlSelection{name: p.name()}
+	sel.alias = sel.name
+	if p.punct(":") {
+		sel.name = p.name()
+	}
+	sel.args = p.arguments(false)
+	sel.directives = p.directives()
+	if t := p.peek(); t.kind == tokPunct && t.text == "{" {
+		sel.selections = p.selectionSet()
+	}
+	return sel
+}
+
+func (p *gqlParser) arguments(constant bool) map[string]*gqlValue {
+	if !p.punct("(") {
+		return nil
+	}
+	args := make(map[string]*gqlValue)
+	for !p.punct(")") {
+		t := p.peek()
+		name := p.name()
+		if args[name] != nil {
+			p.fail(t, fmt.Sprintf("argument %s is given twice", name))
+		}
+		p.expect(":")
+		args[name] = p.value(constant)
+	}
+	return args
+}
+
+func (p *gqlParser) directives() []gqlDirective {
+	var ds []gqlDirective
+	for p.punct("@") {
+		ds = append(ds, gqlDirective{name: p.name(), args: p.arguments(false)})
+	}
+	return ds
+}
+
+// value parses a value, constant ones, such as the defaults of variables, can't have variables
+func (p *gqlParser) value(constant bool) *gqlValue {
+	t := p.next()
+	switch t.kind {
+	case tokInt:
+		return &gqlValue{kind: gqlInt, raw: t.text}
+	case tokFloat:
+		return &gqlValue{kind: gqlFloat, raw: t.text}
+	case tokString:
+		return &gqlValue{kind: gqlString, raw: t.text}
+	case tokName:
+		switch t.text {
+		case "true", "false":
+			return &gqlValue{kind: gqlBoolean, raw: t.text}
+		case "null":
+			return &gqlValue{kind: gqlNull}
+		}
+		return &gqlValue{kind: gqlEnum, raw: t.text}
+	case tokPunct:
+		switch t.text {
+		case "$":
+			if constant {
+				p.fail(t, "a variable can't be used here")
+			}
+			return &gqlValue{kind: gqlVariable, raw: p.name()}
+		case "[":
+			v := &gqlValue{kind: gqlList}
+			for !p.punct("]") {
+				v.list = append(v.list, p.value(constant))
+			}
+			return v
+		case "{":
+			v := &gqlValue{kind: gqlObject, fields: make(map[string]*gqlValue)}
+			for !p.punct("}") {
+				f := p.peek()
+				name := p.name()
+				if v.fields[name] != nil {
+					p.fail(f, fmt.Sprintf("field %s is given twice", name))
+				}
+				p.expect(":")
+				v.fields[name] = p.value(constant)
+			}
+			return v
+		}
+	}
+	p.fail(t, "expected a value")
+	return nil
+}
+
+func (p *gqlParser) peek() gqlToken {
+	return p.toks[p.i]
+}
+
+func (p *gqlParser) next() gqlToken {
+	t := p.toks[p.i]
+	if t.kind != tokEOF {
+		p.i++
+	}
+	return t
+}
+
+// punct consumes the punctuator s if it is next
+func (p *gqlParser) punct(s string) bool {
+	if t := p.peek(); t.kind == tokPunct && t.text == s {
+		p.i++
+		return true
+	}
+	return false
+}
+
+func (p *gqlParser) expect(s string) {
+	if !p.punct(s) {
+		p.fail(p.peek(), fmt.Sprintf("expected %q", s))
+	}
+}
+
+func (p *gqlParser) name() string {
+	t := p.next()
+	if t.kind != tokName {
+		p.fail(t, "expected a name")
+	}
+	return t.text
+}
+
+func (p *gqlParser) keyword(s string) {
+	if t := p.next(); t.kind != tokName || t.text != s {
+		p.fail(t, fmt.Sprintf("expected %q", s))
+	}
+}
+
+func (p *gqlParser) fail(t gqlToken, msg string) {
+	if t.kind == tokEOF {
+		msg += ", the query ends"
+	} else {
+		msg += fmt.Sprintf(", got %q", t.text)
+	}
+	panic(p.errorAt(t.pos, msg))
+}
+
+func (p *gqlParser) errorAt(pos int, msg string) *gqlSyntaxError {
+	before := p.src[:pos]
+	line := strings.Count(before, "\n") + 1
+	column := utf8.RuneCountInString(before[strings.LastIndex(before, "\n")+1:]) + 1
+	return &gqlSyntaxError{line: line, column: column, msg: msg}
+}
+
+// lex splits the query into tokens, dropping whitespace, commas and comments
+func (p *gqlParser) lex() error {
+	src := p.src
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			i++
+		case c == '#':
+			for i < len(src) && src[i] != '\n' && src[i] != '\r' {
+				i++
+			}
+		case strings.HasPrefix(src[i:], "..."):
+			p.toks = append(p.toks, gqlToken{kind: tokPunct, text: "...", pos: i})
+			i += 3
+		case strings.IndexByte("!$()[]{}:=@|&", c) >= 0:
+			p.toks = append(p.toks, gqlToken{kind: tokPunct, text: string(c), pos: i})
+			i++
+		case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+			j := i + 1
+			for j < len(src) && (src[j] == '_' || src[j] >= 'a' && src[j] <= 'z' || src[j] >= 'A' && src[j] <= 'Z' || src[j] >= '0' && src[j] <= '9') {
+				j++
+			}
+			p.toks = append(p.toks, gqlToken{kind: tokName, text: src[i:j], pos: i})
+			i = j
+		case c == '-' || c >= '0' && c <= '9':
+			j, kind, err := p.lexNumber(i)
+			if err != nil {
+				return err
+			}
+			p.toks = append(p.toks, gqlToken{kind: kind, text: src[i:j], pos: i})
+			i = j
+		case c == '"':
+			s, j, err := p.lexString(i)
+			if err != nil {
+				return err
+			}
+			p.toks = append(p.toks, gqlToken{kind: tokString, text: s, pos: i})
+			i = j
+		default:
+			r, _ := utf8.DecodeRuneInString(src[i:])
+			return p.errorAt(i, fmt.Sprintf("unexpected character %q", r))
+		}
+	}
+	p.toks = append(p.toks, gqlToken{kind: tokEOF, pos: len(src)})
+	return nil
+}
+
+func (p *gqlParser) lexNumber(i int) (int, int, error) {
+	src, start := p.src, i
+	digits := func() int {
+		n := 0
+		for i < len(src) && src[i] >= '0' && src[i] <= '9' {
+			i++
+			n++
+		}
+		return n
+	}
+	kind := tokInt
+	if src[i] == '-' {
+		i++
+	}
+	intStart := i
+	if digits() == 0 {
+		return 0, 0, p.errorAt(start, "invalid number")
+	}
+	if src[intStart] == '0' && i-intStart > 1 {
+		return 0, 0, p.errorAt(start, "numbers can't start with 0")
+	}
+	if i < len(src) && src[i] == '.' {
+		i++
+		kind = tokFloat
+		if digits() == 0 {
+			return 0, 0, p.errorAt(start, "invalid number")
+		}
+	}
+	if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+		i++
+		kind = tokFloat
+		if i < len(src) && (src[i] == '+' || src[i] == '-') {
+			i++
+		}
+		if digits() == 0 {
+			return 0, 0, p.errorAt(start, "invalid number")
+		}
+	}
+	return i, kind, nil
+}
+
+// lexString reads the string starting at i, a quoted or a block string, and returns its value
+func (p *gqlParser) lexString(i int) (string, int, error) {
+	src, start := p.src, i
+	if strings.HasPrefix(src[i:], `"""`) {
+		end := strings.Index(src[i+3:], `"""`)
+		for end >= 0 && src[i+3+end-1] == '\\' {
+			next := strings.Index(src[i+3+end+3:], `"""`)
+			if next < 0 {
+				end = -1
+				break
+			}
+			end += 3 + next
+		}
+		if end < 0 {
+			return "", 0, p.errorAt(start, "unterminated string")
+		}
+		raw := strings.Replace(src[i+3:i+3+end], `\"""`, `"""`, -1)
+		return strings.TrimSpace(raw), i + 3 + end + 3, nil
+	}
+	var b strings.Builder
+	for i++; i < len(src); {
+		c := src[i]
+		switch {
+		case c == '"':
+			return b.String(), i + 1, nil
+		case c == '\n' || c == '\r':
+			return "", 0, p.errorAt(start, "unterminated string")
+		case c == '\\' && i+1 < len(src):
+			esc := src[i+1]
+			i += 2
+			switch esc {
+			case '"', '\\', '/':
+				b.WriteByte(esc)
+			case 'b':
+				b.WriteByte('\b')
+			case 'f':
+				b.WriteByte('\f')
+			case 'n':
+				b.WriteByte('\n')
+			case 'r':
+				b.WriteByte('\r')
+			case 't':
+				b.WriteByte('\t')
+			case 'u':
+				if i+4 > len(src) {
+					return "", 0, p.errorAt(i-2, "invalid unicode escape")
+				}
+				n, err := strconv.ParseUint(src[i:i+4], 16, 32)
+				if err != nil {
+					return "", 0, p.errorAt(i-2, "invalid unicode escape")
+				}
+				b.WriteRune(rune(n))
+				i += 4
+			default:
+				return "", 0, p.errorAt(i-2, fmt.Sprintf("invalid escape \\%c", esc))
+			}
+		default:
+			b.WriteByte(c)
+			i++
+		}
+	}
+	return "", 0, p.errorAt(start, "unterminated string")
+}
@@ -0,0 +1,826 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// The executor runs a parsed operation against a schema declared in Go. It
+// covers what the front-end uses: objects, input objects, lists, non-null
+// types, variables, fragments, @skip and @include, and subscriptions that
+// execute the selection again for every event. Interfaces, unions and enums
+// aren't needed by the schema and aren't supported. Clients that generate code
+// read the schema in SDL from GET /graphql/schema, there is no introspection.
+
+// Kinds of types
+const (
+	gqlScalarType = "scalar"
+	gqlObjectType = "type"
+	gqlInputType  = "input"
+)
+
+// gqlSchema is the types the API serves, with the root types of its operations
+type gqlSchema struct {
+	types map[string]*gqlTypeDef
+	order []string // the types in the order they were added, for the SDL
+
+	query, mutation, subscription string
+}
+
+type gqlTypeDef struct {
+	kind    string
+	name    string
+	doc     string
+	fields  []*gqlField // of objects and input objects
+	builtin bool        // left out of the SDL
+}
+
+// field returns the field named, nil if there is none
+func (d *gqlTypeDef) field(name string) *gqlField {
+	for _, f := range d.fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// gqlField is a field of an object or an input object
+type gqlField struct {
+	name string
+	doc  string
+	typ  *gqlType
+	args []*gqlField
+
+	// resolve returns the value of the field for source. Without it the value
+	// is the source's struct field whose JSON name is the field's name in snake case.
+	resolve func(ctx context.Context, source interface{}, args map[string]interface{}) (interface{}, error)
+
+	// subscribe, set on the fields of the subscription type, calls send with
+	// every event until ctx is done or send fails
+	subscribe func(ctx context.Context, args map[string]interface{}, send func(event interface{}) error) error
+}
+
+func newGQLSchema() *gqlSchema {
+	s := &gqlSchema{types: make(map[string]*gqlTypeDef)}
+	for _, name := range []string{"Int", "Float", "String", "Boolean", "ID"} {
+		s.add(&gqlTypeDef{kind: gqlScalarType, name: name, builtin: true})
+	}
+	return s
+}
+
+func (s *gqlSchema) add(def *gqlTypeDef) {
+	s.types[def.name] = def
+	s.order = append(s.order, def.name)
+}
+
+// gqlT parses a type reference of the schema, it panics on an invalid one
+func gqlT(s string) *gqlType {
+	p := &gqlParser{src: s}
+	if err := p.lex(); err != nil {
+		panic(err)
+	}
+	t := p.typeRef()
+	if p.peek().kind != tokEOF {
+		panic(fmt.Sprintf("graphql: invalid type %q", s))
+	}
+	return t
+}
+
+// gqlRequest is the body of a POST /graphql, or the parameters of a GET
+type gqlRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName"`
+	Variables     map[string]interface{} `json:"variables"`
+}
+
+// gqlResponse is the result of an operation, or of a subscription's event
+type gqlResponse struct {
+	Data   interface{} `json:"data,omitempty"`
+	Errors []*gqlError `json:"errors,omitempty"`
+}
+
+type gqlError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+// gqlExtender is implemented by resolver errors that carry details for clients
+type gqlExtender interface {
+	extensions() map[string]interface{}
+}
+
+// gqlExecution is an operation ready to run
+type gqlExecution struct {
+	schema *gqlSchema
+	doc    *gqlDocument
+	op     *gqlOperation
+	root   *gqlTypeDef
+	vars   map[string]interface{}
+	errors []*gqlError
+}
+
+// prepare parses req and checks the operation to run against the schema.
+// The errors returned are the request's, nothing was executed.
+func (s *gqlSchema) prepare(req gqlRequest) (*gqlExecution, []*gqlError) {
+	if strings.TrimSpace(req.Query) == "" {
+		return nil, gqlErrors("the request has no query")
+	}
+	doc, err := parseGraphQL(req.Query)
+	if err != nil {
+		return nil, gqlErrors(err.Error())
+	}
+	e := &gqlExecution{schema: s, doc: doc}
+	for _, op := range doc.operations {
+		if op.name == req.OperationName || req.OperationName == "" && len(doc.operations) == 1 {
+			e.op = op
+		}
+	}
+	if e.op == nil {
+		if req.OperationName == "" {
+			return nil, gqlErrors("the document has several operations, operationName picks one")
+		}
+		return nil, gqlErrors(fmt.Sprintf("there is no operation named %s", req.OperationName))
+	}
+	root := map[string]string{"query": s.query, "mutation": s.mutation, "subscription": s.subscription}[e.op.kind]
+	if root == "" {
+		return nil, gqlErrors(fmt.Sprintf("the schema has no %s type", e.op.kind))
+	}
+	e.root = s.types[root]
+
+	e.vars = make(map[string]interface{})
+	var errs []*gqlError
+	for _, v := range e.op.variables {
+		def := s.types[v.typ.named()]
+		if def == nil || def.kind == gqlObjectType {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("variable $%s: %s isn't an input type", v.name, v.typ)})
+			continue
+		}
+		value, given := req.Variables[v.name]
+		if !given && v.def != nil {
+			value, given = e.literal(v.def), true
+		}
+		if _, err := s.coerce(v.typ, value); err != nil {
+			errs = append(errs, &gqlError{Message: fmt.Sprintf("variable $%s: %v", v.name, err)})
+			continue
+		}
+		if given {
+			e.vars[v.name] = value
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	e.validate(e.root, e.op.selections, nil, make(map[string]bool))
+	if e.op.kind == "subscription" {
+		fields := e.collect(e.root, e.op.selections)
+		if len(fields) != 1 || fields[0][0].name == "__typename" {
+			e.fail(nil, "a subscription selects exactly one field")
+		}
+	}
+	if len(e.errors) > 0 {
+		return nil, e.errors
+	}
+	return e, nil
+}
+
+func gqlErrors(msg string) []*gqlError {
+	return []*gqlError{{Message: msg}}
+}
+
+func (e *gqlExecution) fail(path []interface{}, msg string) {
+	e.errors = append(e.errors, &gqlError{Message: msg, Path: append([]interface{}(nil), path...)})
+}
+
+// validate checks the selections made on def: the fields exist, their arguments
+// are valid and objects, and only objects, have selections
+func (e *gqlExecution) validate(def *gqlTypeDef, sels []*gqlSelection, path []interface{}, spreading map[string]bool) {
+	for _, sel := range sels {
+		for _, d := range sel.directives {
+			e.checkVariables(path, d.args)
+			if d.name != "skip" && d.name != "include" {
+				e.fail(path, fmt.Sprintf("unknown directive @%s", d.name))
+			} else if _, err := e.schema.coerce(gqlT("Boolean!"), e.literal(d.args["if"])); err != nil {
+				e.fail(path, fmt.Sprintf("@%s(if:): %v", d.name, err))
+			}
+		}
+		switch {
+		case sel.spread != "":
+			f := e.doc.fragments[sel.spread]
+			switch {
+			case f == nil:
+				e.fail(path, fmt.Sprintf("unknown fragment %s", sel.spread))
+			case spreading[sel.spread]:
+				e.fail(path, fmt.Sprintf("fragment %s spreads itself", sel.spread))
+			case f.on != def.name:
+				e.fail(path, fmt.Sprintf("fragment %s on %s can't be spread on %s", sel.spread, f.on, def.name))
+			default:
+				spreading[sel.spread] = true
+				e.validate(def, f.selections, path, spreading)
+				delete(spreading, sel.spread)
+			}
+		case sel.inline:
+			if sel.on != "" && sel.on != def.name {
+				e.fail(path, fmt.Sprintf("a fragment on %s can't be spread on %s", sel.on, def.name))
+				continue
+			}
+			e.validate(def, sel.selections, path, spreading)
+		case sel.name == "__typename":
+			if sel.selections != nil {
+				e.fail(append(path, sel.alias), "__typename is a String and has no fields")
+			}
+		default:
+			at := append(path, sel.alias)
+			f := def.field(sel.name)
+			if f == nil {
+				e.fail(at, fmt.Sprintf("%s has no field %s", def.name, sel.name))
+				continue
+			}
+			e.checkVariables(at, sel.args)
+			if _, err := e.args(f, sel); err != nil {
+				e.fail(at, err.Error())
+			}
+			switch named := e.schema.types[f.typ.named()]; {
+			case named.kind == gqlObjectType && sel.selections == nil:
+				e.fail(at, fmt.Sprintf("%s is a %s, select some of its fields", sel.name, f.typ))
+			case named.kind == gqlObjectType:
+				e.validate(named, sel.selections, at, spreading)
+			case sel.selections != nil:
+				e.fail(at, fmt.Sprintf("%s is a %s and has no fields", sel.name, f.typ))
+			}
+		}
+	}
+}
+
+// checkVariables makes sure the variables used in args are declared by the operation
+func (e *gqlExecution) checkVariables(path []interface{}, args map[string]*gqlValue) {
+	var walk func(v *gqlValue)
+	walk = func(v *gqlValue) {
+		switch v.kind {
+		case gqlVariable:
+			for _, def := range e.op.variables {
+				if def.name == v.raw {
+					return
+				}
+			}
+			e.fail(path, fmt.Sprintf("variable $%s isn't declared", v.raw))
+		case gqlList:
+			for _, item := range v.list {
+				walk(item)
+			}
+		case gqlObject:
+			for _, field := range v.fields {
+				walk(field)
+			}
+		}
+	}
+	for _, v := range args {
+		walk(v)
+	}
+}
+
+// args coerces the arguments of sel to the types f declares
+func (e *gqlExecution) args(f *gqlField, sel *gqlSelection) (map[string]interface{}, error) {
+	for name := range sel.args {
+		if a := fieldNamed(f.args, name); a == nil {
+			return nil, fmt.Errorf("%s has no argument %s", f.name, name)
+		}
+	}
+	args := make(map[string]interface{}, len(f.args))
+	for _, a := range f.args {
+		lit, given := sel.args[a.name]
+		if given && lit.kind == gqlVariable {
+			_, given = e.vars[lit.raw]
+		}
+		v, err := e.schema.coerce(a.typ, e.literal(lit))
+		if err != nil {
+			return nil, fmt.Errorf("argument %s: %v", a.name, err)
+		}
+		if given {
+			args[a.name] = v
+		}
+	}
+	return args, nil
+}
+
+func fieldNamed(fields []*gqlField, name string) *gqlField {
+	for _, f := range fields {
+		if f.name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+// gqlEnumLiteral is an enum value written in a query, the schema has no enums to accept it
+type gqlEnumLiteral string
+
+// literal turns a value in the query into the Go value JSON would have decoded,
+// with the variables replaced by their values
+func (e *gqlExecution) literal(v *gqlValue) interface{} {
+	if v == nil {
+		return nil
+	}
+	switch v.kind {
+	case gqlVariable:
+		return e.vars[v.raw]
+	case gqlInt, gqlFloat:
+		return json.Number(v.raw)
+	case gqlString:
+		return v.raw
+	case gqlBoolean:
+		return v.raw == "true"
+	case gqlEnum:
+		return gqlEnumLiteral(v.raw)
+	case gqlList:
+		list := make([]interface{}, len(v.list))
+		for i, item := range v.list {
+			list[i] = e.literal(item)
+		}
+		return list
+	case gqlObject:
+		obj := make(map[string]interface{}, len(v.fields))
+		for name, field := range v.fields {
+			obj[name] = e.literal(field)
+		}
+		return obj
+	}
+	return nil
+}
+
+// coerce checks an input value against t and returns it as resolvers get it:
+// an int, a float64, a string, a bool, a time.Time, a []interface{} or a
+// map[string]interface{} of an input object's fields
+func (s *gqlSchema) coerce(t *gqlType, v interface{}) (interface{}, error) {
+	if v == nil {
+		if t.nonNull {
+			return nil, fmt.Errorf("expected %s, got null", t)
+		}
+		return nil, nil
+	}
+	if t.elem != nil {
+		items, ok := v.([]interface{})
+		if !ok {
+			items = []interface{}{v} // a single value is a list of one
+		}
+		list := make([]interface{}, len(items))
+		for i, item := range items {
+			c, err := s.coerce(t.elem, item)
+			if err != nil {
+				return nil, fmt.Errorf("item %d: %v", i, err)
+			}
+			list[i] = c
+		}
+		return list, nil
+	}
+	def := s.types[t.name]
+	if def.kind == gqlInputType {
+		obj, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("expected %s, got %s", t, describeInput(v))
+		}
+		for name := range obj {
+			if def.field(name) == nil {
+				return nil, fmt.Errorf("%s has no field %s", def.name, name)
+			}
+		}
+		out := make(map[string]interface{}, len(obj))
+		for _, f := range def.fields {
+			fv, given := obj[f.name]
+			c, err := s.coerce(f.typ, fv)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %v", f.name, err)
+			}
+			if given {
+				out[f.name] = c
+			}
+		}
+		return out, nil
+	}
+	if n, ok := v.(json.Number); ok {
+		// numbers from the query are kept as written, so 1.0 isn't an Int
+		switch t.name {
+		case "Int", "ID":
+			if i, err := n.Int64(); err == nil {
+				v = float64(i)
+			}
+		case "Float":
+			if f, err := n.Float64(); err == nil {
+				v = f
+			}
+		}
+	}
+	switch x := v.(type) {
+	case float64:
+		switch t.name {
+		case "Int":
+			if x == math.Trunc(x) && x >= math.MinInt32 && x <= math.MaxInt32 {
+				return int(x), nil
+			}
+		case "Float":
+			return x, nil
+		case "ID":
+			if x == math.Trunc(x) {
+				return fmt.Sprint(int64(x)), nil
+			}
+		}
+	case string:
+		switch t.name {
+		case "String", "ID":
+			return x, nil
+		case "Time":
+			tm, err := time.Parse(time.RFC3339, x)
+			if err != nil {
+				return nil, fmt.Errorf("expected an RFC 3339 time, got %q", x)
+			}
+			return tm, nil
+		}
+	case bool:
+		if t.name == "Boolean" {
+			return x, nil
+		}
+	}
+	return nil, fmt.Errorf("expected %s, got %s", t, describeInput(v))
+}
+
+// describeInput names the kind of an input value for errors
+func describeInput(v interface{}) string {
+	switch x := v.(type) {
+	case json.Number:
+		return string(x)
+	case float64:
+		return fmt.Sprint(x)
+	case string:
+		return fmt.Sprintf("%q", x)
+	case bool:
+		return fmt.Sprint(x)
+	case gqlEnumLiteral:
+		return string(x)
+	case []interface{}:
+		return "a list"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// named returns the name of the type, or of a list's innermost elements
+func (t *gqlType) named() string {
+	for t.elem != nil {
+		t = t.elem
+	}
+	return t.name
+}
+
+// execute runs a query or a mutation, the fields of a mutation one after the other
+func (e *gqlExecution) execute(ctx context.Context) gqlResponse {
+	data, ok := e.selectionSet(ctx, e.root, nil, e.op.selections, nil)
+	if !ok {
+		return gqlResponse{Errors: e.errors}
+	}
+	return gqlResponse{Data: data, Errors: e.errors}
+}
+
+// rootFields returns the names of the fields the operation selects at its root
+func (e *gqlExecution) rootFields() []string {
+	var names []string
+	for _, group := range e.collect(e.root, e.op.selections) {
+		names = append(names, group[0].name)
+	}
+	return names
+}
+
+// subscribe runs a subscription, calling send with the response to every event
+func (e *gqlExecution) subscribe(ctx context.Context, send func(gqlResponse) error) error {
+	group := e.collect(e.root, e.op.selections)[0]
+	sel := group[0]
+	f := e.root.field(sel.name)
+	args, err := e.args(f, sel)
+	if err != nil {
+		return err
+	}
+	return f.subscribe(ctx, args, func(event interface{}) error {
+		e.errors = nil
+		path := []interface{}{sel.alias}
+		value, ok := e.complete(ctx, f.typ, event, subSelections(group), path)
+		if !ok {
+			return send(gqlResponse{Errors: e.errors})
+		}
+		data := &gqlMap{}
+		data.add(sel.alias, value)
+		return send(gqlResponse{Data: data, Errors: e.errors})
+	})
+}
+
+// collect groups the fields selected on def by response name, following the
+// fragments that apply and leaving out the fields @skip or @include drop
+func (e *gqlExecution) collect(def *gqlTypeDef, sels []*gqlSelection) [][]*gqlSelection {
+	var groups [][]*gqlSelection
+	index := make(map[string]int)
+	var walk func(sels []*gqlSelection, visited map[string]bool)
+	walk = func(sels []*gqlSelection, visited map[string]bool) {
+		for _, sel := range sels {
+			if e.skipped(sel) {
+				continue
+			}
+			switch {
+			case sel.spread != "":
+				f := e.doc.fragments[sel.spread]
+				if f == nil || visited[sel.spread] || f.on != def.name {
+					continue
+				}
+				visited[sel.spread] = true
+				walk(f.selections, visited)
+			case sel.inline:
+				if sel.on == "" || sel.on == def.name {
+					walk(sel.selections, visited)
+				}
+			default:
+				if i, ok := index[sel.alias]; ok {
+					groups[i] = append(groups[i], sel)
+					continue
+				}
+				index[sel.alias] = len(groups)
+				groups = append(groups, []*gqlSelection{sel})
+			}
+		}
+	}
+	walk(sels, make(map[string]bool))
+	return groups
+}
+
+func (e *gqlExecution) skipped(sel *gqlSelection) bool {
+	for _, d := range sel.directives {
+		on, _ := e.schema.coerce(gqlT("Boolean!"), e.literal(d.args["if"]))
+		if d.name == "skip" && on == true || d.name == "include" && on == false {
+			return true
+		}
+	}
+	return false
+}
+
+// subSelections merges the selections of the fields of a group
+func subSelections(group []*gqlSelection) []*gqlSelection {
+	var sels []*gqlSelection
+	for _, sel := range group {
+		sels = append(sels, sel.selections...)
+	}
+	return sels
+}
+
+// selectionSet resolves the fields selected on source, an object of type def.
+// ok is false when a non-null field is null, which makes the object null.
+func (e *gqlExecution) selectionSet(ctx context.Context, def *gqlTypeDef, source interface{}, sels []*gqlSelection, path []interface{}) (*gqlMap, bool) {
+	m := &gqlMap{}
+	for _, group := range e.collect(def, sels) {
+		sel := group[0]
+		at := append(append([]interface{}(nil), path...), sel.alias)
+		if sel.name == "__typename" {
+			m.add(sel.alias, def.name)
+			continue
+		}
+		f := def.field(sel.name)
+		args, err := e.args(f, sel)
+		var value interface{}
+		if err == nil {
+			if f.resolve != nil {
+				value, err = f.resolve(ctx, source, args)
+			} else {
+				value = jsonField(source, f.name)
+			}
+		}
+		if err != nil {
+			gerr := &gqlError{Message: err.Error(), Path: at}
+			if x, ok := err.(gqlExtender); ok {
+				gerr.Extensions = x.extensions()
+			}
+			e.errors = append(e.errors, gerr)
+			if f.typ.nonNull {
+				return nil, false
+			}
+			m.add(sel.alias, nil)
+			continue
+		}
+		value, ok := e.complete(ctx, f.typ, value, subSelections(group), at)
+		if !ok {
+			return nil, false
+		}
+		m.add(sel.alias, value)
+	}
+	return m, true
+}
+
+// complete turns a resolved value into the field's JSON value.
+// ok is false, and the error is recorded, when a non-null value is null.
+func (e *gqlExecution) complete(ctx context.Context, t *gqlType, v interface{}, sels []*gqlSelection, path []interface{}) (interface{}, bool) {
+	rv := reflect.ValueOf(v)
+	for rv.Kind() == reflect.Ptr && !rv.IsNil() && t.elem == nil && e.schema.types[t.name].kind == gqlScalarType {
+		rv = rv.Elem()
+	}
+	null := v == nil
+	switch rv.Kind() {
+	case reflect.Ptr, reflect.Map, reflect.Interface:
+		null = null || rv.IsNil()
+	case reflect.Slice:
+		if t.elem != nil && rv.IsNil() {
+			rv = reflect.MakeSlice(rv.Type(), 0, 0) // lists are never null, only empty
+		} else {
+			null = null || rv.IsNil()
+		}
+	}
+	if null {
+		if t.nonNull {
+			e.fail(path, fmt.Sprintf("a %s can't be null", t))
+			return nil, false
+		}
+		return nil, true
+	}
+	value, ok := e.completeValue(ctx, t, rv, sels, path)
+	if !ok && !t.nonNull {
+		return nil, true
+	}
+	return value, ok
+}
+
+func (e *gqlExecution) completeValue(ctx context.Context, t *gqlType, rv reflect.Value, sels []*gqlSelection, path []interface{}) (interface{}, bool) {
+	if t.elem != nil {
+		if rv.Kind() != reflect.Slice && rv.Kind() != reflect.Array {
+			e.fail(path, fmt.Sprintf("expected a list, got %s", rv.Type()))
+			return nil, false
+		}
+		list := make([]interface{}, rv.Len())
+		for i := range list {
+			item, ok := e.complete(ctx, t.elem, rv.Index(i).Interface(), sels, append(path, i))
+			if !ok {
+				return nil, false
+			}
+			list[i] = item
+		}
+		return list, true
+	}
+	def := e.schema.types[t.name]
+	if def.kind == gqlObjectType {
+		return e.selectionSet(ctx, def, rv.Interface(), sels, path)
+	}
+	value, err := serialize(t.name, rv)
+	if err != nil {
+		e.fail(path, err.Error())
+		return nil, false
+	}
+	return value, true
+}
+
+// serialize returns the JSON value of a scalar
+func serialize(scalar string, rv reflect.Value) (interface{}, error) {
+	if hex, ok := rv.Interface().(interface{ Hex() string }); ok && scalar == "ID" {
+		return hex.Hex(), nil
+	}
+	switch k := rv.Kind(); {
+	case scalar == "Int" && k >= reflect.Int && k <= reflect.Int64:
+		return rv.Int(), nil
+	case scalar == "Float" && k >= reflect.Int && k <= reflect.Int64:
+		return float64(rv.Int()), nil
+	case scalar == "Float" && (k == reflect.Float32 || k == reflect.Float64):
+		return rv.Float(), nil
+	case (scalar == "String" || scalar == "ID") && k == reflect.String:
+		return rv.String(), nil
+	case scalar == "Boolean" && k == reflect.Bool:
+		return rv.Bool(), nil
+	case scalar == "Time":
+		if tm, ok := rv.Interface().(time.Time); ok {
+			return tm.UTC().Format(time.RFC3339), nil
+		}
+	}
+	return nil, fmt.Errorf("can't serialize a %s as a %s", rv.Type(), scalar)
+}
+
+// jsonField returns the struct field of source whose JSON name is name in snake case
+func jsonField(source interface{}, name string) interface{} {
+	rv := reflect.Indirect(reflect.ValueOf(source))
+	if rv.Kind() != reflect.Struct {
+		return nil
+	}
+	want := snakeCase(name)
+	for i := 0; i < rv.NumField(); i++ {
+		tag := rv.Type().Field(i).Tag.Get("json")
+		if strings.Split(tag, ",")[0] == want {
+			return rv.Field(i).Interface()
+		}
+	}
+	return nil
+}
+
+// snakeCase turns a GraphQL name, minShare, into a JSON one, min_share
+func snakeCase(name string) string {
+	var b strings.Builder
+	for _, r := range name {
+		if r >= 'A' && r <= 'Z' {
+			b.WriteByte('_')
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// decodeInput decodes an input object's fields into v, a struct whose JSON
+// names are the fields' names in snake case
+func decodeInput(input map[string]interface{}, v interface{}) error {
+	b, err := json.Marshal(snakeKeys(input))
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func snakeKeys(v interface{}) interface{} {
+	switch x := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(x))
+		for k, item := range x {
+			out[snakeCase(k)] = snakeKeys(item)
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(x))
+		for i, item := range x {
+			out[i] = snakeKeys(item)
+		}
+		return out
+	}
+	return v
+}
+
+// gqlMap is a JSON object that keeps its keys in the order they were selected
+type gqlMap struct {
+	keys   []string
+	values []interface{}
+}
+
+func (m *gqlMap) add(key string, v interface{}) {
+	m.keys = append(m.keys, key)
+	m.values = append(m.values, v)
+}
+
+func (m *gqlMap) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, k := range m.keys {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		key, _ := json.Marshal(k)
+		b = append(b, key...)
+		b = append(b, ':')
+		value, err := json.Marshal(m.values[i])
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, value...)
+	}
+	return append(b, '}'), nil
+}
+
+// sdl writes the schema in the GraphQL schema definition language
+func (s *gqlSchema) sdl() string {
+	var b strings.Builder
+	for _, name := range s.order {
+		def := s.types[name]
+		if def.builtin {
+			continue
+		}
+		writeDoc(&b, "", def.doc)
+		if def.kind == gqlScalarType {
+			fmt.Fprintf(&b, "scalar %s\n\n", def.name)
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s {\n", def.kind, def.name)
+		for _, f := range def.fields {
+			writeDoc(&b, "  ", f.doc)
+			b.WriteString("  " + f.name)
+			if len(f.args) > 0 {
+				args := make([]string, len(f.args))
+				for i, a := range f.args {
+					args[i] = a.name + ": " + a.typ.String()
+				}
+				b.WriteString("(" + strings.Join(args, ", ") + ")")
+			}
+			b.WriteString(": " + f.typ.String() + "\n")
+		}
+		b.WriteString("}\n\n")
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
+
+func writeDoc(b *strings.Builder, indent, doc string) {
+	if doc == "" {
+		return
+	}
+	quoted, _ := json.Marshal(doc)
+	b.WriteString(indent + string(quoted) + "\n")
+}
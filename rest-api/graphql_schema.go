@@ -0,0 +1,531 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The GraphQL API serves the polls and their results like the REST endpoints,
+// creating and changing polls with the same checks, and streams the results
+// as they change over server-sent events, like GET /polls/{id}/results/stream.
+// Field names are the REST API's JSON names in camel case.
+
+// gqlResults is a poll's results prepared for display, the Results type
+type gqlResults struct {
+	Total         int               `json:"total"`
+	WeightedTotal *float64          `json:"weighted_total"`
+	Options       []gqlOptionResult `json:"options"`
+}
+
+type gqlOptionResult struct {
+	Option        string   `json:"option"`
+	Votes         int      `json:"votes"`
+	Share         *float64 `json:"share"`
+	WeightedVotes *float64 `json:"weighted_votes"`
+	WeightedShare *float64 `json:"weighted_share"`
+}
+
+// newGQLResults lists the results of p in the order of its options, those
+// hidden by its minimum share counted in "Other" at the end
+func newGQLResults(p poll) gqlResults {
+	e := newResultsEvent(p)
+	res := gqlResults{Total: e.Total, Options: []gqlOptionResult{}}
+	var names []string
+	listed := make(map[string]bool)
+	for _, o := range p.Options {
+		if _, ok := e.Results[o]; ok || p.MinShare == 0 {
+			names = append(names, o)
+		}
+		listed[o] = true
+	}
+	var extra []string
+	for o := range e.Results {
+		if !listed[o] && o != e.Grouped {
+			extra = append(extra, o)
+		}
+	}
+	sort.Strings(extra)
+	names = append(names, extra...)
+	if _, ok := e.Results[e.Grouped]; ok && e.Grouped != "" {
+		names = append(names, e.Grouped)
+	}
+	optional := func(m map[string]float64, key string) *float64 {
+		if v, ok := m[key]; ok {
+			return &v
+		}
+		return nil
+	}
+	for _, o := range names {
+		res.Options = append(res.Options, gqlOptionResult{
+			Option:        o,
+			Votes:         e.Results[o],
+			Share:         optional(e.Shares, o),
+			WeightedVotes: optional(e.WeightedResults, o),
+			WeightedShare: optional(e.WeightedShares, o),
+		})
+	}
+	if len(e.WeightedResults) > 0 {
+		var total float64
+		for _, n := range e.WeightedResults {
+			total += n
+		}
+		res.WeightedTotal = &total
+	}
+	return res
+}
+
+// gqlCreated is the result of createPoll
+type gqlCreated struct {
+	Poll     *poll       `json:"poll"`
+	Warnings []termIssue `json:"warnings"`
+}
+
+// termsError rejects a poll whose options break Twitter's track rules,
+// the problems are in the error's extensions like POST /polls returns them
+type termsError struct {
+	v validation
+}
+
+func (e termsError) Error() string {
+	problems := make([]string, len(e.v.Errors))
+	for i, issue := range e.v.Errors {
+		problems[i] = fmt.Sprintf("%q: %s", issue.Option, issue.Problem)
+	}
+	return "invalid options: " + strings.Join(problems, "; ")
+}
+
+func (e termsError) extensions() map[string]interface{} {
+	return map[string]interface{}{"errors": e.v.Errors, "warnings": e.v.Warnings}
+}
+
+// graphQLSchema declares the API's types and resolves them against the polls collection
+func (s *Server) graphQLSchema() *gqlSchema {
+	schema := newGQLSchema()
+	schema.query, schema.mutation, schema.subscription = "Query", "Mutation", "Subscription"
+	id := func(args map[string]interface{}) string {
+		return args["id"].(string)
+	}
+
+	schema.add(&gqlTypeDef{kind: gqlScalarType, name: "Time", doc: "A time in RFC 3339"})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Query", fields: []*gqlField{
+		{name: "polls", typ: gqlT("[Poll!]!"), doc: "Every poll but the deleted ones",
+			resolve: func(ctx context.Context, _ interface{}, _ map[string]interface{}) (interface{}, error) {
+				session := s.db.Copy()
+				defer session.Close()
+				var polls []*poll
+				err := s.polls(session).Find(notDeleted(nil)).All(&polls)
+				return polls, err
+			}},
+		{name: "poll", typ: gqlT("Poll"), doc: "The poll with the ID, null if there is none",
+			args: []*gqlField{{name: "id", typ: gqlT("ID!")}},
+			resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				p, err := s.findPoll(id(args))
+				if err == mgo.ErrNotFound {
+					return nil, nil
+				}
+				return p, err
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Mutation", fields: []*gqlField{
+		{name: "createPoll", typ: gqlT("CreatedPoll!"),
+			doc:  "Creates a poll, options breaking Twitter's track rules are rejected with the problems in the error's extensions",
+			args: []*gqlField{{name: "input", typ: gqlT("PollInput!")}},
+			resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				var p poll
+				if err := decodeInput(args["input"].(map[string]interface{}), &p); err != nil {
+					return nil, err
+				}
+				if err := preparePoll(&p); err != nil {
+					return nil, err
+				}
+				others, err := s.streamedPolls()
+				if err != nil {
+					return nil, fmt.Errorf("failed to load the polls: %v", err)
+				}
+				terms := validateTerms(&p, others)
+				if !terms.Valid {
+					return nil, termsError{terms}
+				}
+				p.CreatedBy = createdBy(ctx)
+				p.ID = bson.NewObjectId()
+				session := s.db.Copy()
+				defer session.Close()
+				if err := s.polls(session).Insert(p); err != nil {
+					return nil, fmt.Errorf("failed to insert poll: %v", err)
+				}
+				s.publishPollEvent(p.ID.Hex(), "created")
+				return gqlCreated{Poll: &p, Warnings: terms.Warnings}, nil
+			}},
+		{name: "updatePoll", typ: gqlT("Poll!"), doc: "Changes the settings given, the others are left as they are",
+			args: []*gqlField{{name: "id", typ: gqlT("ID!")}, {name: "settings", typ: gqlT("PollSettingsInput!")}},
+			resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				var settings pollSettings
+				if err := decodeInput(args["settings"].(map[string]interface{}), &settings); err != nil {
+					return nil, err
+				}
+				set, err := settings.changes()
+				if err != nil {
+					return nil, err
+				}
+				if len(set) == 0 && settings.Options == nil {
+					return nil, errors.New("nothing to update")
+				}
+				if !bson.IsObjectIdHex(id(args)) {
+					return nil, fmt.Errorf("poll %s not found", id(args))
+				}
+				session := s.db.Copy()
+				defer session.Close()
+				c := s.polls(session)
+				if settings.Options != nil {
+					var terms validation
+					terms, err = s.editOptions(c, bson.ObjectIdHex(id(args)), *settings.Options, set)
+					if err == nil && !terms.Valid {
+						return nil, termsError{terms}
+					}
+				} else {
+					err = c.Update(notDeleted(bson.M{"_id": bson.ObjectIdHex(id(args))}), bson.M{"$set": set})
+				}
+				if err != nil {
+					if err == mgo.ErrNotFound {
+						return nil, fmt.Errorf("poll %s not found", id(args))
+					}
+					if err == errOptionsEdited {
+						return nil, err
+					}
+					return nil, fmt.Errorf("failed to update poll: %v", err)
+				}
+				s.publishPollEvent(id(args), "updated")
+				return s.findPoll(id(args))
+			}},
+		{name: "deletePoll", typ: gqlT("ID!"), doc: "Deletes the poll, which can be restored until it is purged, and returns its ID",
+			args: []*gqlField{{name: "id", typ: gqlT("ID!")}},
+			resolve: func(ctx context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				if !bson.IsObjectIdHex(id(args)) {
+					return nil, fmt.Errorf("poll %s not found", id(args))
+				}
+				session := s.db.Copy()
+				defer session.Close()
+				deleted := bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}}
+				if err := s.polls(session).Update(notDeleted(bson.M{"_id": bson.ObjectIdHex(id(args))}), deleted); err != nil {
+					if err == mgo.ErrNotFound {
+						return nil, fmt.Errorf("poll %s not found", id(args))
+					}
+					return nil, fmt.Errorf("failed to delete poll: %v", err)
+				}
+				s.publishPollEvent(id(args), "deleted")
+				return id(args), nil
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Subscription", fields: []*gqlField{
+		{name: "results", typ: gqlT("Results!"), doc: "The poll's results now, then every time they change",
+			args: []*gqlField{{name: "id", typ: gqlT("ID!")}},
+			subscribe: func(ctx context.Context, args map[string]interface{}, send func(interface{}) error) error {
+				if !bson.IsObjectIdHex(id(args)) {
+					return fmt.Errorf("poll %s not found", id(args))
+				}
+				p, changes, stop, err := s.watchers.subscribe(bson.ObjectIdHex(id(args)))
+				if err != nil {
+					return fmt.Errorf("poll %s not found", id(args))
+				}
+				defer stop()
+				return followResults(ctx, p, changes, func(p poll) error {
+					return send(newGQLResults(p))
+				})
+			}},
+	}})
+
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Poll", fields: []*gqlField{
+		{name: "id", typ: gqlT("ID!")},
+		{name: "title", typ: gqlT("String!")},
+		{name: "options", typ: gqlT("[String!]!")},
+		{name: "type", typ: gqlT("String!"), doc: "standard, weighted or ranked"},
+		{name: "visibility", typ: gqlT("String!"), doc: "public or private"},
+		{name: "status", typ: gqlT("String!"), doc: "draft, active, paused, closed or archived, or deleted",
+			resolve: func(ctx context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(*poll).status(), nil
+			}},
+		{name: "endsAt", typ: gqlT("Time")},
+		{name: "campaign", typ: gqlT("String")},
+		{name: "tags", typ: gqlT("[String!]!")},
+		{name: "account", typ: gqlT("String"), doc: "The Twitter credentials the options are streamed with, the default ones when empty"},
+		{name: "counting", typ: gqlT("String"), doc: "mentions or unique_authors"},
+		{name: "private", typ: gqlT("Boolean!")},
+		{name: "detailedMetrics", typ: gqlT("Boolean!")},
+		{name: "minShare", typ: gqlT("Float!"), doc: "Options below this percentage of the vote are counted in Other"},
+		{name: "precision", typ: gqlT("Int"), doc: "The decimals shares are rounded to"},
+		{name: "hashtagOnly", typ: gqlT("Boolean!")},
+		{name: "excludeSuspect", typ: gqlT("Boolean!")},
+		{name: "sampleEvery", typ: gqlT("Int!")},
+		{name: "maxPerMinute", typ: gqlT("Int!"), doc: "The votes each option counts a minute at most, the others go in the overflow metric; 0 for no cap"},
+		{name: "timezone", typ: gqlT("String"), doc: "The time zone the results are bucketed by hour and day in, UTC when empty"},
+		{name: "excludeRetweets", typ: gqlT("Boolean!")},
+		{name: "excludeQuotes", typ: gqlT("Boolean!")},
+		{name: "excludeReplies", typ: gqlT("Boolean!")},
+		{name: "embeddedText", typ: gqlT("String"), doc: "scan or ignore"},
+		{name: "folding", typ: gqlT("String"), doc: "diacritics or transliterate"},
+		{name: "filter", typ: gqlT("String"), doc: "The expression the tweets must pass for their votes to count"},
+		{name: "priority", typ: gqlT("String"), doc: "high, normal or low, low letting the options leave the stream while idle and first when there are too many, high never"},
+		{name: "locations", typ: gqlT("[[Float!]!]!"), doc: "The west, south, east and north of the boxes votes are limited to"},
+		{name: "geoAggregation", typ: gqlT("String")},
+		{name: "notifications", typ: gqlT("[Notification!]!")},
+		{name: "matching", typ: gqlT("[OptionMatching!]!"), doc: "The options matched in their case, as whole words or stemmed"},
+		{name: "embargo", typ: gqlT("Embargo"), doc: "Holds the votes back from the results until it lifts"},
+		{name: "version", typ: gqlT("Int!"), doc: "The version of the options, 1 until they are edited",
+			resolve: func(ctx context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return source.(*poll).optionsVersion(), nil
+			}},
+		{name: "versions", typ: gqlT("[OptionsVersion!]!"), doc: "Every version of the options once they were edited, the current one last"},
+		{name: "deletedAt", typ: gqlT("Time")},
+		{name: "results", typ: gqlT("Results!"),
+			resolve: func(ctx context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				return newGQLResults(*source.(*poll)), nil
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Results", doc: "A poll's results, prepared for display", fields: []*gqlField{
+		{name: "total", typ: gqlT("Int!")},
+		{name: "weightedTotal", typ: gqlT("Float"), doc: "Set on weighted polls"},
+		{name: "options", typ: gqlT("[OptionResult!]!")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "OptionResult", fields: []*gqlField{
+		{name: "option", typ: gqlT("String!")},
+		{name: "votes", typ: gqlT("Int!")},
+		{name: "share", typ: gqlT("Float"), doc: "The percentage of the votes, rounded to the poll's precision"},
+		{name: "weightedVotes", typ: gqlT("Float")},
+		{name: "weightedShare", typ: gqlT("Float")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Notification", doc: "A chat channel the poll's progress is posted to, its webhook is never shown", fields: []*gqlField{
+		{name: "kind", typ: gqlT("String!"), doc: "slack or discord"},
+		{name: "milestones", typ: gqlT("[Int!]!")},
+		{name: "hourly", typ: gqlT("Boolean!")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "OptionMatching", fields: []*gqlField{
+		{name: "option", typ: gqlT("String!")},
+		{name: "caseSensitive", typ: gqlT("Boolean!")},
+		{name: "exact", typ: gqlT("Boolean!"), doc: "Only counts the option as whole words"},
+		{name: "fuzziness", typ: gqlT("String"), doc: "plural or stem also counts other forms of the option's words"},
+		{name: "language", typ: gqlT("String"), doc: "The language the words are stemmed in, en when empty"},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Embargo", fields: []*gqlField{
+		{name: "until", typ: gqlT("Time")},
+		{name: "quietFrom", typ: gqlT("String"), doc: "The daily quiet hours start, as 22:00"},
+		{name: "quietTo", typ: gqlT("String")},
+		{name: "zone", typ: gqlT("String"), doc: "The quiet hours' time zone, UTC when empty"},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "OptionsVersion", fields: []*gqlField{
+		{name: "version", typ: gqlT("Int!")},
+		{name: "options", typ: gqlT("[String!]!")},
+		{name: "since", typ: gqlT("Time!"), doc: "When they became the poll's options"},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "CreatedPoll", fields: []*gqlField{
+		{name: "poll", typ: gqlT("Poll!")},
+		{name: "warnings", typ: gqlT("[TermIssue!]!"), doc: "Options likely to count more than their votes"},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "TermIssue", fields: []*gqlField{
+		{name: "option", typ: gqlT("String")},
+		{name: "problem", typ: gqlT("String!")},
+		{name: "poll", typ: gqlT("ID"), doc: "The other poll the option overlaps"},
+	}})
+
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "PollInput", fields: []*gqlField{
+		{name: "title", typ: gqlT("String!")},
+		{name: "options", typ: gqlT("[String!]!")},
+		{name: "type", typ: gqlT("String")},
+		{name: "visibility", typ: gqlT("String")},
+		{name: "endsAt", typ: gqlT("Time")},
+		{name: "campaign", typ: gqlT("String")},
+		{name: "tags", typ: gqlT("[String!]")},
+		{name: "account", typ: gqlT("String")},
+		{name: "counting", typ: gqlT("String")},
+		{name: "private", typ: gqlT("Boolean")},
+		{name: "detailedMetrics", typ: gqlT("Boolean")},
+		{name: "minShare", typ: gqlT("Float")},
+		{name: "precision", typ: gqlT("Int")},
+		{name: "hashtagOnly", typ: gqlT("Boolean")},
+		{name: "excludeSuspect", typ: gqlT("Boolean")},
+		{name: "sampleEvery", typ: gqlT("Int")},
+		{name: "maxPerMinute", typ: gqlT("Int")},
+		{name: "timezone", typ: gqlT("String")},
+		{name: "excludeRetweets", typ: gqlT("Boolean")},
+		{name: "excludeQuotes", typ: gqlT("Boolean")},
+		{name: "excludeReplies", typ: gqlT("Boolean")},
+		{name: "embeddedText", typ: gqlT("String")},
+		{name: "folding", typ: gqlT("String")},
+		{name: "filter", typ: gqlT("String")},
+		{name: "priority", typ: gqlT("String")},
+		{name: "locations", typ: gqlT("[[Float!]!]")},
+		{name: "geoAggregation", typ: gqlT("String")},
+		{name: "notifications", typ: gqlT("[NotificationInput!]")},
+		{name: "matching", typ: gqlT("[OptionMatchingInput!]")},
+		{name: "embargo", typ: gqlT("EmbargoInput")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "PollSettingsInput", doc: "The settings that can change after a poll is created", fields: []*gqlField{
+		{name: "visibility", typ: gqlT("String")},
+		{name: "account", typ: gqlT("String")},
+		{name: "counting", typ: gqlT("String")},
+		{name: "private", typ: gqlT("Boolean")},
+		{name: "detailedMetrics", typ: gqlT("Boolean")},
+		{name: "minShare", typ: gqlT("Float")},
+		{name: "precision", typ: gqlT("Int")},
+		{name: "hashtagOnly", typ: gqlT("Boolean")},
+		{name: "excludeSuspect", typ: gqlT("Boolean")},
+		{name: "sampleEvery", typ: gqlT("Int")},
+		{name: "maxPerMinute", typ: gqlT("Int")},
+		{name: "timezone", typ: gqlT("String")},
+		{name: "campaign", typ: gqlT("String"), doc: "Moves the poll to another campaign, empty takes it out of its campaign"},
+		{name: "excludeRetweets", typ: gqlT("Boolean")},
+		{name: "excludeQuotes", typ: gqlT("Boolean")},
+		{name: "excludeReplies", typ: gqlT("Boolean")},
+		{name: "embeddedText", typ: gqlT("String")},
+		{name: "folding", typ: gqlT("String")},
+		{name: "filter", typ: gqlT("String")},
+		{name: "priority", typ: gqlT("String")},
+		{name: "locations", typ: gqlT("[[Float!]!]"), doc: "Replaces the location filters, an empty list removes them"},
+		{name: "geoAggregation", typ: gqlT("String")},
+		{name: "notifications", typ: gqlT("[NotificationInput!]"), doc: "Replaces the chat channels, an empty list removes them"},
+		{name: "matching", typ: gqlT("[OptionMatchingInput!]"), doc: "Replaces how strictly the options are matched"},
+		{name: "embargo", typ: gqlT("EmbargoInput"), doc: "Replaces the embargo, an empty one lifts it"},
+		{name: "options", typ: gqlT("[String!]"), doc: "Replaces the options as their next version, checked as a new poll's are"},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "NotificationInput", fields: []*gqlField{
+		{name: "kind", typ: gqlT("String!")},
+		{name: "url", typ: gqlT("String!"), doc: "The channel's incoming webhook"},
+		{name: "milestones", typ: gqlT("[Int!]")},
+		{name: "hourly", typ: gqlT("Boolean")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "OptionMatchingInput", fields: []*gqlField{
+		{name: "option", typ: gqlT("String!")},
+		{name: "caseSensitive", typ: gqlT("Boolean")},
+		{name: "exact", typ: gqlT("Boolean")},
+		{name: "fuzziness", typ: gqlT("String")},
+		{name: "language", typ: gqlT("String")},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "EmbargoInput", fields: []*gqlField{
+		{name: "until", typ: gqlT("Time")},
+		{name: "quietFrom", typ: gqlT("String")},
+		{name: "quietTo", typ: gqlT("String")},
+		{name: "zone", typ: gqlT("String")},
+	}})
+	return schema
+}
+
+// findPoll loads the poll with the hex ID given
+func (s *Server) findPoll(id string) (*poll, error) {
+	if !bson.IsObjectIdHex(id) {
+		return nil, mgo.ErrNotFound
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	var p poll
+	if err := s.polls(session).FindId(bson.ObjectIdHex(id)).One(&p); err != nil {
+		return nil, err
+	}
+	return &p, nil
+}
+
+// POST /graphql runs a query, a mutation or a subscription, GET a query or a subscription.
+// Subscriptions respond with a next event per result, then a complete event.
+func (s *Server) handleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req gqlRequest
+	switch r.Method {
+	case "GET":
+		q := r.URL.Query()
+		req.Query, req.OperationName = q.Get("query"), q.Get("operationName")
+		if v := q.Get("variables"); v != "" {
+			if err := json.Unmarshal([]byte(v), &req.Variables); err != nil {
+				respond(w, r, http.StatusBadRequest, gqlResponse{Errors: gqlErrors("invalid variables: " + err.Error())})
+				return
+			}
+		}
+	case "POST":
+		if err := decodeBody(r, &req); err != nil {
+			respond(w, r, http.StatusBadRequest, gqlResponse{Errors: gqlErrors("failed to read the request: " + err.Error())})
+			return
+		}
+	case "OPTIONS":
+		w.Header().Add("Access-Control-Allow-Methods", "GET, POST")
+		respond(w, r, http.StatusOK, nil)
+		return
+	default:
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	e, errs := s.graphql.prepare(req)
+	if errs != nil {
+		respond(w, r, http.StatusBadRequest, gqlResponse{Errors: errs})
+		return
+	}
+	switch {
+	case e.op.kind == "mutation" && r.Method != "POST":
+		respond(w, r, http.StatusMethodNotAllowed, gqlResponse{Errors: gqlErrors("mutations need a POST")})
+		return
+	case e.op.kind == "mutation":
+		s.serveMutation(w, r, e)
+		return
+	case e.op.kind == "subscription":
+		s.serveSubscription(w, r, e)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	respond(w, r, http.StatusOK, e.execute(r.Context()))
+}
+
+// serveMutation runs a mutation for poll admins, auditing it like the REST admin actions
+func (s *Server) serveMutation(w http.ResponseWriter, r *http.Request, e *gqlExecution) {
+	p, _ := principalFrom(r.Context())
+	action := "graphql mutation " + strings.Join(e.rootFields(), ",")
+	if !p.can(rolePollAdmin) {
+		s.audit.record(r, p, action, http.StatusForbidden, "")
+		respond(w, r, http.StatusForbidden, gqlResponse{Errors: gqlErrors("mutations need the " + rolePollAdmin + " role")})
+		return
+	}
+	resp := e.execute(r.Context())
+	var errMsg string
+	if len(resp.Errors) > 0 {
+		errMsg = resp.Errors[0].Message
+	}
+	s.audit.record(r, p, action, http.StatusOK, errMsg)
+	w.Header().Set("Content-Type", "application/json")
+	respond(w, r, http.StatusOK, resp)
+}
+
+// serveSubscription streams the results of a subscription as server-sent events
+func (s *Server) serveSubscription(w http.ResponseWriter, r *http.Request, e *gqlExecution) {
+	events, ok := newSSEWriter(w, r)
+	if !ok {
+		return
+	}
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go events.keepAlive(ctx, resultsPing)
+	err := e.subscribe(ctx, func(resp gqlResponse) error {
+		return events.Event("next", resp)
+	})
+	if ctx.Err() != nil {
+		return // the client went away
+	}
+	if err != nil {
+		events.Event("next", gqlResponse{Errors: gqlErrors(err.Error())})
+	}
+	events.Event("complete", nil)
+}
+
+// GET /graphql/schema returns the schema in SDL, for clients generating code from it
+func (s *Server) handleGraphQLSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, s.graphql.sdl())
+}
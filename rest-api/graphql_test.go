@@ -0,0 +1,462 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+func TestParseGraphQL(t *testing.T) {
+	tests := []struct {
+		name  string
+		query string
+		err   string // in the error, none when it parses
+	}{
+		{"a shorthand query", `{ polls { id } }`, ""},
+		{"a named operation with variables", `query Q($id: ID!, $n: Int = 3, $tags: [String!]) { poll(id: $id) { id } }`, ""},
+		{"fragments", `query { ...F } fragment F on Query { polls { ...G } } fragment G on Poll { id }`, ""},
+		{"values", `{ f(i: -1, f: 1.5e3, s: "é\n", b: """a "quoted" block""", t: true, n: null, e: RED, l: [1, 2], o: {a: 1}) }`, ""},
+		{"comments and commas", "{ a, # a comment\n b }", ""},
+		{"an empty document", ``, "the document has no operation"},
+		{"only a fragment", `fragment F on Query { a }`, "the document has no operation"},
+		{"an unclosed selection set", `{ polls { id }`, "the query ends"},
+		{"an unterminated string", `{ f(s: "abc) }`, "unterminated string"},
+		{"a string across lines", "{ f(s: \"a\nb\") }", "unterminated string"},
+		{"an invalid escape", `{ f(s: "\q") }`, `invalid escape \q`},
+		{"an invalid unicode escape", `{ f(s: "\u12") }`, "invalid unicode escape"},
+		{"a number with a leading zero", `{ f(i: 012) }`, "numbers can't start with 0"},
+		{"a number without digits", `{ f(i: -) }`, "invalid number"},
+		{"an unexpected character", `{ f ~ }`, `unexpected character '~'`},
+		{"a fragment named on", `{ a } fragment on on Query { a }`, "a fragment can't be named on"},
+		{"a fragment defined twice", `{ a } fragment F on Query { a } fragment F on Query { b }`, "fragment F is defined twice"},
+		{"a type definition", `type Poll { id: ID }`, "expected an operation or a fragment"},
+		{"the position of the error", "{\n  a(x: ~)\n}", "syntax error at 2:8"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseGraphQL(tt.query)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("parseGraphQL = %v", err)
+			case tt.err != "" && err == nil:
+				t.Errorf("parsed, want an error with %q", tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("parseGraphQL = %v, want %q in it", err, tt.err)
+			}
+		})
+	}
+}
+
+// gqlTestItem is what the test schema's Item type resolves to
+type gqlTestItem struct {
+	ID     string     `json:"id"`
+	Title  string     `json:"title"`
+	Tags   []string   `json:"tags"`
+	EndsAt *time.Time `json:"ends_at"`
+	Score  float64    `json:"score"`
+}
+
+// gqlTestError is a resolver error with extensions
+type gqlTestError struct{}
+
+func (gqlTestError) Error() string { return "rejected" }
+
+func (gqlTestError) extensions() map[string]interface{} {
+	return map[string]interface{}{"code": "REJECTED"}
+}
+
+// testGQLSchema is a schema of its own for the executor, resolving the items
+// from memory rather than from the polls collection
+func testGQLSchema() *gqlSchema {
+	ends := time.Date(2024, 10, 14, 12, 0, 0, 0, time.UTC)
+	items := []*gqlTestItem{
+		{ID: "1", Title: "cats or dogs", Tags: []string{"pets"}, EndsAt: &ends, Score: 1.5},
+		{ID: "2", Title: "tea or coffee"},
+		{ID: "3", Title: "left or right"},
+	}
+	schema := newGQLSchema()
+	schema.query, schema.mutation, schema.subscription = "Query", "Mutation", "Subscription"
+	schema.add(&gqlTypeDef{kind: gqlScalarType, name: "Time"})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Query", fields: []*gqlField{
+		{name: "item", typ: gqlT("Item"), args: []*gqlField{{name: "id", typ: gqlT("ID!")}},
+			resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				for _, it := range items {
+					if it.ID == args["id"] {
+						return it, nil
+					}
+				}
+				return nil, nil
+			}},
+		{name: "items", typ: gqlT("[Item!]!"), args: []*gqlField{{name: "first", typ: gqlT("Int")}},
+			resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				if n, ok := args["first"].(int); ok && n < len(items) {
+					return items[:n], nil
+				}
+				return items, nil
+			}},
+		{name: "echo", typ: gqlT("String!"), args: []*gqlField{{name: "input", typ: gqlT("ItemInput!")}},
+			resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				var it gqlTestItem
+				if err := decodeInput(args["input"].(map[string]interface{}), &it); err != nil {
+					return nil, err
+				}
+				b, err := json.Marshal(it)
+				return string(b), err
+			}},
+		{name: "broken", typ: gqlT("String"),
+			resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return nil, errors.New("the database is down")
+			}},
+		{name: "required", typ: gqlT("Item!"),
+			resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return nil, nil
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Mutation", fields: []*gqlField{
+		{name: "rename", typ: gqlT("Item!"), args: []*gqlField{{name: "id", typ: gqlT("ID!")}, {name: "title", typ: gqlT("String!")}},
+			resolve: func(_ context.Context, _ interface{}, args map[string]interface{}) (interface{}, error) {
+				return &gqlTestItem{ID: args["id"].(string), Title: args["title"].(string)}, nil
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Subscription", fields: []*gqlField{
+		{name: "ticks", typ: gqlT("Item!"), args: []*gqlField{{name: "n", typ: gqlT("Int!")}},
+			subscribe: func(_ context.Context, args map[string]interface{}, send func(interface{}) error) error {
+				for i := 1; i <= args["n"].(int); i++ {
+					if err := send(items[i-1]); err != nil {
+						return err
+					}
+				}
+				return nil
+			}},
+		{name: "other", typ: gqlT("Item!"),
+			subscribe: func(context.Context, map[string]interface{}, func(interface{}) error) error { return nil }},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlObjectType, name: "Item", fields: []*gqlField{
+		{name: "id", typ: gqlT("ID!")},
+		{name: "title", typ: gqlT("String!")},
+		{name: "tags", typ: gqlT("[String!]!")},
+		{name: "endsAt", typ: gqlT("Time")},
+		{name: "score", typ: gqlT("Float!")},
+		{name: "rejected", typ: gqlT("String"),
+			resolve: func(context.Context, interface{}, map[string]interface{}) (interface{}, error) {
+				return nil, gqlTestError{}
+			}},
+		{name: "next", typ: gqlT("Item"),
+			resolve: func(_ context.Context, source interface{}, _ map[string]interface{}) (interface{}, error) {
+				if source.(*gqlTestItem).ID == "1" {
+					return items[1], nil
+				}
+				return nil, nil
+			}},
+	}})
+	schema.add(&gqlTypeDef{kind: gqlInputType, name: "ItemInput", fields: []*gqlField{
+		{name: "title", typ: gqlT("String!")},
+		{name: "tags", typ: gqlT("[String!]")},
+		{name: "endsAt", typ: gqlT("Time")},
+	}})
+	return schema
+}
+
+// gqlJSON is what a response is sent as
+func gqlJSON(t *testing.T, v interface{}) string {
+	t.Helper()
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+func TestGraphQLPrepare(t *testing.T) {
+	tests := []struct {
+		name string
+		req  gqlRequest
+		err  string // the first error, none when the operation is ready to run
+	}{
+		{"a valid query", gqlRequest{Query: `{ items { id } }`}, ""},
+		{"no query", gqlRequest{Query: "  "}, "the request has no query"},
+		{"a syntax error", gqlRequest{Query: `{ items {`}, "syntax error"},
+		{"several operations without a name", gqlRequest{Query: `query A { items { id } } query B { items { id } }`}, "operationName picks one"},
+		{"the operation named", gqlRequest{Query: `query A { items { id } } query B { items { id } }`, OperationName: "B"}, ""},
+		{"an operation named that isn't there", gqlRequest{Query: `query A { items { id } }`, OperationName: "C"}, "there is no operation named C"},
+		{"a field the type hasn't", gqlRequest{Query: `{ items { votes } }`}, "Item has no field votes"},
+		{"an object without selections", gqlRequest{Query: `{ items }`}, "select some of its fields"},
+		{"a scalar with selections", gqlRequest{Query: `{ items { title { x } } }`}, "title is a String! and has no fields"},
+		{"__typename with selections", gqlRequest{Query: `{ __typename { x } }`}, "__typename is a String and has no fields"},
+		{"an argument the field hasn't", gqlRequest{Query: `{ items(last: 1) { id } }`}, "items has no argument last"},
+		{"a required argument missing", gqlRequest{Query: `{ item { id } }`}, "argument id: expected ID!, got null"},
+		{"an argument of the wrong type", gqlRequest{Query: `{ items(first: "two") { id } }`}, `argument first: expected Int, got "two"`},
+		{"a float for an Int", gqlRequest{Query: `{ items(first: 1.0) { id } }`}, "expected Int, got 1.0"},
+		{"an enum the schema hasn't", gqlRequest{Query: `{ items(first: TWO) { id } }`}, "expected Int, got TWO"},
+		{"an input object field it hasn't", gqlRequest{Query: `{ echo(input: {title: "a", votes: 1}) }`}, "ItemInput has no field votes"},
+		{"an input object field missing", gqlRequest{Query: `{ echo(input: {tags: ["a"]}) }`}, "title: expected String!, got null"},
+		{"an invalid time", gqlRequest{Query: `{ echo(input: {title: "a", endsAt: "tomorrow"}) }`}, "expected an RFC 3339 time"},
+		{"an undeclared variable", gqlRequest{Query: `{ item(id: $id) { id } }`}, "variable $id isn't declared"},
+		{"an undeclared variable in a list", gqlRequest{Query: `{ echo(input: {title: "a", tags: [$tag]}) }`}, "variable $tag isn't declared"},
+		{"a variable of an object type", gqlRequest{Query: `query($i: Item) { items { id } }`}, "variable $i: Item isn't an input type"},
+		{"a variable of a type the schema hasn't", gqlRequest{Query: `query($i: Nope) { items { id } }`}, "variable $i: Nope isn't an input type"},
+		{"a required variable missing", gqlRequest{Query: `query($id: ID!) { item(id: $id) { id } }`}, "variable $id: expected ID!, got null"},
+		{"a variable of the wrong type", gqlRequest{Query: `query($n: Int) { items(first: $n) { id } }`, Variables: map[string]interface{}{"n": "two"}}, `variable $n: expected Int, got "two"`},
+		{"a variable's default of the wrong type", gqlRequest{Query: `query($n: Int = "two") { items(first: $n) { id } }`}, `variable $n: expected Int, got "two"`},
+		{"an unknown fragment", gqlRequest{Query: `{ ...F }`}, "unknown fragment F"},
+		{"a fragment spreading itself", gqlRequest{Query: `{ items { ...F } } fragment F on Item { id ...F }`}, "fragment F spreads itself"},
+		{"fragments spreading each other", gqlRequest{Query: `{ items { ...F } } fragment F on Item { ...G } fragment G on Item { id ...F }`}, "fragment F spreads itself"},
+		{"a fragment spread twice side by side", gqlRequest{Query: `{ items { ...F ...F } } fragment F on Item { id }`}, ""},
+		{"a fragment on another type", gqlRequest{Query: `{ items { ...F } } fragment F on Query { items { id } }`}, "fragment F on Query can't be spread on Item"},
+		{"an inline fragment on another type", gqlRequest{Query: `{ items { ... on Query { items { id } } } }`}, "a fragment on Query can't be spread on Item"},
+		{"an unknown directive", gqlRequest{Query: `{ items @cached { id } }`}, "unknown directive @cached"},
+		{"a directive without its if", gqlRequest{Query: `{ items @skip { id } }`}, "@skip(if:): expected Boolean!, got null"},
+		{"a mutation", gqlRequest{Query: `mutation { rename(id: "1", title: "a") { title } }`}, ""},
+		{"a subscription of one field", gqlRequest{Query: `subscription { ticks(n: 1) { id } }`}, ""},
+		{"a subscription of two fields", gqlRequest{Query: `subscription { ticks(n: 1) { id } other { id } }`}, "a subscription selects exactly one field"},
+		{"a subscription of __typename", gqlRequest{Query: `subscription { __typename }`}, "a subscription selects exactly one field"},
+	}
+	schema := testGQLSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, errs := schema.prepare(tt.req)
+			switch {
+			case tt.err == "" && errs != nil:
+				t.Errorf("prepare = %s", gqlJSON(t, errs))
+			case tt.err != "" && errs == nil:
+				t.Errorf("prepared %s, want an error with %q", e.op.kind, tt.err)
+			case tt.err != "" && !strings.Contains(errs[0].Message, tt.err):
+				t.Errorf("prepare = %s, want %q in the first error", gqlJSON(t, errs), tt.err)
+			}
+		})
+	}
+
+	t.Run("a schema without mutations", func(t *testing.T) {
+		queries := testGQLSchema()
+		queries.mutation = ""
+		if _, errs := queries.prepare(gqlRequest{Query: `mutation { rename(id: "1", title: "a") { id } }`}); errs == nil || errs[0].Message != "the schema has no mutation type" {
+			t.Errorf("prepare = %v", errs)
+		}
+	})
+}
+
+func TestGraphQLExecute(t *testing.T) {
+	tests := []struct {
+		name string
+		req  gqlRequest
+		want string
+	}{
+		{"fields resolved from the struct",
+			gqlRequest{Query: `{ item(id: "1") { id title tags endsAt score } }`},
+			`{"data":{"item":{"id":"1","title":"cats or dogs","tags":["pets"],"endsAt":"2024-10-14T12:00:00Z","score":1.5}}}`},
+		{"a null object and nil lists",
+			gqlRequest{Query: `{ none: item(id: "9") { id } two: item(id: "2") { tags endsAt } }`},
+			`{"data":{"none":null,"two":{"tags":[],"endsAt":null}}}`},
+		{"aliases in the order selected",
+			gqlRequest{Query: `{ b: item(id: "2") { t: title } a: item(id: "1") { t: title } }`},
+			`{"data":{"b":{"t":"tea or coffee"},"a":{"t":"cats or dogs"}}}`},
+		{"the same field selected twice is merged",
+			gqlRequest{Query: `{ item(id: "1") { id } item(id: "1") { title } }`},
+			`{"data":{"item":{"id":"1","title":"cats or dogs"}}}`},
+		{"a list with an argument",
+			gqlRequest{Query: `{ items(first: 2) { id } }`},
+			`{"data":{"items":[{"id":"1"},{"id":"2"}]}}`},
+		{"nested objects",
+			gqlRequest{Query: `{ items(first: 2) { id next { id next { id } } } }`},
+			`{"data":{"items":[{"id":"1","next":{"id":"2","next":null}},{"id":"2","next":null}]}}`},
+		{"fragments and inline fragments",
+			gqlRequest{Query: `{ item(id: "1") { ...Names ... on Item { score } ... { id } } } fragment Names on Item { title }`},
+			`{"data":{"item":{"title":"cats or dogs","score":1.5,"id":"1"}}}`},
+		{"__typename",
+			gqlRequest{Query: `{ __typename item(id: "1") { __typename } }`},
+			`{"data":{"__typename":"Query","item":{"__typename":"Item"}}}`},
+		{"variables",
+			gqlRequest{Query: `query($id: ID!, $n: Int) { item(id: $id) { id } items(first: $n) { id } }`, Variables: map[string]interface{}{"id": "2", "n": 1.0}},
+			`{"data":{"item":{"id":"2"},"items":[{"id":"1"}]}}`},
+		{"a variable's default",
+			gqlRequest{Query: `query($n: Int = 1) { items(first: $n) { id } }`},
+			`{"data":{"items":[{"id":"1"}]}}`},
+		{"a variable not given leaves the argument out",
+			gqlRequest{Query: `query($n: Int) { items(first: $n) { id } }`},
+			`{"data":{"items":[{"id":"1"},{"id":"2"},{"id":"3"}]}}`},
+		{"a numeric ID",
+			gqlRequest{Query: `{ item(id: 3) { title } }`},
+			`{"data":{"item":{"title":"left or right"}}}`},
+		{"@skip and @include",
+			gqlRequest{Query: `query($yes: Boolean!) { item(id: "1") { id @skip(if: $yes) title @include(if: $yes) score @include(if: false) } }`, Variables: map[string]interface{}{"yes": true}},
+			`{"data":{"item":{"title":"cats or dogs"}}}`},
+		{"an input object",
+			gqlRequest{Query: `{ echo(input: {title: "a", tags: "solo", endsAt: "2024-10-14T12:00:00+02:00"}) }`},
+			`{"data":{"echo":"{\"id\":\"\",\"title\":\"a\",\"tags\":[\"solo\"],\"ends_at\":\"2024-10-14T12:00:00+02:00\",\"score\":0}"}}`},
+		{"an input object in a variable",
+			gqlRequest{Query: `query($in: ItemInput!) { echo(input: $in) }`, Variables: map[string]interface{}{"in": map[string]interface{}{"title": "b"}}},
+			`{"data":{"echo":"{\"id\":\"\",\"title\":\"b\",\"tags\":null,\"ends_at\":null,\"score\":0}"}}`},
+		{"a failing nullable field is null with an error",
+			gqlRequest{Query: `{ broken item(id: "2") { id } }`},
+			`{"data":{"broken":null,"item":{"id":"2"}},"errors":[{"message":"the database is down","path":["broken"]}]}`},
+		{"a resolver error's extensions",
+			gqlRequest{Query: `{ item(id: "1") { rejected } }`},
+			`{"data":{"item":{"rejected":null}},"errors":[{"message":"rejected","path":["item","rejected"],"extensions":{"code":"REJECTED"}}]}`},
+		{"a null non-null field nulls the data",
+			gqlRequest{Query: `{ item(id: "1") { id } required { id } }`},
+			`{"errors":[{"message":"a Item! can't be null","path":["required"]}]}`},
+	}
+	schema := testGQLSchema()
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e, errs := schema.prepare(tt.req)
+			if errs != nil {
+				t.Fatalf("prepare = %s", gqlJSON(t, errs))
+			}
+			if got := gqlJSON(t, e.execute(context.Background())); got != tt.want {
+				t.Errorf("execute = %s\n want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestGraphQLSubscribe(t *testing.T) {
+	schema := testGQLSchema()
+	e, errs := schema.prepare(gqlRequest{Query: `subscription($n: Int!) { tick: ticks(n: $n) { id rejected } }`, Variables: map[string]interface{}{"n": 2.0}})
+	if errs != nil {
+		t.Fatalf("prepare = %s", gqlJSON(t, errs))
+	}
+	var got []string
+	err := e.subscribe(context.Background(), func(resp gqlResponse) error {
+		got = append(got, gqlJSON(t, resp))
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// every event is executed again, its errors its own
+	want := []string{
+		`{"data":{"tick":{"id":"1","rejected":null}},"errors":[{"message":"rejected","path":["tick","rejected"],"extensions":{"code":"REJECTED"}}]}`,
+		`{"data":{"tick":{"id":"2","rejected":null}},"errors":[{"message":"rejected","path":["tick","rejected"],"extensions":{"code":"REJECTED"}}]}`,
+	}
+	if strings.Join(got, "\n") != strings.Join(want, "\n") {
+		t.Errorf("events\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	t.Run("a send failing stops it", func(t *testing.T) {
+		e, _ := schema.prepare(gqlRequest{Query: `subscription { ticks(n: 3) { id } }`})
+		gone := errors.New("the client went away")
+		var sent int
+		err := e.subscribe(context.Background(), func(gqlResponse) error {
+			sent++
+			return gone
+		})
+		if err != gone || sent != 1 {
+			t.Errorf("subscribe = %v after %d events, want %v after 1", err, sent, gone)
+		}
+	})
+}
+
+func TestGraphQLResultsSubscription(t *testing.T) {
+	db := &fakePolls{p: poll{Options: []string{"cats", "dogs"}, Results: map[string]int{"cats": 3, "dogs": 1}}}
+	s := &Server{watchers: newPollWatchers(5*time.Millisecond, db.load)}
+	schema := s.graphQLSchema()
+	subscribe := func(id string) (*gqlExecution, []*gqlError) {
+		return schema.prepare(gqlRequest{
+			Query:     `subscription($id: ID!) { results(id: $id) { total options { option votes share } } }`,
+			Variables: map[string]interface{}{"id": id},
+		})
+	}
+
+	e, errs := subscribe(bson.NewObjectId().Hex())
+	if errs != nil {
+		t.Fatalf("prepare = %s", gqlJSON(t, errs))
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	events := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		done <- e.subscribe(ctx, func(resp gqlResponse) error {
+			events <- gqlJSON(t, resp)
+			return nil
+		})
+	}()
+	want := []string{
+		`{"data":{"results":{"total":4,"options":[{"option":"cats","votes":3,"share":75},{"option":"dogs","votes":1,"share":25}]}}}`,
+		`{"data":{"results":{"total":5,"options":[{"option":"cats","votes":4,"share":80},{"option":"dogs","votes":1,"share":20}]}}}`,
+	}
+	if got := <-events; got != want[0] {
+		t.Errorf("first event %s, want %s", got, want[0])
+	}
+	db.set(poll{Options: []string{"cats", "dogs"}, Results: map[string]int{"cats": 4, "dogs": 1}}, nil)
+	if got := <-events; got != want[1] {
+		t.Errorf("after a vote %s, want %s", got, want[1])
+	}
+	cancel()
+	if err := <-done; err != context.Canceled {
+		t.Errorf("subscribe = %v once the client left", err)
+	}
+
+	t.Run("a poll that isn't there", func(t *testing.T) {
+		db.set(poll{}, mgo.ErrNotFound)
+		for _, id := range []string{"nope", bson.NewObjectId().Hex()} {
+			e, errs := subscribe(id)
+			if errs != nil {
+				t.Fatalf("prepare = %s", gqlJSON(t, errs))
+			}
+			err := e.subscribe(context.Background(), func(gqlResponse) error { return nil })
+			if err == nil || !strings.Contains(err.Error(), "not found") {
+				t.Errorf("subscribe to %s = %v", id, err)
+			}
+		}
+	})
+}
+
+func TestGraphQLMutationsNeedAPollAdmin(t *testing.T) {
+	tests := []struct {
+		name   string
+		role   string
+		method string
+		status int
+	}{
+		{"a viewer", roleViewer, "POST", http.StatusForbidden},
+		{"nobody authenticated", "", "POST", http.StatusForbidden},
+		{"a poll admin", rolePollAdmin, "POST", http.StatusOK},
+		{"an operator", roleOperator, "POST", http.StatusOK},
+		{"a poll admin with a GET", rolePollAdmin, "GET", http.StatusMethodNotAllowed},
+	}
+	mutation := `mutation { rename(id: "1", title: "birds") { title } }`
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var audit bytes.Buffer
+			s := &Server{graphql: testGQLSchema(), audit: &auditLog{enc: json.NewEncoder(&audit)}}
+			var r *http.Request
+			if tt.method == "GET" {
+				r = httptest.NewRequest("GET", "/graphql?query="+strings.Replace(mutation, " ", "+", -1), nil)
+			} else {
+				body, _ := json.Marshal(gqlRequest{Query: mutation})
+				r = httptest.NewRequest("POST", "/graphql", bytes.NewReader(body))
+			}
+			if tt.role != "" {
+				r = r.WithContext(context.WithValue(r.Context(), contextKeyPrincipal, principal{Name: "ci", Role: tt.role, Auth: "api_key"}))
+			}
+			w := httptest.NewRecorder()
+			s.handleGraphQL(w, r)
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			renamed := strings.Contains(w.Body.String(), `"title":"birds"`)
+			if ok := tt.status == http.StatusOK; renamed != ok {
+				t.Errorf("ran the mutation: %v, want %v: %s", renamed, ok, w.Body)
+			}
+			if tt.method == "POST" {
+				var entry auditEntry
+				if err := json.Unmarshal(audit.Bytes(), &entry); err != nil {
+					t.Fatalf("no audit entry: %v", err)
+				}
+				if entry.Action != "graphql mutation rename" || entry.Status != tt.status {
+					t.Errorf("audited %q with %d, want the mutation with %d", entry.Action, entry.Status, tt.status)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The dashboard shows whether the pipeline feeding the polls is healthy.
+// Rather than have browsers reach the tweetreader and counter directly,
+// the API reads their Prometheus metrics and hands over the few numbers that matter.
+
+// metricsClient fetches the metrics, a stuck pipeline must not hang the dashboard
+var metricsClient = &http.Client{Timeout: 2 * time.Second}
+
+// componentHealth is what the dashboard shows for one part of the pipeline
+type componentHealth struct {
+	Up      bool               `json:"up"`
+	Error   string             `json:"error,omitempty"`
+	Metrics map[string]float64 `json:"metrics,omitempty"`
+}
+
+// streamMetrics and counterMetrics are the metrics passed on to the dashboard
+var (
+	streamMetrics = []string{
+		"tweetreader_options_age_seconds",
+		"tweetreader_options_tracked",
+		"tweetreader_options_stale_total",
+		"tweetreader_options_load_errors_total",
+		"tweetreader_control_sampled_out_total",
+		"tweetreader_degradation_level",
+	}
+	counterMetrics = []string{
+		"twitterpoll_votes_total",
+		"twitterpoll_weighted_votes_total",
+		"twitterpoll_duplicate_votes_total",
+	}
+)
+
+// GET /health/stream reports the health of the tweetreader stream and the counter
+func (s *Server) handleStreamHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "GET" {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	respond(w, r, http.StatusOK, map[string]componentHealth{
+		"stream":  scrape(s.streamMetricsURL, streamMetrics),
+		"counter": scrape(s.counterMetricsURL, counterMetrics),
+	})
+}
+
+// scrape reads the named metrics from a Prometheus text endpoint, summing labelled series
+func scrape(url string, names []string) componentHealth {
+	if url == "" {
+		return componentHealth{Error: "not configured"}
+	}
+	resp, err := metricsClient.Get(url)
+	if err != nil {
+		return componentHealth{Error: err.Error()}
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return componentHealth{Error: resp.Status}
+	}
+	want := make(map[string]bool, len(names))
+	for _, name := range names {
+		want[name] = true
+	}
+	h := componentHealth{Up: true, Metrics: make(map[string]float64)}
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		i := strings.LastIndex(line, " ")
+		if i < 0 {
+			continue
+		}
+		name := line[:i]
+		if j := strings.Index(name, "{"); j >= 0 {
+			name = name[:j]
+		}
+		if !want[name] {
+			continue
+		}
+		if v, err := strconv.ParseFloat(line[i+1:], 64); err == nil {
+			h.Metrics[name] += v
+		}
+	}
+	return h
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The counter keeps every poll's results in a Redis sorted set, when it runs
+// with -leaderboard-redis, so busy result pages read the top options from
+// there and not from MongoDB. A poll without a set, or Redis failing, is
+// answered from the poll's results.
+
+// defaultLeaderboardLimit and maxLeaderboardLimit bound ?limit=
+const (
+	defaultLeaderboardLimit = 10
+	maxLeaderboardLimit     = 100
+)
+
+// leaderboardBackoff is how long Redis isn't asked after it failed
+const leaderboardBackoff = 5 * time.Second
+
+// leaderboardResponse is the top options of a poll, with where they came from
+type leaderboardResponse struct {
+	Poll        string     `json:"poll"`
+	Leaderboard []standing `json:"leaderboard"`
+	Source      string     `json:"source"` // redis or mongo
+}
+
+// leaderboards reads the sets the counter keeps, over one connection
+type leaderboards struct {
+	addr, user, password string
+	db                   int
+	prefix               string
+	timeout              time.Duration
+
+	mu     sync.Mutex
+	conn   net.Conn // nil until dialled, and after a failure
+	r      *bufio.Reader
+	failed time.Time // when Redis last failed
+}
+
+// openLeaderboards reads the sets under prefix in the Redis at rawURL,
+// redis://[user:password@]host[:port][/db]; nil when rawURL is empty
+func openLeaderboards(rawURL, prefix string) (*leaderboards, error) {
+	if rawURL == "" {
+		return nil, nil
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("invalid redis URL %q, want redis://host:port/db", rawURL)
+	}
+	l := &leaderboards{addr: u.Host, prefix: prefix, timeout: 500 * time.Millisecond}
+	if u.Port() == "" {
+		l.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		l.user = u.User.Username()
+		l.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if l.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("invalid redis database %q", db)
+		}
+	}
+	return l, nil
+}
+
+// top returns the limit options of poll with the most votes, none when the
+// counter keeps no set for it
+func (l *leaderboards) top(poll string, limit int) ([]standing, error) {
+	reply, err := l.do("ZREVRANGE", l.prefix+poll, "0", strconv.Itoa(limit-1), "WITHSCORES")
+	if err != nil {
+		return nil, err
+	}
+	list, _ := reply.([]interface{})
+	ranking := make([]standing, 0, len(list)/2)
+	for i := 0; i+1 < len(list); i += 2 {
+		option, _ := list[i].(string)
+		score, _ := list[i+1].(string)
+		votes, err := strconv.ParseFloat(score, 64)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad score %q", score)
+		}
+		ranking = append(ranking, standing{Option: option, Votes: int(votes)})
+	}
+	return ranking, nil
+}
+
+// errBackingOff is returned while Redis failed less than leaderboardBackoff ago
+var errBackingOff = errors.New("redis failed lately, not asking")
+
+// do sends a command and returns its reply; a failure closes the connection,
+// and Redis isn't asked again for leaderboardBackoff
+func (l *leaderboards) do(args ...string) (interface{}, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if time.Since(l.failed) < leaderboardBackoff {
+		return nil, errBackingOff
+	}
+	reply, err := l.command(args...)
+	if err != nil {
+		if l.conn != nil {
+			l.conn.Close()
+			l.conn = nil
+		}
+		l.failed = time.Now()
+	}
+	return reply, err
+}
+
+// command dials when there is no connection, then writes the command and
+// reads its reply; mu must be held
+func (l *leaderboards) command(args ...string) (interface{}, error) {
+	if l.conn == nil {
+		conn, err := net.DialTimeout("tcp", l.addr, l.timeout)
+		if err != nil {
+			return nil, err
+		}
+		l.conn, l.r = conn, bufio.NewReader(conn)
+		if l.password != "" {
+			auth := []string{"AUTH", l.password}
+			if l.user != "" {
+				auth = []string{"AUTH", l.user, l.password}
+			}
+			if _, err := l.roundTrip(auth); err != nil {
+				return nil, err
+			}
+		}
+		if l.db != 0 {
+			if _, err := l.roundTrip([]string{"SELECT", strconv.Itoa(l.db)}); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return l.roundTrip(args)
+}
+
+// roundTrip writes args as an array of bulk strings and reads the reply
+func (l *leaderboards) roundTrip(args []string) (interface{}, error) {
+	if err := l.conn.SetDeadline(time.Now().Add(l.timeout)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, a := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(a)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, a...)
+		b = append(b, "\r\n"...)
+	}
+	if _, err := l.conn.Write(b); err != nil {
+		return nil, err
+	}
+	return l.reply()
+}
+
+// reply reads a reply: a string, nil or an array of replies
+func (l *leaderboards) reply() (interface{}, error) {
+	line, err := l.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+', ':', ',':
+		return rest, nil
+	case '-':
+		return nil, errors.New("redis: " + rest)
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(l.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		list := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := l.reply()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
+
+// GET /polls/{id}/leaderboard returns the ?limit= options with the most votes,
+// 10 by default, from Redis when the counter keeps the poll's set there and
+// else from the poll's results
+func (s *Server) handlePollLeaderboard(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	limit := defaultLeaderboardLimit
+	if v := r.URL.Query().Get("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > maxLeaderboardLimit {
+			respondErr(w, r, http.StatusBadRequest, fmt.Sprintf("limit must be between 1 and %d", maxLeaderboardLimit))
+			return
+		}
+		limit = n
+	}
+	if s.leaderboards != nil {
+		ranking, err := s.leaderboards.top(id, limit)
+		switch {
+		case err == nil && len(ranking) > 0:
+			respond(w, r, http.StatusOK, leaderboardResponse{Poll: id, Leaderboard: ranking, Source: "redis"})
+			return
+		case err != nil && err != errBackingOff:
+			log.Println("failed to read the leaderboard of poll", id, "from redis:", err)
+		}
+	}
+
+	session := s.db.Copy()
+	defer session.Close()
+	var p poll
+	if err := s.polls(session).FindId(bson.ObjectIdHex(id)).One(&p); err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	ranking := rank(p.Results, p.Options)
+	if len(ranking) > limit {
+		ranking = ranking[:limit]
+	}
+	respond(w, r, http.StatusOK, leaderboardResponse{Poll: id, Leaderboard: ranking, Source: "mongo"})
+}
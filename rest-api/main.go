@@ -1,11 +1,12 @@
 package main
 
 import (
-	"context"
 	"flag"
 	"log"
 	"net/http"
+	"time"
 
+	"github.com/nsqio/go-nsq"
 	"gopkg.in/mgo.v2"
 )
 
@@ -23,64 +24,164 @@ type contextKey struct {
 
 // Server is the API server
 type Server struct {
-	db *mgo.Session
-}
+	db     *mgo.Session
+	events *nsq.Producer // publishes poll changes, may be nil
 
-// Key to store API key value in
-var contextKeyAPIKey = &contextKey{"api-key"}
+	// the database the polls and their results are kept in, and the polls collection
+	database        string
+	pollsCollection string
 
-// APIKey is an helper funtion to extract the key, given a context
-func APIKey(ctx context.Context) (string, bool) {
-	key, ok := ctx.Value(contextKeyAPIKey).(string)
-	return key, ok
-}
+	// where the dashboard's pipeline health comes from, either may be empty
+	streamMetricsURL  string
+	counterMetricsURL string
 
-func withAPIKey(fn http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		key := r.URL.Query().Get("key")
-
-		// check if api key is valid
-		if !isValidAPIKey(key) {
-			respondErr(w, r, http.StatusUnauthorized, "invalid API key")
-			return
-		}
-		ctx := context.WithValue(r.Context(), contextKeyAPIKey, key)
-		fn(w, r.WithContext(ctx))
-	}
-}
+	graphql *gqlSchema
+
+	// the polls' leaderboards the counter keeps in Redis, may be nil
+	leaderboards *leaderboards
+
+	// the sites that may put the embed widget in a frame, as CSP frame-ancestors
+	embedOrigins string
+
+	auth  *authenticator
+	audit *auditLog
+
+	// requests a client address and an API key or token subject may make, either may be nil
+	perIP          *limiter
+	perKey         *limiter
+	trustForwarded bool
 
-func isValidAPIKey(key string) bool {
-	return key == "abc123ABC"
+	// the results responses rendered lately, see cache.go
+	results *resultsCache
+	// the polls being streamed, see watch.go
+	watchers *pollWatchers
 }
 
 func withCORS(fn http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Access-Control-Allow-Origin", "*")
-		w.Header().Set("Access-Control-Expose-Headers", "Location")
+		w.Header().Set("Access-Control-Expose-Headers", "Location, Retry-After")
+		w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type, X-API-Key")
 		fn(w, r)
 	}
 }
 
+// polls returns the polls collection on session
+func (s *Server) polls(session *mgo.Session) *mgo.Collection {
+	return session.DB(s.database).C(s.pollsCollection)
+}
+
 func main() {
 	// specify command line flags
 	var (
-		addr  = flag.String("addr", ":8080", "endpoint address")
-		mongo = flag.String("mongo", "localhost", "mongodb address")
+		addr   = flag.String("addr", ":8080", "endpoint address")
+		mongo  = flag.String("mongo", "localhost", "mongodb address, or a mongodb:// URI with credentials and replicaSet")
+		dbName = flag.String("mongo-database", "ballots", "database the polls and their results are kept in")
+		pollsC = flag.String("polls-collection", "polls", "collection of the polls, e.g. a tenant's")
+		nsqd   = flag.String("nsqd", "localhost:4150", "nsqd address for poll events (empty to disable)")
+
+		boardURL    = flag.String("leaderboard-redis", "", "Redis the counter keeps the polls' leaderboards in, as redis://host:6379/0 (empty to read them from mongo)")
+		boardPrefix = flag.String("leaderboard-prefix", "tweetreader:leaderboard:", "prefix of the leaderboards' keys, the counter's LEADERBOARD_REDIS_PREFIX")
+
+		// connections are encrypted when a CA or client certificate is given,
+		// MongoDB's also with ssl=true in its URI
+		mongoTLS  tlsFiles
+		nsqdTLS   tlsFiles
+		nsqdTLSOn = flag.Bool("nsqd-tls", false, "connect to nsqd with TLS, trusting the system roots unless -nsqd-tls-ca is set")
+
+		streamMetrics  = flag.String("stream-metrics", "http://localhost:8082/metrics", "tweetreader metrics URL shown on the dashboard (empty to disable)")
+		counterMetrics = flag.String("counter-metrics", "http://localhost:9102/metrics", "counter metrics URL shown on the dashboard (empty to disable)")
+		embedOrigins   = flag.String("embed-origins", "*", "space separated sites that may frame the embed widget, e.g. https://news.example.com ('self' for this API's pages only)")
+
+		// without API keys or a JWT key, and JWT_SECRET isn't one, only the development key is accepted, read-only unless -dev-key-role
+		auth     authConfig
+		auditLog = flag.String("audit-log", "", "file the admin actions are appended to (default stderr)")
+
+		// a rate of 0 turns limiting off
+		ipRate         = flag.Float64("ip-rate", 10, "requests a second a client address may make")
+		ipBurst        = flag.Int("ip-burst", 20, "requests a client address may make at once")
+		keyRate        = flag.Float64("key-rate", 50, "requests a second an API key or token subject may make")
+		keyBurst       = flag.Int("key-burst", 100, "requests an API key or token subject may make at once")
+		trustForwarded = flag.Bool("trust-forwarded", false, "limit by the address in X-Forwarded-For, set behind a proxy")
+
+		resultsTTL = flag.Duration("results-cache-ttl", 2*time.Second, "how long a poll's results and widget responses are served from memory instead of read again (0 to read them every time)")
 	)
+	flag.StringVar(&auth.keysFile, "api-keys", "", "file of API keys, a line per key with its name, role (viewer, poll-admin or operator) and the key")
+	flag.StringVar(&auth.jwtPublicKey, "jwt-public-key", "", "PEM file with the RSA public key RS256 tokens are checked with, HS256 ones use JWT_SECRET")
+	flag.StringVar(&auth.jwtIssuer, "jwt-issuer", "", "issuer tokens must have (iss)")
+	flag.StringVar(&auth.jwtAudience, "jwt-audience", "", "audience tokens must have (aud)")
+	flag.StringVar(&auth.devRole, "dev-key-role", roleViewer, "role of the development key "+devAPIKey+", accepted only without API keys or JWT keys: viewer, poll-admin or operator")
+	flag.StringVar(&mongoTLS.ca, "mongo-tls-ca", "", "CA certificate the MongoDB servers are checked against")
+	flag.StringVar(&mongoTLS.cert, "mongo-tls-cert", "", "client certificate presented to MongoDB")
+	flag.StringVar(&mongoTLS.key, "mongo-tls-key", "", "key of -mongo-tls-cert")
+	flag.StringVar(&nsqdTLS.ca, "nsqd-tls-ca", "", "CA certificate nsqd is checked against")
+	flag.StringVar(&nsqdTLS.cert, "nsqd-tls-cert", "", "client certificate presented to nsqd")
+	flag.StringVar(&nsqdTLS.key, "nsqd-tls-key", "", "key of -nsqd-tls-cert")
+	flag.Parse()
 
-	log.Println("Dialing mongo", *mongo)
-	db, err := mgo.Dial(*mongo)
+	db, err := dialMongo(*mongo, mongoTLS)
 	if err != nil {
 		log.Fatalln("Failed to connect to mongo:", err)
 	}
 	defer db.Close()
+	nsqdTLSConfig, err := nsqdTLS.config(*nsqdTLSOn)
+	if err != nil {
+		log.Fatalln("Invalid nsqd TLS:", err)
+	}
+	authenticator, err := newAuthenticator(auth)
+	if err != nil {
+		log.Fatalln("Invalid authentication:", err)
+	}
+	audit, err := openAuditLog(*auditLog)
+	if err != nil {
+		log.Fatalln("Failed to open the audit log:", err)
+	}
+	defer audit.Close()
+	boards, err := openLeaderboards(*boardURL, *boardPrefix)
+	if err != nil {
+		log.Fatalln("Invalid -leaderboard-redis:", err)
+	}
 	s := &Server{
-		db: db,
+		db:     db,
+		events: connectEvents(*nsqd, nsqdTLSConfig),
+
+		database:        *dbName,
+		pollsCollection: *pollsC,
+
+		streamMetricsURL:  *streamMetrics,
+		counterMetricsURL: *counterMetrics,
+		embedOrigins:      *embedOrigins,
+
+		auth:  authenticator,
+		audit: audit,
+
+		leaderboards: boards,
+
+		perIP:          newLimiter(*ipRate, *ipBurst),
+		perKey:         newLimiter(*keyRate, *keyBurst),
+		trustForwarded: *trustForwarded,
+
+		results: newResultsCache(*resultsTTL),
+	}
+	s.watchers = newPollWatchers(resultsInterval, s.readPoll)
+	if s.events != nil {
+		defer s.events.Stop()
 	}
+	if err := s.forgetAPIKeys(); err != nil {
+		log.Println("Failed to remove the API keys older polls were stored with:", err)
+	}
+	s.graphql = s.graphQLSchema()
 	mux := http.NewServeMux()
-	mux.HandleFunc("/polls/", withCORS(withAPIKey(s.handlePolls)))
+	mux.HandleFunc("/polls/", withCORS(s.withEmbeds(s.withAuth(readWrite, s.handlePolls))))
+	mux.HandleFunc("/polls/batch", withCORS(s.withAuth(needs(roleOperator), s.handlePollsBatch)))
+	mux.HandleFunc("/polls/bulk", withCORS(s.withAuth(needs(rolePollAdmin), s.handlePollsBulk)))
+	mux.HandleFunc("/polls/validate", withCORS(s.withAuth(needs(rolePollAdmin), s.handlePollsValidate)))
+	mux.HandleFunc("/campaigns/", withCORS(s.withAuth(readWrite, s.handleCampaigns)))
+	mux.HandleFunc("/graphql", withCORS(s.withAuth(needs(roleViewer), s.handleGraphQL))) // mutations are checked by the handler
+	mux.HandleFunc("/graphql/schema", withCORS(s.withAuth(needs(roleViewer), s.handleGraphQLSchema)))
+	mux.HandleFunc("/health/stream", withCORS(s.withAuth(needs(roleViewer), s.handleStreamHealth)))
+	mux.Handle("/dashboard/", dashboardHandler())
 	log.Println("Starting web server on", *addr)
 	http.ListenAndServe(":8080", mux)
 	log.Println("Stopping...")
 }
-
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// Chat services a poll can post its milestones and summaries to
+const (
+	notifySlack   = "slack"
+	notifyDiscord = "discord"
+)
+
+// maxNotifications is how many channels one poll can post to
+const maxNotifications = 5
+
+// notification is a chat channel the counter posts the poll's progress to
+type notification struct {
+	Kind string `json:"kind"`
+	// URL is the channel's incoming webhook, it is a secret and never shown back
+	URL        string `json:"url,omitempty"`
+	Milestones []int  `json:"milestones,omitempty"`
+	Hourly     bool   `json:"hourly,omitempty"`
+}
+
+// validateNotifications checks the kind and webhook of every channel
+func validateNotifications(ns []notification) error {
+	if len(ns) > maxNotifications {
+		return fmt.Errorf("at most %d notifications are allowed", maxNotifications)
+	}
+	for _, n := range ns {
+		switch n.Kind {
+		case notifySlack, notifyDiscord:
+		default:
+			return errors.New("notification kind must be slack or discord")
+		}
+		u, err := url.Parse(n.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("%s notification needs an https webhook url", n.Kind)
+		}
+		for _, m := range n.Milestones {
+			if m <= 0 {
+				return errors.New("milestones must be positive vote counts")
+			}
+		}
+		if len(n.Milestones) == 0 && !n.Hourly {
+			return fmt.Errorf("%s notification has no milestones and isn't hourly, it would never post", n.Kind)
+		}
+	}
+	return nil
+}
+
+// redactNotifications hides the webhook URLs, anyone holding one can post to the channel
+func redactNotifications(p *poll) {
+	for i := range p.Notifications {
+		p.Notifications[i].URL = ""
+	}
+}
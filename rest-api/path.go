@@ -29,6 +29,20 @@ func NewPath(p string) *Path {
 }
 
 // HasID checks if the path has an ID
-func (p *Path) HasID() bool{
+func (p *Path) HasID() bool {
 	return len(p.ID) > 0
-}
\ No newline at end of file
+}
+
+// SubResource splits a path like polls/{id}/race or polls/{id}/results/stream
+// into the id and the sub-resource (race, results/stream).
+// ok is false when the path doesn't address a sub-resource.
+func (p *Path) SubResource() (id, sub string, ok bool) {
+	if !p.HasID() {
+		return "", "", false
+	}
+	s := strings.Split(p.Path+PathSeparator+p.ID, PathSeparator)
+	if len(s) < 3 {
+		return "", "", false
+	}
+	return s[1], strings.Join(s[2:], PathSeparator), true
+}
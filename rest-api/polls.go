@@ -2,22 +2,412 @@ package main
 
 import (
 	"errors"
+	"fmt"
 	"net/http"
+	"time"
 
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 )
 
-// poll defines the structure of a poll with 5 fields
+// Poll types decide how the counter tallies votes
+const (
+	pollTypeStandard = "standard" // every vote counts as one
+	pollTypeWeighted = "weighted" // votes are also tallied by author weight
+	pollTypeRanked   = "ranked"   // tweets list options in order of preference, scored as Borda points
+)
+
+// Poll statuses, a poll without one is active. Drafts aren't streamed or
+// counted, paused polls aren't streamed but count the votes on their way, and
+// closed and archived polls count nothing.
+const (
+	pollStatusDraft    = "draft"
+	pollStatusActive   = "active"
+	pollStatusPaused   = "paused"
+	pollStatusClosed   = "closed"
+	pollStatusArchived = "archived"
+)
+
+// pollStatusDeleted is the status shown for a deleted poll, whatever it was
+// when it was deleted; it is no status a poll can be given
+const pollStatusDeleted = "deleted"
+
+// pollTransitions are the statuses each status can change to, as the streamer's store allows
+var pollTransitions = map[string][]string{
+	pollStatusDraft:    {pollStatusActive, pollStatusClosed},
+	pollStatusActive:   {pollStatusPaused, pollStatusClosed},
+	pollStatusPaused:   {pollStatusActive, pollStatusClosed},
+	pollStatusClosed:   {pollStatusArchived},
+	pollStatusArchived: nil,
+}
+
+// canTransition returns an error unless a poll with status from can become to
+func canTransition(from, to string) error {
+	for _, next := range pollTransitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return fmt.Errorf("a %s poll can't become %s", from, to)
+}
+
+// Counting modes decide what a poll's results count
+const (
+	countMentions      = "mentions"       // every tweet mentioning an option
+	countUniqueAuthors = "unique_authors" // each author once per option
+)
+
+// Embedded text modes decide whether the text of retweeted and quoted tweets
+// is scanned for a poll's options, empty leaves it to how the streamers are set up
+const (
+	embeddedScan   = "scan"
+	embeddedIgnore = "ignore"
+)
+
+// validateEmbedded checks a poll's embedded text mode
+func validateEmbedded(mode string) error {
+	switch mode {
+	case "", embeddedScan, embeddedIgnore:
+		return nil
+	}
+	return fmt.Errorf("embedded_text must be %s or %s", embeddedScan, embeddedIgnore)
+}
+
+// Folding modes also count a poll's options written otherwise, empty only as written
+const (
+	foldDiacritics    = "diacritics"    // without their diacritics, Munchen for München
+	foldTransliterate = "transliterate" // also in another alphabet, Moskva for Москва
+)
+
+// validateFolding checks a poll's folding mode
+func validateFolding(mode string) error {
+	switch mode {
+	case "", foldDiacritics, foldTransliterate:
+		return nil
+	}
+	return fmt.Errorf("folding must be %s or %s", foldDiacritics, foldTransliterate)
+}
+
+// maxFilterLength is the longest filter expression, or route, a poll can have
+const maxFilterLength = 1000
+
+// validateFilter checks a poll's filter expression as far as it can without
+// compiling it: its length, quotes and parentheses. The streamers compile it,
+// a poll whose filter doesn't compile takes no votes.
+func validateFilter(src string) error {
+	return validateExpression("filter", src)
+}
+
+// validateRoute checks a poll's route as validateFilter does its filter, a
+// poll whose route doesn't compile takes no votes for the options it shares
+func validateRoute(src string) error {
+	return validateExpression("route", src)
+}
+
+// validateExpression checks the expression src of the field name
+func validateExpression(name, src string) error {
+	if len(src) > maxFilterLength {
+		return fmt.Errorf("%s must be at most %d bytes", name, maxFilterLength)
+	}
+	depth, quoted := 0, false
+	for i := 0; i < len(src); i++ {
+		switch c := src[i]; {
+		case quoted && c == '\\':
+			i++
+		case c == '"':
+			quoted = !quoted
+		case quoted:
+		case c == '(':
+			depth++
+		case c == ')':
+			if depth--; depth < 0 {
+				return fmt.Errorf("%s has an unmatched ) at %d", name, i)
+			}
+		}
+	}
+	if quoted {
+		return fmt.Errorf("%s has an unterminated string", name)
+	}
+	if depth > 0 {
+		return fmt.Errorf("%s has an unmatched (", name)
+	}
+	return nil
+}
+
+// Poll priorities, a low priority poll's options leave the stream while idle
+// and first when there are too many to track, a high priority poll's never
+const (
+	priorityHigh   = "high"
+	priorityNormal = "normal"
+	priorityLow    = "low"
+)
+
+// validatePriority checks a poll's priority
+func validatePriority(priority string) error {
+	switch priority {
+	case "", priorityHigh, priorityNormal, priorityLow:
+		return nil
+	}
+	return fmt.Errorf("priority must be %s, %s or %s", priorityHigh, priorityNormal, priorityLow)
+}
+
+// maxSampleEvery is the sparsest sampling a poll can ask for
+const maxSampleEvery = 1000
+
+// validateSampling checks a poll's sampling, 0 and 1 keep every vote
+func validateSampling(every int) error {
+	if every < 0 || every > maxSampleEvery {
+		return fmt.Errorf("sample_every must be between 0 and %d", maxSampleEvery)
+	}
+	return nil
+}
+
+// validateRateCap checks a poll's cap on the votes of each option a minute, 0 for none
+func validateRateCap(perMinute int) error {
+	if perMinute < 0 {
+		return fmt.Errorf("max_per_minute must be 0 or more")
+	}
+	return nil
+}
+
+// validateTimezone checks the time zone a poll's results are bucketed in, empty for UTC
+func validateTimezone(zone string) error {
+	if _, err := time.LoadLocation(zone); err != nil {
+		return fmt.Errorf("unknown timezone %q, want an IANA name as Europe/Berlin", zone)
+	}
+	return nil
+}
+
+// validateCounting checks a poll's counting mode, empty counts mentions
+func validateCounting(counting string) error {
+	switch counting {
+	case "", countMentions, countUniqueAuthors:
+		return nil
+	}
+	return fmt.Errorf("counting must be %s or %s", countMentions, countUniqueAuthors)
+}
+
+// optionMatching makes an option of a poll count more strictly than ignoring
+// case, anywhere in the text, or more loosely in other forms of its words:
+// the streamers tag the votes that only had it in another case, inside longer
+// words or stemmed, and the counter sorts those out
+type optionMatching struct {
+	Option string `bson:"option" json:"option"`
+	// CaseSensitive only counts the option written in the same case, "iOS" but not "ios"
+	CaseSensitive bool `bson:"case_sensitive,omitempty" json:"case_sensitive,omitempty"`
+	// Exact only counts the option as whole words, not inside longer ones
+	Exact bool `bson:"exact,omitempty" json:"exact,omitempty"`
+	// Fuzziness also counts the other forms of the option's words, in Language, English when empty
+	Fuzziness string `bson:"fuzziness,omitempty" json:"fuzziness,omitempty"`
+	Language  string `bson:"language,omitempty" json:"language,omitempty"`
+}
+
+// Fuzziness levels also count an option in other forms of its words, empty only as written
+const (
+	fuzzyPlural = "plural" // in the singular or the plural, votes for vote
+	fuzzyStem   = "stem"   // in any form, voting for vote
+)
+
+// stemLanguages are the languages the streamers stem words in, as ISO 639-1 codes
+var stemLanguages = map[string]bool{"de": true, "en": true, "es": true, "fr": true, "it": true, "nl": true, "pt": true}
+
+// pollEmbargo holds a poll's votes back from its results, until a time or
+// every day during its quiet hours; the counter counts them once it lifts
+type pollEmbargo struct {
+	Until *time.Time `bson:"until,omitempty" json:"until,omitempty"`
+	// QuietFrom and QuietTo are the daily quiet hours as 15:04 in Zone, UTC
+	// when empty. They span midnight when QuietFrom is after QuietTo.
+	QuietFrom string `bson:"quiet_from,omitempty" json:"quiet_from,omitempty"`
+	QuietTo   string `bson:"quiet_to,omitempty" json:"quiet_to,omitempty"`
+	Zone      string `bson:"zone,omitempty" json:"zone,omitempty"`
+}
+
+// empty reports whether the embargo holds nothing back
+func (e *pollEmbargo) empty() bool {
+	return e == nil || e.Until == nil && e.QuietFrom == "" && e.QuietTo == ""
+}
+
+// validateEmbargo checks the quiet hours and the time zone of an embargo, which may be nil
+func validateEmbargo(e *pollEmbargo) error {
+	if e == nil {
+		return nil
+	}
+	if (e.QuietFrom == "") != (e.QuietTo == "") {
+		return errors.New("quiet hours need both quiet_from and quiet_to")
+	}
+	if e.QuietFrom != "" {
+		from, err := time.Parse("15:04", e.QuietFrom)
+		if err != nil {
+			return fmt.Errorf("quiet_from %q must be a time like 22:00", e.QuietFrom)
+		}
+		to, err := time.Parse("15:04", e.QuietTo)
+		if err != nil {
+			return fmt.Errorf("quiet_to %q must be a time like 07:00", e.QuietTo)
+		}
+		if from.Equal(to) {
+			return errors.New("quiet_from and quiet_to are the same time")
+		}
+	}
+	if _, err := time.LoadLocation(e.Zone); err != nil {
+		return fmt.Errorf("unknown zone %q", e.Zone)
+	}
+	return nil
+}
+
+// validateMatching checks the matching of a poll's options, each listed once.
+// options, when given, are the poll's.
+func validateMatching(matching []optionMatching, options []string) error {
+	seen := make(map[string]bool, len(matching))
+	for _, m := range matching {
+		switch {
+		case m.Option == "":
+			return errors.New("matching needs the option it applies to")
+		case seen[m.Option]:
+			return fmt.Errorf("matching lists %q twice", m.Option)
+		case options != nil && !hasOption(options, m.Option):
+			return fmt.Errorf("matching lists %q, which isn't one of the options", m.Option)
+		case m.Fuzziness != "" && m.Fuzziness != fuzzyPlural && m.Fuzziness != fuzzyStem:
+			return fmt.Errorf("the fuzziness of %q must be %s or %s", m.Option, fuzzyPlural, fuzzyStem)
+		case m.Language != "" && !stemLanguages[m.Language]:
+			return fmt.Errorf("the language of %q must be one of de, en, es, fr, it, nl or pt", m.Option)
+		}
+		seen[m.Option] = true
+	}
+	return nil
+}
+
+func hasOption(options []string, option string) bool {
+	for _, o := range options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
+
+// poll defines the structure of a poll
 type poll struct {
 	ID      bson.ObjectId  `bson:"_id" json:"id"`
 	Title   string         `json:"title"`
 	Options []string       `json:"options"`
 	Results map[string]int `json:"results,omitempty"`
-	APIKey  string         `json:"apikey"` // shouldn't be done in production
+	// WeightedResults holds the tallies with author weighting applied
+	WeightedResults map[string]float64 `bson:"weighted_results" json:"weighted_results,omitempty"`
+	Type            string             `json:"type,omitempty"`
+	Visibility      string             `json:"visibility,omitempty"` // public or private
+	// DetailedMetrics opts the poll in to per-option metrics in the counter
+	DetailedMetrics bool `bson:"detailed_metrics" json:"detailed_metrics,omitempty"`
+	// MinShare hides options below this percentage of the vote, grouping them as "Other"
+	MinShare float64 `bson:"min_share" json:"min_share,omitempty"`
+	// Precision is the number of decimals shares are rounded to
+	Precision *int `bson:"precision,omitempty" json:"precision,omitempty"`
+	// Grouped is the label the options hidden by MinShare are counted under,
+	// "Other" unless an option has that name; computed when a poll is read
+	Grouped string `bson:"-" json:"grouped,omitempty"`
+	// Shares and WeightedShares are computed when a poll is read, never stored
+	Shares         map[string]float64 `bson:"-" json:"shares,omitempty"`
+	WeightedShares map[string]float64 `bson:"-" json:"weighted_shares,omitempty"`
+	Status         string             `json:"status,omitempty"`
+	EndsAt         *time.Time         `bson:"ends_at,omitempty" json:"ends_at,omitempty"`
+	Campaign       string             `json:"campaign,omitempty"`
+	Tags           []string           `json:"tags,omitempty"`
+	// Locations limits the poll to votes from inside these west,south,east,north boxes
+	Locations [][4]float64 `json:"locations,omitempty"`
+	// GeoAggregation breaks the results down by country or region
+	GeoAggregation string `bson:"geo_aggregation" json:"geo_aggregation,omitempty"`
+	// GeoResults holds the raw counts per area when GeoAggregation is set
+	GeoResults map[string]map[string]int `bson:"geo_results" json:"geo_results,omitempty"`
+	// HashtagOnly only counts tweets that have an option as a hashtag, not just in their text
+	HashtagOnly bool `bson:"hashtag_only" json:"hashtag_only,omitempty"`
+	// Metrics holds the custom results the counter computes for the poll's type, per metric and option,
+	// in undelivered the votes per option estimated lost to Twitter's stream limits,
+	// in retracted the votes taken back because Twitter asked to stop showing their tweets,
+	// and in overflow the votes over max_per_minute
+	Metrics map[string]map[string]float64 `json:"metrics,omitempty"`
+	// SourceResults holds the raw counts per source the votes were cast on (twitter, youtube, sms...)
+	SourceResults map[string]map[string]int `bson:"source_results" json:"source_results,omitempty"`
+	// LanguageResults holds the raw counts per language of the votes, und when it couldn't be told
+	LanguageResults map[string]map[string]int `bson:"language_results" json:"language_results,omitempty"`
+	// Notifications are the Slack or Discord channels told about milestones and hourly progress
+	Notifications []notification `json:"notifications,omitempty"`
+	// Account names the Twitter credentials the poll's options are streamed with, the default ones when empty
+	Account string `json:"account,omitempty"`
+	// Private polls get votes with hashed authors and without their text
+	Private bool `bson:"private" json:"private,omitempty"`
+	// Counting is mentions or unique_authors, which counts each author once per option
+	Counting string `json:"counting,omitempty"`
+	// ExcludeSuspect leaves out the votes cast during a spike for their option
+	ExcludeSuspect bool `bson:"exclude_suspect" json:"exclude_suspect,omitempty"`
+	// SampleEvery has the streamers publish 1 in SampleEvery votes, counted as
+	// SampleEvery votes each, for polls too busy to count every vote
+	SampleEvery int `bson:"sample_every" json:"sample_every,omitempty"`
+	// MaxPerMinute caps the votes each option counts a minute, the counter adds
+	// up the votes over it in the overflow metric instead; no cap when 0
+	MaxPerMinute int `bson:"max_per_minute,omitempty" json:"max_per_minute,omitempty"`
+	// Timezone is the IANA time zone the results are bucketed by hour and day
+	// in, see include=hourly and include=daily; UTC when empty
+	Timezone string `bson:"timezone,omitempty" json:"timezone,omitempty"`
+	// ExcludeRetweets, ExcludeQuotes and ExcludeReplies leave out the votes cast
+	// by retweets, quote tweets or replies
+	ExcludeRetweets bool `bson:"exclude_retweets" json:"exclude_retweets,omitempty"`
+	ExcludeQuotes   bool `bson:"exclude_quotes" json:"exclude_quotes,omitempty"`
+	ExcludeReplies  bool `bson:"exclude_replies" json:"exclude_replies,omitempty"`
+	// EnrichAuthors has the streamers look up the profile of the authors of
+	// votes whose tweets came without it, for the weighting and the filter
+	EnrichAuthors bool `bson:"enrich_authors,omitempty" json:"enrich_authors,omitempty"`
+	// EmbeddedText is scan or ignore, whether the text of retweeted and quoted tweets counts
+	EmbeddedText string `bson:"embedded_text" json:"embedded_text,omitempty"`
+	// Folding is diacritics or transliterate, also counting the options written without their diacritics or in another alphabet
+	Folding string `json:"folding,omitempty"`
+	// Filter is an expression the tweets must pass for their votes to count, as
+	// contains("go") AND NOT contains("pokemon go") AND lang == "en"
+	Filter string `bson:"filter,omitempty" json:"filter,omitempty"`
+	// Route is an expression like Filter deciding which of the votes for the
+	// options other streamed polls also have count for this one; all of them when empty
+	Route string `bson:"route,omitempty" json:"route,omitempty"`
+	// Priority is low for a poll whose options may leave the stream's filter while they are idle,
+	// high for one whose options keep their place in it when there are too many to track
+	Priority string `json:"priority,omitempty"`
+	// Matching makes some options count only in the case written, or as whole
+	// words, and others also in the other forms of their words
+	Matching []optionMatching `json:"matching,omitempty"`
+	// Embargo holds the votes back from the results until a time or during quiet hours
+	Embargo *pollEmbargo `bson:"embargo,omitempty" json:"embargo,omitempty"`
+	// Version is the version of the options, Versions all of them once they
+	// were edited, the current one last
+	Version  int           `bson:"version,omitempty" json:"version,omitempty"`
+	Versions []pollVersion `bson:"versions,omitempty" json:"versions,omitempty"`
+	// DeletedAt is when the poll was deleted, it is kept until purged
+	DeletedAt *time.Time `bson:"deleted_at,omitempty" json:"deleted_at,omitempty"`
+	// CreatedBy is who created the poll, an API key's name or a token's subject
+	CreatedBy string `bson:"created_by,omitempty" json:"created_by,omitempty"`
+}
+
+// forgetAPIKeys removes the API keys polls used to be stored with, their
+// creators', so no read hands them out
+func (s *Server) forgetAPIKeys() error {
+	session := s.db.Copy()
+	defer session.Close()
+	_, err := s.polls(session).UpdateAll(bson.M{"apikey": bson.M{"$exists": true}}, bson.M{"$unset": bson.M{"apikey": ""}})
+	return err
+}
+
+// status returns the poll's status, defaulting to active, or deleted
+func (p *poll) status() string {
+	if p.DeletedAt != nil {
+		return pollStatusDeleted
+	}
+	if p.Status == "" {
+		return pollStatusActive
+	}
+	return p.Status
 }
 
 func (s *Server) handlePolls(w http.ResponseWriter, r *http.Request) {
+	if id, sub, ok := NewPath(r.URL.Path).SubResource(); ok {
+		s.handlePollSubResource(w, r, id, sub)
+		return
+	}
 	switch r.Method {
 	case "GET":
 		s.handlePollsGet(w, r)
@@ -25,12 +415,15 @@ func (s *Server) handlePolls(w http.ResponseWriter, r *http.Request) {
 	case "POST":
 		s.handlePollsPost(w, r)
 		return
+	case "PATCH":
+		s.handlePollsPatch(w, r)
+		return
 	case "DELETE":
 		s.handlePollsDelete(w, r)
 		return
 	case "OPTIONS":
-		// allow delete over CORS
-		w.Header().Add("Access-Control-Allow-Methods", "DELETE")
+		// allow delete and patch over CORS
+		w.Header().Add("Access-Control-Allow-Methods", "DELETE, PATCH")
 		respond(w, r, http.StatusOK, nil)
 		return
 	}
@@ -38,6 +431,38 @@ func (s *Server) handlePolls(w http.ResponseWriter, r *http.Request) {
 	respondHTTPErr(w, r, http.StatusNotFound)
 }
 
+// handlePollSubResource routes requests for /polls/{id}/{sub...}
+func (s *Server) handlePollSubResource(w http.ResponseWriter, r *http.Request, id, sub string) {
+	switch {
+	case sub == "race" && r.Method == "GET":
+		s.handlePollRace(w, r, id)
+		return
+	case sub == "results" && r.Method == "GET":
+		s.results.serve(w, r, func(w http.ResponseWriter, r *http.Request) { s.handlePollResults(w, r, id) })
+		return
+	case sub == "leaderboard" && r.Method == "GET":
+		s.handlePollLeaderboard(w, r, id)
+		return
+	case sub == "results/stream" && r.Method == "GET":
+		s.handlePollResultsStream(w, r, id)
+		return
+	case sub == "certificate" && r.Method == "GET":
+		s.handlePollCertificate(w, r, id)
+		return
+	case sub == "restore" && r.Method == "POST":
+		s.handlePollRestore(w, r, id)
+		return
+	case sub == "disputes" && (r.Method == "GET" || r.Method == "POST"):
+		s.handlePollDisputes(w, r, id)
+		return
+	case sub == "annotations" && (r.Method == "GET" || r.Method == "POST"):
+		s.handlePollAnnotations(w, r, id)
+		return
+	}
+	// not found
+	respondHTTPErr(w, r, http.StatusNotFound)
+}
+
 // Reading polls
 func (s *Server) handlePollsGet(w http.ResponseWriter, r *http.Request) {
 	var q *mgo.Query
@@ -48,21 +473,27 @@ func (s *Server) handlePollsGet(w http.ResponseWriter, r *http.Request) {
 	defer session.Close()
 
 	// create an object referring to the polls collection
-	c := session.DB("ballots").C("polls")
+	c := s.polls(session)
 
 	// parse the url path into an instance of the Path type
 	p := NewPath(r.URL.Path)
 
 	// build an mgo.Query object by parsing the path
 	if p.HasID() {
-		q = c.FindId(bson.ObjectIdHex(p.ID)) // get a specific poll
+		q = c.FindId(bson.ObjectIdHex(p.ID)) // get a specific poll, deleted or not
+	} else if r.URL.Query().Get("deleted") == "true" {
+		q = c.Find(nil) // get all polls, the deleted ones too
 	} else {
-		q = c.Find(nil)	// get all polls
+		q = c.Find(notDeleted(nil)) // get all polls
 	}
 	if err := q.All(&result); err != nil {
 		respondErr(w, r, http.StatusInternalServerError, errors.New("not implemented"))
 		return
 	}
+	for _, p := range result {
+		applyDisplay(p)
+		redactNotifications(p)
+	}
 	respond(w, r, http.StatusOK, &result)
 }
 
@@ -75,29 +506,388 @@ func (s *Server) handlePollsPost(w http.ResponseWriter, r *http.Request) {
 	defer session.Close()
 
 	// create object referring to the polls collection
-	c := session.DB("ballots").C("polls")
+	c := s.polls(session)
 
 	// read the request body and store the value into &p
 	if err := decodeBody(r, &p); err != nil {
 		respondErr(w, r, http.StatusBadRequest, "failed to read poll from request", err)
+		return
 	}
-
-	// Extract the apiKey
-	apiKey, ok := APIKey(r.Context())
-	if ok {
-		p.APIKey = apiKey
+	if err := preparePoll(&p); err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	others, err := s.streamedPolls()
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to load the polls", err)
+		return
+	}
+	terms := validateTerms(&p, others)
+	if !terms.Valid {
+		respond(w, r, http.StatusBadRequest, terms)
+		return
 	}
+
+	p.CreatedBy = createdBy(r.Context())
 	p.ID = bson.NewObjectId()
 	if err := c.Insert(p); err != nil {
 		respondErr(w, r, http.StatusInternalServerError, "failed to insert poll", err)
 		return
 	}
+	s.publishPollEvent(p.ID.Hex(), "created")
 
 	// point to the URL to access the newly created poll
 	w.Header().Set("Location", "polls/"+p.ID.Hex())
+	if len(terms.Warnings) > 0 {
+		respond(w, r, http.StatusCreated, terms)
+		return
+	}
 	respond(w, r, http.StatusCreated, nil)
 }
 
+// preparePoll fills in the defaults of a new poll and checks its settings,
+// the options are checked against the streamed polls by validateTerms
+func preparePoll(p *poll) error {
+	p.Version, p.Versions, p.DeletedAt = 1, nil, nil
+	switch p.Type {
+	case "":
+		p.Type = pollTypeStandard
+	case pollTypeStandard, pollTypeWeighted, pollTypeRanked:
+	default:
+		return fmt.Errorf("unknown poll type %s", p.Type)
+	}
+	if p.Visibility == "" {
+		p.Visibility = "public"
+	}
+	switch p.Status {
+	case "", pollStatusActive:
+		p.Status = pollStatusActive
+	case pollStatusDraft:
+	default:
+		return fmt.Errorf("a new poll is active or a draft, not %s", p.Status)
+	}
+	if err := validateDisplay(p.MinShare, p.Precision); err != nil {
+		return err
+	}
+	if err := validateGeo(p.Locations, p.GeoAggregation); err != nil {
+		return err
+	}
+	if err := validateNotifications(p.Notifications); err != nil {
+		return err
+	}
+	if err := validateAccount(p.Account); err != nil {
+		return err
+	}
+	if err := validateCounting(p.Counting); err != nil {
+		return err
+	}
+	if err := validateSampling(p.SampleEvery); err != nil {
+		return err
+	}
+	if err := validateRateCap(p.MaxPerMinute); err != nil {
+		return err
+	}
+	if err := validateTimezone(p.Timezone); err != nil {
+		return err
+	}
+	if err := validateCampaign(p.Campaign); err != nil {
+		return err
+	}
+	if err := validateMatching(p.Matching, p.Options); err != nil {
+		return err
+	}
+	if p.Embargo.empty() {
+		p.Embargo = nil
+	}
+	if err := validateEmbargo(p.Embargo); err != nil {
+		return err
+	}
+	if err := validateFolding(p.Folding); err != nil {
+		return err
+	}
+	if err := validatePriority(p.Priority); err != nil {
+		return err
+	}
+	if err := validateFilter(p.Filter); err != nil {
+		return err
+	}
+	if err := validateRoute(p.Route); err != nil {
+		return err
+	}
+	return validateEmbedded(p.EmbeddedText)
+}
+
+// pollSettings are the poll fields that can be changed after creation.
+// Fields left out of the request body are not touched.
+type pollSettings struct {
+	Visibility      *string  `json:"visibility"`
+	DetailedMetrics *bool    `json:"detailed_metrics"`
+	MinShare        *float64 `json:"min_share"`
+	Precision       *int     `json:"precision"`
+	// Locations replaces the poll's location filters, an empty list removes them
+	Locations      *[][4]float64 `json:"locations"`
+	GeoAggregation *string       `json:"geo_aggregation"`
+	HashtagOnly    *bool         `json:"hashtag_only"`
+	// Notifications replaces the poll's chat channels, an empty list removes them
+	Notifications *[]notification `json:"notifications"`
+	// Account moves the poll to another account's stream, an empty string to the default one
+	Account *string `json:"account"`
+	// Private turns privacy mode on or off for the votes streamed from now on
+	Private *bool `json:"private"`
+	// Counting switches what the votes counted from now on count, the results so far are kept
+	Counting *string `json:"counting"`
+	// ExcludeSuspect starts or stops leaving out the suspect votes counted from now on
+	ExcludeSuspect *bool `json:"exclude_suspect"`
+	// SampleEvery changes the sampling of the votes streamed from now on
+	SampleEvery *int `json:"sample_every"`
+	// MaxPerMinute changes the cap on the votes each option counts a minute, 0 removes it
+	MaxPerMinute *int `json:"max_per_minute"`
+	// Timezone changes the time zone the results counted from now on are bucketed in, empty for UTC
+	Timezone *string `json:"timezone"`
+	// Campaign moves the poll to another campaign, an empty string takes it out of its campaign
+	Campaign *string `json:"campaign"`
+	// ExcludeRetweets, ExcludeQuotes and ExcludeReplies apply to the votes counted from now on
+	ExcludeRetweets *bool `json:"exclude_retweets"`
+	ExcludeQuotes   *bool `json:"exclude_quotes"`
+	ExcludeReplies  *bool `json:"exclude_replies"`
+	// EnrichAuthors starts or stops looking up the authors' profiles of the votes streamed from now on
+	EnrichAuthors *bool `json:"enrich_authors"`
+	// EmbeddedText changes whether the text of retweeted and quoted tweets streamed from now on counts
+	EmbeddedText *string `json:"embedded_text"`
+	// Folding changes which spellings of the options streamed from now on count
+	Folding *string `json:"folding"`
+	// Filter replaces the filter expression of the votes streamed from now on, an empty one removes it
+	Filter *string `json:"filter"`
+	// Route replaces the route of the votes for the shared options streamed from now on, an empty one removes it
+	Route *string `json:"route"`
+	// Priority changes whether the poll's options may leave the stream while idle or when there are too many
+	Priority *string `json:"priority"`
+	// Matching replaces how strictly the options are matched, an empty list matches them all ignoring case
+	Matching *[]optionMatching `json:"matching"`
+	// Embargo replaces the poll's embargo, an empty one lifts it; the votes held back so far are counted once it lifts
+	Embargo *pollEmbargo `json:"embargo"`
+	// Options replaces the poll's options as their next version, see editOptions
+	Options *[]string `json:"options"`
+}
+
+// validateAccount checks an account name, which the streamers turn into the
+// names of its TWITTER_<ACCOUNT>_* credentials
+func validateAccount(account string) error {
+	for _, r := range account {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("account %q may only have lowercase letters, digits and underscores", account)
+		}
+	}
+	return nil
+}
+
+// changes returns the fields to $set for the settings given, once they are checked
+func (settings pollSettings) changes() (bson.M, error) {
+	set := bson.M{}
+	if settings.Visibility != nil {
+		set["visibility"] = *settings.Visibility
+	}
+	if settings.DetailedMetrics != nil {
+		set["detailed_metrics"] = *settings.DetailedMetrics
+	}
+	if settings.HashtagOnly != nil {
+		set["hashtag_only"] = *settings.HashtagOnly
+	}
+	if settings.Account != nil {
+		if err := validateAccount(*settings.Account); err != nil {
+			return nil, err
+		}
+		set["account"] = *settings.Account
+	}
+	if settings.Private != nil {
+		set["private"] = *settings.Private
+	}
+	if settings.Counting != nil {
+		if err := validateCounting(*settings.Counting); err != nil {
+			return nil, err
+		}
+		set["counting"] = *settings.Counting
+	}
+	if settings.ExcludeSuspect != nil {
+		set["exclude_suspect"] = *settings.ExcludeSuspect
+	}
+	if settings.SampleEvery != nil {
+		if err := validateSampling(*settings.SampleEvery); err != nil {
+			return nil, err
+		}
+		set["sample_every"] = *settings.SampleEvery
+	}
+	if settings.MaxPerMinute != nil {
+		if err := validateRateCap(*settings.MaxPerMinute); err != nil {
+			return nil, err
+		}
+		set["max_per_minute"] = *settings.MaxPerMinute
+	}
+	if settings.Timezone != nil {
+		if err := validateTimezone(*settings.Timezone); err != nil {
+			return nil, err
+		}
+		set["timezone"] = *settings.Timezone
+	}
+	if settings.Campaign != nil {
+		if err := validateCampaign(*settings.Campaign); err != nil {
+			return nil, err
+		}
+		set["campaign"] = *settings.Campaign
+	}
+	if settings.ExcludeRetweets != nil {
+		set["exclude_retweets"] = *settings.ExcludeRetweets
+	}
+	if settings.ExcludeQuotes != nil {
+		set["exclude_quotes"] = *settings.ExcludeQuotes
+	}
+	if settings.ExcludeReplies != nil {
+		set["exclude_replies"] = *settings.ExcludeReplies
+	}
+	if settings.EnrichAuthors != nil {
+		set["enrich_authors"] = *settings.EnrichAuthors
+	}
+	if settings.EmbeddedText != nil {
+		if err := validateEmbedded(*settings.EmbeddedText); err != nil {
+			return nil, err
+		}
+		set["embedded_text"] = *settings.EmbeddedText
+	}
+	if settings.Folding != nil {
+		if err := validateFolding(*settings.Folding); err != nil {
+			return nil, err
+		}
+		set["folding"] = *settings.Folding
+	}
+	if settings.Filter != nil {
+		if err := validateFilter(*settings.Filter); err != nil {
+			return nil, err
+		}
+		set["filter"] = *settings.Filter
+	}
+	if settings.Route != nil {
+		if err := validateRoute(*settings.Route); err != nil {
+			return nil, err
+		}
+		set["route"] = *settings.Route
+	}
+	if settings.Priority != nil {
+		if err := validatePriority(*settings.Priority); err != nil {
+			return nil, err
+		}
+		set["priority"] = *settings.Priority
+	}
+	if settings.Matching != nil {
+		if err := validateMatching(*settings.Matching, nil); err != nil {
+			return nil, err
+		}
+		set["matching"] = *settings.Matching
+	}
+	if settings.Embargo != nil {
+		if settings.Embargo.empty() {
+			set["embargo"] = nil
+		} else if err := validateEmbargo(settings.Embargo); err != nil {
+			return nil, err
+		} else {
+			set["embargo"] = settings.Embargo
+		}
+	}
+	if settings.Notifications != nil {
+		if err := validateNotifications(*settings.Notifications); err != nil {
+			return nil, err
+		}
+		set["notifications"] = *settings.Notifications
+	}
+	if settings.MinShare != nil || settings.Precision != nil {
+		var minShare float64
+		if settings.MinShare != nil {
+			minShare = *settings.MinShare
+			set["min_share"] = minShare
+		}
+		if err := validateDisplay(minShare, settings.Precision); err != nil {
+			return nil, err
+		}
+		if settings.Precision != nil {
+			set["precision"] = *settings.Precision
+		}
+	}
+	if settings.Locations != nil || settings.GeoAggregation != nil {
+		var locations [][4]float64
+		var aggregation string
+		if settings.Locations != nil {
+			locations = *settings.Locations
+			set["locations"] = locations
+		}
+		if settings.GeoAggregation != nil {
+			aggregation = *settings.GeoAggregation
+			set["geo_aggregation"] = aggregation
+		}
+		if err := validateGeo(locations, aggregation); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Updating a poll's settings
+func (s *Server) handlePollsPatch(w http.ResponseWriter, r *http.Request) {
+	var settings pollSettings
+
+	// create a copy of the database connection
+	session := s.db.Copy()
+	defer session.Close()
+
+	// create object referring to the polls collection
+	c := s.polls(session)
+
+	// parse the url path into an instance of the Path type
+	p := NewPath(r.URL.Path)
+	if !p.HasID() || !bson.IsObjectIdHex(p.ID) {
+		respondErr(w, r, http.StatusBadRequest, "poll id required")
+		return
+	}
+	if err := decodeBody(r, &settings); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read settings from request", err)
+		return
+	}
+	set, err := settings.changes()
+	if err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if len(set) == 0 && settings.Options == nil {
+		respondErr(w, r, http.StatusBadRequest, "nothing to update")
+		return
+	}
+	terms := validation{Valid: true}
+	if settings.Options != nil {
+		terms, err = s.editOptions(c, bson.ObjectIdHex(p.ID), *settings.Options, set)
+		if err == nil && !terms.Valid {
+			respond(w, r, http.StatusBadRequest, terms)
+			return
+		}
+	} else {
+		err = c.Update(notDeleted(bson.M{"_id": bson.ObjectIdHex(p.ID)}), bson.M{"$set": set})
+	}
+	if err != nil {
+		switch err {
+		case mgo.ErrNotFound:
+			respondHTTPErr(w, r, http.StatusNotFound)
+		case errOptionsEdited:
+			respondErr(w, r, http.StatusConflict, err)
+		default:
+			respondErr(w, r, http.StatusInternalServerError, "failed to update poll", err)
+		}
+		return
+	}
+	s.publishPollEvent(p.ID, "updated")
+	if len(terms.Warnings) > 0 {
+		respond(w, r, http.StatusOK, terms)
+		return
+	}
+	respond(w, r, http.StatusOK, nil)
+}
+
 // Deleting a poll
 func (s *Server) handlePollsDelete(w http.ResponseWriter, r *http.Request) {
 
@@ -106,7 +896,7 @@ func (s *Server) handlePollsDelete(w http.ResponseWriter, r *http.Request) {
 	defer session.Close()
 
 	// create an on=bject referring to the polls collection
-	c := session.DB("ballots").C("polls")
+	c := s.polls(session)
 
 	// parse the url path into an instance of the Path type
 	p := NewPath(r.URL.Path)
@@ -118,10 +908,21 @@ func (s *Server) handlePollsDelete(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// delete the poll with the given id and handle any errors
-	if err := c.RemoveId(bson.ObjectIdHex(p.ID)); err != nil{
+	// mark the poll with the given id deleted, or remove it when purging, and handle any errors
+	var err error
+	if r.URL.Query().Get("purge") == "true" {
+		err = c.RemoveId(bson.ObjectIdHex(p.ID))
+	} else {
+		err = c.Update(notDeleted(bson.M{"_id": bson.ObjectIdHex(p.ID)}), bson.M{"$set": bson.M{"deleted_at": time.Now().UTC()}})
+	}
+	if err == mgo.ErrNotFound {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	if err != nil {
 		respondErr(w, r, http.StatusInternalServerError, "failed to delete poll", err)
 		return
 	}
-	respond(w, r, http.StatusOK, nil)	
+	s.publishPollEvent(p.ID, "deleted")
+	respond(w, r, http.StatusOK, nil)
 }
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"net/http"
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Race mode streams only what a broadcast ticker cares about:
+// changes to the rank order of the options and changes of the leader.
+// Count updates that don't move anyone are not sent at all.
+
+// racePing is how often an idle stream is kept alive
+const racePing = 15 * time.Second
+
+// standing is an option's place in the race
+type standing struct {
+	Option string `json:"option"`
+	Votes  int    `json:"votes"`
+}
+
+// move describes an option changing place, ranks count from 1
+type move struct {
+	Option string `json:"option"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+}
+
+// rankEvent is sent when the rank order changes
+type rankEvent struct {
+	Ranking []standing `json:"ranking"`
+	Moves   []move     `json:"moves"`
+}
+
+// leadEvent is sent when a different option takes the lead
+type leadEvent struct {
+	Leader   string `json:"leader"`
+	Previous string `json:"previous,omitempty"`
+	Votes    int    `json:"votes"`
+}
+
+// rank orders the results by votes, ties broken by option name so the order is stable
+func rank(results map[string]int, options []string) []standing {
+	ranking := make([]standing, 0, len(options))
+	for _, option := range options {
+		ranking = append(ranking, standing{Option: option, Votes: results[option]})
+	}
+	sort.SliceStable(ranking, func(i, j int) bool {
+		if ranking[i].Votes != ranking[j].Votes {
+			return ranking[i].Votes > ranking[j].Votes
+		}
+		return ranking[i].Option < ranking[j].Option
+	})
+	return ranking
+}
+
+// ranked ranks the options of p as they are shown, those under its minimum
+// share grouped like in the results
+func ranked(p poll) []standing {
+	options := newDisplay(&p).options(p.Options)
+	applyDisplay(&p)
+	return rank(p.Results, options)
+}
+
+// moves compares two rankings, returning every option whose place changed
+func moves(prev, next []standing) []move {
+	was := make(map[string]int, len(prev))
+	for i, s := range prev {
+		was[s.Option] = i + 1
+	}
+	var changed []move
+	for i, s := range next {
+		if from, ok := was[s.Option]; !ok || from != i+1 {
+			changed = append(changed, move{Option: s.Option, From: from, To: i + 1})
+		}
+	}
+	return changed
+}
+
+// GET /polls/{id}/race streams rank and lead changes as server-sent events
+func (s *Server) handlePollRace(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	p, changes, stop, err := s.watchers.subscribe(bson.ObjectIdHex(id))
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	defer stop()
+	events, ok := newSSEWriter(w, r)
+	if !ok {
+		return
+	}
+	ranking := ranked(p)
+	if err := events.Event("snapshot", rankEvent{Ranking: ranking}); err != nil {
+		return
+	}
+	ping := time.NewTicker(racePing)
+	defer ping.Stop()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ping.C:
+			if err := events.Ping(); err != nil {
+				return
+			}
+		case latest := <-changes:
+			next := ranked(latest)
+			changed := moves(ranking, next)
+			if len(changed) == 0 {
+				continue
+			}
+			if err := events.Event("rank", rankEvent{Ranking: next, Moves: changed}); err != nil {
+				return
+			}
+			if len(next) > 0 && (len(ranking) == 0 || next[0].Option != ranking[0].Option) {
+				lead := leadEvent{Leader: next[0].Option, Votes: next[0].Votes}
+				if len(ranking) > 0 {
+					lead.Previous = ranking[0].Option
+				}
+				if err := events.Event("lead", lead); err != nil {
+					return
+				}
+			}
+			ranking = next
+		}
+	}
+}
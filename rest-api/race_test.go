@@ -0,0 +1,37 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRanked(t *testing.T) {
+	tests := []struct {
+		name string
+		p    poll
+		want []standing
+	}{
+		{
+			name: "every option without a minimum share",
+			p:    poll{Options: []string{"a", "b", "c"}, Results: map[string]int{"a": 3, "b": 5}},
+			want: []standing{{"b", 5}, {"a", 3}, {"c", 0}},
+		},
+		{
+			name: "the options under the minimum share race as one",
+			p:    poll{Options: []string{"a", "b", "c", "d"}, Results: map[string]int{"a": 45, "b": 40, "c": 8, "d": 7}, MinShare: 10},
+			want: []standing{{"a", 45}, {"b", 40}, {"Other", 15}},
+		},
+		{
+			name: "an option named Other races on its own",
+			p:    poll{Options: []string{"a", "Other", "c"}, Results: map[string]int{"a": 80, "Other": 15, "c": 5}, MinShare: 10},
+			want: []standing{{"a", 80}, {"Other", 15}, {"Other (2)", 5}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ranked(tt.p); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("ranked = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
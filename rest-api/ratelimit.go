@@ -0,0 +1,105 @@
+package main
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Every client, an IP address or an API key, has a token bucket that refills
+// at the limiter's rate up to its burst, and a request takes a token, so a
+// misbehaving client is answered 429 before it reaches MongoDB. A results
+// stream takes a single token for as long as it is open.
+
+// limiterSweep is how often the buckets that refilled are dropped, so
+// clients that went away don't keep memory
+const limiterSweep = time.Minute
+
+// limiter is a token bucket per client, a nil limiter allows everything
+type limiter struct {
+	rate  float64 // tokens a second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*tokenBucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type tokenBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// newLimiter returns a limiter of rate requests a second and bursts of up to
+// burst, nil when rate isn't positive
+func newLimiter(rate float64, burst int) *limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*tokenBucket), now: time.Now}
+}
+
+// allow takes a token from the bucket of client. When it is empty it returns
+// false and how long until the next token.
+func (l *limiter) allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= limiterSweep {
+		for c, b := range l.buckets {
+			if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+				delete(l.buckets, c)
+			}
+		}
+		l.lastSweep = now
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &tokenBucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+// clientIP returns the address r came from. Behind a proxy that appends to
+// X-Forwarded-For, trustForwarded takes the address the proxy saw instead.
+func clientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			return strings.TrimSpace(hops[len(hops)-1]) // the earlier hops are whatever the client sent
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// respondTooMany tells the client to retry once its bucket has a token again
+func respondTooMany(w http.ResponseWriter, r *http.Request, wait time.Duration) {
+	seconds := int(math.Ceil(wait.Seconds()))
+	if seconds < 1 {
+		seconds = 1
+	}
+	w.Header().Set("Retry-After", strconv.Itoa(seconds))
+	respondErr(w, r, http.StatusTooManyRequests, "too many requests, retry in ", seconds, "s")
+}
@@ -38,4 +38,4 @@ func respondErr(w http.ResponseWriter, r *http.Request, status int, args ...inte
 // HTTP-error specific helper to generate the correct message
 func respondHTTPErr(w http.ResponseWriter, r *http.Request, status int) {
 	respondErr(w, r, status, http.StatusText(status))
-}
\ No newline at end of file
+}
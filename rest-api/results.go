@@ -0,0 +1,180 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// otherOption collects the options hidden by a poll's minimum display share
+const otherOption = "Other"
+
+// defaultPrecision is the number of decimals shares are rounded to when a poll doesn't say
+const defaultPrecision = 1
+
+// applyDisplay prepares a poll's results for public display.
+// Shares are computed as percentages rounded to the poll's precision,
+// and options below the poll's minimum share are folded into "Other", in
+// the breakdowns by area, source and language and the metrics too.
+// Doing this here means every client renders the same numbers.
+func applyDisplay(p *poll) {
+	d := newDisplay(p)
+	if len(p.Results) > 0 {
+		counts := make(map[string]float64, len(p.Results))
+		for option, n := range p.Results {
+			counts[option] = float64(n)
+		}
+		grouped, shares := d.counts(counts)
+		p.Results = make(map[string]int, len(grouped))
+		for option, n := range grouped {
+			p.Results[option] = int(n)
+		}
+		p.Shares = shares
+	}
+	if len(p.WeightedResults) > 0 {
+		p.WeightedResults, p.WeightedShares = d.counts(p.WeightedResults)
+	}
+	if len(d.grouped) > 0 {
+		p.Grouped = d.other
+		p.GeoResults = d.breakdown(p.GeoResults)
+		p.SourceResults = d.breakdown(p.SourceResults)
+		p.LanguageResults = d.breakdown(p.LanguageResults)
+		p.Metrics = d.metrics(p.Metrics)
+	}
+}
+
+// display is how the results of a poll are shown: the options under its
+// minimum share of the raw counts are grouped into one bucket, the same ones
+// in every count shown, weighted or broken down
+type display struct {
+	grouped   map[string]bool
+	other     string // the label of the bucket, which no option of the poll has
+	precision int
+}
+
+// newDisplay picks the options of p grouped from its raw results, before
+// they are prepared for display
+func newDisplay(p *poll) display {
+	d := display{grouped: make(map[string]bool), other: otherOption, precision: defaultPrecision}
+	if p.Precision != nil {
+		d.precision = *p.Precision
+	}
+	var total float64
+	for _, n := range p.Results {
+		total += float64(n)
+	}
+	if p.MinShare > 0 && total > 0 {
+		for option, n := range p.Results {
+			if float64(n)/total*100 < p.MinShare {
+				d.grouped[option] = true
+			}
+		}
+	}
+	taken := make(map[string]bool, len(p.Options)+len(p.Results))
+	for _, o := range p.Options {
+		taken[o] = true
+	}
+	for o := range p.Results {
+		taken[o] = true
+	}
+	for o := range p.WeightedResults {
+		taken[o] = true
+	}
+	for i := 2; taken[d.other]; i++ {
+		d.other = fmt.Sprintf("%s (%d)", otherOption, i)
+	}
+	return d
+}
+
+// label returns what option is shown as
+func (d display) label(option string) string {
+	if d.grouped[option] {
+		return d.other
+	}
+	return option
+}
+
+// counts groups counts by label and returns them with their rounded shares,
+// no shares when there is nothing counted
+func (d display) counts(counts map[string]float64) (map[string]float64, map[string]float64) {
+	var total float64
+	grouped := make(map[string]float64, len(counts))
+	for option, n := range counts {
+		grouped[d.label(option)] += n
+		total += n
+	}
+	if total == 0 {
+		return grouped, nil
+	}
+	shares := make(map[string]float64, len(grouped))
+	for option, n := range grouped {
+		shares[option] = round(n/total*100, d.precision)
+	}
+	return grouped, shares
+}
+
+// ints groups counts by label, like counts without the shares
+func (d display) ints(counts map[string]int) map[string]int {
+	if counts == nil {
+		return nil
+	}
+	grouped := make(map[string]int, len(counts))
+	for option, n := range counts {
+		grouped[d.label(option)] += n
+	}
+	return grouped
+}
+
+// breakdown groups the counts of every area, source or language by label
+func (d display) breakdown(counts map[string]map[string]int) map[string]map[string]int {
+	if counts == nil {
+		return nil
+	}
+	grouped := make(map[string]map[string]int, len(counts))
+	for key, c := range counts {
+		grouped[key] = d.ints(c)
+	}
+	return grouped
+}
+
+// metrics groups the values of every metric by label, they add up like the votes
+func (d display) metrics(metrics map[string]map[string]float64) map[string]map[string]float64 {
+	if metrics == nil {
+		return nil
+	}
+	grouped := make(map[string]map[string]float64, len(metrics))
+	for name, values := range metrics {
+		grouped[name], _ = d.counts(values)
+	}
+	return grouped
+}
+
+// options returns the labels of options, once each
+func (d display) options(options []string) []string {
+	var labels []string
+	seen := make(map[string]bool, len(options))
+	for _, o := range options {
+		if l := d.label(o); !seen[l] {
+			seen[l] = true
+			labels = append(labels, l)
+		}
+	}
+	return labels
+}
+
+// round rounds v to the given number of decimals
+func round(v float64, decimals int) float64 {
+	pow := math.Pow(10, float64(decimals))
+	return math.Round(v*pow) / pow
+}
+
+// validateDisplay checks the display settings of a poll
+func validateDisplay(minShare float64, precision *int) error {
+	if minShare < 0 || minShare > 100 {
+		return errors.New("min_share must be a percentage between 0 and 100")
+	}
+	if precision != nil && (*precision < 0 || *precision > 6) {
+		return errors.New("precision must be between 0 and 6 decimals")
+	}
+	return nil
+}
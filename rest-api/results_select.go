@@ -0,0 +1,291 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Tickers and widgets poll results often and only need a total or the shares,
+// dashboards want everything. ?fields= trims the response to the named fields
+// and ?include= adds the details that cost extra queries or bytes.
+
+// resultFields are the fields of a results response that ?fields= can pick from
+var resultFields = []string{"results", "weighted_results", "shares", "weighted_shares", "total"}
+
+// Details that ?include= can add to a results response
+const (
+	includeTimeseries = "timeseries"  // per-minute points from the counter, for the last timeseriesSpan
+	includeSources    = "sources"     // the votes per country or region, on polls with a geo aggregation
+	includeMetrics    = "metrics"     // the custom results computed for the poll's type
+	includeChannels   = "channels"    // the votes per source they were cast on: twitter, youtube, sms...
+	includeLanguages  = "languages"   // the votes per language
+	includeHourly     = "hourly"      // the votes per hour of the poll's time zone, for the last hourlySpan
+	includeDaily      = "daily"       // the votes per day of the poll's time zone
+	includeNotes      = "annotations" // the moments marked on the poll's results, see annotations.go
+)
+
+var resultIncludes = []string{includeTimeseries, includeSources, includeMetrics, includeChannels, includeLanguages, includeHourly, includeDaily, includeNotes}
+
+// timeseriesSpan is how far back include=timeseries goes
+const timeseriesSpan = 24 * time.Hour
+
+// hourlySpan is how far back include=hourly goes
+const hourlySpan = 7 * 24 * time.Hour
+
+// selection is what a client asked to get back
+type selection struct {
+	fields  map[string]bool // nil for every field
+	include map[string]bool
+}
+
+// parseSelection reads ?fields= and ?include=, both comma separated
+func parseSelection(r *http.Request) (selection, error) {
+	var sel selection
+	q := r.URL.Query()
+	if v := q.Get("fields"); v != "" {
+		sel.fields = make(map[string]bool)
+		for _, f := range strings.Split(v, ",") {
+			f = strings.TrimSpace(f)
+			if !contains(resultFields, f) {
+				return sel, fmt.Errorf("unknown field %q, expected some of %s", f, strings.Join(resultFields, ","))
+			}
+			sel.fields[f] = true
+		}
+	}
+	if v := q.Get("include"); v != "" {
+		sel.include = make(map[string]bool)
+		for _, inc := range strings.Split(v, ",") {
+			inc = strings.TrimSpace(inc)
+			if !contains(resultIncludes, inc) {
+				return sel, fmt.Errorf("unknown include %q, expected some of %s", inc, strings.Join(resultIncludes, ","))
+			}
+			sel.include[inc] = true
+		}
+	}
+	return sel, nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// apply returns the fields of e the selection keeps, keyed by their JSON names
+func (sel selection) apply(e resultsEvent) (map[string]interface{}, error) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err := json.Unmarshal(b, &all); err != nil {
+		return nil, err
+	}
+	out := make(map[string]interface{}, len(all))
+	for name, v := range all {
+		if sel.fields == nil || sel.fields[name] {
+			out[name] = v
+		}
+	}
+	return out, nil
+}
+
+// point is one bucket of the time series the counter writes to results_timeseries
+type point struct {
+	Option   string    `bson:"option" json:"option"`
+	Time     time.Time `bson:"bucket" json:"time"`
+	Count    int       `bson:"count" json:"count"`
+	Weighted float64   `bson:"weighted" json:"weighted,omitempty"`
+}
+
+// calendarPoint is one hour or day of the results in the poll's time zone,
+// which the counter writes to results_calendar
+type calendarPoint struct {
+	Option   string    `bson:"option" json:"option"`
+	Local    string    `bson:"local" json:"local"` // the hour or day as it reads there: 2024-10-14T09+02:00 or 2024-10-14
+	Start    time.Time `bson:"start" json:"start"`
+	Count    int       `bson:"count" json:"count"`
+	Weighted float64   `bson:"weighted" json:"weighted,omitempty"`
+}
+
+// groupPoints sums the points of the options d groups into one per bucket
+func groupPoints(d display, points []point) []point {
+	if len(d.grouped) == 0 {
+		return points
+	}
+	grouped := make([]point, 0, len(points))
+	at := make(map[string]int) // index in grouped by bucket and label
+	for _, pt := range points {
+		pt.Option = d.label(pt.Option)
+		key := pt.Time.String() + "/" + pt.Option
+		if i, ok := at[key]; ok {
+			grouped[i].Count += pt.Count
+			grouped[i].Weighted += pt.Weighted
+			continue
+		}
+		at[key] = len(grouped)
+		grouped = append(grouped, pt)
+	}
+	return grouped
+}
+
+// groupCalendar sums the hours or days of the options d groups into one per period
+func groupCalendar(d display, points []calendarPoint) []calendarPoint {
+	if len(d.grouped) == 0 {
+		return points
+	}
+	grouped := make([]calendarPoint, 0, len(points))
+	at := make(map[string]int)
+	for _, pt := range points {
+		pt.Option = d.label(pt.Option)
+		key := pt.Local + "/" + pt.Option
+		if i, ok := at[key]; ok {
+			grouped[i].Count += pt.Count
+			grouped[i].Weighted += pt.Weighted
+			continue
+		}
+		at[key] = len(grouped)
+		grouped = append(grouped, pt)
+	}
+	return grouped
+}
+
+// calendar returns the poll's results per period, hour or day, in its time
+// zone since since, the zero time for all of them
+func calendar(db *mgo.Database, p poll, period string, since time.Time) ([]calendarPoint, error) {
+	zone, err := time.LoadLocation(p.Timezone)
+	if err != nil {
+		zone = time.UTC
+	}
+	q := bson.M{"poll": p.ID.Hex(), "period": period, "timezone": zone.String()}
+	if !since.IsZero() {
+		q["start"] = bson.M{"$gte": since}
+	}
+	var points []calendarPoint
+	if err := db.C("results_calendar").Find(q).Sort("start", "option").All(&points); err != nil {
+		return nil, err
+	}
+	for i := range points {
+		points[i].Start = points[i].Start.In(zone)
+	}
+	return points, nil
+}
+
+// GET /polls/{id}/results returns a poll's results prepared for display,
+// narrowed by ?fields= and extended by ?include=
+func (s *Server) handlePollResults(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	sel, err := parseSelection(r)
+	if err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	db := session.DB(s.database)
+
+	p, err := s.currentPoll(db.C(s.pollsCollection), bson.ObjectIdHex(id))
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	body, err := sel.apply(newResultsEvent(p))
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, err)
+		return
+	}
+	// what's included is grouped like the results, the options they hide stay hidden
+	d := newDisplay(&p)
+	if sel.include[includeTimeseries] {
+		var points []point
+		q := bson.M{"poll": id, "bucket": bson.M{"$gte": time.Now().Add(-timeseriesSpan)}}
+		if err := db.C("results_timeseries").Find(q).Sort("bucket", "option").All(&points); err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load time series", err)
+			return
+		}
+		body[includeTimeseries] = groupPoints(d, points)
+	}
+	if sel.include[includeHourly] {
+		points, err := calendar(db, p, "hour", time.Now().Add(-hourlySpan))
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load the hourly results", err)
+			return
+		}
+		body[includeHourly] = groupCalendar(d, points)
+	}
+	if sel.include[includeDaily] {
+		points, err := calendar(db, p, "day", time.Time{})
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load the daily results", err)
+			return
+		}
+		body[includeDaily] = groupCalendar(d, points)
+	}
+	if sel.include[includeSources] {
+		body[includeSources] = sources(d.breakdown(p.GeoResults))
+	}
+	if sel.include[includeMetrics] {
+		body[includeMetrics] = d.metrics(p.Metrics)
+	}
+	if sel.include[includeChannels] {
+		body[includeChannels] = d.breakdown(p.SourceResults)
+	}
+	if sel.include[includeLanguages] {
+		body[includeLanguages] = d.breakdown(p.LanguageResults)
+	}
+	if sel.include[includeNotes] {
+		notes, err := s.annotationsOf(session, id)
+		if err != nil {
+			respondErr(w, r, http.StatusInternalServerError, "failed to load the annotations", err)
+			return
+		}
+		for i := range notes {
+			notes[i].Options = d.options(notes[i].Options)
+		}
+		body[includeNotes] = notes
+	}
+	respond(w, r, http.StatusOK, body)
+}
+
+// source is the votes from one country or region
+type source struct {
+	Area    string         `json:"area"`
+	Total   int            `json:"total"`
+	Results map[string]int `json:"results"`
+}
+
+// sources lists the geo results with the most votes first
+func sources(geo map[string]map[string]int) []source {
+	list := make([]source, 0, len(geo))
+	for area, results := range geo {
+		src := source{Area: area, Results: results}
+		for _, n := range results {
+			src.Total += n
+		}
+		list = append(list, src)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Total != list[j].Total {
+			return list[i].Total > list[j].Total
+		}
+		return list[i].Area < list[j].Area
+	})
+	return list
+}
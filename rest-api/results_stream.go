@@ -0,0 +1,91 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// The results stream is the lightweight way for a dashboard to stay current:
+// a plain EventSource in the browser, no WebSocket upgrade, and an event only
+// when the tallies actually changed.
+
+// resultsInterval is how often the poll is checked for new votes
+const resultsInterval = 1 * time.Second
+
+// resultsPing is how often an idle stream is kept alive
+const resultsPing = 15 * time.Second
+
+// resultsEvent carries a poll's tallies, prepared for display like GET /polls/{id}
+type resultsEvent struct {
+	Results         map[string]int     `json:"results"`
+	WeightedResults map[string]float64 `json:"weighted_results,omitempty"`
+	Shares          map[string]float64 `json:"shares,omitempty"`
+	WeightedShares  map[string]float64 `json:"weighted_shares,omitempty"`
+	Total           int                `json:"total"`
+	// Grouped is the label of the options hidden by the poll's minimum share
+	Grouped string `json:"grouped,omitempty"`
+}
+
+func newResultsEvent(p poll) resultsEvent {
+	var total int
+	for _, n := range p.Results {
+		total += n
+	}
+	applyDisplay(&p)
+	return resultsEvent{
+		Results:         p.Results,
+		WeightedResults: p.WeightedResults,
+		Shares:          p.Shares,
+		WeightedShares:  p.WeightedShares,
+		Total:           total,
+		Grouped:         p.Grouped,
+	}
+}
+
+// GET /polls/{id}/results/stream sends the results as server-sent events every time they change.
+// ?fields= narrows the events like it does GET /polls/{id}/results.
+func (s *Server) handlePollResultsStream(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	sel, err := parseSelection(r)
+	if err != nil {
+		respondErr(w, r, http.StatusBadRequest, err)
+		return
+	}
+	if sel.include != nil {
+		respondErr(w, r, http.StatusBadRequest, "include isn't supported on the results stream, use GET /polls/{id}/results")
+		return
+	}
+	p, changes, stop, err := s.watchers.subscribe(bson.ObjectIdHex(id))
+	if err != nil {
+		if err == mgo.ErrNotFound {
+			respondHTTPErr(w, r, http.StatusNotFound)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to load poll", err)
+		return
+	}
+	defer stop()
+	events, ok := newSSEWriter(w, r)
+	if !ok {
+		return
+	}
+	send := func(p poll) error {
+		body, err := sel.apply(newResultsEvent(p))
+		if err != nil {
+			return err
+		}
+		return events.Event("results", body)
+	}
+	// the pings stop with the stream, they can't be written once the handler returned
+	ctx, cancel := context.WithCancel(r.Context())
+	defer cancel()
+	go events.keepAlive(ctx, resultsPing)
+	followResults(ctx, p, changes, send)
+}
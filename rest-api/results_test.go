@@ -0,0 +1,124 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyDisplay(t *testing.T) {
+	tests := []struct {
+		name     string
+		p        poll
+		results  map[string]int
+		weighted map[string]float64
+		shares   map[string]float64
+		grouped  string
+	}{
+		{
+			name:    "without a minimum share every option is shown",
+			p:       poll{Options: []string{"a", "b"}, Results: map[string]int{"a": 3, "b": 1}},
+			results: map[string]int{"a": 3, "b": 1},
+			shares:  map[string]float64{"a": 75, "b": 25},
+		},
+		{
+			name:    "the options under the minimum share grouped",
+			p:       poll{Options: []string{"a", "b", "c"}, Results: map[string]int{"a": 90, "b": 6, "c": 4}, MinShare: 10},
+			results: map[string]int{"a": 90, "Other": 10},
+			shares:  map[string]float64{"a": 90, "Other": 10},
+			grouped: "Other",
+		},
+		{
+			name:    "an option named Other isn't merged into the bucket",
+			p:       poll{Options: []string{"a", "Other", "c"}, Results: map[string]int{"a": 80, "Other": 15, "c": 5}, MinShare: 10},
+			results: map[string]int{"a": 80, "Other": 15, "Other (2)": 5},
+			shares:  map[string]float64{"a": 80, "Other": 15, "Other (2)": 5},
+			grouped: "Other (2)",
+		},
+		{
+			name: "the weighted results grouped like the raw ones",
+			p: poll{Options: []string{"a", "b"}, MinShare: 10,
+				Results:         map[string]int{"a": 95, "b": 5},
+				WeightedResults: map[string]float64{"a": 50, "b": 50}},
+			results:  map[string]int{"a": 95, "Other": 5},
+			weighted: map[string]float64{"a": 50, "Other": 50},
+			shares:   map[string]float64{"a": 95, "Other": 5},
+			grouped:  "Other",
+		},
+		{
+			name:    "nothing grouped without votes",
+			p:       poll{Options: []string{"a", "b"}, Results: map[string]int{"a": 0}, MinShare: 10},
+			results: map[string]int{"a": 0},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := tt.p
+			applyDisplay(&p)
+			if !reflect.DeepEqual(p.Results, tt.results) {
+				t.Errorf("results %v, want %v", p.Results, tt.results)
+			}
+			if tt.weighted != nil && !reflect.DeepEqual(p.WeightedResults, tt.weighted) {
+				t.Errorf("weighted results %v, want %v", p.WeightedResults, tt.weighted)
+			}
+			if !reflect.DeepEqual(p.Shares, tt.shares) {
+				t.Errorf("shares %v, want %v", p.Shares, tt.shares)
+			}
+			if p.Grouped != tt.grouped {
+				t.Errorf("grouped as %q, want %q", p.Grouped, tt.grouped)
+			}
+		})
+	}
+}
+
+func TestIncludesGroupedLikeTheResults(t *testing.T) {
+	p := poll{Options: []string{"a", "b", "c"}, Results: map[string]int{"a": 90, "b": 6, "c": 4}, MinShare: 10}
+	d := newDisplay(&p)
+	at := time.Date(2024, 10, 14, 9, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"time series", groupPoints(d, []point{{"a", at, 5, 0}, {"b", at, 2, 1}, {"c", at, 1, 1}, {"b", at.Add(time.Minute), 1, 0}}),
+			[]point{{"a", at, 5, 0}, {"Other", at, 3, 2}, {"Other", at.Add(time.Minute), 1, 0}}},
+		{"hours", groupCalendar(d, []calendarPoint{{"b", "2024-10-14T09Z", at, 2, 0}, {"c", "2024-10-14T09Z", at, 3, 0}}),
+			[]calendarPoint{{"Other", "2024-10-14T09Z", at, 5, 0}}},
+		{"areas", d.breakdown(map[string]map[string]int{"GB": {"a": 9, "b": 1, "c": 1}}),
+			map[string]map[string]int{"GB": {"a": 9, "Other": 2}}},
+		{"metrics", d.metrics(map[string]map[string]float64{"retracted": {"b": 1, "c": 2}}),
+			map[string]map[string]float64{"retracted": {"Other": 3}}},
+		{"annotated options", d.options([]string{"a", "b", "c"}), []string{"a", "Other"}},
+		{"no breakdown", d.breakdown(nil), map[string]map[string]int(nil)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !reflect.DeepEqual(tt.got, tt.want) {
+				t.Errorf("got %v, want %v", tt.got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateAnnotation(t *testing.T) {
+	p := poll{Options: []string{"cats", "dogs"}}
+	tests := []struct {
+		name string
+		a    annotation
+		err  bool
+	}{
+		{"about the whole poll", annotation{Text: "debate starts"}, false},
+		{"about an option", annotation{Text: "TV mention", Options: []string{"cats"}}, false},
+		{"without a text", annotation{Text: "  "}, true},
+		{"a text too long", annotation{Text: strings.Repeat("x", maxAnnotationText+1)}, true},
+		{"an option the poll hasn't", annotation{Text: "bots", Options: []string{"birds"}}, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateAnnotation(&tt.a, &p); (err != nil) != tt.err {
+				t.Errorf("validateAnnotation = %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// sseWriter writes server-sent events to a streaming response.
+// Events and pings may be sent from different goroutines.
+type sseWriter struct {
+	mu sync.Mutex
+	w  http.ResponseWriter
+	f  http.Flusher
+}
+
+// newSSEWriter prepares w for streaming events.
+// ok is false when the ResponseWriter can't flush, in which case an error has already been written.
+func newSSEWriter(w http.ResponseWriter, r *http.Request) (*sseWriter, bool) {
+	f, ok := w.(http.Flusher)
+	if !ok {
+		respondErr(w, r, http.StatusInternalServerError, "streaming not supported")
+		return nil, false
+	}
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	f.Flush()
+	return &sseWriter{w: w, f: f}, true
+}
+
+// Event sends data encoded as JSON under the given event name
+func (s *sseWriter) Event(name string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprintf(s.w, "event: %s\ndata: %s\n\n", name, b); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+// Ping sends a comment so proxies don't close an idle stream
+func (s *sseWriter) Ping() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+		return err
+	}
+	s.f.Flush()
+	return nil
+}
+
+// keepAlive pings every interval until ctx is done or a ping fails
+func (s *sseWriter) keepAlive(ctx context.Context, every time.Duration) {
+	ping := time.NewTicker(every)
+	defer ping.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ping.C:
+			if err := s.Ping(); err != nil {
+				return
+			}
+		}
+	}
+}
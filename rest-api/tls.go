@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// tlsFiles are the files a TLS client connection is configured from, all optional
+type tlsFiles struct {
+	ca, cert, key string
+}
+
+// config returns the TLS configuration, or nil when no file is set and enable is false
+func (f tlsFiles) config(enable bool) (*tls.Config, error) {
+	if !enable && f.ca == "" && f.cert == "" && f.key == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	if f.ca != "" {
+		pem, err := ioutil.ReadFile(f.ca)
+		if err != nil {
+			return nil, err
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no PEM certificates in %s", f.ca)
+		}
+	}
+	if f.cert != "" || f.key != "" {
+		pair, err := tls.LoadX509KeyPair(f.cert, f.key)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// dialMongo connects to the MongoDB at addr, a host or a mongodb:// URI with
+// the credentials, authSource and replicaSet. mgo doesn't know the ssl and
+// tls URI options, they are handled here. MONGO_USERNAME and MONGO_PASSWORD
+// replace the credentials in addr.
+func dialMongo(addr string, files tlsFiles) (*mgo.Session, error) {
+	useTLS := false
+	if i := strings.Index(addr, "?"); i >= 0 {
+		query, err := url.ParseQuery(addr[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range []string{"ssl", "tls"} {
+			if query.Get(k) == "true" {
+				useTLS = true
+			}
+			query.Del(k)
+		}
+		addr = addr[:i]
+		if len(query) > 0 {
+			addr += "?" + query.Encode()
+		}
+	}
+	info, err := mgo.ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = 10 * time.Second
+	if u := os.Getenv("MONGO_USERNAME"); u != "" {
+		info.Username = u
+	}
+	if p := os.Getenv("MONGO_PASSWORD"); p != "" {
+		info.Password = p
+	}
+	cfg, err := files.config(useTLS)
+	if err != nil {
+		return nil, fmt.Errorf("mongo TLS: %v", err)
+	}
+	if cfg != nil {
+		dialer := &net.Dialer{Timeout: info.Timeout}
+		info.DialServer = func(a *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, "tcp", a.String(), cfg)
+		}
+	}
+	log.Println("Dialing mongo", strings.Join(info.Addrs, ","))
+	return mgo.DialWithInfo(info)
+}
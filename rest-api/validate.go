@@ -0,0 +1,195 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A poll's options are tracked on the Twitter stream as they are written, so
+// they have to follow Twitter's track rules, and options that are common words
+// or overlap the options of other polls count many tweets that aren't votes.
+// Polls breaking the rules are rejected, the rest are created with warnings.
+// POST /polls/validate checks a poll without creating it.
+
+// Twitter's rules for the track parameter
+const (
+	maxTrackTerms = 400 // per stream, so per account
+	maxTermBytes  = 60
+)
+
+// commonWords are options so frequent they track a large share of all tweets
+var commonWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "at": true, "be": true, "for": true, "i": true,
+	"in": true, "is": true, "it": true, "me": true, "my": true, "of": true, "on": true, "or": true,
+	"rt": true, "that": true, "the": true, "this": true, "to": true, "with": true, "you": true,
+}
+
+// termIssue is a problem with one of a poll's options
+type termIssue struct {
+	Option  string `json:"option,omitempty"`
+	Problem string `json:"problem"`
+	Poll    string `json:"poll,omitempty"` // the other poll the option overlaps, if any
+}
+
+// validation is the response of POST /polls/validate
+type validation struct {
+	Valid    bool        `json:"valid"`
+	Errors   []termIssue `json:"errors,omitempty"`   // the poll is rejected
+	Warnings []termIssue `json:"warnings,omitempty"` // the poll is created, but likely counts more than its votes
+}
+
+// validateTerms checks the options of p against Twitter's track rules and the
+// options of others, the polls being streamed
+func validateTerms(p *poll, others []*poll) validation {
+	var v validation
+	own := make(map[string]bool, len(p.Options))
+	var unique []string // the options, each once
+	for _, o := range p.Options {
+		term := strings.TrimSpace(o)
+		lower := strings.ToLower(term)
+		switch {
+		case term == "":
+			v.Errors = append(v.Errors, termIssue{Option: o, Problem: "the option is blank"})
+		case strings.Contains(term, ","):
+			v.Errors = append(v.Errors, termIssue{Option: o, Problem: "commas separate the terms Twitter tracks"})
+		case len(term) > maxTermBytes:
+			v.Errors = append(v.Errors, termIssue{Option: o, Problem: fmt.Sprintf("Twitter tracks terms of up to %d bytes", maxTermBytes)})
+		case strings.HasPrefix(term, "@") && !isHandle(term):
+			v.Errors = append(v.Errors, termIssue{Option: o, Problem: "a handle has up to 15 letters, digits and underscores"})
+		case own[lower]:
+			v.Warnings = append(v.Warnings, termIssue{Option: o, Problem: "the option is listed twice"})
+		case commonWords[lower]:
+			v.Warnings = append(v.Warnings, termIssue{Option: o, Problem: "a common word, tracking it streams a large share of all tweets"})
+		case allCommon(strings.Fields(lower)):
+			v.Warnings = append(v.Warnings, termIssue{Option: o, Problem: "only common words, Twitter streams every tweet with all of them in any order"})
+		case utf8.RuneCountInString(term) < 3 && isWord(term):
+			v.Warnings = append(v.Warnings, termIssue{Option: o, Problem: "a short option also counts for the words it is part of"})
+		}
+		if !own[lower] {
+			unique = append(unique, o)
+		}
+		own[lower] = true
+	}
+	// options are matched anywhere in the text, so one inside another gets its votes too
+	for i, a := range unique {
+		for _, b := range unique[i+1:] {
+			if issue, ok := overlap(a, b); ok {
+				v.Warnings = append(v.Warnings, issue)
+			}
+		}
+	}
+	tracked := make(map[string]bool)
+	for _, other := range others {
+		if other.ID == p.ID {
+			continue
+		}
+		for _, b := range other.Options {
+			if other.Account == p.Account {
+				tracked[strings.ToLower(b)] = true
+			}
+			for _, a := range unique {
+				if issue, ok := overlap(a, b); ok {
+					issue.Poll = other.ID.Hex()
+					v.Warnings = append(v.Warnings, issue)
+				}
+			}
+		}
+	}
+	for lower := range own {
+		tracked[lower] = true
+	}
+	if len(tracked) > maxTrackTerms {
+		v.Warnings = append(v.Warnings, termIssue{Problem: fmt.Sprintf("the stream would track %d terms, Twitter tracks %d", len(tracked), maxTrackTerms)})
+	}
+	v.Valid = len(v.Errors) == 0
+	return v
+}
+
+// overlap reports whether a tweet mentioning b is also a vote for a, or the other way around.
+// Handles are matched in the mentions, so they only overlap the same handle.
+func overlap(a, b string) (termIssue, bool) {
+	la, lb := strings.ToLower(strings.TrimSpace(a)), strings.ToLower(strings.TrimSpace(b))
+	switch {
+	case la == "" || lb == "":
+		return termIssue{}, false
+	case la == lb:
+		return termIssue{Option: a, Problem: "also an option of another poll, the two share their votes"}, true
+	case strings.HasPrefix(la, "@") || strings.HasPrefix(lb, "@"):
+		return termIssue{}, false
+	case strings.Contains(la, lb):
+		return termIssue{Option: a, Problem: fmt.Sprintf("contains %q, so its votes also count for %q", b, b)}, true
+	case strings.Contains(lb, la):
+		return termIssue{Option: a, Problem: fmt.Sprintf("is part of %q, so the votes for %q also count for it", b, b)}, true
+	}
+	return termIssue{}, false
+}
+
+// isHandle reports whether option is an @ and a valid screen name
+func isHandle(option string) bool {
+	name := strings.TrimPrefix(option, "@")
+	if len(name) == len(option) || name == "" || len(name) > 15 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// allCommon reports whether the words of a phrase, more than one, are all common words
+func allCommon(words []string) bool {
+	if len(words) < 2 {
+		return false
+	}
+	for _, w := range words {
+		if !commonWords[w] {
+			return false
+		}
+	}
+	return true
+}
+
+// isWord reports whether s only has letters and digits, emoji options are short on purpose
+func isWord(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// streamedPolls returns the polls that aren't closed or archived, whose options are or will be tracked
+func (s *Server) streamedPolls() ([]*poll, error) {
+	session := s.db.Copy()
+	defer session.Close()
+	var polls []*poll
+	err := s.polls(session).Find(notDeleted(bson.M{"status": bson.M{"$nin": []string{pollStatusClosed, pollStatusArchived}}})).All(&polls)
+	return polls, err
+}
+
+// POST /polls/validate checks the options of the poll in the body as creating it would
+func (s *Server) handlePollsValidate(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		respondHTTPErr(w, r, http.StatusMethodNotAllowed)
+		return
+	}
+	var p poll
+	if err := decodeBody(r, &p); err != nil {
+		respondErr(w, r, http.StatusBadRequest, "failed to read poll from request", err)
+		return
+	}
+	others, err := s.streamedPolls()
+	if err != nil {
+		respondErr(w, r, http.StatusInternalServerError, "failed to load the polls", err)
+		return
+	}
+	respond(w, r, http.StatusOK, validateTerms(&p, others))
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// A poll's options can be edited while it runs. Every edit makes a new
+// version of them, kept in the poll's versions with when it took over, and
+// the streamers tag the votes with the version they were matched against, so
+// the counter tallies the votes of each version in the version_<n> metrics
+// and what an edit did to the results can be explained.
+//
+// Deleting a poll only marks it deleted: it is neither streamed nor counted,
+// it is left out of the lists of polls, and it can be restored with its
+// results and versions. DELETE /polls/{id}?purge=true removes it for good.
+
+// errOptionsEdited is returned when the poll's options were edited by someone else meanwhile
+var errOptionsEdited = errors.New("the poll's options were edited meanwhile, try again")
+
+// pollVersion is a version of a poll's options
+type pollVersion struct {
+	Version int      `json:"version"`
+	Options []string `json:"options"`
+	// Since is when they became the poll's options
+	Since time.Time `json:"since"`
+}
+
+// notDeleted selects the polls that weren't deleted, with the fields of q
+func notDeleted(q bson.M) bson.M {
+	if q == nil {
+		q = bson.M{}
+	}
+	q["deleted_at"] = nil
+	return q
+}
+
+// optionsVersion returns the version of p's options, 1 until they are edited
+func (p *poll) optionsVersion() int {
+	if p.Version < 1 {
+		return 1
+	}
+	return p.Version
+}
+
+// sameOptions reports whether a and b are the same options in the same order
+func sameOptions(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// editOptions replaces the options of the poll id with options as their next
+// version, with the other changes of set. The options are checked as a new
+// poll's are: terms isn't valid when they are rejected, and nothing changes.
+// Options the same as the poll's are no new version.
+func (s *Server) editOptions(c *mgo.Collection, id bson.ObjectId, options []string, set bson.M) (validation, error) {
+	var p poll
+	if err := c.Find(notDeleted(bson.M{"_id": id})).One(&p); err != nil {
+		return validation{}, err
+	}
+	if sameOptions(p.Options, options) {
+		if len(set) == 0 {
+			return validation{Valid: true}, nil
+		}
+		return validation{Valid: true}, c.Update(notDeleted(bson.M{"_id": id}), bson.M{"$set": set})
+	}
+	if len(options) == 0 {
+		return validation{Errors: []termIssue{{Problem: "a poll needs options"}}}, nil
+	}
+	if err := validateMatching(p.Matching, options); err != nil {
+		return validation{Errors: []termIssue{{Problem: err.Error()}}}, nil
+	}
+	others, err := s.streamedPolls()
+	if err != nil {
+		return validation{}, err
+	}
+	edited := p
+	edited.Options = options
+	terms := validateTerms(&edited, others)
+	if !terms.Valid {
+		return terms, nil
+	}
+	versions := p.Versions
+	if len(versions) == 0 {
+		// the poll predates versions, its first options took over when it was created
+		versions = []pollVersion{{Version: 1, Options: p.Options, Since: p.ID.Time().UTC()}}
+	}
+	next := p.optionsVersion() + 1
+	set["options"] = options
+	set["version"] = next
+	set["versions"] = append(versions, pollVersion{Version: next, Options: options, Since: time.Now().UTC()})
+	current := bson.M{"_id": id, "version": p.Version}
+	if p.Version == 0 {
+		current["version"] = bson.M{"$in": []interface{}{nil, 0}}
+	}
+	if err := c.Update(notDeleted(current), bson.M{"$set": set}); err != nil {
+		if err == mgo.ErrNotFound {
+			return validation{}, errOptionsEdited
+		}
+		return validation{}, err
+	}
+	return terms, nil
+}
+
+// POST /polls/{id}/restore brings a deleted poll back as it was
+func (s *Server) handlePollRestore(w http.ResponseWriter, r *http.Request, id string) {
+	if !bson.IsObjectIdHex(id) {
+		respondHTTPErr(w, r, http.StatusNotFound)
+		return
+	}
+	session := s.db.Copy()
+	defer session.Close()
+	deleted := bson.M{"_id": bson.ObjectIdHex(id), "deleted_at": bson.M{"$ne": nil}}
+	if err := s.polls(session).Update(deleted, bson.M{"$unset": bson.M{"deleted_at": ""}}); err != nil {
+		if err == mgo.ErrNotFound {
+			respondErr(w, r, http.StatusNotFound, "no deleted poll ", id)
+			return
+		}
+		respondErr(w, r, http.StatusInternalServerError, "failed to restore poll", err)
+		return
+	}
+	s.publishPollEvent(id, "updated")
+	respond(w, r, http.StatusOK, nil)
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Every stream of a poll's results, the results and embed streams, the race
+// and the GraphQL subscription, follows the poll through one watcher: it
+// reads the poll every resultsInterval and hands each change to all of them,
+// so a widget on a busy page costs one read a second rather than one per
+// viewer. The watcher stops with its last subscriber, and while it runs the
+// results and embed responses are rendered from its copy of the poll too.
+
+// pollWatchers runs the watchers of the polls being streamed
+type pollWatchers struct {
+	interval time.Duration
+	load     func(id bson.ObjectId) (poll, error)
+
+	mu       sync.Mutex
+	watchers map[bson.ObjectId]*pollWatcher
+}
+
+// pollWatcher follows one poll for its subscribers
+type pollWatcher struct {
+	// ready is closed once the poll was first read, err is why it couldn't be
+	ready chan struct{}
+	err   error
+
+	// latest and subs are guarded by pollWatchers.mu
+	latest poll
+	subs   map[chan poll]struct{}
+	stop   chan struct{}
+}
+
+func newPollWatchers(interval time.Duration, load func(id bson.ObjectId) (poll, error)) *pollWatchers {
+	return &pollWatchers{interval: interval, load: load, watchers: map[bson.ObjectId]*pollWatcher{}}
+}
+
+// subscribe returns the poll id as it is now and a channel of its changes,
+// which only ever holds the latest one so a slow subscriber skips to it
+// rather than holding up the others. cancel must be called once done with it.
+// The polls are shared by the subscribers, they must only be read.
+func (pw *pollWatchers) subscribe(id bson.ObjectId) (p poll, changes <-chan poll, cancel func(), err error) {
+	pw.mu.Lock()
+	w, ok := pw.watchers[id]
+	if !ok {
+		w = &pollWatcher{ready: make(chan struct{}), subs: map[chan poll]struct{}{}, stop: make(chan struct{})}
+		pw.watchers[id] = w
+	}
+	ch := make(chan poll, 1)
+	w.subs[ch] = struct{}{}
+	pw.mu.Unlock()
+	cancel = func() { pw.unsubscribe(id, w, ch) }
+
+	if !ok {
+		latest, err := pw.load(id)
+		pw.mu.Lock()
+		w.latest, w.err = latest, err
+		if err != nil && pw.watchers[id] == w {
+			delete(pw.watchers, id) // the next subscriber tries again
+		}
+		pw.mu.Unlock()
+		close(w.ready)
+		if err == nil {
+			go pw.watch(id, w, latest)
+		}
+	}
+	<-w.ready
+	if w.err != nil {
+		cancel()
+		return poll{}, nil, nil, w.err
+	}
+	pw.mu.Lock()
+	p = w.latest
+	pw.mu.Unlock()
+	return p, ch, cancel, nil
+}
+
+func (pw *pollWatchers) unsubscribe(id bson.ObjectId, w *pollWatcher, ch chan poll) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	if _, ok := w.subs[ch]; !ok {
+		return
+	}
+	delete(w.subs, ch)
+	if len(w.subs) == 0 && w.err == nil {
+		if pw.watchers[id] == w {
+			delete(pw.watchers, id)
+		}
+		close(w.stop)
+	}
+}
+
+// latest returns the poll id as its watcher last read it, if one is running
+func (pw *pollWatchers) latest(id bson.ObjectId) (poll, bool) {
+	pw.mu.Lock()
+	defer pw.mu.Unlock()
+	w, ok := pw.watchers[id]
+	if !ok {
+		return poll{}, false
+	}
+	select {
+	case <-w.ready:
+		return w.latest, w.err == nil
+	default:
+		return poll{}, false // still being read for the first time
+	}
+}
+
+// readPoll is what the watchers load a poll with
+func (s *Server) readPoll(id bson.ObjectId) (poll, error) {
+	session := s.db.Copy()
+	defer session.Close()
+	var p poll
+	err := s.polls(session).FindId(id).One(&p)
+	return p, err
+}
+
+// currentPoll returns the poll id from its watcher when it's being streamed,
+// else reads it from c
+func (s *Server) currentPoll(c *mgo.Collection, id bson.ObjectId) (poll, error) {
+	if p, ok := s.watchers.latest(id); ok {
+		return p, nil
+	}
+	var p poll
+	err := c.FindId(id).One(&p)
+	return p, err
+}
+
+// followResults calls send with p, then with every change of it, until ctx
+// is done or send fails
+func followResults(ctx context.Context, p poll, changes <-chan poll, send func(poll) error) error {
+	for {
+		if err := send(p); err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case p = <-changes:
+		}
+	}
+}
+
+// watch reads the poll every interval until stopped, handing it to the
+// subscribers when it changed from last
+func (pw *pollWatchers) watch(id bson.ObjectId, w *pollWatcher, last poll) {
+	check := time.NewTicker(pw.interval)
+	defer check.Stop()
+	for {
+		select {
+		case <-w.stop:
+			return
+		case <-check.C:
+			p, err := pw.load(id)
+			if err != nil {
+				continue // transient, try again next tick
+			}
+			pw.mu.Lock()
+			w.latest = p
+			if resultsChanged(last, p) {
+				for ch := range w.subs {
+					select {
+					case <-ch: // not taken yet, replaced by this one
+					default:
+					}
+					ch <- p
+				}
+				last = p
+			}
+			pw.mu.Unlock()
+		}
+	}
+}
+
+// resultsChanged reports whether the streams have anything new to show in b:
+// its results, or whether and how they're shown
+func resultsChanged(a, b poll) bool {
+	return !reflect.DeepEqual(a.Results, b.Results) ||
+		!reflect.DeepEqual(a.WeightedResults, b.WeightedResults) ||
+		a.MinShare != b.MinShare || !reflect.DeepEqual(a.Precision, b.Precision) ||
+		a.Visibility != b.Visibility || a.status() != b.status()
+}
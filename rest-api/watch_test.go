@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// fakePolls stands in for the polls collection, counting the reads
+type fakePolls struct {
+	mu    sync.Mutex
+	p     poll
+	err   error
+	reads int
+}
+
+func (f *fakePolls) load(id bson.ObjectId) (poll, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads++
+	p := f.p
+	p.ID = id
+	return p, f.err
+}
+
+func (f *fakePolls) set(p poll, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.p, f.err = p, err
+}
+
+func (f *fakePolls) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+func receive(t *testing.T, changes <-chan poll) poll {
+	t.Helper()
+	select {
+	case p := <-changes:
+		return p
+	case <-time.After(time.Second):
+		t.Fatal("no change received")
+		return poll{}
+	}
+}
+
+func TestPollWatchersShareOneWatcher(t *testing.T) {
+	db := &fakePolls{p: poll{Results: map[string]int{"a": 1}}}
+	pw := newPollWatchers(5*time.Millisecond, db.load)
+	id := bson.NewObjectId()
+
+	first, changes1, cancel1, err := pw.subscribe(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, changes2, cancel2, err := pw.subscribe(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.Results["a"] != 1 {
+		t.Errorf("subscribed with %v, want the poll as it is", first.Results)
+	}
+	if n := db.readCount(); n != 1 {
+		t.Errorf("read %d times for two subscribers, want once", n)
+	}
+
+	db.set(poll{Results: map[string]int{"a": 2}}, nil)
+	for i, changes := range []<-chan poll{changes1, changes2} {
+		if p := receive(t, changes); p.Results["a"] != 2 {
+			t.Errorf("subscriber %d got %v, want the new results", i+1, p.Results)
+		}
+	}
+	if p, ok := pw.latest(id); !ok || p.Results["a"] != 2 {
+		t.Errorf("latest = %v, %v, want the watcher's copy", p.Results, ok)
+	}
+
+	cancel1()
+	if _, ok := pw.latest(id); !ok {
+		t.Error("the watcher stopped with a subscriber left")
+	}
+	cancel2()
+	if _, ok := pw.latest(id); ok {
+		t.Error("the watcher runs on without subscribers")
+	}
+	time.Sleep(20 * time.Millisecond) // a tick under way when stopped
+	stopped := db.readCount()
+	time.Sleep(20 * time.Millisecond)
+	if n := db.readCount(); n != stopped {
+		t.Errorf("read %d times after the last subscriber left", n-stopped)
+	}
+}
+
+func TestPollWatchersHandOnTheLatestChange(t *testing.T) {
+	db := &fakePolls{p: poll{Results: map[string]int{"a": 1}}}
+	pw := newPollWatchers(time.Millisecond, db.load)
+	_, changes, cancel, err := pw.subscribe(bson.NewObjectId())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cancel()
+
+	// the subscriber isn't reading meanwhile, it gets the last one only
+	for n := 2; n <= 5; n++ {
+		db.set(poll{Results: map[string]int{"a": n}}, nil)
+		time.Sleep(10 * time.Millisecond)
+	}
+	if p := receive(t, changes); p.Results["a"] != 5 {
+		t.Errorf("got %v, want the latest results", p.Results)
+	}
+	select {
+	case p := <-changes:
+		t.Errorf("got %v as well, an older change", p.Results)
+	default:
+	}
+
+	// a failed read isn't a change
+	db.set(poll{}, errors.New("no reachable servers"))
+	time.Sleep(10 * time.Millisecond)
+	select {
+	case p := <-changes:
+		t.Errorf("got %v after a failed read", p.Results)
+	default:
+	}
+}
+
+func TestPollWatchersNotFound(t *testing.T) {
+	db := &fakePolls{err: mgo.ErrNotFound}
+	pw := newPollWatchers(time.Millisecond, db.load)
+	id := bson.NewObjectId()
+	if _, _, _, err := pw.subscribe(id); err != mgo.ErrNotFound {
+		t.Fatalf("subscribed to a poll that isn't there: %v", err)
+	}
+	if _, ok := pw.latest(id); ok {
+		t.Error("a watcher was left for a poll that isn't there")
+	}
+	// created meanwhile
+	db.set(poll{Results: map[string]int{"a": 1}}, nil)
+	_, _, cancel, err := pw.subscribe(id)
+	if err != nil {
+		t.Fatalf("the poll isn't read again: %v", err)
+	}
+	cancel()
+}
@@ -1,8 +0,0 @@
-module github.com/olawolu/twitter-polls/tweetcounter
-
-go 1.14
-
-require (
-	github.com/nsqio/go-nsq v1.0.8
-	gopkg.in/mgo.v2 v2.0.0-20190816093944-a6b53ec6cb22
-)
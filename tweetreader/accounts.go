@@ -0,0 +1,163 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// twitterAccounts returns the accounts to stream for: the ones named in
+// TWITTER_ACCOUNTS, and "" for the polls without an account unless only named
+// accounts are configured. Every account gets a connection of its own, so
+// one customer's rate limits and quota don't affect another's polls.
+func twitterAccounts() ([]string, error) {
+	named := envList("TWITTER_ACCOUNTS")
+	var accounts []string
+	if len(named) == 0 || secret("TWITTER_KEY") != "" {
+		accounts = append(accounts, "")
+	}
+	seen := make(map[string]bool)
+	for _, a := range named {
+		if err := validAccount(a); err != nil {
+			return nil, fmt.Errorf("invalid TWITTER_ACCOUNTS: %v", err)
+		}
+		if !seen[a] {
+			seen[a] = true
+			accounts = append(accounts, a)
+		}
+	}
+	return accounts, nil
+}
+
+// validAccount checks an account name, which is part of the names of its credentials
+func validAccount(account string) error {
+	for _, r := range account {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '_') {
+			return fmt.Errorf("account %q may only have lowercase letters, digits and underscores", account)
+		}
+	}
+	return nil
+}
+
+// accountCredentials returns the credentials of account from the secrets or
+// the environment: TWITTER_<ACCOUNT>_KEY and so on, twitterCredentials for ""
+func accountCredentials(account string) stream.Credentials {
+	if account == "" {
+		return twitterCredentials()
+	}
+	prefix := "TWITTER_" + strings.ToUpper(account) + "_"
+	return stream.Credentials{
+		ConsumerKey:    secret(prefix + "KEY"),
+		ConsumerSecret: secret(prefix + "SECRET"),
+		AccessToken:    secret(prefix + "ACCESS_TOKEN"),
+		AccessSecret:   secret(prefix + "ACCESS_SECRET"),
+	}
+}
+
+// accountLabel is how an account shows up in logs
+func accountLabel(account string) string {
+	if account == "" {
+		return "default"
+	}
+	return account
+}
+
+// twitterDependency names the circuit breaker of account's stream: twitter_<account>,
+// or twitter for the default account
+func twitterDependency(account string) string {
+	if account == "" {
+		return "twitter"
+	}
+	return "twitter_" + account
+}
+
+// accountStore loads the options of the polls streamed with one account's credentials
+type accountStore struct {
+	db      store.PollStore
+	account string
+	known   map[string]bool // the accounts being streamed
+}
+
+// LoadOptions returns the options of the account's active polls. The default
+// account warns about polls of accounts nobody streams, they get no votes.
+func (s accountStore) LoadOptions() ([]string, error) {
+	polls, err := s.db.Polls()
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		if p.Account == s.account {
+			options = append(options, p.Options...)
+		} else if s.account == "" && !s.known[p.Account] {
+			log.Printf("WARNING: poll %s is for account %q, which isn't in TWITTER_ACCOUNTS, its options aren't streamed", p.ID, p.Account)
+		}
+	}
+	return options, nil
+}
+
+// Close does nothing, the store is closed by runStream
+func (accountStore) Close() {}
+
+// sources fans reconnects and pauses out to the stream of every account
+type sources []tweetSource
+
+// Reconnect reconnects every stream
+func (s sources) Reconnect() {
+	for _, src := range s {
+		src.Reconnect()
+	}
+}
+
+// Pause pauses every stream for d
+func (s sources) Pause(d time.Duration) {
+	for _, src := range s {
+		src.Pause(d)
+	}
+}
+
+// Resume lifts the pause of every stream
+func (s sources) Resume() {
+	for _, src := range s {
+		src.Resume()
+	}
+}
+
+// allStopped returns a channel that is closed once every one of chs has received or been closed
+func allStopped(chs []<-chan struct{}) <-chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, ch := range chs {
+			<-ch
+		}
+	}()
+	return done
+}
+
+// searchSince keeps where the searches of account left off in the store's
+// snapshots, so -source twitter-search goes on from there after a restart
+func searchSince(db store.SnapshotStore, account string) (load func() (string, error), save func(id string) error) {
+	name := "twitter_search"
+	if account != "" {
+		name += "_" + account
+	}
+	load = func() (string, error) {
+		b, err := db.LoadSnapshot(name)
+		if err == store.ErrNoSnapshot {
+			return "", nil
+		}
+		return string(b), err
+	}
+	save = func(id string) error {
+		return db.SaveSnapshot(name, []byte(id))
+	}
+	return load, save
+}
@@ -0,0 +1,366 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/auth"
+	"github.com/olawolu/twitter-polls/tweetreader/budget"
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/degrade"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/features"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/quarantine"
+	"github.com/olawolu/twitter-polls/tweetreader/ratelimit"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/silence"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+var (
+	adminAddr = envString("ADMIN_ADDR", ":8082")
+	adminKey  = envString("ADMIN_KEY", "") // an operator key, kept for the scripts using it
+
+	// more API keys and the JWTs accepted, the HS256 key is the JWT_SECRET secret
+	adminKeysFile     = envString("ADMIN_API_KEYS", "")
+	adminJWTPublicKey = envString("JWT_PUBLIC_KEY", "")
+	adminJWTIssuer    = envString("JWT_ISSUER", "")
+	adminJWTAudience  = envString("JWT_AUDIENCE", "")
+	adminAuditLog     = envString("ADMIN_AUDIT_LOG", "")
+
+	// requests a second, and bursts, allowed per client address and per caller, 0 turns a limit off.
+	// Behind a proxy ADMIN_TRUST_FORWARDED limits the addresses in X-Forwarded-For.
+	adminIPRate         = envFloat("ADMIN_IP_RATE", 10)
+	adminIPBurst        = envInt64("ADMIN_IP_BURST", 20)
+	adminKeyRate        = envFloat("ADMIN_KEY_RATE", 5)
+	adminKeyBurst       = envInt64("ADMIN_KEY_BURST", 10)
+	adminTrustForwarded = envBool("ADMIN_TRUST_FORWARDED", false)
+)
+
+// maxPause bounds how long publishing can be held back in one go
+var maxPause = envDuration("MAX_PUBLISH_PAUSE", 30*time.Minute)
+
+// maxStreamPause bounds how long reading tweets can be stopped in one go
+var maxStreamPause = envDuration("MAX_STREAM_PAUSE", time.Hour)
+
+// adminAuth authenticates the admin API's requests
+type adminAuth struct {
+	auth  *auth.Authenticator
+	audit *auth.AuditLog
+
+	perIP, perKey *ratelimit.Limiter
+}
+
+func newAdminAuth() (*adminAuth, error) {
+	cfg := auth.Config{
+		KeysFile:      adminKeysFile,
+		PublicKeyFile: adminJWTPublicKey,
+		Issuer:        adminJWTIssuer,
+		Audience:      adminJWTAudience,
+	}
+	if adminKey != "" {
+		cfg.Keys = map[string]auth.Principal{adminKey: {Name: "ADMIN_KEY", Role: auth.Operator, Auth: "api_key"}}
+	}
+	if s := secret("JWT_SECRET"); s != "" {
+		cfg.Secret = []byte(s)
+	}
+	a, err := auth.New(cfg)
+	if err != nil {
+		return nil, err
+	}
+	audit, err := auth.OpenAuditLog(adminAuditLog)
+	if err != nil {
+		return nil, err
+	}
+	return &adminAuth{
+		auth:   a,
+		audit:  audit,
+		perIP:  ratelimit.New(adminIPRate, int(adminIPBurst)),
+		perKey: ratelimit.New(adminKeyRate, int(adminKeyBurst)),
+	}, nil
+}
+
+// with only lets requests from callers with role through, as many as the
+// rate limits allow. Everything but reading, every admin action, is audited.
+func (a *adminAuth) with(role string, fn http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if ok, wait := a.perIP.Allow(ratelimit.ClientIP(r, adminTrustForwarded)); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		p, err := a.auth.Authenticate(r)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			respondErr(w, http.StatusUnauthorized, err)
+			return
+		}
+		if ok, wait := a.perKey.Allow(p.Auth + ":" + p.Name); !ok {
+			tooManyRequests(w, wait)
+			return
+		}
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			if !p.Can(role) {
+				respondErr(w, http.StatusForbidden, "needs the ", role, " role")
+				return
+			}
+			fn(w, r)
+			return
+		}
+		rec := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		if p.Can(role) {
+			fn(rec, r)
+		} else {
+			respondErr(rec, http.StatusForbidden, "needs the ", role, " role")
+		}
+		action := r.Method + " " + r.URL.Path
+		if q := r.URL.Query(); len(q) > 0 {
+			q.Del("key") // never write the key to the log
+			if len(q) > 0 {
+				action += "?" + q.Encode()
+			}
+		}
+		a.audit.Record(r, p, action, rec.status)
+	}
+}
+
+func tooManyRequests(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", ratelimit.RetryAfter(wait))
+	respondErr(w, http.StatusTooManyRequests, "too many requests, retry in ", ratelimit.RetryAfter(wait), "s")
+}
+
+// statusRecorder remembers the status a handler responded with
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (r *statusRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// startAdmin serves the admin API in the background, with the probes
+// /healthz and /readyz, ready until ready drains. Poll status changes are
+// announced with events, which may be nil.
+func startAdmin(gate *publish.Gate, sp *publish.Spool, src sources, db store.PollStore, events publisherFunc, guard *quarantine.Guard, silent *silence.Watcher, flags *features.Flags, filters *expr.Filters, ladder *degrade.Ladder, ready *shutdown.Readiness) (*http.Server, error) {
+	a, err := newAdminAuth()
+	if err != nil {
+		return nil, fmt.Errorf("admin API: %v", err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.Handler)
+	mux.HandleFunc("/healthz", shutdown.Live)
+	mux.Handle("/readyz", ready)
+	mux.HandleFunc("/admin/publisher", a.with(auth.Viewer, handlePublisherStatus(gate, sp)))
+	mux.HandleFunc("/admin/publisher/pause", a.with(auth.Operator, handlePublisherPause(gate)))
+	mux.HandleFunc("/admin/publisher/resume", a.with(auth.Operator, handlePublisherResume(gate)))
+	mux.HandleFunc("/admin/pause", a.with(auth.Operator, handleStreamPause(src)))
+	mux.HandleFunc("/admin/resume", a.with(auth.Operator, handleStreamResume(src)))
+	mux.HandleFunc("/admin/refresh", a.with(auth.Operator, handleStreamRefresh(src)))
+	mux.HandleFunc("/admin/stream/slo", a.with(auth.Viewer, handleStreamSLO))
+	mux.HandleFunc("/admin/budgets", a.with(auth.Viewer, handleBudgets))
+	mux.HandleFunc("/admin/events", a.with(auth.Viewer, handleOpsEvents))
+	mux.HandleFunc("/admin/degradation", a.with(auth.Viewer, handleDegradation(ladder)))
+	mux.HandleFunc("/admin/intervals", a.with(auth.Viewer, handleIntervals))
+	mux.HandleFunc("/admin/intervals/set", a.with(auth.Operator, handleIntervalSet))
+	mux.HandleFunc("/admin/top", a.with(auth.Viewer, handleTop(gate, sp)))
+	mux.HandleFunc("/admin/polls", a.with(auth.Viewer, handlePollList(db)))
+	mux.HandleFunc("/admin/polls/status", a.with(auth.PollAdmin, handlePollStatus(db, events, src)))
+	mux.HandleFunc("/admin/polls/conflicts", a.with(auth.Viewer, handlePollConflicts(filters)))
+	mux.HandleFunc("/admin/options", a.with(auth.Viewer, handleOptionList(silent)))
+	mux.HandleFunc("/admin/quarantine", a.with(auth.Viewer, handleQuarantineList(guard)))
+	mux.HandleFunc("/admin/quarantine/add", a.with(auth.PollAdmin, handleQuarantine(db, guard)))
+	mux.HandleFunc("/admin/quarantine/release", a.with(auth.PollAdmin, handleQuarantineRelease(guard)))
+	mux.HandleFunc("/admin/features", a.with(auth.Viewer, handleFeatureList(flags)))
+	mux.HandleFunc("/admin/features/set", a.with(auth.Operator, handleFeatureSet(db, flags)))
+	mux.HandleFunc("/admin/features/clear", a.with(auth.Operator, handleFeatureClear(flags)))
+	mux.HandleFunc("/debug/recent", a.with(auth.Operator, handleRecent))
+	srv := &http.Server{Addr: adminAddr, Handler: mux}
+	go func() {
+		log.Println("Starting admin server on", adminAddr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("admin server:", err)
+		}
+	}()
+	return srv, nil
+}
+
+// GET /admin/publisher reports whether publishing is paused and how much is spooled
+func handlePublisherStatus(gate *publish.Gate, sp *publish.Spool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paused, until := gate.Paused()
+		status := map[string]interface{}{
+			"paused":      paused,
+			"spool_bytes": sp.Size(),
+		}
+		if paused {
+			status["until"] = until
+		}
+		respond(w, http.StatusOK, status)
+	}
+}
+
+// POST /admin/publisher/pause?for=10m stops publishing to the broker;
+// votes keep being ingested and are spooled to disk until resumed
+func handlePublisherPause(gate *publish.Gate) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		d := maxPause
+		if v := r.URL.Query().Get("for"); v != "" {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+				respondErr(w, http.StatusBadRequest, "invalid pause duration ", v)
+				return
+			}
+		}
+		if d > maxPause {
+			respondErr(w, http.StatusBadRequest, "pause may not exceed ", maxPause)
+			return
+		}
+		until := gate.Pause(d)
+		log.Println("Publisher: paused until", until)
+		respond(w, http.StatusOK, map[string]interface{}{"paused": true, "until": until})
+	}
+}
+
+// POST /admin/publisher/resume lifts the pause; the spool is drained before new votes go out
+func handlePublisherResume(gate *publish.Gate) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		gate.Resume()
+		log.Println("Publisher: resumed")
+		respond(w, http.StatusOK, map[string]interface{}{"paused": false})
+	}
+}
+
+// POST /admin/pause?for=30m disconnects from Twitter, no votes are ingested
+// until the pause runs out or /admin/resume
+func handleStreamPause(src sources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		d := maxStreamPause
+		if v := r.URL.Query().Get("for"); v != "" {
+			var err error
+			if d, err = time.ParseDuration(v); err != nil || d <= 0 {
+				respondErr(w, http.StatusBadRequest, "invalid pause duration ", v)
+				return
+			}
+		}
+		if d > maxStreamPause {
+			respondErr(w, http.StatusBadRequest, "pause may not exceed ", maxStreamPause)
+			return
+		}
+		until := time.Now().Add(d)
+		src.Pause(d)
+		respond(w, http.StatusOK, map[string]interface{}{"paused": true, "until": until})
+	}
+}
+
+// POST /admin/resume reconnects to Twitter right away
+func handleStreamResume(src sources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		src.Resume()
+		respond(w, http.StatusOK, map[string]interface{}{"paused": false})
+	}
+}
+
+// POST /admin/refresh reconnects with freshly loaded options, e.g. after
+// changing many polls directly in the database
+func handleStreamRefresh(src sources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		log.Println("Refresh requested, reconnecting to Twitter")
+		src.Reconnect()
+		respond(w, http.StatusOK, map[string]interface{}{"refreshing": true})
+	}
+}
+
+// GET /admin/stream/slo sums up how the streams did since the last SLO report
+func handleStreamSLO(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, stream.CurrentSummary())
+}
+
+// GET /admin/budgets lists the error and log budgets of the components, which
+// are exhausted and until when
+func handleBudgets(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, budget.Statuses())
+}
+
+// GET /admin/degradation reports the steps of the degradation ladder taken
+// and the pressure of what it watches
+func handleDegradation(ladder *degrade.Ladder) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, ladder.Status())
+	}
+}
+
+// GET /admin/intervals lists the intervals that can be changed while running
+func handleIntervals(w http.ResponseWriter, r *http.Request) {
+	respond(w, http.StatusOK, clock.Intervals())
+}
+
+// POST /admin/intervals/set?name=refresh&to=5m changes an interval until the
+// process restarts, the loops waiting on it start over with the new one; 0 turns it off
+func handleIntervalSet(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+		return
+	}
+	name, to := r.URL.Query().Get("name"), r.URL.Query().Get("to")
+	d, err := time.ParseDuration(to)
+	if err != nil {
+		respondErr(w, http.StatusBadRequest, "invalid interval ", to)
+		return
+	}
+	switch err := clock.Set(name, d); err {
+	case nil:
+	case clock.ErrUnknownInterval:
+		respondErr(w, http.StatusNotFound, "no interval ", name)
+		return
+	default:
+		respondErr(w, http.StatusBadRequest, err)
+		return
+	}
+	log.Printf("Interval %s set to %s", name, d)
+	respond(w, http.StatusOK, clock.Intervals())
+}
+
+// respond writes the status code and data as JSON
+func respond(w http.ResponseWriter, status int, data interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if data != nil {
+		json.NewEncoder(w).Encode(data)
+	}
+}
+
+// respondErr writes an error message in the same shape as the rest-api
+func respondErr(w http.ResponseWriter, status int, args ...interface{}) {
+	respond(w, status, map[string]interface{}{
+		"error": map[string]interface{}{
+			"message": fmt.Sprint(args...),
+		},
+	})
+}
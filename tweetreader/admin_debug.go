@@ -0,0 +1,39 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/tap"
+)
+
+// tapVotes records the votes from in on the tap and passes them on, the
+// returned channel is closed once in is
+func tapVotes(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			tap.Vote(v)
+			out <- v
+		}
+	}()
+	return out
+}
+
+// GET /debug/recent?n=20 returns the last votes about to be published and the
+// last stream messages that couldn't be decoded, the newest first; all the
+// tap keeps without n. The votes carry their text and authors, of the private
+// polls anonymized, so it needs the operator role.
+func handleRecent(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			respondErr(w, http.StatusBadRequest, "invalid n ", v)
+			return
+		}
+	}
+	respond(w, http.StatusOK, tap.Last(n))
+}
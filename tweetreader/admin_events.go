@@ -0,0 +1,22 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+)
+
+// GET /admin/events?n=50&kind=stream lists the last operational events, the
+// newest first: every one kept without n, of a kind or a family of kinds with kind
+func handleOpsEvents(w http.ResponseWriter, r *http.Request) {
+	var n int
+	if v := r.URL.Query().Get("n"); v != "" {
+		var err error
+		if n, err = strconv.Atoi(v); err != nil || n <= 0 {
+			respondErr(w, http.StatusBadRequest, "invalid n ", v)
+			return
+		}
+	}
+	respond(w, http.StatusOK, events.Recent(n, r.URL.Query().Get("kind")))
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/olawolu/twitter-polls/tweetreader/features"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// GET /admin/features lists the flags' defaults and what was set, and with
+// poll=... whether each flag is on for that poll
+func handleFeatureList(flags *features.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := map[string]interface{}{
+			"defaults": flags.Defaults(),
+			"settings": flags.List(),
+		}
+		if poll := r.URL.Query().Get("poll"); poll != "" {
+			enabled := make(map[string]bool, len(features.Known))
+			for _, flag := range features.Known {
+				enabled[flag] = flags.Enabled(flag, poll)
+			}
+			status["poll"], status["enabled"] = poll, enabled
+		}
+		respond(w, http.StatusOK, status)
+	}
+}
+
+// POST /admin/features/set?flag=moderation&enabled=true&poll=... turns a flag on
+// or off for the poll, or for every poll without one, right away here and with
+// the next refresh on the other streamers
+func handleFeatureSet(db store.PollStore, flags *features.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		q := r.URL.Query()
+		enabled, err := strconv.ParseBool(q.Get("enabled"))
+		if err != nil {
+			respondErr(w, http.StatusBadRequest, "invalid enabled ", strconv.Quote(q.Get("enabled")), ", want true or false")
+			return
+		}
+		poll := q.Get("poll")
+		if poll != "" {
+			if _, err := db.Poll(poll); err == store.ErrNotFound {
+				respondErr(w, http.StatusNotFound, err)
+				return
+			} else if err != nil {
+				respondErr(w, http.StatusServiceUnavailable, "failed to load the poll: ", err)
+				return
+			}
+		}
+		s, err := flags.Set(q.Get("flag"), poll, enabled)
+		if err == features.ErrUnknown {
+			respondErr(w, http.StatusBadRequest, "unknown flag ", strconv.Quote(q.Get("flag")))
+			return
+		} else if err != nil {
+			// it holds in this streamer until the next refresh reads the snapshot back
+			respondErr(w, http.StatusServiceUnavailable, "failed to save the flags: ", err)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"setting": s})
+	}
+}
+
+// POST /admin/features/clear?flag=moderation&poll=... puts a flag back to what
+// is set for every poll, or to its default without a poll
+func handleFeatureClear(flags *features.Flags) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		q := r.URL.Query()
+		flag, poll := q.Get("flag"), q.Get("poll")
+		cleared, err := flags.Clear(flag, poll)
+		if err == features.ErrUnknown {
+			respondErr(w, http.StatusBadRequest, "unknown flag ", strconv.Quote(flag))
+			return
+		} else if err != nil {
+			respondErr(w, http.StatusServiceUnavailable, "failed to save the flags: ", err)
+			return
+		}
+		if !cleared {
+			respondErr(w, http.StatusNotFound, "flag ", flag, " isn't set for ", pollOrAll(poll))
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"flag": flag, "poll": poll, "cleared": true})
+	}
+}
+
+func pollOrAll(poll string) string {
+	if poll == "" {
+		return "every poll"
+	}
+	return "poll " + poll
+}
@@ -0,0 +1,15 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/olawolu/twitter-polls/tweetreader/silence"
+)
+
+// GET /admin/options lists the options tracked with when they last matched a
+// vote, and whether they went silent while their siblings got votes
+func handleOptionList(silent *silence.Watcher) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, map[string]interface{}{"options": silent.List()})
+	}
+}
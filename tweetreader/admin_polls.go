@@ -0,0 +1,108 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// pollEvent is what the rest-api announces poll changes with on poll_events
+type pollEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// GET /admin/polls lists the polls with their status, ?status=closed only the ones with it
+func handlePollList(db store.PollStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		want := r.URL.Query().Get("status")
+		if want != "" && !store.ValidStatus(want) {
+			respondErr(w, http.StatusBadRequest, "unknown status ", want, ", want draft, active, paused, closed or archived")
+			return
+		}
+		polls, err := db.Polls()
+		if err != nil {
+			respondErr(w, http.StatusServiceUnavailable, "failed to load the polls: ", err)
+			return
+		}
+		list := []map[string]interface{}{}
+		for _, p := range polls {
+			if want != "" && p.State() != want {
+				continue
+			}
+			list = append(list, map[string]interface{}{
+				"id":      p.ID,
+				"title":   p.Title,
+				"status":  p.State(),
+				"options": p.Options,
+				"tenant":  p.Tenant,
+			})
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"polls": list})
+	}
+}
+
+// POST /admin/polls/status?id=...&status=closed moves a poll to another status.
+// Drafts can become active or closed, active polls paused or closed, paused
+// polls active or closed, and closed polls archived. The change is announced
+// on poll_events, so counters and streamers watching it pick it up, and this
+// streamer reconnects when the poll started or stopped being tracked.
+func handlePollStatus(db store.PollStore, events publisherFunc, src sources) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		id, to := r.URL.Query().Get("id"), r.URL.Query().Get("status")
+		if id == "" {
+			respondErr(w, http.StatusBadRequest, "missing id")
+			return
+		}
+		if !store.ValidStatus(to) {
+			respondErr(w, http.StatusBadRequest, "unknown status ", to, ", want draft, active, paused, closed or archived")
+			return
+		}
+		p, err := store.Transition(db, id, to)
+		if err != nil {
+			status := http.StatusServiceUnavailable
+			if _, ok := err.(*store.TransitionError); ok || err == store.ErrStatusChanged {
+				status = http.StatusConflict
+			} else if err == store.ErrNotFound {
+				status = http.StatusNotFound
+			}
+			respondErr(w, status, err)
+			return
+		}
+		from := p.State()
+		log.Printf("Poll %s: %s -> %s", id, from, to)
+		if events != nil {
+			b, _ := json.Marshal(pollEvent{ID: id, Action: "updated"})
+			if err := events(b); err != nil {
+				log.Println("failed to publish poll event:", err)
+			}
+		}
+		if p.Tracked() != (to == store.StatusActive) {
+			src.Reconnect()
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"id": id, "from": from, "to": to})
+	}
+}
+
+// GET /admin/polls/conflicts lists the terms several tracked polls have as an
+// option, with the polls and whether they route its votes, as of the last
+// refresh of the options
+func handlePollConflicts(filters *expr.Filters) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		conflicts := filters.Conflicts()
+		if conflicts == nil {
+			conflicts = []expr.Conflict{}
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"conflicts": conflicts})
+	}
+}
+
+// publisherFunc publishes a message on one topic
+type publisherFunc func(b []byte) error
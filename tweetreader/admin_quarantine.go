@@ -0,0 +1,70 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/olawolu/twitter-polls/tweetreader/quarantine"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// GET /admin/quarantine lists the quarantined polls, the longest quarantined first
+func handleQuarantineList(guard *quarantine.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		respond(w, http.StatusOK, map[string]interface{}{"quarantined": guard.List()})
+	}
+}
+
+// POST /admin/quarantine/add?id=... drops the poll's votes right away, its
+// options leave the stream's filter with the next refresh
+func handleQuarantine(db store.PollStore, guard *quarantine.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			respondErr(w, http.StatusBadRequest, "missing id")
+			return
+		}
+		if _, err := db.Poll(id); err == store.ErrNotFound {
+			respondErr(w, http.StatusNotFound, err)
+			return
+		} else if err != nil {
+			respondErr(w, http.StatusServiceUnavailable, "failed to load the poll: ", err)
+			return
+		}
+		e, added, err := guard.Quarantine(id, quarantine.Manual)
+		if err != nil {
+			// it holds in this streamer until the next refresh reads the snapshot back
+			respondErr(w, http.StatusServiceUnavailable, "failed to save the quarantined polls: ", err)
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"quarantined": e, "added": added})
+	}
+}
+
+// POST /admin/quarantine/release?id=... lets the poll's votes through again
+func handleQuarantineRelease(guard *quarantine.Guard) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, http.StatusText(http.StatusMethodNotAllowed))
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			respondErr(w, http.StatusBadRequest, "missing id")
+			return
+		}
+		released, err := guard.Release(id)
+		if err != nil {
+			respondErr(w, http.StatusServiceUnavailable, "failed to save the quarantined polls: ", err)
+			return
+		}
+		if !released {
+			respondErr(w, http.StatusNotFound, "poll ", id, " isn't quarantined")
+			return
+		}
+		respond(w, http.StatusOK, map[string]interface{}{"id": id, "released": true})
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// topGauges are the metrics /admin/top reports, the depths of the pipeline's
+// buffers and the state of the dependencies
+var topGauges = []string{
+	"tweetreader_votes_queue_depth",
+	"tweetreader_votes_queue_capacity",
+	"tweetreader_stream_connected",
+	"tweetreader_stream_connection_uptime_seconds",
+	"tweetreader_breaker_state",
+	"tweetreader_outbox_pending",
+}
+
+// maxRecentErrors is how many of the last error lines /admin/top reports
+const maxRecentErrors = 20
+
+var (
+	// topVotes counts the votes of the process per option, for top
+	topVotes = &voteTally{counts: make(map[string]int64)}
+	// recentErrors keeps the last lines logged about an error or a failure, for top
+	recentErrors = &errorLog{max: maxRecentErrors}
+)
+
+// voteTally counts the votes passing through it per option
+type voteTally struct {
+	mu     sync.Mutex
+	counts map[string]int64
+}
+
+// Run counts the votes from in and passes them on, the returned channel is closed once in is
+func (t *voteTally) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			t.mu.Lock()
+			t.counts[v.Option]++
+			t.mu.Unlock()
+			out <- v
+		}
+	}()
+	return out
+}
+
+// snapshot returns a copy of the counts
+func (t *voteTally) snapshot() map[string]int64 {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	counts := make(map[string]int64, len(t.counts))
+	for o, n := range t.counts {
+		counts[o] = n
+	}
+	return counts
+}
+
+// loggedError is a line logged about an error
+type loggedError struct {
+	Time time.Time `json:"time"`
+	Line string    `json:"line"`
+}
+
+// errorLog is a writer for the log keeping the last lines that mention an
+// error or a failure
+type errorLog struct {
+	mu    sync.Mutex
+	max   int
+	lines []loggedError
+}
+
+// Write keeps the error lines of p, which the log writes a line at a time
+func (l *errorLog) Write(p []byte) (int, error) {
+	for _, line := range bytes.Split(bytes.TrimRight(p, "\n"), []byte("\n")) {
+		lower := strings.ToLower(string(line))
+		if !strings.Contains(lower, "error") && !strings.Contains(lower, "fail") {
+			continue
+		}
+		l.mu.Lock()
+		if len(l.lines) == l.max {
+			l.lines = l.lines[1:]
+		}
+		l.lines = append(l.lines, loggedError{Time: time.Now(), Line: string(line)})
+		l.mu.Unlock()
+	}
+	return len(p), nil
+}
+
+// recent returns the lines kept, the oldest first
+func (l *errorLog) recent() []loggedError {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]loggedError(nil), l.lines...)
+}
+
+// topStatus is what /admin/top reports
+type topStatus struct {
+	Time       time.Time          `json:"time"`
+	Votes      map[string]int64   `json:"votes"`
+	Stream     stream.Summary     `json:"stream"`
+	Paused     bool               `json:"publisher_paused"`
+	SpoolBytes int64              `json:"spool_bytes"`
+	Gauges     map[string]float64 `json:"gauges"`
+	Errors     []loggedError      `json:"errors"`
+}
+
+// GET /admin/top reports the votes per option so far, the stream's health,
+// the depths of the buffers and the recent errors, for the top command
+func handleTop(gate *publish.Gate, sp *publish.Spool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		paused, _ := gate.Paused()
+		respond(w, http.StatusOK, topStatus{
+			Time:       time.Now(),
+			Votes:      topVotes.snapshot(),
+			Stream:     stream.CurrentSummary(),
+			Paused:     paused,
+			SpoolBytes: sp.Size(),
+			Gauges:     dumpedGauges(topGauges),
+			Errors:     recentErrors.recent(),
+		})
+	}
+}
+
+// dumpedGauges returns the series of the metrics called names, keyed by
+// their name and labels as exported
+func dumpedGauges(names []string) map[string]float64 {
+	gauges := make(map[string]float64)
+	for _, line := range metrics.Dump() {
+		sp := strings.LastIndex(line, " ")
+		if sp < 0 {
+			continue
+		}
+		series := line[:sp]
+		name := series
+		if i := strings.Index(name, "{"); i >= 0 {
+			name = name[:i]
+		}
+		for _, n := range names {
+			if name == n {
+				if v, err := strconv.ParseFloat(line[sp+1:], 64); err == nil {
+					gauges[series] = v
+				}
+			}
+		}
+	}
+	return gauges
+}
@@ -0,0 +1,63 @@
+// Package aggregate computes custom results for polls while their votes are counted.
+//
+// The counter always stores a poll's raw counts, plus the weighted tallies of weighted polls.
+// Aggregators registered for a poll type add named metrics next to them, such as Borda points
+// for ranked polls. An aggregator scores each vote for the option it was cast for; the scores
+// are summed between flushes and added to the stored metric, so they must be additive.
+package aggregate
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Vote is what aggregators get to see of a counted vote
+type Vote struct {
+	Option    string
+	Weight    float64
+	Text      string // the tweet, for aggregators that care how it was written
+	Followers int    // of the author
+}
+
+// Func scores a vote for p, the result is added to the metric for v.Option
+type Func func(p *store.Poll, v Vote) float64
+
+// Aggregator computes one named metric
+type Aggregator struct {
+	Name  string
+	Score Func
+}
+
+var (
+	mu          sync.RWMutex
+	aggregators = make(map[string][]Aggregator) // by poll type
+)
+
+func init() {
+	Register(Ranked, Aggregator{Name: "borda", Score: Borda})
+}
+
+// Register applies a to every poll of pollType, replacing an aggregator with the same name
+func Register(pollType string, a Aggregator) {
+	mu.Lock()
+	defer mu.Unlock()
+	list := aggregators[pollType]
+	for i := range list {
+		if list[i].Name == a.Name {
+			list[i] = a
+			return
+		}
+	}
+	list = append(list, a)
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	aggregators[pollType] = list
+}
+
+// For returns the aggregators applied to polls of pollType
+func For(pollType string) []Aggregator {
+	mu.RLock()
+	defer mu.RUnlock()
+	return aggregators[pollType]
+}
@@ -0,0 +1,30 @@
+package aggregate
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// Ranked polls are voted on by listing the options in order of preference
+const Ranked = "ranked"
+
+// Borda scores a ranked vote by where the tweet lists the option:
+// with n options the first one mentioned gets n-1 points, the second n-2 and so on.
+// Options the tweet doesn't mention get nothing.
+func Borda(p *store.Poll, v Vote) float64 {
+	text := textnorm.Fold(v.Text)
+	at := textnorm.IndexTerm(text, textnorm.Fold(v.Option))
+	if at < 0 {
+		return 0
+	}
+	var before int
+	for _, o := range p.Options {
+		if i := textnorm.IndexTerm(text, textnorm.Fold(o)); i >= 0 && i < at {
+			before++
+		}
+	}
+	if before >= len(p.Options) {
+		return 0
+	}
+	return float64(len(p.Options) - 1 - before)
+}
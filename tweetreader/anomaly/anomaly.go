@@ -0,0 +1,214 @@
+// Package anomaly flags spikes in the votes for an option, such as a bot
+// campaign flooding a poll.
+//
+// Votes are counted per option in buckets of a fixed width. Each option keeps a
+// baseline, the exponentially weighted mean and variance of its past buckets,
+// and a bucket getting more than Threshold standard deviations above the mean
+// is a spike. The spike starts as soon as the bucket crosses the line, not when
+// it ends, and lasts until a whole bucket stays under it. Votes cast during a
+// spike are tagged Suspect, so the counter can leave them out of the polls that
+// ask it to, and the start of every spike is alerted.
+package anomaly
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Topic is the NSQ topic alerts are published on
+const Topic = "alerts"
+
+var (
+	spikes = metrics.NewCounter("tweetreader_anomaly_spikes_total",
+		"Vote spikes detected.")
+	suspect = metrics.NewCounter("tweetreader_anomaly_suspect_votes_total",
+		"Votes tagged suspect, cast during a spike.")
+)
+
+// Config tunes the detector, zero values keep the defaults
+type Config struct {
+	// Bucket is the width of the buckets votes are counted in, 1m by default
+	Bucket time.Duration
+	// Threshold is how many standard deviations above the baseline a bucket
+	// is a spike at, 4 by default
+	Threshold float64
+	// MinVotes is the fewest votes in a bucket that can be a spike, 50 by default
+	MinVotes int
+	// Warmup is how many buckets an option needs for a baseline before it is
+	// watched, 10 by default
+	Warmup int
+	// Alpha is how much each bucket moves the baseline, 0.1 by default
+	Alpha float64
+	// Events, if set, is told about every spike as events.VoteSpike
+	Events *events.Bus
+	// Alerts, if set, publishes every spike as an Alert in JSON
+	Alerts Publisher
+}
+
+// Publisher is the part of publish.Publisher alerts are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Alert describes a spike
+type Alert struct {
+	Kind   string    `json:"kind"` // vote_spike
+	Option string    `json:"option"`
+	Votes  int       `json:"votes"`  // in the bucket so far when the spike started
+	Mean   float64   `json:"mean"`   // of the baseline, votes per bucket
+	StdDev float64   `json:"stddev"` // of the baseline
+	Bucket string    `json:"bucket"` // the bucket width, e.g. 1m0s
+	Time   time.Time `json:"time"`
+}
+
+// Detector watches the vote rate of every option
+type Detector struct {
+	cfg Config
+
+	mu      sync.Mutex
+	options map[string]*rate
+}
+
+// rate is the state of one option
+type rate struct {
+	start    time.Time // of the current bucket
+	votes    int       // in the current bucket
+	mean     float64
+	variance float64
+	buckets  int  // folded into the baseline
+	spiking  bool // since a bucket crossed the line, until a whole bucket stays under it
+}
+
+// maxGap caps the empty buckets folded in after a quiet spell, the baseline is
+// long back near zero by then
+const maxGap = 200
+
+// New creates a detector with cfg
+func New(cfg Config) *Detector {
+	if cfg.Bucket <= 0 {
+		cfg.Bucket = time.Minute
+	}
+	if cfg.Threshold <= 0 {
+		cfg.Threshold = 4
+	}
+	if cfg.MinVotes <= 0 {
+		cfg.MinVotes = 50
+	}
+	if cfg.Warmup <= 0 {
+		cfg.Warmup = 10
+	}
+	if cfg.Alpha <= 0 || cfg.Alpha > 1 {
+		cfg.Alpha = 0.1
+	}
+	return &Detector{cfg: cfg, options: make(map[string]*rate)}
+}
+
+// Observe counts a vote for option at now and reports whether it is suspect
+func (d *Detector) Observe(option string, now time.Time) bool {
+	d.mu.Lock()
+	r := d.options[option]
+	if r == nil {
+		r = &rate{start: now.Truncate(d.cfg.Bucket)}
+		d.options[option] = r
+	}
+	d.roll(r, now)
+	r.votes++
+	var alert *Alert
+	if !r.spiking && r.buckets >= d.cfg.Warmup && r.votes >= d.cfg.MinVotes && float64(r.votes) > d.limit(r) {
+		r.spiking = true
+		alert = &Alert{
+			Kind:   "vote_spike",
+			Option: option,
+			Votes:  r.votes,
+			Mean:   r.mean,
+			StdDev: math.Sqrt(r.variance),
+			Bucket: d.cfg.Bucket.String(),
+			Time:   now,
+		}
+	}
+	spiking := r.spiking
+	d.mu.Unlock()
+	if alert != nil {
+		d.alert(*alert)
+	}
+	if spiking {
+		suspect.Inc()
+	}
+	return spiking
+}
+
+// roll closes the buckets of r that ended by now, folding them into the baseline.
+// Must be called with mu held.
+func (d *Detector) roll(r *rate, now time.Time) {
+	for gap := 0; now.Sub(r.start) >= d.cfg.Bucket; gap++ {
+		if gap >= maxGap {
+			r.start = now.Truncate(d.cfg.Bucket)
+			break
+		}
+		if r.spiking && float64(r.votes) <= d.limit(r) {
+			r.spiking = false
+		}
+		x := float64(r.votes)
+		if r.buckets == 0 {
+			r.mean = x
+		} else {
+			diff := x - r.mean
+			r.mean += d.cfg.Alpha * diff
+			r.variance = (1 - d.cfg.Alpha) * (r.variance + d.cfg.Alpha*diff*diff)
+		}
+		r.buckets++
+		r.votes = 0
+		r.start = r.start.Add(d.cfg.Bucket)
+	}
+}
+
+// limit is the most votes a bucket of r gets without being a spike. The
+// deviation is at least what a Poisson rate of the mean would have, so a
+// steady trickle doesn't make every extra vote a spike.
+func (d *Detector) limit(r *rate) float64 {
+	sd := math.Max(math.Sqrt(r.variance), math.Max(math.Sqrt(r.mean), 1))
+	return r.mean + d.cfg.Threshold*sd
+}
+
+func (d *Detector) alert(a Alert) {
+	spikes.Inc()
+	detail := fmt.Sprintf("option %s: %d votes within %s, baseline %.1f±%.1f", a.Option, a.Votes, a.Bucket, a.Mean, a.StdDev)
+	log.Println("vote spike:", detail)
+	d.cfg.Events.Emit(events.VoteSpike, detail)
+	if d.cfg.Alerts == nil {
+		return
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		log.Println("failed to encode the alert:", err)
+		return
+	}
+	go func() {
+		if err := d.cfg.Alerts.Publish(b); err != nil {
+			log.Println("failed to publish the alert:", err)
+		}
+	}()
+}
+
+// Run tags the votes cast during a spike for their option as Suspect
+func (d *Detector) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if d.Observe(v.Option, time.Now()) {
+				v.Suspect = true
+			}
+			out <- v
+		}
+	}()
+	return out
+}
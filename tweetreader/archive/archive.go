@@ -0,0 +1,257 @@
+// Package archive keeps the tweets that matched a poll, so analysts can
+// process them again later, e.g. with new matching rules.
+//
+// Tweets are batched per poll and hour as gzipped NDJSON, one tweet as the
+// streamer decoded it per line, and stored under
+//
+//	poll=<poll id>/hour=<yyyy-mm-dd-hh>/<instance>-<unix nanoseconds>.ndjson.gz
+//
+// Each batch is stored once it holds BatchBytes of tweets, or every FlushInterval.
+// Archiving is best effort: it never holds up the votes, a tweet that doesn't
+// fit the queue is dropped, and a batch that can't be stored is retried with
+// the next flush while no more than maxPending batches wait.
+package archive
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// HourFormat is how the hour of a batch is written in its key
+const HourFormat = "2006-01-02-15"
+
+// maxPending is how many batches that failed to be stored are kept for another try
+const maxPending = 64
+
+var (
+	archived = metrics.NewCounter("tweetreader_archive_tweets_total",
+		"Tweets stored in the archive, once per poll they matched.")
+	batchesStored = metrics.NewCounter("tweetreader_archive_batches_total",
+		"Batches stored in the archive.")
+	storeErrors = metrics.NewCounter("tweetreader_archive_errors_total",
+		"Failed attempts to store a batch, it is retried with the next flush.")
+	dropped = metrics.NewCounter("tweetreader_archive_dropped_total",
+		"Tweets not archived because the queue or the pending batches were full.")
+)
+
+// Config configures an Archiver
+type Config struct {
+	Sink Sink
+	// Instance starts the names of the batches, so streamers don't overwrite each other's
+	Instance      string
+	FlushInterval time.Duration
+	// BatchBytes is the size of the uncompressed tweets a batch is stored at
+	BatchBytes int
+}
+
+// Archiver batches the tweets and stores them in the sink
+type Archiver struct {
+	cfg  Config
+	in   chan entry
+	done chan struct{}
+
+	mu    sync.RWMutex
+	polls map[string][]string // poll IDs by option, leaving out the private polls
+}
+
+// entry is a tweet to archive for the polls of options
+type entry struct {
+	raw     []byte
+	options []string
+	at      time.Time
+}
+
+// partition is where a batch is stored
+type partition struct {
+	poll string
+	hour time.Time
+}
+
+type batch struct {
+	partition
+	buf    bytes.Buffer
+	gz     *gzip.Writer
+	size   int // uncompressed
+	tweets int
+	key    string // set once, so a retry replaces an upload that only seemed to fail
+}
+
+// New creates an Archiver and starts storing the batches
+func New(cfg Config) *Archiver {
+	a := &Archiver{
+		cfg:  cfg,
+		in:   make(chan entry, 1024),
+		done: make(chan struct{}),
+	}
+	go a.run()
+	return a
+}
+
+// Update takes the polls to archive the tweets for. Private polls are left
+// out, their tweets' text and authors must not be kept.
+func (a *Archiver) Update(polls []store.Poll) {
+	byOption := make(map[string][]string)
+	for _, p := range polls {
+		if p.Private {
+			continue
+		}
+		for _, o := range p.Options {
+			byOption[o] = append(byOption[o], p.ID)
+		}
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.polls = byOption
+}
+
+// Options wraps a function loading the options so every load also updates
+// the polls. When the polls can't be loaded the last ones are kept.
+func (a *Archiver) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("archive: failed to load the polls, keeping the last ones:", err)
+			return options, nil
+		}
+		a.Update(all)
+		return options, nil
+	}
+}
+
+// Add archives raw, a JSON encoded tweet, for the polls of the options it
+// matched. It must not be called once Stop was.
+func (a *Archiver) Add(raw []byte, options []string) {
+	select {
+	case a.in <- entry{raw: raw, options: options, at: time.Now()}:
+	default:
+		dropped.Inc()
+	}
+}
+
+// Stop stores what is batched and returns once it is stored, or failed to be
+func (a *Archiver) Stop() {
+	close(a.in)
+	<-a.done
+}
+
+func (a *Archiver) run() {
+	defer close(a.done)
+	batches := make(map[partition]*batch)
+	var pending []*batch
+	tick := time.NewTicker(a.cfg.FlushInterval)
+	defer tick.Stop()
+	flush := func() {
+		for p, b := range batches {
+			pending = append(pending, b)
+			delete(batches, p)
+		}
+		pending = a.store(pending)
+	}
+	for {
+		select {
+		case e, ok := <-a.in:
+			if !ok {
+				flush()
+				if len(pending) > 0 {
+					log.Printf("archive: %d batches couldn't be stored and are lost", len(pending))
+				}
+				return
+			}
+			for _, p := range a.partitions(e) {
+				b := batches[p]
+				if b == nil {
+					b = &batch{partition: p}
+					b.gz = gzip.NewWriter(&b.buf)
+					batches[p] = b
+				}
+				b.gz.Write(e.raw)
+				b.gz.Write([]byte{'\n'})
+				b.size += len(e.raw) + 1
+				b.tweets++
+				if b.size >= a.cfg.BatchBytes {
+					delete(batches, p)
+					pending = a.store(append(pending, b))
+				}
+			}
+		case <-tick.C:
+			flush()
+		}
+	}
+}
+
+// partitions are the batches e goes in: one per poll of its options
+func (a *Archiver) partitions(e entry) []partition {
+	hour := e.at.UTC().Truncate(time.Hour)
+	a.mu.RLock()
+	defer a.mu.RUnlock()
+	var parts []partition
+	seen := make(map[string]bool)
+	for _, o := range e.options {
+		for _, id := range a.polls[o] {
+			if !seen[id] {
+				seen[id] = true
+				parts = append(parts, partition{poll: id, hour: hour})
+			}
+		}
+	}
+	return parts
+}
+
+// store stores the batches in order, returning the ones that failed. It gives
+// up at the first failure, the sink is likely to fail the others too.
+func (a *Archiver) store(batches []*batch) []*batch {
+	for i, b := range batches {
+		if b.gz != nil {
+			b.gz.Close()
+			b.gz = nil
+			b.key = fmt.Sprintf("%shour=%s/%s-%d%s", PollPrefix(b.poll), b.hour.Format(HourFormat), a.cfg.Instance, time.Now().UnixNano(), batchSuffix)
+		}
+		if err := a.cfg.Sink.Put(b.key, b.buf.Bytes()); err != nil {
+			storeErrors.Inc()
+			log.Printf("archive: failed to store %s, retrying with the next flush: %v", b.key, err)
+			return trim(batches[i:])
+		}
+		batchesStored.Inc()
+		archived.Add(float64(b.tweets))
+	}
+	return nil
+}
+
+// Hour returns the hour of the batch stored under key
+func Hour(key string) (time.Time, error) {
+	for _, part := range strings.Split(key, "/") {
+		if strings.HasPrefix(part, "hour=") {
+			return time.Parse(HourFormat, strings.TrimPrefix(part, "hour="))
+		}
+	}
+	return time.Time{}, fmt.Errorf("archive: %s has no hour", key)
+}
+
+// PollPrefix is the prefix of the keys of a poll's batches
+func PollPrefix(poll string) string {
+	return "poll=" + poll + "/"
+}
+
+// trim drops the oldest batches beyond maxPending
+func trim(pending []*batch) []*batch {
+	if len(pending) <= maxPending {
+		return pending
+	}
+	for _, b := range pending[:len(pending)-maxPending] {
+		dropped.Add(float64(b.tweets))
+	}
+	log.Printf("archive: dropped %d batches that couldn't be stored", len(pending)-maxPending)
+	return append([]*batch(nil), pending[len(pending)-maxPending:]...)
+}
@@ -0,0 +1,253 @@
+package archive
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/sigv4"
+)
+
+// Sink stores the batches
+type Sink interface {
+	// Put stores data under key, a slash separated path
+	Put(key string, data []byte) error
+}
+
+// Source reads the batches back
+type Source interface {
+	// List returns the keys of the batches starting with prefix, sorted
+	List(prefix string) ([]string, error)
+	// Get returns the batch stored under key
+	Get(key string) ([]byte, error)
+}
+
+// SinkConfig describes where the batches go
+type SinkConfig struct {
+	// URL is s3://bucket/prefix, gs://bucket/prefix, or a local directory as
+	// file:///path or a plain path
+	URL string
+	// Endpoint overrides the S3 endpoint, e.g. for MinIO. Buckets are addressed
+	// in the path then.
+	Endpoint string
+	// Region is the bucket's region, auto for gs
+	Region string
+	// Credentials sign the requests to the bucket, for gs they are HMAC keys
+	Credentials sigv4.Credentials
+}
+
+// OpenSink creates the sink described by cfg
+func OpenSink(cfg SinkConfig) (Sink, error) {
+	return open(cfg)
+}
+
+// OpenSource opens the archive described by cfg for reading
+func OpenSource(cfg SinkConfig) (Source, error) {
+	return open(cfg)
+}
+
+func open(cfg SinkConfig) (interface {
+	Sink
+	Source
+}, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("archive: invalid URL %q: %v", cfg.URL, err)
+	}
+	switch u.Scheme {
+	case "", "file":
+		if u.Path == "" {
+			return nil, fmt.Errorf("archive: %q has no directory", cfg.URL)
+		}
+		return Dir(u.Path), nil
+	case "s3", "gs":
+		return newBucket(u, cfg)
+	default:
+		return nil, fmt.Errorf("archive: unknown scheme %q, want s3, gs or file", u.Scheme)
+	}
+}
+
+// Dir stores the batches as files under a directory
+type Dir string
+
+// Put writes data to a temporary file first, so readers never see half a batch
+func (d Dir) Put(key string, data []byte) error {
+	name := filepath.Join(string(d), filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+		return err
+	}
+	tmp := name + ".tmp"
+	if err := ioutil.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, name)
+}
+
+// List walks the directory for the batches
+func (d Dir) List(prefix string) ([]string, error) {
+	var keys []string
+	err := filepath.Walk(string(d), func(name string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || !strings.HasSuffix(name, batchSuffix) {
+			return nil
+		}
+		rel, err := filepath.Rel(string(d), name)
+		if err != nil {
+			return err
+		}
+		if key := filepath.ToSlash(rel); strings.HasPrefix(key, prefix) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	sort.Strings(keys)
+	return keys, err
+}
+
+// Get reads the file of key
+func (d Dir) Get(key string) ([]byte, error) {
+	return ioutil.ReadFile(filepath.Join(string(d), filepath.FromSlash(key)))
+}
+
+// bucket stores the batches as objects in S3, or in Google Cloud Storage
+// through its S3 compatible XML API
+type bucket struct {
+	base   string // every key is appended to it
+	prefix string
+	region string
+	creds  sigv4.Credentials
+	client *http.Client
+}
+
+// batchSuffix ends the names of the batches
+const batchSuffix = ".ndjson.gz"
+
+func newBucket(u *url.URL, cfg SinkConfig) (*bucket, error) {
+	if u.Host == "" {
+		return nil, fmt.Errorf("archive: %q has no bucket", cfg.URL)
+	}
+	if cfg.Credentials.AccessKey == "" || cfg.Credentials.SecretKey == "" {
+		return nil, fmt.Errorf("archive: %s needs an access key and a secret key", u.Scheme)
+	}
+	b := &bucket{
+		prefix: strings.Trim(u.Path, "/"),
+		region: cfg.Region,
+		creds:  cfg.Credentials,
+		client: &http.Client{Timeout: time.Minute},
+	}
+	switch {
+	case cfg.Endpoint != "":
+		b.base = strings.TrimSuffix(cfg.Endpoint, "/") + "/" + u.Host + "/"
+	case u.Scheme == "gs":
+		b.base = "https://storage.googleapis.com/" + u.Host + "/"
+		if b.region == "" {
+			b.region = "auto"
+		}
+	default:
+		if b.region == "" {
+			return nil, fmt.Errorf("archive: s3 needs the bucket's region")
+		}
+		b.base = "https://" + u.Host + ".s3." + b.region + ".amazonaws.com/"
+	}
+	if b.region == "" {
+		b.region = "us-east-1"
+	}
+	if b.prefix != "" {
+		b.prefix += "/"
+	}
+	return b, nil
+}
+
+// Put uploads data in a single request, batches are small enough
+func (b *bucket) Put(key string, data []byte) error {
+	req, err := http.NewRequest("PUT", b.base+b.prefix+key, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	// not Content-Encoding, downloads would be decompressed and no longer match their .gz names
+	req.Header.Set("Content-Type", "application/gzip")
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.HexSHA256(data))
+	sigv4.Sign(req, data, b.creds, b.region, "s3", time.Now())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("archive: PUT %s answered %s: %s", key, resp.Status, bytes.TrimSpace(body))
+	}
+	return nil
+}
+
+// List pages through ListObjectsV2
+func (b *bucket) List(prefix string) ([]string, error) {
+	var keys []string
+	token := ""
+	for {
+		q := url.Values{"list-type": {"2"}, "prefix": {b.prefix + prefix}}
+		if token != "" {
+			q.Set("continuation-token", token)
+		}
+		body, err := b.get(b.base+"?"+q.Encode(), "list "+prefix)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Contents []struct {
+				Key string
+			}
+			IsTruncated           bool
+			NextContinuationToken string
+		}
+		if err := xml.Unmarshal(body, &page); err != nil {
+			return nil, fmt.Errorf("archive: unreadable listing: %v", err)
+		}
+		for _, c := range page.Contents {
+			if strings.HasSuffix(c.Key, batchSuffix) {
+				keys = append(keys, strings.TrimPrefix(c.Key, b.prefix))
+			}
+		}
+		if !page.IsTruncated || page.NextContinuationToken == "" {
+			break
+		}
+		token = page.NextContinuationToken
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// Get downloads the object of key
+func (b *bucket) Get(key string) ([]byte, error) {
+	return b.get(b.base+b.prefix+key, key)
+}
+
+func (b *bucket) get(target, what string) ([]byte, error) {
+	req, err := http.NewRequest("GET", target, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Amz-Content-Sha256", sigv4.HexSHA256(nil))
+	sigv4.Sign(req, nil, b.creds, b.region, "s3", time.Now())
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return nil, fmt.Errorf("archive: GET %s answered %s: %s", what, resp.Status, bytes.TrimSpace(body))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,183 @@
+// Package audit samples a small share of the votes for people to spot-check
+// how well the options match, without keeping every tweet.
+//
+// The votes are sampled per option, each option a stratum of its own: an
+// option is sampled at Fraction, or higher when that would leave it fewer
+// than MinPerOption samples a Window, so the quiet options are checked as
+// well as the busy ones. A vote standing for several, the vote of a sampled
+// poll, is as many times as likely to be kept. Every sample carries the
+// probability it was kept with and how many votes it stands for, its weight,
+// so what the reviewers find adds up to an estimate for all the votes.
+package audit
+
+import (
+	"encoding/json"
+	"hash/fnv"
+	"log"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Topic is the NSQ topic samples are published on
+const Topic = "audit"
+
+var (
+	sampled = metrics.NewCounter("tweetreader_audit_samples_total",
+		"Votes sampled for audit and published to the audit topic.")
+	sampleErrors = metrics.NewCounter("tweetreader_audit_publish_errors_total",
+		"Votes sampled for audit that couldn't be published.")
+)
+
+// Publisher is the part of publish.Publisher samples are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Config is how many votes are sampled and where they go
+type Config struct {
+	// Fraction is the share of the votes of every option sampled, e.g. 0.001
+	Fraction float64
+	// MinPerOption is how many votes of every option are sampled at least a
+	// Window, going by its votes the Window before; 5 by default
+	MinPerOption int
+	// Window is what MinPerOption is counted over, an hour by default
+	Window time.Duration
+	// Publisher publishes the samples as Sample in JSON, when nil they are
+	// only logged, for dry runs
+	Publisher Publisher
+}
+
+// Sample is a vote sampled for audit
+type Sample struct {
+	Vote match.Vote `json:"vote"`
+	// Probability is how likely the vote was to be sampled
+	Probability float64 `json:"probability"`
+	// StandsFor is how many votes for the option the sample stands for, the
+	// vote's own divided by Probability
+	StandsFor float64   `json:"stands_for"`
+	SampledAt time.Time `json:"sampled_at"`
+}
+
+// stratum is what is known of the votes of an option
+type stratum struct {
+	votes    int // this window
+	previous int // the window before, -1 in the first
+}
+
+// Sampler samples the votes of every option
+type Sampler struct {
+	cfg Config
+
+	mu      sync.Mutex
+	options map[string]*stratum
+	start   time.Time // of the window
+	now     func() time.Time
+}
+
+// New creates a Sampler with cfg
+func New(cfg Config) *Sampler {
+	if cfg.MinPerOption == 0 {
+		cfg.MinPerOption = 5
+	}
+	if cfg.Window <= 0 {
+		cfg.Window = time.Hour
+	}
+	return &Sampler{cfg: cfg, options: make(map[string]*stratum), now: time.Now}
+}
+
+// probability returns how likely a vote for option standing for votes is to
+// be sampled, counting it in the option's stratum
+func (s *Sampler) probability(option string, votes int) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	now := s.now()
+	if s.start.IsZero() {
+		s.start = now
+	}
+	if now.Sub(s.start) >= s.cfg.Window {
+		for o, st := range s.options {
+			if st.votes == 0 {
+				// a stratum without votes starts again, as a new option
+				delete(s.options, o)
+				continue
+			}
+			st.previous, st.votes = st.votes, 0
+		}
+		s.start = now
+	}
+	st := s.options[option]
+	if st == nil {
+		st = &stratum{previous: -1}
+		s.options[option] = st
+	}
+	st.votes += votes
+	p := s.cfg.Fraction
+	switch floor := float64(s.cfg.MinPerOption); {
+	case st.previous < 0 && float64(st.votes) <= floor:
+		// nothing known of the option yet, its first votes make up its minimum
+		p = 1
+	case st.previous > 0:
+		p = math.Max(p, floor/float64(st.previous))
+	}
+	return math.Min(1, p*float64(votes))
+}
+
+// Sample returns v sampled for audit, or false when it isn't
+func (s *Sampler) Sample(v match.Vote) (Sample, bool) {
+	votes := 1
+	if v.Scale > 1 {
+		votes = v.Scale
+	}
+	p := s.probability(v.Option, votes)
+	id := v.MessageID
+	if id == "" {
+		id = match.MessageID(v.ID, v.Option)
+	}
+	// a replica keeps the same votes, and they are kept apart from the ones sampling keeps
+	h := fnv.New64a()
+	h.Write([]byte("audit/" + id))
+	if p < 1 && float64(h.Sum64())/math.MaxUint64 >= p {
+		return Sample{}, false
+	}
+	return Sample{Vote: v, Probability: p, StandsFor: float64(votes) / p, SampledAt: s.now().UTC()}, true
+}
+
+// publish sends sample to the audit topic
+func (s *Sampler) publish(sample Sample) {
+	if s.cfg.Publisher == nil {
+		log.Printf("audit: would sample %s, standing for %.1f votes", sample.Vote.MessageID, sample.StandsFor)
+		return
+	}
+	b, err := json.Marshal(sample)
+	if err == nil {
+		err = s.cfg.Publisher.Publish(b)
+	}
+	if err != nil {
+		log.Println("audit: failed to publish the sample of", sample.Vote.MessageID+":", err)
+		sampleErrors.Inc()
+		return
+	}
+	sampled.Inc()
+}
+
+// Run passes on the votes from in, publishing the ones sampled, the returned
+// channel is closed once in is
+func (s *Sampler) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if s.cfg.Fraction > 0 {
+				if sample, ok := s.Sample(v); ok {
+					s.publish(sample)
+				}
+			}
+			out <- v
+		}
+	}()
+	return out
+}
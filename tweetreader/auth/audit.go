@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"encoding/json"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+)
+
+// Entry is a line of the audit log
+type Entry struct {
+	Time   time.Time `json:"time"`
+	Actor  string    `json:"actor"`
+	Role   string    `json:"role"`
+	Auth   string    `json:"auth"`   // api_key or jwt
+	Action string    `json:"action"` // the method and path
+	Status int       `json:"status"`
+	Remote string    `json:"remote"`
+}
+
+// AuditLog writes the admin actions as a JSON object per line, to a file or to stderr
+type AuditLog struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+	f   *os.File // nil for stderr
+}
+
+// OpenAuditLog appends to the file named, stderr when name is empty
+func OpenAuditLog(name string) (*AuditLog, error) {
+	if name == "" {
+		return &AuditLog{enc: json.NewEncoder(os.Stderr)}, nil
+	}
+	f, err := os.OpenFile(name, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &AuditLog{enc: json.NewEncoder(f), f: f}, nil
+}
+
+// Record writes an entry for the action p took with r
+func (a *AuditLog) Record(r *http.Request, p Principal, action string, status int) {
+	remote, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		remote = r.RemoteAddr
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if err := a.enc.Encode(Entry{
+		Time:   time.Now().UTC(),
+		Actor:  p.Name,
+		Role:   p.Role,
+		Auth:   p.Auth,
+		Action: action,
+		Status: status,
+		Remote: remote,
+	}); err != nil {
+		log.Println("failed to write the audit log:", err)
+	}
+}
+
+// Close closes the file the log writes to
+func (a *AuditLog) Close() error {
+	if a.f == nil {
+		return nil
+	}
+	return a.f.Close()
+}
@@ -0,0 +1,263 @@
+// Package auth authenticates the requests to the admin API and gives callers
+// a role. A request carries an API key, in ?key=, X-API-Key or as a bearer
+// token, or a JWT bearer token signed with HS256 or RS256. Viewers can read
+// the state of the streamer, operators can also pause, resume and refresh it.
+// The roles are the ones the rest-api uses, poll admins can also change the
+// status of polls.
+package auth
+
+import (
+	"bufio"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Roles, each can do what the ones before it can
+const (
+	Viewer    = "viewer"
+	PollAdmin = "poll-admin"
+	Operator  = "operator"
+)
+
+var ranks = map[string]int{Viewer: 1, PollAdmin: 2, Operator: 3}
+
+// leeway is how far the clocks of the token issuer and the streamer may drift apart
+const leeway = 30 * time.Second
+
+// Principal is who made a request
+type Principal struct {
+	Name string // the API key's name or the token's subject
+	Role string
+	Auth string // api_key or jwt
+}
+
+// Can reports whether p has role, or one above it
+func (p Principal) Can(role string) bool {
+	return ranks[p.Role] >= ranks[role]
+}
+
+// Config is where the credentials come from, all optional
+type Config struct {
+	Keys          map[string]Principal // more API keys, by key
+	KeysFile      string               // lines of name, role and key
+	Secret        []byte               // the HS256 key
+	PublicKeyFile string               // a PEM file with the RS256 public key
+	Issuer        string               // checked when set
+	Audience      string               // checked when set
+}
+
+// Authenticator checks the credentials of requests
+type Authenticator struct {
+	keys      map[[sha256.Size]byte]Principal // by the SHA-256 of the key, so lookups don't leak it through timing
+	secret    []byte
+	publicKey *rsa.PublicKey
+	issuer    string
+	audience  string
+	now       func() time.Time
+}
+
+// New loads the API keys and the JWT keys of cfg
+func New(cfg Config) (*Authenticator, error) {
+	a := &Authenticator{
+		keys:     make(map[[sha256.Size]byte]Principal),
+		secret:   cfg.Secret,
+		issuer:   cfg.Issuer,
+		audience: cfg.Audience,
+		now:      time.Now,
+	}
+	for key, p := range cfg.Keys {
+		a.keys[sha256.Sum256([]byte(key))] = p
+	}
+	if cfg.KeysFile != "" {
+		if err := a.loadKeys(cfg.KeysFile); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.PublicKeyFile != "" {
+		b, err := ioutil.ReadFile(cfg.PublicKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(b)
+		if block == nil {
+			return nil, fmt.Errorf("no PEM key in %s", cfg.PublicKeyFile)
+		}
+		key, err := x509.ParsePKIXPublicKey(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", cfg.PublicKeyFile, err)
+		}
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("%s: not an RSA public key", cfg.PublicKeyFile)
+		}
+		a.publicKey = rsaKey
+	}
+	return a, nil
+}
+
+// loadKeys reads API keys, a line per key with its name, role and the key
+// itself separated by spaces. Blank lines and lines starting with # are skipped.
+func (a *Authenticator) loadKeys(name string) error {
+	f, err := os.Open(name)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 3 {
+			return fmt.Errorf("%s:%d: want a name, a role and a key", name, line)
+		}
+		if ranks[fields[1]] == 0 {
+			return fmt.Errorf("%s:%d: unknown role %q, want viewer, poll-admin or operator", name, line, fields[1])
+		}
+		a.keys[sha256.Sum256([]byte(fields[2]))] = Principal{Name: fields[0], Role: fields[1], Auth: "api_key"}
+	}
+	return scanner.Err()
+}
+
+// Authenticate returns who made r
+func (a *Authenticator) Authenticate(r *http.Request) (Principal, error) {
+	key := r.URL.Query().Get("key")
+	if key == "" {
+		key = r.Header.Get("X-API-Key")
+	}
+	if key == "" {
+		bearer := r.Header.Get("Authorization")
+		if !strings.HasPrefix(bearer, "Bearer ") {
+			return Principal{}, errors.New("missing API key or bearer token")
+		}
+		token := strings.TrimSpace(strings.TrimPrefix(bearer, "Bearer "))
+		if strings.Count(token, ".") == 2 {
+			return a.verifyJWT(token)
+		}
+		key = token // an API key as the bearer token
+	}
+	p, ok := a.keys[sha256.Sum256([]byte(key))]
+	if !ok {
+		return Principal{}, errors.New("invalid API key")
+	}
+	return p, nil
+}
+
+// claims are the claims read from a token. The role is role, or the highest
+// of roles, so tokens from identity providers listing several work.
+type claims struct {
+	Subject   string          `json:"sub"`
+	Issuer    string          `json:"iss"`
+	Audience  json.RawMessage `json:"aud"` // a string or a list
+	ExpiresAt *float64        `json:"exp"`
+	NotBefore *float64        `json:"nbf"`
+	Role      string          `json:"role"`
+	Roles     []string        `json:"roles"`
+}
+
+// verifyJWT checks the signature and the claims of a compact JWT
+func (a *Authenticator) verifyJWT(token string) (Principal, error) {
+	parts := strings.Split(token, ".")
+	var header struct {
+		Alg string `json:"alg"`
+	}
+	if err := decodePart(parts[0], &header); err != nil {
+		return Principal{}, fmt.Errorf("invalid token header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return Principal{}, errors.New("invalid token signature")
+	}
+	signed := []byte(parts[0] + "." + parts[1])
+	switch {
+	case header.Alg == "HS256" && a.secret != nil:
+		mac := hmac.New(sha256.New, a.secret)
+		mac.Write(signed)
+		if !hmac.Equal(mac.Sum(nil), sig) {
+			return Principal{}, errors.New("invalid token signature")
+		}
+	case header.Alg == "RS256" && a.publicKey != nil:
+		sum := sha256.Sum256(signed)
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, sum[:], sig); err != nil {
+			return Principal{}, errors.New("invalid token signature")
+		}
+	default:
+		return Principal{}, fmt.Errorf("tokens signed with %q aren't accepted", header.Alg)
+	}
+
+	var c claims
+	if err := decodePart(parts[1], &c); err != nil {
+		return Principal{}, fmt.Errorf("invalid token claims: %v", err)
+	}
+	now := a.now()
+	if c.ExpiresAt == nil {
+		return Principal{}, errors.New("the token has no expiry")
+	}
+	if now.After(unixTime(*c.ExpiresAt).Add(leeway)) {
+		return Principal{}, errors.New("the token expired")
+	}
+	if c.NotBefore != nil && now.Add(leeway).Before(unixTime(*c.NotBefore)) {
+		return Principal{}, errors.New("the token isn't valid yet")
+	}
+	if a.issuer != "" && c.Issuer != a.issuer {
+		return Principal{}, errors.New("the token has the wrong issuer")
+	}
+	if a.audience != "" && !hasAudience(c.Audience, a.audience) {
+		return Principal{}, errors.New("the token isn't meant for this API")
+	}
+	p := Principal{Name: c.Subject, Auth: "jwt"}
+	for _, role := range append(c.Roles, c.Role) {
+		if ranks[role] > ranks[p.Role] {
+			p.Role = role
+		}
+	}
+	if p.Role == "" {
+		return Principal{}, errors.New("the token has no role")
+	}
+	return p, nil
+}
+
+func decodePart(part string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func unixTime(seconds float64) time.Time {
+	return time.Unix(0, int64(seconds*float64(time.Second)))
+}
+
+// hasAudience reports whether aud, a string or a list of them, has want
+func hasAudience(aud json.RawMessage, want string) bool {
+	var one string
+	if json.Unmarshal(aud, &one) == nil {
+		return one == want
+	}
+	var many []string
+	if json.Unmarshal(aud, &many) != nil {
+		return false
+	}
+	for _, a := range many {
+		if a == want {
+			return true
+		}
+	}
+	return false
+}
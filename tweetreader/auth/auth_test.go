@@ -0,0 +1,213 @@
+package auth
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2024, 10, 14, 12, 0, 0, 0, time.UTC)
+
+// token is a compact JWT of claims, with alg in its header, signed with sign
+func token(t *testing.T, alg string, c map[string]interface{}, sign func([]byte) []byte) string {
+	t.Helper()
+	enc := func(v interface{}) string {
+		b, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return base64.RawURLEncoding.EncodeToString(b)
+	}
+	signed := enc(map[string]string{"alg": alg, "typ": "JWT"}) + "." + enc(c)
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sign([]byte(signed)))
+}
+
+func withHMAC(key []byte) func([]byte) []byte {
+	return func(b []byte) []byte {
+		mac := hmac.New(sha256.New, key)
+		mac.Write(b)
+		return mac.Sum(nil)
+	}
+}
+
+func withRSA(key *rsa.PrivateKey) func([]byte) []byte {
+	return func(b []byte) []byte {
+		sum := sha256.Sum256(b)
+		sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, sum[:])
+		return sig
+	}
+}
+
+// operatorClaims are an operator's claims for the tests' issuer and
+// audience, valid an hour more, with changes; a nil change removes the claim
+func operatorClaims(changes map[string]interface{}) map[string]interface{} {
+	c := map[string]interface{}{"sub": "deploy", "iss": "https://id.example.com", "aud": "tweetreader", "exp": now.Add(time.Hour).Unix(), "role": Operator}
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+			continue
+		}
+		c[k] = v
+	}
+	return c
+}
+
+// newTestAuthenticator accepts the key k-viewer, HS256 tokens signed with
+// secret and RS256 ones signed with key, at now
+func newTestAuthenticator(t *testing.T, secret []byte, key *rsa.PrivateKey) *Authenticator {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := filepath.Join(t.TempDir(), "jwt.pem")
+	if err := ioutil.WriteFile(pub, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	a, err := New(Config{
+		Keys:          map[string]Principal{"k-viewer": {Name: "dashboard", Role: Viewer, Auth: "api_key"}},
+		Secret:        secret,
+		PublicKeyFile: pub,
+		Issuer:        "https://id.example.com",
+		Audience:      "tweetreader",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	a.now = func() time.Time { return now }
+	return a
+}
+
+func TestAuthenticate(t *testing.T) {
+	secret := []byte("s3cret")
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stranger, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, _ := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	unsigned := func([]byte) []byte { return nil }
+	viewerClaims := operatorClaims(map[string]interface{}{"role": Viewer})
+	forged := func(tok string) string {
+		parts := strings.Split(tok, ".")
+		c, _ := json.Marshal(operatorClaims(nil))
+		return parts[0] + "." + base64.RawURLEncoding.EncodeToString(c) + "." + parts[2]
+	}
+	a := newTestAuthenticator(t, secret, key)
+
+	tests := []struct {
+		name   string
+		query  string
+		bearer string
+		role   string // of the principal, none when refused
+		err    string
+	}{
+		{"an API key", "?key=k-viewer", "", Viewer, ""},
+		{"an API key as the bearer token", "", "k-viewer", Viewer, ""},
+		{"an unknown API key", "?key=k-guess", "", "", "invalid API key"},
+		{"no credentials", "", "", "", "missing API key or bearer token"},
+		{"HS256", "", token(t, "HS256", operatorClaims(nil), withHMAC(secret)), Operator, ""},
+		{"RS256", "", token(t, "RS256", viewerClaims, withRSA(key)), Viewer, ""},
+		{"alg none", "", token(t, "none", operatorClaims(nil), unsigned), "", `tokens signed with "none" aren't accepted`},
+		{"alg NONE", "", token(t, "NONE", operatorClaims(nil), unsigned), "", "aren't accepted"},
+		{"RS384", "", token(t, "RS384", operatorClaims(nil), withRSA(key)), "", `tokens signed with "RS384" aren't accepted`},
+		{"HS256 keyed with the RSA public key", "", token(t, "HS256", operatorClaims(nil), withHMAC(public)), "", "invalid token signature"},
+		{"HS256 with an unknown secret", "", token(t, "HS256", operatorClaims(nil), withHMAC([]byte("guess"))), "", "invalid token signature"},
+		{"RS256 with an unknown key", "", token(t, "RS256", operatorClaims(nil), withRSA(stranger)), "", "invalid token signature"},
+		{"a viewer's token made an operator's", "", forged(token(t, "HS256", viewerClaims, withHMAC(secret))), "", "invalid token signature"},
+		{"an RS256 viewer's token made an operator's", "", forged(token(t, "RS256", viewerClaims, withRSA(key))), "", "invalid token signature"},
+		{"no exp", "", token(t, "HS256", operatorClaims(map[string]interface{}{"exp": nil}), withHMAC(secret)), "", "the token has no expiry"},
+		{"expired", "", token(t, "HS256", operatorClaims(map[string]interface{}{"exp": now.Add(-leeway - time.Second).Unix()}), withHMAC(secret)), "", "the token expired"},
+		{"expired within the leeway", "", token(t, "HS256", operatorClaims(map[string]interface{}{"exp": now.Add(-leeway + time.Second).Unix()}), withHMAC(secret)), Operator, ""},
+		{"not valid before a minute", "", token(t, "HS256", operatorClaims(map[string]interface{}{"nbf": now.Add(time.Minute).Unix()}), withHMAC(secret)), "", "the token isn't valid yet"},
+		{"another issuer's", "", token(t, "HS256", operatorClaims(map[string]interface{}{"iss": "https://evil.example.com"}), withHMAC(secret)), "", "the token has the wrong issuer"},
+		{"for another API", "", token(t, "HS256", operatorClaims(map[string]interface{}{"aud": []string{"rest-api"}}), withHMAC(secret)), "", "the token isn't meant for this API"},
+		{"without a role", "", token(t, "HS256", operatorClaims(map[string]interface{}{"role": nil}), withHMAC(secret)), "", "the token has no role"},
+		{"the highest role listed", "", token(t, "HS256", operatorClaims(map[string]interface{}{"role": nil, "roles": []string{PollAdmin, Viewer}}), withHMAC(secret)), PollAdmin, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest("GET", "/admin/state"+tt.query, nil)
+			if tt.bearer != "" {
+				r.Header.Set("Authorization", "Bearer "+tt.bearer)
+			}
+			p, err := a.Authenticate(r)
+			switch {
+			case tt.err == "" && err != nil:
+				t.Errorf("Authenticate = %v", err)
+			case tt.err == "" && p.Role != tt.role:
+				t.Errorf("a %q, want a %q", p.Role, tt.role)
+			case tt.err != "" && err == nil:
+				t.Errorf("accepted as %+v, want %q", p, tt.err)
+			case tt.err != "" && !strings.Contains(err.Error(), tt.err):
+				t.Errorf("Authenticate = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestCan(t *testing.T) {
+	tests := []struct {
+		role string
+		can  map[string]bool
+	}{
+		{Viewer, map[string]bool{Viewer: true, PollAdmin: false, Operator: false}},
+		{PollAdmin, map[string]bool{Viewer: true, PollAdmin: true, Operator: false}},
+		{Operator, map[string]bool{Viewer: true, PollAdmin: true, Operator: true}},
+		{"", map[string]bool{Viewer: false, PollAdmin: false, Operator: false}},
+	}
+	for _, tt := range tests {
+		for role, want := range tt.can {
+			if got := (Principal{Role: tt.role}).Can(role); got != want {
+				t.Errorf("a %q can %s: %v, want %v", tt.role, role, got, want)
+			}
+		}
+	}
+}
+
+func TestNewReadsTheKeys(t *testing.T) {
+	tests := []struct {
+		name string
+		file string
+		err  string
+	}{
+		{"keys with comments", "# name role key\nci operator k-ci\n\n", ""},
+		{"a line short of a key", "ci operator\n", "want a name, a role and a key"},
+		{"a role that isn't one", "ci admin k-ci\n", `unknown role "admin"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			name := filepath.Join(t.TempDir(), "keys")
+			if err := ioutil.WriteFile(name, []byte(tt.file), 0600); err != nil {
+				t.Fatal(err)
+			}
+			a, err := New(Config{KeysFile: name})
+			switch {
+			case tt.err == "" && err != nil:
+				t.Fatalf("New = %v", err)
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Fatalf("New = %v, want %q", err, tt.err)
+			case tt.err != "":
+				return
+			}
+			p, err := a.Authenticate(httptest.NewRequest("GET", "/admin/state?key=k-ci", nil))
+			if err != nil || p.Role != Operator || p.Name != "ci" {
+				t.Errorf("Authenticate = %+v, %v", p, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,180 @@
+// Package breaker stops calling a dependency that keeps failing.
+//
+// After Threshold failures in a row the breaker opens and calls are refused
+// for a cool-down, instead of retrying in a tight loop. Then one call is let
+// through as a probe (half-open): if it succeeds the breaker closes again,
+// if it fails the breaker reopens for twice as long, up to MaxCooldown.
+package breaker
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// States of a breaker, also the values of tweetreader_breaker_state
+const (
+	Closed   = 0
+	HalfOpen = 1
+	Open     = 2
+)
+
+var (
+	stateGauge = metrics.NewGauge("tweetreader_breaker_state",
+		"Circuit breaker state per dependency: 0 closed, 1 half-open (probing), 2 open.")
+	trips = metrics.NewCounter("tweetreader_breaker_trips_total",
+		"Times the circuit breaker of a dependency opened.")
+)
+
+// Config describes when a breaker opens and for how long
+type Config struct {
+	// Threshold is how many failures in a row open the breaker, 0 never opens it
+	Threshold int
+	// Cooldown is how long the breaker stays open the first time
+	Cooldown time.Duration
+	// MaxCooldown caps the cool-down as failed probes double it, 10 times Cooldown when 0
+	MaxCooldown time.Duration
+	// Clock times the cool-downs, clock.Real when nil
+	Clock clock.Clock
+}
+
+// Breaker guards one dependency. A nil Breaker allows everything.
+type Breaker struct {
+	name string
+	cfg  Config
+	now  func() time.Time
+
+	mu       sync.Mutex
+	state    int
+	failures int           // in a row, while closed
+	cooldown time.Duration // of the current or next opening
+	until    time.Time     // when an open breaker lets a probe through
+	failing  time.Time     // when it last opened after being closed, zero while closed
+}
+
+// New creates a closed breaker for the dependency called name
+func New(name string, cfg Config) *Breaker {
+	if cfg.MaxCooldown <= 0 {
+		cfg.MaxCooldown = 10 * cfg.Cooldown
+	}
+	b := &Breaker{name: name, cfg: cfg, now: clock.Or(cfg.Clock).Now, cooldown: cfg.Cooldown}
+	stateGauge.Set(Closed, "dependency", name)
+	return b
+}
+
+// Allow reports whether a call may go ahead. Once the cool-down is over it
+// allows a single probe, whose outcome must be reported with Success or Failure.
+func (b *Breaker) Allow() bool {
+	if b == nil {
+		return true
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if b.now().Before(b.until) {
+			return false
+		}
+		b.set(HalfOpen)
+		log.Printf("breaker: %s half-open, probing", b.name)
+		return true
+	case HalfOpen:
+		return false // the probe is still out
+	}
+	return true
+}
+
+// Wait is how long until Allow lets a probe through, 0 when it would now
+func (b *Breaker) Wait() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Open {
+		return 0
+	}
+	if d := b.until.Sub(b.now()); d > 0 {
+		return d
+	}
+	return 0
+}
+
+// Success reports a call that worked, closing the breaker
+func (b *Breaker) Success() {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state != Closed {
+		log.Printf("breaker: %s closed, the probe succeeded", b.name)
+	}
+	b.failures = 0
+	b.cooldown = b.cfg.Cooldown
+	b.set(Closed)
+}
+
+// Failure reports a call that failed, opening the breaker after Threshold in a row
+// or when it was the probe
+func (b *Breaker) Failure(err error) {
+	if b == nil || b.cfg.Threshold <= 0 {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Closed:
+		if b.failures++; b.failures < b.cfg.Threshold {
+			return
+		}
+		b.failures = 0
+	case HalfOpen:
+		if b.cooldown *= 2; b.cooldown > b.cfg.MaxCooldown {
+			b.cooldown = b.cfg.MaxCooldown
+		}
+	case Open:
+		return
+	}
+	b.until = b.now().Add(b.cooldown)
+	b.set(Open)
+	trips.Inc("dependency", b.name)
+	log.Printf("breaker: %s open for %s after: %v", b.name, b.cooldown, err)
+}
+
+// State returns Closed, HalfOpen or Open
+func (b *Breaker) State() int {
+	if b == nil {
+		return Closed
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state
+}
+
+// FailingFor is how long the breaker has been open or half-open, 0 while closed
+func (b *Breaker) FailingFor() time.Duration {
+	if b == nil {
+		return 0
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.failing.IsZero() {
+		return 0
+	}
+	return b.now().Sub(b.failing)
+}
+
+func (b *Breaker) set(state int) {
+	switch {
+	case state == Closed:
+		b.failing = time.Time{}
+	case b.failing.IsZero():
+		b.failing = b.now()
+	}
+	b.state = state
+	stateGauge.Set(float64(state), "dependency", b.name)
+}
@@ -0,0 +1,116 @@
+package breaker
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+)
+
+// step is a call through the breaker, after the clock moved on by after
+type step struct {
+	after   time.Duration
+	fails   bool // how the call went, when it was allowed
+	allowed bool
+	state   int // once the call's outcome is reported
+}
+
+func TestBreakerCooldowns(t *testing.T) {
+	cfg := Config{Threshold: 2, Cooldown: 10 * time.Second, MaxCooldown: 30 * time.Second}
+	tests := []struct {
+		name  string
+		steps []step
+	}{
+		{"opens after threshold failures in a row", []step{
+			{0, true, true, Closed},
+			{0, true, true, Open},
+			{0, false, false, Open},
+		}},
+		{"a success in between keeps it closed", []step{
+			{0, true, true, Closed},
+			{0, false, true, Closed},
+			{0, true, true, Closed},
+		}},
+		{"probes once the cool-down is over", []step{
+			{0, true, true, Closed},
+			{0, true, true, Open},
+			{9 * time.Second, false, false, Open},
+			{time.Second, false, true, Closed},
+			{0, true, true, Closed},
+		}},
+		{"a failed probe doubles the cool-down, up to the max", []step{
+			{0, true, true, Closed},
+			{0, true, true, Open},
+			{10 * time.Second, true, true, Open},
+			{19 * time.Second, false, false, Open},
+			{time.Second, true, true, Open},
+			{30 * time.Second, false, true, Closed},
+		}},
+		{"a success resets the cool-down", []step{
+			{0, true, true, Closed},
+			{0, true, true, Open},
+			{10 * time.Second, true, true, Open}, // 20s now
+			{20 * time.Second, false, true, Closed},
+			{0, true, true, Closed},
+			{0, true, true, Open},
+			{10 * time.Second, false, true, Closed},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clk := clock.NewFake(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+			c := cfg
+			c.Clock = clk
+			b := New("test", c)
+			for i, s := range tt.steps {
+				clk.Advance(s.after)
+				allowed := b.Allow()
+				if allowed != s.allowed {
+					t.Fatalf("step %d: Allow = %v, want %v", i, allowed, s.allowed)
+				}
+				if allowed && s.fails {
+					b.Failure(errors.New("unavailable"))
+				} else if allowed {
+					b.Success()
+				}
+				if got := b.State(); got != s.state {
+					t.Fatalf("step %d: state %d, want %d", i, got, s.state)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerWaitAndFailingFor(t *testing.T) {
+	clk := clock.NewFake(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+	b := New("test", Config{Threshold: 1, Cooldown: time.Minute, Clock: clk})
+	tests := []struct {
+		after   time.Duration
+		wait    time.Duration
+		failing time.Duration
+	}{
+		{0, time.Minute, 0},
+		{20 * time.Second, 40 * time.Second, 20 * time.Second},
+		{40 * time.Second, 0, time.Minute},
+		{time.Hour, 0, time.Hour + time.Minute},
+	}
+	b.Failure(errors.New("unavailable"))
+	for i, tt := range tests {
+		clk.Advance(tt.after)
+		if got := b.Wait(); got != tt.wait {
+			t.Errorf("%d: Wait = %s, want %s", i, got, tt.wait)
+		}
+		if got := b.FailingFor(); got != tt.failing {
+			t.Errorf("%d: FailingFor = %s, want %s", i, got, tt.failing)
+		}
+	}
+}
+
+func TestNilBreaker(t *testing.T) {
+	var b *Breaker
+	b.Failure(errors.New("unavailable"))
+	if !b.Allow() || b.State() != Closed || b.Wait() != 0 {
+		t.Error("a nil breaker refuses calls")
+	}
+}
@@ -0,0 +1,222 @@
+// Package budget gives each component of a process an error budget and a log budget.
+//
+// A component reports every attempt it makes, publishing a vote or connecting
+// to a stream, and once most of them fail within the window (Threshold of at
+// least MinEvents) its budget is exhausted: the process is told to pause
+// ingestion for a while instead of burning CPU on a dependency that is down,
+// and Degraded reports it until the pause is over, for the readiness probe.
+//
+// The log budget caps the lines a component logs a minute, so a failure
+// repeated for every vote doesn't flood the logs; the lines over the budget
+// are counted and summed up once the minute is over.
+package budget
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Window is how far back the failure ratio looks
+const Window = time.Minute
+
+// slots is how many parts Window is split into, the oldest part is dropped as time moves on
+const slots = 6
+
+const slotWidth = Window / slots
+
+var (
+	ratioGauge = metrics.NewGauge("tweetreader_budget_failure_ratio",
+		"Share of a component's attempts that failed within the last minute.")
+	exhaustedGauge = metrics.NewGauge("tweetreader_budget_exhausted",
+		"1 while a component's error budget is exhausted and ingestion paused for it.")
+	exhaustions = metrics.NewCounter("tweetreader_budget_exhaustions_total",
+		"Times a component's error budget was exhausted.")
+	suppressed = metrics.NewCounter("tweetreader_budget_suppressed_logs_total",
+		"Log lines of a component not written because its log budget was spent.")
+)
+
+// Config is when the budgets run out
+type Config struct {
+	// Threshold is the share of failed attempts exhausting a budget, 0.9 when
+	// 90% of them fail; 0 never exhausts one
+	Threshold float64
+	// MinEvents is how many attempts the window needs before its ratio counts, 20 by default
+	MinEvents int
+	// Pause is how long an exhausted budget pauses ingestion, 30s by default
+	Pause time.Duration
+	// LogsPerMinute is how many lines a component logs a minute, 0 for no limit
+	LogsPerMinute int
+}
+
+// Status is where a component's budgets stand
+type Status struct {
+	Component      string    `json:"component"`
+	Attempts       int       `json:"attempts"`
+	Failures       int       `json:"failures"`
+	FailureRatio   float64   `json:"failure_ratio"`
+	Exhausted      bool      `json:"exhausted"`
+	ExhaustedUntil time.Time `json:"exhausted_until"`
+	SuppressedLogs int       `json:"suppressed_logs"` // in the current minute
+}
+
+// component is the budgets of one component
+type component struct {
+	seq       [slots]int64
+	ok        [slots]int
+	failed    [slots]int
+	until     time.Time // exhausted until then
+	logged    int       // lines logged in the minute logMinute
+	dropped   int       // lines not logged in that minute
+	logMinute int64
+}
+
+var (
+	mu         sync.Mutex
+	cfg        Config
+	onExhaust  func(Status)
+	components = make(map[string]*component)
+)
+
+// Configure sets when the budgets run out and what to do when one does,
+// exhausted is called on its own goroutine
+func Configure(c Config, exhausted func(Status)) {
+	if c.MinEvents <= 0 {
+		c.MinEvents = 20
+	}
+	if c.Pause <= 0 {
+		c.Pause = 30 * time.Second
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	cfg = c
+	onExhaust = exhausted
+}
+
+// get returns the budgets of name, mu held
+func get(name string) *component {
+	c, ok := components[name]
+	if !ok {
+		c = &component{}
+		components[name] = c
+	}
+	return c
+}
+
+// Observe records an attempt of a component, exhausting its budget when too many failed
+func Observe(name string, ok bool) {
+	now := time.Now()
+	n := now.UnixNano() / int64(slotWidth)
+	i := int(n % slots)
+	mu.Lock()
+	c := get(name)
+	if c.seq[i] != n {
+		c.seq[i] = n
+		c.ok[i], c.failed[i] = 0, 0
+	}
+	if ok {
+		c.ok[i]++
+	} else {
+		c.failed[i]++
+	}
+	st := c.status(name, now)
+	ratioGauge.Set(st.FailureRatio, "component", name)
+	exhaust := cfg.Threshold > 0 && !st.Exhausted && st.Attempts >= cfg.MinEvents && st.FailureRatio >= cfg.Threshold
+	if exhaust {
+		c.until = now.Add(cfg.Pause)
+		// the attempts that exhausted it don't count after the pause
+		c.seq = [slots]int64{}
+		st.Exhausted, st.ExhaustedUntil = true, c.until
+	}
+	pause, fn := cfg.Pause, onExhaust
+	mu.Unlock()
+	if !exhaust {
+		return
+	}
+	exhaustions.Inc("component", name)
+	exhaustedGauge.Set(1, "component", name)
+	log.Printf("budget: %d of the last %d attempts of %s failed, pausing for %s", st.Failures, st.Attempts, name, pause)
+	time.AfterFunc(pause, func() { exhaustedGauge.Set(0, "component", name) })
+	if fn != nil {
+		go fn(st)
+	}
+}
+
+// status returns where c stands at now, mu held
+func (c *component) status(name string, now time.Time) Status {
+	n := now.UnixNano() / int64(slotWidth)
+	st := Status{Component: name, SuppressedLogs: c.dropped}
+	for i := range c.seq {
+		if n-c.seq[i] < slots {
+			st.Attempts += c.ok[i] + c.failed[i]
+			st.Failures += c.failed[i]
+		}
+	}
+	if st.Attempts > 0 {
+		st.FailureRatio = float64(st.Failures) / float64(st.Attempts)
+	}
+	if now.Before(c.until) {
+		st.Exhausted, st.ExhaustedUntil = true, c.until
+	}
+	return st
+}
+
+// Logf logs a line of a component, unless it logged its budget of lines this minute already
+func Logf(name, format string, args ...interface{}) {
+	minute := time.Now().Unix() / 60
+	mu.Lock()
+	c := get(name)
+	var dropped int
+	if c.logMinute != minute {
+		dropped = c.dropped
+		c.logMinute, c.logged, c.dropped = minute, 0, 0
+	}
+	write := cfg.LogsPerMinute <= 0 || c.logged < cfg.LogsPerMinute
+	if write {
+		c.logged++
+	} else {
+		c.dropped++
+	}
+	mu.Unlock()
+	if dropped > 0 {
+		log.Printf("budget: %d more log lines of %s weren't logged last minute", dropped, name)
+	}
+	if !write {
+		suppressed.Inc("component", name)
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// Statuses returns where the budget of every component that reported stands, by name
+func Statuses() []Status {
+	now := time.Now()
+	mu.Lock()
+	defer mu.Unlock()
+	all := make([]Status, 0, len(components))
+	for name, c := range components {
+		all = append(all, c.status(name, now))
+	}
+	sort.Slice(all, func(i, j int) bool { return all[i].Component < all[j].Component })
+	return all
+}
+
+// Degraded returns why the process is degraded, the components whose budget
+// is exhausted, or nil
+func Degraded() error {
+	var names []string
+	for _, st := range Statuses() {
+		if st.Exhausted {
+			names = append(names, fmt.Sprintf("%s until %s", st.Component, st.ExhaustedUntil.Format(time.RFC3339)))
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	return fmt.Errorf("error budget exhausted: %s", strings.Join(names, ", "))
+}
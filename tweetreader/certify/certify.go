@@ -0,0 +1,236 @@
+// Package certify signs the final results of closed polls, so they can be
+// published and checked by anyone holding the counter's public key.
+//
+// A certificate is a result document, the poll's final tallies, its counts
+// per source and the results history it got there through, signed with
+// Ed25519. The history entries are chained: the hash of each covers the one
+// before it, so an entry can't be changed, dropped or added in between without
+// breaking every hash after it, and the document names the last, its head.
+package certify
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Version is the version of the result documents signed, bumped whenever what
+// they hold or how the chain is hashed changes
+const Version = 1
+
+// Document is the result document of a closed poll
+type Document struct {
+	Version     int       `json:"version"`
+	Poll        string    `json:"poll"`
+	Title       string    `json:"title"`
+	Options     []string  `json:"options"`
+	CertifiedAt time.Time `json:"certified_at"`
+	// Results and WeightedResults are the poll's final tallies, TotalVotes their sum
+	Results         map[string]int     `json:"results"`
+	WeightedResults map[string]float64 `json:"weighted_results,omitempty"`
+	TotalVotes      int                `json:"total_votes"`
+	// SourceResults are the counts per source and option, SourceVotes the votes per source
+	SourceResults map[string]map[string]int `json:"source_results,omitempty"`
+	SourceVotes   map[string]int            `json:"source_votes,omitempty"`
+	// History is the poll's results history, oldest first, and HistoryHead the
+	// hash of its last entry; both are empty when no history was kept
+	History     []Link `json:"history,omitempty"`
+	HistoryHead string `json:"history_head,omitempty"`
+}
+
+// Link is an entry of the results history, chained to the one before it by its hash
+type Link struct {
+	Time    time.Time      `json:"time"`
+	Results map[string]int `json:"results"`
+	Hash    string         `json:"hash"`
+}
+
+// Certificate is a signed result document. The signature is of the document
+// exactly as it is written, so it is kept as raw JSON.
+type Certificate struct {
+	Document json.RawMessage `json:"document"`
+	// Signature is the Ed25519 signature of Document, in base64
+	Signature string `json:"signature"`
+	// KeyID names the key that signed it, see KeyID, and PublicKey is that
+	// key in base64; check it is the counter's before trusting the certificate
+	KeyID     string `json:"key_id"`
+	PublicKey string `json:"public_key"`
+}
+
+// New returns the result document of p, with the entries of its results history
+func New(p store.Poll, history []store.HistoryEntry, at time.Time) Document {
+	d := Document{
+		Version:         Version,
+		Poll:            p.ID,
+		Title:           p.Title,
+		Options:         p.Options,
+		CertifiedAt:     at.UTC().Truncate(time.Second),
+		Results:         p.Results,
+		WeightedResults: p.WeightedResults,
+		SourceResults:   p.SourceResults,
+	}
+	if d.Results == nil {
+		d.Results = map[string]int{}
+	}
+	for _, n := range p.Results {
+		d.TotalVotes += n
+	}
+	if len(p.SourceResults) > 0 {
+		d.SourceVotes = make(map[string]int, len(p.SourceResults))
+		for source, counts := range p.SourceResults {
+			for _, n := range counts {
+				d.SourceVotes[source] += n
+			}
+		}
+	}
+	prev := ""
+	for _, e := range history {
+		l := Link{Time: e.Time.UTC(), Results: e.Results}
+		l.Hash = l.hash(prev)
+		d.History = append(d.History, l)
+		prev = l.Hash
+	}
+	d.HistoryHead = prev
+	return d
+}
+
+// hash returns the hash of the entry following the one hashed prev, the hex
+// SHA-256 of prev, the time in RFC 3339 and a line "option<TAB>count" per
+// option, sorted, each ended by a newline
+func (l Link) hash(prev string) string {
+	var b bytes.Buffer
+	b.WriteString(prev + "\n")
+	b.WriteString(l.Time.UTC().Format(time.RFC3339Nano) + "\n")
+	options := make([]string, 0, len(l.Results))
+	for o := range l.Results {
+		options = append(options, o)
+	}
+	sort.Strings(options)
+	for _, o := range options {
+		b.WriteString(o + "\t" + strconv.Itoa(l.Results[o]) + "\n")
+	}
+	sum := sha256.Sum256(b.Bytes())
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckChain returns an error unless every hash of the history follows from
+// the entries before it and the last is the head
+func (d Document) CheckChain() error {
+	prev := ""
+	for i, l := range d.History {
+		if h := l.hash(prev); h != l.Hash {
+			return fmt.Errorf("history entry %d (%s) hashes to %s, not %s", i, l.Time.Format(time.RFC3339), h, l.Hash)
+		}
+		prev = l.Hash
+	}
+	if prev != d.HistoryHead {
+		return fmt.Errorf("the history ends in %q, not the head %q", prev, d.HistoryHead)
+	}
+	return nil
+}
+
+// KeyID returns the name of a public key, the first 16 hex digits of its SHA-256
+func KeyID(pub ed25519.PublicKey) string {
+	sum := sha256.Sum256(pub)
+	return hex.EncodeToString(sum[:8])
+}
+
+// Sign returns the certificate of d signed with key, as JSON
+func Sign(d Document, key ed25519.PrivateKey) ([]byte, error) {
+	doc, err := json.Marshal(d)
+	if err != nil {
+		return nil, err
+	}
+	pub := key.Public().(ed25519.PublicKey)
+	return json.Marshal(Certificate{
+		Document:  doc,
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, doc)),
+		KeyID:     KeyID(pub),
+		PublicKey: base64.StdEncoding.EncodeToString(pub),
+	})
+}
+
+// ErrBadSignature is returned for a certificate its key didn't sign
+var ErrBadSignature = errors.New("the signature doesn't match the document")
+
+// Verify checks the certificate in data was signed with pub and its history
+// chain is whole, and returns its document. A nil pub trusts the key the
+// certificate carries, which only shows it wasn't changed since it was signed.
+func Verify(data []byte, pub ed25519.PublicKey) (Document, error) {
+	var c Certificate
+	var d Document
+	if err := json.Unmarshal(data, &c); err != nil {
+		return d, fmt.Errorf("invalid certificate: %v", err)
+	}
+	if pub == nil {
+		b, err := base64.StdEncoding.DecodeString(c.PublicKey)
+		if err != nil || len(b) != ed25519.PublicKeySize {
+			return d, fmt.Errorf("invalid public_key in the certificate")
+		}
+		pub = b
+	} else if c.PublicKey != "" && c.PublicKey != base64.StdEncoding.EncodeToString(pub) {
+		return d, fmt.Errorf("the certificate was signed with key %s, not %s", c.KeyID, KeyID(pub))
+	}
+	sig, err := base64.StdEncoding.DecodeString(c.Signature)
+	if err != nil {
+		return d, fmt.Errorf("invalid signature: %v", err)
+	}
+	if !ed25519.Verify(pub, c.Document, sig) {
+		return d, ErrBadSignature
+	}
+	if err := json.Unmarshal(c.Document, &d); err != nil {
+		return d, fmt.Errorf("invalid document: %v", err)
+	}
+	if d.Version != Version {
+		return d, fmt.Errorf("unknown document version %d, want %d", d.Version, Version)
+	}
+	return d, d.CheckChain()
+}
+
+// ParsePrivateKey reads an Ed25519 private key in PKCS #8 PEM, as written by
+// "openssl genpkey -algorithm ed25519"
+func ParsePrivateKey(data []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	k, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("a %T isn't an Ed25519 key", k)
+	}
+	return key, nil
+}
+
+// ParsePublicKey reads an Ed25519 public key in PKIX PEM, as written by
+// "openssl pkey -pubout"
+func ParsePublicKey(data []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found")
+	}
+	k, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := k.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("a %T isn't an Ed25519 key", k)
+	}
+	return key, nil
+}
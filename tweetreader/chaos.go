@@ -0,0 +1,29 @@
+//go:build chaos
+// +build chaos
+
+package main
+
+// Chaos builds inject the faults listed in CHAOS into the broker, MongoDB and
+// the stream, to check the backoff, spooling and draining under failure.
+// They are never linked into the default build:
+//
+//	go build -tags chaos && CHAOS=broker=0.05,mongo=0.01,stream=0.001 ./twitter-poll stream
+import (
+	"log"
+	"os"
+
+	"github.com/olawolu/twitter-polls/tweetreader/chaos"
+)
+
+func init() {
+	s := os.Getenv("CHAOS")
+	if s == "" {
+		return
+	}
+	cfg, err := chaos.ParseConfig(s)
+	if err != nil {
+		log.Fatalf("invalid CHAOS: %v", err)
+	}
+	log.Printf("CHAOS: injecting faults %s", s)
+	faults = chaos.New(cfg)
+}
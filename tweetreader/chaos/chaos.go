@@ -0,0 +1,217 @@
+// Package chaos injects failures into the pipeline's dependencies, to check
+// the backoff, spooling and draining hold up when they fail: publishes to the
+// broker fail, MongoDB operations time out and the stream is disconnected,
+// each at a probability.
+//
+// It is only wired in by builds with -tags chaos, see chaos.go in the main
+// package, so production builds never inject anything.
+package chaos
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+)
+
+// The faults that can be injected
+const (
+	// Broker fails a publish to the broker
+	Broker = "broker"
+	// Mongo times out a read from or write to MongoDB, and drops the connection
+	Mongo = "mongo"
+	// Stream drops the connection to Twitter while the stream is read
+	Stream = "stream"
+)
+
+var injected = metrics.NewCounter("tweetreader_chaos_faults_total",
+	"Faults injected by chaos testing, by fault.")
+
+// ErrInjected is the error of an injected broker failure
+var ErrInjected = errors.New("chaos: injected failure")
+
+// timeoutError is the error of an injected MongoDB timeout, a net.Error like a real one
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "chaos: injected i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+// Config sets the probability of each fault
+type Config struct {
+	// Broker is the probability of a publish failing
+	Broker float64
+	// Mongo is the probability of a read or write on a MongoDB connection timing out
+	Mongo float64
+	// Stream is the probability of a read from the stream dropping the connection
+	Stream float64
+	// Delay is how long an injected MongoDB timeout takes, 0 to fail right away
+	Delay time.Duration
+	// Seed makes a run repeatable, 0 seeds from the clock
+	Seed int64
+}
+
+// ParseConfig parses the faults written fault=probability and separated by
+// commas, with delay=duration and seed=number, e.g. broker=0.05,mongo=0.01,delay=2s
+func ParseConfig(s string) (Config, error) {
+	var cfg Config
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return cfg, fmt.Errorf("chaos: %q isn't fault=probability", part)
+		}
+		key, value := part[:eq], part[eq+1:]
+		var err error
+		switch key {
+		case Broker, Mongo, Stream:
+			var p float64
+			if p, err = strconv.ParseFloat(value, 64); err == nil && (p < 0 || p > 1) {
+				err = errors.New("not between 0 and 1")
+			}
+			switch key {
+			case Broker:
+				cfg.Broker = p
+			case Mongo:
+				cfg.Mongo = p
+			case Stream:
+				cfg.Stream = p
+			}
+		case "delay":
+			cfg.Delay, err = time.ParseDuration(value)
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		default:
+			return cfg, fmt.Errorf("chaos: unknown fault %q, want broker, mongo, stream, delay or seed", key)
+		}
+		if err != nil {
+			return cfg, fmt.Errorf("chaos: invalid %s %q: %v", key, value, err)
+		}
+	}
+	return cfg, nil
+}
+
+// Injector decides when to inject the faults. A nil Injector injects nothing,
+// its wrappers return what they are given.
+type Injector struct {
+	cfg Config
+
+	mu  sync.Mutex
+	rnd *rand.Rand
+}
+
+// New creates an Injector injecting the faults at the probabilities of cfg
+func New(cfg Config) *Injector {
+	seed := cfg.Seed
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+	return &Injector{cfg: cfg, rnd: rand.New(rand.NewSource(seed))}
+}
+
+// fail reports whether to inject fault, counting it when it is
+func (in *Injector) fail(fault string) bool {
+	var p float64
+	switch fault {
+	case Broker:
+		p = in.cfg.Broker
+	case Mongo:
+		p = in.cfg.Mongo
+	case Stream:
+		p = in.cfg.Stream
+	}
+	if p <= 0 {
+		return false
+	}
+	in.mu.Lock()
+	hit := in.rnd.Float64() < p
+	in.mu.Unlock()
+	if hit {
+		injected.Inc("fault", fault)
+	}
+	return hit
+}
+
+// Publisher wraps pub so its publishes fail at the Broker probability
+func (in *Injector) Publisher(pub publish.Publisher) publish.Publisher {
+	if in == nil || in.cfg.Broker <= 0 {
+		return pub
+	}
+	return &publisher{Publisher: pub, in: in}
+}
+
+type publisher struct {
+	publish.Publisher
+	in *Injector
+}
+
+func (p *publisher) Publish(b []byte) error {
+	if p.in.fail(Broker) {
+		return ErrInjected
+	}
+	return p.Publisher.Publish(b)
+}
+
+// WrapConn returns the function wrapping the connections of fault, Mongo or
+// Stream, nil when that fault isn't injected
+func (in *Injector) WrapConn(fault string) func(net.Conn) net.Conn {
+	if in == nil {
+		return nil
+	}
+	if fault == Mongo && in.cfg.Mongo <= 0 || fault == Stream && in.cfg.Stream <= 0 {
+		return nil
+	}
+	return func(c net.Conn) net.Conn {
+		return &conn{Conn: c, in: in, fault: fault}
+	}
+}
+
+// conn fails reads and writes at the probability of its fault, closing the
+// connection like the server or the network would
+type conn struct {
+	net.Conn
+	in    *Injector
+	fault string
+}
+
+func (c *conn) Read(b []byte) (int, error) {
+	if err := c.inject(); err != nil {
+		return 0, err
+	}
+	return c.Conn.Read(b)
+}
+
+func (c *conn) Write(b []byte) (int, error) {
+	if c.fault == Mongo {
+		// the stream only writes its request, it is dropped while read
+		if err := c.inject(); err != nil {
+			return 0, err
+		}
+	}
+	return c.Conn.Write(b)
+}
+
+// inject returns the error of an injected fault, nil when there is none
+func (c *conn) inject() error {
+	if !c.in.fail(c.fault) {
+		return nil
+	}
+	if c.fault == Mongo {
+		time.Sleep(c.in.cfg.Delay)
+		c.Conn.Close()
+		return timeoutError{}
+	}
+	c.Conn.Close()
+	return io.ErrUnexpectedEOF
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// checkpoint is a source whose place is kept in the store's snapshots
+type checkpoint struct {
+	name string // of its snapshot
+	src  stream.Resumable
+}
+
+// checkpoints keeps where the sources that can resume are, so a restarted
+// streamer doesn't read again what it had read, or count it twice
+type checkpoints struct {
+	db    store.SnapshotStore
+	saved []checkpoint
+}
+
+// restoreCheckpoints restores the place of every pipeline's source that can
+// resume from its last checkpoint, and returns what saves them; their
+// snapshots are named stream_state_ and the account or source
+func restoreCheckpoints(db store.SnapshotStore, pipes []pipeline) *checkpoints {
+	c := &checkpoints{db: db}
+	for _, p := range pipes {
+		src, ok := p.src.(stream.Resumable)
+		if !ok {
+			continue
+		}
+		cp := checkpoint{name: "stream_state_" + p.name, src: src}
+		b, err := db.LoadSnapshot(cp.name)
+		switch err {
+		case nil:
+			if err := src.Restore(b); err != nil {
+				log.Printf("%s: ignoring the last checkpoint: %v", p.name, err)
+			} else {
+				log.Printf("%s: going on from the last checkpoint", p.name)
+			}
+		case store.ErrNoSnapshot:
+		default:
+			// reading it all again is better than not reading at all
+			log.Printf("%s: failed to load the last checkpoint, starting afresh: %v", p.name, err)
+		}
+		c.saved = append(c.saved, cp)
+	}
+	return c
+}
+
+// save saves where every source is
+func (c *checkpoints) save() {
+	for _, cp := range c.saved {
+		b, err := cp.src.State()
+		if err == nil {
+			err = c.db.SaveSnapshot(cp.name, b)
+		}
+		if err != nil {
+			log.Printf("failed to save the checkpoint %s: %v", cp.name, err)
+		}
+	}
+}
+
+// run saves every interval on clk until stop is closed, the interval may change meanwhile
+func (c *checkpoints) run(interval *clock.Interval, clk clock.Clock, stop <-chan struct{}) {
+	if len(c.saved) == 0 {
+		return
+	}
+	interval.Every(clk, stop, c.save)
+}
@@ -0,0 +1,276 @@
+// Package clock is the time the long-running loops wait on: the stream's
+// reconnect backoff, the refreshes, the counter's flushes and snapshots and
+// the breakers' cool-downs.
+//
+// They take a Clock, Real unless set, so a test can hand them a Fake and move
+// time on by hand instead of sleeping. The periods operators may want to
+// change without a restart are Intervals, registered by name: a loop waiting
+// on one starts over with the new period as soon as it is Set, see the
+// admin API's /admin/intervals.
+package clock
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Clock tells the time and waits
+type Clock interface {
+	Now() time.Time
+	// After sends the time on the channel returned once d has passed
+	After(d time.Duration) <-chan time.Time
+	// NewTicker sends the time every d, dropping the ticks a slow reader misses
+	NewTicker(d time.Duration) Ticker
+}
+
+// Ticker sends the time every period until stopped
+type Ticker interface {
+	C() <-chan time.Time
+	Stop()
+}
+
+// Real is the wall clock
+var Real Clock = realClock{}
+
+// Or returns c, or Real when c is nil
+func Or(c Clock) Clock {
+	if c == nil {
+		return Real
+	}
+	return c
+}
+
+type realClock struct{}
+
+func (realClock) Now() time.Time                         { return time.Now() }
+func (realClock) After(d time.Duration) <-chan time.Time { return time.After(d) }
+func (realClock) NewTicker(d time.Duration) Ticker       { return realTicker{time.NewTicker(d)} }
+
+type realTicker struct {
+	t *time.Ticker
+}
+
+func (t realTicker) C() <-chan time.Time { return t.t.C }
+func (t realTicker) Stop()               { t.t.Stop() }
+
+// Fake is a clock that only moves when Advance is called, for tests
+type Fake struct {
+	mu      sync.Mutex
+	now     time.Time
+	waiters []*waiter
+}
+
+// waiter is a pending After, or a Ticker, of a Fake
+type waiter struct {
+	at     time.Time
+	period time.Duration // 0 for an After
+	c      chan time.Time
+}
+
+// NewFake creates a Fake reading now
+func NewFake(now time.Time) *Fake {
+	return &Fake{now: now}
+}
+
+// Now returns the fake time
+func (f *Fake) Now() time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.now
+}
+
+// After sends the fake time once Advance moved it d on
+func (f *Fake) After(d time.Duration) <-chan time.Time {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	c := make(chan time.Time, 1)
+	if d <= 0 {
+		c <- f.now
+		return c
+	}
+	f.waiters = append(f.waiters, &waiter{at: f.now.Add(d), c: c})
+	return c
+}
+
+// NewTicker ticks every d of fake time
+func (f *Fake) NewTicker(d time.Duration) Ticker {
+	if d <= 0 {
+		panic("clock: non-positive interval for NewTicker")
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	w := &waiter{at: f.now.Add(d), period: d, c: make(chan time.Time, 1)}
+	f.waiters = append(f.waiters, w)
+	return fakeTicker{f: f, w: w}
+}
+
+// Advance moves the fake time d on, sending on the channels of the waits
+// that are over; a ticker late by several periods ticks once, as time's do
+func (f *Fake) Advance(d time.Duration) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.now = f.now.Add(d)
+	kept := f.waiters[:0]
+	for _, w := range f.waiters {
+		if w.at.After(f.now) {
+			kept = append(kept, w)
+			continue
+		}
+		select {
+		case w.c <- f.now:
+		default:
+		}
+		if w.period > 0 {
+			for !w.at.After(f.now) {
+				w.at = w.at.Add(w.period)
+			}
+			kept = append(kept, w)
+		}
+	}
+	f.waiters = kept
+}
+
+// Waiters returns how many Afters and Tickers are pending, for a test to
+// tell a loop started waiting before it advances the time
+func (f *Fake) Waiters() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return len(f.waiters)
+}
+
+type fakeTicker struct {
+	f *Fake
+	w *waiter
+}
+
+func (t fakeTicker) C() <-chan time.Time { return t.w.c }
+
+func (t fakeTicker) Stop() {
+	t.f.mu.Lock()
+	defer t.f.mu.Unlock()
+	for i, w := range t.f.waiters {
+		if w == t.w {
+			t.f.waiters = append(t.f.waiters[:i], t.f.waiters[i+1:]...)
+			return
+		}
+	}
+}
+
+// Interval is a period the loops waiting on it pick up the changes of while they run
+type Interval struct {
+	name string
+	min  time.Duration
+
+	mu      sync.Mutex
+	d       time.Duration
+	changed chan struct{} // closed by the next Set
+}
+
+var (
+	intervalsMu sync.Mutex
+	intervals   = make(map[string]*Interval)
+)
+
+// NewInterval creates an interval of d registered as name, replacing the one
+// registered before; it can't be Set under min, other than to 0
+func NewInterval(name string, d, min time.Duration) *Interval {
+	i := &Interval{name: name, min: min, d: d, changed: make(chan struct{})}
+	intervalsMu.Lock()
+	intervals[name] = i
+	intervalsMu.Unlock()
+	return i
+}
+
+// Get returns the period
+func (i *Interval) Get() time.Duration {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.d
+}
+
+// Changed returns a channel closed the next time the period is Set
+func (i *Interval) Changed() <-chan struct{} {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	return i.changed
+}
+
+// Set changes the period, 0 turns the loops waiting on it off until it is Set again
+func (i *Interval) Set(d time.Duration) error {
+	if d < 0 || d > 0 && d < i.min {
+		return fmt.Errorf("clock: %s can't be %s, it takes 0 or at least %s", i.name, d, i.min)
+	}
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	i.d = d
+	close(i.changed)
+	i.changed = make(chan struct{})
+	return nil
+}
+
+// Every calls fn every period on c until stop is closed, starting the period
+// over whenever it is Set
+func (i *Interval) Every(c Clock, stop <-chan struct{}, fn func()) {
+	c = Or(c)
+	for {
+		changed := i.Changed()
+		var ticks <-chan time.Time
+		var t Ticker
+		if d := i.Get(); d > 0 {
+			t = c.NewTicker(d)
+			ticks = t.C()
+		}
+		for again := true; again; {
+			select {
+			case <-ticks:
+				fn()
+			case <-changed:
+				again = false
+			case <-stop:
+				if t != nil {
+					t.Stop()
+				}
+				return
+			}
+		}
+		if t != nil {
+			t.Stop()
+		}
+	}
+}
+
+// IntervalStatus is the period of a registered Interval
+type IntervalStatus struct {
+	Name   string `json:"name"`
+	Period string `json:"period"`
+	Min    string `json:"min"`
+}
+
+// Intervals returns the registered intervals, by name
+func Intervals() []IntervalStatus {
+	intervalsMu.Lock()
+	defer intervalsMu.Unlock()
+	all := make([]IntervalStatus, 0, len(intervals))
+	for name, i := range intervals {
+		all = append(all, IntervalStatus{Name: name, Period: i.Get().String(), Min: i.min.String()})
+	}
+	sort.Slice(all, func(a, b int) bool { return all[a].Name < all[b].Name })
+	return all
+}
+
+// ErrUnknownInterval is returned when setting an interval that isn't registered
+var ErrUnknownInterval = errors.New("clock: no such interval")
+
+// Set changes the period of the interval registered as name
+func Set(name string, d time.Duration) error {
+	intervalsMu.Lock()
+	i, ok := intervals[name]
+	intervalsMu.Unlock()
+	if !ok {
+		return ErrUnknownInterval
+	}
+	return i.Set(d)
+}
@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// runBackfill catches up on votes missed while the stream was down.
+// It searches for recent tweets mentioning the poll options and publishes
+// the votes exactly like the stream would.
+func runBackfill(args []string) error {
+	fs := newFlagSet("backfill")
+	var (
+		sinceID = fs.String("since-id", "", "only fetch tweets newer than this tweet ID")
+		topic   = fs.String("topic", "votes", "NSQ topic to publish votes to")
+	)
+	fs.Parse(args)
+
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	private, _, err := newPrivacy()
+	if err != nil {
+		return err
+	}
+	filters := expr.NewFilters()
+	options, err := filters.Options(db, private.Options(db, db.LoadOptions))()
+	if err != nil {
+		return fmt.Errorf("failed to load options: %v", err)
+	}
+	matcher, err := newMatcher()
+	if err != nil {
+		return err
+	}
+	matcher.Update(options)
+	c, err := voteCodec(*topic)
+	if err != nil {
+		return err
+	}
+
+	pub, err := newPublisher(*topic)
+	if err != nil {
+		return fmt.Errorf("failed to create publisher: %v", err)
+	}
+	defer pub.Stop()
+	if ops, err := newPublisher(events.OpsTopic); err != nil {
+		log.Println("failed to create the ops publisher, the backfill isn't reported on it:", err)
+	} else {
+		defer ops.Stop()
+		defer events.PublishOps(envString("INSTANCE_ID", "backfill"), ops.Publish)()
+	}
+
+	twitter, err := newTwitter("", nil, nil, nil, nil, nil, nil)
+	if err != nil {
+		return err
+	}
+	events.Record(events.BackfillStarted, fmt.Sprintf("%d options since tweet %q, publishing to %s", len(options), *sinceID, *topic))
+	tweets, err := twitter.Search(options, *sinceID)
+	if err != nil && len(tweets) == 0 {
+		return err
+	}
+	if err != nil {
+		log.Println("search stopped early:", err)
+	}
+	var published int
+	lastID := *sinceID
+	for _, t := range tweets {
+		for _, v := range matcher.Match(t) {
+			v.Source = stream.SourceTwitter
+			if !filters.Filter(&v) {
+				continue
+			}
+			private.Apply(&v)
+			b, err := codec.Encode(c, &v)
+			if err != nil {
+				log.Println("Marshall error: ", err)
+				continue
+			}
+			if err := pub.Publish(b); err != nil {
+				return fmt.Errorf("failed to publish, resume with -since-id %s: %v", lastID, err)
+			}
+			published++
+		}
+		lastID = t.ID
+	}
+	log.Printf("backfilled %d votes from %d tweets, resume with -since-id %s", published, len(tweets), lastID)
+	return nil
+}
@@ -0,0 +1,335 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"runtime/pprof"
+	"sort"
+	"strconv"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// benchQueue is the buffer between two pipeline stages, like the publisher's in local
+const benchQueue = 1024
+
+// benchStages are the timed stages, in pipeline order
+var benchStages = []string{"match", "encode", "count", "flush"}
+
+// runBench drives the whole pipeline in one process with generated tweets,
+// raising the rate step by step up to -rate. It reports the highest rate the
+// pipeline kept up with, the p99 of every stage and which one fell behind first.
+func runBench(args []string) error {
+	fs := newFlagSet("bench")
+	var (
+		rate     = fs.Float64("rate", 50000, "target tweets per second, reached in -steps equal steps")
+		steps    = fs.Int("steps", 10, "number of rate steps")
+		stepTime = fs.Duration("step-duration", 5*time.Second, "how long each step runs")
+		nOptions = fs.Int("options", 10, "number of options in the benchmark poll")
+		interval = fs.Duration("interval", 1*time.Second, "how often tallies are flushed to the store")
+		verbose  = fs.Bool("v", false, "show the pipeline's logs")
+		cpuProf  = fs.String("cpuprofile", "", "write a CPU profile of the whole run to this file")
+		memProf  = fs.String("memprofile", "", "write a heap profile to this file once the run is done")
+	)
+	fs.Parse(args)
+	if *rate <= 0 || *steps <= 0 || *nOptions <= 0 {
+		fs.Usage()
+		return fmt.Errorf("-rate, -steps and -options must be positive")
+	}
+
+	matcher, err := newMatcher()
+	if err != nil {
+		return err
+	}
+	c, err := voteCodec("votes")
+	if err != nil {
+		return err
+	}
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+		defer log.SetOutput(os.Stderr)
+	}
+	if *cpuProf != "" {
+		f, err := os.Create(*cpuProf)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		if err := pprof.StartCPUProfile(f); err != nil {
+			return err
+		}
+		defer pprof.StopCPUProfile()
+	}
+	options := benchOptions(*nOptions)
+	matcher.Update(options)
+	db := store.NewMemory(store.Poll{ID: "bench", Title: "Bench", Options: options, Status: "active"})
+
+	// generate -> tweets -> match -> votes -> encode -> pub -> count
+	times := &stageTimes{}
+	tweets := make(chan stream.Tweet, benchQueue)
+	votes := make(chan match.Vote, benchQueue)
+	pub := publish.NewMemory(benchQueue)
+	go func() {
+		defer close(votes)
+		for t := range tweets {
+			start := time.Now()
+			matched := matcher.Match(t)
+			times.record("match", time.Since(start))
+			for _, v := range matched {
+				votes <- v
+			}
+		}
+	}()
+	go func() {
+		defer pub.Stop()
+		for v := range votes {
+			start := time.Now()
+			b, err := codec.Encode(c, &v)
+			times.record("encode", time.Since(start))
+			if err != nil {
+				log.Println("Marshall error: ", err)
+				continue
+			}
+			pub.Publish(b)
+		}
+	}()
+	counted := make(chan error, 1)
+	go func() {
+		counted <- count.RunLocal(count.Config{
+			MetricsAddr:      "127.0.0.1:0",
+			MetricsMaxSeries: 1000,
+			UpdateInterval:   *interval,
+			PollCacheTTL:     5 * time.Minute,
+			DedupWindow:      time.Minute,
+			SnapshotInterval: time.Hour,
+			TimeSeries:       timeseries.Config{Backend: "none"},
+			Timing:           times.record,
+		}, db, pub.Messages())
+	}()
+
+	b := &bench{
+		options: options,
+		tweets:  tweets,
+		queues: []benchQueueLen{
+			{stage: "match", len: func() int { return len(tweets) }},
+			{stage: "encode", len: func() int { return len(votes) }},
+			{stage: "count", len: func() int { return len(pub.Messages()) }},
+		},
+		times:  times,
+		lastID: 1290000000000000000,
+	}
+	var results []benchStep
+	for i := 1; i <= *steps; i++ {
+		target := *rate * float64(i) / float64(*steps)
+		res := b.step(target, *stepTime)
+		results = append(results, res)
+		fmt.Fprintf(os.Stderr, "bench: %.0f tweets/s: %.0f votes/s counted\n", target, res.counted)
+		if !res.sustained() {
+			break
+		}
+	}
+	close(tweets)
+	if err := <-counted; err != nil {
+		return err
+	}
+	printBench(results, *rate)
+	if *memProf != "" {
+		f, err := os.Create(*memProf)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		runtime.GC() // up to date statistics
+		return pprof.WriteHeapProfile(f)
+	}
+	return nil
+}
+
+// benchOptions returns n options of the same length, so none contains another
+func benchOptions(n int) []string {
+	options := make([]string, n)
+	for i := range options {
+		options[i] = fmt.Sprintf("bench%c%c%c", 'a'+i/676%26, 'a'+i/26%26, 'a'+i%26)
+	}
+	return options
+}
+
+// bench generates the tweets and measures the pipeline
+type bench struct {
+	options []string
+	tweets  chan<- stream.Tweet
+	queues  []benchQueueLen
+	times   *stageTimes
+	lastID  int64
+}
+
+// benchQueueLen reports how full the input queue of a stage is
+type benchQueueLen struct {
+	stage string
+	len   func() int
+}
+
+// benchStep is what one rate step measured
+type benchStep struct {
+	target  float64 // tweets per second asked for
+	sent    float64 // tweets per second generated
+	counted float64 // votes per second counted
+	encoded float64 // votes per second handed to the counter
+	p99     map[string]time.Duration
+	fill    map[string]float64 // average fill of each stage's input queue, 0 to 1
+}
+
+// sustained reports whether the pipeline kept up with the step's rate
+func (s benchStep) sustained() bool {
+	return s.sent >= 0.95*s.target && s.counted >= 0.95*s.encoded
+}
+
+// bottleneck names the stage that fell behind: the one with the fullest input queue,
+// or the generator itself when every queue had room
+func (s benchStep) bottleneck() (string, float64) {
+	stage, fill := "generator", 0.0
+	for _, name := range benchStages {
+		if f, ok := s.fill[name]; ok && f > fill && f > 0.5 {
+			stage, fill = name, f
+		}
+	}
+	return stage, fill
+}
+
+// step generates tweets at rate for d, sampling the queues as it goes
+func (b *bench) step(rate float64, d time.Duration) benchStep {
+	b.times.reset()
+	fill := make(map[string]float64)
+	var samples int
+	var sent int
+	start := time.Now()
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for elapsed := time.Duration(0); elapsed < d; elapsed = time.Since(start) {
+		// catch up on what is due, a blocked send means the matcher can't keep up
+		for due := int(rate * elapsed.Seconds()); sent < due; sent++ {
+			b.tweets <- b.tweet()
+		}
+		for _, q := range b.queues {
+			fill[q.stage] += float64(q.len()) / benchQueue
+		}
+		samples++
+		<-tick.C
+	}
+	took := time.Since(start).Seconds()
+	for stage := range fill {
+		fill[stage] /= float64(samples)
+	}
+	durations := b.times.reset()
+	p99 := make(map[string]time.Duration)
+	for stage, ds := range durations {
+		p99[stage] = quantile(ds, 0.99)
+	}
+	return benchStep{
+		target:  rate,
+		sent:    float64(sent) / took,
+		encoded: float64(len(durations["encode"])) / took,
+		counted: float64(len(durations["count"])) / took,
+		p99:     p99,
+		fill:    fill,
+	}
+}
+
+// tweet names one or two options, the way a real vote sometimes does
+func (b *bench) tweet() stream.Tweet {
+	b.lastID++
+	text := "voting for " + b.options[rand.Intn(len(b.options))]
+	if rand.Intn(10) == 0 {
+		text += " and " + b.options[rand.Intn(len(b.options))]
+	}
+	t := stream.Tweet{ID: strconv.FormatInt(b.lastID, 10), CreatedAt: time.Now().Format(time.RubyDate), Text: text}
+	t.User.ScreenName = "bench" + strconv.Itoa(rand.Intn(10000))
+	return t
+}
+
+// printBench writes one line per step and the conclusion
+func printBench(results []benchStep, rate float64) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprint(w, "target tweets/s\ttweets/s\tvotes/s\t")
+	for _, stage := range benchStages {
+		fmt.Fprintf(w, "%s p99\t", stage)
+	}
+	fmt.Fprintln(w)
+	for _, s := range results {
+		fmt.Fprintf(w, "%.0f\t%.0f\t%.0f\t", s.target, s.sent, s.counted)
+		for _, stage := range benchStages {
+			fmt.Fprintf(w, "%s\t", s.p99[stage])
+		}
+		fmt.Fprintln(w)
+	}
+	w.Flush()
+	fmt.Println()
+
+	var best *benchStep
+	for i := range results {
+		if results[i].sustained() {
+			best = &results[i]
+		}
+	}
+	if best == nil {
+		fmt.Println("max sustainable: none, lower -rate")
+	} else {
+		fmt.Printf("max sustainable: %.0f votes/s (%.0f tweets/s)\n", best.counted, best.sent)
+	}
+	last := results[len(results)-1]
+	if last.sustained() {
+		fmt.Printf("bottleneck: none up to %.0f tweets/s, raise -rate\n", rate)
+		return
+	}
+	if stage, fill := last.bottleneck(); stage != "generator" {
+		fmt.Printf("bottleneck: %s, its input queue was %.0f%% full at %.0f tweets/s\n", stage, fill*100, last.target)
+	} else {
+		fmt.Printf("bottleneck: the generator couldn't produce %.0f tweets/s, the pipeline had room\n", last.target)
+	}
+}
+
+// stageTimes collects how long each item took in each stage
+type stageTimes struct {
+	mu        sync.Mutex
+	durations map[string][]time.Duration
+}
+
+func (t *stageTimes) record(stage string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.durations == nil {
+		t.durations = make(map[string][]time.Duration)
+	}
+	t.durations[stage] = append(t.durations[stage], d)
+}
+
+// reset starts collecting again, returning what was collected so far
+func (t *stageTimes) reset() map[string][]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	durations := t.durations
+	t.durations = nil
+	return durations
+}
+
+// quantile returns the q quantile of ds, sorting them
+func quantile(ds []time.Duration, q float64) time.Duration {
+	if len(ds) == 0 {
+		return 0
+	}
+	sort.Slice(ds, func(i, j int) bool { return ds[i] < ds[j] })
+	return ds[int(q*float64(len(ds)-1))]
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/olawolu/twitter-polls/tweetreader/certify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// runCertificate writes the signed certificate of a closed poll's results, or
+// with -verify checks one, as anyone the results are published to can
+func runCertificate(args []string) error {
+	fs := newFlagSet("certificate")
+	var (
+		poll   = fs.String("poll", "", "ID of the poll whose certificate to write")
+		out    = fs.String("out", "-", "file to write the certificate to, - for stdout")
+		verify = fs.String("verify", "", "check the certificate in this file, - for stdin, instead")
+		pubKey = fs.String("key", "", "Ed25519 public key, PKIX PEM, the certificate must be signed with for -verify; the one it carries when empty")
+	)
+	fs.Parse(args)
+	if *verify != "" {
+		return verifyCertificate(*verify, *pubKey)
+	}
+	if *poll == "" {
+		fs.Usage()
+		return fmt.Errorf("certificate needs -poll or -verify")
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	certs, ok := db.(store.CertificateStore)
+	if !ok {
+		return fmt.Errorf("this store doesn't keep certificates")
+	}
+	cert, err := certs.Certificate(*poll)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *poll, err)
+	}
+	if *out == "-" {
+		_, err = os.Stdout.Write(append(cert, '\n'))
+		return err
+	}
+	return ioutil.WriteFile(*out, append(cert, '\n'), 0644)
+}
+
+// verifyCertificate checks the certificate in the file named and prints its results
+func verifyCertificate(name, keyFile string) error {
+	var data []byte
+	var err error
+	if name == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(name)
+	}
+	if err != nil {
+		return err
+	}
+	var pub ed25519.PublicKey
+	if keyFile != "" {
+		b, err := ioutil.ReadFile(keyFile)
+		if err != nil {
+			return err
+		}
+		if pub, err = certify.ParsePublicKey(b); err != nil {
+			return fmt.Errorf("invalid -key: %v", err)
+		}
+	}
+	d, err := certify.Verify(data, pub)
+	if err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	if pub == nil {
+		fmt.Println("warning: checked against the key the certificate carries, pass -key to check who signed it")
+	}
+	fmt.Printf("poll %s %q certified at %s, %d votes\n", d.Poll, d.Title, d.CertifiedAt.Format("2006-01-02 15:04:05 MST"), d.TotalVotes)
+	options := append([]string(nil), d.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return d.Results[options[i]] > d.Results[options[j]] })
+	for _, o := range options {
+		fmt.Printf("  %-20s %d\n", o, d.Results[o])
+	}
+	if d.HistoryHead != "" {
+		fmt.Printf("history of %d entries, head %s\n", len(d.History), d.HistoryHead)
+	}
+	return nil
+}
+
+// certifyKey reads the key the counter signs certificates with from the file
+// named, or the PEM in CERTIFY_KEY; nil when neither is set
+func certifyKey(file string) (ed25519.PrivateKey, error) {
+	data := []byte(secret("CERTIFY_KEY"))
+	if file != "" {
+		var err error
+		if data, err = ioutil.ReadFile(file); err != nil {
+			return nil, fmt.Errorf("invalid -certify-key: %v", err)
+		}
+	}
+	if len(data) == 0 {
+		return nil, nil
+	}
+	key, err := certify.ParsePrivateKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid certificate key: %v", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,227 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// checks are the groups of checks runCheck runs, in order
+var checks = []string{"config", "twitter", "store", "nsq"}
+
+// durationSuffixes end the names of variables that are durations
+var durationSuffixes = []string{"_TIMEOUT", "_INTERVAL", "_EVERY", "_DELAY", "_BACKOFF", "_COOLDOWN", "_GRACE"}
+
+// runCheck verifies what the daemons need before they start: that the
+// configuration is valid, the Twitter credentials are accepted and the store
+// and NSQ are reachable. It reports every check and fails when any did, to
+// gate a deploy on.
+func runCheck(args []string) error {
+	fs := newFlagSet("check")
+	var (
+		lookupd = fs.String("lookupd", envString("NSQLOOKUPD_ADDR", "localhost:4161"), "nsqlookupd address")
+		skip    = fs.String("skip", "", "comma separated checks not to run: "+strings.Join(checks, ", ")+"; twitter is skipped unless -source is twitter or twitter-search")
+		source  = fs.String("source", envString("SOURCE", "twitter"), "where the streamer's tweets come from, as for stream")
+		timeout = fs.Duration("timeout", 10*time.Second, "how long every check may take")
+	)
+	fs.Parse(args)
+	known := make(map[string]bool)
+	for _, name := range checks {
+		known[name] = true
+	}
+	skipped := make(map[string]bool)
+	for _, name := range splitList(*skip) {
+		if !known[name] {
+			return fmt.Errorf("invalid -skip %q, want %s", name, strings.Join(checks, ", "))
+		}
+		skipped[name] = true
+	}
+	if *source != "twitter" && *source != "twitter-search" {
+		skipped["twitter"] = true
+	}
+
+	var ran, failed int
+	check := func(group, name string, f func() (string, error)) {
+		if skipped[group] {
+			return
+		}
+		ran++
+		detail, err := within(*timeout, f)
+		if err != nil {
+			failed++
+			fmt.Printf("FAIL  %-22s %v\n", name, err)
+			return
+		}
+		fmt.Printf("ok    %-22s %s\n", name, detail)
+	}
+
+	check("config", "config file", func() (string, error) {
+		path := os.Getenv("CONFIG_FILE")
+		if path == "" {
+			return "no CONFIG_FILE, the environment only", nil
+		}
+		values, err := parseConfig(path)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d variables from %s", len(values), path), nil
+	})
+	if backend := os.Getenv("SECRETS_BACKEND"); backend != "" {
+		// loaded before any command runs, failing to load them stops it
+		check("config", "secrets", func() (string, error) {
+			fetched.Lock()
+			defer fetched.Unlock()
+			return fmt.Sprintf("%d values from %s", len(fetched.values), backend), nil
+		})
+	}
+	accounts, err := twitterAccounts()
+	check("config", "twitter accounts", func() (string, error) {
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%d to stream for", len(accounts)), nil
+	})
+	check("config", "stream settings", func() (string, error) {
+		if err := envStreamSettings(*source).validate(); err != nil {
+			return "", err
+		}
+		return "valid", nil
+	})
+	check("config", "nsq config", func() (string, error) {
+		if _, err := nsqConfig(); err != nil {
+			return "", err
+		}
+		return "valid", nil
+	})
+	check("config", "runbook", func() (string, error) {
+		if _, err := openRunbook(); err != nil {
+			return "", err
+		}
+		return envString("RUNBOOK_FILE", "none"), nil
+	})
+
+	for _, account := range accounts {
+		account := account
+		name := "twitter"
+		if account != "" {
+			name += " " + account
+		}
+		check("twitter", name, func() (string, error) {
+			if accountCredentials(account) == (stream.Credentials{}) {
+				return "", errors.New("no credentials set")
+			}
+			t, err := newTwitter(account, nil, nil, nil, nil, nil, nil)
+			if err != nil {
+				return "", err
+			}
+			handle, err := t.VerifyCredentials()
+			if err != nil {
+				return "", err
+			}
+			return "@" + handle, nil
+		})
+	}
+
+	check("store", "store", func() (string, error) {
+		kind, addr, opts, err := storeConfig()
+		if err != nil {
+			return "", err
+		}
+		// a single attempt
+		opts.Timeout = 0
+		db, err := store.Open(kind, addr, opts)
+		if err != nil {
+			return "", err
+		}
+		defer db.Close()
+		polls, err := db.Polls()
+		if err != nil {
+			return "", fmt.Errorf("failed to read the polls: %v", err)
+		}
+		return fmt.Sprintf("%s, %d polls", kind, len(polls)), nil
+	})
+
+	check("nsq", "nsqd", func() (string, error) {
+		cfg, err := nsqConfig()
+		if err != nil {
+			return "", err
+		}
+		cfg.DialTimeout = *timeout
+		p, err := nsq.NewProducer(nsqdAddr, cfg)
+		if err != nil {
+			return "", err
+		}
+		defer p.Stop()
+		p.SetLogger(log.New(ioutil.Discard, "", 0), nsq.LogLevelError)
+		if err := p.Ping(); err != nil {
+			return "", err
+		}
+		return nsqdAddr, nil
+	})
+	check("nsq", "nsqlookupd", func() (string, error) {
+		url := *lookupd
+		if !strings.Contains(url, "://") {
+			url = "http://" + url
+		}
+		resp, err := (&http.Client{Timeout: *timeout}).Get(strings.TrimSuffix(url, "/") + "/ping")
+		if err != nil {
+			return "", err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return "", fmt.Errorf("ping answered %s", resp.Status)
+		}
+		return *lookupd, nil
+	})
+
+	// last, once the checks read what they need; the durations the commands
+	// read later are known by their names
+	check("config", "variables", func() (string, error) {
+		for _, kv := range os.Environ() {
+			key := kv[:strings.IndexByte(kv, '=')]
+			for _, suffix := range durationSuffixes {
+				if strings.HasSuffix(key, suffix) {
+					envDuration(key, 0)
+				}
+			}
+		}
+		if bad := invalidVariables(); len(bad) > 0 {
+			return "", fmt.Errorf("invalid, the defaults are used instead: %s", strings.Join(bad, ", "))
+		}
+		return "valid", nil
+	})
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, ran)
+	}
+	return nil
+}
+
+// within returns what f returns, or fails once it takes longer than d
+func within(d time.Duration, f func() (string, error)) (string, error) {
+	type result struct {
+		detail string
+		err    error
+	}
+	done := make(chan result, 1)
+	go func() {
+		detail, err := f()
+		done <- result{detail, err}
+	}()
+	select {
+	case r := <-done:
+		return r.detail, r.err
+	case <-time.After(d):
+		return "", fmt.Errorf("timed out after %s", d)
+	}
+}
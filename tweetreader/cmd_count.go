@@ -0,0 +1,226 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/control"
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/dedup"
+	"github.com/olawolu/twitter-polls/tweetreader/leaderboard"
+	"github.com/olawolu/twitter-polls/tweetreader/partition"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// runCount consumes votes from NSQ and tallies them into the polls, replacing the old tweetcounter
+func runCount(args []string) error {
+	fs := newFlagSet("count")
+	var (
+		lookupd  = fs.String("lookupd", envString("NSQLOOKUPD_ADDR", "localhost:4161"), "nsqlookupd address")
+		topic    = fs.String("topic", envString("VOTES_TOPIC", "votes"), "NSQ topic to count the votes of, e.g. votes_replay for replayed votes")
+		metrics  = fs.String("metrics", envString("METRICS_ADDR", ":9102"), "address to serve /metrics on")
+		series   = fs.Int("metrics-max-series", int(envInt64("METRICS_MAX_SERIES", 1000)), "maximum number of per-option metric series")
+		interval = fs.Duration("interval", envDuration("COUNT_FLUSH_INTERVAL", 1*time.Second), "how often tallies are written to the database")
+		batch    = fs.Int("batch-size", int(envInt64("COUNT_BATCH_SIZE", 0)), "polls whose results one bulk write adds, on MongoDB (0 to write every poll's on its own)")
+		retries  = fs.Int("write-retries", int(envInt64("COUNT_WRITE_RETRIES", 3)), "times a bulk write failing to reach the database is tried again within a flush")
+		backoff  = fs.Duration("write-retry-wait", envDuration("COUNT_WRITE_RETRY_WAIT", 100*time.Millisecond), "wait before retrying a bulk write, doubling with every retry")
+		backend  = fs.String("timeseries", envString("TIMESERIES_BACKEND", "mongo"), "results time series backend: mongo, influx or timescale")
+		tsURL    = fs.String("timeseries-url", os.Getenv("TIMESERIES_URL"), "InfluxDB write URL or TimescaleDB connection string")
+		bucket   = fs.Duration("timeseries-bucket", time.Minute, "bucket width for the mongo time series")
+		rates    = fs.String("rates", os.Getenv("RATES_BACKEND"), "also push per-option vote rates to influx or remote-write (Prometheus remote write)")
+		ratesURL = fs.String("rates-url", os.Getenv("RATES_URL"), "InfluxDB write URL or remote-write endpoint for -rates")
+		ratesInt = fs.Duration("rates-interval", envDuration("RATES_INTERVAL", 15*time.Second), "how often vote rates are pushed")
+		dedupFor = fs.Duration("dedup-window", envDuration("DEDUP_WINDOW", 10*time.Minute), "how long counted tweets are remembered to skip redeliveries")
+		authors  = fs.Duration("unique-authors-window", envDuration("UNIQUE_AUTHORS_WINDOW", 7*24*time.Hour), "how long an author counted by a unique_authors poll isn't counted again")
+		maxSeen  = fs.Int("ledger-limit", int(envInt64("LEDGER_LIMIT", 0)), "votes and unique_authors authors each remembered in memory at most within their windows, evicting the least recently seen (0 for no limit)")
+		spillTo  = fs.String("ledger-spill-dir", envString("LEDGER_SPILL_DIR", ""), "directory the votes and authors evicted over -ledger-limit are kept in until their window is over, instead of being forgotten")
+		retracts = fs.Duration("retract-window", envDuration("RETRACT_WINDOW", 24*time.Hour), "how long what each vote added to the results is remembered, to take it back when the streamers retract its tweet (0 to ignore retractions)")
+		overload = fs.Float64("overload-rate", envFloat("OVERLOAD_RATE", 0), "votes per second for one poll that make the counter ask streamers to back off (0 to disable)")
+		action   = fs.String("overload-action", envString("OVERLOAD_ACTION", "sample"), "what streamers are asked to do when a poll is overloaded: sample or slow")
+		hold     = fs.Duration("overload-hold", envDuration("OVERLOAD_HOLD", time.Minute), "how long a back-off request lasts")
+		snapshot = fs.Duration("snapshot-interval", envDuration("SNAPSHOT_INTERVAL", 30*time.Second), "how often the counter state is saved for warm restarts")
+		history  = fs.Duration("history-interval", envDuration("HISTORY_INTERVAL", 0), "how often every poll's results are copied to results_history (0 to disable)")
+		keep     = fs.Duration("history-retention", envDuration("HISTORY_RETENTION", 0), "how long results_history entries are kept (0 for ever)")
+		compact  = fs.Duration("history-compact-after", envDuration("HISTORY_COMPACT_AFTER", 24*time.Hour), "age after which results_history entries are thinned out (0 to never thin)")
+		every    = fs.Duration("history-compact-every", envDuration("HISTORY_COMPACT_EVERY", time.Hour), "thinned results_history entries keep one per this much time")
+		certKey  = fs.String("certify-key", envString("CERTIFY_KEY_FILE", ""), "Ed25519 private key, PKCS #8 PEM, the closed polls' results are signed with (CERTIFY_KEY also takes the PEM itself)")
+		certInt  = fs.Duration("certify-interval", envDuration("CERTIFY_INTERVAL", time.Minute), "how often closed polls are looked for to certify")
+		grace    = fs.Duration("certify-grace", envDuration("CERTIFY_GRACE", time.Minute), "how long a poll stays closed before its results are certified")
+		correct  = fs.Duration("correct-interval", envDuration("CORRECT_INTERVAL", time.Minute), "how often the corrections of the votes disputed through the API are applied (0 to never)")
+		voteLog  = fs.Bool("vote-log", os.Getenv("VOTE_LOG") != "", "append every vote counted to the store's vote_log, so the results can be recounted")
+		handlers = fs.Int("handlers", int(envInt64("COUNT_HANDLERS", 4)), "vote messages decoded and matched to their polls at once")
+		inFlight = fs.Int("max-in-flight", int(envInt64("COUNT_MAX_IN_FLIGHT", 64)), "vote messages nsqd sends before they are answered, at least -handlers")
+		attempts = fs.Int("max-attempts", int(envInt64("COUNT_MAX_ATTEMPTS", 5)), "deliveries of a vote message before it is dead lettered")
+		requeue  = fs.Duration("requeue-delay", envDuration("COUNT_REQUEUE_DELAY", 5*time.Second), "delay before a requeued message is delivered again, times its attempts")
+		maxDelay = fs.Duration("max-requeue-delay", envDuration("COUNT_MAX_REQUEUE_DELAY", 2*time.Minute), "longest delay before a requeued message is delivered again")
+		timeout  = fs.Duration("handler-timeout", envDuration("COUNT_HANDLER_TIMEOUT", 10*time.Second), "how long counting a message may take before it is requeued (0 for no limit)")
+		maxDefer = fs.Duration("embargo-max-defer", envDuration("EMBARGO_MAX_DEFER", time.Hour), "longest nsqd defers a held vote, its -max-req-timeout")
+		owner    = fs.String("tenant", envString("COUNT_TENANT", ""), "only count the votes for this tenant's polls, for the topic the streamers route its votes to")
+		shared   = fs.Bool("untenanted", os.Getenv("COUNT_UNTENANTED") != "", "only count the votes for the polls without a tenant, when the streamers route the tenants' votes")
+		part     = fs.Int("partition", int(envInt64("COUNT_PARTITION", 0)), "only count the votes for the polls in this partition, out of -partitions")
+		parts    = fs.Int("partitions", int(envInt64("VOTE_PARTITIONS", 0)), "partitions the streamers publish the votes to, see stream -partitions (0 to count every poll)")
+		shareURL = fs.String("dedup-redis", envString("DEDUP_REDIS_URL", ""), "Redis the counters share the votes they counted in, as redis://host:6379/0, to count the votes of side by side streamers once (empty for each counter's own)")
+		boardURL = fs.String("leaderboard-redis", envString("LEADERBOARD_REDIS_URL", ""), "Redis the polls' leaderboards are kept in for the REST API, as redis://host:6379/0 (empty for none)")
+		boardFor = fs.Duration("leaderboard-interval", envDuration("LEADERBOARD_INTERVAL", 5*time.Minute), "how often the leaderboards are rebuilt from the stored results (0 to only rebuild on start)")
+		stopIn   = fs.Duration("shutdown-grace", envDuration("SHUTDOWN_GRACE", 0), "how long stopping may take in all, e.g. the pod's terminationGracePeriodSeconds (0 for the stages' own timeouts)")
+		stall    = fs.Duration("watchdog-stall", envDuration("WATCHDOG_STALL", 5*time.Minute), "how long the flushes may stop or nsqd be unreachable before systemd's watchdog isn't petted, with WatchdogSec=")
+		drain    = fs.Duration("drain-delay", envDuration("DRAIN_DELAY", 0), "how long /readyz reports not ready before stopping, for the load balancer to notice")
+		ttl      = fs.Duration("vote-ttl", envDuration("VOTE_TTL", 0), "dead letter the votes whose tweets are older than this when they could be counted (0 to count them however old)")
+	)
+	fs.Parse(args)
+	if *handlers < 1 {
+		return fmt.Errorf("invalid -handlers %d, want at least 1", *handlers)
+	}
+	if *attempts < 1 || *attempts > 65535 {
+		return fmt.Errorf("invalid -max-attempts %d, want 1 to 65535", *attempts)
+	}
+	if *owner != "" && *shared {
+		return fmt.Errorf("-tenant and -untenanted count different polls, pick one")
+	}
+	if *owner != "" && *topic == "votes" {
+		// the topic the streamers route the tenant's votes to
+		*topic = "votes." + *owner
+	}
+	if *parts < 0 || *parts > 0 && (*part < 0 || *part >= *parts) {
+		return fmt.Errorf("invalid -partition %d of -partitions %d, want 0 to %d", *part, *parts, *parts-1)
+	}
+	if *parts > 0 && (*owner != "" || *shared) {
+		return fmt.Errorf("-partitions and -tenant or -untenanted count votes from different topics, pick one")
+	}
+	if *parts > 0 && *topic == "votes" {
+		// the topic the streamers publish the partition's votes to
+		*topic = partition.Topic("votes", *part)
+	}
+	if *stopIn > 0 && *drain >= *stopIn {
+		return fmt.Errorf("-drain-delay %s leaves nothing of -shutdown-grace %s to stop in", *drain, *stopIn)
+	}
+	if *action != control.ActionSample && *action != control.ActionSlow {
+		return fmt.Errorf("invalid -overload-action %q, want sample or slow", *action)
+	}
+	key, err := certifyKey(*certKey)
+	if err != nil {
+		return err
+	}
+	db, err := dialStore()
+	if err != nil {
+		return err
+	}
+	defer db.Close()
+	bus, err := openRunbook()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+	nsqCfg, err := nsqConfig()
+	if err != nil {
+		return err
+	}
+	var ledger count.SharedLedger
+	if *shareURL != "" {
+		r, err := dedup.Open(dedup.Config{URL: *shareURL, Prefix: envString("DEDUP_REDIS_PREFIX", "")})
+		if err != nil {
+			return err
+		}
+		defer r.Close()
+		ledger = r
+	}
+	var board count.Leaderboard
+	if *boardURL != "" {
+		b, err := leaderboard.Open(leaderboard.Config{
+			URL:    *boardURL,
+			Prefix: envString("LEADERBOARD_REDIS_PREFIX", ""),
+			TTL:    envDuration("LEADERBOARD_TTL", 0),
+		})
+		if err != nil {
+			return err
+		}
+		defer b.Close()
+		board = b
+	}
+	dead, stopDead, err := newDeadLetters("count", *topic)
+	if err != nil {
+		return err
+	}
+	defer stopDead()
+	// held votes go back on the topic they came from
+	held, err := newPublisher(*topic)
+	if err != nil {
+		return fmt.Errorf("failed to create the publisher holding embargoed votes: %v", err)
+	}
+	defer held.Stop()
+	return count.Run(count.Config{
+		LookupdAddr:      *lookupd,
+		Topic:            *topic,
+		MetricsAddr:      *metrics,
+		MetricsMaxSeries: *series,
+		UpdateInterval:   *interval,
+		PollCacheTTL:     5 * time.Minute,
+		DedupWindow:      *dedupFor,
+		SharedDedup:      ledger,
+		AuthorsWindow:    *authors,
+		RetractWindow:    *retracts,
+		CorrectInterval:  *correct,
+		SnapshotInterval: *snapshot,
+		NsqdAddr:         nsqdAddr,
+		NSQ:              nsqCfg,
+		OverloadRate:     *overload,
+		ControlAction:    *action,
+		ControlHold:      *hold,
+		TimeSeries: timeseries.Config{
+			Backend: *backend,
+			URL:     *tsURL,
+			Token:   os.Getenv("TIMESERIES_TOKEN"),
+			Bucket:  *bucket,
+			Rates: timeseries.RatesConfig{
+				Backend:  *rates,
+				URL:      *ratesURL,
+				Token:    os.Getenv("RATES_TOKEN"),
+				Interval: *ratesInt,
+			},
+		},
+		History: count.HistoryConfig{
+			Interval:     *history,
+			Retention:    *keep,
+			CompactAfter: *compact,
+			CompactEvery: *every,
+		},
+		Certify: count.CertifyConfig{
+			Key:      key,
+			Interval: *certInt,
+			Grace:    *grace,
+		},
+		Batch: count.BatchConfig{
+			Size:      *batch,
+			Retries:   *retries,
+			RetryWait: *backoff,
+		},
+		VoteLog: *voteLog,
+		Consumer: count.ConsumerConfig{
+			Handlers:        *handlers,
+			MaxInFlight:     *inFlight,
+			MaxAttempts:     *attempts,
+			RequeueDelay:    *requeue,
+			MaxRequeueDelay: *maxDelay,
+			Timeout:         *timeout,
+		},
+		Tenant:        *owner,
+		Untenanted:    *shared,
+		Partition:     *part,
+		Partitions:    *parts,
+		ShutdownGrace: *stopIn,
+		DrainDelay:    *drain,
+		WatchdogStall: *stall,
+		Embargo: count.EmbargoConfig{
+			Hold:     held,
+			MaxDefer: *maxDefer,
+			TTL:      *ttl,
+		},
+		Events:              bus,
+		DeadLetters:         dead,
+		Leaderboard:         board,
+		LeaderboardInterval: *boardFor,
+		LedgerLimit:         *maxSeen,
+		LedgerSpillDir:      *spillTo,
+	}, db)
+}
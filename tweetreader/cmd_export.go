@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/export"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// runExport writes a poll's tallies, and with -votes the votes it got, for analysts
+func runExport(args []string) error {
+	fs := newFlagSet("export")
+	var (
+		poll       = fs.String("poll", "", "ID of the poll to export")
+		format     = fs.String("format", export.CSV, "csv, json (an object per line) or parquet")
+		out        = fs.String("out", "-", "file to write the tallies to, - for stdout")
+		from       = fs.String("from", "", "only export from this time on, as 2006-01-02, 2006-01-02-15 or RFC 3339")
+		to         = fs.String("to", "", "only export before this time")
+		votes      = fs.String("votes", "", "also write the votes for the poll's archived tweets to this file")
+		archiveURL = fs.String("archive", "", "where the tweets are archived, for -votes: s3://bucket/prefix, gs://bucket/prefix or a local directory")
+	)
+	fs.Parse(args)
+	if *poll == "" {
+		fs.Usage()
+		return fmt.Errorf("export needs -poll")
+	}
+	switch *format {
+	case export.CSV, export.JSON, export.Parquet:
+	default:
+		return fmt.Errorf("invalid -format %q, want csv, json or parquet", *format)
+	}
+	if *votes != "" && *archiveURL == "" {
+		return fmt.Errorf("-votes needs -archive")
+	}
+	var window hourRange
+	var err error
+	if window.from, err = parseExportTime(*from); err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	if window.to, err = parseExportTime(*to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	p, err := db.Poll(*poll)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *poll, err)
+	}
+
+	tallies, err := exportTallies(db, p, window)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(*out, *format, tallies); err != nil {
+		return err
+	}
+	log.Printf("exported %d tallies of %s", len(tallies.Rows), p.ID)
+	if *votes == "" {
+		return nil
+	}
+	cast, err := exportVotes(*archiveURL, p, window)
+	if err != nil {
+		return err
+	}
+	if err := writeExport(*votes, *format, cast); err != nil {
+		return err
+	}
+	log.Printf("exported %d votes of %s", len(cast.Rows), p.ID)
+	return nil
+}
+
+// parseExportTime parses a day, an hour of the archive or a time, "" is the zero time
+func parseExportTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	for _, layout := range []string{"2006-01-02", archive.HourFormat, time.RFC3339} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return t.UTC(), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("%q isn't a day like 2006-01-02, an hour like 2006-01-02-15 or a time like 2006-01-02T15:04:05Z", s)
+}
+
+// exportTallies returns the tallies of p in window: a row per option of every
+// entry of its history, then its totals now when window reaches that far
+func exportTallies(db store.Backend, p store.Poll, window hourRange) (*export.Table, error) {
+	t := &export.Table{Columns: []export.Column{
+		{Name: "poll", Kind: export.String},
+		{Name: "time", Kind: export.Time},
+		{Name: "kind", Kind: export.String}, // history, or total for the results at the time of the export
+		{Name: "option", Kind: export.String},
+		{Name: "votes", Kind: export.Int},
+		{Name: "weighted", Kind: export.Float},
+	}}
+	add := func(at time.Time, kind string, results map[string]int, weighted map[string]float64) {
+		options := make([]string, 0, len(results))
+		for o := range results {
+			options = append(options, o)
+		}
+		sort.Strings(options)
+		for _, o := range options {
+			t.Add(p.ID, at, kind, o, int64(results[o]), weighted[o])
+		}
+	}
+	if h, ok := db.(store.HistoryStore); ok {
+		entries, err := h.History(p.ID, window.from, window.to)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load the history: %v", err)
+		}
+		for _, e := range entries {
+			add(e.Time, "history", e.Results, e.WeightedResults)
+		}
+	} else {
+		log.Println("the store keeps no history, only exporting the totals")
+	}
+	if now := time.Now(); window.contains(now) {
+		add(now, "total", p.Results, p.WeightedResults)
+	}
+	return t, nil
+}
+
+// exportVotes matches the tweets archived for p in window again with its
+// options, as replay does. The poll's filters, such as its locations, aren't
+// applied: every vote a tweet cast for the options is exported.
+func exportVotes(url string, p store.Poll, window hourRange) (*export.Table, error) {
+	matcher, err := pollMatcher(p, p.Options)
+	if err != nil {
+		return nil, err
+	}
+	t := &export.Table{Columns: []export.Column{
+		{Name: "time", Kind: export.Time},
+		{Name: "tweet_id", Kind: export.String},
+		{Name: "option", Kind: export.String},
+		{Name: "author", Kind: export.String},
+		{Name: "text", Kind: export.String},
+		{Name: "weight", Kind: export.Float},
+		{Name: "hashtag", Kind: export.Bool},
+		{Name: "retweet", Kind: export.Bool},
+		{Name: "quote", Kind: export.Bool},
+		{Name: "reply", Kind: export.Bool},
+		{Name: "country", Kind: export.String},
+	}}
+	err = archivedTweets(url, p.ID, window, func(tweet stream.Tweet, at time.Time) error {
+		for _, v := range matcher.Match(tweet) {
+			var country string
+			if v.Geo != nil {
+				country = v.Geo.CountryCode
+			}
+			t.Add(at, v.ID, v.Option, v.User.ScreenName, v.Text, v.Weight, v.Hashtag, v.Retweet, v.Quote, v.Reply, country)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// pollMatcher returns a matcher for options, matched as p matches its options
+func pollMatcher(p store.Poll, options []string) (*match.Matcher, error) {
+	matcher, err := newMatcher()
+	if err != nil {
+		return nil, err
+	}
+	if p.Folding != "" {
+		folds := make(map[string]string, len(options))
+		for _, o := range options {
+			folds[o] = p.Folding
+		}
+		matcher.FoldFor(folds)
+	}
+	stems := make(map[string]match.Stemming)
+	for _, m := range p.Matching {
+		if m.Fuzziness != "" {
+			stems[m.Option] = match.Stemming{Level: m.Fuzziness, Language: m.Language}
+		}
+	}
+	matcher.StemFor(stems)
+	matcher.Update(options)
+	if p.EmbeddedText == store.EmbeddedScan {
+		matcher.ScanEmbeddedFor(options)
+	}
+	return matcher, nil
+}
+
+// archivedTweets calls fn with every tweet archived for poll in window, once,
+// with the time it was created
+func archivedTweets(url, poll string, window hourRange, fn func(tweet stream.Tweet, at time.Time) error) error {
+	// the archive is partitioned by hour, the tweets are then filtered to the second
+	hours := hourRange{from: window.from.Truncate(time.Hour), to: window.to}
+	if !window.to.IsZero() && !window.to.Equal(window.to.Truncate(time.Hour)) {
+		hours.to = window.to.Truncate(time.Hour).Add(time.Hour)
+	}
+	seen := make(map[string]bool)
+	return archivedBatches(url, poll, hours, func(key string, r io.Reader) error {
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for line := 1; scanner.Scan(); line++ {
+			if len(strings.TrimSpace(scanner.Text())) == 0 {
+				continue
+			}
+			var tweet stream.Tweet
+			if err := json.Unmarshal(scanner.Bytes(), &tweet); err != nil {
+				log.Printf("%s:%d: skipping: %v", key, line, err)
+				continue
+			}
+			if seen[tweet.ID] {
+				continue
+			}
+			seen[tweet.ID] = true
+			at, err := time.Parse(time.RubyDate, tweet.CreatedAt)
+			if err != nil {
+				log.Printf("%s:%d: skipping: %v", key, line, err)
+				continue
+			}
+			if !window.contains(at) {
+				continue
+			}
+			if err := fn(tweet, at); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	})
+}
+
+// writeExport writes t in format to the file named, - for stdout
+func writeExport(name, format string, t *export.Table) error {
+	if name == "-" {
+		return export.Write(os.Stdout, format, t)
+	}
+	f, err := os.Create(name)
+	if err != nil {
+		return err
+	}
+	if err := export.Write(f, format, t); err != nil {
+		f.Close()
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return f.Close()
+}
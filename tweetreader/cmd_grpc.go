@@ -0,0 +1,71 @@
+//go:build grpc
+// +build grpc
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"os"
+
+	"github.com/nsqio/go-nsq"
+	"google.golang.org/grpc"
+
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/votesapi"
+)
+
+func init() {
+	optionalCommands = append(optionalCommands, &command{
+		name: "grpc", usage: "grpc [-addr :8083]", summary: "serve the votes gRPC API to internal services", run: runGRPC,
+	})
+}
+
+// runGRPC streams the votes on NSQ to gRPC subscribers and serves the poll management RPCs
+func runGRPC(args []string) error {
+	fs := newFlagSet("grpc")
+	var (
+		addr    = fs.String("addr", envString("GRPC_ADDR", ":8083"), "address to serve gRPC on")
+		lookupd = fs.String("lookupd", envString("NSQLOOKUPD_ADDR", "localhost:4161"), "nsqlookupd address")
+		buffer  = fs.Int("buffer", 1024, "votes buffered per subscriber before it starts missing votes")
+	)
+	fs.Parse(args)
+
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	hub := votesapi.NewHub(*buffer)
+	cfg, err := nsqConfig()
+	if err != nil {
+		return err
+	}
+	q, err := nsq.NewConsumer("votes", "grpc", cfg)
+	if err != nil {
+		return err
+	}
+	q.AddHandler(hub)
+	if err := q.ConnectToNSQLookupd(*lookupd); err != nil {
+		return err
+	}
+	defer q.Stop()
+
+	lis, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	srv := grpc.NewServer()
+	votesapi.RegisterVotesServer(srv, votesapi.NewServer(hub, db))
+	go func() {
+		termChan := make(chan os.Signal, 1)
+		shutdown.Notify(termChan)
+		<-termChan
+		log.Println("Stopping gRPC server...")
+		srv.GracefulStop()
+	}()
+	log.Println("Serving gRPC on", *addr)
+	return srv.Serve(lis)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// runLocal runs replay and count in one process against a SQLite file,
+// so the pipeline can be tried out without MongoDB, NSQ or Twitter.
+func runLocal(args []string) error {
+	fs := newFlagSet("local")
+	var (
+		path     = fs.String("db", envString("SQLITE_PATH", "twitter-poll.db"), "SQLite database file")
+		rate     = fs.Float64("rate", 0, "maximum tweets per second to replay (0 for no limit)")
+		metrics  = fs.String("metrics", envString("METRICS_ADDR", ":9102"), "address to serve /metrics on")
+		interval = fs.Duration("interval", 1*time.Second, "how often tallies are written to the database")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 {
+		fs.Usage()
+		return fmt.Errorf("no files to replay")
+	}
+
+	db, err := store.OpenSQLite(*path)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %v (is the binary built with -tags sqlite?)", *path, err)
+	}
+	defer db.Close()
+	private, _, err := newPrivacy()
+	if err != nil {
+		return err
+	}
+	filters := expr.NewFilters()
+	options, err := filters.Options(db, private.Options(db, db.LoadOptions))()
+	if err != nil {
+		return fmt.Errorf("failed to load options: %v", err)
+	}
+	if len(options) == 0 {
+		return fmt.Errorf("no polls in %s, create one with: STORE=sqlite SQLITE_PATH=%s twitter-poll polls create", *path, *path)
+	}
+	matcher, err := newMatcher()
+	if err != nil {
+		return err
+	}
+	matcher.Update(options)
+	c, err := voteCodec("votes")
+	if err != nil {
+		return err
+	}
+
+	var delay time.Duration
+	if *rate > 0 {
+		delay = time.Duration(float64(time.Second) / *rate)
+	}
+	pub := publish.NewMemory(1024)
+	go func() {
+		defer pub.Stop()
+		for _, name := range fs.Args() {
+			n, err := replayFile(name, matcher, filters, private, pub, c, delay)
+			if err != nil {
+				log.Printf("%s: %v", name, err)
+				return
+			}
+			log.Printf("%s: replayed %d votes", name, n)
+		}
+	}()
+
+	return count.RunLocal(count.Config{
+		MetricsAddr:      *metrics,
+		MetricsMaxSeries: int(envInt64("METRICS_MAX_SERIES", 1000)),
+		UpdateInterval:   *interval,
+		PollCacheTTL:     5 * time.Minute,
+		DedupWindow:      envDuration("DEDUP_WINDOW", 10*time.Minute),
+		SnapshotInterval: envDuration("SNAPSHOT_INTERVAL", 30*time.Second),
+		TimeSeries:       timeseries.Config{Backend: "none"},
+	}, db, pub.Messages())
+}
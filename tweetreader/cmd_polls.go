@@ -0,0 +1,299 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/aggregate"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/stem"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// maxSampleEvery is the sparsest sampling a poll can ask for, the estimate gets too rough past it
+const maxSampleEvery = 1000
+
+// runPolls manages poll documents directly in the database
+func runPolls(args []string) error {
+	if len(args) == 0 {
+		args = []string{"list"}
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	switch sub, args := args[0], args[1:]; sub {
+	case "list":
+		return listPolls(db)
+	case "create":
+		fs := newFlagSet("polls")
+		var (
+			title     = fs.String("title", "", "poll title")
+			options   = fs.String("options", "", "comma separated poll options")
+			kind      = fs.String("type", "standard", "poll type: standard, weighted or ranked")
+			locations = fs.String("locations", "", "only count votes from these areas, west,south,east,north separated by ;")
+			geo       = fs.String("geo-aggregation", "", "also tally votes per country or region")
+			hashtags  = fs.Bool("hashtag-only", false, "only count tweets that have an option as a hashtag")
+			account   = fs.String("account", "", "stream the options with this account's Twitter credentials, see TWITTER_ACCOUNTS")
+			private   = fs.Bool("private", false, "hash the authors of the votes and drop their text before publishing them")
+			counting  = fs.String("counting", store.CountMentions, "what the results count: mentions, or unique_authors to count each author once per option")
+			suspect   = fs.Bool("exclude-suspect", false, "leave out the votes streamers tagged suspect, cast during a spike for their option")
+			sample    = fs.Int("sample-every", 0, "only publish 1 in this many votes for the options, counted as that many (0 for every vote)")
+			retweets  = fs.Bool("exclude-retweets", false, "leave out the votes cast by retweets")
+			quotes    = fs.Bool("exclude-quotes", false, "leave out the votes cast by quote tweets")
+			replies   = fs.Bool("exclude-replies", false, "leave out the votes cast by replies")
+			embedded  = fs.String("embedded-text", "", "scan, or ignore, the text of retweeted and quoted tweets for the options (as the streamers are set up when empty)")
+			sensitive = fs.String("case-sensitive", "", "comma separated options only counted when written in the same case")
+			exact     = fs.String("exact", "", "comma separated options only counted as whole words, not inside longer ones")
+			plural    = fs.String("plural", "", "comma separated options also counted in the singular or the plural, \"votes\" for \"vote\"")
+			stems     = fs.String("stem", "", "comma separated options also counted in the other forms of their words, \"voting\" for \"vote\"")
+			stemLang  = fs.String("stem-language", "", "language the -plural and -stem options are stemmed in: "+strings.Join(stem.Languages(), ", ")+" (en when empty)")
+			folding   = fs.String("folding", "", "also count the options written without their diacritics (diacritics), or in another alphabet too (transliterate)")
+			perMinute = fs.Int("max-per-minute", 0, "count at most this many votes a minute for each option, the rest are added up in the overflow metric (0 for no cap)")
+			timezone  = fs.String("timezone", "", "time zone the results are bucketed by hour and day in, as Europe/Berlin (UTC when empty)")
+			filter    = fs.String("filter", "", "only count the votes whose tweet passes this expression, as 'contains(\"go\") AND lang == \"en\"', see the README")
+			route     = fs.String("route", "", "only count the votes for the options other tracked polls also have whose tweet passes this expression, in -filter's language (all of them when empty)")
+			priority  = fs.String("priority", "", "low lets the poll's options leave the stream's filter while idle, see -hibernate-after of stream, and first when there are too many to track, high never (normal when empty)")
+			draft     = fs.Bool("draft", false, "create the poll as a draft, not streamed or counted until made active with polls status")
+			until     = fs.String("embargo-until", "", "hold the votes back from the results until this RFC 3339 time")
+			quiet     = fs.String("quiet-hours", "", "hold the votes cast every day between these times back until they end, as 22:00-07:00")
+			zone      = fs.String("zone", "", "time zone of -quiet-hours, e.g. Europe/Paris (UTC when empty)")
+			enrich    = fs.Bool("enrich-authors", false, "look up the profile of the authors of votes whose tweets came without it, for VOTE_WEIGHTING and -filter, see the ENRICH_ settings of stream")
+			campaign  = fs.String("campaign", "", "make the poll part of this campaign, reported on with its other polls")
+			owner     = fs.String("tenant", "", "create the poll in this tenant's collection, see MONGO_TENANTS")
+		)
+		fs.Parse(args)
+		status := store.StatusActive
+		if *draft {
+			status = store.StatusDraft
+		}
+		p := store.Poll{Title: *title, Status: status, Type: *kind, GeoAggregation: *geo, HashtagOnly: *hashtags, Account: *account, Private: *private, Counting: *counting, ExcludeSuspect: *suspect, SampleEvery: *sample,
+			ExcludeRetweets: *retweets, ExcludeQuotes: *quotes, ExcludeReplies: *replies, EmbeddedText: *embedded, Folding: *folding, Filter: *filter, Route: *route, MaxPerMinute: *perMinute, Timezone: *timezone, Campaign: *campaign, EnrichAuthors: *enrich, Priority: *priority, Tenant: *owner}
+		if _, ok := db.(*store.Mongo); *owner != "" && !ok {
+			return fmt.Errorf("-tenant needs the mongo store, polls are read from the tenants' collections")
+		}
+		switch *kind {
+		case "standard", "weighted", aggregate.Ranked:
+		default:
+			return fmt.Errorf("invalid -type %q, want standard, weighted or ranked", *kind)
+		}
+		switch *counting {
+		case store.CountMentions, store.CountUniqueAuthors:
+		default:
+			return fmt.Errorf("invalid -counting %q, want mentions or unique_authors", *counting)
+		}
+		switch *embedded {
+		case "", store.EmbeddedScan, store.EmbeddedIgnore:
+		default:
+			return fmt.Errorf("invalid -embedded-text %q, want scan or ignore", *embedded)
+		}
+		switch *folding {
+		case "", store.FoldDiacritics, store.FoldTransliterate:
+		default:
+			return fmt.Errorf("invalid -folding %q, want diacritics or transliterate", *folding)
+		}
+		if *filter != "" {
+			if _, err := expr.Compile(*filter); err != nil {
+				return fmt.Errorf("invalid -filter: %v", err)
+			}
+		}
+		if *route != "" {
+			if _, err := expr.Compile(*route); err != nil {
+				return fmt.Errorf("invalid -route: %v", err)
+			}
+		}
+		switch *priority {
+		case "", store.PriorityHigh, store.PriorityNormal, store.PriorityLow:
+		default:
+			return fmt.Errorf("invalid -priority %q, want high, normal or low", *priority)
+		}
+		if *sample < 0 || *sample > maxSampleEvery {
+			return fmt.Errorf("invalid -sample-every %d, want 0 to %d", *sample, maxSampleEvery)
+		}
+		if *perMinute < 0 {
+			return fmt.Errorf("invalid -max-per-minute %d, want 0 or more", *perMinute)
+		}
+		if _, err := time.LoadLocation(*timezone); err != nil {
+			return fmt.Errorf("invalid -timezone %q: %v", *timezone, err)
+		}
+		if err := validAccount(*account); err != nil {
+			return err
+		}
+		switch *geo {
+		case "", store.GeoByCountry, store.GeoByRegion:
+		default:
+			return fmt.Errorf("invalid -geo-aggregation %q, want country or region", *geo)
+		}
+		if *locations != "" {
+			for _, l := range strings.Split(*locations, ";") {
+				b, err := parseBoundingBox(l)
+				if err != nil {
+					return err
+				}
+				p.Locations = append(p.Locations, b)
+			}
+		}
+		for _, o := range strings.Split(*options, ",") {
+			if o = strings.TrimSpace(o); o != "" {
+				if err := stream.TermError(o); err != nil {
+					return err
+				}
+				for _, w := range stream.TermWarnings(o) {
+					fmt.Fprintln(os.Stderr, "warning:", w)
+				}
+				p.Options = append(p.Options, o)
+			}
+		}
+		if p.Title == "" || len(p.Options) == 0 {
+			return fmt.Errorf("create needs -title and -options")
+		}
+		if p.Matching, err = optionMatching(p.Options, *sensitive, *exact, *plural, *stems, *stemLang); err != nil {
+			return err
+		}
+		if p.Embargo, err = pollEmbargo(*until, *quiet, *zone); err != nil {
+			return err
+		}
+		if err := db.CreatePoll(&p); err != nil {
+			return err
+		}
+		fmt.Println(p.ID)
+		return nil
+	case "delete":
+		if len(args) == 0 {
+			return fmt.Errorf("delete needs the IDs of the polls to delete")
+		}
+		for _, id := range args {
+			if err := db.DeletePoll(id); err != nil {
+				return fmt.Errorf("%s: %v", id, err)
+			}
+		}
+		return nil
+	case "status":
+		if len(args) != 2 {
+			return fmt.Errorf("status needs the ID of the poll and its new status: draft, active, paused, closed or archived")
+		}
+		p, err := store.Transition(db, args[0], args[1])
+		if err != nil {
+			return fmt.Errorf("%s: %v", args[0], err)
+		}
+		fmt.Printf("%s: %s -> %s\n", p.ID, p.State(), args[1])
+		return nil
+	default:
+		return fmt.Errorf("unknown polls command %q, expected list, create, status or delete", sub)
+	}
+}
+
+// parseBoundingBox parses west,south,east,north
+func parseBoundingBox(s string) (stream.BoundingBox, error) {
+	var b stream.BoundingBox
+	parts := strings.Split(s, ",")
+	if len(parts) != 4 {
+		return b, fmt.Errorf("invalid location %q, want west,south,east,north", s)
+	}
+	for i, part := range parts {
+		f, err := strconv.ParseFloat(strings.TrimSpace(part), 64)
+		if err != nil {
+			return b, fmt.Errorf("invalid location %q: %v", s, err)
+		}
+		b[i] = f
+	}
+	if !b.Valid() {
+		return b, fmt.Errorf("invalid location %q, corners out of range or the wrong way round", s)
+	}
+	return b, nil
+}
+
+// pollEmbargo returns the embargo of the -embargo-until, -quiet-hours and -zone flags, nil for none
+func pollEmbargo(until, quiet, zone string) (*store.Embargo, error) {
+	if until == "" && quiet == "" {
+		if zone != "" {
+			return nil, fmt.Errorf("-zone needs -quiet-hours")
+		}
+		return nil, nil
+	}
+	e := &store.Embargo{Zone: zone}
+	if until != "" {
+		t, err := time.Parse(time.RFC3339, until)
+		if err != nil {
+			return nil, fmt.Errorf("invalid -embargo-until %q, want an RFC 3339 time", until)
+		}
+		e.Until = &t
+	}
+	if quiet != "" {
+		i := strings.Index(quiet, "-")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid -quiet-hours %q, want 22:00-07:00", quiet)
+		}
+		e.QuietFrom, e.QuietTo = quiet[:i], quiet[i+1:]
+	}
+	if err := e.Validate(); err != nil {
+		return nil, err
+	}
+	return e, nil
+}
+
+// optionMatching returns how strictly the options listed in sensitive and
+// exact, comma separated, are matched, and how fuzzily the ones in plural and
+// stems are, stemmed in language. Each must be one of options.
+func optionMatching(options []string, sensitive, exact, plural, stems, language string) ([]store.OptionMatching, error) {
+	if stem.For(language, stem.Full) == nil {
+		return nil, fmt.Errorf("-stem-language must be one of %s", strings.Join(stem.Languages(), ", "))
+	}
+	known := make(map[string]bool, len(options))
+	for _, o := range options {
+		known[o] = true
+	}
+	var matching []store.OptionMatching
+	add := func(flag, list string, set func(*store.OptionMatching)) error {
+		for _, o := range strings.Split(list, ",") {
+			if o = strings.TrimSpace(o); o == "" {
+				continue
+			}
+			if !known[o] {
+				return fmt.Errorf("%s lists %q, which isn't one of the options", flag, o)
+			}
+			i := 0
+			for i < len(matching) && matching[i].Option != o {
+				i++
+			}
+			if i == len(matching) {
+				matching = append(matching, store.OptionMatching{Option: o})
+			}
+			set(&matching[i])
+		}
+		return nil
+	}
+	if err := add("-case-sensitive", sensitive, func(m *store.OptionMatching) { m.CaseSensitive = true }); err != nil {
+		return nil, err
+	}
+	if err := add("-exact", exact, func(m *store.OptionMatching) { m.Exact = true }); err != nil {
+		return nil, err
+	}
+	for _, f := range []struct{ flag, list, level string }{{"-plural", plural, store.FuzzyPlural}, {"-stem", stems, store.FuzzyStem}} {
+		level := f.level
+		if err := add(f.flag, f.list, func(m *store.OptionMatching) { m.Fuzziness, m.Language = level, language }); err != nil {
+			return nil, err
+		}
+	}
+	return matching, nil
+}
+
+func listPolls(s store.PollStore) error {
+	polls, err := s.Polls()
+	if err != nil {
+		return err
+	}
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATUS\tTENANT\tTITLE\tOPTIONS")
+	for _, p := range polls {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.ID, p.State(), p.Tenant, p.Title, strings.Join(p.Options, ", "))
+	}
+	return w.Flush()
+}
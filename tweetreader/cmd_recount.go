@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// runRecount folds the logged votes of polls into their results again, with
+// the counting of this build, and replaces theirs unless -dry-run
+func runRecount(args []string) error {
+	fs := newFlagSet("recount")
+	var (
+		polls   = fs.String("poll", "", "comma separated IDs of the polls to recount")
+		dryRun  = fs.Bool("dry-run", false, "only print the differences, leave the results as they are")
+		force   = fs.Bool("force", false, "also recount polls still accepting votes, whose votes counted meanwhile are lost")
+		dedup   = fs.Duration("dedup-window", envDuration("DEDUP_WINDOW", 10*time.Minute), "the -dedup-window the counter ran with")
+		authors = fs.Duration("unique-authors-window", envDuration("UNIQUE_AUTHORS_WINDOW", 7*24*time.Hour), "the -unique-authors-window the counter ran with")
+	)
+	fs.Parse(args)
+	if *polls == "" {
+		fs.Usage()
+		return fmt.Errorf("recount needs -poll")
+	}
+	ids := strings.Split(*polls, ",")
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	vl, ok := db.(store.VoteLogStore)
+	if !ok {
+		return fmt.Errorf("this store doesn't keep a vote log")
+	}
+	if !*dryRun && !*force {
+		// the counter adds to the results while the recount replaces them
+		for _, id := range ids {
+			p, err := db.Poll(id)
+			if err != nil {
+				return fmt.Errorf("%s: %v", id, err)
+			}
+			if p.AcceptsVotes() {
+				return fmt.Errorf("poll %s is %s, close it first or pass -force", id, p.State())
+			}
+		}
+	}
+
+	recounted, err := count.Recount(db, ids, *dedup, *authors)
+	if err != nil {
+		return err
+	}
+	for _, r := range recounted {
+		fmt.Printf("poll %s %q, %d logged votes\n", r.Poll, r.Title, r.Votes)
+		printRecount(r.Before.Results, r.After.Results)
+		if *dryRun {
+			continue
+		}
+		if err := vl.ReplaceResults(r.Poll, r.After); err != nil {
+			return fmt.Errorf("%s: failed to replace the results: %v", r.Poll, err)
+		}
+		log.Printf("replaced the results of poll %s", r.Poll)
+	}
+	return nil
+}
+
+// printRecount prints the count of every option before and after recounting
+func printRecount(before, after map[string]int) {
+	options := make([]string, 0, len(after))
+	for o := range after {
+		options = append(options, o)
+	}
+	for o := range before {
+		if _, ok := after[o]; !ok {
+			options = append(options, o)
+		}
+	}
+	sort.Strings(options)
+	for _, o := range options {
+		change := ""
+		if d := after[o] - before[o]; d != 0 {
+			change = fmt.Sprintf(" (%+d)", d)
+		}
+		fmt.Printf("  %-20s %d -> %d%s\n", o, before[o], after[o], change)
+	}
+}
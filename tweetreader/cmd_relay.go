@@ -0,0 +1,95 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/leader"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// runRelay publishes the votes the streamers running with -outbox added to
+// the store's outbox, until interrupted
+func runRelay(args []string) error {
+	fs := newFlagSet("relay")
+	var (
+		batch   = fs.Int("batch", int(envInt64("RELAY_BATCH", 100)), "messages read from the outbox at once")
+		every   = fs.Duration("every", envDuration("RELAY_EVERY", time.Second), "how long to wait once the outbox is empty, or a pass failed")
+		elect   = fs.Bool("leader-election", os.Getenv("RELAY_LEADER_ELECTION") != "", "only relay while leading the relays using the same store, the others stand by")
+		metricz = fs.String("metrics", envString("METRICS_ADDR", ":9103"), "address to serve /metrics on")
+	)
+	fs.Parse(args)
+
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	outbox, ok := db.(store.OutboxStore)
+	if !ok {
+		return fmt.Errorf("the store doesn't keep an outbox")
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.Handler)
+	go func() {
+		if err := http.ListenAndServe(*metricz, mux); err != nil {
+			log.Println("relay: metrics server stopped:", err)
+		}
+	}()
+
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	var lost <-chan struct{}
+	if *elect {
+		leaders, ok := db.(store.LeaderStore)
+		if !ok {
+			return fmt.Errorf("-leader-election needs a store that keeps leases")
+		}
+		host, _ := os.Hostname()
+		id := envString("LEADER_ID", fmt.Sprintf("%s-%d", host, os.Getpid()))
+		elector := leader.New(leaders, envString("LEADER_NAME", "relay"), id, envDuration("LEADER_LEASE", 10*time.Second))
+		elector.Start()
+		defer elector.Stop()
+		log.Println("Standing by until elected leader...")
+		select {
+		case <-elector.Elected():
+		case <-termChan:
+			log.Println("Stopping relay...")
+			return nil
+		}
+		lost = elector.Lost()
+	}
+
+	open := func(topic string) (publish.Publisher, error) {
+		p, err := newPublisher(topic)
+		if err != nil {
+			return nil, err
+		}
+		return p, nil
+	}
+	relay := publish.NewRelay(outbox, open, publish.RelayConfig{Batch: *batch, Every: *every})
+	defer relay.Stop()
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		relay.Run(stop)
+	}()
+	log.Println("Relaying the outbox...")
+	select {
+	case <-termChan:
+		log.Println("Stopping relay...")
+	case <-lost:
+		log.Println("Lost the leadership, stopping relay...")
+	}
+	close(stop)
+	<-done
+	return nil
+}
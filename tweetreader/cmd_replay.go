@@ -0,0 +1,262 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/privacy"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// runReplay feeds tweets saved as newline delimited JSON back through matching and publishing.
+// "-" reads from stdin, files ending in .gz are gunzipped. With -archive the
+// batches of the tweet archive are replayed instead.
+func runReplay(args []string) error {
+	fs := newFlagSet("replay")
+	var (
+		topic      = fs.String("topic", "", "NSQ topic to publish votes to (default votes, or votes_replay with -archive)")
+		options    = fs.String("options", "", "comma separated options to match (default: load from the store)")
+		rate       = fs.Float64("rate", 0, "maximum tweets per second to replay (0 for no limit)")
+		archiveURL = fs.String("archive", "", "replay the tweets archived at s3://bucket/prefix, gs://bucket/prefix or a local directory")
+		poll       = fs.String("poll", "", "only replay the archived tweets of this poll")
+		from       = fs.String("from", "", "only replay the archived tweets from this hour on, as 2006-01-02-15 or RFC 3339")
+		to         = fs.String("to", "", "only replay the archived tweets before this hour")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 && *archiveURL == "" {
+		fs.Usage()
+		return fmt.Errorf("no files to replay")
+	}
+	if fs.NArg() > 0 && *archiveURL != "" {
+		return fmt.Errorf("replay either files or -archive, not both")
+	}
+	if *topic == "" {
+		*topic = "votes"
+		if *archiveURL != "" {
+			// kept apart from the live votes, which were counted once already
+			*topic = "votes_replay"
+		}
+	}
+	if *archiveURL != "" && *topic == "votes" {
+		log.Println("WARNING: replaying the archive to the votes topic counts its tweets again in the live results")
+	}
+	var window hourRange
+	var err error
+	if window.from, err = parseHour(*from); err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	if window.to, err = parseHour(*to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+
+	matcher, err := newMatcher()
+	if err != nil {
+		return err
+	}
+	// without the store the filter can't tell the private polls, and anonymizes every vote
+	private, _, err := newPrivacy()
+	if err != nil {
+		return err
+	}
+	// nor the filter expressions of the polls, -options replays every vote
+	filters := expr.NewFilters()
+	if *options != "" {
+		matcher.Update(strings.Split(*options, ","))
+	} else {
+		db, err := dialStore()
+		if err != nil {
+			return fmt.Errorf("failed to open the store: %v", err)
+		}
+		loaded, err := filters.Options(db, private.Options(db, db.LoadOptions))()
+		db.Close()
+		if err != nil {
+			return fmt.Errorf("failed to load options: %v", err)
+		}
+		matcher.Update(loaded)
+	}
+
+	c, err := voteCodec(*topic)
+	if err != nil {
+		return err
+	}
+	pub, err := newPublisher(*topic)
+	if err != nil {
+		return fmt.Errorf("failed to create publisher: %v", err)
+	}
+	defer pub.Stop()
+
+	var delay time.Duration
+	if *rate > 0 {
+		delay = time.Duration(float64(time.Second) / *rate)
+	}
+	if *archiveURL != "" {
+		n, err := replayArchive(*archiveURL, *poll, window, matcher, filters, private, pub, c, delay)
+		log.Printf("%s: replayed %d votes to %s", *archiveURL, n, *topic)
+		return err
+	}
+	for _, name := range fs.Args() {
+		n, err := replayFile(name, matcher, filters, private, pub, c, delay)
+		if err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+		log.Printf("%s: replayed %d votes", name, n)
+	}
+	return nil
+}
+
+// replayFile publishes the votes for every tweet in the named file
+func replayFile(name string, matcher *match.Matcher, filters *expr.Filters, private *privacy.Filter, pub publish.Publisher, c codec.Codec, delay time.Duration) (int, error) {
+	var r io.Reader = os.Stdin
+	if name != "-" {
+		f, err := os.Open(name)
+		if err != nil {
+			return 0, err
+		}
+		defer f.Close()
+		r = f
+	}
+	if strings.HasSuffix(name, ".gz") {
+		gz, err := gzip.NewReader(r)
+		if err != nil {
+			return 0, err
+		}
+		defer gz.Close()
+		r = gz
+	}
+	return replayTweets(name, r, matcher, filters, private, pub, c, delay, nil)
+}
+
+// hourRange holds the hours from from up to to, either may be zero for no limit
+type hourRange struct {
+	from, to time.Time
+}
+
+func (h hourRange) contains(t time.Time) bool {
+	return (h.from.IsZero() || !t.Before(h.from)) && (h.to.IsZero() || t.Before(h.to))
+}
+
+// parseHour parses an hour of the archive, "" is the zero time
+func parseHour(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(archive.HourFormat, s); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		return t, fmt.Errorf("%q isn't an hour like 2006-01-02-15 or a time like 2006-01-02T15:04:05Z", s)
+	}
+	return t.UTC().Truncate(time.Hour), nil
+}
+
+// replayArchive publishes the votes for the archived tweets of poll, or every
+// poll, in window. A tweet archived for several polls is only replayed once.
+func replayArchive(url, poll string, window hourRange, matcher *match.Matcher, filters *expr.Filters, private *privacy.Filter, pub publish.Publisher, c codec.Codec, delay time.Duration) (int, error) {
+	seen := make(map[string]bool)
+	var published int
+	err := archivedBatches(url, poll, window, func(key string, r io.Reader) error {
+		n, err := replayTweets(key, r, matcher, filters, private, pub, c, delay, seen)
+		published += n
+		return err
+	})
+	return published, err
+}
+
+// archivedBatches calls fn with the tweets of every batch archived for poll, or
+// every poll, in window, stopping at the first error
+func archivedBatches(url, poll string, window hourRange, fn func(key string, r io.Reader) error) error {
+	src, err := archive.OpenSource(archiveConfig(url))
+	if err != nil {
+		return err
+	}
+	prefix := ""
+	if poll != "" {
+		prefix = archive.PollPrefix(poll)
+	}
+	keys, err := src.List(prefix)
+	if err != nil {
+		return err
+	}
+	var batches int
+	for _, key := range keys {
+		hour, err := archive.Hour(key)
+		if err != nil {
+			log.Printf("skipping %s: %v", key, err)
+			continue
+		}
+		if !window.contains(hour) {
+			continue
+		}
+		data, err := src.Get(key)
+		if err != nil {
+			return err
+		}
+		gz, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		if err := fn(key, gz); err != nil {
+			return fmt.Errorf("%s: %v", key, err)
+		}
+		batches++
+	}
+	log.Printf("read %d of %d archived batches", batches, len(keys))
+	return nil
+}
+
+// replayTweets publishes the votes for every tweet r has, skipping the IDs in
+// seen if it isn't nil and adding the others to it
+func replayTweets(name string, r io.Reader, matcher *match.Matcher, filters *expr.Filters, private *privacy.Filter, pub publish.Publisher, c codec.Codec, delay time.Duration, seen map[string]bool) (int, error) {
+	var published int
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(strings.TrimSpace(scanner.Text())) == 0 {
+			continue
+		}
+		var t stream.Tweet
+		if err := json.Unmarshal(scanner.Bytes(), &t); err != nil {
+			log.Printf("%s:%d: skipping: %v", name, line, err)
+			continue
+		}
+		if seen != nil {
+			if seen[t.ID] {
+				continue
+			}
+			seen[t.ID] = true
+		}
+		for _, v := range matcher.Match(t) {
+			// the archive keeps the tweets of every source
+			v.Source = stream.SourceOf(t.ID)
+			if !filters.Filter(&v) {
+				continue
+			}
+			private.Apply(&v)
+			b, err := codec.Encode(c, &v)
+			if err != nil {
+				log.Println("Marshall error: ", err)
+				continue
+			}
+			if err := pub.Publish(b); err != nil {
+				return published, err
+			}
+			published++
+		}
+		time.Sleep(delay)
+	}
+	return published, scanner.Err()
+}
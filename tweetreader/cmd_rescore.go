@@ -0,0 +1,147 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// metricRescored is the metric adding up the deltas rescore added to each
+// option's results
+const metricRescored = "rescored"
+
+// rescoreState is saved under rescoreSnapshot once a poll's deltas are added,
+// so a version of its options is rescored once
+type rescoreState struct {
+	Version int       `json:"version"`
+	At      time.Time `json:"at"`
+}
+
+func rescoreSnapshot(poll string) string {
+	return "rescore_" + poll
+}
+
+// runRescore matches the tweets archived for a poll again with its options
+// now and adds the deltas to its results, so they count as if the options had
+// always been what they are, unless -dry-run
+func runRescore(args []string) error {
+	fs := newFlagSet("rescore")
+	var (
+		poll       = fs.String("poll", "", "ID of the poll whose options were edited")
+		archiveURL = fs.String("archive", envString("ARCHIVE_URL", ""), "where the tweets are archived: s3://bucket/prefix, gs://bucket/prefix or a local directory")
+		from       = fs.String("from", "", "only rescore the tweets from this time on, as 2006-01-02, 2006-01-02-15 or RFC 3339")
+		to         = fs.String("to", "", "only rescore the tweets before this time")
+		dryRun     = fs.Bool("dry-run", false, "only print the deltas, leave the results as they are")
+		force      = fs.Bool("force", false, "also rescore a version of the options already rescored, whose deltas are then added again")
+		dedup      = fs.Duration("dedup-window", envDuration("DEDUP_WINDOW", 10*time.Minute), "the -dedup-window the counter ran with")
+		authors    = fs.Duration("unique-authors-window", envDuration("UNIQUE_AUTHORS_WINDOW", 7*24*time.Hour), "the -unique-authors-window the counter ran with")
+	)
+	fs.Parse(args)
+	if *poll == "" || *archiveURL == "" {
+		fs.Usage()
+		return fmt.Errorf("rescore needs -poll and -archive")
+	}
+	var window hourRange
+	var err error
+	if window.from, err = parseExportTime(*from); err != nil {
+		return fmt.Errorf("invalid -from: %v", err)
+	}
+	if window.to, err = parseExportTime(*to); err != nil {
+		return fmt.Errorf("invalid -to: %v", err)
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	p, err := db.Poll(*poll)
+	if err != nil {
+		return fmt.Errorf("%s: %v", *poll, err)
+	}
+	if len(p.Versions) == 0 {
+		return fmt.Errorf("the options of poll %s were never edited, there is nothing to rescore", p.ID)
+	}
+	if !*dryRun && !*force {
+		var last rescoreState
+		b, err := db.LoadSnapshot(rescoreSnapshot(p.ID))
+		if err != nil && err != store.ErrNoSnapshot {
+			return err
+		}
+		if err == nil {
+			if err := json.Unmarshal(b, &last); err != nil {
+				return fmt.Errorf("failed to read the last rescore of poll %s: %v", p.ID, err)
+			}
+		}
+		if last.Version >= p.OptionsVersion() {
+			return fmt.Errorf("version %d of the options of poll %s was rescored at %s, pass -force to add its deltas again",
+				last.Version, p.ID, last.At.Format(time.RFC3339))
+		}
+	}
+
+	rescored, err := rescore(*archiveURL, p, window, *dedup, *authors)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("poll %s %q, %d archived tweets, options version %d\n", p.ID, p.Title, rescored.Tweets, p.OptionsVersion())
+	printRecount(rescored.Before.Results, rescored.After.Results)
+	if *dryRun {
+		return nil
+	}
+	if len(rescored.Results) > 0 || len(rescored.Weighted) > 0 {
+		if err := db.AddResults(p.ID, rescored.Results, rescored.Weighted); err != nil {
+			return fmt.Errorf("%s: failed to add the deltas: %v", p.ID, err)
+		}
+		deltas := make(map[string]float64, len(rescored.Results))
+		for o, d := range rescored.Results {
+			deltas[o] = float64(d)
+		}
+		if err := db.AddMetrics(p.ID, map[string]map[string]float64{metricRescored: deltas}); err != nil {
+			return fmt.Errorf("%s: failed to add the %s metric: %v", p.ID, metricRescored, err)
+		}
+	}
+	b, err := json.Marshal(rescoreState{Version: p.OptionsVersion(), At: time.Now().UTC()})
+	if err != nil {
+		return err
+	}
+	if err := db.SaveSnapshot(rescoreSnapshot(p.ID), b); err != nil {
+		return fmt.Errorf("%s: the deltas were added but not recorded, don't rescore this version again: %v", p.ID, err)
+	}
+	log.Printf("added the deltas of %d options to the results of poll %s", len(rescored.Results), p.ID)
+	return nil
+}
+
+// rescore folds the votes of the tweets archived for p in window with the
+// version of its options each tweet was matched against and with its options now
+func rescore(url string, p store.Poll, window hourRange, dedup, authors time.Duration) (count.Rescored, error) {
+	r, err := count.NewRescore(p, dedup, authors)
+	if err != nil {
+		return count.Rescored{}, err
+	}
+	now, err := pollMatcher(p, p.Options)
+	if err != nil {
+		return count.Rescored{}, err
+	}
+	matchers := make(map[int]*match.Matcher)
+	err = archivedTweets(url, p.ID, window, func(tweet stream.Tweet, at time.Time) error {
+		version := p.OptionsAt(at)
+		then, ok := matchers[version.Version]
+		if !ok {
+			var err error
+			if then, err = pollMatcher(p, version.Options); err != nil {
+				return err
+			}
+			matchers[version.Version] = then
+		}
+		return r.Fold(then.Match(tweet), now.Match(tweet), at)
+	})
+	if err != nil {
+		return count.Rescored{}, err
+	}
+	return r.Done()
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/retention"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+)
+
+// runRetention deletes the data older than its retention and archives and
+// compacts the polls that are over, once or every -every
+func runRetention(args []string) error {
+	fs := newFlagSet("retention")
+	var (
+		tweets     = fs.Duration("tweets", envDuration("RETENTION_TWEETS", 0), "delete the counted tweets older than this (0 to keep them)")
+		series     = fs.Duration("series", envDuration("RETENTION_SERIES", 0), "delete the results_timeseries buckets older than this (0 to keep them)")
+		history    = fs.Duration("history", envDuration("RETENTION_HISTORY", 0), "delete the results_history entries older than this (0 to keep them)")
+		after      = fs.Duration("archive-closed-after", envDuration("RETENTION_ARCHIVE_AFTER", 0), "archive the polls closed for this long (0 to never)")
+		archiveURL = fs.String("archive", envString("RETENTION_ARCHIVE", ""), "store the expired tweets here before deleting them: s3://bucket/prefix, gs://bucket/prefix or a local directory")
+		every      = fs.Duration("every", envDuration("RETENTION_EVERY", 0), "sweep this often until stopped (0 to sweep once and exit)")
+	)
+	fs.Parse(args)
+
+	cfg := retention.Config{Tweets: *tweets, Series: *series, History: *history, ArchiveAfter: *after}
+	if *archiveURL != "" {
+		sink, err := archive.OpenSink(archiveConfig(*archiveURL))
+		if err != nil {
+			return fmt.Errorf("invalid -archive: %v", err)
+		}
+		cfg.Archive = sink
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	sweep := func() error {
+		r, err := retention.Sweep(db, cfg, time.Now())
+		b, _ := json.Marshal(r)
+		log.Printf("retention: %s", b)
+		return err
+	}
+	if *every <= 0 {
+		return sweep()
+	}
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	t := time.NewTicker(*every)
+	defer t.Stop()
+	for {
+		sweep() // a failed sweep is logged and retried with the next
+		select {
+		case <-t.C:
+		case <-termChan:
+			log.Println("Stopping retention...")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/auth"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/moderation"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+)
+
+// runReview keeps the votes the streamers held for moderation and serves the
+// API moderators approve or reject them with
+func runReview(args []string) error {
+	fs := newFlagSet("review")
+	var (
+		addr    = fs.String("addr", envString("REVIEW_ADDR", ":8084"), "address to serve the review API on")
+		lookupd = fs.String("lookupd", envString("NSQLOOKUPD_ADDR", "localhost:4161"), "nsqlookupd address")
+		topic   = fs.String("topic", envString("VOTES_TOPIC", "votes"), "NSQ topic approved votes are published on")
+		max     = fs.Int("max-pending", int(envInt64("REVIEW_MAX_PENDING", 10000)), "votes kept for review, more wait on the pending topic")
+	)
+	fs.Parse(args)
+
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+	queue := moderation.NewQueue(*max, db)
+	if err := queue.Load(); err != nil {
+		return fmt.Errorf("failed to load the review queue: %v", err)
+	}
+
+	c, err := voteCodec(*topic)
+	if err != nil {
+		return err
+	}
+	pub, err := newPublisher(*topic)
+	if err != nil {
+		return err
+	}
+	defer pub.Stop()
+
+	cfg, err := nsqConfig()
+	if err != nil {
+		return err
+	}
+	q, err := nsq.NewConsumer(moderation.Topic, "review", cfg)
+	if err != nil {
+		return err
+	}
+	q.AddHandler(queue)
+	if err := q.ConnectToNSQLookupd(*lookupd); err != nil {
+		return err
+	}
+	defer q.Stop()
+
+	a, err := newAdminAuth()
+	if err != nil {
+		return fmt.Errorf("review API: %v", err)
+	}
+	approve := func(v *match.Vote) error {
+		b, err := codec.Encode(c, v)
+		if err != nil {
+			return err
+		}
+		return pub.Publish(b)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", metrics.Handler)
+	mux.HandleFunc("/review", a.with(auth.Viewer, handleReviewList(queue)))
+	mux.HandleFunc("/review/approve", a.with(auth.PollAdmin, handleReviewDecision(queue, approve)))
+	mux.HandleFunc("/review/reject", a.with(auth.PollAdmin, handleReviewDecision(queue, nil)))
+	srv := &http.Server{Addr: *addr, Handler: mux}
+	go func() {
+		termChan := make(chan os.Signal, 1)
+		shutdown.Notify(termChan)
+		<-termChan
+		log.Println("Stopping review server...")
+		ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+		defer cancel()
+		srv.Shutdown(ctx)
+	}()
+	log.Println("Serving the review API on", *addr)
+	if err := srv.ListenAndServe(); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// handleReviewList lists the pending votes, oldest first, up to ?limit= (100 by default)
+func handleReviewList(queue *moderation.Queue) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			respondErr(w, http.StatusMethodNotAllowed, "use GET")
+			return
+		}
+		limit := 100
+		if s := r.URL.Query().Get("limit"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 1 {
+				respondErr(w, http.StatusBadRequest, "invalid limit ", strconv.Quote(s), ", want a positive number")
+				return
+			}
+			limit = n
+		}
+		votes, total := queue.List(limit)
+		respond(w, http.StatusOK, map[string]interface{}{"pending": votes, "total": total})
+	}
+}
+
+// handleReviewDecision decides on the pending vote ?id= names: approves it
+// with approve, or rejects it when approve is nil
+func handleReviewDecision(queue *moderation.Queue, approve func(v *match.Vote) error) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			respondErr(w, http.StatusMethodNotAllowed, "use POST")
+			return
+		}
+		id := r.URL.Query().Get("id")
+		if id == "" {
+			respondErr(w, http.StatusBadRequest, "missing id, the message_id of the pending vote")
+			return
+		}
+		decision := "rejected"
+		var err error
+		if approve != nil {
+			decision = "approved"
+			err = queue.Approve(id, approve)
+		} else {
+			err = queue.Reject(id)
+		}
+		switch {
+		case err == moderation.ErrNotPending:
+			respondErr(w, http.StatusNotFound, err)
+		case err != nil:
+			log.Println("review: failed to publish", id, err)
+			respondErr(w, http.StatusServiceUnavailable, "failed to publish the vote: ", err)
+		default:
+			respond(w, http.StatusOK, map[string]interface{}{"id": id, "decision": decision})
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+)
+
+// runRules reconciles the rules of a v2 filtered stream with the options of
+// the tracked polls, once or every -every
+func runRules(args []string) error {
+	fs := newFlagSet("rules")
+	var (
+		url    = fs.String("url", envString("TWITTER_RULES_URL", stream.RulesURL), "filtered stream rules endpoint")
+		dryRun = fs.Bool("dry-run", os.Getenv("RULES_DRY_RUN") != "", "report the drift and have Twitter validate the repairs without making them")
+		every  = fs.Duration("every", envDuration("RULES_EVERY", 0), "reconcile this often until stopped (0 to reconcile once and exit)")
+	)
+	fs.Parse(args)
+
+	token := secret("TWITTER_BEARER_TOKEN")
+	if token == "" {
+		return fmt.Errorf("TWITTER_BEARER_TOKEN is needed to manage the filtered stream rules")
+	}
+	t, err := tlsConfig("TWITTER")
+	if err != nil {
+		return err
+	}
+	rules := stream.NewRules(stream.RulesConfig{
+		URL:         *url,
+		BearerToken: token,
+		Transport: stream.TransportConfig{
+			TLS:           t,
+			DialTimeout:   envDuration("TWITTER_DIAL_TIMEOUT", 10*time.Second),
+			SearchTimeout: envDuration("TWITTER_SEARCH_TIMEOUT", 30*time.Second),
+		},
+	})
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	sync := func() error {
+		options, err := db.LoadOptions()
+		if err != nil {
+			log.Println("rules: failed to load the options:", err)
+			return err
+		}
+		r, err := rules.Sync(options, *dryRun)
+		b, _ := json.Marshal(r)
+		switch {
+		case err != nil:
+			log.Printf("rules: %v, after %s", err, b)
+		case r.Drifted() && *dryRun:
+			log.Printf("rules: drifted, dry run: %s", b)
+		case r.Drifted():
+			log.Printf("rules: repaired drift: %s", b)
+		default:
+			log.Printf("rules: in sync, %d rules", r.Remote)
+		}
+		return err
+	}
+	if *every <= 0 {
+		return sync()
+	}
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	tick := time.NewTicker(*every)
+	defer tick.Stop()
+	for {
+		sync() // a failed reconciliation is logged and retried with the next
+		select {
+		case <-tick.C:
+		case <-termChan:
+			log.Println("Stopping rules...")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,1819 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/anomaly"
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/audit"
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+	"github.com/olawolu/twitter-polls/tweetreader/budget"
+	"github.com/olawolu/twitter-polls/tweetreader/chaos"
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/content"
+	"github.com/olawolu/twitter-polls/tweetreader/control"
+	"github.com/olawolu/twitter-polls/tweetreader/deadletter"
+	"github.com/olawolu/twitter-polls/tweetreader/degrade"
+	"github.com/olawolu/twitter-polls/tweetreader/enrich"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/features"
+	"github.com/olawolu/twitter-polls/tweetreader/hibernate"
+	"github.com/olawolu/twitter-polls/tweetreader/leader"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/moderation"
+	"github.com/olawolu/twitter-polls/tweetreader/partition"
+	"github.com/olawolu/twitter-polls/tweetreader/privacy"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/quarantine"
+	"github.com/olawolu/twitter-polls/tweetreader/retract"
+	"github.com/olawolu/twitter-polls/tweetreader/rollup"
+	"github.com/olawolu/twitter-polls/tweetreader/sampling"
+	"github.com/olawolu/twitter-polls/tweetreader/sdnotify"
+	"github.com/olawolu/twitter-polls/tweetreader/shadow"
+	"github.com/olawolu/twitter-polls/tweetreader/shard"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/sigv4"
+	"github.com/olawolu/twitter-polls/tweetreader/silence"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/tap"
+	"github.com/olawolu/twitter-polls/tweetreader/tenant"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+	"github.com/olawolu/twitter-polls/tweetreader/tiers"
+	"github.com/olawolu/twitter-polls/tweetreader/versions"
+)
+
+var (
+	nsqdAddr = envString("NSQD_ADDR", "localhost:4150")
+
+	// votes are spooled here while publishing is paused
+	spoolDir      = envString("SPOOL_DIR", filepath.Join(os.TempDir(), "tweetreader"))
+	spoolMaxBytes = envInt64("SPOOL_MAX_BYTES", 256<<20)
+
+	// faults injects failures in builds with -tags chaos, see chaos.go, and is nil otherwise
+	faults *chaos.Injector
+)
+
+// runStream is the original tweetreader: stream, match and publish votes until interrupted
+func runStream(args []string) error {
+	fs := newFlagSet("stream")
+	var (
+		backPressure = fs.Bool("back-pressure", os.Getenv("BACK_PRESSURE") != "", "sample or slow votes when the counter asks to on the control topic")
+		lookupd      = fs.String("lookupd", envString("NSQLOOKUPD_ADDR", "localhost:4161"), "nsqlookupd address used to find the control and poll_events topics")
+		refresh      = fs.Duration("refresh", envDuration("REFRESH_INTERVAL", time.Minute), "how often to reconnect to Twitter to pick up new options (0 to only reconnect on poll changes)")
+		sourceName   = fs.String("source", envString("SOURCE", "twitter"), "where tweets come from: twitter, twitter-search to poll the search API every TWITTER_SEARCH_INTERVAL instead of streaming, activity for the Account Activity webhook on -activity-addr alone, youtube for the live chats of -youtube-videos, twitch for the chats of -twitch-channels, telegram for the groups and channels of -telegram-chats, feeds for the RSS and Atom feeds of -feeds, or synthetic to generate votes for the poll options")
+		videos       = fs.String("youtube-videos", envString("YOUTUBE_VIDEO_IDS", ""), "comma separated IDs of the YouTube live streams whose chats -source youtube reads")
+		channels     = fs.String("twitch-channels", envString("TWITCH_CHANNELS", ""), "comma separated Twitch channels whose chats -source twitch reads")
+		smsAddr      = fs.String("sms-addr", envString("SMS_ADDR", ""), "address to serve Twilio's inbound SMS webhook on, taking \"VOTE <option>\" texts as votes besides -source; off when empty")
+		activityAddr = fs.String("activity-addr", envString("ACTIVITY_ADDR", ""), "address to serve the Account Activity API webhook on, taking the mentions and replies of the subscribed accounts as votes besides -source, or alone with -source activity; off when empty")
+		feeds        = fs.String("feeds", envString("FEEDS", ""), "comma separated URLs of the RSS and Atom feeds -source feeds polls")
+		chats        = fs.String("telegram-chats", envString("TELEGRAM_CHATS", ""), "comma separated IDs or @usernames of the Telegram groups and channels -source telegram reads")
+		synthRate    = fs.Float64("synthetic-rate", 100, "tweets per second generated by -source synthetic")
+		synthDist    = fs.String("synthetic-distribution", stream.Uniform, "how -source synthetic spreads votes over the options: uniform or zipf")
+		synthMulti   = fs.Float64("synthetic-multi", 0.1, "share of synthetic tweets that name a second option")
+		queueSize    = fs.Int("votes-buffer", int(envInt64("VOTES_BUFFER", 1024)), "votes held for the publisher while the broker is slow (0 for none)")
+		overflow     = fs.String("votes-overflow", envString("VOTES_OVERFLOW", publish.Block), "what to do with votes once -votes-buffer is full: block, drop-oldest or drop-new")
+		pollEvents   = fs.Bool("poll-events", os.Getenv("POLL_EVENTS") != "", "reconnect to Twitter as soon as the rest-api announces a poll change on poll_events")
+		sharded      = fs.Bool("shard", os.Getenv("SHARD") != "", "split the options with the other streamers using the same store, each tracking its share")
+		elect        = fs.Bool("leader-election", os.Getenv("LEADER_ELECTION") != "", "only stream while leading the replicas using the same store, the others stand by")
+		leases       = fs.String("leader-leases", envString("LEADER_LEASES", "store"), "where the leader's lease is kept: store, or kubernetes for a Lease of the pod's namespace (LEADER_NAMESPACE)")
+		grace        = fs.Duration("shutdown-grace", envDuration("SHUTDOWN_GRACE", 0), "how long stopping may take in all, e.g. the pod's terminationGracePeriodSeconds (0 for the stages' own timeouts)")
+		stall        = fs.Duration("watchdog-stall", envDuration("WATCHDOG_STALL", 5*time.Minute), "how long the streams may be down or the broker failing before systemd's watchdog isn't petted, with WatchdogSec=")
+		drainDelay   = fs.Duration("drain-delay", envDuration("DRAIN_DELAY", 0), "how long /readyz reports not ready before stopping, for the load balancer to notice")
+		dryRun       = fs.Bool("dry-run", os.Getenv("DRY_RUN") != "", "stream and match as usual but log the votes instead of publishing them")
+		blockedFile  = fs.String("blocked-terms", envString("BLOCKED_TERMS_FILE", ""), "file of terms, one per line, whose tweets' votes are dropped")
+		redactedFile = fs.String("redacted-terms", envString("REDACTED_TERMS_FILE", ""), "file of terms, one per line, masked in the text of the votes")
+		archiveURL   = fs.String("archive", envString("ARCHIVE_URL", ""), "archive the matched tweets to s3://bucket/prefix, gs://bucket/prefix or a local directory")
+		spikes       = fs.Bool("spike-detection", os.Getenv("SPIKE_DETECTION") != "", "alert on spikes of votes for an option and tag the votes cast during them as suspect")
+		silentAfter  = fs.Duration("silence-after", envDuration("SILENCE_AFTER", 0), "alert on an option matching no vote for this long while the other options of its polls get them, likely dropped by Twitter or misspelled (0 to never)")
+		retractFor   = fs.Duration("retract-window", envDuration("RETRACT_WINDOW", 24*time.Hour), "how long the tweets of the votes are remembered by author, retracting them when Twitter says their author protected or lost the account; deleted tweets are always retracted (0 to retract nothing)")
+		auditShare   = fs.Float64("audit-fraction", envFloat("AUDIT_FRACTION", 0), "share of each option's votes published on the audit topic for people to spot-check the matches, e.g. 0.001, at least AUDIT_MIN_PER_OPTION an hour (0 to sample none)")
+		moderate     = fs.Bool("moderate", os.Getenv("MODERATE") != "", "hold contested votes on the pending topic for review instead of counting them")
+		featureFlags = fs.String("features", envString("FEATURE_FLAGS", ""), "stages on or off unless set otherwise through the admin API, as moderation=on,content_filter=off; content_filter is on and moderation as -moderate by default")
+		rateCap      = fs.Float64("poll-rate-cap", envFloat("POLL_RATE_CAP", 0), "votes a second each poll publishes at most, the rest are dropped (0 for no cap)")
+		rateBurst    = fs.Int("poll-rate-burst", int(envInt64("POLL_RATE_BURST", 0)), "votes over -poll-rate-cap a poll may publish at once (0 for the cap)")
+		quarantineAt = fs.Int("auto-quarantine", int(envInt64("AUTO_QUARANTINE", 0)), "quarantine a poll once this many of its votes went over its cap within a minute (0 to never)")
+		tenantTopics = fs.Bool("tenant-topics", os.Getenv("TENANT_TOPICS") != "", "publish the votes for tenants' polls to votes.<tenant>, see MONGO_TENANTS")
+		partitions   = fs.Int("partitions", int(envInt64("VOTE_PARTITIONS", 0)), "publish the votes to votes-0 to votes-<n-1> by their polls, for a counter per partition (0 for votes alone)")
+		tenantQuota  = fs.Float64("tenant-quota", envFloat("TENANT_QUOTA", 0), "votes a second each tenant ingests at most, the rest aren't published for it (0 for no quota)")
+		quotas       = fs.String("tenant-quotas", envString("TENANT_QUOTAS", ""), "quotas of some tenants overriding -tenant-quota, as acme=500,globex=50")
+		publishTo    = fs.String("publishers", envString("PUBLISHERS", "nsq"), "comma separated backends the votes are published to, each with its own breaker and spool: "+strings.Join(publishers, ", ")+"; nsq must be one, the counter consumes it")
+		outboxed     = fs.Bool("outbox", os.Getenv("OUTBOX") != "", "add the votes to the store's outbox for the relay command to publish, instead of publishing them")
+		hibernAfter  = fs.Duration("hibernate-after", envDuration("HIBERNATE_AFTER", 0), "take a low priority poll's options out of the filter once they matched no vote for this long (0 to never)")
+		wakeEvery    = fs.Duration("hibernate-wake-every", envDuration("HIBERNATE_WAKE_EVERY", time.Hour), "how often a hibernating poll's options are tracked again to check for votes (0 to never)")
+		wakeFor      = fs.Duration("hibernate-wake-for", envDuration("HIBERNATE_WAKE_FOR", 5*time.Minute), "how long a hibernating poll's options are tracked when it wakes")
+		rollupWindow = fs.Duration("rollup-window", envDuration("ROLLUP_WINDOW", 0), "publish the votes for each option added up over this window, as one message counting them, instead of one a tweet (0 for one a tweet)")
+		trackLimit   = fs.Int("track-limit", int(envInt64("TRACK_LIMIT", 0)), "terms each Twitter stream tracks at most, by poll priority, searching for the options over it every TRACK_SEARCH_INTERVAL (0 to track them all)")
+		sloEvery     = fs.Duration("slo-report-interval", envDuration("SLO_REPORT_INTERVAL", 24*time.Hour), "how often to log and emit the stream's SLO summary as a stream.slo_summary event (0 to never)")
+		errBudget    = fs.Float64("error-budget", envFloat("ERROR_BUDGET", 0.9), "share of publishing's or the streams' attempts failing within a minute that pauses the streams for -error-budget-pause and reports /readyz degraded (0 to never)")
+		budgetPause  = fs.Duration("error-budget-pause", envDuration("ERROR_BUDGET_PAUSE", 30*time.Second), "how long the streams pause once a component's error budget is exhausted")
+		workers      = fs.Int("match-workers", int(envInt64("MATCH_WORKERS", 1)), "goroutines matching the tweets of each source, for matching to use several cores; the votes keep the order of their tweets")
+		tapSize      = fs.Int("tap-size", int(envInt64("TAP_SIZE", tap.DefaultSize)), "how many of the last votes published, and of the stream messages that couldn't be decoded, /debug/recent shows (0 for none)")
+		checkpointIn = fs.Duration("checkpoint-interval", envDuration("CHECKPOINT_INTERVAL", 30*time.Second), "how often the YouTube, Telegram and feeds sources save where they are reading, to go on from there after a restart; they save when stopping too (0 to only save when stopping)")
+		latBudget    = fs.Duration("latency-budget", envDuration("LATENCY_BUDGET", time.Minute), "the 95th percentile of the time from a tweet being posted to the broker acknowledging its vote over which a vote.latency event is emitted, once until it is back within (0 to never)")
+		degradeSteps = fs.String("degrade-ladder", envString("DEGRADE_LADDER", ""), "steps taken one at a time while the memory, the spool or the votes buffer is over its threshold: "+strings.Join(degrade.Steps, ", ")+" in that order by default")
+		degradeMem   = fs.Int64("degrade-memory", envInt64("DEGRADE_MEMORY", 0), "bytes of heap in use over which a step of -degrade-ladder is taken (0 to never)")
+		degradeSpool = fs.Int64("degrade-spool", envInt64("DEGRADE_SPOOL", 0), "bytes of votes spooled for the broker over which a step of -degrade-ladder is taken (0 to never)")
+		degradeQueue = fs.Float64("degrade-buffer", envFloat("DEGRADE_BUFFER", 0), "share of -votes-buffer taken, e.g. 0.9, over which a step of -degrade-ladder is taken (0 to never)")
+		logBudget    = fs.Int("log-budget", int(envInt64("LOG_BUDGET", 60)), "lines a minute publishing and the streams each log about their failures at most, the rest are counted (0 for no limit)")
+	)
+	fs.Parse(args)
+	settings := streamSettings{
+		source:       *sourceName,
+		publishers:   splitList(*publishTo),
+		overflow:     *overflow,
+		dryRun:       *dryRun,
+		outbox:       *outboxed,
+		sharded:      *sharded,
+		elect:        *elect,
+		tenantTopics: *tenantTopics,
+		partitions:   *partitions,
+		grace:        *grace,
+		drainDelay:   *drainDelay,
+		rollupWindow: *rollupWindow,
+		activityAddr: *activityAddr,
+		degradeSteps: *degradeSteps,
+	}
+	if err := settings.validate(); err != nil {
+		return err
+	}
+	if *refresh <= 0 && !*pollEvents {
+		log.Println("-refresh is 0 without -poll-events, new options are only picked up when the stream reconnects on its own")
+	}
+	tap.SetSize(*tapSize)
+
+	signalChan := make(chan os.Signal, 1)
+	// the admin API's top keeps the last errors logged
+	log.SetOutput(io.MultiWriter(os.Stderr, recentErrors))
+
+	c, err := voteCodec("votes")
+	if err != nil {
+		return err
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	dir := spoolDir
+	if *dryRun {
+		// the live streamer's spooled votes aren't drained into the log, and a dry run leaves nothing behind
+		if dir, err = ioutil.TempDir("", "tweetreader-dry-run"); err != nil {
+			return err
+		}
+		defer os.RemoveAll(dir)
+	}
+	sp, err := publish.OpenSpool(dir, spoolMaxBytes)
+	if err != nil {
+		return fmt.Errorf("failed to open spool: %v", err)
+	}
+	defer sp.Close()
+	gate := &publish.Gate{}
+	bus, err := openRunbook()
+	if err != nil {
+		return err
+	}
+	defer bus.Close()
+	if *sloEvery > 0 {
+		go reportSLO(bus, *sloEvery)
+	}
+
+	var outbox store.OutboxStore
+	if *outboxed {
+		var ok bool
+		if outbox, ok = db.(store.OutboxStore); !ok {
+			return fmt.Errorf("-outbox needs a store that keeps an outbox")
+		}
+	}
+	var pub publish.Publisher
+	nsqBreaker := newBreaker("nsq", 30*time.Second)
+	if *dryRun {
+		log.Println("Dry run: votes are logged, not published")
+		pub, nsqBreaker = publish.NewLog(), nil
+	} else if outbox != nil {
+		log.Println("Votes are added to the outbox, the relay publishes them")
+		pub, nsqBreaker = publish.NewOutbox(outbox, "votes"), newBreaker("outbox", 30*time.Second)
+	} else if pub, err = newPublisher("votes"); err != nil {
+		return fmt.Errorf("failed to create publisher: %v", err)
+	}
+	pub = faults.Publisher(pub)
+	var dead *deadletter.Sink
+	if !*dryRun {
+		var stop func()
+		if dead, stop, err = newDeadLetters("stream", "votes"); err != nil {
+			return err
+		}
+		defer stop()
+	}
+
+	var side []sidePublisher
+	keys := &pollKeys{}
+	if names := splitList(*publishTo); *dryRun && len(names) > 1 {
+		log.Println("Dry run: the votes are only logged, not published to", strings.Join(names, ", "))
+	} else if side, err = openSidePublishers(names, dir, keys); err != nil {
+		return err
+	}
+	for _, p := range side {
+		defer p.spool.Close()
+	}
+
+	host, _ := os.Hostname()
+	// tells this streamer from the others, on its votes and leases
+	instance := envString("INSTANCE_ID", fmt.Sprintf("%s-%d", host, os.Getpid()))
+	if !*dryRun {
+		ops, err := newPublisher(events.OpsTopic)
+		if err != nil {
+			return fmt.Errorf("failed to create the ops publisher: %v", err)
+		}
+		defer ops.Stop()
+		defer events.PublishOps(instance, ops.Publish)()
+	}
+	var shards *shard.Coordinator
+	if *sharded {
+		leases, ok := db.(store.LeaseStore)
+		if !ok {
+			return fmt.Errorf("-shard needs a store that keeps leases")
+		}
+		id := envString("SHARD_ID", instance)
+		shards = shard.New(leases, id, envDuration("SHARD_LEASE", 15*time.Second))
+	}
+	var elector *leader.Elector
+	if *elect {
+		var leaders store.LeaderStore
+		switch *leases {
+		case "store":
+			var ok bool
+			if leaders, ok = db.(store.LeaderStore); !ok {
+				return fmt.Errorf("-leader-election needs a store that keeps leases")
+			}
+		case "kubernetes":
+			if leaders, err = leader.InCluster(envString("LEADER_NAMESPACE", "")); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("invalid -leader-leases %q, want store or kubernetes", *leases)
+		}
+		id := envString("LEADER_ID", instance)
+		elector = leader.New(leaders, envString("LEADER_NAME", "stream"), id, envDuration("LEADER_LEASE", 10*time.Second))
+	}
+	private, hasher, err := newPrivacy()
+	if err != nil {
+		return err
+	}
+	var archiver *archive.Archiver
+	if *archiveURL != "" && *dryRun {
+		log.Println("Dry run: not archiving tweets")
+	} else if *archiveURL != "" {
+		if archiver, err = newArchiver(*archiveURL, host); err != nil {
+			return err
+		}
+	}
+	sampler := sampling.New()
+	// under pressure, the work the ladder sheds; it watches the votes buffer too once there is one
+	ladder, err := newLadder(bus, sp, *degradeSteps, *degradeMem, *degradeSpool)
+	if err != nil {
+		return err
+	}
+	pollFilters := expr.NewFilters()
+	optionVersions := versions.New()
+	guard := quarantine.New(quarantine.Config{Rate: *rateCap, Burst: *rateBurst, AutoAfter: *quarantineAt, Snapshots: db, Events: bus})
+	defaults, err := features.ParseDefaults(*featureFlags)
+	if err != nil {
+		return fmt.Errorf("invalid -features: %v", err)
+	}
+	if _, ok := defaults[features.ContentFilter]; !ok {
+		defaults[features.ContentFilter] = true
+	}
+	if _, ok := defaults[features.Moderation]; !ok {
+		defaults[features.Moderation] = *moderate
+	}
+	flags := features.New(features.Config{Defaults: defaults, Snapshots: db})
+	var rollups *rollup.Rollup
+	if *rollupWindow > 0 {
+		rollups = rollup.New(*rollupWindow, instance)
+	}
+	var hibernator *hibernate.Hibernator
+	if *hibernAfter > 0 {
+		hibernator = hibernate.New(hibernate.Config{Idle: *hibernAfter, WakeEvery: *wakeEvery, WakeFor: *wakeFor})
+	}
+	weigh, err := match.ParseWeighting(getenv("VOTE_WEIGHTING"))
+	if err != nil {
+		return fmt.Errorf("invalid VOTE_WEIGHTING: %v", err)
+	}
+	enricher := enrich.New(enrich.Config{
+		Weigh:       weigh,
+		TTL:         envDuration("ENRICH_TTL", time.Hour),
+		Size:        int(envInt64("ENRICH_CACHE_SIZE", 100000)),
+		Batch:       int(envInt64("ENRICH_BATCH", 100)),
+		Wait:        envDuration("ENRICH_WAIT", time.Second),
+		MinInterval: envDuration("ENRICH_MIN_INTERVAL", time.Second),
+		Off:         func() bool { return ladder.Active(degrade.Enrichment) },
+	})
+	var pipes []pipeline
+	silent, silenceAlerts, err := newWatcher(bus, *dryRun, *silentAfter, func() []string { return trackedOptions(pipes) })
+	if err != nil {
+		return err
+	}
+	if silenceAlerts != nil {
+		defer silenceAlerts.Stop()
+	}
+	retractions, retractionsPub, err := newRetractions(*dryRun, *retractFor)
+	if err != nil {
+		return err
+	}
+	if retractionsPub != nil {
+		defer retractionsPub.Stop()
+	}
+	var tagger *tenant.Tagger
+	if os.Getenv("MONGO_TENANTS") != "" {
+		perTenant, err := tenant.ParseQuotas(*quotas)
+		if err != nil {
+			return fmt.Errorf("invalid -tenant-quotas: %v", err)
+		}
+		tagger = tenant.New(tenant.Config{Quota: *tenantQuota, Quotas: perTenant})
+	}
+	var partitioner *partition.Partitioner
+	if *partitions > 0 {
+		partitioner = partition.New("votes", *partitions)
+	}
+	if *tenantTopics || partitioner != nil {
+		open := func(topic string) (publish.Publisher, error) {
+			p, err := newPublisher(topic)
+			if err != nil {
+				return nil, err
+			}
+			return p, nil
+		}
+		switch {
+		case *dryRun:
+			open = func(string) (publish.Publisher, error) { return publish.NewLog(), nil }
+		case outbox != nil:
+			open = func(topic string) (publish.Publisher, error) { return publish.NewOutbox(outbox, topic), nil }
+		}
+		opened := open
+		open = func(topic string) (publish.Publisher, error) {
+			p, err := opened(topic)
+			if err != nil {
+				return nil, err
+			}
+			return faults.Publisher(p), nil
+		}
+		if partitioner != nil {
+			pub = publish.NewRouter(pub, partitioner.Route, open)
+		} else {
+			pub = publish.NewRouter(pub, tenantRoute(tagger), open)
+		}
+	}
+	// pollsOf has every options load also read the polls once, for m to pick up how to match the options and for the loaders to pick up the private, sampled and quarantined polls, the feature flags,
+	// the filter expressions, the versions of the options, the siblings of the options, the tenants, partitions and polls of the options, the polls to archive for, the ones not rolled up and the ones enriched, then leave out the hibernating ones
+	pollsOf := func(polls *store.PollsSnapshot, m *match.Matcher, load func() ([]string, error)) func() ([]string, error) {
+		load = polls.Take(load)
+		load = pollMatching(polls, m, load)
+		load = guard.Options(polls, load)
+		load = flags.Options(polls, load)
+		load = private.Options(polls, load)
+		load = sampler.Options(polls, load)
+		load = ladder.Options(polls, load)
+		load = pollFilters.Options(polls, load)
+		load = optionVersions.Options(polls, load)
+		load = silent.Options(polls, load)
+		if tagger != nil {
+			load = tagger.Options(polls, load)
+		}
+		if partitioner != nil {
+			load = partitioner.Options(polls, load)
+		}
+		if len(side) > 0 {
+			load = keys.Options(polls, load)
+		}
+		if archiver != nil {
+			load = archiver.Options(polls, load)
+		}
+		if rollups != nil {
+			load = rollups.Options(polls, load)
+		}
+		load = enricher.Options(polls, load)
+		if hibernator != nil {
+			// last, the others still see the options of the hibernating polls
+			load = hibernator.Options(polls, load)
+		}
+		return load
+	}
+	// shardOf narrows the options loaded by load down to this streamer's shard
+	shardOf := func(load func() ([]string, error)) func() ([]string, error) {
+		if shards == nil {
+			return load
+		}
+		return shards.Options(load)
+	}
+
+	var compliance func(stream.Compliance)
+	if retractions != nil {
+		compliance = retractions.Handle
+	}
+	opened, err := openSources(sourceFlags{
+		source:       *sourceName,
+		videos:       *videos,
+		channels:     *channels,
+		chats:        *chats,
+		feeds:        *feeds,
+		synthRate:    *synthRate,
+		synthMulti:   *synthMulti,
+		synthDist:    *synthDist,
+		trackLimit:   *trackLimit,
+		smsAddr:      *smsAddr,
+		activityAddr: *activityAddr,
+		dryRun:       *dryRun,
+	}, db, pollsOf, shardOf, compliance, bus)
+	if err != nil {
+		return err
+	}
+	for _, s := range opened.servers {
+		defer s.Close()
+	}
+	pipes = opened.pipes
+	var src sources
+	for _, p := range pipes {
+		src = append(src, p.src)
+	}
+	filter, err := loadContentFilter(*blockedFile, *redactedFile, nil)
+	if err != nil {
+		return err
+	}
+	var announce publisherFunc
+	if !*dryRun {
+		events, err := newPublisher("poll_events")
+		if err != nil {
+			return fmt.Errorf("failed to create the poll_events publisher: %v", err)
+		}
+		defer events.Stop()
+		announce = events.Publish
+	}
+	ready := &shutdown.Readiness{Degraded: budget.Degraded, Notes: func() string {
+		if st := ladder.Status(); st.Level > 0 {
+			return fmt.Sprintf("degraded to level %d: %s", st.Level, strings.Join(st.Steps, ", "))
+		}
+		return ""
+	}}
+	admin, err := startAdmin(gate, sp, src, db, announce, guard, silent, flags, pollFilters, ladder, ready)
+	if err != nil {
+		return err
+	}
+	go handleDaemonSignals(pipes, func() {
+		opened.updateCredentials()
+		if key := secret("PRIVACY_KEY"); key != "" {
+			hasher.SetSecret(key)
+		}
+		if filter != nil {
+			if _, err := loadContentFilter(*blockedFile, *redactedFile, filter); err != nil {
+				log.Println("failed to reload the content filter terms, keeping the last ones:", err)
+			}
+		}
+	})
+	bus.Handle(events.ActionPauseStream, func(step events.Step, e events.Event) error {
+		src.Pause(time.Duration(step.For))
+		return nil
+	})
+	bus.Handle(events.ActionPausePublisher, func(step events.Step, e events.Event) error {
+		d := time.Duration(step.For)
+		if d > maxPause {
+			d = maxPause
+		}
+		until := gate.Pause(d)
+		log.Println("Publisher: paused until", until)
+		return nil
+	})
+	// rather than failing for every vote, ingestion stops until the pause is over and tries again
+	budget.Configure(budget.Config{Threshold: *errBudget, Pause: *budgetPause, LogsPerMinute: *logBudget}, func(st budget.Status) {
+		src.Pause(time.Until(st.ExhaustedUntil))
+		bus.Emit(events.BudgetExhausted, fmt.Sprintf("%s: %d of %d attempts failed", st.Component, st.Failures, st.Attempts))
+	})
+	publish.SetLatencyBudget(*latBudget, func(p95 time.Duration) {
+		log.Printf("Publisher: votes are published %s after their tweets at the 95th percentile, over the %s budget", p95, *latBudget)
+		bus.Emit(events.VoteLatency, fmt.Sprintf("p95 %s over the %s budget", p95, *latBudget))
+	})
+
+	shutdown.Notify(signalChan)
+	// a standby is ready too, it has its admin API up
+	sdnotify.Notify(sdnotify.Ready)
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go sdnotify.Watch(streamHealth(*stall, nsqBreaker), stopWatchdog)
+
+	// a standby only serves its admin API until it is elected
+	var lost <-chan struct{}
+	if elector != nil {
+		elector.Start()
+		log.Println("Standing by until elected leader...")
+		sdnotify.Notify(sdnotify.Status("standing by"))
+		select {
+		case <-elector.Elected():
+		case <-signalChan:
+			log.Println("Stopping...")
+			sdnotify.Notify(sdnotify.Stopping)
+			ready.Drain(0) // nothing to drain while standing by
+			elector.Stop()
+			ctx, cancel := context.WithTimeout(context.Background(), apiTimeout)
+			defer cancel()
+			return admin.Shutdown(ctx)
+		}
+		lost = elector.Lost()
+	}
+
+	// start things
+	// every vote matched counts as its option matching, dropped later or not
+	stages := []Stage{silent.Run}
+	if filter != nil {
+		// before anything else, dropped votes and the original text go no further
+		stages = append(stages, func(in <-chan match.Vote) <-chan match.Vote {
+			return flags.Run(features.ContentFilter, in, filter.Apply)
+		})
+	}
+	if opened.lookup != nil {
+		// before the filters and the moderation, which look at the authors
+		stages = append(stages, func(in <-chan match.Vote) <-chan match.Vote { return enricher.Run(in, opened.lookup) })
+	}
+	// while the votes have their text, the polls whose filter a tweet fails don't count it
+	stages = append(stages, pollFilters.Run)
+	// and every vote says which version of its polls' options it was matched against
+	stages = append(stages, optionVersions.Run)
+	if tagger != nil {
+		stages = append(stages, tagger.Run)
+	}
+	if *spikes {
+		detector, alerts, err := newDetector(bus, *dryRun)
+		if err != nil {
+			return err
+		}
+		if alerts != nil {
+			defer alerts.Stop()
+		}
+		stages = append(stages, detector.Run)
+	}
+	if hibernator != nil {
+		// every vote matched keeps its poll awake, capped or not
+		stages = append(stages, hibernator.Run)
+	}
+	// after the detector, which needs the whole rate
+	stages = append(stages, guard.Run, sampler.Run, ladder.Run)
+	if retractions != nil {
+		// before anonymizing drops the authors' IDs
+		stages = append(stages, retractions.Run)
+	}
+	stages = append(stages, private.Run)
+	if *auditShare > 0 {
+		// after anonymizing, the samples of private polls don't identify their authors either
+		auditor, samples, err := newAuditor(*dryRun, *auditShare)
+		if err != nil {
+			return err
+		}
+		if samples != nil {
+			defer samples.Stop()
+		}
+		stages = append(stages, auditor.Run)
+	}
+	// after anonymizing, so reviewers see what would be counted; set up even
+	// without -moderate, the moderation flag may turn it on for some polls
+	moderator, pending, err := newModerator(*dryRun)
+	if err != nil {
+		return err
+	}
+	if pending != nil {
+		defer pending.Stop()
+	}
+	stages = append(stages, func(in <-chan match.Vote) <-chan match.Vote {
+		return flags.Run(features.Moderation, in, moderator.Keep)
+	})
+	var signals *nsq.Consumer
+	if *backPressure {
+		throttle := control.NewThrottle()
+		signals, err = watchControl(*lookupd, throttle)
+		if err != nil {
+			return fmt.Errorf("failed to watch the control topic: %v", err)
+		}
+		stages = append(stages, throttle.Run)
+	}
+	// what top shows, the votes about to be published, and what /debug/recent shows, the last of them
+	stages = append(stages, topVotes.Run, tapVotes)
+	if rollups != nil {
+		// last before publishing, every stage before sees the votes of the tweets
+		stages = append(stages, rollups.Run)
+	}
+	stages = append(stages, func(in <-chan match.Vote) <-chan match.Vote { return stampInstance(in, instance) })
+	if *queueSize > 0 {
+		q, err := publish.NewQueue(*queueSize, *overflow)
+		if err != nil {
+			return err
+		}
+		stages = append(stages, q.Run)
+		if *degradeQueue > 0 {
+			ladder.Watch(degrade.Source{Name: "buffer", Pressure: func() float64 { return q.Fill() / *degradeQueue }})
+		}
+	}
+	stopLadder := make(chan struct{})
+	defer close(stopLadder)
+	ladder.Start(stopLadder)
+	// both can be changed through the admin API while streaming
+	refreshes := clock.NewInterval("refresh", *refresh, pollEventsGap)
+	checkpointEvery := clock.NewInterval("checkpoint", *checkpointIn, time.Second)
+	opts := []Option{
+		WithStore(db),
+		WithPublisher(pub, sp, nsqBreaker),
+		WithStages(stages...),
+		WithRefresh(refreshes),
+		WithGate(gate),
+		WithCodec(c),
+		WithDeadLetters(dead),
+		WithBuffer(*queueSize),
+		WithArchiver(archiver),
+		WithWorkers(*workers),
+	}
+	for _, p := range side {
+		opts = append(opts, WithPublisher(p.pub, p.spool, p.breaker))
+	}
+	for _, p := range pipes {
+		opts = append(opts, WithSource(p))
+	}
+	if !*dryRun {
+		opts = append(opts, WithCheckpoints(checkpointEvery))
+	}
+	streamer, err := NewStreamer(opts...)
+	if err != nil {
+		return err
+	}
+	if shards != nil {
+		// join before connecting, so the first connection already tracks just this streamer's share
+		shards.Start(func() { streamer.Reload("Streamers changed") })
+	}
+	// once elected, a standby goes on from where the last leader saved
+	streamer.Start()
+	stopWatching := make(chan struct{})
+	defer close(stopWatching)
+	go silent.Watch(stopWatching)
+	var changes *nsq.Consumer
+	if *pollEvents {
+		if changes, err = watchPollEvents(*lookupd, streamer.Reload); err != nil {
+			return fmt.Errorf("failed to watch the poll_events topic: %v", err)
+		}
+	}
+
+	sdnotify.Notify(sdnotify.Status("streaming"))
+	var leadershipLost bool
+	select {
+	case <-signalChan:
+		log.Println("Stopping...")
+	case <-lost:
+		log.Println("Stopping, no longer the leader...")
+		leadershipLost = true
+	}
+	sdnotify.Notify(sdnotify.Stopping)
+	ready.Drain(*drainDelay)
+	// stop upstream first: no more tweets come in, the matcher finishes what
+	// it has and the publisher flushes it, then the admin API goes
+	var sd shutdown.Coordinator
+	if *grace > 0 {
+		sd.Grace = *grace - *drainDelay
+	}
+	sd.Add("sources", sourcesTimeout, func(ctx context.Context) error {
+		if shards != nil {
+			shards.Stop()
+		}
+		if changes != nil {
+			changes.Stop()
+		}
+		for _, s := range opened.servers {
+			// no more votes by the webhooks, and the ones being counted are answered
+			s.Shutdown(ctx)
+		}
+		err := streamer.StopSources(ctx)
+		// only once disconnected, or the next leader's stream could be refused as a duplicate
+		if elector != nil {
+			elector.Stop()
+		}
+		return err
+	})
+	sd.Chain("matcher", matcherTimeout, streamer.StopMatchers)
+	sd.Chain("publisher", publisherTimeout, shutdown.Wait(streamer.Published()))
+	if !*dryRun {
+		sd.Chain("checkpoints", apiTimeout, func(context.Context) error {
+			streamer.SaveCheckpoints()
+			return nil
+		})
+	}
+	if archiver != nil {
+		sd.Add("archive", archiveTimeout, func(ctx context.Context) error {
+			select {
+			case <-streamer.Matched():
+			default:
+				return shutdown.ErrSkipped // a matcher may still be adding tweets
+			}
+			stopped := make(chan struct{})
+			go func() {
+				defer close(stopped)
+				archiver.Stop()
+			}()
+			return shutdown.Wait(stopped)(ctx)
+		})
+	}
+	if signals != nil {
+		sd.Add("control", apiTimeout, func(ctx context.Context) error {
+			signals.Stop()
+			select {
+			case <-signals.StopChan:
+				return nil
+			case <-ctx.Done():
+				return shutdown.ErrTimeout
+			}
+		})
+	}
+	sd.Add("api", apiTimeout, admin.Shutdown)
+	sd.Shutdown().Log()
+	if leadershipLost {
+		return fmt.Errorf("lost the leadership, restart to stand by again")
+	}
+	return nil
+}
+
+// sourceFlags are the stream command's flags picking the sources and configuring them
+type sourceFlags struct {
+	source                         string
+	videos, channels, chats, feeds string
+	synthRate, synthMulti          float64
+	synthDist                      string
+	trackLimit                     int
+	smsAddr, activityAddr          string
+	dryRun                         bool
+}
+
+// openedSources are the pipelines of the sources openSources opened, and what else they need
+type openedSources struct {
+	pipes []pipeline
+	// lookup looks up the authors' profiles with the first account's credentials, only when streaming Twitter
+	lookup enrich.Lookup
+	// updateCredentials has the streams reconnect with the rotated credentials
+	updateCredentials func()
+	// servers are the webhooks, serving already, shut down once the votes stop
+	servers []*http.Server
+}
+
+// openSources opens the sources picked by f. The options of each are loaded
+// from db through pollsOf, with a snapshot of the polls of its own, and then
+// shardOf, but for the webhooks' which take the votes for every option;
+// compliance handles the Twitter streams' compliance messages, it may be nil.
+func openSources(f sourceFlags, db store.Backend, pollsOf func(*store.PollsSnapshot, *match.Matcher, func() ([]string, error)) func() ([]string, error), shardOf func(func() ([]string, error)) func() ([]string, error), compliance func(stream.Compliance), bus *events.Bus) (o openedSources, err error) {
+	defer func() {
+		if err != nil {
+			for _, s := range o.servers {
+				s.Close()
+			}
+		}
+	}()
+	// every account's stream feeds a matcher of its own, which only knows that account's options
+	o.updateCredentials = func() {}
+	switch f.source {
+	case "twitter", "twitter-search":
+		// without access to the streaming API the search API is polled instead
+		searching := f.source == "twitter-search"
+		accounts, err := twitterAccounts()
+		if err != nil {
+			return o, err
+		}
+		known := make(map[string]bool, len(accounts))
+		for _, a := range accounts {
+			known[a] = true
+		}
+		var twitters []*stream.Stream
+		for _, a := range accounts {
+			matcher, err := newMatcher()
+			if err != nil {
+				return o, err
+			}
+			// if MongoDB is unavailable keep streaming with the last options we loaded
+			options := store.NewOptionsCache(accountStore{db: db, account: a, known: known})
+			polls := store.NewPollsSnapshot(db)
+			load, onConnect := shardOf(pollsOf(polls, matcher, options.Refresh)), matcher.Update
+			var searched func() []string
+			if f.trackLimit > 0 && !searching {
+				// after sharding, each stream has a limit of its own
+				tier := tiers.New(accountLabel(a), f.trackLimit)
+				load, searched = tier.Options(polls, load), tier.Searched
+				onConnect = func(tracked []string) { matcher.Update(append(tracked, tier.Searched()...)) }
+			}
+			twitter, err := newTwitter(a, load, onConnect, searched, pollLocations(db, a), bus, compliance)
+			if err != nil {
+				return o, err
+			}
+			twitters = append(twitters, twitter)
+			var src tweetSource = twitter
+			if searching {
+				since, saveSince := searchSince(db, a)
+				if f.dryRun {
+					// the live searchers go on from where they left off
+					saveSince = nil
+				}
+				src = stream.NewSearcher(twitter, stream.SearcherConfig{
+					Options:     load,
+					OnConnect:   onConnect,
+					Interval:    envDuration("TWITTER_SEARCH_INTERVAL", time.Minute),
+					SinceID:     since,
+					SaveSinceID: saveSince,
+				})
+			}
+			o.pipes = append(o.pipes, newPipeline(stream.SourceTwitter, accountLabel(a), src, matcher))
+		}
+		if len(accounts) > 1 || accounts[0] != "" {
+			log.Printf("Streaming for %d Twitter accounts", len(accounts))
+		}
+		// rotated credentials take effect on the next request, the streams reconnect with them
+		o.updateCredentials = func() {
+			for i, twitter := range twitters {
+				twitter.SetCredentials(accountCredentials(accounts[i]))
+			}
+		}
+		watchSecrets(o.updateCredentials)
+		o.lookup = twitters[0].LookupProfiles
+	case "synthetic":
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		synthetic, err := stream.NewSynthetic(stream.SyntheticConfig{
+			Options:      shardOf(pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh)),
+			OnConnect:    matcher.Update,
+			Rate:         f.synthRate,
+			Distribution: f.synthDist,
+			Multi:        f.synthMulti,
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceSynthetic, stream.SourceSynthetic, synthetic, matcher))
+	case "youtube":
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		youtube, err := stream.NewYouTube(stream.YouTubeConfig{
+			URL:           envString("YOUTUBE_API_URL", stream.YouTubeURL),
+			APIKey:        secret("YOUTUBE_API_KEY"),
+			Videos:        splitList(f.videos),
+			Options:       shardOf(pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh)),
+			OnConnect:     matcher.Update,
+			MinInterval:   envDuration("YOUTUBE_MIN_INTERVAL", time.Second),
+			RetryInterval: envDuration("YOUTUBE_RETRY_INTERVAL", 30*time.Second),
+			Transport: stream.TransportConfig{
+				DialTimeout:   envDuration("YOUTUBE_DIAL_TIMEOUT", 10*time.Second),
+				SearchTimeout: envDuration("YOUTUBE_REQUEST_TIMEOUT", 30*time.Second),
+			},
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceYouTube, stream.SourceYouTube, youtube, matcher))
+	case "twitch":
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		t, err := tlsConfig("TWITCH")
+		if err != nil {
+			return o, err
+		}
+		twitch, err := stream.NewTwitch(stream.TwitchConfig{
+			Addr:      envString("TWITCH_ADDR", stream.TwitchAddr),
+			Plain:     envBool("TWITCH_PLAIN", false),
+			Nick:      envString("TWITCH_NICK", ""),
+			Token:     secret("TWITCH_TOKEN"),
+			Channels:  splitList(f.channels),
+			Options:   shardOf(pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh)),
+			OnConnect: matcher.Update,
+			Transport: stream.TransportConfig{
+				TLS:          t,
+				DialTimeout:  envDuration("TWITCH_DIAL_TIMEOUT", 10*time.Second),
+				StallTimeout: envDuration("TWITCH_STALL_TIMEOUT", 6*time.Minute),
+				WrapConn:     faults.WrapConn(chaos.Stream),
+			},
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceTwitch, stream.SourceTwitch, twitch, matcher))
+	case "telegram":
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		telegram, err := stream.NewTelegram(stream.TelegramConfig{
+			URL:         envString("TELEGRAM_API_URL", stream.TelegramURL),
+			Token:       secret("TELEGRAM_BOT_TOKEN"),
+			Chats:       splitList(f.chats),
+			Options:     shardOf(pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh)),
+			OnConnect:   matcher.Update,
+			PollTimeout: envDuration("TELEGRAM_POLL_TIMEOUT", 50*time.Second),
+			Transport: stream.TransportConfig{
+				DialTimeout:   envDuration("TELEGRAM_DIAL_TIMEOUT", 10*time.Second),
+				SearchTimeout: envDuration("TELEGRAM_REQUEST_TIMEOUT", 30*time.Second),
+			},
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceTelegram, stream.SourceTelegram, telegram, matcher))
+	case "feeds":
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		feed, err := stream.NewFeed(stream.FeedConfig{
+			URLs:      splitList(f.feeds),
+			Options:   shardOf(pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh)),
+			OnConnect: matcher.Update,
+			Interval:  envDuration("FEED_INTERVAL", 5*time.Minute),
+			Backfill:  envDuration("FEED_BACKFILL", 24*time.Hour),
+			Transport: stream.TransportConfig{
+				DialTimeout:   envDuration("FEED_DIAL_TIMEOUT", 10*time.Second),
+				SearchTimeout: envDuration("FEED_TIMEOUT", 30*time.Second),
+			},
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceFeeds, stream.SourceFeeds, feed, matcher))
+	case "activity":
+		// the Account Activity webhook below is the only source
+	default:
+		return o, fmt.Errorf("invalid -source %q, want %s", f.source, strings.Join(sourceNames, ", "))
+	}
+	if f.smsAddr != "" {
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		vote, err := smsVote(db)
+		if err != nil {
+			return o, err
+		}
+		// every streamer takes votes for every option, Twilio may post to any of them
+		sms, err := stream.NewSMS(stream.SMSConfig{
+			AuthToken: secret("TWILIO_AUTH_TOKEN"),
+			URL:       envString("SMS_WEBHOOK_URL", ""),
+			Options:   pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh),
+			OnConnect: matcher.Update,
+			Vote:      vote,
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceSMS, stream.SourceSMS, sms, matcher))
+		mux := http.NewServeMux()
+		mux.Handle("/sms", sms)
+		webhook := &http.Server{Addr: f.smsAddr, Handler: mux}
+		o.servers = append(o.servers, webhook)
+		go func() {
+			if err := webhook.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("SMS webhook stopped:", err)
+			}
+		}()
+	}
+	if f.activityAddr != "" {
+		matcher, err := newMatcher()
+		if err != nil {
+			return o, err
+		}
+		// like SMS, every streamer takes the tweets for every option
+		activity, err := stream.NewActivity(stream.ActivityConfig{
+			ConsumerSecret: twitterCredentials().ConsumerSecret,
+			Options:        pollsOf(store.NewPollsSnapshot(db), matcher, store.NewOptionsCache(db).Refresh),
+			OnConnect:      matcher.Update,
+		})
+		if err != nil {
+			return o, err
+		}
+		o.pipes = append(o.pipes, newPipeline(stream.SourceTwitter, stream.SourceActivity, activity, matcher))
+		mux := http.NewServeMux()
+		mux.Handle("/webhooks/twitter", activity)
+		hook := &http.Server{Addr: f.activityAddr, Handler: mux}
+		o.servers = append(o.servers, hook)
+		go func() {
+			if err := hook.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Println("Account Activity webhook stopped:", err)
+			}
+		}()
+	}
+	return o, nil
+}
+
+// Shutdown stage timeouts, sources get long enough for a reconnect to give up dialing
+const (
+	sourcesTimeout   = 15 * time.Second
+	matcherTimeout   = 5 * time.Second
+	publisherTimeout = 30 * time.Second // the spool may still be replaying
+	archiveTimeout   = time.Minute      // the last batches are uploaded
+	apiTimeout       = 5 * time.Second
+)
+
+// newBreaker creates the circuit breaker for a dependency, configured by
+// BREAKER_THRESHOLD (failures in a row, 5 by default, 0 to disable) and
+// <NAME>_BREAKER_COOLDOWN
+func newBreaker(name string, cooldown time.Duration) *breaker.Breaker {
+	return breaker.New(name, breaker.Config{
+		Threshold: int(envInt64("BREAKER_THRESHOLD", 5)),
+		Cooldown:  envDuration(strings.ToUpper(name)+"_BREAKER_COOLDOWN", cooldown),
+	})
+}
+
+// tweetSource is where runStream's tweets come from: a stream.Stream, a stream.YouTube, a stream.Twitch, a stream.Telegram, a stream.Feed or a stream.Synthetic
+type tweetSource interface {
+	Start(stopchan <-chan struct{}, tweets chan<- stream.Tweet) <-chan struct{}
+	Reconnect()
+	Pause(d time.Duration)
+	Resume()
+}
+
+// loadContentFilter reads the blocked and redacted terms files into filter, or
+// a new filter if it is nil. Without either file there is no filter and it
+// returns nil.
+func loadContentFilter(blockedFile, redactedFile string, filter *content.Filter) (*content.Filter, error) {
+	if blockedFile == "" && redactedFile == "" {
+		return nil, nil
+	}
+	blocked, err := content.ReadTerms(blockedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -blocked-terms: %v", err)
+	}
+	redacted, err := content.ReadTerms(redactedFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read -redacted-terms: %v", err)
+	}
+	log.Printf("Content filter: %d blocked terms, %d redacted terms", len(blocked), len(redacted))
+	if filter == nil {
+		return content.New(blocked, redacted), nil
+	}
+	filter.Update(blocked, redacted)
+	return filter, nil
+}
+
+// pipeline is a source with the matcher turning its tweets into votes
+type pipeline struct {
+	source  string // stamped on its votes, see the stream.Source constants
+	name    string // the account, or the source
+	src     tweetSource
+	matcher *match.Matcher
+	stop    chan struct{}
+	tweets  chan stream.Tweet
+}
+
+func newPipeline(source, name string, src tweetSource, m *match.Matcher) pipeline {
+	return pipeline{source: source, name: name, src: src, matcher: m, stop: make(chan struct{}, 1), tweets: make(chan stream.Tweet)}
+}
+
+// match runs the matcher on workers goroutines until the tweets channel is closed, the returned channel is closed then.
+// The pipelines share votes, so it is left open for the caller to close once they all stopped.
+// The tweets that match are handed to archiver too, unless it is nil. However many workers
+// match them, the votes are sent in the order their tweets were read.
+func (p pipeline) match(votes chan<- match.Vote, archiver *archive.Archiver, workers int) <-chan struct{} {
+	stopped := make(chan struct{})
+	if workers <= 1 {
+		go func() {
+			defer close(stopped)
+			for t := range p.tweets {
+				for _, v := range p.votesOf(t, archiver) {
+					votes <- v
+				}
+			}
+		}()
+		return stopped
+	}
+	// every tweet's votes come back on a channel of their own, queued in the
+	// order of the tweets; at most workers tweets wait to be sent
+	queued := make(chan chan []match.Vote, workers)
+	jobs := make(chan matchJob)
+	go func() {
+		defer close(queued)
+		defer close(jobs)
+		for t := range p.tweets {
+			done := make(chan []match.Vote, 1)
+			queued <- done
+			jobs <- matchJob{tweet: t, done: done}
+		}
+	}()
+	for i := 0; i < workers; i++ {
+		go func() {
+			for j := range jobs {
+				j.done <- p.votesOf(j.tweet, archiver)
+			}
+		}()
+	}
+	go func() {
+		defer close(stopped)
+		for done := range queued {
+			for _, v := range <-done {
+				votes <- v
+			}
+		}
+	}()
+	return stopped
+}
+
+// matchJob is a tweet for a worker to match, and where its votes go
+type matchJob struct {
+	tweet stream.Tweet
+	done  chan<- []match.Vote
+}
+
+// votesOf matches t, archiving it when it matches
+func (p pipeline) votesOf(t stream.Tweet, archiver *archive.Archiver) []match.Vote {
+	var raw []byte
+	if archiver != nil {
+		// before matching, which expands the retweeted and quoted tweets in place
+		raw, _ = json.Marshal(t)
+	}
+	matched := p.matcher.Match(t)
+	if raw != nil && len(matched) > 0 {
+		options := make([]string, len(matched))
+		for i, v := range matched {
+			options[i] = v.Option
+		}
+		archiver.Add(raw, options)
+	}
+	for i := range matched {
+		matched[i].Source = p.source
+	}
+	return matched
+}
+
+// handleDaemonSignals follows the usual daemon conventions: SIGHUP reloads the
+// configuration and reconnects with freshly loaded options, SIGUSR1 logs the
+// metrics and the terms every pipeline tracks. reload is called on SIGHUP before
+// the streams reconnect, to pick up what else changed.
+func handleDaemonSignals(pipes []pipeline, reload func()) {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, daemonSignals...)
+	for sig := range signals {
+		switch sig {
+		case syscall.SIGHUP:
+			log.Println("SIGHUP: reloading the configuration and options")
+			if err := reloadConfig(); err != nil {
+				log.Println("failed to reload CONFIG_FILE, keeping the last configuration:", err)
+			}
+			refreshSecrets()
+			reload()
+			for _, p := range pipes {
+				p.src.Reconnect()
+			}
+		default: // SIGUSR1
+			for _, line := range metrics.Dump() {
+				log.Println("stats:", line)
+			}
+			for _, p := range pipes {
+				options := p.matcher.Options()
+				log.Printf("tracked (%s): %d terms: %s", p.name, len(options), strings.Join(options, ", "))
+			}
+		}
+	}
+}
+
+// dialStore connects to the store picked by STORE, retrying MongoDB while it starts up
+func dialStore() (store.Backend, error) {
+	kind, addr, opts, err := storeConfig()
+	if err != nil {
+		return nil, err
+	}
+	return store.Open(kind, addr, opts)
+}
+
+// storeConfig returns the kind and address of the store picked by STORE and
+// the options it is dialled with
+func storeConfig() (kind, addr string, opts store.DialOptions, err error) {
+	kind, addr = envString("STORE", "mongo"), secret("DBHOST")
+	switch kind {
+	case "mongo":
+		if addr == "" {
+			addr = "localhost"
+		}
+	case "postgres":
+		addr = secret("POSTGRES_URL")
+	case "sqlite":
+		addr = envString("SQLITE_PATH", "twitter-poll.db")
+	}
+	t, err := tlsConfig("MONGO")
+	if err != nil {
+		return "", "", opts, err
+	}
+	tenants, err := store.ParseTenants(os.Getenv("MONGO_TENANTS"))
+	if err != nil {
+		return "", "", opts, err
+	}
+	return kind, addr, store.DialOptions{
+		Timeout:    envDuration("DB_DIAL_TIMEOUT", 2*time.Minute),
+		Backoff:    envDuration("DB_DIAL_BACKOFF", 1*time.Second),
+		MaxBackoff: envDuration("DB_DIAL_MAX_BACKOFF", 30*time.Second),
+		TLS:        t,
+		Username:   secret("MONGO_USERNAME"),
+		Password:   secret("MONGO_PASSWORD"),
+		Database:   envString("MONGO_DATABASE", store.DefaultDatabase),
+		Polls:      envString("MONGO_POLLS_COLLECTION", store.DefaultPolls),
+		Tenants:    tenants,
+		WrapConn:   faults.WrapConn(chaos.Mongo),
+	}, nil
+}
+
+// watchControl applies the counter's control signals to throttle.
+// Every streamer has to see every signal, so each listens on its own ephemeral channel.
+func watchControl(lookupdAddr string, throttle *control.Throttle) (*nsq.Consumer, error) {
+	cfg, err := nsqConfig()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	q, err := nsq.NewConsumer(control.Topic, fmt.Sprintf("stream-%s-%d#ephemeral", host, os.Getpid()), cfg)
+	if err != nil {
+		return nil, err
+	}
+	q.AddHandler(throttle)
+	if err := q.ConnectToNSQLookupd(lookupdAddr); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// pollLocations returns the areas of every active poll of account that has some,
+// falling back to the last ones loaded when the store is unavailable
+func pollLocations(db store.PollStore, account string) func() []stream.BoundingBox {
+	var last []stream.BoundingBox
+	return func() []stream.BoundingBox {
+		polls, err := db.Polls()
+		if err != nil {
+			log.Println("failed to load poll locations, using the last ones:", err)
+			return last
+		}
+		last = nil
+		for _, p := range polls {
+			if p.Account != account || !p.Tracked() {
+				continue
+			}
+			last = append(last, p.Locations...)
+		}
+		return last
+	}
+}
+
+// voteCodec returns the codec votes are published to topic with, picked by
+// VOTE_CODEC_<TOPIC>, e.g. VOTE_CODEC_VOTES_REPLAY, or else by VOTE_CODEC,
+// compressed as VOTE_COMPRESSION_<TOPIC> or VOTE_COMPRESSION say and encrypted
+// as VOTE_ENCRYPTION_<TOPIC> or VOTE_ENCRYPTION say
+func voteCodec(topic string) (codec.Codec, error) {
+	suffix := "_" + strings.NewReplacer("-", "_", ".", "_").Replace(strings.ToUpper(topic))
+	c, err := codec.Lookup(envString("VOTE_CODEC"+suffix, envString("VOTE_CODEC", "json")))
+	if err != nil {
+		return nil, fmt.Errorf("invalid VOTE_CODEC%s or VOTE_CODEC: %v", suffix, err)
+	}
+	// publishers find out now rather than with the first vote if the registry can't be reached
+	if a, ok := c.(*codec.Avro); ok {
+		if err := a.RegisterSchema(); err != nil {
+			return nil, err
+		}
+	}
+	if algo := envString("VOTE_COMPRESSION"+suffix, envString("VOTE_COMPRESSION", "")); algo != "" {
+		if c, err = codec.Compress(c, algo); err != nil {
+			return nil, fmt.Errorf("invalid VOTE_COMPRESSION%s or VOTE_COMPRESSION: %v", suffix, err)
+		}
+	}
+	// after compressing, ciphertext doesn't compress
+	if algo := envString("VOTE_ENCRYPTION"+suffix, envString("VOTE_ENCRYPTION", "")); algo != "" {
+		if c, err = codec.Encrypt(c, algo); err != nil {
+			return nil, fmt.Errorf("invalid VOTE_ENCRYPTION%s or VOTE_ENCRYPTION: %v", suffix, err)
+		}
+	}
+	return c, nil
+}
+
+// registerCodecs makes the codecs that need configuring available, the avro
+// codec when SCHEMA_REGISTRY_URL points at a Confluent schema registry, and
+// sets the keys of the encrypted votes from VOTE_ENCRYPTION_KEYS
+func registerCodecs() error {
+	if keys := secret("VOTE_ENCRYPTION_KEYS"); keys != "" {
+		k, err := codec.ParseKeyring(keys)
+		if err != nil {
+			return fmt.Errorf("invalid VOTE_ENCRYPTION_KEYS: %v", err)
+		}
+		voteKeys = k
+		codec.SetKeyring(k)
+	}
+	url := getenv("SCHEMA_REGISTRY_URL")
+	if url == "" {
+		return nil
+	}
+	subject := envString("SCHEMA_REGISTRY_SUBJECT", "votes-value")
+	codec.Register(codec.NewAvro(codec.NewRegistry(url, subject, secret("SCHEMA_REGISTRY_USER"), secret("SCHEMA_REGISTRY_PASSWORD"))))
+	return nil
+}
+
+// voteKeys are the keys of the encrypted votes, nil when VOTE_ENCRYPTION_KEYS isn't set
+var voteKeys *codec.Keyring
+
+// updateVoteKeys sets the keys of the encrypted votes again from the secrets,
+// the last ones are kept when they are gone or invalid
+func updateVoteKeys() {
+	keys := secret("VOTE_ENCRYPTION_KEYS")
+	if voteKeys == nil || keys == "" {
+		return
+	}
+	if err := voteKeys.Update(keys); err != nil {
+		log.Println("keeping the last vote encryption keys:", err)
+		return
+	}
+	log.Printf("Votes are encrypted with key %s", voteKeys.Current())
+}
+
+// newMatcher creates the matcher with the weighting configured in VOTE_WEIGHTING,
+// also matching retweeted and quoted tweets when MATCH_EMBEDDED is set,
+// letting MATCH_PHRASE_GAP words stand between the words of phrase options
+// and normalizing texts with the Go plugin at MATCH_PLUGIN. With SHADOW_MATCH
+// set it has a shadow matcher, configured by the same settings prefixed with
+// SHADOW_ when they are set, whose disagreements are counted.
+func newMatcher() (*match.Matcher, error) {
+	m, err := configureMatcher("")
+	if err != nil {
+		return nil, err
+	}
+	if !envBool("SHADOW_MATCH", false) {
+		return m, nil
+	}
+	// the shadow is configured as the primary but for the SHADOW_ settings
+	s, err := configureMatcher("SHADOW_")
+	if err != nil {
+		return nil, err
+	}
+	c := shadow.New(shadow.Config{
+		Exact:         envBool("SHADOW_MATCH_EXACT", false),
+		CaseSensitive: envBool("SHADOW_MATCH_CASE_SENSITIVE", false),
+		LogEvery:      envDuration("SHADOW_LOG_EVERY", time.Minute),
+	})
+	m.Shadow(s, c.Compare)
+	return m, nil
+}
+
+// configureMatcher creates a matcher configured by the settings newMatcher
+// reads, each taken from prefix and the setting's name first when set
+func configureMatcher(prefix string) (*match.Matcher, error) {
+	setting := func(key string) string { return envString(prefix+key, getenv(key)) }
+	weigh, err := match.ParseWeighting(setting("VOTE_WEIGHTING"))
+	if err != nil {
+		return nil, fmt.Errorf("invalid %sVOTE_WEIGHTING: %v", prefix, err)
+	}
+	gap := envInt64(prefix+"MATCH_PHRASE_GAP", envInt64("MATCH_PHRASE_GAP", 0))
+	if gap < 0 || gap > textnorm.MaxPhraseGap {
+		return nil, fmt.Errorf("invalid %sMATCH_PHRASE_GAP %d, want 0 to %d", prefix, gap, textnorm.MaxPhraseGap)
+	}
+	m := match.NewMatcher(weigh)
+	m.ScanEmbedded(setting("MATCH_EMBEDDED") != "")
+	m.PhraseGap(int(gap))
+	if path := setting("MATCH_PLUGIN"); path != "" {
+		normalize, err := match.LoadNormalizer(path)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %sMATCH_PLUGIN: %v", prefix, err)
+		}
+		log.Println("Normalizing texts with", path)
+		m.Normalize(normalize)
+	}
+	return m, nil
+}
+
+// pollMatching wraps a function loading the options so every load also tells m
+// which of them to match in retweeted and quoted tweets, the options of the polls
+// whose embedded text is scanned, which to match folded, the options of the
+// polls with a folding, and which to match stemmed, the options with a
+// fuzziness. When the polls can't be loaded m keeps the last ones.
+func pollMatching(polls store.PollStore, m *match.Matcher, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("failed to load the polls, keeping the options scanned in embedded tweets:", err)
+			return options, nil
+		}
+		var scanned []string
+		folds := make(map[string]string)
+		stems := make(map[string]match.Stemming)
+		for _, p := range all {
+			if p.EmbeddedText == store.EmbeddedScan {
+				scanned = append(scanned, p.Options...)
+			}
+			if p.Folding != "" {
+				for _, o := range p.Options {
+					// options shared with a transliterating poll are transliterated
+					if folds[o] != store.FoldTransliterate {
+						folds[o] = p.Folding
+					}
+				}
+			}
+			for _, om := range p.Matching {
+				// options shared by polls are stemmed as much as the most, in the first one's language
+				if s, ok := stems[om.Option]; om.Fuzziness != "" && (!ok || s.Level != store.FuzzyStem) {
+					if ok {
+						om.Language = s.Language
+					}
+					stems[om.Option] = match.Stemming{Level: om.Fuzziness, Language: om.Language}
+				}
+			}
+		}
+		m.ScanEmbeddedFor(scanned)
+		m.FoldFor(folds)
+		m.StemFor(stems)
+		return options, nil
+	}
+}
+
+// newDetector creates the spike detector tuned by the SPIKE_ variables, alerting
+// on bus and, unless dryRun, the alerts topic with the publisher it returns
+func newDetector(bus *events.Bus, dryRun bool) (*anomaly.Detector, *publish.NSQ, error) {
+	cfg := anomaly.Config{
+		Bucket:    envDuration("SPIKE_BUCKET", time.Minute),
+		Threshold: envFloat("SPIKE_THRESHOLD", 4),
+		MinVotes:  int(envInt64("SPIKE_MIN_VOTES", 50)),
+		Warmup:    int(envInt64("SPIKE_WARMUP", 10)),
+		Events:    bus,
+	}
+	if dryRun {
+		return anomaly.New(cfg), nil, nil
+	}
+	alerts, err := newPublisher(anomaly.Topic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the alerts publisher: %v", err)
+	}
+	cfg.Alerts = alerts
+	return anomaly.New(cfg), alerts, nil
+}
+
+// newLadder creates the degradation ladder of steps, taking them while the
+// heap in use is over memory bytes or sp holds over spooled, when either is set
+func newLadder(bus *events.Bus, sp *publish.Spool, steps string, memory, spooled int64) (*degrade.Ladder, error) {
+	ladder, err := degrade.ParseLadder(steps)
+	if err != nil {
+		return nil, err
+	}
+	cfg := degrade.Config{
+		Ladder:      ladder,
+		SampleEvery: int(envInt64("DEGRADE_SAMPLE_EVERY", 10)),
+		Interval:    envDuration("DEGRADE_INTERVAL", 10*time.Second),
+		Hold:        envDuration("DEGRADE_HOLD", time.Minute),
+		Events:      bus,
+	}
+	if memory > 0 {
+		cfg.Sources = append(cfg.Sources, degrade.Memory(uint64(memory)))
+	}
+	if spooled > 0 {
+		cfg.Sources = append(cfg.Sources, degrade.Source{Name: "spool", Pressure: func() float64 { return float64(sp.Size()) / float64(spooled) }})
+	}
+	return degrade.New(cfg), nil
+}
+
+// newWatcher creates the watcher of the options tracked, alerting on the silent
+// ones after after unless it is 0, and the publisher of its alerts, nil on a dry run
+func newWatcher(bus *events.Bus, dryRun bool, after time.Duration, tracked func() []string) (*silence.Watcher, *publish.NSQ, error) {
+	cfg := silence.Config{
+		After:    after,
+		MinVotes: int(envInt64("SILENCE_MIN_VOTES", 20)),
+		Tracked:  tracked,
+		Events:   bus,
+	}
+	if dryRun || after <= 0 {
+		return silence.New(cfg), nil, nil
+	}
+	alerts, err := newPublisher(anomaly.Topic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the alerts publisher: %v", err)
+	}
+	cfg.Alerts = alerts
+	return silence.New(cfg), alerts, nil
+}
+
+// newRetractions creates the index retracting the votes of the tweets Twitter
+// asks to stop showing, remembering them by author for window, and the
+// publisher of the retractions, nil on a dry run; none when window is 0
+func newRetractions(dryRun bool, window time.Duration) (*retract.Index, *publish.NSQ, error) {
+	if window <= 0 {
+		return nil, nil, nil
+	}
+	cfg := retract.Config{Window: window, MaxTweets: int(envInt64("RETRACT_MAX_TWEETS", 100000))}
+	if dryRun {
+		return retract.New(cfg), nil, nil
+	}
+	pub, err := newPublisher(retract.Topic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the retractions publisher: %v", err)
+	}
+	cfg.Publisher = pub
+	return retract.New(cfg), pub, nil
+}
+
+// newAuditor creates the sampler publishing fraction of the votes of every
+// option for audit, and its publisher, nil on a dry run
+func newAuditor(dryRun bool, fraction float64) (*audit.Sampler, *publish.NSQ, error) {
+	if fraction > 1 {
+		return nil, nil, fmt.Errorf("invalid -audit-fraction %g, want a share of the votes between 0 and 1", fraction)
+	}
+	cfg := audit.Config{
+		Fraction:     fraction,
+		MinPerOption: int(envInt64("AUDIT_MIN_PER_OPTION", 5)),
+		Window:       envDuration("AUDIT_WINDOW", time.Hour),
+	}
+	if dryRun {
+		return audit.New(cfg), nil, nil
+	}
+	pub, err := newPublisher(audit.Topic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the audit publisher: %v", err)
+	}
+	cfg.Publisher = pub
+	return audit.New(cfg), pub, nil
+}
+
+// trackedOptions returns the options the matchers of pipes know, the ones
+// their streams track
+func trackedOptions(pipes []pipeline) []string {
+	var options []string
+	for _, p := range pipes {
+		options = append(options, p.matcher.Options()...)
+	}
+	return options
+}
+
+// tenantRoute routes the votes for tenants' polls to votes.<tenant>, and to
+// votes when they have no tenant or a poll without one has their option too
+func tenantRoute(tagger *tenant.Tagger) func(v *match.Vote) []string {
+	return func(v *match.Vote) []string {
+		if len(v.Tenants) == 0 {
+			return nil
+		}
+		var topics []string
+		if tagger.Untenanted(v.Option) {
+			topics = append(topics, "votes")
+		}
+		for _, t := range v.Tenants {
+			topics = append(topics, "votes."+t)
+		}
+		return topics
+	}
+}
+
+// streamHealth returns the health check petting systemd's watchdog: the
+// streams may be down and the broker failing for stall at most, then a
+// restart is worth a try
+func streamHealth(stall time.Duration, broker *breaker.Breaker) func() error {
+	return func() error {
+		if d := stream.DownFor(); d > stall {
+			return fmt.Errorf("the streams have been down for %s", d.Round(time.Second))
+		}
+		if d := broker.FailingFor(); d > stall {
+			return fmt.Errorf("publishing has been failing for %s", d.Round(time.Second))
+		}
+		return nil
+	}
+}
+
+// stampInstance sets the streamer instance on the votes from in
+func stampInstance(in <-chan match.Vote, instance string) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			v.Instance = instance
+			out <- v
+		}
+	}()
+	return out
+}
+
+// reportSLO logs and emits the streams' SLO summary every period, starting a new one each time
+func reportSLO(bus *events.Bus, every time.Duration) {
+	for range time.Tick(every) {
+		b, err := json.Marshal(stream.TakeSummary())
+		if err != nil {
+			continue
+		}
+		log.Printf("SLO summary: %s", b)
+		bus.Emit(events.StreamSLOSummary, string(b))
+	}
+}
+
+// newDeadLetters creates the sink stage sends the messages of topic it can't
+// process to, publishing on DEAD_LETTER_TOPIC unless it is none, and the
+// function stopping its publisher
+func newDeadLetters(stage, topic string) (*deadletter.Sink, func(), error) {
+	deadTopic := envString("DEAD_LETTER_TOPIC", deadletter.Topic)
+	if deadTopic == "none" {
+		return nil, func() {}, nil
+	}
+	pub, err := newPublisher(deadTopic)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create the dead letter publisher: %v", err)
+	}
+	return deadletter.New(pub, stage, topic), pub.Stop, nil
+}
+
+// newModerator creates the moderator holding the votes contested for the
+// MODERATE_REASONS and, unless dryRun, the pending topic's publisher it returns
+func newModerator(dryRun bool) (*moderation.Moderator, *publish.NSQ, error) {
+	var pending *publish.NSQ
+	if !dryRun {
+		var err error
+		if pending, err = newPublisher(moderation.Topic); err != nil {
+			return nil, nil, fmt.Errorf("failed to create the pending publisher: %v", err)
+		}
+	}
+	cfg := moderation.Config{
+		Reasons:      envList("MODERATE_REASONS"),
+		MinFollowers: int(envInt64("MODERATE_MIN_FOLLOWERS", 10)),
+	}
+	if pending != nil {
+		cfg.Pending = pending
+	}
+	m, err := moderation.New(cfg)
+	if err != nil {
+		if pending != nil {
+			pending.Stop()
+		}
+		return nil, nil, err
+	}
+	return m, pending, nil
+}
+
+// newPrivacy creates the filter anonymizing the votes for private polls and its
+// hasher, keyed with PRIVACY_KEY and rotated every PRIVACY_KEY_ROTATION
+func newPrivacy() (*privacy.Filter, *privacy.Hasher, error) {
+	rotation := envDuration("PRIVACY_KEY_ROTATION", 24*time.Hour)
+	if rotation <= 0 {
+		return nil, nil, fmt.Errorf("invalid PRIVACY_KEY_ROTATION %v, want a positive duration", rotation)
+	}
+	key := secret("PRIVACY_KEY")
+	if key == "" {
+		log.Println("PRIVACY_KEY isn't set, the authors of private polls' votes hash differently after every restart and on every replica")
+	}
+	h := privacy.NewHasher(key, rotation)
+	return privacy.NewFilter(h), h, nil
+}
+
+// newArchiver creates the archiver storing the matched tweets at url, every
+// ARCHIVE_FLUSH or once ARCHIVE_BATCH_BYTES are batched for a poll
+func newArchiver(url, host string) (*archive.Archiver, error) {
+	sink, err := archive.OpenSink(archiveConfig(url))
+	if err != nil {
+		return nil, err
+	}
+	flush := envDuration("ARCHIVE_FLUSH", 5*time.Minute)
+	if flush <= 0 {
+		return nil, fmt.Errorf("invalid ARCHIVE_FLUSH %v, want a positive duration", flush)
+	}
+	log.Println("Archiving matched tweets to", url)
+	return archive.New(archive.Config{
+		Sink:          sink,
+		Instance:      envString("ARCHIVE_INSTANCE", host),
+		FlushInterval: flush,
+		BatchBytes:    int(envInt64("ARCHIVE_BATCH_BYTES", 16<<20)),
+	}), nil
+}
+
+// archiveConfig describes the tweet archive at url. Buckets are accessed with
+// ARCHIVE_ACCESS_KEY and ARCHIVE_SECRET_KEY, the AWS_* keys by default.
+func archiveConfig(url string) archive.SinkConfig {
+	creds := sigv4.Credentials{
+		AccessKey:    secret("ARCHIVE_ACCESS_KEY"),
+		SecretKey:    secret("ARCHIVE_SECRET_KEY"),
+		SessionToken: secret("ARCHIVE_SESSION_TOKEN"),
+	}
+	if creds.AccessKey == "" {
+		creds = sigv4.Credentials{
+			AccessKey:    secret("AWS_ACCESS_KEY_ID"),
+			SecretKey:    secret("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: secret("AWS_SESSION_TOKEN"),
+		}
+	}
+	return archive.SinkConfig{
+		URL:         url,
+		Endpoint:    envString("ARCHIVE_ENDPOINT", ""),
+		Region:      envString("ARCHIVE_REGION", getenv("AWS_REGION")),
+		Credentials: creds,
+	}
+}
+
+// twitterCredentials returns the Twitter credentials from the secrets or the environment
+func twitterCredentials() stream.Credentials {
+	return stream.Credentials{
+		ConsumerKey:    secret("TWITTER_KEY"),
+		ConsumerSecret: secret("TWITTER_SECRET"),
+		AccessToken:    secret("TWITTER_ACCESS_TOKEN"),
+		AccessSecret:   secret("TWITTER_ACCESS_SECRET"),
+	}
+}
+
+// newTwitter creates the Twitter client with the credentials of account, telling
+// compliance, when set, about the tweets and authors Twitter asks to stop showing.
+// Requests go through the proxy in HTTPS_PROXY, the TWITTER_TLS variables configure TLS.
+func newTwitter(account string, options func() ([]string, error), onConnect func([]string), searched func() []string, locations func() []stream.BoundingBox, bus *events.Bus, compliance func(stream.Compliance)) (*stream.Stream, error) {
+	t, err := tlsConfig("TWITTER")
+	if err != nil {
+		return nil, err
+	}
+	framing := envString("TWITTER_STREAM_FRAMING", stream.FramingV1)
+	if framing != stream.FramingV1 && framing != stream.FramingV2 {
+		return nil, fmt.Errorf("invalid TWITTER_STREAM_FRAMING %q, want v1 or v2", framing)
+	}
+	var bearer string
+	if framing == stream.FramingV2 {
+		bearer = secret("TWITTER_BEARER_TOKEN")
+	}
+	return stream.New(stream.Config{
+		Name:              accountLabel(account),
+		Credentials:       accountCredentials(account),
+		Options:           options,
+		OnConnect:         onConnect,
+		Searched:          searched,
+		SearchEvery:       envDuration("TRACK_SEARCH_INTERVAL", time.Minute),
+		Locations:         locations,
+		DuplicateCooldown: envDuration("DUPLICATE_CONNECTION_COOLDOWN", 15*time.Minute),
+		DuplicatePatterns: envList("DUPLICATE_CONNECTION_PATTERNS"),
+		Events:            bus,
+		Breaker:           newBreaker(twitterDependency(account), 5*time.Minute),
+		Gzip:              envBool("TWITTER_GZIP", true),
+		Compliance:        compliance,
+		Framing:           framing,
+		BearerToken:       bearer,
+		Transport: stream.TransportConfig{
+			TLS:                   t,
+			DialTimeout:           envDuration("TWITTER_DIAL_TIMEOUT", 10*time.Second),
+			TLSHandshakeTimeout:   envDuration("TWITTER_TLS_HANDSHAKE_TIMEOUT", 10*time.Second),
+			ResponseHeaderTimeout: envDuration("TWITTER_RESPONSE_HEADER_TIMEOUT", 30*time.Second),
+			StallTimeout:          envDuration("TWITTER_STALL_TIMEOUT", 90*time.Second),
+			SearchTimeout:         envDuration("TWITTER_SEARCH_TIMEOUT", 30*time.Second),
+			WrapConn:              faults.WrapConn(chaos.Stream),
+		},
+	}), nil
+}
+
+// smsVote enforces the one-vote rule of SMS votes: a voter's first vote in a
+// poll counts, the next ones for any of its options are refused. It returns
+// false when every tracked poll with the option already has the voter.
+func smsVote(db store.Backend) (func(voter, option string) (bool, func() error, error), error) {
+	voters, ok := db.(store.VoterStore)
+	if !ok {
+		return nil, fmt.Errorf("-sms-addr needs a store that remembers voters")
+	}
+	return func(voter, option string) (bool, func() error, error) {
+		polls, err := db.Polls()
+		if err != nil {
+			return false, nil, err
+		}
+		// the polls the vote is recorded in, which undo forgets it in again
+		var added []string
+		undo := func() error {
+			for _, id := range added {
+				if err := voters.RemoveVoter(id, voter); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		for _, p := range polls {
+			if !p.Tracked() {
+				continue
+			}
+			for _, o := range p.Options {
+				if strings.EqualFold(o, option) {
+					ok, err := voters.AddVoter(p.ID, voter)
+					if err != nil {
+						undo()
+						return false, nil, err
+					}
+					if ok {
+						added = append(added, p.ID)
+					}
+					break
+				}
+			}
+		}
+		return len(added) > 0, undo, nil
+	}, nil
+}
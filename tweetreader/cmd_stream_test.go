@@ -0,0 +1,94 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+const smsTestURL = "https://example.com/sms"
+
+// textVote posts a text from voter to sms as Twilio would, returning the reply
+func textVote(t *testing.T, sms *stream.SMS, id, from, body string) string {
+	form := url.Values{"MessageSid": {id}, "From": {from}, "Body": {body}}
+	keys := make([]string, 0, len(form))
+	for k := range form {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte("token"))
+	mac.Write([]byte(smsTestURL))
+	for _, k := range keys {
+		mac.Write([]byte(k + form.Get(k)))
+	}
+	r := httptest.NewRequest(http.MethodPost, "/sms", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("X-Twilio-Signature", base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+	w := httptest.NewRecorder()
+	sms.ServeHTTP(w, r)
+	return w.Body.String()
+}
+
+func TestSMSVoteRetriedAfterATimeout(t *testing.T) {
+	db := store.NewMemory(store.Poll{ID: "p", Options: []string{"yes", "no"}})
+	vote, err := smsVote(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded := make(chan struct{}, 1)
+	sms, err := stream.NewSMS(stream.SMSConfig{
+		AuthToken: "token",
+		URL:       smsTestURL,
+		Options:   func() ([]string, error) { return []string{"yes", "no"}, nil },
+		OnConnect: func([]string) { loaded <- struct{}{} },
+		Vote:      vote,
+		Timeout:   50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tweets := make(chan stream.Tweet)
+	stop := make(chan struct{})
+	stopped := sms.Start(stop, tweets)
+	defer func() {
+		close(stop)
+		<-stopped
+	}()
+	<-loaded
+
+	tests := []struct {
+		name  string
+		id    string
+		from  string
+		body  string
+		take  bool // whether the pipeline takes a vote before sending the text
+		reply string
+	}{
+		// nothing reads the tweets, so the source holds on to this one and takes no more
+		{"the first vote is passed on", "1", "+441", "VOTE yes", false, "is counted"},
+		{"a vote the pipeline doesn't take in time", "2", "+442", "VOTE no", false, "try again later"},
+		{"its retry once the pipeline takes votes", "3", "+442", "VOTE no", true, "is counted"},
+		{"a second vote after it counted", "4", "+442", "VOTE yes", false, "already voted"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.take {
+				<-tweets
+				go func() { <-tweets }()
+			}
+			if got := textVote(t, sms, tt.id, tt.from, tt.body); !strings.Contains(got, tt.reply) {
+				t.Errorf("replied %q, want %q in it", got, tt.reply)
+			}
+		})
+	}
+}
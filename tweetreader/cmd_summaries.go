@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/summary"
+)
+
+// logPoster logs the tweets a dry run would post
+type logPoster struct{}
+
+func (logPoster) Post(text string) (string, error) {
+	log.Printf("summary: would tweet %q", text)
+	return "dry-run", nil
+}
+
+// runSummaries tweets the standings of the running polls, once or every -every
+func runSummaries(args []string) error {
+	fs := newFlagSet("summaries")
+	var (
+		account  = fs.String("account", envString("SUMMARY_ACCOUNT", ""), "Twitter account the summaries are tweeted from, as in TWITTER_ACCOUNTS (empty for the default credentials)")
+		text     = fs.String("template", envString("SUMMARY_TEMPLATE", summary.DefaultTemplate), "Go template of a tweet, with the poll's .Title, .Total and .Standings, each with .Option, .Votes and .Share")
+		polls    = fs.String("polls", envString("SUMMARY_POLLS", ""), "comma separated IDs of the polls tweeted about (empty for every poll counting votes)")
+		minVotes = fs.Int("min-votes", int(envInt64("SUMMARY_MIN_VOTES", 10)), "votes a poll needs before its standings are tweeted")
+		interval = fs.Duration("interval", envDuration("SUMMARY_INTERVAL", time.Hour), "least time between two tweets about a poll")
+		perHour  = fs.Int("max-per-hour", int(envInt64("SUMMARY_MAX_PER_HOUR", 10)), "most tweets an hour for every poll together (0 for no cap)")
+		every    = fs.Duration("every", envDuration("SUMMARY_EVERY", 0), "look for the polls due a tweet this often until stopped (0 to look once and exit)")
+		dryRun   = fs.Bool("dry-run", os.Getenv("SUMMARY_DRY_RUN") != "", "log the tweets instead of posting them")
+	)
+	fs.Parse(args)
+	if err := validAccount(*account); err != nil {
+		return fmt.Errorf("invalid -account: %v", err)
+	}
+	tmpl, err := summary.ParseTemplate(*text)
+	if err != nil {
+		return fmt.Errorf("invalid -template: %v", err)
+	}
+	var poster summary.Poster = logPoster{}
+	if !*dryRun {
+		tw, err := summary.NewTwitter(accountCredentials(*account), envString("TWITTER_TWEETS_URL", ""))
+		if err != nil {
+			return fmt.Errorf("account %s: %v", accountLabel(*account), err)
+		}
+		poster = tw
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	s := summary.New(summary.Config{
+		Template:   tmpl,
+		Polls:      splitList(*polls),
+		MinVotes:   *minVotes,
+		Interval:   *interval,
+		MaxPerHour: *perHour,
+	}, db, poster)
+	round := func() error {
+		r, err := s.Run(time.Now())
+		b, _ := json.Marshal(r)
+		log.Printf("summary: %s", b)
+		return err
+	}
+	if *every <= 0 {
+		return round()
+	}
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	t := time.NewTicker(*every)
+	defer t.Stop()
+	for {
+		// a failed round is retried with the next, the polls not tweeted about are still due
+		if err := round(); err != nil {
+			log.Println("summary: round failed:", err)
+		}
+		select {
+		case <-t.C:
+		case <-termChan:
+			log.Println("Stopping summaries...")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+)
+
+// clearScreen moves the cursor home and clears the terminal
+const clearScreen = "\x1b[H\x1b[2J"
+
+// breakerStates names the values of tweetreader_breaker_state
+var breakerStates = map[float64]string{0: "closed", 1: "probing", 2: "open"}
+
+// runTop shows what a running streamer is doing, redrawn every -every from
+// its admin API: the vote rates per option, the stream's health, the depths
+// of the buffers and the last errors
+func runTop(args []string) error {
+	fs := newFlagSet("top")
+	var (
+		addr  = fs.String("addr", envString("TOP_ADDR", "http://localhost"+adminAddr), "admin API of the streamer to watch")
+		key   = fs.String("key", envString("TOP_KEY", adminKey), "API key of a viewer of the admin API")
+		every = fs.Duration("every", envDuration("TOP_EVERY", 2*time.Second), "how often to redraw")
+		rows  = fs.Int("rows", 20, "options shown, the busiest first")
+		once  = fs.Bool("once", false, "print the status once, without the vote rates, and exit")
+	)
+	fs.Parse(args)
+	if *every <= 0 {
+		return fmt.Errorf("invalid -every %v", *every)
+	}
+	url := strings.TrimRight(*addr, "/") + "/admin/top"
+	client := &http.Client{Timeout: 10 * time.Second}
+	fetch := func() (topStatus, error) {
+		var st topStatus
+		req, err := http.NewRequest("GET", url, nil)
+		if err != nil {
+			return st, err
+		}
+		if *key != "" {
+			req.Header.Set("X-API-Key", *key)
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			return st, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+			return st, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+		}
+		return st, json.NewDecoder(resp.Body).Decode(&st)
+	}
+
+	if *once {
+		st, err := fetch()
+		if err != nil {
+			return err
+		}
+		drawTop(os.Stdout, *addr, st, nil, *rows)
+		return nil
+	}
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	tick := time.NewTicker(*every)
+	defer tick.Stop()
+	var last *topStatus
+	for {
+		st, err := fetch()
+		if err != nil {
+			fmt.Fprintf(os.Stdout, "%stwitter-poll top  %s  %s\n\ncan't reach the admin API: %v\n", clearScreen, *addr, time.Now().Format("15:04:05"), err)
+		} else {
+			drawTop(os.Stdout, *addr, st, last, *rows)
+			last = &st
+		}
+		select {
+		case <-tick.C:
+		case <-termChan:
+			return nil
+		}
+	}
+}
+
+// drawTop draws st, with the vote rates since last when there is one
+func drawTop(w io.Writer, addr string, st topStatus, last *topStatus, rows int) {
+	if last != nil {
+		fmt.Fprint(w, clearScreen)
+	}
+	fmt.Fprintf(w, "twitter-poll top  %s  %s\n\n", addr, st.Time.Format("15:04:05"))
+
+	s := st.Stream
+	conn := "disconnected"
+	if st.Gauges["tweetreader_stream_connected"] == 1 {
+		uptime := time.Duration(st.Gauges["tweetreader_stream_connection_uptime_seconds"]) * time.Second
+		conn = "connected " + uptime.String()
+	}
+	reconnects, causes := 0, make([]string, 0, len(s.Reconnects))
+	for cause, n := range s.Reconnects {
+		reconnects += n
+		causes = append(causes, fmt.Sprintf("%s %d", cause, n))
+	}
+	sort.Strings(causes)
+	fmt.Fprintf(w, "stream   %s  tweets %d  uptime %.2f%%  gaps %d (%s)  reconnects %d",
+		conn, s.Tweets, 100*s.UptimeRatio, s.Gaps, time.Duration(s.GapSeconds*float64(time.Second)).Round(time.Second), reconnects)
+	if len(causes) > 0 {
+		fmt.Fprintf(w, " (%s)", strings.Join(causes, ", "))
+	}
+	fmt.Fprintln(w)
+
+	paused := "no"
+	if st.Paused {
+		paused = "yes"
+	}
+	fmt.Fprintf(w, "publish  queue %.0f/%.0f  spool %d bytes  paused %s",
+		st.Gauges["tweetreader_votes_queue_depth"], st.Gauges["tweetreader_votes_queue_capacity"], st.SpoolBytes, paused)
+	if n, ok := st.Gauges["tweetreader_outbox_pending"]; ok {
+		fmt.Fprintf(w, "  outbox %.0f", n)
+	}
+	fmt.Fprintln(w)
+	var breakers []string
+	for series, v := range st.Gauges {
+		if strings.HasPrefix(series, "tweetreader_breaker_state{") {
+			dep := strings.TrimSuffix(strings.TrimPrefix(series, `tweetreader_breaker_state{dependency="`), `"}`)
+			breakers = append(breakers, dep+" "+breakerStates[v])
+		}
+	}
+	sort.Strings(breakers)
+	if len(breakers) > 0 {
+		fmt.Fprintf(w, "breakers %s\n", strings.Join(breakers, ", "))
+	}
+
+	// the busiest options first, by rate once there is one and by total before
+	type row struct {
+		option string
+		total  int64
+		rate   float64
+	}
+	var table []row
+	elapsed := 0.0
+	if last != nil {
+		elapsed = st.Time.Sub(last.Time).Seconds()
+	}
+	busiest := 0.0
+	for option, n := range st.Votes {
+		r := row{option: option, total: n}
+		if elapsed > 0 {
+			r.rate = float64(n-last.Votes[option]) / elapsed
+		}
+		if r.rate > busiest {
+			busiest = r.rate
+		}
+		table = append(table, r)
+	}
+	sort.Slice(table, func(i, j int) bool {
+		if table[i].rate != table[j].rate {
+			return table[i].rate > table[j].rate
+		}
+		if table[i].total != table[j].total {
+			return table[i].total > table[j].total
+		}
+		return table[i].option < table[j].option
+	})
+	if len(table) > rows {
+		table = table[:rows]
+	}
+	fmt.Fprintf(w, "\n%-24s %10s %12s\n", "OPTION", "VOTES/S", "VOTES")
+	for _, r := range table {
+		rate, bar := "-", ""
+		if elapsed > 0 {
+			rate = fmt.Sprintf("%.1f", r.rate)
+			if busiest > 0 {
+				bar = strings.Repeat("#", int(30*r.rate/busiest))
+			}
+		}
+		option := r.option
+		if len(option) > 24 {
+			option = option[:23] + "~"
+		}
+		fmt.Fprintf(w, "%-24s %10s %12d  %s\n", option, rate, r.total, bar)
+	}
+	if len(st.Votes) == 0 {
+		fmt.Fprintln(w, "no votes yet")
+	}
+
+	fmt.Fprintln(w, "\nrecent errors")
+	if len(st.Errors) == 0 {
+		fmt.Fprintln(w, "none")
+	}
+	for _, e := range st.Errors {
+		fmt.Fprintf(w, "%s %s\n", e.Time.Format("15:04:05"), e.Line)
+	}
+}
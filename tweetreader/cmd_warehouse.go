@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/warehouse"
+)
+
+// runWarehouse loads the votes and the polls' results into the warehouse,
+// once or every -every
+func runWarehouse(args []string) error {
+	fs := newFlagSet("warehouse")
+	var (
+		sinkURL = fs.String("sink", envString("WAREHOUSE_URL", ""), "where to load: bigquery://project/dataset, or gzipped CSV files to s3://bucket/prefix, gs://bucket/prefix or a local directory")
+		every   = fs.Duration("every", envDuration("WAREHOUSE_EVERY", 0), "load this often until stopped (0 to load once and exit)")
+	)
+	fs.Parse(args)
+	if *sinkURL == "" {
+		fs.Usage()
+		return fmt.Errorf("warehouse needs -sink")
+	}
+	sink, err := newWarehouseSink(*sinkURL)
+	if err != nil {
+		return fmt.Errorf("invalid -sink: %v", err)
+	}
+	db, err := dialStore()
+	if err != nil {
+		return fmt.Errorf("failed to open the store: %v", err)
+	}
+	defer db.Close()
+
+	load := func() error {
+		r, err := warehouse.Load(db, sink, time.Now())
+		if err != nil {
+			log.Println("warehouse: load failed:", err)
+			return err
+		}
+		b, _ := json.Marshal(r)
+		log.Printf("warehouse: %s", b)
+		return nil
+	}
+	if *every <= 0 {
+		return load()
+	}
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan)
+	t := time.NewTicker(*every)
+	defer t.Stop()
+	for {
+		load() // a failed load is retried with the next, from where the last one got to
+		select {
+		case <-t.C:
+		case <-termChan:
+			log.Println("Stopping warehouse...")
+			return nil
+		}
+	}
+}
+
+// newWarehouseSink opens the warehouse at u. BigQuery authenticates with the
+// service account key in the WAREHOUSE_CREDENTIALS secret, or the file
+// GOOGLE_APPLICATION_CREDENTIALS names, else as the instance's service
+// account; the files go where the archive would, with its credentials.
+func newWarehouseSink(u string) (warehouse.Sink, error) {
+	if !strings.HasPrefix(u, "bigquery://") {
+		files, err := archive.OpenSink(archiveConfig(u))
+		if err != nil {
+			return nil, err
+		}
+		return warehouse.Files{Sink: files}, nil
+	}
+	parsed, err := url.Parse(u)
+	if err != nil {
+		return nil, err
+	}
+	credentials := []byte(secret("WAREHOUSE_CREDENTIALS"))
+	if path := getenv("GOOGLE_APPLICATION_CREDENTIALS"); len(credentials) == 0 && path != "" {
+		if credentials, err = ioutil.ReadFile(path); err != nil {
+			return nil, err
+		}
+	}
+	return warehouse.NewBigQuery(warehouse.BigQueryConfig{
+		Project:     parsed.Host,
+		Dataset:     strings.Trim(parsed.Path, "/"),
+		URL:         envString("BIGQUERY_URL", warehouse.BigQueryURL),
+		Credentials: credentials,
+		Timeout:     envDuration("WAREHOUSE_TIMEOUT", time.Minute),
+	})
+}
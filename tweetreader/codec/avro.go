@@ -0,0 +1,490 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// AvroSchema is the schema of the votes the Avro codec writes
+const AvroSchema = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroAggregateFields + avroInstanceField + avroFilteredField + avroUndeliveredField + avroVersionsField + avroSchemaEnd
+
+// The earlier versions of AvroSchema, whose votes are still read
+const (
+	avroSchemaV1  = avroSchemaFields + avroSchemaEnd
+	avroSchemaV2  = avroSchemaFields + avroSuspectField + avroSchemaEnd
+	avroSchemaV3  = avroSchemaFields + avroSuspectField + avroScaleField + avroSchemaEnd
+	avroSchemaV4  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroSchemaEnd
+	avroSchemaV5  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroSchemaEnd
+	avroSchemaV6  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroSchemaEnd
+	avroSchemaV7  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSchemaEnd
+	avroSchemaV8  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroSchemaEnd
+	avroSchemaV9  = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroSchemaEnd
+	avroSchemaV10 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroSchemaEnd
+	avroSchemaV11 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroSchemaEnd
+	avroSchemaV12 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroAggregateFields + avroSchemaEnd
+	avroSchemaV13 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroAggregateFields + avroInstanceField + avroSchemaEnd
+	avroSchemaV14 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroAggregateFields + avroInstanceField + avroFilteredField + avroSchemaEnd
+	avroSchemaV15 = avroSchemaFields + avroSuspectField + avroScaleField + avroKindFields + avroStrictFields + avroHitField + avroTenantsField + avroSourceField + avroLangField + avroFoldedField + avroStemmedField + avroAggregateFields + avroInstanceField + avroFilteredField + avroUndeliveredField + avroSchemaEnd
+)
+
+// avroSchemas are the versions of the schema this build reads, oldest first
+var avroSchemas = []string{avroSchemaV1, avroSchemaV2, avroSchemaV3, avroSchemaV4, avroSchemaV5, avroSchemaV6, avroSchemaV7, avroSchemaV8, avroSchemaV9, avroSchemaV10, avroSchemaV11, avroSchemaV12, avroSchemaV13, avroSchemaV14, avroSchemaV15, AvroSchema}
+
+// the fields later versions added, and the end of the schema
+const (
+	avroSuspectField = `,
+    {"name": "suspect", "type": "boolean", "default": false}`
+	avroScaleField = `,
+    {"name": "scale", "type": "long", "default": 0}`
+	avroKindFields = `,
+    {"name": "retweet", "type": "boolean", "default": false},
+    {"name": "quote", "type": "boolean", "default": false},
+    {"name": "reply", "type": "boolean", "default": false},
+    {"name": "embedded", "type": "boolean", "default": false}`
+	avroStrictFields = `,
+    {"name": "case_folded", "type": "boolean", "default": false},
+    {"name": "partial", "type": "boolean", "default": false}`
+	avroHitField = `,
+    {"name": "hit", "type": ["null", {
+      "type": "record",
+      "name": "Hit",
+      "fields": [
+        {"name": "term", "type": "string"},
+        {"name": "start", "type": "long"},
+        {"name": "end", "type": "long"},
+        {"name": "snippet", "type": "string"},
+        {"name": "snippet_start", "type": "long"}
+      ]
+    }], "default": null}`
+	avroTenantsField = `,
+    {"name": "tenants", "type": {"type": "array", "items": "string"}, "default": []}`
+	avroSourceField = `,
+    {"name": "source", "type": "string", "default": ""}`
+	avroLangField = `,
+    {"name": "lang", "type": "string", "default": ""}`
+	avroFoldedField = `,
+    {"name": "folded", "type": "string", "default": ""}`
+	avroStemmedField = `,
+    {"name": "stemmed", "type": "string", "default": ""}`
+	avroAggregateFields = `,
+    {"name": "count", "type": "long", "default": 0},
+    {"name": "window", "type": "long", "default": 0}`
+	avroInstanceField = `,
+    {"name": "instance", "type": "string", "default": ""}`
+	avroFilteredField = `,
+    {"name": "filtered", "type": {"type": "array", "items": "string"}, "default": []}`
+	avroUndeliveredField = `,
+    {"name": "undelivered", "type": "double", "default": 0}`
+	avroVersionsField = `,
+    {"name": "versions", "type": {"type": "array", "items": "string"}, "default": []}`
+	avroSchemaEnd = `
+  ]
+}`
+)
+
+// avroSchemaFields is the schema up to the fields later versions added
+const avroSchemaFields = `{
+  "type": "record",
+  "name": "Vote",
+  "namespace": "twitterpoll",
+  "fields": [
+    {"name": "id_str", "type": "string"},
+    {"name": "created_at", "type": "string"},
+    {"name": "text", "type": "string"},
+    {"name": "user", "type": {
+      "type": "record",
+      "name": "User",
+      "fields": [
+        {"name": "name", "type": "string"},
+        {"name": "screen_name", "type": "string"},
+        {"name": "verified", "type": "boolean"},
+        {"name": "followers_count", "type": "long"}
+      ]
+    }},
+    {"name": "option", "type": "string"},
+    {"name": "weight", "type": "double"},
+    {"name": "geo", "type": ["null", {
+      "type": "record",
+      "name": "Geo",
+      "fields": [
+        {"name": "longitude", "type": "double"},
+        {"name": "latitude", "type": "double"},
+        {"name": "exact", "type": "boolean"},
+        {"name": "place_id", "type": "string"},
+        {"name": "place", "type": "string"},
+        {"name": "country_code", "type": "string"},
+        {"name": "country", "type": "string"},
+        {"name": "region", "type": "string"}
+      ]
+    }], "default": null},
+    {"name": "hashtag", "type": "boolean", "default": false},
+    {"name": "message_id", "type": "string", "default": ""}`
+
+// Avro encodes votes with AvroSchema in the Confluent wire format: a zero
+// byte, the schema's ID in the registry as 4 bytes big endian, and the Avro
+// binary encoding. Consumers using the registry look the schema up by its ID.
+// Like the other codecs the encoding is written by hand, only for this schema.
+type Avro struct {
+	registry *Registry
+
+	mu      sync.Mutex
+	id      int32
+	retryAt time.Time // no registering again before, after a failure
+}
+
+// avroRetry is how long Marshal fails right away after failing to register the schema
+const avroRetry = 30 * time.Second
+
+// NewAvro creates the Avro codec registering AvroSchema with registry
+func NewAvro(registry *Registry) *Avro {
+	return &Avro{registry: registry}
+}
+
+// Name is avro
+func (*Avro) Name() string { return "avro" }
+
+// RegisterSchema registers AvroSchema, which Marshal otherwise does with the first vote
+func (a *Avro) RegisterSchema() error {
+	_, err := a.schemaID()
+	return err
+}
+
+func (a *Avro) schemaID() (int32, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.id != 0 {
+		return a.id, nil
+	}
+	if time.Now().Before(a.retryAt) {
+		return 0, errors.New("codec: avro schema not registered yet")
+	}
+	id, err := a.registry.Register(AvroSchema)
+	if err != nil {
+		a.retryAt = time.Now().Add(avroRetry)
+		return 0, err
+	}
+	a.id = id
+	return id, nil
+}
+
+func (a *Avro) Marshal(v *match.Vote) ([]byte, error) {
+	id, err := a.schemaID()
+	if err != nil {
+		return nil, err
+	}
+	b := []byte{0, 0, 0, 0, 0}
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	b = avroString(b, v.ID)
+	b = avroString(b, v.CreatedAt)
+	b = avroString(b, v.Text)
+	b = avroString(b, v.User.Name)
+	b = avroString(b, v.User.ScreenName)
+	b = avroBool(b, v.User.Verified)
+	b = avroLong(b, int64(v.User.FollowersCount))
+	b = avroString(b, v.Option)
+	b = avroDouble(b, v.Weight)
+	if g := v.Geo; g == nil {
+		b = avroLong(b, 0)
+	} else {
+		b = avroLong(b, 1)
+		b = avroDouble(b, g.Longitude)
+		b = avroDouble(b, g.Latitude)
+		b = avroBool(b, g.Exact)
+		b = avroString(b, g.PlaceID)
+		b = avroString(b, g.Place)
+		b = avroString(b, g.CountryCode)
+		b = avroString(b, g.Country)
+		b = avroString(b, g.Region)
+	}
+	b = avroBool(b, v.Hashtag)
+	b = avroString(b, v.MessageID)
+	b = avroBool(b, v.Suspect)
+	b = avroLong(b, int64(v.Scale))
+	b = avroBool(b, v.Retweet)
+	b = avroBool(b, v.Quote)
+	b = avroBool(b, v.Reply)
+	b = avroBool(b, v.Embedded)
+	b = avroBool(b, v.CaseFolded)
+	b = avroBool(b, v.Partial)
+	if h := v.Hit; h == nil {
+		b = avroLong(b, 0)
+	} else {
+		b = avroLong(b, 1)
+		b = avroString(b, h.Term)
+		b = avroLong(b, int64(h.Start))
+		b = avroLong(b, int64(h.End))
+		b = avroString(b, h.Snippet)
+		b = avroLong(b, int64(h.SnippetStart))
+	}
+	// one block with all the tenants, then the empty block ending the array
+	if len(v.Tenants) > 0 {
+		b = avroLong(b, int64(len(v.Tenants)))
+		for _, t := range v.Tenants {
+			b = avroString(b, t)
+		}
+	}
+	b = avroLong(b, 0)
+	b = avroString(b, v.Source)
+	b = avroString(b, v.Lang)
+	b = avroString(b, v.Folded)
+	b = avroString(b, v.Stemmed)
+	b = avroLong(b, int64(v.Count))
+	b = avroLong(b, int64(v.Window))
+	b = avroString(b, v.Instance)
+	if len(v.Filtered) > 0 {
+		b = avroLong(b, int64(len(v.Filtered)))
+		for _, p := range v.Filtered {
+			b = avroString(b, p)
+		}
+	}
+	b = avroLong(b, 0)
+	b = avroDouble(b, v.Undelivered)
+	if len(v.Versions) > 0 {
+		b = avroLong(b, int64(len(v.Versions)))
+		for _, t := range v.Versions {
+			b = avroString(b, t)
+		}
+	}
+	return avroLong(b, 0), nil
+}
+
+// Unmarshal decodes votes written with AvroSchema or an earlier version of it,
+// checking with the registry which one the ID they carry is of
+func (a *Avro) Unmarshal(b []byte, v *match.Vote) error {
+	if len(b) < 5 || b[0] != 0 {
+		return errors.New("codec: avro: missing the schema ID")
+	}
+	id := int32(binary.BigEndian.Uint32(b[1:5]))
+	version := 0 // of the schema, counting from 1
+	for i := len(avroSchemas) - 1; i >= 0 && version == 0; i-- {
+		ok, err := a.registry.Is(id, avroSchemas[i])
+		if err != nil {
+			return err
+		}
+		if ok {
+			version = i + 1
+		}
+	}
+	if version == 0 {
+		return fmt.Errorf("codec: avro: schema %d isn't a vote schema this build reads", id)
+	}
+	d := avroDecoder{b: b[5:]}
+	v.ID = d.string()
+	v.CreatedAt = d.string()
+	v.Text = d.string()
+	v.User.Name = d.string()
+	v.User.ScreenName = d.string()
+	v.User.Verified = d.bool()
+	v.User.FollowersCount = int(d.long())
+	v.Option = d.string()
+	v.Weight = d.double()
+	switch d.long() {
+	case 0:
+		v.Geo = nil
+	case 1:
+		v.Geo = &match.Geo{
+			Longitude:   d.double(),
+			Latitude:    d.double(),
+			Exact:       d.bool(),
+			PlaceID:     d.string(),
+			Place:       d.string(),
+			CountryCode: d.string(),
+			Country:     d.string(),
+			Region:      d.string(),
+		}
+	default:
+		return errors.New("codec: avro: invalid geo")
+	}
+	v.Hashtag = d.bool()
+	v.MessageID = d.string()
+	if version >= 2 {
+		v.Suspect = d.bool()
+	}
+	if version >= 3 {
+		v.Scale = int(d.long())
+	}
+	if version >= 4 {
+		v.Retweet = d.bool()
+		v.Quote = d.bool()
+		v.Reply = d.bool()
+		v.Embedded = d.bool()
+	}
+	if version >= 5 {
+		v.CaseFolded = d.bool()
+		v.Partial = d.bool()
+	}
+	if version >= 6 {
+		switch d.long() {
+		case 0:
+			v.Hit = nil
+		case 1:
+			v.Hit = &match.Hit{
+				Term:         d.string(),
+				Start:        int(d.long()),
+				End:          int(d.long()),
+				Snippet:      d.string(),
+				SnippetStart: int(d.long()),
+			}
+		default:
+			return errors.New("codec: avro: invalid hit")
+		}
+	}
+	if version >= 7 {
+		v.Tenants = nil
+		for n := d.long(); n != 0 && d.err == nil; n = d.long() {
+			if n < 0 {
+				// a block with its size in bytes, which isn't needed
+				n = -n
+				d.long()
+			}
+			for ; n > 0 && d.err == nil; n-- {
+				v.Tenants = append(v.Tenants, d.string())
+			}
+		}
+	}
+	if version >= 8 {
+		v.Source = d.string()
+	}
+	if version >= 9 {
+		v.Lang = d.string()
+	}
+	if version >= 10 {
+		v.Folded = d.string()
+	}
+	if version >= 11 {
+		v.Stemmed = d.string()
+	}
+	if version >= 12 {
+		v.Count = int(d.long())
+		v.Window = int(d.long())
+	}
+	if version >= 13 {
+		v.Instance = d.string()
+	}
+	if version >= 14 {
+		v.Filtered = nil
+		for n := d.long(); n != 0 && d.err == nil; n = d.long() {
+			if n < 0 {
+				n = -n
+				d.long()
+			}
+			for ; n > 0 && d.err == nil; n-- {
+				v.Filtered = append(v.Filtered, d.string())
+			}
+		}
+	}
+	if version >= 15 {
+		v.Undelivered = d.double()
+	}
+	if version >= 16 {
+		v.Versions = nil
+		for n := d.long(); n != 0 && d.err == nil; n = d.long() {
+			if n < 0 {
+				n = -n
+				d.long()
+			}
+			for ; n > 0 && d.err == nil; n-- {
+				v.Versions = append(v.Versions, d.string())
+			}
+		}
+	}
+	return d.err
+}
+
+func avroLong(b []byte, n int64) []byte {
+	u := uint64(n<<1) ^ uint64(n>>63) // zigzag
+	for u >= 0x80 {
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
+}
+
+func avroString(b []byte, s string) []byte {
+	return append(avroLong(b, int64(len(s))), s...)
+}
+
+func avroBool(b []byte, t bool) []byte {
+	if t {
+		return append(b, 1)
+	}
+	return append(b, 0)
+}
+
+func avroDouble(b []byte, f float64) []byte {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+	return append(b, buf[:]...)
+}
+
+// avroDecoder reads the Avro binary encoding, err is set at the first problem
+// and every read after it returns zero values
+type avroDecoder struct {
+	b   []byte
+	err error
+}
+
+var errAvroTruncated = errors.New("codec: avro: truncated message")
+
+func (d *avroDecoder) long() int64 {
+	var u uint64
+	for shift := uint(0); d.err == nil; shift += 7 {
+		if len(d.b) == 0 || shift > 63 {
+			d.err = errAvroTruncated
+			break
+		}
+		c := d.b[0]
+		d.b = d.b[1:]
+		u |= uint64(c&0x7f) << shift
+		if c < 0x80 {
+			return int64(u>>1) ^ -int64(u&1)
+		}
+	}
+	return 0
+}
+
+func (d *avroDecoder) string() string {
+	n := d.long()
+	if d.err != nil {
+		return ""
+	}
+	if n < 0 || int64(len(d.b)) < n {
+		d.err = errAvroTruncated
+		return ""
+	}
+	s := string(d.b[:n])
+	d.b = d.b[n:]
+	return s
+}
+
+func (d *avroDecoder) bool() bool {
+	if d.err != nil {
+		return false
+	}
+	if len(d.b) == 0 {
+		d.err = errAvroTruncated
+		return false
+	}
+	t := d.b[0] != 0
+	d.b = d.b[1:]
+	return t
+}
+
+func (d *avroDecoder) double() float64 {
+	if d.err != nil {
+		return 0
+	}
+	if len(d.b) < 8 {
+		d.err = errAvroTruncated
+		return 0
+	}
+	f := math.Float64frombits(binary.LittleEndian.Uint64(d.b))
+	d.b = d.b[8:]
+	return f
+}
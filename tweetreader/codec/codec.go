@@ -0,0 +1,108 @@
+// Package codec encodes votes for the broker.
+//
+// NSQ messages have no headers, so the codec travels in the message itself.
+// JSON, the original format, is published as is; every other codec's messages
+// start with a zero byte, the length of the codec name, and the name:
+//
+//	0x00 len(name) name payload
+//
+// A JSON message can never start with a zero byte, so consumers tell the two apart
+// and old JSON-only consumers keep working while publishers stay on JSON.
+// During a rolling upgrade, upgrade the consumers first, then switch the publishers.
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// Codec turns votes into message payloads and back
+type Codec interface {
+	// Name identifies the codec in framed messages, at most 255 bytes
+	Name() string
+	Marshal(v *match.Vote) ([]byte, error)
+	Unmarshal(b []byte, v *match.Vote) error
+}
+
+var (
+	mu     sync.RWMutex
+	codecs = make(map[string]Codec)
+)
+
+func init() {
+	Register(JSON)
+	Register(MsgPack)
+	Register(Protobuf)
+}
+
+// Register makes a codec available to Lookup and Decode, replacing any codec with the same name
+func Register(c Codec) {
+	mu.Lock()
+	defer mu.Unlock()
+	codecs[c.Name()] = c
+}
+
+// Lookup returns the codec registered under name, or a registered codec with
+// compression for names like protobuf+snappy
+func Lookup(name string) (Codec, error) {
+	mu.RLock()
+	defer mu.RUnlock()
+	c, ok := codecs[name]
+	if !ok {
+		c, ok = lookupCompressed(name)
+	}
+	if !ok {
+		return nil, fmt.Errorf("codec: unknown codec %q, have %v", name, names())
+	}
+	return c, nil
+}
+
+// names lists the registered codecs, must be called with mu held
+func names() []string {
+	var list []string
+	for name := range codecs {
+		list = append(list, name)
+	}
+	sort.Strings(list)
+	return list
+}
+
+// Encode marshals v with c and frames it for the broker
+func Encode(c Codec, v *match.Vote) ([]byte, error) {
+	payload, err := c.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.Name() == JSON.Name() {
+		return payload, nil
+	}
+	name := c.Name()
+	if len(name) > 255 {
+		return nil, fmt.Errorf("codec: name %q is too long", name)
+	}
+	b := make([]byte, 0, 2+len(name)+len(payload))
+	b = append(b, 0, byte(len(name)))
+	b = append(b, name...)
+	return append(b, payload...), nil
+}
+
+// Decode unmarshals a message published by Encode into v, returning the codec it used
+func Decode(b []byte, v *match.Vote) (string, error) {
+	c := Codec(JSON)
+	if len(b) > 0 && b[0] == 0 {
+		if len(b) < 2 || len(b) < 2+int(b[1]) {
+			return "", errors.New("codec: truncated header")
+		}
+		var err error
+		name := string(b[2 : 2+int(b[1])])
+		if c, err = Lookup(name); err != nil {
+			return name, err
+		}
+		b = b[2+int(b[1]):]
+	}
+	return c.Name(), c.Unmarshal(b, v)
+}
@@ -0,0 +1,108 @@
+package codec
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// testVotes have every field the codecs carry set, a few at a time
+func testVotes() map[string]match.Vote {
+	plain := match.Vote{Option: "yes", Weight: 1}
+	plain.ID, plain.CreatedAt, plain.Text = "1", "Sun Mar 01 12:00:00 +0000 2020", "voting yes"
+
+	author := plain
+	author.User.Name, author.User.ScreenName = "Ada", "ada"
+	author.User.Verified, author.User.FollowersCount = true, 1200
+	author.Weight = 2.5
+	author.Geo = &match.Geo{Longitude: -0.12, Latitude: 51.5, Exact: true, PlaceID: "p1", Place: "London", CountryCode: "GB", Country: "United Kingdom", Region: "England"}
+
+	flags := plain
+	flags.Hashtag, flags.Suspect, flags.Retweet, flags.Quote, flags.Reply = true, true, true, true, true
+	flags.Embedded, flags.CaseFolded, flags.Partial = true, true, true
+	flags.MessageID, flags.Scale = "1/yes", 10
+	flags.Hit = &match.Hit{Term: "Yes", Start: 7, End: 10, Snippet: "voting Yes", SnippetStart: 0}
+
+	lists := plain
+	lists.Tenants, lists.Filtered, lists.Versions = []string{"acme", "globex"}, []string{"p2"}, []string{"p1:3"}
+	lists.Source, lists.Lang, lists.Folded, lists.Stemmed = "sms", "en", "yes", "ye"
+	lists.Count, lists.Window, lists.Instance, lists.Undelivered = 12, 60, "stream-1", 0.25
+
+	return map[string]match.Vote{"plain": plain, "author and geo": author, "flags and hit": flags, "lists": lists}
+}
+
+// testRegistry is a schema registry that knows AvroSchema as ID 1
+func testRegistry(t *testing.T) *Registry {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/subjects/"):
+			json.NewEncoder(w).Encode(map[string]int{"id": 1})
+		case r.URL.Path == "/schemas/ids/1":
+			json.NewEncoder(w).Encode(map[string]string{"schema": AvroSchema})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	t.Cleanup(srv.Close)
+	return NewRegistry(srv.URL, "votes-value", "", "")
+}
+
+func TestRoundTrip(t *testing.T) {
+	avro := NewAvro(testRegistry(t))
+	Register(avro)
+	codecs := []Codec{JSON, MsgPack, Protobuf, avro}
+	for _, c := range []Codec{JSON, Protobuf} {
+		for _, algo := range []string{Gzip, Snappy} {
+			compressed, err := Compress(c, algo)
+			if err != nil {
+				t.Fatal(err)
+			}
+			codecs = append(codecs, compressed)
+		}
+	}
+	for _, c := range codecs {
+		for name, want := range testVotes() {
+			t.Run(c.Name()+"/"+name, func(t *testing.T) {
+				b, err := Encode(c, &want)
+				if err != nil {
+					t.Fatal(err)
+				}
+				var got match.Vote
+				used, err := Decode(b, &got)
+				if err != nil {
+					t.Fatal(err)
+				}
+				if used != c.Name() {
+					t.Errorf("decoded with %s, want %s", used, c.Name())
+				}
+				if !reflect.DeepEqual(got, want) {
+					t.Errorf("got  %+v\nwant %+v", got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestDecodeFails(t *testing.T) {
+	tests := []struct {
+		name string
+		b    []byte
+	}{
+		{"truncated header", []byte{0, 9, 'm'}},
+		{"unknown codec", append([]byte{0, 4}, "nope{}"...)},
+		{"not json", []byte("{")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var v match.Vote
+			if _, err := Decode(tt.b, &v); err == nil {
+				t.Errorf("decoded %q", tt.b)
+			}
+		})
+	}
+}
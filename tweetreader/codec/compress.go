@@ -0,0 +1,112 @@
+package codec
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/golang/snappy"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// The algorithms payloads can be compressed with
+const (
+	Gzip   = "gzip"
+	Snappy = "snappy"
+)
+
+// maxDecompressed caps a decompressed vote, a vote is far smaller
+const maxDecompressed = 16 << 20
+
+var errTooLarge = errors.New("codec: decompressed vote is too large")
+
+// Compress returns c compressing its payloads with algo, gzip or snappy. It is
+// named c's name, a plus and algo, e.g. protobuf+snappy, so Decode finds it
+// for every registered codec.
+func Compress(c Codec, algo string) (Codec, error) {
+	switch algo {
+	case Gzip, Snappy:
+		return compressed{c, algo}, nil
+	default:
+		return nil, fmt.Errorf("codec: unknown compression %q, want gzip or snappy", algo)
+	}
+}
+
+type compressed struct {
+	Codec
+	algo string
+}
+
+func (c compressed) Name() string { return c.Codec.Name() + "+" + c.algo }
+
+func (c compressed) Marshal(v *match.Vote) ([]byte, error) {
+	payload, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	if c.algo == Snappy {
+		return snappy.Encode(nil, payload), nil
+	}
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(payload)
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (c compressed) Unmarshal(b []byte, v *match.Vote) error {
+	var payload []byte
+	if c.algo == Snappy {
+		n, err := snappy.DecodedLen(b)
+		if err != nil {
+			return err
+		}
+		if n > maxDecompressed {
+			return errTooLarge
+		}
+		if payload, err = snappy.Decode(nil, b); err != nil {
+			return err
+		}
+	} else {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return err
+		}
+		if payload, err = ioutil.ReadAll(io.LimitReader(r, maxDecompressed+1)); err != nil {
+			return err
+		}
+		if len(payload) > maxDecompressed {
+			return errTooLarge
+		}
+	}
+	return c.Codec.Unmarshal(payload, v)
+}
+
+// lookupCompressed finds the codec named base+algo, or base+aesgcm for an
+// encrypted one whose base may be compressed; must be called with mu held
+func lookupCompressed(name string) (Codec, bool) {
+	i := strings.LastIndexByte(name, '+')
+	if i < 0 {
+		return nil, false
+	}
+	base, ok := codecs[name[:i]]
+	if name[i+1:] == AESGCM {
+		if !ok {
+			base, ok = lookupCompressed(name[:i])
+		}
+		// even without keys, so the error says what is missing
+		return encrypted{base}, ok
+	}
+	if !ok {
+		return nil, false
+	}
+	c, err := Compress(base, name[i+1:])
+	return c, err == nil
+}
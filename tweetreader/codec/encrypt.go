@@ -0,0 +1,203 @@
+package codec
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// AESGCM is the encryption payloads can be sealed with
+const AESGCM = "aesgcm"
+
+var (
+	errNoKeys    = errors.New("codec: no keys to encrypt or decrypt the vote with, see SetKeyring")
+	errTruncated = errors.New("codec: truncated encrypted vote")
+)
+
+// Keyring holds the keys votes are encrypted and decrypted with, by ID. The
+// current key encrypts, any of them decrypts, so a key can be replaced while
+// messages sealed with the previous one are still queued.
+type Keyring struct {
+	mu      sync.RWMutex
+	keys    map[string]cipher.AEAD
+	current string
+}
+
+// ParseKeyring reads keys written id:base64key and separated by commas, the
+// first one current: k2:c2VjcmV0...,k1:b2xk... Every key is 16, 24 or 32 bytes,
+// for AES-128, AES-192 or AES-256.
+func ParseKeyring(s string) (*Keyring, error) {
+	k := &Keyring{}
+	if err := k.Update(s); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// Update replaces the keys with the ones in s, written as for ParseKeyring;
+// the keys are kept when s is invalid
+func (k *Keyring) Update(s string) error {
+	keys := make(map[string]cipher.AEAD)
+	var current string
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		i := strings.IndexByte(entry, ':')
+		if i <= 0 || i > 255 {
+			return fmt.Errorf("codec: invalid key %q, want id:base64key", redact(entry))
+		}
+		id := entry[:i]
+		raw, err := base64.StdEncoding.DecodeString(entry[i+1:])
+		if err != nil {
+			return fmt.Errorf("codec: key %s isn't base64: %v", id, err)
+		}
+		block, err := aes.NewCipher(raw)
+		if err != nil {
+			return fmt.Errorf("codec: key %s: %v", id, err)
+		}
+		aead, err := cipher.NewGCM(block)
+		if err != nil {
+			return fmt.Errorf("codec: key %s: %v", id, err)
+		}
+		if _, ok := keys[id]; ok {
+			return fmt.Errorf("codec: key %s given twice", id)
+		}
+		keys[id] = aead
+		if current == "" {
+			current = id
+		}
+	}
+	if current == "" {
+		return errors.New("codec: no encryption key")
+	}
+	k.mu.Lock()
+	k.keys, k.current = keys, current
+	k.mu.Unlock()
+	return nil
+}
+
+// redact keeps a key out of the errors, only its ID is shown
+func redact(entry string) string {
+	if i := strings.IndexByte(entry, ':'); i >= 0 {
+		return entry[:i] + ":..."
+	}
+	return "..."
+}
+
+// Current returns the ID of the key votes are encrypted with
+func (k *Keyring) Current() string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.current
+}
+
+// seal encrypts payload with the current key, binding it to aad:
+//
+//	len(id) id nonce ciphertext
+func (k *Keyring) seal(payload, aad []byte) ([]byte, error) {
+	k.mu.RLock()
+	id, aead := k.current, k.keys[k.current]
+	k.mu.RUnlock()
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 1+len(id)+len(nonce)+len(payload)+aead.Overhead())
+	b = append(b, byte(len(id)))
+	b = append(b, id...)
+	b = append(b, nonce...)
+	return aead.Seal(b, nonce, payload, aad), nil
+}
+
+// open decrypts what seal encrypted with any of the keys
+func (k *Keyring) open(b, aad []byte) ([]byte, error) {
+	if len(b) < 1 || len(b) < 1+int(b[0]) {
+		return nil, errTruncated
+	}
+	id := string(b[1 : 1+int(b[0])])
+	b = b[1+int(b[0]):]
+	k.mu.RLock()
+	aead, ok := k.keys[id]
+	k.mu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("codec: no key %s to decrypt the vote with", id)
+	}
+	if len(b) < aead.NonceSize() {
+		return nil, errTruncated
+	}
+	payload, err := aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], aad)
+	if err != nil {
+		return nil, fmt.Errorf("codec: vote sealed with key %s can't be decrypted: %v", id, err)
+	}
+	return payload, nil
+}
+
+// keyring encrypts and decrypts the votes, guarded by mu
+var keyring *Keyring
+
+// SetKeyring sets the keys the encrypted codecs use, the encrypted votes
+// fail to encode and decode until it is
+func SetKeyring(k *Keyring) {
+	mu.Lock()
+	defer mu.Unlock()
+	keyring = k
+}
+
+func currentKeyring() *Keyring {
+	mu.RLock()
+	defer mu.RUnlock()
+	return keyring
+}
+
+// Encrypt returns c encrypting its payloads with algo, aesgcm, and the current
+// key set with SetKeyring. It is named c's name, a plus and algo, e.g.
+// protobuf+snappy+aesgcm, so Decode finds it for every registered codec. The
+// codec's name is authenticated along with the payload.
+func Encrypt(c Codec, algo string) (Codec, error) {
+	if algo != AESGCM {
+		return nil, fmt.Errorf("codec: unknown encryption %q, want aesgcm", algo)
+	}
+	if currentKeyring() == nil {
+		return nil, errNoKeys
+	}
+	return encrypted{c}, nil
+}
+
+type encrypted struct {
+	Codec
+}
+
+func (c encrypted) Name() string { return c.Codec.Name() + "+" + AESGCM }
+
+func (c encrypted) Marshal(v *match.Vote) ([]byte, error) {
+	k := currentKeyring()
+	if k == nil {
+		return nil, errNoKeys
+	}
+	payload, err := c.Codec.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return k.seal(payload, []byte(c.Codec.Name()))
+}
+
+func (c encrypted) Unmarshal(b []byte, v *match.Vote) error {
+	k := currentKeyring()
+	if k == nil {
+		return errNoKeys
+	}
+	payload, err := k.open(b, []byte(c.Codec.Name()))
+	if err != nil {
+		return err
+	}
+	return c.Codec.Unmarshal(payload, v)
+}
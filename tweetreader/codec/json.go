@@ -0,0 +1,22 @@
+package codec
+
+import (
+	"encoding/json"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// JSON is the original vote format, the tweet fields at the top level next to option and weight
+var JSON Codec = jsonCodec{}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Name() string { return "json" }
+
+func (jsonCodec) Marshal(v *match.Vote) ([]byte, error) {
+	return json.Marshal(v)
+}
+
+func (jsonCodec) Unmarshal(b []byte, v *match.Vote) error {
+	return json.Unmarshal(b, v)
+}
@@ -0,0 +1,709 @@
+package codec
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// MsgPack encodes votes as a MessagePack map with the same keys as the JSON format.
+// Only the types a vote needs are implemented; unknown keys are skipped when decoding.
+var MsgPack Codec = msgpackCodec{}
+
+type msgpackCodec struct{}
+
+func (msgpackCodec) Name() string { return "msgpack" }
+
+func (msgpackCodec) Marshal(v *match.Vote) ([]byte, error) {
+	var e mpEncoder
+	fields := 6
+	if v.Geo != nil {
+		fields++
+	}
+	if v.Hit != nil {
+		fields++
+	}
+	if len(v.Tenants) > 0 {
+		fields++
+	}
+	if v.Source != "" {
+		fields++
+	}
+	if v.Lang != "" {
+		fields++
+	}
+	if v.Folded != "" {
+		fields++
+	}
+	if v.Stemmed != "" {
+		fields++
+	}
+	if v.Instance != "" {
+		fields++
+	}
+	if len(v.Filtered) > 0 {
+		fields++
+	}
+	if v.Undelivered != 0 {
+		fields++
+	}
+	if len(v.Versions) > 0 {
+		fields++
+	}
+	if v.Hashtag {
+		fields++
+	}
+	if v.MessageID != "" {
+		fields++
+	}
+	if v.Suspect {
+		fields++
+	}
+	if v.Scale != 0 {
+		fields++
+	}
+	if v.Count != 0 {
+		fields += 2
+	}
+	for _, set := range []bool{v.Retweet, v.Quote, v.Reply, v.Embedded, v.CaseFolded, v.Partial} {
+		if set {
+			fields++
+		}
+	}
+	e.mapHeader(fields)
+	e.str("id_str")
+	e.str(v.ID)
+	e.str("created_at")
+	e.str(v.CreatedAt)
+	e.str("text")
+	e.str(v.Text)
+	e.str("user")
+	e.mapHeader(4)
+	e.str("name")
+	e.str(v.User.Name)
+	e.str("screen_name")
+	e.str(v.User.ScreenName)
+	e.str("verified")
+	e.bool(v.User.Verified)
+	e.str("followers_count")
+	e.int(int64(v.User.FollowersCount))
+	e.str("option")
+	e.str(v.Option)
+	e.str("weight")
+	e.float(v.Weight)
+	if g := v.Geo; g != nil {
+		e.str("geo")
+		e.mapHeader(8)
+		e.str("longitude")
+		e.float(g.Longitude)
+		e.str("latitude")
+		e.float(g.Latitude)
+		e.str("exact")
+		e.bool(g.Exact)
+		e.str("place_id")
+		e.str(g.PlaceID)
+		e.str("place")
+		e.str(g.Place)
+		e.str("country_code")
+		e.str(g.CountryCode)
+		e.str("country")
+		e.str(g.Country)
+		e.str("region")
+		e.str(g.Region)
+	}
+	if v.Hashtag {
+		e.str("hashtag")
+		e.bool(true)
+	}
+	if v.MessageID != "" {
+		e.str("message_id")
+		e.str(v.MessageID)
+	}
+	if v.Suspect {
+		e.str("suspect")
+		e.bool(true)
+	}
+	if v.Scale != 0 {
+		e.str("scale")
+		e.int(int64(v.Scale))
+	}
+	if v.Retweet {
+		e.str("retweet")
+		e.bool(true)
+	}
+	if v.Quote {
+		e.str("quote")
+		e.bool(true)
+	}
+	if v.Reply {
+		e.str("reply")
+		e.bool(true)
+	}
+	if v.Embedded {
+		e.str("embedded")
+		e.bool(true)
+	}
+	if v.CaseFolded {
+		e.str("case_folded")
+		e.bool(true)
+	}
+	if v.Partial {
+		e.str("partial")
+		e.bool(true)
+	}
+	if h := v.Hit; h != nil {
+		e.str("hit")
+		e.mapHeader(5)
+		e.str("term")
+		e.str(h.Term)
+		e.str("start")
+		e.int(int64(h.Start))
+		e.str("end")
+		e.int(int64(h.End))
+		e.str("snippet")
+		e.str(h.Snippet)
+		e.str("snippet_start")
+		e.int(int64(h.SnippetStart))
+	}
+	if len(v.Tenants) > 0 {
+		e.str("tenants")
+		e.arrayHeader(len(v.Tenants))
+		for _, t := range v.Tenants {
+			e.str(t)
+		}
+	}
+	if v.Source != "" {
+		e.str("source")
+		e.str(v.Source)
+	}
+	if v.Lang != "" {
+		e.str("lang")
+		e.str(v.Lang)
+	}
+	if v.Folded != "" {
+		e.str("folded")
+		e.str(v.Folded)
+	}
+	if v.Stemmed != "" {
+		e.str("stemmed")
+		e.str(v.Stemmed)
+	}
+	if v.Count != 0 {
+		e.str("count")
+		e.int(int64(v.Count))
+		e.str("window")
+		e.int(int64(v.Window))
+	}
+	if v.Instance != "" {
+		e.str("instance")
+		e.str(v.Instance)
+	}
+	if len(v.Filtered) > 0 {
+		e.str("filtered")
+		e.arrayHeader(len(v.Filtered))
+		for _, p := range v.Filtered {
+			e.str(p)
+		}
+	}
+	if v.Undelivered != 0 {
+		e.str("undelivered")
+		e.float(v.Undelivered)
+	}
+	if len(v.Versions) > 0 {
+		e.str("versions")
+		e.arrayHeader(len(v.Versions))
+		for _, t := range v.Versions {
+			e.str(t)
+		}
+	}
+	return e.b, nil
+}
+
+func (msgpackCodec) Unmarshal(b []byte, v *match.Vote) error {
+	d := mpDecoder{b: b}
+	err := d.fields(func(key string) error {
+		var err error
+		switch key {
+		case "id_str":
+			v.ID, err = d.str()
+		case "created_at":
+			v.CreatedAt, err = d.str()
+		case "text":
+			v.Text, err = d.str()
+		case "option":
+			v.Option, err = d.str()
+		case "weight":
+			v.Weight, err = d.float()
+		case "hashtag":
+			v.Hashtag, err = d.bool()
+		case "message_id":
+			v.MessageID, err = d.str()
+		case "suspect":
+			v.Suspect, err = d.bool()
+		case "scale":
+			var n int64
+			n, err = d.int()
+			v.Scale = int(n)
+		case "count":
+			var n int64
+			n, err = d.int()
+			v.Count = int(n)
+		case "window":
+			var n int64
+			n, err = d.int()
+			v.Window = int(n)
+		case "retweet":
+			v.Retweet, err = d.bool()
+		case "quote":
+			v.Quote, err = d.bool()
+		case "reply":
+			v.Reply, err = d.bool()
+		case "embedded":
+			v.Embedded, err = d.bool()
+		case "case_folded":
+			v.CaseFolded, err = d.bool()
+		case "partial":
+			v.Partial, err = d.bool()
+		case "user":
+			err = d.fields(func(key string) error {
+				var err error
+				switch key {
+				case "name":
+					v.User.Name, err = d.str()
+				case "screen_name":
+					v.User.ScreenName, err = d.str()
+				case "verified":
+					v.User.Verified, err = d.bool()
+				case "followers_count":
+					var n int64
+					n, err = d.int()
+					v.User.FollowersCount = int(n)
+				default:
+					err = d.skip()
+				}
+				return err
+			})
+		case "geo":
+			g := &match.Geo{}
+			v.Geo = g
+			err = d.fields(func(key string) error {
+				var err error
+				switch key {
+				case "longitude":
+					g.Longitude, err = d.float()
+				case "latitude":
+					g.Latitude, err = d.float()
+				case "exact":
+					g.Exact, err = d.bool()
+				case "place_id":
+					g.PlaceID, err = d.str()
+				case "place":
+					g.Place, err = d.str()
+				case "country_code":
+					g.CountryCode, err = d.str()
+				case "country":
+					g.Country, err = d.str()
+				case "region":
+					g.Region, err = d.str()
+				default:
+					err = d.skip()
+				}
+				return err
+			})
+		case "source":
+			v.Source, err = d.str()
+		case "lang":
+			v.Lang, err = d.str()
+		case "folded":
+			v.Folded, err = d.str()
+		case "stemmed":
+			v.Stemmed, err = d.str()
+		case "instance":
+			v.Instance, err = d.str()
+		case "tenants":
+			v.Tenants = nil
+			err = d.items(func() error {
+				t, err := d.str()
+				v.Tenants = append(v.Tenants, t)
+				return err
+			})
+		case "undelivered":
+			v.Undelivered, err = d.float()
+		case "filtered":
+			v.Filtered = nil
+			err = d.items(func() error {
+				p, err := d.str()
+				v.Filtered = append(v.Filtered, p)
+				return err
+			})
+		case "versions":
+			v.Versions = nil
+			err = d.items(func() error {
+				t, err := d.str()
+				v.Versions = append(v.Versions, t)
+				return err
+			})
+		case "hit":
+			h := &match.Hit{}
+			v.Hit = h
+			err = d.fields(func(key string) error {
+				var err error
+				var n int64
+				switch key {
+				case "term":
+					h.Term, err = d.str()
+				case "start":
+					n, err = d.int()
+					h.Start = int(n)
+				case "end":
+					n, err = d.int()
+					h.End = int(n)
+				case "snippet":
+					h.Snippet, err = d.str()
+				case "snippet_start":
+					n, err = d.int()
+					h.SnippetStart = int(n)
+				default:
+					err = d.skip()
+				}
+				return err
+			})
+		default:
+			err = d.skip()
+		}
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("msgpack: %v", err)
+	}
+	return nil
+}
+
+// mpEncoder appends MessagePack values to b
+type mpEncoder struct {
+	b []byte
+}
+
+func (e *mpEncoder) mapHeader(n int) {
+	if n < 16 {
+		e.b = append(e.b, 0x80|byte(n))
+		return
+	}
+	e.b = append(e.b, 0xde)
+	e.b = appendUint(e.b, uint64(n), 2)
+}
+
+func (e *mpEncoder) arrayHeader(n int) {
+	if n < 16 {
+		e.b = append(e.b, 0x90|byte(n))
+		return
+	}
+	e.b = append(e.b, 0xdc)
+	e.b = appendUint(e.b, uint64(n), 2)
+}
+
+func (e *mpEncoder) str(s string) {
+	switch n := len(s); {
+	case n < 32:
+		e.b = append(e.b, 0xa0|byte(n))
+	case n <= math.MaxUint8:
+		e.b = append(e.b, 0xd9, byte(n))
+	case n <= math.MaxUint16:
+		e.b = append(e.b, 0xda)
+		e.b = appendUint(e.b, uint64(n), 2)
+	default:
+		e.b = append(e.b, 0xdb)
+		e.b = appendUint(e.b, uint64(n), 4)
+	}
+	e.b = append(e.b, s...)
+}
+
+func (e *mpEncoder) bool(v bool) {
+	if v {
+		e.b = append(e.b, 0xc3)
+	} else {
+		e.b = append(e.b, 0xc2)
+	}
+}
+
+func (e *mpEncoder) int(n int64) {
+	if n >= 0 && n < 128 {
+		e.b = append(e.b, byte(n))
+		return
+	}
+	e.b = append(e.b, 0xd3)
+	e.b = appendUint(e.b, uint64(n), 8)
+}
+
+func (e *mpEncoder) float(f float64) {
+	e.b = append(e.b, 0xcb)
+	e.b = appendUint(e.b, math.Float64bits(f), 8)
+}
+
+// appendUint appends the low size bytes of u, big endian
+func appendUint(b []byte, u uint64, size int) []byte {
+	for i := size - 1; i >= 0; i-- {
+		b = append(b, byte(u>>(8*uint(i))))
+	}
+	return b
+}
+
+var errShort = errors.New("unexpected end of message")
+
+// mpDecoder reads MessagePack values from b
+type mpDecoder struct {
+	b []byte
+}
+
+func (d *mpDecoder) next(n int) ([]byte, error) {
+	if len(d.b) < n {
+		return nil, errShort
+	}
+	p := d.b[:n]
+	d.b = d.b[n:]
+	return p, nil
+}
+
+func (d *mpDecoder) byte() (byte, error) {
+	p, err := d.next(1)
+	if err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
+
+// length reads an n byte big endian length
+func (d *mpDecoder) length(n int) (int, error) {
+	p, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	switch n {
+	case 1:
+		return int(p[0]), nil
+	case 2:
+		return int(binary.BigEndian.Uint16(p)), nil
+	default:
+		return int(binary.BigEndian.Uint32(p)), nil
+	}
+}
+
+// fields calls fn for every key of a map, fn must consume the value
+func (d *mpDecoder) fields(fn func(key string) error) error {
+	t, err := d.byte()
+	if err != nil {
+		return err
+	}
+	var n int
+	switch {
+	case t&0xf0 == 0x80:
+		n = int(t & 0x0f)
+	case t == 0xde:
+		n, err = d.length(2)
+	case t == 0xdf:
+		n, err = d.length(4)
+	default:
+		return fmt.Errorf("expected a map, got 0x%02x", t)
+	}
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		key, err := d.str()
+		if err != nil {
+			return err
+		}
+		if err := fn(key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// items calls fn for every item of an array, fn must consume the item
+func (d *mpDecoder) items(fn func() error) error {
+	t, err := d.byte()
+	if err != nil {
+		return err
+	}
+	var n int
+	switch {
+	case t&0xf0 == 0x90:
+		n = int(t & 0x0f)
+	case t == 0xdc:
+		n, err = d.length(2)
+	case t == 0xdd:
+		n, err = d.length(4)
+	case t == 0xc0:
+		return nil
+	default:
+		return fmt.Errorf("expected an array, got 0x%02x", t)
+	}
+	if err != nil {
+		return err
+	}
+	for i := 0; i < n; i++ {
+		if err := fn(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *mpDecoder) str() (string, error) {
+	t, err := d.byte()
+	if err != nil {
+		return "", err
+	}
+	var n int
+	switch {
+	case t&0xe0 == 0xa0:
+		n = int(t & 0x1f)
+	case t == 0xd9:
+		n, err = d.length(1)
+	case t == 0xda:
+		n, err = d.length(2)
+	case t == 0xdb:
+		n, err = d.length(4)
+	case t == 0xc0:
+		return "", nil
+	default:
+		return "", fmt.Errorf("expected a string, got 0x%02x", t)
+	}
+	if err != nil {
+		return "", err
+	}
+	p, err := d.next(n)
+	return string(p), err
+}
+
+func (d *mpDecoder) bool() (bool, error) {
+	t, err := d.byte()
+	switch {
+	case err != nil:
+		return false, err
+	case t == 0xc3:
+		return true, nil
+	case t == 0xc2, t == 0xc0:
+		return false, nil
+	}
+	return false, fmt.Errorf("expected a bool, got 0x%02x", t)
+}
+
+func (d *mpDecoder) int() (int64, error) {
+	t, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch {
+	case t < 0x80:
+		return int64(t), nil
+	case t >= 0xe0:
+		return int64(int8(t)), nil
+	case t == 0xc0:
+		return 0, nil
+	}
+	sizes := map[byte]int{0xcc: 1, 0xcd: 2, 0xce: 4, 0xcf: 8, 0xd0: 1, 0xd1: 2, 0xd2: 4, 0xd3: 8}
+	n, ok := sizes[t]
+	if !ok {
+		return 0, fmt.Errorf("expected an integer, got 0x%02x", t)
+	}
+	p, err := d.next(n)
+	if err != nil {
+		return 0, err
+	}
+	var u uint64
+	for _, c := range p {
+		u = u<<8 | uint64(c)
+	}
+	if t >= 0xd0 { // signed, extend the sign of shorter encodings
+		shift := uint(64 - 8*n)
+		return int64(u<<shift) >> shift, nil
+	}
+	return int64(u), nil
+}
+
+func (d *mpDecoder) float() (float64, error) {
+	t, err := d.byte()
+	if err != nil {
+		return 0, err
+	}
+	switch t {
+	case 0xcb:
+		p, err := d.next(8)
+		if err != nil {
+			return 0, err
+		}
+		return math.Float64frombits(binary.BigEndian.Uint64(p)), nil
+	case 0xca:
+		p, err := d.next(4)
+		if err != nil {
+			return 0, err
+		}
+		return float64(math.Float32frombits(binary.BigEndian.Uint32(p))), nil
+	}
+	// integers are valid weights too
+	d.b = append([]byte{t}, d.b...)
+	n, err := d.int()
+	return float64(n), err
+}
+
+// skip consumes one value of any type
+func (d *mpDecoder) skip() error {
+	t, err := d.byte()
+	if err != nil {
+		return err
+	}
+	var n, items int
+	switch {
+	case t < 0x80, t >= 0xe0, t == 0xc0, t == 0xc2, t == 0xc3:
+		return nil
+	case t&0xf0 == 0x80:
+		items = 2 * int(t&0x0f)
+	case t&0xf0 == 0x90:
+		items = int(t & 0x0f)
+	case t&0xe0 == 0xa0:
+		n = int(t & 0x1f)
+	case t == 0xcc, t == 0xd0:
+		n = 1
+	case t == 0xcd, t == 0xd1:
+		n = 2
+	case t == 0xce, t == 0xd2, t == 0xca:
+		n = 4
+	case t == 0xcf, t == 0xd3, t == 0xcb:
+		n = 8
+	case t == 0xd9, t == 0xc4:
+		n, err = d.length(1)
+	case t == 0xda, t == 0xc5:
+		n, err = d.length(2)
+	case t == 0xdb, t == 0xc6:
+		n, err = d.length(4)
+	case t == 0xdc:
+		items, err = d.length(2)
+	case t == 0xdd:
+		items, err = d.length(4)
+	case t == 0xde:
+		items, err = d.length(2)
+		items *= 2
+	case t == 0xdf:
+		items, err = d.length(4)
+		items *= 2
+	default:
+		return fmt.Errorf("unsupported type 0x%02x", t)
+	}
+	if err != nil {
+		return err
+	}
+	if _, err := d.next(n); err != nil {
+		return err
+	}
+	for i := 0; i < items; i++ {
+		if err := d.skip(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
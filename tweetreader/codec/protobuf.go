@@ -0,0 +1,333 @@
+package codec
+
+import (
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// Protobuf encodes votes as the Vote message in votesapi/votes.proto,
+// so services generating code from that file can decode them directly.
+// The wire format is written by hand to keep protobuf out of the default build.
+var Protobuf Codec = protobufCodec{}
+
+type protobufCodec struct{}
+
+func (protobufCodec) Name() string { return "protobuf" }
+
+// protobuf wire types
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+	wireFixed32 = 5
+)
+
+func (protobufCodec) Marshal(v *match.Vote) ([]byte, error) {
+	var user []byte
+	user = pbString(user, 1, v.User.Name)
+	user = pbString(user, 2, v.User.ScreenName)
+	if v.User.Verified {
+		user = pbVarint(pbTag(user, 3, wireVarint), 1)
+	}
+	if v.User.FollowersCount != 0 {
+		user = pbVarint(pbTag(user, 4, wireVarint), uint64(v.User.FollowersCount))
+	}
+
+	var b []byte
+	b = pbString(b, 1, v.ID)
+	b = pbString(b, 2, v.CreatedAt)
+	b = pbString(b, 3, v.Text)
+	if len(user) > 0 {
+		b = pbBytes(b, 4, user)
+	}
+	b = pbString(b, 5, v.Option)
+	if v.Weight != 0 {
+		b = pbDouble(b, 6, v.Weight)
+	}
+	if g := v.Geo; g != nil {
+		var geo []byte
+		geo = pbDouble(geo, 1, g.Longitude)
+		geo = pbDouble(geo, 2, g.Latitude)
+		if g.Exact {
+			geo = pbVarint(pbTag(geo, 3, wireVarint), 1)
+		}
+		geo = pbString(geo, 4, g.PlaceID)
+		geo = pbString(geo, 5, g.Place)
+		geo = pbString(geo, 6, g.CountryCode)
+		geo = pbString(geo, 7, g.Country)
+		geo = pbString(geo, 8, g.Region)
+		b = pbBytes(b, 7, geo)
+	}
+	if v.Hashtag {
+		b = pbVarint(pbTag(b, 8, wireVarint), 1)
+	}
+	b = pbString(b, 9, v.MessageID)
+	if v.Suspect {
+		b = pbVarint(pbTag(b, 10, wireVarint), 1)
+	}
+	if v.Scale != 0 {
+		b = pbVarint(pbTag(b, 11, wireVarint), uint64(v.Scale))
+	}
+	for i, set := range []bool{v.Retweet, v.Quote, v.Reply, v.Embedded, v.CaseFolded, v.Partial} {
+		if set {
+			b = pbVarint(pbTag(b, 12+i, wireVarint), 1)
+		}
+	}
+	if h := v.Hit; h != nil {
+		var hit []byte
+		hit = pbString(hit, 1, h.Term)
+		hit = pbVarint(pbTag(hit, 2, wireVarint), uint64(h.Start))
+		hit = pbVarint(pbTag(hit, 3, wireVarint), uint64(h.End))
+		hit = pbString(hit, 4, h.Snippet)
+		hit = pbVarint(pbTag(hit, 5, wireVarint), uint64(h.SnippetStart))
+		b = pbBytes(b, 18, hit)
+	}
+	for _, t := range v.Tenants {
+		b = pbString(b, 19, t)
+	}
+	b = pbString(b, 20, v.Source)
+	b = pbString(b, 21, v.Lang)
+	b = pbString(b, 22, v.Folded)
+	b = pbString(b, 23, v.Stemmed)
+	if v.Count != 0 {
+		b = pbVarint(pbTag(b, 24, wireVarint), uint64(v.Count))
+		b = pbVarint(pbTag(b, 25, wireVarint), uint64(v.Window))
+	}
+	b = pbString(b, 26, v.Instance)
+	for _, p := range v.Filtered {
+		b = pbString(b, 27, p)
+	}
+	if v.Undelivered != 0 {
+		b = pbDouble(b, 28, v.Undelivered)
+	}
+	for _, t := range v.Versions {
+		b = pbString(b, 29, t)
+	}
+	return b, nil
+}
+
+func (protobufCodec) Unmarshal(b []byte, v *match.Vote) error {
+	var userErr, geoErr, hitErr error
+	err := pbFields(b, func(field int, wire int, value uint64, data []byte) {
+		switch {
+		case field == 1 && wire == wireBytes:
+			v.ID = string(data)
+		case field == 2 && wire == wireBytes:
+			v.CreatedAt = string(data)
+		case field == 3 && wire == wireBytes:
+			v.Text = string(data)
+		case field == 4 && wire == wireBytes:
+			userErr = pbFields(data, func(field int, wire int, value uint64, data []byte) {
+				switch {
+				case field == 1 && wire == wireBytes:
+					v.User.Name = string(data)
+				case field == 2 && wire == wireBytes:
+					v.User.ScreenName = string(data)
+				case field == 3 && wire == wireVarint:
+					v.User.Verified = value != 0
+				case field == 4 && wire == wireVarint:
+					v.User.FollowersCount = int(int64(value))
+				}
+			})
+		case field == 5 && wire == wireBytes:
+			v.Option = string(data)
+		case field == 6 && wire == wireFixed64:
+			v.Weight = math.Float64frombits(value)
+		case field == 8 && wire == wireVarint:
+			v.Hashtag = value != 0
+		case field == 9 && wire == wireBytes:
+			v.MessageID = string(data)
+		case field == 10 && wire == wireVarint:
+			v.Suspect = value != 0
+		case field == 11 && wire == wireVarint:
+			v.Scale = int(int64(value))
+		case field == 12 && wire == wireVarint:
+			v.Retweet = value != 0
+		case field == 13 && wire == wireVarint:
+			v.Quote = value != 0
+		case field == 14 && wire == wireVarint:
+			v.Reply = value != 0
+		case field == 15 && wire == wireVarint:
+			v.Embedded = value != 0
+		case field == 16 && wire == wireVarint:
+			v.CaseFolded = value != 0
+		case field == 17 && wire == wireVarint:
+			v.Partial = value != 0
+		case field == 19 && wire == wireBytes:
+			v.Tenants = append(v.Tenants, string(data))
+		case field == 20 && wire == wireBytes:
+			v.Source = string(data)
+		case field == 21 && wire == wireBytes:
+			v.Lang = string(data)
+		case field == 22 && wire == wireBytes:
+			v.Folded = string(data)
+		case field == 23 && wire == wireBytes:
+			v.Stemmed = string(data)
+		case field == 24 && wire == wireVarint:
+			v.Count = int(int64(value))
+		case field == 25 && wire == wireVarint:
+			v.Window = int(int64(value))
+		case field == 26 && wire == wireBytes:
+			v.Instance = string(data)
+		case field == 27 && wire == wireBytes:
+			v.Filtered = append(v.Filtered, string(data))
+		case field == 28 && wire == wireFixed64:
+			v.Undelivered = math.Float64frombits(value)
+		case field == 29 && wire == wireBytes:
+			v.Versions = append(v.Versions, string(data))
+		case field == 18 && wire == wireBytes:
+			h := &match.Hit{}
+			v.Hit = h
+			hitErr = pbFields(data, func(field int, wire int, value uint64, data []byte) {
+				switch {
+				case field == 1 && wire == wireBytes:
+					h.Term = string(data)
+				case field == 2 && wire == wireVarint:
+					h.Start = int(int64(value))
+				case field == 3 && wire == wireVarint:
+					h.End = int(int64(value))
+				case field == 4 && wire == wireBytes:
+					h.Snippet = string(data)
+				case field == 5 && wire == wireVarint:
+					h.SnippetStart = int(int64(value))
+				}
+			})
+		case field == 7 && wire == wireBytes:
+			g := &match.Geo{}
+			v.Geo = g
+			geoErr = pbFields(data, func(field int, wire int, value uint64, data []byte) {
+				switch {
+				case field == 1 && wire == wireFixed64:
+					g.Longitude = math.Float64frombits(value)
+				case field == 2 && wire == wireFixed64:
+					g.Latitude = math.Float64frombits(value)
+				case field == 3 && wire == wireVarint:
+					g.Exact = value != 0
+				case field == 4 && wire == wireBytes:
+					g.PlaceID = string(data)
+				case field == 5 && wire == wireBytes:
+					g.Place = string(data)
+				case field == 6 && wire == wireBytes:
+					g.CountryCode = string(data)
+				case field == 7 && wire == wireBytes:
+					g.Country = string(data)
+				case field == 8 && wire == wireBytes:
+					g.Region = string(data)
+				}
+			})
+		}
+	})
+	if err != nil {
+		return fmt.Errorf("protobuf: %v", err)
+	}
+	if userErr != nil {
+		return fmt.Errorf("protobuf: user: %v", userErr)
+	}
+	if geoErr != nil {
+		return fmt.Errorf("protobuf: geo: %v", geoErr)
+	}
+	if hitErr != nil {
+		return fmt.Errorf("protobuf: hit: %v", hitErr)
+	}
+	return nil
+}
+
+func pbTag(b []byte, field, wire int) []byte {
+	return pbVarint(b, uint64(field)<<3|uint64(wire))
+}
+
+func pbVarint(b []byte, u uint64) []byte {
+	for u >= 0x80 {
+		b = append(b, byte(u)|0x80)
+		u >>= 7
+	}
+	return append(b, byte(u))
+}
+
+func pbBytes(b []byte, field int, data []byte) []byte {
+	b = pbVarint(pbTag(b, field, wireBytes), uint64(len(data)))
+	return append(b, data...)
+}
+
+// pbDouble writes a double field
+func pbDouble(b []byte, field int, f float64) []byte {
+	return appendUintLE(pbTag(b, field, wireFixed64), math.Float64bits(f))
+}
+
+// pbString writes a string field, leaving it out when empty as proto3 does
+func pbString(b []byte, field int, s string) []byte {
+	if s == "" {
+		return b
+	}
+	return pbBytes(b, field, []byte(s))
+}
+
+// appendUintLE appends the 8 bytes of u, little endian
+func appendUintLE(b []byte, u uint64) []byte {
+	for i := 0; i < 8; i++ {
+		b = append(b, byte(u>>(8*uint(i))))
+	}
+	return b
+}
+
+// readVarint decodes a varint from the start of b, returning its length
+func readVarint(b []byte) (uint64, int, error) {
+	var u uint64
+	for i := 0; i < len(b) && i < 10; i++ {
+		u |= uint64(b[i]&0x7f) << (7 * uint(i))
+		if b[i] < 0x80 {
+			return u, i + 1, nil
+		}
+	}
+	return 0, 0, errors.New("bad varint")
+}
+
+// pbFields calls fn for every field in a message, with the value for numeric wire types
+// and the data for length delimited ones
+func pbFields(b []byte, fn func(field int, wire int, value uint64, data []byte)) error {
+	for len(b) > 0 {
+		tag, n, err := readVarint(b)
+		if err != nil {
+			return err
+		}
+		b = b[n:]
+		field, wire := int(tag>>3), int(tag&7)
+		var value uint64
+		var data []byte
+		switch wire {
+		case wireVarint:
+			if value, n, err = readVarint(b); err != nil {
+				return err
+			}
+		case wireFixed64, wireFixed32:
+			n = 8
+			if wire == wireFixed32 {
+				n = 4
+			}
+			if len(b) < n {
+				return errShort
+			}
+			for i := n - 1; i >= 0; i-- {
+				value = value<<8 | uint64(b[i])
+			}
+		case wireBytes:
+			size, m, err := readVarint(b)
+			if err != nil {
+				return err
+			}
+			if uint64(len(b)-m) < size {
+				return errShort
+			}
+			data = b[m : m+int(size)]
+			n = m + int(size)
+		default:
+			return fmt.Errorf("unsupported wire type %d", wire)
+		}
+		fn(field, wire, value, data)
+		b = b[n:]
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package codec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Registry talks to a Confluent schema registry
+type Registry struct {
+	url      string
+	subject  string
+	user     string
+	password string
+	client   *http.Client
+
+	mu      sync.Mutex
+	schemas map[int32]string // the schemas looked up by ID, normalized
+}
+
+// NewRegistry creates a client for the registry at url registering schemas
+// under subject, with basic auth when user is set
+func NewRegistry(url, subject, user, password string) *Registry {
+	return &Registry{
+		url:      strings.TrimSuffix(url, "/"),
+		subject:  subject,
+		user:     user,
+		password: password,
+		client:   &http.Client{Timeout: 10 * time.Second},
+		schemas:  make(map[int32]string),
+	}
+}
+
+// Register registers schema under the subject, returning its ID. A schema
+// registered before keeps its ID.
+func (r *Registry) Register(schema string) (int32, error) {
+	body, err := json.Marshal(map[string]string{"schema": schema})
+	if err != nil {
+		return 0, err
+	}
+	var resp struct {
+		ID int32 `json:"id"`
+	}
+	if err := r.do("POST", "/subjects/"+url.PathEscape(r.subject)+"/versions", body, &resp); err != nil {
+		return 0, fmt.Errorf("codec: failed to register the schema under %s: %v", r.subject, err)
+	}
+	if normalized, err := normalizeSchema(schema); err == nil {
+		r.mu.Lock()
+		r.schemas[resp.ID] = normalized
+		r.mu.Unlock()
+	}
+	return resp.ID, nil
+}
+
+// Is reports whether the schema with id is schema, looking it up the first time
+func (r *Registry) Is(id int32, schema string) (bool, error) {
+	want, err := normalizeSchema(schema)
+	if err != nil {
+		return false, err
+	}
+	r.mu.Lock()
+	got, ok := r.schemas[id]
+	r.mu.Unlock()
+	if !ok {
+		var resp struct {
+			Schema string `json:"schema"`
+		}
+		if err := r.do("GET", fmt.Sprintf("/schemas/ids/%d", id), nil, &resp); err != nil {
+			return false, fmt.Errorf("codec: failed to look schema %d up: %v", id, err)
+		}
+		if got, err = normalizeSchema(resp.Schema); err != nil {
+			return false, fmt.Errorf("codec: schema %d: %v", id, err)
+		}
+		r.mu.Lock()
+		r.schemas[id] = got
+		r.mu.Unlock()
+	}
+	return got == want, nil
+}
+
+func (r *Registry) do(method, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, r.url+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/vnd.schemaregistry.v1+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/vnd.schemaregistry.v1+json")
+	}
+	if r.user != "" {
+		req.SetBasicAuth(r.user, r.password)
+	}
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("registry answered %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	return json.Unmarshal(b, v)
+}
+
+// normalizeSchema re-encodes a JSON schema, so schemas differing only in
+// whitespace or the order of their keys compare equal
+func normalizeSchema(schema string) (string, error) {
+	var v interface{}
+	if err := json.Unmarshal([]byte(schema), &v); err != nil {
+		return "", err
+	}
+	b, err := json.Marshal(v)
+	return string(b), err
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"log"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// config holds what was applied from CONFIG_FILE, a file of KEY=value lines
+// setting any of the environment variables. Variables set in the real
+// environment win over the file.
+var config struct {
+	once sync.Once
+	sync.Mutex
+	applied map[string]string // the values set from the file
+}
+
+// loadConfig applies CONFIG_FILE to the environment, the first time it is
+// called. The variables are read while the package initializes, so every env
+// helper calls it.
+func loadConfig() {
+	config.once.Do(func() {
+		if _, err := applyConfig(); err != nil {
+			log.Fatalln("failed to load CONFIG_FILE:", err)
+		}
+	})
+}
+
+// getenv is os.Getenv once the config file is applied
+func getenv(key string) string {
+	loadConfig()
+	return os.Getenv(key)
+}
+
+// reloadConfig applies CONFIG_FILE again, logging the names of the variables that changed
+func reloadConfig() error {
+	loadConfig()
+	changed, err := applyConfig()
+	if err != nil {
+		return err
+	}
+	if len(changed) == 0 {
+		log.Println("Configuration unchanged")
+		return nil
+	}
+	log.Printf("Configuration changed: %s", strings.Join(changed, ", "))
+	return nil
+}
+
+// applyConfig sets the variables of CONFIG_FILE that the environment doesn't,
+// and unsets the ones it set before that were since removed from the file
+func applyConfig() (changed []string, err error) {
+	path := os.Getenv("CONFIG_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	values, err := parseConfig(path)
+	if err != nil {
+		return nil, err
+	}
+	config.Lock()
+	defer config.Unlock()
+	applied := make(map[string]string, len(values))
+	for key, v := range values {
+		old, set := os.LookupEnv(key)
+		prev, fromFile := config.applied[key]
+		if set && (!fromFile || old != prev) {
+			continue // from the real environment
+		}
+		if !set || old != v {
+			os.Setenv(key, v)
+			changed = append(changed, key)
+		}
+		applied[key] = v
+	}
+	for key, prev := range config.applied {
+		if _, kept := values[key]; !kept && os.Getenv(key) == prev {
+			os.Unsetenv(key)
+			changed = append(changed, key)
+		}
+	}
+	config.applied = applied
+	sort.Strings(changed)
+	return changed, nil
+}
+
+// parseConfig reads KEY=value lines, skipping blank lines and # comments.
+// Values may be quoted and lines may start with export, like a shell file.
+func parseConfig(path string) (map[string]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	values := make(map[string]string)
+	scanner := bufio.NewScanner(f)
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		i := strings.IndexByte(line, '=')
+		if i <= 0 {
+			return nil, fmt.Errorf("%s:%d: want KEY=value", path, n)
+		}
+		key, v := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if len(v) >= 2 && (v[0] == '"' || v[0] == '\'') && v[len(v)-1] == v[0] {
+			v = v[1 : len(v)-1]
+		}
+		values[key] = v
+	}
+	return values, scanner.Err()
+}
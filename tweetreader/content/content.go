@@ -0,0 +1,172 @@
+// Package content keeps votes brand safe: it drops the votes of tweets with
+// blocked terms, such as slurs, and masks redacted terms, such as profanity,
+// in the text and hit snippet of the votes it lets through, before anything
+// downstream stores or forwards it.
+//
+// Terms are compared like options are, after textnorm.Fold, but only as
+// whole words: blocking "ass" doesn't drop a vote for "class".
+package content
+
+import (
+	"bufio"
+	"os"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+var (
+	dropped = metrics.NewCounter("tweetreader_content_dropped_total",
+		"Votes dropped because their tweet had a blocked term.")
+	redacted = metrics.NewCounter("tweetreader_content_redacted_total",
+		"Votes whose text had redacted terms masked.")
+)
+
+// Filter checks votes against the blocked and redacted terms
+type Filter struct {
+	mu       sync.RWMutex
+	blocked  *textnorm.Set
+	redacted *textnorm.Set
+}
+
+// New creates a Filter dropping the votes of tweets with any of blocked and
+// masking redacted in the text of the others
+func New(blocked, redacted []string) *Filter {
+	f := &Filter{}
+	f.Update(blocked, redacted)
+	return f
+}
+
+// Update replaces the terms
+func (f *Filter) Update(blocked, redacted []string) {
+	b, r := termSet(blocked), termSet(redacted)
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blocked, f.redacted = b, r
+}
+
+func termSet(terms []string) *textnorm.Set {
+	folded := make([]string, len(terms))
+	for i, t := range terms {
+		folded[i] = textnorm.Fold(strings.TrimSpace(t))
+	}
+	return textnorm.NewSet(folded)
+}
+
+// Apply reports whether v may go on, masking the redacted terms in its text
+func (f *Filter) Apply(v *match.Vote) bool {
+	f.mu.RLock()
+	blocked, toRedact := f.blocked, f.redacted
+	f.mu.RUnlock()
+	folded, offsets := textnorm.FoldOffsets(v.Text)
+	isBlocked := false
+	blocked.FindSpans(folded, func(term, start, end int) {
+		if wholeWord(folded, start, end) {
+			isBlocked = true
+		}
+	})
+	if isBlocked {
+		dropped.Inc()
+		return false
+	}
+	text, masked := redact(toRedact, v.Text, folded, offsets)
+	if v.Hit != nil {
+		var snippetMasked bool
+		folded, offsets := textnorm.FoldOffsets(v.Hit.Snippet)
+		if v.Hit.Snippet, snippetMasked = redact(toRedact, v.Hit.Snippet, folded, offsets); snippetMasked {
+			masked = true
+		}
+	}
+	if masked {
+		v.Text = text
+		redacted.Inc()
+	}
+	return true
+}
+
+// redact masks the terms of set in text, folded with its offsets, and
+// reports whether it had any
+func redact(set *textnorm.Set, text, folded string, offsets []int) (string, bool) {
+	var spans [][2]int // in text
+	set.FindSpans(folded, func(term, start, end int) {
+		if wholeWord(folded, start, end) {
+			spans = append(spans, [2]int{offsets[start], offsets[end]})
+		}
+	})
+	if len(spans) == 0 {
+		return text, false
+	}
+	return mask(text, spans), true
+}
+
+// Run passes on the votes from in that Apply lets through, the returned
+// channel is closed once in is
+func (f *Filter) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if f.Apply(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
+
+// wholeWord reports whether text[start:end] isn't part of a longer word
+func wholeWord(text string, start, end int) bool {
+	before, _ := utf8.DecodeLastRuneInString(text[:start])
+	after, _ := utf8.DecodeRuneInString(text[end:])
+	return !isWord(before) && !isWord(after)
+}
+
+func isWord(r rune) bool {
+	return r != utf8.RuneError && (unicode.IsLetter(r) || unicode.IsDigit(r))
+}
+
+// mask replaces every rune inside spans with *, spans may overlap
+func mask(text string, spans [][2]int) string {
+	var b strings.Builder
+	for i, r := range text {
+		masked := false
+		for _, s := range spans {
+			if i >= s[0] && i < s[1] {
+				masked = true
+				break
+			}
+		}
+		if masked {
+			b.WriteByte('*')
+		} else {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// ReadTerms reads a terms file, one term per line. Blank lines and lines
+// starting with # are skipped, and an empty path has no terms.
+func ReadTerms(path string) ([]string, error) {
+	if path == "" {
+		return nil, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	var terms []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if t := strings.TrimSpace(scanner.Text()); t != "" && !strings.HasPrefix(t, "#") {
+			terms = append(terms, t)
+		}
+	}
+	return terms, scanner.Err()
+}
@@ -0,0 +1,48 @@
+// Package control carries flow control signals from the counter back to the streamer.
+//
+// During an extreme spike the counter can fall behind the votes topic.
+// When a poll receives more votes than the counter is configured to absorb,
+// the counter publishes a Signal on the control topic asking every streamer
+// to sample the votes for that poll's options, or to slow down publishing them,
+// until the signal expires. Signals are advisory: a streamer that doesn't
+// listen keeps publishing everything, as before.
+package control
+
+import (
+	"encoding/json"
+	"time"
+)
+
+// Topic is the NSQ topic signals are published on
+const Topic = "control"
+
+// Actions a signal can ask for
+const (
+	// ActionSample keeps only Rate (0-1) of the votes for the options
+	ActionSample = "sample"
+	// ActionSlow publishes at most Rate votes per second for each option
+	ActionSlow = "slow"
+)
+
+// Signal asks streamers to ease off the votes for some options until it expires
+type Signal struct {
+	Poll    string    `json:"poll"`
+	Options []string  `json:"options"`
+	Action  string    `json:"action"`
+	Rate    float64   `json:"rate"`
+	Until   time.Time `json:"until"`
+}
+
+// Publisher is the part of publish.Publisher signals are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Send encodes and publishes a signal
+func Send(pub Publisher, sig Signal) error {
+	b, err := json.Marshal(sig)
+	if err != nil {
+		return err
+	}
+	return pub.Publish(b)
+}
@@ -0,0 +1,118 @@
+package control
+
+import (
+	"encoding/json"
+	"log"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	sampledOut = metrics.NewCounter("tweetreader_control_sampled_out_total",
+		"Votes dropped because the counter asked for sampling.")
+	delayed = metrics.NewCounter("tweetreader_control_delay_seconds_total",
+		"Time votes were held back because the counter asked to slow down.")
+)
+
+// Throttle applies the signals received from the counter to the votes on their way to the publisher
+type Throttle struct {
+	mu      sync.Mutex
+	now     func() time.Time
+	options map[string]*limit
+}
+
+// limit is the signal currently applying to one option
+type limit struct {
+	Signal
+	next time.Time // for ActionSlow, when the next vote may go out
+}
+
+// NewThrottle creates a throttle with no signals applied
+func NewThrottle() *Throttle {
+	return &Throttle{now: time.Now, options: make(map[string]*limit)}
+}
+
+// Apply starts honouring sig, replacing any earlier signal for the same options
+func (t *Throttle) Apply(sig Signal) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, option := range sig.Options {
+		t.options[option] = &limit{Signal: sig}
+	}
+	log.Printf("control: %s votes for poll %s at %v until %s", sig.Action, sig.Poll, sig.Rate, sig.Until.Format(time.RFC3339))
+}
+
+// HandleMessage applies a signal published on the control topic, it makes Throttle an nsq.Handler
+func (t *Throttle) HandleMessage(m *nsq.Message) error {
+	var sig Signal
+	if err := json.Unmarshal(m.Body, &sig); err != nil {
+		log.Println("Unmarshall error: ", err)
+		return nil
+	}
+	t.Apply(sig)
+	return nil
+}
+
+// admit decides what happens to a vote: it is dropped when keep is false,
+// otherwise it goes out after wait
+func (t *Throttle) admit(v *match.Vote) (keep bool, wait time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	l, ok := t.options[v.Option]
+	if !ok {
+		return true, 0
+	}
+	now := t.now()
+	if now.After(l.Until) {
+		delete(t.options, v.Option)
+		log.Printf("control: signal for poll %s expired", l.Poll)
+		return true, 0
+	}
+	if l.Rate <= 0 {
+		return true, 0
+	}
+	switch l.Action {
+	case ActionSample:
+		if l.Rate >= 1 || rand.Float64() < l.Rate {
+			// the kept votes stand in for the dropped ones in the weighted tally
+			v.Weight /= l.Rate
+			return true, 0
+		}
+		sampledOut.Inc("poll", l.Poll)
+		return false, 0
+	case ActionSlow:
+		if l.next.Before(now) {
+			l.next = now
+		}
+		wait = l.next.Sub(now)
+		delayed.Add(wait.Seconds(), "poll", l.Poll)
+		l.next = l.next.Add(time.Duration(float64(time.Second) / l.Rate))
+		return true, wait
+	}
+	return true, 0
+}
+
+// Run passes votes from in to the returned channel, sampled or delayed as the signals ask.
+// Delaying a vote holds up the ones behind it, which slows the stream reader down in turn.
+// The returned channel is closed once in is.
+func (t *Throttle) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			keep, wait := t.admit(&v)
+			if !keep {
+				continue
+			}
+			time.Sleep(wait)
+			out <- v
+		}
+	}()
+	return out
+}
@@ -0,0 +1,31 @@
+package count
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/aggregate"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// tallyMetrics scores v with the aggregators of each poll's type, must be called with countsLock held.
+// polls are the ones v counts for, see countedBy, so filtered polls only score the votes they count.
+func (c *Counter) tallyMetrics(v vote, av aggregate.Vote, polls []*store.Poll) {
+	for _, p := range polls {
+		aggregators := aggregate.For(p.Type)
+		if len(aggregators) == 0 {
+			continue
+		}
+		if c.computed == nil {
+			c.computed = make(map[string]map[string]map[string]float64)
+		}
+		metrics := c.computed[p.ID]
+		if metrics == nil {
+			metrics = make(map[string]map[string]float64)
+			c.computed[p.ID] = metrics
+		}
+		for _, a := range aggregators {
+			if metrics[a.Name] == nil {
+				metrics[a.Name] = make(map[string]float64)
+			}
+			metrics[a.Name][v.Option] += a.Score(p, av) * float64(v.votes())
+		}
+	}
+}
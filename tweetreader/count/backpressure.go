@@ -0,0 +1,55 @@
+package count
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/control"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// backPressure asks the streamers to ease off polls receiving more votes per second than
+// cfg.OverloadRate, at most once every half ControlHold per poll so signals don't pile up
+type backPressure struct {
+	cfg  Config
+	pub  control.Publisher
+	sent map[string]time.Time // when each poll was last signalled
+}
+
+func newBackPressure(cfg Config, pub control.Publisher) *backPressure {
+	return &backPressure{cfg: cfg, pub: pub, sent: make(map[string]time.Time)}
+}
+
+// observe checks the rate a poll received votes at over the last flush
+func (b *backPressure) observe(p *store.Poll, votes int, elapsed time.Duration, now time.Time) {
+	if b == nil || b.cfg.OverloadRate <= 0 || elapsed <= 0 || len(p.Options) == 0 {
+		return
+	}
+	rate := float64(votes) / elapsed.Seconds()
+	if rate <= b.cfg.OverloadRate || now.Sub(b.sent[p.ID]) < b.cfg.ControlHold/2 {
+		return
+	}
+	sig := control.Signal{
+		Poll:    p.ID,
+		Options: p.Options,
+		Action:  b.cfg.ControlAction,
+		Until:   now.Add(b.cfg.ControlHold),
+	}
+	switch sig.Action {
+	case control.ActionSlow:
+		sig.Rate = b.cfg.OverloadRate / float64(len(p.Options))
+	default:
+		sig.Action = control.ActionSample
+		sig.Rate = b.cfg.OverloadRate / rate
+	}
+	b.sent[p.ID] = now
+	log.Printf("poll %s is getting %.0f votes/s, asking streamers to %s at %v", p.ID, rate, sig.Action, sig.Rate)
+	b.cfg.Events.Emit(events.CountOverload, fmt.Sprintf("poll %s at %.0f votes/s", p.ID, rate))
+	go func() {
+		if err := control.Send(b.pub, sig); err != nil {
+			log.Println("failed to send control signal:", err)
+		}
+	}()
+}
@@ -0,0 +1,177 @@
+package count
+
+import (
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+var unwrittenPolls = metrics.NewGauge("tweetreader_count_unwritten_polls",
+	"Polls whose results a flush failed to write, written with the next flush.")
+
+// BatchConfig tunes how a flush writes the results, zero values keep the
+// results written poll by poll
+type BatchConfig struct {
+	// Size is how many polls' results one bulk write adds, on stores that
+	// can; 0 writes every poll's results with updates of its own
+	Size int
+	// Retries is how many times a bulk write failing to reach the database is
+	// tried again within the flush, and RetryWait the wait before the first
+	// retry, doubling with every one, 100ms by default. Counting waits for
+	// the flush meanwhile.
+	Retries   int
+	RetryWait time.Duration
+}
+
+func (bc BatchConfig) retryWait() time.Duration {
+	if bc.RetryWait <= 0 {
+		return 100 * time.Millisecond
+	}
+	return bc.RetryWait
+}
+
+// pollWrite is what a flush adds to the results of a poll
+type pollWrite struct {
+	poll     *store.Poll
+	update   store.ResultUpdate
+	weighted map[string]float64 // for the time series, whether or not the poll's results are weighted
+	at       time.Time          // of the flush
+}
+
+// points returns the time series points of w
+func (w pollWrite) points() []timeseries.Point {
+	var points []timeseries.Point
+	for option, n := range w.update.Results {
+		points = append(points, timeseries.Point{
+			Poll:     w.poll.ID,
+			Option:   option,
+			Time:     w.at,
+			Count:    n,
+			Weighted: w.weighted[option],
+			Timezone: w.poll.Timezone,
+		})
+	}
+	return points
+}
+
+// writeBulk writes the results of a flush in batches, after the ones earlier
+// flushes failed to write. What isn't written is kept for the next flush
+// rather than the tallies, so the polls written aren't added to twice. It
+// returns the points of the results written. Only used with countsLock held.
+func (c *Counter) writeBulk(writes []pollWrite) ([]timeseries.Point, error) {
+	writes = append(c.unwritten, writes...)
+	c.unwritten = nil
+	var points []timeseries.Point
+	for start := 0; start < len(writes); start += c.cfg.Batch.Size {
+		end := start + c.cfg.Batch.Size
+		if end > len(writes) {
+			end = len(writes)
+		}
+		batch := writes[start:end]
+		added, err := c.addBulk(batch)
+		for _, w := range batch[:added] {
+			c.notes.counted(w.poll, w.update.Results)
+			points = append(points, w.points()...)
+		}
+		if err != nil {
+			c.unwritten = append([]pollWrite(nil), writes[start+added:]...)
+			unwrittenPolls.Set(float64(len(c.unwritten)))
+			return points, err
+		}
+	}
+	unwrittenPolls.Set(0)
+	return points, nil
+}
+
+// addBulk adds a batch of results, trying again while it fails to reach the
+// database, and returns how many of the first were added
+func (c *Counter) addBulk(batch []pollWrite) (int, error) {
+	updates := make([]store.ResultUpdate, len(batch))
+	for i, w := range batch {
+		updates[i] = w.update
+	}
+	wait := c.cfg.Batch.retryWait()
+	added := 0
+	for attempt := 0; ; attempt++ {
+		n, err := c.bulk.AddResultsBulk(updates[added:])
+		added += n
+		if err == nil || !store.IsTransient(err) || attempt >= c.cfg.Batch.Retries {
+			return added, err
+		}
+		log.Printf("failed to write the results, retrying in %v: %v", wait, err)
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// writeEach writes the results of a flush poll by poll, after the ones earlier
+// flushes failed to write. A poll's results and its results by area, source,
+// language and metric are written apart: only what fails is kept for the next
+// flush, so what was written isn't added to twice. It returns the points of
+// the results written and the last error. Only used with countsLock held.
+func (c *Counter) writeEach(writes []pollWrite) ([]timeseries.Point, error) {
+	writes = append(c.unwritten, writes...)
+	c.unwritten = nil
+	var points []timeseries.Point
+	var failed error
+	for _, w := range writes {
+		left, written, err := c.writePoll(w)
+		if written {
+			c.notes.counted(w.poll, w.update.Results)
+			points = append(points, w.points()...)
+		}
+		if err != nil {
+			failed = err
+			c.unwritten = append(c.unwritten, left)
+		}
+	}
+	unwrittenPolls.Set(float64(len(c.unwritten)))
+	return points, failed
+}
+
+// writePoll writes w, returning what of it wasn't written, whether its
+// results were, and the last error
+func (c *Counter) writePoll(w pollWrite) (left pollWrite, written bool, err error) {
+	u := w.update
+	left = pollWrite{poll: w.poll, update: store.ResultUpdate{Poll: u.Poll}, weighted: w.weighted, at: w.at}
+	failed := func(what string, e error) {
+		log.Printf("failed to update %s of poll %s: %v", what, u.Poll, e)
+		err = e
+	}
+	if len(u.Results) > 0 || len(u.WeightedResults) > 0 {
+		if e := c.db.AddResults(u.Poll, u.Results, u.WeightedResults); e != nil {
+			failed("the results", e)
+			left.update.Results, left.update.WeightedResults = u.Results, u.WeightedResults
+		} else {
+			written = true
+		}
+	}
+	if len(u.GeoResults) > 0 {
+		if e := c.db.AddGeoResults(u.Poll, u.GeoResults); e != nil {
+			failed("the geo results", e)
+			left.update.GeoResults = u.GeoResults
+		}
+	}
+	if len(u.SourceResults) > 0 {
+		if e := c.db.AddSourceResults(u.Poll, u.SourceResults); e != nil {
+			failed("the source results", e)
+			left.update.SourceResults = u.SourceResults
+		}
+	}
+	if len(u.LanguageResults) > 0 {
+		if e := c.db.AddLanguageResults(u.Poll, u.LanguageResults); e != nil {
+			failed("the language results", e)
+			left.update.LanguageResults = u.LanguageResults
+		}
+	}
+	if len(u.Metrics) > 0 {
+		if e := c.db.AddMetrics(u.Poll, u.Metrics); e != nil {
+			failed("the metrics", e)
+			left.update.Metrics = u.Metrics
+		}
+	}
+	return left, written, err
+}
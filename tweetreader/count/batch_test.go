@@ -0,0 +1,87 @@
+package count
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/notify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// flakyStore fails its first geoFailures geo and sourceFailures source writes
+type flakyStore struct {
+	*store.Memory
+	geoFailures    int
+	sourceFailures int
+}
+
+func (s *flakyStore) AddGeoResults(id string, counts map[string]map[string]int) error {
+	if s.geoFailures > 0 {
+		s.geoFailures--
+		return errors.New("geo results unavailable")
+	}
+	return s.Memory.AddGeoResults(id, counts)
+}
+
+func (s *flakyStore) AddSourceResults(id string, counts map[string]map[string]int) error {
+	if s.sourceFailures > 0 {
+		s.sourceFailures--
+		return errors.New("source results unavailable")
+	}
+	return s.Memory.AddSourceResults(id, counts)
+}
+
+func TestWriteEachKeepsOnlyWhatFailed(t *testing.T) {
+	tests := []struct {
+		name           string
+		geoFailures    int
+		sourceFailures int
+		unwritten      []int // polls left unwritten after each flush
+	}{
+		{"written at once", 0, 0, []int{0, 0}},
+		{"geo results fail once", 1, 0, []int{1, 0}},
+		{"geo and source results fail once", 1, 1, []int{1, 0}},
+		{"geo results fail twice", 2, 0, []int{1, 1, 0}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &flakyStore{Memory: store.NewMemory(store.Poll{ID: "p", Options: []string{"yes", "no"}}), geoFailures: tt.geoFailures, sourceFailures: tt.sourceFailures}
+			c := &Counter{db: db, notes: newPollNotifier(db, notify.New())}
+			poll, _ := db.Poll("p")
+			flush := pollWrite{poll: &poll, at: time.Now(), update: store.ResultUpdate{Poll: "p"}}
+			flush.update.Results = map[string]int{"yes": 3, "no": 1}
+			flush.update.GeoResults = map[string]map[string]int{"GB": {"yes": 3}}
+			flush.update.SourceResults = map[string]map[string]int{"twitter": {"yes": 3, "no": 1}}
+
+			writes := []pollWrite{flush}
+			for i, want := range tt.unwritten {
+				points, err := c.writeEach(writes)
+				if got := len(c.unwritten); got != want {
+					t.Fatalf("flush %d: %d polls unwritten, want %d", i, got, want)
+				}
+				if (err != nil) != (want > 0) {
+					t.Fatalf("flush %d: err %v with %d polls unwritten", i, err, want)
+				}
+				if i == 0 && len(points) != 2 {
+					t.Fatalf("flush %d: %d points, want the 2 of the results written", i, len(points))
+				}
+				if i > 0 && len(points) != 0 {
+					t.Fatalf("flush %d: %d points for results written already", i, len(points))
+				}
+				writes = nil
+			}
+
+			got, _ := db.Poll("p")
+			if got.Results["yes"] != 3 || got.Results["no"] != 1 {
+				t.Errorf("results %v, want yes 3 and no 1 counted once", got.Results)
+			}
+			if got.GeoResults["GB"]["yes"] != 3 {
+				t.Errorf("geo results %v, want GB yes 3", got.GeoResults)
+			}
+			if got.SourceResults["twitter"]["yes"] != 3 {
+				t.Errorf("source results %v, want twitter yes 3", got.SourceResults)
+			}
+		})
+	}
+}
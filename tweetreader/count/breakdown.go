@@ -0,0 +1,52 @@
+package count
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/lang"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// breakdown holds counts per poll, then per source or language, then per option
+type breakdown map[string]map[string]map[string]int
+
+// add adds n votes for option under key to the counts of the poll
+func (b breakdown) add(poll, key, option string, n int) {
+	byKey := b[poll]
+	if byKey == nil {
+		byKey = make(map[string]map[string]int)
+		b[poll] = byKey
+	}
+	if byKey[key] == nil {
+		byKey[key] = make(map[string]int)
+	}
+	byKey[key][option] += n
+}
+
+// tallyBreakdowns adds the vote to the per-source and per-language tallies of the polls counting it
+func (c *Counter) tallyBreakdowns(v vote, polls []*store.Poll) {
+	source, language := breakdownKeys(v)
+	if c.sources == nil {
+		c.sources = make(breakdown)
+	}
+	if c.languages == nil {
+		c.languages = make(breakdown)
+	}
+	for _, p := range polls {
+		c.sources.add(p.ID, source, v.Option, v.votes())
+		c.languages.add(p.ID, language, v.Option, v.votes())
+	}
+}
+
+// breakdownKeys returns the source and the language v is tallied under
+func breakdownKeys(v vote) (source, language string) {
+	source = v.Source
+	if source == "" {
+		// streamers that predate sources only leave the ID to tell
+		source = stream.SourceOf(v.ID)
+	}
+	language = v.Lang
+	if language == "" {
+		language = lang.Undetermined
+	}
+	return source, language
+}
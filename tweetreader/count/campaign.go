@@ -0,0 +1,170 @@
+package count
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/notify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// campaignRefresh is how long a campaign is used before it's read again, so
+// changes to its notifications are picked up without a restart
+const campaignRefresh = time.Minute
+
+// knownCampaign is a campaign as last read, found is false when it doesn't exist
+type knownCampaign struct {
+	campaign store.Campaign
+	found    bool
+	read     time.Time
+}
+
+// campaignNote is the summary of a campaign to post
+type campaignNote struct {
+	campaign store.Campaign
+	recent   map[string]int // votes per poll since the last summary
+}
+
+// campaign returns the campaign id when it has notifications, reading it at
+// most every campaignRefresh; n.mu is held
+func (n *pollNotifier) campaign(id string) *store.Campaign {
+	if id == "" || n.campaigns == nil {
+		return nil
+	}
+	k, ok := n.known[id]
+	if !ok || time.Since(k.read) >= campaignRefresh {
+		c, err := n.campaigns.Campaign(id)
+		switch {
+		case err == nil:
+			k = knownCampaign{campaign: c, found: true, read: time.Now()}
+		case err == store.ErrNoCampaign:
+			k = knownCampaign{read: time.Now()}
+		default:
+			// keep what was read before, if anything, and try again next time
+			log.Println("notify: failed to load campaign", id, err)
+			k.read = time.Now()
+		}
+		n.known[id] = k
+	}
+	if !k.found || len(k.campaign.Notifications) == 0 {
+		return nil
+	}
+	return &k.campaign
+}
+
+// campaignVotes returns the total votes of every poll of campaign id, reading
+// them from the store on its first flush; n.mu is held
+func (n *pollNotifier) campaignVotes(id string) (map[string]int, error) {
+	if totals, ok := n.campaignTotals[id]; ok {
+		return totals, nil
+	}
+	polls, err := n.db.Polls()
+	if err != nil {
+		return nil, err
+	}
+	totals := make(map[string]int)
+	for _, p := range polls {
+		if p.Campaign == id {
+			totals[p.ID] = total(p.Results)
+		}
+	}
+	n.campaignTotals[id] = totals
+	return totals, nil
+}
+
+// campaignCounted is told about the delta votes just stored for poll, which
+// had before until then, to post the milestones of its campaign c; n.mu is held
+func (n *pollNotifier) campaignCounted(c *store.Campaign, poll string, before, delta int) {
+	totals, err := n.campaignVotes(c.ID)
+	if err != nil {
+		log.Println("notify: failed to load the polls of campaign", c.ID, err)
+		return
+	}
+	// the poll's stored total may already have included delta when it was read
+	totals[poll] = before
+	var from int
+	for _, v := range totals {
+		from += v
+	}
+	to := from + delta
+	totals[poll] = before + delta
+	for _, nf := range c.Notifications {
+		for _, m := range nf.Milestones {
+			if from < m && m <= to {
+				n.out.Send(notify.Message{Kind: nf.Kind, URL: nf.URL, Text: fmt.Sprintf("Campaign %q reached %d votes", campaignTitle(c), m)})
+			}
+		}
+		if nf.Hourly {
+			if n.campaignHourly[c.ID] == nil {
+				n.campaignHourly[c.ID] = make(map[string]int)
+			}
+			n.campaignHourly[c.ID][poll] += delta
+		}
+	}
+}
+
+// summaries returns the campaigns to summarize, the ones with votes since the
+// last summary, and starts over; n.mu is held
+func (n *pollNotifier) summaries() []campaignNote {
+	var notes []campaignNote
+	for id, recent := range n.campaignHourly {
+		if k := n.known[id]; k.found {
+			notes = append(notes, campaignNote{campaign: k.campaign, recent: recent})
+		}
+	}
+	n.campaignHourly = make(map[string]map[string]int)
+	return notes
+}
+
+// summarizeCampaigns posts the summaries of notes
+func (n *pollNotifier) summarizeCampaigns(notes []campaignNote) {
+	if len(notes) == 0 {
+		return
+	}
+	polls, err := n.db.Polls()
+	if err != nil {
+		log.Println("notify: failed to load the polls of the campaigns", err)
+		return
+	}
+	for _, note := range notes {
+		var in []store.Poll
+		for _, p := range polls {
+			if p.Campaign == note.campaign.ID {
+				in = append(in, p)
+			}
+		}
+		text := campaignSummary(&note.campaign, in, note.recent)
+		for _, nf := range note.campaign.Notifications {
+			if nf.Hourly {
+				n.out.Send(notify.Message{Kind: nf.Kind, URL: nf.URL, Text: text})
+			}
+		}
+	}
+}
+
+// campaignSummary lists the polls of c by total votes, with what they got in
+// the last hour
+func campaignSummary(c *store.Campaign, polls []store.Poll, recent map[string]int) string {
+	sort.SliceStable(polls, func(i, j int) bool { return total(polls[i].Results) > total(polls[j].Results) })
+	var all int
+	for _, p := range polls {
+		all += total(p.Results)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "Campaign %q: %d votes in the last hour, %d in total", campaignTitle(c), total(recent), all)
+	for _, p := range polls {
+		fmt.Fprintf(&b, "\n%s: %d (+%d)", p.Title, total(p.Results), recent[p.ID])
+	}
+	return b.String()
+}
+
+// campaignTitle is what c is called in the messages, its ID when untitled
+func campaignTitle(c *store.Campaign) string {
+	if c.Title != "" {
+		return c.Title
+	}
+	return c.ID
+}
@@ -0,0 +1,106 @@
+package count
+
+import (
+	"crypto/ed25519"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/certify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// CertifyConfig has the final results of the polls signed once they close, see the certify package
+type CertifyConfig struct {
+	Key      ed25519.PrivateKey // signs the certificates, nil disables them
+	Interval time.Duration      // how often closed polls are looked for
+	// Grace is how long a poll stays closed before it is certified, for the
+	// votes on their way when it closed to be counted, at least two flushes.
+	// A poll's closing time isn't stored, it is when the counter first found
+	// it closed, so a restart starts its grace over.
+	Grace time.Duration
+}
+
+// certifyTicks returns the channel closed polls are certified on, nil when
+// certificates are disabled or the store can't keep them
+func (c *Counter) certifyTicks() (<-chan time.Time, func()) {
+	if c.cfg.Certify.Key == nil || c.cfg.Certify.Interval <= 0 {
+		return nil, func() {}
+	}
+	if _, ok := c.db.(store.CertificateStore); !ok {
+		log.Println("this store doesn't keep certificates, not certifying the results")
+		return nil, func() {}
+	}
+	t := c.clock.NewTicker(c.cfg.Certify.Interval)
+	return t.C(), t.Stop
+}
+
+// certifyClosed signs the results of the polls closed for longer than the
+// grace and keeps their certificates
+func (c *Counter) certifyClosed() {
+	certs := c.db.(store.CertificateStore)
+	polls, err := c.db.Polls()
+	if err != nil {
+		log.Println("failed to load polls to certify:", err)
+		return
+	}
+	if c.closed == nil {
+		c.closed = make(map[string]time.Time)
+	}
+	now := time.Now()
+	grace := c.cfg.Certify.Grace
+	if min := 2 * c.cfg.UpdateInterval; grace < min {
+		grace = min
+	}
+	seen := make(map[string]bool)
+	for i := range polls {
+		p := &polls[i]
+		if p.State() != store.StatusClosed || !c.cfg.counts(p) {
+			continue
+		}
+		seen[p.ID] = true
+		since, ok := c.closed[p.ID]
+		if !ok {
+			c.closed[p.ID] = now
+			continue
+		}
+		if since.IsZero() || now.Sub(since) < grace {
+			// zero once certified
+			continue
+		}
+		if err := c.certify(certs, *p, now); err != nil {
+			log.Printf("failed to certify the results of poll %s: %v", p.ID, err)
+			continue
+		}
+		c.closed[p.ID] = time.Time{}
+	}
+	// forget the polls reopened, archived or deleted
+	for id := range c.closed {
+		if !seen[id] {
+			delete(c.closed, id)
+		}
+	}
+}
+
+// certify signs the results of p with its history, when the store keeps one
+func (c *Counter) certify(certs store.CertificateStore, p store.Poll, now time.Time) error {
+	if _, err := certs.Certificate(p.ID); err != store.ErrNoCertificate {
+		// already certified, by this counter before a restart or another one
+		return err
+	}
+	var history []store.HistoryEntry
+	if h, ok := c.db.(store.HistoryStore); ok {
+		var err error
+		if history, err = h.History(p.ID, time.Time{}, time.Time{}); err != nil {
+			return err
+		}
+	}
+	cert, err := certify.Sign(certify.New(p, history, now), c.cfg.Certify.Key)
+	if err != nil {
+		return err
+	}
+	saved, err := certs.SaveCertificate(p.ID, cert)
+	if saved {
+		log.Printf("certified the results of poll %s with key %s", p.ID, certify.KeyID(c.cfg.Certify.Key.Public().(ed25519.PublicKey)))
+	}
+	return err
+}
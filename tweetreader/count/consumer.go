@@ -0,0 +1,126 @@
+package count
+
+import (
+	"errors"
+	"log"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/deadletter"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	timeouts = metrics.NewCounter("tweetreader_count_message_timeouts_total",
+		"Vote messages that took longer than the handler timeout and were requeued.")
+	gaveUp = metrics.NewCounter("tweetreader_count_messages_given_up_total",
+		"Vote messages requeued the most times allowed and dead lettered instead.")
+)
+
+// errTimeout requeues a message that took too long to count
+var errTimeout = errors.New("counting the vote timed out")
+
+// ConsumerConfig tunes how the votes are consumed from NSQ, zero values keep the defaults
+type ConsumerConfig struct {
+	// Handlers is how many messages are counted at once, 1 by default.
+	// Decoding and looking up the polls happen in parallel, tallying one at a time.
+	Handlers int
+	// MaxInFlight is how many messages nsqd sends before they are answered,
+	// at least Handlers
+	MaxInFlight int
+	// MaxAttempts is how many times a message is delivered before it is dead
+	// lettered, 5 by default
+	MaxAttempts int
+	// RequeueDelay is how long a requeued message waits, times its attempts up
+	// to MaxRequeueDelay, 5s and 2m by default
+	RequeueDelay    time.Duration
+	MaxRequeueDelay time.Duration
+	// Timeout is how long a message may take to be counted before it is
+	// requeued, 0 for no limit
+	Timeout time.Duration
+}
+
+func (cc ConsumerConfig) handlers() int {
+	if cc.Handlers < 1 {
+		return 1
+	}
+	return cc.Handlers
+}
+
+// maxInFlight is how many votes the counter has from nsqd at once
+func (cc ConsumerConfig) maxInFlight() int {
+	if cc.MaxInFlight < cc.handlers() {
+		return cc.handlers()
+	}
+	return cc.MaxInFlight
+}
+
+// apply sets the consumer's settings on cfg, nsq copies it into the consumer
+func (cc ConsumerConfig) apply(cfg *nsq.Config) {
+	cfg.MaxInFlight = cc.maxInFlight()
+	cfg.MaxAttempts = 5
+	if cc.MaxAttempts > 0 {
+		cfg.MaxAttempts = uint16(cc.MaxAttempts)
+	}
+	cfg.DefaultRequeueDelay = 5 * time.Second
+	if cc.RequeueDelay > 0 {
+		cfg.DefaultRequeueDelay = cc.RequeueDelay
+	}
+	cfg.MaxRequeueDelay = 2 * time.Minute
+	if cc.MaxRequeueDelay > 0 {
+		cfg.MaxRequeueDelay = cc.MaxRequeueDelay
+	}
+}
+
+// In order to count the votes, the messages are consumed in the votes topic in NSQ
+func (c *Counter) consume() (*nsq.Consumer, error) {
+	log.Println("Connecting to nsq...")
+
+	// create a consumer
+	cfg := c.cfg.nsqConfig()
+	c.cfg.Consumer.apply(cfg)
+	q, err := nsq.NewConsumer(c.cfg.topic(), "counter", cfg)
+	if err != nil {
+		return nil, err
+	}
+	q.AddConcurrentHandlers(&consumer{c}, c.cfg.Consumer.handlers())
+	if err := q.ConnectToNSQLookupd(c.cfg.LookupdAddr); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// consumer counts the messages of the votes topic
+type consumer struct {
+	c *Counter
+}
+
+// HandleMessage counts a vote message. One that takes longer than the timeout,
+// usually waiting for a slow flush, is requeued with nsq's backoff; counting it
+// still finishes and the redelivery is skipped as a duplicate.
+func (h *consumer) HandleMessage(m *nsq.Message) error {
+	timeout := h.c.cfg.Consumer.Timeout
+	if timeout <= 0 {
+		return h.c.handle(m.Body)
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- h.c.handle(m.Body)
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case err := <-done:
+		return err
+	case <-timer.C:
+		timeouts.Inc()
+		return errTimeout
+	}
+}
+
+// LogFailedMessage dead letters a message nsq delivered the most times allowed
+func (h *consumer) LogFailedMessage(m *nsq.Message) {
+	gaveUp.Inc()
+	h.c.cfg.DeadLetters.Send(deadletter.MaxAttempts, errors.New("delivered too many times"), m.Body, nil)
+}
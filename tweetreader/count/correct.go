@@ -0,0 +1,133 @@
+package count
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var corrections = metrics.NewCounter("tweetreader_count_corrections_total",
+	"Corrections of disputed votes looked at, by outcome: applied, already applied, rejected or failed.")
+
+// correctTicks returns the channel the corrections of disputed votes are
+// applied on, nil when they aren't or the store can't keep them
+func (c *Counter) correctTicks() (<-chan time.Time, func()) {
+	if c.cfg.CorrectInterval <= 0 {
+		return nil, func() {}
+	}
+	if _, ok := c.db.(store.CorrectionStore); !ok {
+		log.Println("this store doesn't keep corrections, not correcting disputed votes")
+		return nil, func() {}
+	}
+	t := c.clock.NewTicker(c.cfg.CorrectInterval)
+	return t.C(), t.Stop
+}
+
+// applyCorrections applies the pending corrections to the polls this counter
+// counts. A correction the poll can't take is rejected, with why; one that
+// fails stays pending for the next tick.
+func (c *Counter) applyCorrections() {
+	cs := c.db.(store.CorrectionStore)
+	pending, err := cs.PendingCorrections()
+	if err != nil {
+		log.Println("failed to load the pending corrections:", err)
+		return
+	}
+	for _, corr := range pending {
+		p, err := c.db.Poll(corr.Poll)
+		if err == store.ErrNotFound {
+			c.rejectCorrection(cs, corr, "the poll was removed")
+			continue
+		}
+		if err != nil {
+			log.Printf("failed to load poll %s to correct: %v", corr.Poll, err)
+			corrections.Inc("outcome", "failed")
+			continue
+		}
+		if !c.cfg.counts(&p) {
+			// another counter's
+			continue
+		}
+		if problem, err := c.correctable(&p, corr); err != nil {
+			log.Printf("failed to check correction %s: %v", corr.ID, err)
+			corrections.Inc("outcome", "failed")
+			continue
+		} else if problem != "" {
+			c.rejectCorrection(cs, corr, problem)
+			continue
+		}
+		applied, err := cs.ApplyCorrection(corr)
+		if err != nil {
+			log.Printf("failed to apply correction %s: %v", corr.ID, err)
+			corrections.Inc("outcome", "failed")
+			continue
+		}
+		if !applied {
+			corrections.Inc("outcome", "already_applied")
+			continue
+		}
+		corrections.Inc("outcome", "applied")
+		if corr.Action == store.CorrectionReassign {
+			log.Printf("moved %d votes of tweet %s in poll %s from %q to %q", corr.Votes, corr.Tweet, corr.Poll, corr.Option, corr.To)
+		} else {
+			log.Printf("took %d votes of tweet %s for %q out of poll %s", corr.Votes, corr.Tweet, corr.Option, corr.Poll)
+		}
+	}
+}
+
+// correctable returns why p can't take corr, empty when it can
+func (c *Counter) correctable(p *store.Poll, corr store.Correction) (string, error) {
+	if certs, ok := c.db.(store.CertificateStore); ok {
+		if _, err := certs.Certificate(p.ID); err == nil {
+			return "the poll's results are certified", nil
+		} else if err != store.ErrNoCertificate {
+			return "", err
+		}
+	}
+	if !hasOption(p, corr.Option) {
+		return fmt.Sprintf("the poll has no option %q", corr.Option), nil
+	}
+	if corr.Action == store.CorrectionReassign && !hasOption(p, corr.To) {
+		return fmt.Sprintf("the poll has no option %q", corr.To), nil
+	}
+	if corr.Votes <= 0 {
+		return "no votes to correct", nil
+	}
+	if p.Results[corr.Option] < corr.Votes && !c.appliedTo(p, corr) {
+		return fmt.Sprintf("%q has fewer than %d votes", corr.Option, corr.Votes), nil
+	}
+	return "", nil
+}
+
+// appliedTo reports whether corr already changed p's results, by a counter
+// that stopped before marking it applied
+func (c *Counter) appliedTo(p *store.Poll, corr store.Correction) bool {
+	for _, id := range p.Corrections {
+		if id == corr.ID {
+			return true
+		}
+	}
+	return false
+}
+
+func (c *Counter) rejectCorrection(cs store.CorrectionStore, corr store.Correction, problem string) {
+	if err := cs.RejectCorrection(corr.ID, problem); err != nil {
+		log.Printf("failed to reject correction %s: %v", corr.ID, err)
+		corrections.Inc("outcome", "failed")
+		return
+	}
+	log.Printf("rejected correction %s: %s", corr.ID, problem)
+	corrections.Inc("outcome", "rejected")
+}
+
+func hasOption(p *store.Poll, option string) bool {
+	for _, o := range p.Options {
+		if o == option {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,756 @@
+// Package count consumes votes from NSQ and tallies them into the poll store.
+package count
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/olawolu/twitter-polls/tweetreader/aggregate"
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/control"
+	"github.com/olawolu/twitter-polls/tweetreader/deadletter"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/notify"
+	"github.com/olawolu/twitter-polls/tweetreader/partition"
+	"github.com/olawolu/twitter-polls/tweetreader/sdnotify"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// Config describes where the counter reads votes from and writes tallies to
+type Config struct {
+	LookupdAddr      string        // nsqlookupd used to find the votes topic
+	Topic            string        // the votes are consumed from, votes when empty
+	MetricsAddr      string        // address /metrics is served on
+	MetricsMaxSeries int           // cap on per-option metric series
+	UpdateInterval   time.Duration // how often tallies are flushed to the database
+	PollCacheTTL     time.Duration // how often the poll cache is fully refreshed
+	DedupWindow      time.Duration // how long a counted tweet is remembered
+	// SharedDedup remembers the votes counted by every counter sharing it, for
+	// DedupWindow too; each counter only remembers its own when nil
+	SharedDedup      SharedLedger
+	AuthorsWindow    time.Duration // how long an author counted by a unique_authors poll is remembered
+	SnapshotInterval time.Duration // how often the counter state is saved for the next start
+	NsqdAddr         string        // nsqd control signals are published to
+	NSQ              *nsq.Config   // TLS and auth for nsqd and nsqlookupd, nsq's defaults when nil
+	OverloadRate     float64       // votes per second for one poll that trigger back-pressure, 0 to disable
+	ControlAction    string        // what streamers are asked to do when overloaded: sample or slow
+	ControlHold      time.Duration // how long a control signal lasts
+	TimeSeries       timeseries.Config
+	History          HistoryConfig
+	Certify          CertifyConfig
+	// Batch writes the results of a flush with bulk writes, on stores that can
+	Batch BatchConfig
+	// VoteLog appends every vote counted to the store's vote log, for Recount
+	VoteLog  bool
+	Events   *events.Bus // told about store errors and overloads, and runs the runbook's counter actions
+	Consumer ConsumerConfig
+	// Embargo holds the votes of embargoed polls back until they may be counted
+	Embargo EmbargoConfig
+	// Tenant only counts the votes for the polls of this tenant, and
+	// Untenanted the votes for the polls without one, for the counters of the
+	// topics the streamers route the tenants' votes to
+	Tenant     string
+	Untenanted bool
+	// Partition only counts the votes for the polls in this partition out of
+	// Partitions, for the counters of the topics the streamers partition the
+	// votes between; 0 Partitions counts every poll's
+	Partition  int
+	Partitions int
+	// RetractWindow is how long what each vote added to the results is
+	// remembered, to take it back when the streamers retract its tweet; the
+	// retractions are ignored when 0
+	RetractWindow time.Duration
+	// LedgerLimit is how many votes, and authors, the counter remembers in
+	// memory at most, evicting the least recently seen; 0 for no limit. The
+	// evicted ones are spilled to LedgerSpillDir when set, forgotten otherwise
+	LedgerLimit    int
+	LedgerSpillDir string
+	// CorrectInterval is how often the corrections of the votes disputed
+	// through the API are applied, never when 0
+	CorrectInterval time.Duration
+	// Leaderboard gets what every flush adds to the results, and is rebuilt
+	// from the stored results when the counter starts and every
+	// LeaderboardInterval; there is none when nil
+	Leaderboard         Leaderboard
+	LeaderboardInterval time.Duration
+	// DeadLetters gets the messages that can't be decoded and the votes no poll has the option of, they are only logged when nil
+	DeadLetters *deadletter.Sink
+	// ShutdownGrace, when set, is how long stopping may take in all, and
+	// DrainDelay how long /readyz reports not ready before anything stops
+	ShutdownGrace time.Duration
+	DrainDelay    time.Duration
+	// WatchdogStall is how long the flushes may stop or nsqd be unreachable
+	// before systemd's watchdog isn't petted, 5m by default
+	WatchdogStall time.Duration
+	// Timing, when set, is told how long each vote message took to count ("count") and each flush took ("flush")
+	Timing func(stage string, took time.Duration)
+	// Clock ticks the flushes, snapshots and the other periodic work, clock.Real when nil
+	Clock clock.Clock
+}
+
+// counts reports whether the counter counts the votes for p
+func (cfg Config) counts(p *store.Poll) bool {
+	if cfg.Partitions > 0 && partition.Of(p.ID, cfg.Partitions) != cfg.Partition {
+		return false
+	}
+	switch {
+	case cfg.Tenant != "":
+		return p.Tenant == cfg.Tenant
+	case cfg.Untenanted:
+		return p.Tenant == ""
+	}
+	return true
+}
+
+type tweet struct {
+	ID        string `bson:"id_str"`
+	CreatedAt string `bson:"created_at"`
+	Text      string `bson:"text"`
+	User      struct {
+		Name       string `bson:"name"`
+		ScreenName string `bson:"screen_name"`
+	} `bson:"user"`
+	// Place            interface{}              `bson:"place"`
+	// Urls             []map[string]interface{} `bson:"urls"`
+	// Entities         struct  {
+	// 	Hashtags []interface{}
+	// }
+	// ExtendedEntities map[string]interface{}   `bson:"extended_entities"`
+}
+
+// vote carries the option a tweet was counted for and how much it weighs.
+// It is decoded from the same message as the tweet itself.
+type vote struct {
+	ID     string
+	Option string
+	Weight float64
+	Geo    *match.Geo
+	// Hashtag is set when the tweet had the option as a hashtag
+	Hashtag bool
+	// Author is the screen name of the tweet's author, hashed for private polls
+	Author string
+	// Suspect is set when the streamer saw a spike of votes for the option
+	Suspect bool
+	// Scale is how many votes this one stands for when its poll is sampled, 0 means 1
+	Scale int
+	// Count is how many votes this one adds up when the streamer rolled them up, see match.Vote
+	Count int
+	// Retweet, Quote and Reply tell what kind of tweet cast the vote
+	Retweet, Quote, Reply bool
+	// Embedded is set when the option was only in the retweeted or quoted tweet
+	Embedded bool
+	// CaseFolded and Partial are set when the tweet only had the option in
+	// another case, or only inside longer words
+	CaseFolded, Partial bool
+	// Source is where the vote was cast, see the stream.Source constants
+	Source string
+	// Lang is the language of the tweet, see the lang package
+	Lang string
+	// Folded is set when the tweet only had the option once folded, and
+	// Stemmed in another form of its words, see match.Vote
+	Folded, Stemmed string
+	// Filtered are the polls whose filter expression, or route, the tweet failed
+	Filtered []string
+	// Undelivered is the share of the tweets Twitter didn't deliver on the
+	// connection the vote came on, see stream.Tweet
+	Undelivered float64
+	// Versions are the versions of the options of the polls it was matched
+	// against, see match.Vote
+	Versions []string
+}
+
+// votes is how many votes v counts as
+func (v vote) votes() int {
+	if v.Count > 0 {
+		// the scales of the votes are added up in it
+		return v.Count
+	}
+	if v.Scale > 1 {
+		return v.Scale
+	}
+	return 1
+}
+
+// tally accumulates the raw and weighted votes for an option
+type tally struct {
+	Count    int
+	Weighted float64
+}
+
+// Counter tallies votes between flushes to the database
+type Counter struct {
+	cfg     Config
+	clock   clock.Clock
+	db      store.Backend
+	polls   *pollCache
+	metrics *voteMetrics
+	server  *http.Server // serves /metrics
+	ready   shutdown.Readiness
+	flushed int64 // when Run's loop last flushed, in unix nanoseconds, for the watchdog
+	series  timeseries.Store
+	ledger  *ledger       // guarded by countsLock
+	authors *ledger       // the authors counted by unique_authors polls, guarded by countsLock
+	undo    *retractable  // what the tweets counted lately added, guarded by countsLock; nil when retractions are ignored
+	control *backPressure // nil when back-pressure is off
+	notes   *pollNotifier
+	closed  map[string]time.Time  // when each closed poll was found closed, zero once certified; only used by Run
+	voteLog store.VoteLogStore    // nil when the votes aren't logged
+	bulk    store.BulkResultStore // nil when the results are written poll by poll
+
+	countsLock sync.Mutex
+	since      time.Time                                // when the current tallies started
+	counts     map[tweet]int                            // hold the vote counts
+	tallies    map[string]*tally                        // hold the raw and weighted tallies per option
+	geo        map[string]*pollGeo                      // hold the tallies of polls filtering or aggregating by location
+	computed   map[string]map[string]map[string]float64 // hold the aggregator metrics per poll, metric and option
+	sources    breakdown                                // hold the counts per poll, source and option
+	languages  breakdown                                // hold the counts per poll, language and option
+	created    []time.Time                              // when the tweets behind the tallies were posted, for the latency SLO
+	logged     []store.LoggedVote                       // the votes behind the tallies, for the vote log
+	unwritten  []pollWrite                              // the results earlier flushes failed to write
+	caps       map[string]*capBucket                    // the room left for votes of the options of capped polls, by poll/option
+}
+
+// nsqTopic publishes to one topic on nsqd
+type nsqTopic struct {
+	producer *nsq.Producer
+	topic    string
+}
+
+func (t nsqTopic) Publish(b []byte) error {
+	return t.producer.Publish(t.topic, b)
+}
+
+// tweetSaver is implemented by stores that keep a copy of every counted tweet
+type tweetSaver interface {
+	SaveTweet(doc interface{}) error
+}
+
+// newCounter sets up a counter writing to db, warmed up from its last snapshot
+func newCounter(cfg Config, db store.Backend) (*Counter, error) {
+	var session *mgo.Session
+	if m, ok := db.(*store.Mongo); ok {
+		session = m.Session()
+		if cfg.TimeSeries.Database == "" {
+			cfg.TimeSeries.Database = m.Database()
+		}
+	}
+	series, err := timeseries.Open(cfg.TimeSeries, session)
+	if err != nil {
+		return nil, err
+	}
+	c := &Counter{
+		cfg:     cfg,
+		clock:   clock.Or(cfg.Clock),
+		db:      db,
+		polls:   newPollCache(db, cfg.PollCacheTTL, cfg.counts),
+		metrics: newVoteMetrics(cfg.MetricsMaxSeries),
+		series:  series,
+		undo:    newRetractable(cfg.RetractWindow),
+		notes:   newPollNotifier(db, notify.New()),
+		since:   time.Now(),
+		voteLog: openVoteLog(cfg, db),
+	}
+	if b, ok := db.(store.BulkResultStore); ok && cfg.Batch.Size > 0 {
+		c.bulk = b
+	}
+	if err := c.openLedgers(); err != nil {
+		series.Close()
+		return nil, err
+	}
+	c.warmup()
+	registerSLO()
+	c.server = serveMetrics(cfg.MetricsAddr, c.metrics, &c.ready)
+	return c, nil
+}
+
+// nsqConfig returns cfg.NSQ, or nsq's defaults when it isn't set
+func (cfg Config) nsqConfig() *nsq.Config {
+	if cfg.NSQ != nil {
+		return cfg.NSQ
+	}
+	return nsq.NewConfig()
+}
+
+// Run counts votes into db until the process is told to stop
+func Run(cfg Config, db store.Backend) error {
+	c, err := newCounter(cfg, db)
+	if err != nil {
+		return err
+	}
+	defer c.series.Close()
+	if events := watchPollEvents(cfg.LookupdAddr, cfg.nsqConfig(), c.polls, c.metrics); events != nil {
+		defer events.Stop()
+	}
+	if retractions := c.watchRetractions(); retractions != nil {
+		defer retractions.Stop()
+	}
+	if cfg.OverloadRate > 0 {
+		producer, err := nsq.NewProducer(cfg.NsqdAddr, cfg.nsqConfig())
+		if err != nil {
+			return err
+		}
+		defer producer.Stop()
+		c.control = newBackPressure(cfg, nsqTopic{producer, control.Topic})
+	}
+
+	q, err := c.consume()
+	if err != nil {
+		return err
+	}
+	c.handleActions(q)
+	c.markFlushed()
+	sdnotify.Notify(sdnotify.Ready)
+	stopWatchdog := make(chan struct{})
+	defer close(stopWatchdog)
+	go sdnotify.Watch(c.health(q), stopWatchdog)
+	ticker := c.clock.NewTicker(cfg.UpdateInterval)
+	snapshots := c.clock.NewTicker(cfg.SnapshotInterval)
+	defer snapshots.Stop()
+	history, stopHistory := c.historyTicks()
+	defer stopHistory()
+	certificates, stopCertify := c.certifyTicks()
+	defer stopCertify()
+	disputes, stopCorrect := c.correctTicks()
+	defer stopCorrect()
+	if cfg.Leaderboard != nil {
+		c.rebuildLeaderboards()
+	}
+	leaderboards, stopLeaderboards := c.leaderboardTicks()
+	defer stopLeaderboards()
+	summaries := c.clock.NewTicker(summaryInterval)
+	defer summaries.Stop()
+	termChan := make(chan os.Signal, 1)
+	shutdown.Notify(termChan, syscall.SIGHUP)
+	for {
+		select {
+		case <-ticker.C():
+			c.doCount()
+			c.doPush()
+			c.markFlushed()
+		case <-snapshots.C():
+			c.saveSnapshot()
+		case <-history:
+			c.saveHistory()
+		case <-certificates:
+			c.certifyClosed()
+		case <-disputes:
+			c.applyCorrections()
+		case <-leaderboards:
+			c.rebuildLeaderboards()
+		case <-summaries.C():
+			c.notes.summarize()
+		case <-termChan:
+			ticker.Stop()
+			sdnotify.Notify(sdnotify.Stopping)
+			c.ready.Drain(cfg.DrainDelay)
+			c.stop(q).Log()
+			return nil
+		case <-q.StopChan:
+			c.shutdown()
+			return nil
+		}
+	}
+}
+
+// RunLocal counts the vote messages received on messages until the channel is closed.
+// It is used to run the whole pipeline in one process, without NSQ.
+func RunLocal(cfg Config, db store.Backend, messages <-chan []byte) error {
+	c, err := newCounter(cfg, db)
+	if err != nil {
+		return err
+	}
+	defer c.series.Close()
+	c.handleActions(nil)
+	ticker := c.clock.NewTicker(cfg.UpdateInterval)
+	defer ticker.Stop()
+	snapshots := c.clock.NewTicker(cfg.SnapshotInterval)
+	defer snapshots.Stop()
+	for {
+		select {
+		case b, ok := <-messages:
+			if !ok {
+				c.shutdown()
+				return nil
+			}
+			if err := c.handle(b); err != nil {
+				log.Println(err)
+			}
+		case <-ticker.C():
+			c.doCount()
+			c.doPush()
+		case <-snapshots.C():
+			c.saveSnapshot()
+		}
+	}
+}
+
+// shutdown flushes what is left so the snapshot matches the stored results
+func (c *Counter) shutdown() {
+	c.doCount()
+	c.doPush()
+	c.saveSnapshot()
+	c.ledger.close()
+	c.authors.close()
+	c.notes.out.Close()
+}
+
+// Shutdown stage timeouts, the consumer waits for the votes nsqd has in flight
+const (
+	consumerTimeout = 30 * time.Second
+	flushTimeout    = 30 * time.Second
+	metricsTimeout  = 5 * time.Second
+)
+
+// stop stops consuming, flushes the tallies and then stops serving metrics,
+// so the last scrape sees the final counts
+func (c *Counter) stop(q *nsq.Consumer) shutdown.Report {
+	var sd shutdown.Coordinator
+	if c.cfg.ShutdownGrace > 0 {
+		sd.Grace = c.cfg.ShutdownGrace - c.cfg.DrainDelay
+	}
+	sd.Add("consumer", consumerTimeout, func(ctx context.Context) error {
+		q.Stop()
+		select {
+		case <-q.StopChan:
+			return nil
+		case <-ctx.Done():
+			return shutdown.ErrTimeout
+		}
+	})
+	sd.Add("counter", flushTimeout, func(context.Context) error {
+		c.shutdown()
+		return nil
+	})
+	sd.Add("api", metricsTimeout, c.server.Shutdown)
+	return sd.Shutdown()
+}
+
+// handleActions registers the runbook actions the counter can take,
+// pause_counter only when it consumes from NSQ
+func (c *Counter) handleActions(q *nsq.Consumer) {
+	c.cfg.Events.Handle(events.ActionSnapshot, func(events.Step, events.Event) error {
+		c.saveSnapshot()
+		return nil
+	})
+	if q == nil {
+		return
+	}
+	c.cfg.Events.Handle(events.ActionPauseCounter, func(step events.Step, e events.Event) error {
+		d := time.Duration(step.For)
+		log.Println("Pausing the counter for", d)
+		q.ChangeMaxInFlight(0)
+		time.AfterFunc(d, func() {
+			log.Println("Resuming the counter")
+			q.ChangeMaxInFlight(c.cfg.Consumer.maxInFlight())
+		})
+		return nil
+	})
+}
+
+// topic is the topic the votes are consumed from
+func (cfg Config) topic() string {
+	if cfg.Topic == "" {
+		return "votes"
+	}
+	return cfg.Topic
+}
+
+// timed tells cfg.Timing how long stage has taken since start
+func (c *Counter) timed(stage string, start time.Time) {
+	if c.cfg.Timing != nil {
+		c.cfg.Timing(stage, time.Since(start))
+	}
+}
+
+// handle counts a single vote message. It only fails when the message has to
+// be delivered again, the messages it can't count are dead lettered.
+func (c *Counter) handle(body []byte) error {
+	defer c.timed("count", time.Now())
+	var msg match.Vote
+	name, err := codec.Decode(body, &msg)
+	c.metrics.Message(name)
+	if err != nil {
+		log.Println("Unmarshall error: ", err)
+		c.cfg.DeadLetters.Send(deadletter.DecodeFailed, err, body, nil)
+		return nil
+	}
+	var metas []*store.Poll
+	if msg.Option != "" {
+		metas, err = c.polls.PollsFor(msg.Option)
+		if err != nil {
+			log.Println("failed to load polls:", err)
+		} else if len(metas) == 0 {
+			// the poll went away while the streamer still tracked the option, the vote
+			// isn't remembered as counted so it can be replayed once there is one
+			c.cfg.DeadLetters.Send(deadletter.NoPoll, nil, body, &msg)
+			return nil
+		}
+	}
+	now := time.Now()
+	if c.cfg.Embargo.Hold != nil {
+		if until := embargoed(metas, now); until.After(now) {
+			// not in the ledger yet, so it counts when it comes back
+			return c.hold(body, until.Sub(now))
+		}
+	}
+	if ttl := c.cfg.Embargo.TTL; ttl > 0 {
+		if at, err := time.Parse(tweetTimeFmt, msg.CreatedAt); err == nil && now.Sub(at) > ttl {
+			c.cfg.DeadLetters.Send(deadletter.Expired, fmt.Errorf("tweeted at %s, over %s ago", at.UTC().Format(time.RFC3339), ttl), body, &msg)
+			return nil
+		}
+	}
+	// a tweet naming several options is one message per option, each with its own key.
+	// Votes from producers that didn't set one get the same key they would have.
+	key := voteKey(msg)
+	if key != "" && c.sharedSeen(key, msg) {
+		return nil
+	}
+	// decoding and finding the polls don't need the lock, concurrent handlers do them in parallel
+	c.countsLock.Lock()         //lock the countsLock mutex when a new vote comes in
+	defer c.countsLock.Unlock() // defer til when the function exits
+	// check whether the counts is nil and make a new map
+	if c.counts == nil {
+		c.counts = make(map[tweet]int)
+	}
+	if key != "" && c.ledger.Seen(key, now) {
+		log.Println("skipping tweet already counted:", msg.ID, msg.Option)
+		c.metrics.Duplicate()
+		return nil
+	}
+	t := tweet{ID: msg.ID, CreatedAt: msg.CreatedAt, Text: msg.Text}
+	t.User.Name, t.User.ScreenName = msg.User.Name, msg.User.ScreenName
+	c.counts[t]++
+	c.logVote(key, body, metas, now)
+	c.fold(msg, metas, now)
+	return nil
+}
+
+// voteKey returns the message ID the vote in msg is deduplicated by
+func voteKey(msg match.Vote) string {
+	if msg.MessageID == "" && msg.ID != "" {
+		return match.MessageID(msg.ID, msg.Option)
+	}
+	return msg.MessageID
+}
+
+// voteOf is the vote the counter tallies for msg
+func voteOf(msg match.Vote) vote {
+	return vote{ID: msg.ID, Option: msg.Option, Weight: msg.Weight, Geo: msg.Geo, Hashtag: msg.Hashtag, Author: msg.User.ScreenName, Suspect: msg.Suspect, Scale: msg.Scale, Count: msg.Count,
+		Retweet: msg.Retweet, Quote: msg.Quote, Reply: msg.Reply, Embedded: msg.Embedded,
+		CaseFolded: msg.CaseFolded, Partial: msg.Partial, Source: msg.Source, Lang: msg.Lang, Folded: msg.Folded, Stemmed: msg.Stemmed, Filtered: msg.Filtered, Undelivered: msg.Undelivered, Versions: msg.Versions}
+}
+
+// fold tallies the vote in msg for metas, the polls with its option, as
+// counted at now; must be called with countsLock held. It is all counting a
+// vote takes once it is known to be new, so the vote log is recounted by it.
+func (c *Counter) fold(msg match.Vote, metas []*store.Poll, now time.Time) {
+	t := tweet{ID: msg.ID, CreatedAt: msg.CreatedAt, Text: msg.Text}
+	t.User.Name, t.User.ScreenName = msg.User.Name, msg.User.ScreenName
+	v := voteOf(msg)
+	if v.Suspect {
+		c.metrics.Suspect()
+	}
+	if v.Option == "" {
+		return
+	}
+	if c.tallies == nil {
+		c.tallies = make(map[string]*tally)
+	}
+	if c.tallies[v.Option] == nil {
+		c.tallies[v.Option] = &tally{}
+	}
+	// messages from publishers that predate weighting count as 1
+	if v.Weight == 0 {
+		v.Weight = 1
+	}
+	// sampled votes stand for the ones the streamer didn't publish. Folded votes
+	// and the ones only found stemmed only count for the polls folding text or
+	// stemming the option, which are filtered and keep their own tallies
+	if v.Folded == "" && (v.Stemmed == "" || v.Partial) {
+		c.tallies[v.Option].Count += v.votes()
+		c.tallies[v.Option].Weighted += v.Weight * float64(v.votes())
+	}
+	if at, err := time.Parse(tweetTimeFmt, msg.CreatedAt); err == nil && len(c.created) < maxPending {
+		c.created = append(c.created, at)
+	}
+	c.metrics.Observe(v, metas)
+	metas = c.countedBy(v, metas, now)
+	av := aggregate.Vote{Option: v.Option, Weight: v.Weight, Text: msg.Text, Followers: msg.User.FollowersCount}
+	metas, parts := c.capped(v, metas, now)
+	c.tallyCounted(v, av, metas, now)
+	for _, part := range parts {
+		c.tallyCounted(part.v, av, []*store.Poll{part.poll}, now)
+	}
+}
+
+// tallyCounted records v for polls, the ones counting it, must be called with countsLock held
+func (c *Counter) tallyCounted(v vote, av aggregate.Vote, polls []*store.Poll, now time.Time) {
+	c.tallyGeo(v, polls)
+	c.tallyBreakdowns(v, polls)
+	c.tallyUndelivered(v, polls)
+	c.tallyVersions(v, polls)
+	c.remember(v, polls, now)
+	c.tallyMetrics(v, av, polls)
+}
+
+// push reults to database
+// Every poll tracking an option gets its raw count incremented,
+// weighted polls also get the weighted tally.
+// Polls with locations get only the votes from inside them,
+// polls aggregating by area get their geo_results incremented,
+// every poll gets its counts per source and per language incremented,
+// and polls whose type has aggregators get their metrics incremented, as do the
+// polls whose votes Twitter didn't all deliver with the estimate of the missed ones
+// and the polls whose options were edited with the votes of each version.
+func (c *Counter) doCount() {
+	defer c.timed("flush", time.Now())
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	if len(c.tallies) == 0 && len(c.unwritten) == 0 {
+		log.Println("No new votes, skippin database update")
+		c.since = time.Now()
+		return
+	}
+	log.Println("Updating database...")
+	// the votes are logged first, so none is in the results without being in the log
+	if len(c.logged) > 0 {
+		if err := c.voteLog.AppendVotes(c.logged); err != nil {
+			log.Println("failed to append to the vote log:", err)
+			c.cfg.Events.Emit(events.CountStoreError, err.Error())
+			return
+		}
+		c.logged = nil
+	}
+	now := time.Now()
+	c.sweepCaps(now)
+	polls := make(map[string]*store.Poll)
+	counts := make(map[string]map[string]int)
+	weighted := make(map[string]map[string]float64)
+	var writes []pollWrite
+	for option, t := range c.tallies {
+		metas, err := c.polls.PollsFor(option)
+		if err != nil {
+			// nothing is written, the tallies are kept whole for the next flush
+			log.Println("failed to load polls:", err)
+			c.cfg.Events.Emit(events.CountStoreError, err.Error())
+			return
+		}
+		for _, p := range metas {
+			if t = c.tallyFor(p, option); t == nil {
+				continue
+			}
+			polls[p.ID] = p
+			if counts[p.ID] == nil {
+				counts[p.ID] = make(map[string]int)
+				weighted[p.ID] = make(map[string]float64)
+			}
+			counts[p.ID][option] = t.Count
+			weighted[p.ID][option] = t.Weighted
+		}
+	}
+	elapsed := now.Sub(c.since)
+	for id, p := range polls {
+		var votes int
+		for _, n := range counts[id] {
+			votes += n
+		}
+		// back-pressure is about the messages, a sampled poll gets 1 in SampleEvery of its votes as messages
+		if p.SampleEvery > 1 {
+			votes /= p.SampleEvery
+		}
+		c.control.observe(p, votes, elapsed, now)
+		var w map[string]float64
+		if p.Weighted() {
+			w = weighted[id]
+		}
+		u := store.ResultUpdate{Poll: id}
+		u.Results, u.WeightedResults = counts[id], w
+		if pg := c.geo[id]; pg != nil && len(pg.areas) > 0 {
+			u.GeoResults = pg.areas
+		}
+		u.SourceResults, u.LanguageResults, u.Metrics = c.sources[id], c.languages[id], c.computed[id]
+		writes = append(writes, pollWrite{poll: p, update: u, weighted: weighted[id], at: now})
+	}
+	// what isn't written is kept for the next flush rather than the tallies
+	var points []timeseries.Point
+	var err error
+	if c.bulk != nil {
+		points, err = c.writeBulk(writes)
+	} else {
+		points, err = c.writeEach(writes)
+	}
+	if err != nil {
+		log.Println("failed to update:", err)
+		c.cfg.Events.Emit(events.CountStoreError, err.Error())
+	}
+	// the time series is for charts, losing a point must not hold back the tallies
+	if err := c.series.Write(points); err != nil {
+		log.Println("failed to write time series:", err)
+	}
+	c.addLeaderboard(points)
+	if err == nil {
+		log.Println("Finished updating database...")
+	}
+	observeLatency(c.created, time.Now())
+	c.created = nil
+	c.tallies = nil // reset tallies
+	c.geo = nil
+	c.sources = nil
+	c.languages = nil
+	c.computed = nil
+	c.since = now
+}
+
+// doPush keeps a copy of the counted tweets, on stores that support it
+func (c *Counter) doPush() {
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	saver, supported := c.db.(tweetSaver)
+	if !supported {
+		c.counts = nil
+		return
+	}
+	ok := true
+	for option := range c.counts {
+		b, err := toBson(option)
+		if err != nil {
+			log.Println("error converting to bson: ", err)
+		}
+		if err = saver.SaveTweet(b); err != nil {
+			log.Println("Insert Error: ", err)
+			ok = false
+		}
+	}
+	if ok {
+		log.Println("Finished updating database...")
+		c.counts = nil // saved, don't insert them again on the next tick
+	}
+}
+
+// convert tweet to a bson document
+func toBson(v interface{}) (doc *bson.D, err error) {
+	b, err := bson.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = bson.Unmarshal(b, &doc)
+	if err != nil {
+		return nil, err
+	}
+	return doc, nil
+}
@@ -0,0 +1,65 @@
+package count
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var held = metrics.NewCounter("tweetreader_count_held_votes_total",
+	"Votes of embargoed polls published again, deferred until the embargo lifts.")
+
+// Deferrer publishes a message on the votes topic, delivered after delay
+type Deferrer interface {
+	DeferredPublish(b []byte, delay time.Duration) error
+}
+
+// EmbargoConfig holds the votes of embargoed polls back and expires old votes
+type EmbargoConfig struct {
+	// Hold publishes the votes held back again, deferred. When it is nil the
+	// votes are counted whatever the embargo.
+	Hold Deferrer
+	// MaxDefer is the longest a vote is deferred at once, nsqd's
+	// -max-req-timeout, 1h by default. A vote held back longer is deferred again.
+	MaxDefer time.Duration
+	// TTL dead letters the votes whose tweets are older than this by the time
+	// they could be counted, 0 counts them however old
+	TTL time.Duration
+}
+
+func (ec EmbargoConfig) maxDefer() time.Duration {
+	if ec.MaxDefer <= 0 {
+		return time.Hour
+	}
+	return ec.MaxDefer
+}
+
+// embargoed returns when the vote for the polls metas may be counted: when
+// the last embargo of the polls it counts for lifts, or now
+func embargoed(metas []*store.Poll, now time.Time) time.Time {
+	until := now
+	for _, p := range metas {
+		if !p.AcceptsVotes() {
+			continue
+		}
+		if t := p.Embargo.Lifts(now); t.After(until) {
+			until = t
+		}
+	}
+	return until
+}
+
+// hold publishes the vote message body again, to be delivered when it can be
+// counted or as late as nsqd allows
+func (c *Counter) hold(body []byte, delay time.Duration) error {
+	if max := c.cfg.Embargo.maxDefer(); delay > max {
+		delay = max
+	}
+	if err := c.cfg.Embargo.Hold.DeferredPublish(body, delay); err != nil {
+		return fmt.Errorf("failed to hold the vote back: %v", err)
+	}
+	held.Inc()
+	return nil
+}
@@ -0,0 +1,86 @@
+package count
+
+import (
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// filtered reports whether p only counts some of the votes for its options
+func filtered(p *store.Poll) bool {
+	return !p.AcceptsVotes() || len(p.Locations) > 0 || p.HashtagOnly || p.UniqueAuthors() || p.ExcludeSuspect ||
+		p.ExcludeRetweets || p.ExcludeQuotes || p.ExcludeReplies || p.EmbeddedText == store.EmbeddedIgnore || len(p.Matching) > 0 ||
+		p.Folding != "" || p.Filter != "" || p.Route != "" || p.MaxPerMinute > 0
+}
+
+// accepts reports whether v counts for p: drafts and closed and archived polls
+// take no votes, polls with locations only take the votes
+// from inside them, hashtag-only polls the votes whose tweet had the option as a hashtag,
+// and polls excluding suspect votes the ones cast outside of a spike. Polls can
+// also leave out retweets, quotes and replies, the votes for options only in
+// the tweet retweeted or quoted, and match some options in their case or as whole words.
+// Votes only found in their text once folded only count for the polls folding it so,
+// and the ones only found stemmed for the polls stemming their option as much,
+// which count them as whole words. Polls with a filter expression leave out the
+// votes whose tweet the streamer found failing it, and polls with a route the
+// votes for the options they share failing it.
+func accepts(p *store.Poll, v vote) bool {
+	if !p.AcceptsVotes() {
+		return false
+	}
+	if len(p.Locations) > 0 && !inside(p, v.Geo) {
+		return false
+	}
+	if p.HashtagOnly && !v.Hashtag {
+		return false
+	}
+	if p.ExcludeSuspect && v.Suspect {
+		return false
+	}
+	if p.ExcludeRetweets && v.Retweet || p.ExcludeQuotes && v.Quote || p.ExcludeReplies && v.Reply {
+		return false
+	}
+	if p.EmbeddedText == store.EmbeddedIgnore && v.Embedded {
+		return false
+	}
+	m := p.MatchingOf(v.Option)
+	if m.CaseSensitive && v.CaseFolded {
+		return false
+	}
+	// the options stemmed as much find stemmed votes as whole words, the others
+	// only count them when they were found as written too, see match.Vote.Stemmed
+	if stems := v.Stemmed != "" && m.Stems(v.Stemmed); !stems && (m.Exact && v.Partial || v.Stemmed != "" && !v.Partial) {
+		return false
+	}
+	if !p.Folds(v.Folded) {
+		return false
+	}
+	for _, id := range v.Filtered {
+		if id == p.ID {
+			return false
+		}
+	}
+	return true
+}
+
+// countedBy returns the polls v counts for, must be called with countsLock held:
+// the ones accepting it, leaving out the unique_authors polls that already counted
+// its author for the option. Votes without an author always count.
+func (c *Counter) countedBy(v vote, polls []*store.Poll, now time.Time) []*store.Poll {
+	var counting []*store.Poll
+	for _, p := range polls {
+		if !accepts(p, v) {
+			if !p.AcceptsVotes() {
+				c.metrics.Rejected(p.State())
+			}
+			continue
+		}
+		if p.UniqueAuthors() && v.Author != "" && c.authors.Seen(p.ID+"/"+v.Option+"/"+strings.ToLower(v.Author), now) {
+			c.metrics.RepeatAuthor()
+			continue
+		}
+		counting = append(counting, p)
+	}
+	return counting
+}
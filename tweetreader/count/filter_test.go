@@ -0,0 +1,192 @@
+package count
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/olawolu/twitter-polls/tweetreader/expr"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// countedFor matches t against the options of polls, with the matcher and
+// the filters set from them the way the streamer does, and returns the
+// options each poll counts a vote for, by poll
+func countedFor(polls []store.Poll, t stream.Tweet) map[string][]string {
+	m := match.NewMatcher(nil)
+	var options, scanned []string
+	folds := make(map[string]string)
+	stems := make(map[string]match.Stemming)
+	seen := make(map[string]bool)
+	for _, p := range polls {
+		for _, o := range p.Options {
+			if !seen[o] {
+				seen[o] = true
+				options = append(options, o)
+			}
+			if p.Folding != "" && folds[o] != store.FoldTransliterate {
+				folds[o] = p.Folding
+			}
+		}
+		if p.EmbeddedText == store.EmbeddedScan {
+			scanned = append(scanned, p.Options...)
+		}
+		for _, om := range p.Matching {
+			if s, ok := stems[om.Option]; om.Fuzziness != "" && (!ok || s.Level != store.FuzzyStem) {
+				stems[om.Option] = match.Stemming{Level: om.Fuzziness, Language: om.Language}
+			}
+		}
+	}
+	m.ScanEmbeddedFor(scanned)
+	m.FoldFor(folds)
+	m.StemFor(stems)
+	m.Update(options)
+	filters := expr.NewFilters()
+	filters.Update(polls)
+
+	counted := make(map[string][]string)
+	for _, msg := range m.Match(t) {
+		if !filters.Filter(&msg) {
+			continue
+		}
+		v := voteOf(msg)
+		for i := range polls {
+			p := &polls[i]
+			for _, o := range p.Options {
+				if o == v.Option && accepts(p, v) {
+					counted[p.ID] = append(counted[p.ID], o)
+				}
+			}
+		}
+	}
+	return counted
+}
+
+func TestAccepts(t *testing.T) {
+	poll := func(id string, options ...string) store.Poll {
+		return store.Poll{ID: id, Options: options, Status: store.StatusActive}
+	}
+	with := func(p store.Poll, change func(p *store.Poll)) store.Poll {
+		change(&p)
+		return p
+	}
+	matching := func(om ...store.OptionMatching) func(p *store.Poll) {
+		return func(p *store.Poll) { p.Matching = om }
+	}
+	tagged := func(text string, tags ...string) stream.Tweet {
+		t := stream.Tweet{Text: text, Entities: &stream.Entities{}}
+		for _, tag := range tags {
+			t.Entities.Hashtags = append(t.Entities.Hashtags, stream.Hashtag{Text: tag})
+		}
+		return t
+	}
+	quoting := func(text, quoted string) stream.Tweet {
+		return stream.Tweet{Text: text, QuotedStatus: &stream.Tweet{Text: quoted}}
+	}
+	inLang := func(lang, text string) stream.Tweet {
+		return stream.Tweet{Text: text, Lang: lang}
+	}
+	pets := poll("p", "cats", "dogs")
+
+	tests := []struct {
+		name    string
+		polls   []store.Poll
+		tweet   stream.Tweet
+		counted map[string][]string
+	}{
+		{"every option the tweet has", []store.Poll{pets}, stream.Tweet{Text: "cats and dogs"}, map[string][]string{"p": {"cats", "dogs"}}},
+		{"a closed poll", []store.Poll{with(pets, func(p *store.Poll) { p.Status = store.StatusClosed })}, stream.Tweet{Text: "cats"}, map[string][]string{}},
+		{"a paused poll", []store.Poll{with(pets, func(p *store.Poll) { p.Status = store.StatusPaused })}, stream.Tweet{Text: "cats"}, map[string][]string{"p": {"cats"}}},
+
+		// hashtag-only polls
+		{"hashtag-only, in the text", []store.Poll{with(pets, func(p *store.Poll) { p.HashtagOnly = true })}, stream.Tweet{Text: "cats"}, map[string][]string{}},
+		{"hashtag-only, as a hashtag", []store.Poll{with(pets, func(p *store.Poll) { p.HashtagOnly = true })}, tagged("#cats", "cats"), map[string][]string{"p": {"cats"}}},
+
+		// retweets, quotes and replies
+		{"excluding retweets", []store.Poll{with(pets, func(p *store.Poll) { p.ExcludeRetweets = true })},
+			stream.Tweet{Text: "RT @pets: cats", RetweetedStatus: &stream.Tweet{Text: "cats"}}, map[string][]string{}},
+		{"excluding quotes", []store.Poll{with(pets, func(p *store.Poll) { p.ExcludeQuotes = true })}, quoting("cats", "dogs?"), map[string][]string{}},
+		{"excluding replies", []store.Poll{with(pets, func(p *store.Poll) { p.ExcludeReplies = true })},
+			stream.Tweet{Text: "cats", InReplyToStatusID: "1"}, map[string][]string{}},
+		{"excluding replies, a quote", []store.Poll{with(pets, func(p *store.Poll) { p.ExcludeReplies = true })}, quoting("cats", "dogs?"), map[string][]string{"p": {"cats"}}},
+		{"the quoted tweet scanned", []store.Poll{with(pets, func(p *store.Poll) { p.EmbeddedText = store.EmbeddedScan })},
+			quoting("so true", "cats rule"), map[string][]string{"p": {"cats"}}},
+		{"the quoted tweet not scanned", []store.Poll{pets}, quoting("so true", "cats rule"), map[string][]string{}},
+		{"the quoted tweet scanned for another poll", []store.Poll{
+			with(poll("scan", "cats"), func(p *store.Poll) { p.EmbeddedText = store.EmbeddedScan }),
+			with(poll("ignore", "cats"), func(p *store.Poll) { p.EmbeddedText = store.EmbeddedIgnore }),
+			poll("default", "cats"),
+		}, quoting("so true", "cats rule"), map[string][]string{"scan": {"cats"}, "default": {"cats"}}},
+
+		// case-sensitive and exact options
+		{"case-sensitive, in another case", []store.Poll{with(poll("p", "iOS"), matching(store.OptionMatching{Option: "iOS", CaseSensitive: true}))},
+			stream.Tweet{Text: "ios update"}, map[string][]string{}},
+		{"case-sensitive, as written", []store.Poll{with(poll("p", "iOS"), matching(store.OptionMatching{Option: "iOS", CaseSensitive: true}))},
+			stream.Tweet{Text: "iOS update"}, map[string][]string{"p": {"iOS"}}},
+		{"exact, inside a word", []store.Poll{with(poll("p", "cat"), matching(store.OptionMatching{Option: "cat", Exact: true}))},
+			stream.Tweet{Text: "concatenate"}, map[string][]string{}},
+		{"exact, a whole word", []store.Poll{with(poll("p", "cat"), matching(store.OptionMatching{Option: "cat", Exact: true}))},
+			stream.Tweet{Text: "my cat"}, map[string][]string{"p": {"cat"}}},
+		{"not exact, inside a word", []store.Poll{poll("p", "cat")}, stream.Tweet{Text: "concatenate"}, map[string][]string{"p": {"cat"}}},
+
+		// folding
+		{"folding diacritics", []store.Poll{with(poll("p", "café"), func(p *store.Poll) { p.Folding = store.FoldDiacritics })},
+			stream.Tweet{Text: "cafe au lait"}, map[string][]string{"p": {"café"}}},
+		{"folding diacritics, exact", []store.Poll{with(poll("p", "café"), func(p *store.Poll) {
+			p.Folding, p.Matching = store.FoldDiacritics, []store.OptionMatching{{Option: "café", Exact: true}}
+		})}, stream.Tweet{Text: "cafe au lait"}, map[string][]string{"p": {"café"}}},
+		{"folding diacritics, exact, inside a word", []store.Poll{with(poll("p", "café"), func(p *store.Poll) {
+			p.Folding, p.Matching = store.FoldDiacritics, []store.OptionMatching{{Option: "café", Exact: true}}
+		})}, stream.Tweet{Text: "cafeteria"}, map[string][]string{}},
+		{"folded for another poll", []store.Poll{
+			with(poll("folding", "café"), func(p *store.Poll) { p.Folding = store.FoldDiacritics }),
+			poll("strict", "café"),
+		}, stream.Tweet{Text: "cafe au lait"}, map[string][]string{"folding": {"café"}}},
+		{"transliterating", []store.Poll{with(poll("p", "Москва"), func(p *store.Poll) { p.Folding = store.FoldTransliterate })},
+			stream.Tweet{Text: "Moskva"}, map[string][]string{"p": {"Москва"}}},
+		{"folding diacritics, in another alphabet", []store.Poll{
+			with(poll("translit", "Москва"), func(p *store.Poll) { p.Folding = store.FoldTransliterate }),
+			with(poll("diacritics", "Москва"), func(p *store.Poll) { p.Folding = store.FoldDiacritics }),
+		}, stream.Tweet{Text: "Moskva"}, map[string][]string{"translit": {"Москва"}}},
+
+		// stemming
+		{"plurals", []store.Poll{with(poll("p", "puppy"), matching(store.OptionMatching{Option: "puppy", Fuzziness: store.FuzzyPlural}))},
+			stream.Tweet{Text: "puppies"}, map[string][]string{"p": {"puppy"}}},
+		{"stemmed for another poll", []store.Poll{
+			with(poll("fuzzy", "vote"), matching(store.OptionMatching{Option: "vote", Fuzziness: store.FuzzyStem})),
+			poll("plain", "vote"),
+		}, stream.Tweet{Text: "stop voting"}, map[string][]string{"fuzzy": {"vote"}}},
+		{"stemmed, found as written too", []store.Poll{
+			with(poll("fuzzy", "vote"), matching(store.OptionMatching{Option: "vote", Fuzziness: store.FuzzyStem})),
+			poll("plain", "vote"),
+		}, stream.Tweet{Text: "vote now"}, map[string][]string{"fuzzy": {"vote"}, "plain": {"vote"}}},
+
+		// filter and route expressions
+		{"a filter met", []store.Poll{with(pets, func(p *store.Poll) { p.Filter = `lang == "en"` })}, inLang("en", "cats"), map[string][]string{"p": {"cats"}}},
+		{"a filter failed", []store.Poll{with(pets, func(p *store.Poll) { p.Filter = `lang == "en"` })}, inLang("fr", "cats"), map[string][]string{}},
+		{"a filter failed, another poll counting", []store.Poll{
+			with(poll("en", "cats"), func(p *store.Poll) { p.Filter = `lang == "en"` }),
+			poll("all", "cats"),
+		}, inLang("fr", "cats"), map[string][]string{"all": {"cats"}}},
+		{"a route met", []store.Poll{
+			with(poll("team", "cats"), func(p *store.Poll) { p.Route = `hashtag("team")` }),
+			poll("all", "cats"),
+		}, tagged("cats #team", "team"), map[string][]string{"team": {"cats"}, "all": {"cats"}}},
+		{"a route failed", []store.Poll{
+			with(poll("team", "cats"), func(p *store.Poll) { p.Route = `hashtag("team")` }),
+			poll("all", "cats"),
+		}, stream.Tweet{Text: "cats"}, map[string][]string{"all": {"cats"}}},
+		{"a route of an option not shared", []store.Poll{
+			with(poll("team", "cats", "dogs"), func(p *store.Poll) { p.Route = `hashtag("team")` }),
+			poll("all", "cats"),
+		}, stream.Tweet{Text: "dogs"}, map[string][]string{"team": {"dogs"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := countedFor(tt.polls, tt.tweet); !reflect.DeepEqual(got, tt.counted) {
+				t.Errorf("counted %v, want %v", got, tt.counted)
+			}
+		})
+	}
+}
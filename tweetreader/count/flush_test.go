@@ -0,0 +1,108 @@
+package count
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// flushStep votes, moves the clock on and then checks what was flushed
+type flushStep struct {
+	votes   int
+	advance time.Duration
+	flushes int // in all, so far
+	counted int
+}
+
+func TestRunLocalFlushesOnTheClock(t *testing.T) {
+	tests := []struct {
+		name  string
+		steps []flushStep
+	}{
+		{"not before the interval", []flushStep{{2, 59 * time.Second, 0, 0}, {0, time.Second, 1, 2}}},
+		{"every interval", []flushStep{{1, time.Minute, 1, 1}, {2, time.Minute, 2, 3}, {0, 30 * time.Second, 2, 3}}},
+		{"once when late by several intervals", []flushStep{{1, 3 * time.Minute, 1, 1}, {1, time.Minute, 2, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := store.NewMemory(store.Poll{ID: "p", Options: []string{"yes", "no"}, Status: "active"})
+			clk := clock.NewFake(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+			var mu sync.Mutex
+			flushes := 0
+			messages := make(chan []byte)
+			done := make(chan error)
+			go func() {
+				done <- RunLocal(Config{
+					MetricsAddr:      "127.0.0.1:0",
+					MetricsMaxSeries: 100,
+					UpdateInterval:   time.Minute,
+					SnapshotInterval: time.Hour,
+					PollCacheTTL:     time.Minute,
+					TimeSeries:       timeseries.Config{Backend: "none"},
+					Clock:            clk,
+					Timing: func(stage string, took time.Duration) {
+						if stage == "flush" {
+							mu.Lock()
+							flushes++
+							mu.Unlock()
+						}
+					},
+				}, db, messages)
+			}()
+			flushed := func() int {
+				mu.Lock()
+				defer mu.Unlock()
+				return flushes
+			}
+			// the flush and snapshot tickers
+			for deadline := time.Now().Add(5 * time.Second); clk.Waiters() < 2; {
+				if time.Now().After(deadline) {
+					t.Fatal("the counter isn't waiting on the clock")
+				}
+				time.Sleep(time.Millisecond)
+			}
+
+			id := 0
+			for i, step := range tt.steps {
+				for j := 0; j < step.votes; j++ {
+					id++
+					v := match.Vote{Option: "yes", Weight: 1}
+					v.ID, v.Text = strconv.Itoa(id), "yes"
+					b, err := codec.Encode(codec.JSON, &v)
+					if err != nil {
+						t.Fatal(err)
+					}
+					messages <- b
+				}
+				clk.Advance(step.advance)
+				for deadline := time.Now().Add(5 * time.Second); flushed() < step.flushes; {
+					if time.Now().After(deadline) {
+						t.Fatalf("step %d: %d flushes, want %d", i, flushed(), step.flushes)
+					}
+					time.Sleep(time.Millisecond)
+				}
+				// once received, the loop is done with what came before
+				messages <- []byte("{")
+				messages <- []byte("{")
+				if got := flushed(); got != step.flushes {
+					t.Fatalf("step %d: %d flushes, want %d", i, got, step.flushes)
+				}
+				p, _ := db.Poll("p")
+				if got := p.Results["yes"]; got != step.counted {
+					t.Fatalf("step %d: %d votes counted, want %d", i, got, step.counted)
+				}
+			}
+			close(messages)
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
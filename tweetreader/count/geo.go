@@ -0,0 +1,91 @@
+package count
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Filtered polls only count some of the votes for their options, see countedBy, and polls
+// with a geo aggregation also tally every vote under its country or region. Both need
+// to look at each vote, so they get their own tallies next to the per-option ones.
+
+// unknownArea collects the votes that can't be placed in a country or region
+const unknownArea = "unknown"
+
+// pollGeo holds the votes for one poll that were looked at individually
+type pollGeo struct {
+	tallies map[string]*tally         // per option, for filtered polls
+	areas   map[string]map[string]int // per area and option, for polls with a geo aggregation
+}
+
+// inside reports whether a vote falls inside one of the poll's locations
+func inside(p *store.Poll, g *match.Geo) bool {
+	if g == nil {
+		return false
+	}
+	for _, b := range p.Locations {
+		if b.Contains(g.Longitude, g.Latitude) {
+			return true
+		}
+	}
+	return false
+}
+
+// area returns the country code, or country code and region, a vote is tallied under
+func area(mode string, g *match.Geo) string {
+	if g == nil || g.CountryCode == "" {
+		return unknownArea
+	}
+	if mode == store.GeoByRegion {
+		if g.Region == "" {
+			return g.CountryCode + "/" + unknownArea
+		}
+		return g.CountryCode + "/" + g.Region
+	}
+	return g.CountryCode
+}
+
+// tallyGeo records v for the polls that need to see each vote, must be called with countsLock held.
+// polls are the ones v counts for, see countedBy.
+func (c *Counter) tallyGeo(v vote, polls []*store.Poll) {
+	for _, p := range polls {
+		if !filtered(p) && p.GeoAggregation == "" {
+			continue
+		}
+		if c.geo == nil {
+			c.geo = make(map[string]*pollGeo)
+		}
+		pg := c.geo[p.ID]
+		if pg == nil {
+			pg = &pollGeo{tallies: make(map[string]*tally), areas: make(map[string]map[string]int)}
+			c.geo[p.ID] = pg
+		}
+		if filtered(p) {
+			t := pg.tallies[v.Option]
+			if t == nil {
+				t = &tally{}
+				pg.tallies[v.Option] = t
+			}
+			t.Count += v.votes()
+			t.Weighted += v.Weight * float64(v.votes())
+		}
+		if p.GeoAggregation != "" {
+			a := area(p.GeoAggregation, v.Geo)
+			if pg.areas[a] == nil {
+				pg.areas[a] = make(map[string]int)
+			}
+			pg.areas[a][v.Option] += v.votes()
+		}
+	}
+}
+
+// tallyFor returns the votes to add to a poll for option: its own tally when it is filtered
+func (c *Counter) tallyFor(p *store.Poll, option string) *tally {
+	if !filtered(p) {
+		return c.tallies[option]
+	}
+	if pg := c.geo[p.ID]; pg != nil {
+		return pg.tallies[option]
+	}
+	return nil
+}
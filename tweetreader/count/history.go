@@ -0,0 +1,70 @@
+package count
+
+import (
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// HistoryConfig schedules the copies of every poll's results kept in results_history.
+// Unlike the time series, which holds the votes of each bucket, an entry is the
+// running total at that moment, so a chart can be drawn from the entries alone.
+type HistoryConfig struct {
+	Interval     time.Duration // how often the results are copied, 0 disables the history
+	Retention    time.Duration // entries older than this are deleted, 0 keeps them forever
+	CompactAfter time.Duration // entries older than this are thinned out, 0 never thins
+	CompactEvery time.Duration // thinned entries keep one per this much time
+}
+
+// historyTicks returns the channel the history is written on, nil when it is disabled
+// or the store can't keep it
+func (c *Counter) historyTicks() (<-chan time.Time, func()) {
+	if c.cfg.History.Interval <= 0 {
+		return nil, func() {}
+	}
+	if _, ok := c.db.(store.HistoryStore); !ok {
+		log.Println("this store doesn't keep a results history, not writing one")
+		return nil, func() {}
+	}
+	t := c.clock.NewTicker(c.cfg.History.Interval)
+	return t.C(), t.Stop
+}
+
+// saveHistory copies every poll's results into the history and compacts it
+func (c *Counter) saveHistory() {
+	h := c.db.(store.HistoryStore)
+	polls, err := c.db.Polls()
+	if err != nil {
+		log.Println("failed to load polls for the results history:", err)
+		return
+	}
+	now := time.Now().UTC().Truncate(time.Second)
+	entries := make([]store.HistoryEntry, 0, len(polls))
+	for _, p := range polls {
+		if len(p.Results) == 0 {
+			continue
+		}
+		entries = append(entries, store.HistoryEntry{Poll: p.ID, Time: now, Results: p.Results, WeightedResults: p.WeightedResults})
+	}
+	if err := h.SaveHistory(entries); err != nil {
+		log.Println("failed to save the results history:", err)
+		return
+	}
+
+	cfg := c.cfg.History
+	if cfg.Retention <= 0 && (cfg.CompactAfter <= 0 || cfg.CompactEvery <= 0) {
+		return
+	}
+	// the zero time deletes nothing and thins nothing
+	var deleteBefore, thinBefore time.Time
+	if cfg.Retention > 0 {
+		deleteBefore = now.Add(-cfg.Retention)
+	}
+	if cfg.CompactAfter > 0 && cfg.CompactEvery > 0 {
+		thinBefore = now.Add(-cfg.CompactAfter)
+	}
+	if err := h.CompactHistory(deleteBefore, thinBefore, cfg.CompactEvery); err != nil {
+		log.Println("failed to compact the results history:", err)
+	}
+}
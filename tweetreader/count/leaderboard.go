@@ -0,0 +1,88 @@
+package count
+
+import (
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/leaderboard"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+var leaderboardErrors = metrics.NewCounter("tweetreader_count_leaderboard_errors_total",
+	"Leaderboard updates that failed, by operation: add or rebuild.")
+
+// Leaderboard keeps the polls' results where the result pages read them, see leaderboard
+type Leaderboard interface {
+	// Add adds the votes of deltas, by poll and option
+	Add(deltas map[string]map[string]int) error
+	// Set replaces the results of poll with results
+	Set(poll string, options []string, results map[string]int) error
+	// Remove forgets poll
+	Remove(poll string) error
+}
+
+// leaderboardTicks returns the channel the leaderboards are rebuilt on, nil
+// when there are none or they are never rebuilt
+func (c *Counter) leaderboardTicks() (<-chan time.Time, func()) {
+	if c.cfg.Leaderboard == nil || c.cfg.LeaderboardInterval <= 0 {
+		return nil, func() {}
+	}
+	t := c.clock.NewTicker(c.cfg.LeaderboardInterval)
+	return t.C(), t.Stop
+}
+
+// addLeaderboard adds what a flush wrote to the results to the leaderboards.
+// They are a copy for reading, a failure is only logged and the next rebuild
+// sets them right.
+func (c *Counter) addLeaderboard(points []timeseries.Point) {
+	if c.cfg.Leaderboard == nil || len(points) == 0 {
+		return
+	}
+	deltas := make(map[string]map[string]int)
+	for _, p := range points {
+		if deltas[p.Poll] == nil {
+			deltas[p.Poll] = make(map[string]int)
+		}
+		deltas[p.Poll][p.Option] += p.Count
+	}
+	if err := c.cfg.Leaderboard.Add(deltas); err != nil {
+		leaderboardErrors.Inc("operation", "add")
+		if err != leaderboard.ErrUnavailable {
+			log.Println("failed to update the leaderboards:", err)
+		}
+	}
+}
+
+// rebuildLeaderboards sets the leaderboard of every poll this counter counts
+// to its stored results, and drops those of the deleted polls. The tallies
+// are held meanwhile, so no flush adds to a leaderboard between reading its
+// results and setting it.
+func (c *Counter) rebuildLeaderboards() {
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	polls, err := c.db.Polls()
+	if err != nil {
+		log.Println("failed to load the polls to rebuild the leaderboards:", err)
+		return
+	}
+	for i := range polls {
+		p := &polls[i]
+		if !c.cfg.counts(p) {
+			continue
+		}
+		if p.State() == store.StatusDeleted {
+			err = c.cfg.Leaderboard.Remove(p.ID)
+		} else {
+			err = c.cfg.Leaderboard.Set(p.ID, p.Options, p.Results)
+		}
+		if err != nil {
+			leaderboardErrors.Inc("operation", "rebuild")
+			if err != leaderboard.ErrUnavailable {
+				log.Println("failed to rebuild the leaderboard of poll", p.ID+":", err)
+			}
+			return
+		}
+	}
+}
@@ -0,0 +1,199 @@
+package count
+
+import (
+	"container/list"
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/spill"
+)
+
+var (
+	ledgerEntries = metrics.NewGauge("tweetreader_count_ledger_entries",
+		"Entries the counter's ledgers keep in memory, by ledger: votes or authors, as of the last prune.")
+	ledgerEvictions = metrics.NewCounter("tweetreader_count_ledger_evictions_total",
+		"Entries evicted from a ledger over its memory limit, by ledger and where they went: disk or dropped.")
+	ledgerSpillHits = metrics.NewCounter("tweetreader_count_ledger_spill_hits_total",
+		"Votes and authors found in a ledger's spill on disk, by ledger.")
+	ledgerSpillErrors = metrics.NewCounter("tweetreader_count_ledger_spill_errors_total",
+		"Failed reads and writes of a ledger's spill on disk, by ledger.")
+)
+
+// ledger remembers the votes counted within window so redelivered votes are counted once.
+// With a limit it keeps that many in memory at most, evicting the least recently
+// seen to its spill on disk, or forgetting them without one.
+// It is only used with the counter's countsLock held.
+type ledger struct {
+	name   string // votes or authors, for the metrics
+	window time.Duration
+	limit  int        // entries kept in memory at most, 0 for no limit
+	spill  *spill.Set // where evicted entries go, nil to forget them
+	seen   map[string]*list.Element
+	order  *list.List // of *seenAt, the least recently seen first
+}
+
+// seenAt is when a key was seen last
+type seenAt struct {
+	key string
+	at  time.Time
+}
+
+func newLedger(window time.Duration) *ledger {
+	return &ledger{window: window, seen: make(map[string]*list.Element), order: list.New()}
+}
+
+// openLedger creates a ledger keeping limit entries in memory at most, spilling
+// the evicted ones to dir when set
+func openLedger(name string, window time.Duration, limit int, dir string) (*ledger, error) {
+	l := newLedger(window)
+	l.name, l.limit = name, limit
+	if limit > 0 && dir != "" && window > 0 {
+		s, err := spill.Open(filepath.Join(dir, name), window)
+		if err != nil {
+			return nil, err
+		}
+		l.spill = s
+	}
+	return l, nil
+}
+
+// openLedgers creates the counter's ledgers, spilling to a directory of
+// their own under LedgerSpillDir, by topic like the snapshot
+func (c *Counter) openLedgers() error {
+	var dir string
+	if c.cfg.LedgerSpillDir != "" {
+		dir = filepath.Join(c.cfg.LedgerSpillDir, c.snapshotKey())
+	}
+	var err error
+	if c.ledger, err = openLedger("votes", c.cfg.DedupWindow, c.cfg.LedgerLimit, dir); err != nil {
+		return fmt.Errorf("failed to open the votes ledger: %v", err)
+	}
+	if c.authors, err = openLedger("authors", c.cfg.AuthorsWindow, c.cfg.LedgerLimit, dir); err != nil {
+		c.ledger.close()
+		return fmt.Errorf("failed to open the authors ledger: %v", err)
+	}
+	return nil
+}
+
+// Seen records a vote, keyed by its message ID, and reports whether it was already counted
+func (l *ledger) Seen(id string, now time.Time) bool {
+	if e, ok := l.seen[id]; ok {
+		s := e.Value.(*seenAt)
+		if now.Sub(s.at) < l.window {
+			return true
+		}
+		s.at = now
+		l.order.MoveToBack(e)
+		return false
+	}
+	if l.spilled(id, now) {
+		return true
+	}
+	l.add(id, now)
+	return false
+}
+
+// spilled reports whether id was evicted to the spill within the window
+func (l *ledger) spilled(id string, now time.Time) bool {
+	if l.spill == nil {
+		return false
+	}
+	at, ok, err := l.spill.Get(id)
+	if err != nil {
+		// counted, rather than stopping counting until the disk is fixed
+		ledgerSpillErrors.Inc("ledger", l.name)
+		log.Printf("failed to read the %s ledger's spill: %v", l.name, err)
+		return false
+	}
+	if !ok || now.Sub(at) >= l.window {
+		return false
+	}
+	ledgerSpillHits.Inc("ledger", l.name)
+	return true
+}
+
+// add records id seen at, evicting the least recently seen over the limit
+func (l *ledger) add(id string, at time.Time) {
+	l.seen[id] = l.order.PushBack(&seenAt{key: id, at: at})
+	for l.limit > 0 && l.order.Len() > l.limit {
+		l.evict(at)
+	}
+}
+
+// evict drops the least recently seen entry, spilling it while within the window at now
+func (l *ledger) evict(now time.Time) {
+	s := l.order.Remove(l.order.Front()).(*seenAt)
+	delete(l.seen, s.key)
+	if l.spill == nil || now.Sub(s.at) >= l.window {
+		ledgerEvictions.Inc("ledger", l.name, "to", "dropped")
+		return
+	}
+	if err := l.spill.Add(s.key, s.at); err != nil {
+		ledgerSpillErrors.Inc("ledger", l.name)
+		log.Printf("failed to spill the %s ledger: %v", l.name, err)
+		ledgerEvictions.Inc("ledger", l.name, "to", "dropped")
+		return
+	}
+	ledgerEvictions.Inc("ledger", l.name, "to", "disk")
+}
+
+// prune forgets the tweets that fell out of the window
+func (l *ledger) prune(now time.Time) {
+	for e := l.order.Front(); e != nil; {
+		next := e.Next()
+		if s := e.Value.(*seenAt); now.Sub(s.at) >= l.window {
+			l.order.Remove(e)
+			delete(l.seen, s.key)
+		}
+		e = next
+	}
+	if l.spill != nil {
+		if err := l.spill.Prune(now); err != nil {
+			log.Printf("failed to prune the %s ledger's spill: %v", l.name, err)
+		}
+	}
+	if l.name != "" {
+		ledgerEntries.Set(float64(l.order.Len()), "ledger", l.name)
+	}
+}
+
+// entries returns what the ledger keeps in memory, for the snapshot
+func (l *ledger) entries() map[string]time.Time {
+	m := make(map[string]time.Time, len(l.seen))
+	for id, e := range l.seen {
+		m[id] = e.Value.(*seenAt).at
+	}
+	return m
+}
+
+// restore records the entries of a snapshot, the oldest first, so the most
+// recent are the ones kept in memory
+func (l *ledger) restore(entries map[string]time.Time) {
+	ids := make([]string, 0, len(entries))
+	for id := range entries {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return entries[ids[i]].Before(entries[ids[j]]) })
+	for _, id := range ids {
+		if e, ok := l.seen[id]; ok {
+			l.order.Remove(e)
+		}
+		l.add(id, entries[id])
+	}
+}
+
+// len returns how many entries the ledger keeps in memory
+func (l *ledger) len() int {
+	return l.order.Len()
+}
+
+// close closes the spill, what it holds is kept for the next start
+func (l *ledger) close() {
+	if l.spill != nil {
+		l.spill.Close()
+	}
+}
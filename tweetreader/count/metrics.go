@@ -0,0 +1,227 @@
+package count
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Per-option labels multiply quickly once there are hundreds of polls,
+// so the counter only exports aggregate vote metrics by default.
+// Polls opt in to per-option series with detailed_metrics, and the total
+// number of labelled series is capped so a burst of opted-in polls can't
+// blow up the metrics backend.
+
+// series identifies one labelled time series
+type series struct {
+	poll, option string
+}
+
+// voteMetrics holds the counter's Prometheus metrics
+type voteMetrics struct {
+	mu        sync.Mutex
+	max       int
+	votes     float64
+	weighted  float64
+	perOption map[series]float64
+	dropped   float64 // observations dropped by the cardinality guard
+	duplicate float64 // redelivered votes that were not counted again
+	repeated  float64 // votes of authors unique_authors polls had already counted
+	suspect   float64 // votes tagged suspect by the streamers
+	codecs    map[string]float64
+	rejected  map[string]float64 // votes polls not taking votes left out, by the poll's status
+}
+
+func newVoteMetrics(max int) *voteMetrics {
+	return &voteMetrics{max: max, perOption: make(map[series]float64)}
+}
+
+// Observe records a vote for option, adding per-option series for polls that opted in
+func (m *voteMetrics) Observe(v vote, polls []*store.Poll) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := float64(v.votes())
+	m.votes += n
+	m.weighted += v.Weight * n
+	for _, p := range polls {
+		if !p.DetailedMetrics {
+			continue
+		}
+		s := series{poll: p.ID, option: v.Option}
+		if _, ok := m.perOption[s]; !ok && len(m.perOption) >= m.max {
+			m.dropped++
+			continue
+		}
+		m.perOption[s] += n
+	}
+}
+
+// Message records a vote message decoded with the named codec
+func (m *voteMetrics) Message(codec string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.codecs == nil {
+		m.codecs = make(map[string]float64)
+	}
+	m.codecs[codec]++
+}
+
+// Duplicate records a vote that was skipped because its tweet was already counted
+func (m *voteMetrics) Duplicate() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.duplicate++
+}
+
+// RepeatAuthor records a vote a unique_authors poll didn't count, its author was already counted
+func (m *voteMetrics) RepeatAuthor() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.repeated++
+}
+
+// Rejected records a vote a poll with status didn't count, it takes no votes
+func (m *voteMetrics) Rejected(status string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.rejected == nil {
+		m.rejected = make(map[string]float64)
+	}
+	m.rejected[status]++
+}
+
+// Suspect records a vote the streamer tagged suspect
+func (m *voteMetrics) Suspect() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.suspect++
+}
+
+// Totals returns the aggregate counters for the snapshot
+func (m *voteMetrics) Totals() (votes, weighted, dropped float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.votes, m.weighted, m.dropped
+}
+
+// Restore puts back the aggregate counters from a snapshot
+func (m *voteMetrics) Restore(votes, weighted, dropped float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.votes, m.weighted, m.dropped = votes, weighted, dropped
+}
+
+// Seed starts a poll's per-option series at its stored results
+func (m *voteMetrics) Seed(pollID string, results map[string]int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for option, n := range results {
+		s := series{poll: pollID, option: option}
+		if _, ok := m.perOption[s]; !ok && len(m.perOption) >= m.max {
+			m.dropped++
+			continue
+		}
+		m.perOption[s] = float64(n)
+	}
+}
+
+// ServeHTTP writes the metrics in the Prometheus text exposition format
+func (m *voteMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	writeMetric(w, "twitterpoll_votes_total", "counter", "Votes counted across all polls.", m.votes)
+	writeMetric(w, "twitterpoll_weighted_votes_total", "counter", "Sum of vote weights across all polls.", m.weighted)
+	writeMetric(w, "twitterpoll_metrics_series", "gauge", "Per-option series currently exported.", float64(len(m.perOption)))
+	writeMetric(w, "twitterpoll_metrics_series_limit", "gauge", "Maximum number of per-option series.", float64(m.max))
+	writeMetric(w, "twitterpoll_metrics_series_dropped_total", "counter", "Observations dropped by the cardinality guard.", m.dropped)
+	writeMetric(w, "twitterpoll_duplicate_votes_total", "counter", "Redelivered votes that were not counted again.", m.duplicate)
+	writeMetric(w, "twitterpoll_repeat_author_votes_total", "counter", "Votes unique_authors polls didn't count, their author already voted for the option.", m.repeated)
+	writeMetric(w, "twitterpoll_suspect_votes_total", "counter", "Votes streamers tagged suspect, cast during a spike for their option.", m.suspect)
+
+	names := make([]string, 0, len(m.codecs))
+	for name := range m.codecs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	fmt.Fprintln(w, "# HELP twitterpoll_messages_total Vote messages received per codec.")
+	fmt.Fprintln(w, "# TYPE twitterpoll_messages_total counter")
+	for _, name := range names {
+		fmt.Fprintf(w, "twitterpoll_messages_total{codec=\"%s\"} %v\n", escapeLabel(name), m.codecs[name])
+	}
+
+	statuses := make([]string, 0, len(m.rejected))
+	for status := range m.rejected {
+		statuses = append(statuses, status)
+	}
+	sort.Strings(statuses)
+	fmt.Fprintln(w, "# HELP twitterpoll_rejected_votes_total Votes polls not taking votes didn't count, by the poll's status.")
+	fmt.Fprintln(w, "# TYPE twitterpoll_rejected_votes_total counter")
+	for _, status := range statuses {
+		fmt.Fprintf(w, "twitterpoll_rejected_votes_total{status=\"%s\"} %v\n", escapeLabel(status), m.rejected[status])
+	}
+
+	keys := make([]series, 0, len(m.perOption))
+	for s := range m.perOption {
+		keys = append(keys, s)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].poll != keys[j].poll {
+			return keys[i].poll < keys[j].poll
+		}
+		return keys[i].option < keys[j].option
+	})
+	fmt.Fprintln(w, "# HELP twitterpoll_option_votes_total Votes per option for polls with detailed metrics.")
+	fmt.Fprintln(w, "# TYPE twitterpoll_option_votes_total counter")
+	for _, s := range keys {
+		fmt.Fprintf(w, "twitterpoll_option_votes_total{poll=\"%s\",option=\"%s\"} %v\n", s.poll, escapeLabel(s.option), m.perOption[s])
+	}
+}
+
+// Forget drops every series belonging to a poll, e.g. after it opted out or was deleted
+func (m *voteMetrics) Forget(pollID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for s := range m.perOption {
+		if s.poll == pollID {
+			delete(m.perOption, s)
+		}
+	}
+}
+
+func writeMetric(w http.ResponseWriter, name, typ, help string, v float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %v\n", name, help, name, typ, name, v)
+}
+
+// escapeLabel escapes a label value as the exposition format expects
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+// serveMetrics exposes /metrics in the background, with the probes /healthz
+// and /readyz, ready until ready drains
+func serveMetrics(addr string, m *voteMetrics, ready *shutdown.Readiness) *http.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/healthz", shutdown.Live)
+	mux.Handle("/readyz", ready)
+	// followed by the process wide metrics, such as the runbook's
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		m.ServeHTTP(w, r)
+		metrics.Handler(w, r)
+	})
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		log.Println("Serving metrics on", addr)
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			log.Println("metrics server:", err)
+		}
+	}()
+	return srv
+}
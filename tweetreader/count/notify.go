@@ -0,0 +1,124 @@
+package count
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/notify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// summaryInterval is how often the polls with hourly notifications get a summary
+const summaryInterval = time.Hour
+
+// pollNotifier tells the chat channels of polls with notifications about their
+// milestones as tallies are flushed, and sums up the last hour on every summary
+type pollNotifier struct {
+	db        store.PollStore
+	campaigns store.CampaignStore // nil when the store keeps none
+	out       *notify.Notifier
+
+	mu     sync.Mutex
+	totals map[string]int            // total votes per poll as of the last flush
+	hourly map[string]map[string]int // votes per poll and option since the last summary
+	// the campaigns' counterparts, by campaign and poll
+	known          map[string]knownCampaign
+	campaignTotals map[string]map[string]int
+	campaignHourly map[string]map[string]int
+}
+
+func newPollNotifier(db store.PollStore, out *notify.Notifier) *pollNotifier {
+	n := &pollNotifier{db: db, out: out, totals: make(map[string]int), hourly: make(map[string]map[string]int),
+		known: make(map[string]knownCampaign), campaignTotals: make(map[string]map[string]int), campaignHourly: make(map[string]map[string]int)}
+	n.campaigns, _ = db.(store.CampaignStore)
+	return n
+}
+
+// counted is told about the votes just stored for p
+func (n *pollNotifier) counted(p *store.Poll, counts map[string]int) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	c := n.campaign(p.Campaign)
+	if len(p.Notifications) == 0 && c == nil {
+		return
+	}
+	delta := total(counts)
+	before, ok := n.totals[p.ID]
+	if !ok {
+		// the first flush since the start: the stored results already include counts
+		stored, err := n.db.Poll(p.ID)
+		if err != nil {
+			log.Println("notify: failed to load poll", p.ID, err)
+			return
+		}
+		before = total(stored.Results) - delta
+	}
+	after := before + delta
+	n.totals[p.ID] = after
+	for _, nf := range p.Notifications {
+		for _, m := range nf.Milestones {
+			if before < m && m <= after {
+				n.out.Send(notify.Message{Kind: nf.Kind, URL: nf.URL, Text: fmt.Sprintf("Poll %q reached %d votes", p.Title, m)})
+			}
+		}
+		if nf.Hourly {
+			if n.hourly[p.ID] == nil {
+				n.hourly[p.ID] = make(map[string]int)
+			}
+			for option, v := range counts {
+				n.hourly[p.ID][option] += v
+			}
+		}
+	}
+	if c != nil {
+		n.campaignCounted(c, p.ID, before, delta)
+	}
+}
+
+// summarize posts the summary of every poll that got votes since the last one
+func (n *pollNotifier) summarize() {
+	n.mu.Lock()
+	hourly := n.hourly
+	n.hourly = make(map[string]map[string]int)
+	campaigns := n.summaries()
+	n.mu.Unlock()
+	for id, recent := range hourly {
+		p, err := n.db.Poll(id)
+		if err != nil {
+			log.Println("notify: failed to load poll", id, err)
+			continue
+		}
+		text := summary(&p, recent)
+		for _, nf := range p.Notifications {
+			if nf.Hourly {
+				n.out.Send(notify.Message{Kind: nf.Kind, URL: nf.URL, Text: text})
+			}
+		}
+	}
+	n.summarizeCampaigns(campaigns)
+}
+
+// summary lists the options by total votes, with what they got in the last hour
+func summary(p *store.Poll, recent map[string]int) string {
+	options := append([]string(nil), p.Options...)
+	sort.SliceStable(options, func(i, j int) bool { return p.Results[options[i]] > p.Results[options[j]] })
+	var b strings.Builder
+	fmt.Fprintf(&b, "Poll %q: %d votes in the last hour, %d in total", p.Title, total(recent), total(p.Results))
+	for _, o := range options {
+		fmt.Fprintf(&b, "\n%s: %d (+%d)", o, p.Results[o], recent[o])
+	}
+	return b.String()
+}
+
+// total adds up the votes of every option
+func total(counts map[string]int) int {
+	var n int
+	for _, v := range counts {
+		n += v
+	}
+	return n
+}
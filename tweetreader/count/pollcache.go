@@ -0,0 +1,138 @@
+package count
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// pollCache keeps poll metadata in memory so counting a vote doesn't need a database query.
+// Entries are reloaded when the rest-api announces a change on the poll_events topic,
+// and the whole cache is refreshed every ttl in case an event was missed.
+type pollCache struct {
+	mu       sync.RWMutex
+	s        store.PollStore
+	ttl      time.Duration
+	loaded   time.Time
+	polls    map[string]*store.Poll
+	byOption map[string][]*store.Poll
+	counts   func(p *store.Poll) bool // the polls PollsFor returns
+}
+
+func newPollCache(s store.PollStore, ttl time.Duration, counts func(p *store.Poll) bool) *pollCache {
+	return &pollCache{s: s, ttl: ttl, counts: counts}
+}
+
+// PollsFor returns the polls counted that have option as one of their options
+func (pc *pollCache) PollsFor(option string) ([]*store.Poll, error) {
+	pc.mu.RLock()
+	stale := pc.polls == nil || time.Since(pc.loaded) > pc.ttl
+	pc.mu.RUnlock()
+	if stale {
+		if err := pc.reload(); err != nil {
+			return nil, err
+		}
+	}
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	return pc.byOption[option], nil
+}
+
+// reload replaces the cache with every poll in the database
+func (pc *pollCache) reload() error {
+	all, err := pc.s.Polls()
+	if err != nil {
+		return err
+	}
+	polls := make(map[string]*store.Poll, len(all))
+	for i := range all {
+		polls[all[i].ID] = &all[i]
+	}
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	pc.polls = polls
+	pc.loaded = time.Now()
+	pc.index()
+	return nil
+}
+
+// Get returns the cached metadata for a poll
+func (pc *pollCache) Get(id string) (*store.Poll, bool) {
+	pc.mu.RLock()
+	defer pc.mu.RUnlock()
+	p, ok := pc.polls[id]
+	return p, ok
+}
+
+// Invalidate reloads a single poll, dropping it if it no longer exists
+func (pc *pollCache) Invalidate(id string) {
+	p, err := pc.s.Poll(id)
+	pc.mu.Lock()
+	defer pc.mu.Unlock()
+	if pc.polls == nil {
+		return // nothing cached yet, the next lookup loads everything
+	}
+	switch err {
+	case nil:
+		pc.polls[p.ID] = &p
+	case store.ErrNotFound:
+		delete(pc.polls, id)
+	default:
+		log.Println("failed to reload poll", id, ":", err)
+		pc.loaded = time.Time{} // force a full reload next time
+		return
+	}
+	pc.index()
+}
+
+// index rebuilds the option lookup, must be called with mu held
+func (pc *pollCache) index() {
+	pc.byOption = make(map[string][]*store.Poll)
+	for _, p := range pc.polls {
+		if pc.counts != nil && !pc.counts(p) {
+			continue
+		}
+		for _, option := range p.Options {
+			pc.byOption[option] = append(pc.byOption[option], p)
+		}
+	}
+}
+
+// pollEvent is published by the rest-api whenever a poll changes
+type pollEvent struct {
+	ID     string `json:"id"`
+	Action string `json:"action"`
+}
+
+// watchPollEvents invalidates cache entries as poll change events arrive,
+// and drops detailed metric series for polls that no longer want them
+func watchPollEvents(lookupdAddr string, cfg *nsq.Config, pc *pollCache, metrics *voteMetrics) *nsq.Consumer {
+	q, err := nsq.NewConsumer("poll_events", "counter", cfg)
+	if err != nil {
+		log.Println("poll events disabled:", err)
+		return nil
+	}
+	q.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		var e pollEvent
+		if err := json.Unmarshal(m.Body, &e); err != nil {
+			log.Println("Unmarshall error: ", err)
+			return nil
+		}
+		log.Println("poll", e.ID, e.Action)
+		pc.Invalidate(e.ID)
+		if p, ok := pc.Get(e.ID); !ok || !p.DetailedMetrics {
+			metrics.Forget(e.ID)
+		}
+		return nil
+	}))
+	if err := q.ConnectToNSQLookupd(lookupdAddr); err != nil {
+		log.Println("poll events disabled:", err)
+		return nil
+	}
+	return q
+}
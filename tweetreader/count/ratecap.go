@@ -0,0 +1,114 @@
+package count
+
+import (
+	"math"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var overflowVotes = metrics.NewCounter("tweetreader_count_overflow_votes_total",
+	"Votes over their poll's max_per_minute for their option, kept out of the results and added up as overflow.")
+
+// capBucket holds how many votes an option of a capped poll can still count,
+// refilling at the poll's MaxPerMinute up to as many
+type capBucket struct {
+	votes float64
+	last  time.Time
+	rate  float64 // votes a minute, as of the last vote
+}
+
+// partVote is the part of a vote a capped poll counts
+type partVote struct {
+	v    vote
+	poll *store.Poll
+}
+
+// capped splits polls, the ones counting v, into the ones counting all of it
+// and the capped ones counting only part of it, the votes of v the option has
+// room for; must be called with countsLock held. What goes over the caps is
+// added up in the polls' metrics as store.MetricOverflow.
+func (c *Counter) capped(v vote, polls []*store.Poll, now time.Time) (whole []*store.Poll, parts []partVote) {
+	for _, p := range polls {
+		if p.MaxPerMinute <= 0 {
+			whole = append(whole, p)
+			continue
+		}
+		n := v.votes()
+		room := c.takeVotes(p, v.Option, n, now)
+		if room == n {
+			whole = append(whole, p)
+			continue
+		}
+		c.tallyOverflow(p, v.Option, n-room)
+		if room > 0 {
+			part := v
+			// Count overrides the sampling scale, see votes
+			part.Count = room
+			parts = append(parts, partVote{v: part, poll: p})
+		}
+	}
+	return whole, parts
+}
+
+// takeVotes takes up to n votes from the bucket of option in p, returning how many it had room for
+func (c *Counter) takeVotes(p *store.Poll, option string, n int, now time.Time) int {
+	if c.caps == nil {
+		c.caps = make(map[string]*capBucket)
+	}
+	rate := float64(p.MaxPerMinute)
+	key := p.ID + "/" + option
+	b := c.caps[key]
+	if b == nil {
+		b = &capBucket{votes: rate, last: now}
+		c.caps[key] = b
+	}
+	b.votes = math.Min(rate, b.votes+now.Sub(b.last).Minutes()*rate)
+	b.last, b.rate = now, rate
+	room := int(math.Min(b.votes, float64(n)))
+	if room < 0 {
+		room = 0
+	}
+	b.votes -= float64(room)
+	return room
+}
+
+// tallyOverflow adds the n votes for option over the cap of p to its overflow metric
+func (c *Counter) tallyOverflow(p *store.Poll, option string, n int) {
+	overflowVotes.Add(float64(n))
+	if c.computed == nil {
+		c.computed = make(map[string]map[string]map[string]float64)
+	}
+	metrics := c.computed[p.ID]
+	if metrics == nil {
+		metrics = make(map[string]map[string]float64)
+		c.computed[p.ID] = metrics
+	}
+	if metrics[store.MetricOverflow] == nil {
+		metrics[store.MetricOverflow] = make(map[string]float64)
+	}
+	metrics[store.MetricOverflow][option] += float64(n)
+	// the metrics are written with the results, which the poll has even when every vote overflowed
+	if c.geo == nil {
+		c.geo = make(map[string]*pollGeo)
+	}
+	pg := c.geo[p.ID]
+	if pg == nil {
+		pg = &pollGeo{tallies: make(map[string]*tally), areas: make(map[string]map[string]int)}
+		c.geo[p.ID] = pg
+	}
+	if pg.tallies[option] == nil {
+		pg.tallies[option] = &tally{}
+	}
+}
+
+// sweepCaps drops the buckets full again at now, the options without votes
+// for a minute; must be called with countsLock held
+func (c *Counter) sweepCaps(now time.Time) {
+	for key, b := range c.caps {
+		if b.votes+now.Sub(b.last).Minutes()*b.rate >= b.rate {
+			delete(c.caps, key)
+		}
+	}
+}
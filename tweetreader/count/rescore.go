@@ -0,0 +1,144 @@
+package count
+
+import (
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Rescoring matches the tweets archived for a poll again after its options
+// were edited: with the options the poll had when each tweet came, what it
+// counted then, and with its options now. Both are folded into copies of the
+// poll as Recount does, without the results it has, and the difference is
+// what the edit would have changed had it been made before the tweets came,
+// the deltas that correct the poll's results.
+
+// Rescore folds the votes of a poll's archived tweets as they counted and as
+// they count with its options now
+type Rescore struct {
+	poll          store.Poll
+	before, after *Counter
+	// Tweets is how many tweets were folded, duplicates included
+	Tweets int
+}
+
+// Rescored is what rescoring a poll's archived tweets made of its results
+type Rescored struct {
+	Poll          string
+	Title         string
+	Tweets        int
+	Before, After store.Tallies
+	// Results and Weighted are the deltas to add to the poll's results, per
+	// option, Weighted only for weighted polls
+	Results  map[string]int
+	Weighted map[string]float64
+}
+
+// NewRescore rescores p, whose copies have every option of its versions.
+// dedup and authors are the windows the counter ran with.
+func NewRescore(p store.Poll, dedup, authors time.Duration) (*Rescore, error) {
+	p.Options = allOptions(p)
+	before, _, err := foldingCounter(p, dedup, authors)
+	if err != nil {
+		return nil, err
+	}
+	after, _, err := foldingCounter(p, dedup, authors)
+	if err != nil {
+		return nil, err
+	}
+	return &Rescore{poll: p, before: before, after: after}, nil
+}
+
+// allOptions returns the options of every version of p, each once
+func allOptions(p store.Poll) []string {
+	var options []string
+	seen := make(map[string]bool)
+	add := func(more []string) {
+		for _, o := range more {
+			if !seen[o] {
+				seen[o] = true
+				options = append(options, o)
+			}
+		}
+	}
+	for _, v := range p.Versions {
+		add(v.Options)
+	}
+	add(p.Options)
+	return options
+}
+
+// Fold folds the votes a tweet received at at cast: before with the options
+// it was matched against when it came and after with the options now
+func (r *Rescore) Fold(before, after []match.Vote, at time.Time) error {
+	r.Tweets++
+	for _, c := range []struct {
+		counter *Counter
+		votes   []match.Vote
+	}{{r.before, before}, {r.after, after}} {
+		if err := foldVotes(c.counter, c.votes, at, r.Tweets); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// foldVotes folds votes into c as Recount folds logged votes, n counting the
+// tweets folded so far
+func foldVotes(c *Counter, votes []match.Vote, at time.Time, n int) error {
+	c.countsLock.Lock()
+	defer c.countsLock.Unlock()
+	if n%recountPrune == 0 {
+		c.ledger.prune(at)
+		c.authors.prune(at)
+	}
+	for _, v := range votes {
+		metas, err := c.polls.PollsFor(v.Option)
+		if err != nil {
+			return err
+		}
+		if key := voteKey(v); key != "" && c.ledger.Seen(key, at) {
+			continue
+		}
+		c.fold(v, metas, at)
+	}
+	return nil
+}
+
+// Done flushes both copies and returns the deltas between them
+func (r *Rescore) Done() (Rescored, error) {
+	rs := Rescored{Poll: r.poll.ID, Title: r.poll.Title, Tweets: r.Tweets, Results: make(map[string]int)}
+	var err error
+	if rs.Before, err = r.flush(r.before); err != nil {
+		return rs, err
+	}
+	if rs.After, err = r.flush(r.after); err != nil {
+		return rs, err
+	}
+	for _, o := range r.poll.Options {
+		if d := rs.After.Results[o] - rs.Before.Results[o]; d != 0 {
+			rs.Results[o] = d
+		}
+		if !r.poll.Weighted() {
+			continue
+		}
+		if d := rs.After.WeightedResults[o] - rs.Before.WeightedResults[o]; d != 0 {
+			if rs.Weighted == nil {
+				rs.Weighted = make(map[string]float64)
+			}
+			rs.Weighted[o] = d
+		}
+	}
+	return rs, nil
+}
+
+// flush counts what c folded into its copy of the poll and returns its results
+func (r *Rescore) flush(c *Counter) (store.Tallies, error) {
+	c.flushFolded()
+	counted, err := c.db.Poll(r.poll.ID)
+	if err != nil {
+		return store.Tallies{}, err
+	}
+	return store.TalliesOf(counted), nil
+}
@@ -0,0 +1,211 @@
+package count
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/retract"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var (
+	retractedVotes = metrics.NewCounter("tweetreader_count_retracted_votes_total",
+		"Votes taken back out of the results because Twitter asked to stop showing their tweets, by kind.")
+	unknownRetractions = metrics.NewCounter("tweetreader_count_unknown_retractions_total",
+		"Tweets retracted that the counter doesn't remember counting: never votes, counted too long ago, rolled up, or by another counter.")
+)
+
+// maxRetractable caps how many tweets are remembered to be retracted, the
+// oldest are forgotten first
+const maxRetractable = 200000
+
+// contribution is what a vote added to the results of its polls
+type contribution struct {
+	option           string
+	polls            []*store.Poll // the polls that counted it
+	votes            int
+	weighted         float64
+	source, language string
+}
+
+// countedTweet is a tweet remembered
+type countedTweet struct {
+	id string
+	at time.Time
+}
+
+// retractable remembers what the tweets counted lately added to the results,
+// for window after they were counted, so it can be taken back out
+type retractable struct {
+	window  time.Duration
+	byTweet map[string][]contribution
+	order   []countedTweet // oldest first
+}
+
+func newRetractable(window time.Duration) *retractable {
+	if window <= 0 {
+		return nil
+	}
+	return &retractable{window: window, byTweet: make(map[string][]contribution)}
+}
+
+// add remembers what the vote for tweet counted at now added, a nil
+// retractable remembers nothing
+func (r *retractable) add(tweet string, c contribution, now time.Time) {
+	if r == nil || tweet == "" || len(c.polls) == 0 {
+		return
+	}
+	for len(r.order) > 0 && (len(r.order) >= maxRetractable || now.Sub(r.order[0].at) > r.window) {
+		delete(r.byTweet, r.order[0].id)
+		r.order = r.order[1:]
+	}
+	if _, ok := r.byTweet[tweet]; !ok {
+		r.order = append(r.order, countedTweet{id: tweet, at: now})
+	}
+	r.byTweet[tweet] = append(r.byTweet[tweet], c)
+}
+
+// take returns what tweet added and forgets it
+func (r *retractable) take(tweet string) ([]contribution, bool) {
+	cs, ok := r.byTweet[tweet]
+	delete(r.byTweet, tweet)
+	return cs, ok
+}
+
+// remember records what v added to the results of polls, must be called
+// with countsLock held
+func (c *Counter) remember(v vote, polls []*store.Poll, now time.Time) {
+	source, language := breakdownKeys(v)
+	c.undo.add(v.ID, contribution{
+		option:   v.Option,
+		polls:    polls,
+		votes:    v.votes(),
+		weighted: v.Weight * float64(v.votes()),
+		source:   source,
+		language: language,
+	}, now)
+}
+
+// tweetDeleter is implemented by stores that keep a copy of every counted
+// tweet and can remove them
+type tweetDeleter interface {
+	DeleteTweets(ids []string) error
+}
+
+// retract takes the votes of the tweets in r back out of the results, and
+// adds them up in the polls' metrics as store.MetricRetracted so the results
+// show what was taken back. The tweets saved are deleted. The geo results
+// and the time series keep the votes.
+func (c *Counter) retract(r retract.Retraction) error {
+	ids := make(map[string]bool, len(r.Tweets))
+	var taken []contribution
+	c.countsLock.Lock()
+	for _, id := range r.Tweets {
+		ids[id] = true
+		cs, ok := c.undo.take(id)
+		if !ok {
+			unknownRetractions.Inc()
+			continue
+		}
+		taken = append(taken, cs...)
+	}
+	// the copies not saved yet never are
+	for t := range c.counts {
+		if ids[t.ID] {
+			delete(c.counts, t)
+		}
+	}
+	c.countsLock.Unlock()
+
+	polls := make(map[string]*store.Poll)
+	counts := make(map[string]map[string]int)
+	weighted := make(map[string]map[string]float64)
+	sources, languages := make(breakdown), make(breakdown)
+	for _, ct := range taken {
+		for _, p := range ct.polls {
+			polls[p.ID] = p
+			if counts[p.ID] == nil {
+				counts[p.ID] = make(map[string]int)
+				weighted[p.ID] = make(map[string]float64)
+			}
+			counts[p.ID][ct.option] -= ct.votes
+			weighted[p.ID][ct.option] -= ct.weighted
+			sources.add(p.ID, ct.source, ct.option, -ct.votes)
+			languages.add(p.ID, ct.language, ct.option, -ct.votes)
+		}
+		retractedVotes.Add(float64(ct.votes), "kind", r.Kind)
+	}
+	var failed error
+	for id, p := range polls {
+		var w map[string]float64
+		if p.Weighted() {
+			w = weighted[id]
+		}
+		if err := c.db.AddResults(id, counts[id], w); err != nil {
+			failed = err
+			continue
+		}
+		if err := c.db.AddSourceResults(id, sources[id]); err != nil {
+			failed = err
+		}
+		if err := c.db.AddLanguageResults(id, languages[id]); err != nil {
+			failed = err
+		}
+		flagged := make(map[string]float64, len(counts[id]))
+		for option, n := range counts[id] {
+			flagged[option] = float64(-n)
+		}
+		if err := c.db.AddMetrics(id, map[string]map[string]float64{store.MetricRetracted: flagged}); err != nil {
+			failed = err
+		}
+	}
+	if d, ok := c.db.(tweetDeleter); ok {
+		if err := d.DeleteTweets(r.Tweets); err != nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		c.cfg.Events.Emit(events.CountStoreError, failed.Error())
+		return fmt.Errorf("failed to retract %s tweets: %v", r.Kind, failed)
+	}
+	return nil
+}
+
+// watchRetractions takes back the votes of the tweets the streamers retract.
+// Every counter has to see every retraction, each takes back the votes it
+// counted, so each listens on its own ephemeral channel.
+func (c *Counter) watchRetractions() *nsq.Consumer {
+	if c.undo == nil {
+		return nil
+	}
+	host, _ := os.Hostname()
+	q, err := nsq.NewConsumer(retract.Topic, fmt.Sprintf("count-%s-%d#ephemeral", host, os.Getpid()), c.cfg.nsqConfig())
+	if err != nil {
+		log.Println("retractions disabled:", err)
+		return nil
+	}
+	q.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		var r retract.Retraction
+		if err := json.Unmarshal(m.Body, &r); err != nil {
+			log.Println("Unmarshall error: ", err)
+			return nil
+		}
+		if err := c.retract(r); err != nil {
+			// not requeued, its tweets are forgotten already and a retry would take nothing back
+			log.Println(err)
+		}
+		return nil
+	}))
+	if err := q.ConnectToNSQLookupd(c.cfg.LookupdAddr); err != nil {
+		log.Println("retractions disabled:", err)
+		return nil
+	}
+	return q
+}
@@ -0,0 +1,48 @@
+package count
+
+import (
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/dedup"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	sharedDuplicates = metrics.NewCounter("tweetreader_count_shared_duplicates_total",
+		"Votes another counter sharing the dedup ledger had counted, skipped.")
+	sharedErrors = metrics.NewCounter("tweetreader_count_shared_dedup_errors_total",
+		"Votes counted without checking the shared dedup ledger, because it failed.")
+)
+
+// SharedLedger is a ledger of the votes counted shared between counters, see dedup
+type SharedLedger interface {
+	// Seen records the vote with message ID id, cast by the streamer
+	// instance, for window and reports whether a counter already recorded it
+	Seen(id, instance string, window time.Duration) (bool, error)
+}
+
+// sharedSeen reports whether a counter sharing the ledger already counted
+// the vote in msg, keyed key. When the ledger fails the vote is counted, the
+// counter's own ledger still drops the redeliveries it gets.
+func (c *Counter) sharedSeen(key string, msg match.Vote) bool {
+	if c.cfg.SharedDedup == nil {
+		return false
+	}
+	seen, err := c.cfg.SharedDedup.Seen(key, msg.Instance, c.cfg.DedupWindow)
+	switch {
+	case err != nil:
+		sharedErrors.Inc()
+		if err != dedup.ErrUnavailable {
+			log.Println("failed to check the shared dedup ledger:", err)
+		}
+		return false
+	case seen:
+		log.Println("skipping tweet another counter counted:", msg.ID, msg.Option, "from", msg.Instance)
+		sharedDuplicates.Inc()
+		c.metrics.Duplicate()
+		return true
+	}
+	return false
+}
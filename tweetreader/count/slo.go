@@ -0,0 +1,47 @@
+package count
+
+import (
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/slo"
+)
+
+// End-to-end latency runs from when a tweet was posted to when its vote was written to the store.
+// Twitter's timestamps are to the second, so the buckets start at a second.
+var latencyBuckets = []float64{1, 2, 5, 10, 30, 60, 120, 300, 600}
+
+var (
+	latency      = slo.NewLatency(latencyBuckets)
+	latencyHist  *metrics.Histogram
+	latencyOnce  sync.Once
+	tweetTimeFmt = time.RubyDate // Twitter's created_at, "Mon Jan 02 15:04:05 -0700 2006"
+)
+
+// maxPending caps how many vote times are kept for the latency between flushes,
+// votes beyond it during a long store outage aren't measured
+const maxPending = 100000
+
+// registerSLO exports the latency metrics, only in processes that count
+func registerSLO() {
+	latencyOnce.Do(func() {
+		latencyHist = metrics.NewHistogram("tweetreader_slo_vote_latency_seconds",
+			"Time from a tweet being posted to its vote being stored.", latencyBuckets)
+		metrics.NewGaugeFunc("tweetreader_slo_vote_latency_p99_seconds",
+			"99th percentile of the vote latency within the SLO window, 0 when there were no votes.",
+			func() float64 { return latency.Quantile(0.99) })
+	})
+}
+
+// observeLatency records the latency of the votes that were just stored
+func observeLatency(created []time.Time, now time.Time) {
+	for _, t := range created {
+		d := now.Sub(t)
+		if d < 0 {
+			d = 0
+		}
+		latency.Observe(d)
+		latencyHist.Observe(d.Seconds())
+	}
+}
@@ -0,0 +1,95 @@
+package count
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Without a warmup a restarted counter starts its metrics from zero and forgets
+// which tweets it has already counted, so NSQ redeliveries get counted twice and
+// the totals on the dashboards dip until traffic catches up. The counter saves
+// a snapshot periodically and on shutdown, and loads it before it consumes.
+
+// snapshotName is the key the counter's state is saved under
+const snapshotName = "counter"
+
+// snapshotKey is snapshotName for the votes topic. Counters of other topics,
+// e.g. replayed votes, save their state of their own under snapshotName_<topic>.
+func (c *Counter) snapshotKey() string {
+	if t := c.cfg.topic(); t != "votes" {
+		return snapshotName + "_" + t
+	}
+	return snapshotName
+}
+
+// snapshot is the counter state that survives a restart
+type snapshot struct {
+	SavedAt  time.Time            `json:"saved_at"`
+	Votes    float64              `json:"votes"`
+	Weighted float64              `json:"weighted"`
+	Dropped  float64              `json:"dropped"`
+	Seen     map[string]time.Time `json:"seen"`
+	// Authors are the authors unique_authors polls counted, by poll, option and author
+	Authors map[string]time.Time `json:"authors,omitempty"`
+}
+
+// warmup restores the last snapshot and seeds the per-option metrics from the stored results
+func (c *Counter) warmup() {
+	data, err := c.db.LoadSnapshot(c.snapshotKey())
+	switch err {
+	case nil:
+		var snap snapshot
+		if err := json.Unmarshal(data, &snap); err != nil {
+			log.Println("ignoring unreadable snapshot:", err)
+			break
+		}
+		c.metrics.Restore(snap.Votes, snap.Weighted, snap.Dropped)
+		now := time.Now()
+		c.ledger.restore(snap.Seen)
+		c.ledger.prune(now)
+		c.authors.restore(snap.Authors)
+		c.authors.prune(now)
+		log.Printf("warmed up from snapshot saved %s ago, %d recent tweets, %d counted authors",
+			now.Sub(snap.SavedAt).Round(time.Second), c.ledger.len(), c.authors.len())
+	case store.ErrNoSnapshot:
+		log.Println("no snapshot, starting cold")
+	default:
+		log.Println("failed to load snapshot, starting cold:", err)
+	}
+
+	polls, err := c.db.Polls()
+	if err != nil {
+		log.Println("failed to load results:", err)
+		return
+	}
+	for _, p := range polls {
+		if p.DetailedMetrics {
+			c.metrics.Seed(p.ID, p.Results)
+		}
+	}
+}
+
+// saveSnapshot writes the counter state to the store
+func (c *Counter) saveSnapshot() {
+	c.countsLock.Lock()
+	c.ledger.prune(time.Now())
+	c.authors.prune(time.Now())
+	snap := snapshot{SavedAt: time.Now(), Seen: c.ledger.entries()}
+	if c.authors.len() > 0 {
+		snap.Authors = c.authors.entries()
+	}
+	c.countsLock.Unlock()
+	snap.Votes, snap.Weighted, snap.Dropped = c.metrics.Totals()
+
+	data, err := json.Marshal(snap)
+	if err != nil {
+		log.Println("failed to encode snapshot:", err)
+		return
+	}
+	if err := c.db.SaveSnapshot(c.snapshotKey(), data); err != nil {
+		log.Println("failed to save snapshot:", err)
+	}
+}
@@ -0,0 +1,39 @@
+package count
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// maxUndelivered caps the share of undelivered tweets a vote is scaled up
+// for, the estimate is meaningless past it
+const maxUndelivered = 0.99
+
+// tallyUndelivered estimates the votes polls missed because Twitter didn't
+// deliver their tweets, must be called with countsLock held. A vote whose
+// connection had a share f of its tweets undelivered stands for 1/(1-f)
+// tweets, f/(1-f) of them missed; the estimate goes in the polls' metrics as
+// store.MetricUndelivered, next to the results it undercounts.
+func (c *Counter) tallyUndelivered(v vote, polls []*store.Poll) {
+	f := v.Undelivered
+	if f <= 0 || len(polls) == 0 {
+		return
+	}
+	if f > maxUndelivered {
+		f = maxUndelivered
+	}
+	missed := f / (1 - f) * float64(v.votes())
+	if c.computed == nil {
+		c.computed = make(map[string]map[string]map[string]float64)
+	}
+	for _, p := range polls {
+		metrics := c.computed[p.ID]
+		if metrics == nil {
+			metrics = make(map[string]map[string]float64)
+			c.computed[p.ID] = metrics
+		}
+		if metrics[store.MetricUndelivered] == nil {
+			metrics[store.MetricUndelivered] = make(map[string]float64)
+		}
+		metrics[store.MetricUndelivered][v.Option] += missed
+	}
+}
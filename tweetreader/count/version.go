@@ -0,0 +1,37 @@
+package count
+
+import (
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// tallyVersions counts v for the polls whose options were edited in the
+// metric of the version of their options it was matched against, must be
+// called with countsLock held. The votes counted before the first edit are
+// all of the first version and left out, so a poll never edited writes no
+// version metric.
+func (c *Counter) tallyVersions(v vote, polls []*store.Poll) {
+	for _, p := range polls {
+		version := match.PollVersion(v.Versions, p.ID)
+		if version == 0 {
+			// streamers that predate versions match against the options stored
+			version = p.OptionsVersion()
+		}
+		if p.OptionsVersion() == 1 && version == 1 {
+			continue
+		}
+		if c.computed == nil {
+			c.computed = make(map[string]map[string]map[string]float64)
+		}
+		metrics := c.computed[p.ID]
+		if metrics == nil {
+			metrics = make(map[string]map[string]float64)
+			c.computed[p.ID] = metrics
+		}
+		name := store.MetricVersion(version)
+		if metrics[name] == nil {
+			metrics[name] = make(map[string]float64)
+		}
+		metrics[name][v.Option] += float64(v.votes())
+	}
+}
@@ -0,0 +1,161 @@
+package count
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/notify"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// The vote log keeps every vote the counter counted, the message as it was
+// consumed, in the store. The results are then only a fold of the log: after
+// a bug in counting is fixed, Recount folds the logged votes again with the
+// fixed counting and the results can be replaced with what they should be.
+// Whether a vote is new and whether its polls accepted votes when it came are
+// settled before it is logged; everything else is decided again by the fold.
+
+// recountPrune is how many votes are folded between prunes of the ledgers
+const recountPrune = 10000
+
+// openVoteLog returns the store's vote log when cfg keeps one, nil otherwise
+func openVoteLog(cfg Config, db store.Backend) store.VoteLogStore {
+	if !cfg.VoteLog {
+		return nil
+	}
+	vl, ok := db.(store.VoteLogStore)
+	if !ok {
+		log.Println("this store doesn't keep a vote log, not writing one")
+		return nil
+	}
+	return vl
+}
+
+// logVote has the vote appended to the vote log with the next flush, when
+// there is one; must be called with countsLock held
+func (c *Counter) logVote(key string, body []byte, metas []*store.Poll, now time.Time) {
+	if c.voteLog == nil {
+		return
+	}
+	var accepting []string
+	for _, p := range metas {
+		if p.AcceptsVotes() {
+			accepting = append(accepting, p.ID)
+		}
+	}
+	if len(accepting) == 0 {
+		return
+	}
+	c.logged = append(c.logged, store.LoggedVote{Key: key, Polls: accepting, Received: now, Body: body})
+}
+
+// Recounted is what folding a poll's logged votes again made of its results
+type Recounted struct {
+	Poll  string
+	Title string
+	// Votes is how many logged votes were folded for the poll, duplicates included
+	Votes         int
+	Before, After store.Tallies
+}
+
+// Recount folds the logged votes of the polls into new results, with the
+// counting of this build, and returns them next to the stored ones; the
+// caller decides whether to replace them. dedup and authors are the windows
+// the counter ran with, a vote falls out of them by the time it was received.
+func Recount(db store.Backend, pollIDs []string, dedup, authors time.Duration) ([]Recounted, error) {
+	vl, ok := db.(store.VoteLogStore)
+	if !ok {
+		return nil, errors.New("this store doesn't keep a vote log")
+	}
+	recounted := make([]Recounted, len(pollIDs))
+	for i, id := range pollIDs {
+		p, err := db.Poll(id)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+		if recounted[i], err = recount(vl, p, dedup, authors); err != nil {
+			return nil, fmt.Errorf("%s: %v", id, err)
+		}
+	}
+	return recounted, nil
+}
+
+// recount folds the logged votes of p into a copy of it in memory, on its own
+// so the polls sharing its options don't share its tallies, see foldingCounter
+func recount(vl store.VoteLogStore, p store.Poll, dedup, authors time.Duration) (Recounted, error) {
+	r := Recounted{Poll: p.ID, Title: p.Title, Before: store.TalliesOf(p)}
+	c, mem, err := foldingCounter(p, dedup, authors)
+	if err != nil {
+		return r, err
+	}
+	err = vl.ScanVotes([]string{p.ID}, func(lv store.LoggedVote) error {
+		var msg match.Vote
+		if _, err := codec.Decode(lv.Body, &msg); err != nil {
+			log.Printf("skipping a logged vote that doesn't decode: %v", err)
+			return nil
+		}
+		metas, err := c.polls.PollsFor(msg.Option)
+		if err != nil {
+			return err
+		}
+		r.Votes++
+		c.countsLock.Lock()
+		defer c.countsLock.Unlock()
+		if r.Votes%recountPrune == 0 {
+			c.ledger.prune(lv.Received)
+			c.authors.prune(lv.Received)
+		}
+		if key := voteKey(msg); key != "" && c.ledger.Seen(key, lv.Received) {
+			return nil
+		}
+		c.fold(msg, metas, lv.Received)
+		return nil
+	})
+	if err != nil {
+		return r, fmt.Errorf("reading the vote log: %v", err)
+	}
+	c.flushFolded()
+	counted, err := mem.Poll(p.ID)
+	if err != nil {
+		return r, err
+	}
+	r.After = store.TalliesOf(counted)
+	return r, nil
+}
+
+// foldingCounter returns a counter folding votes into a copy of p in memory,
+// with no results yet, which takes every vote p accepted when it came and
+// doesn't notify anyone about it
+func foldingCounter(p store.Poll, dedup, authors time.Duration) (*Counter, *store.Memory, error) {
+	p.Status, p.DeletedAt, p.Notifications, p.Embargo = store.StatusActive, nil, nil, nil
+	p.Results, p.WeightedResults, p.GeoResults, p.Metrics = nil, nil, nil, nil
+	p.SourceResults, p.LanguageResults = nil, nil
+	mem := store.NewMemory(p)
+	series, err := timeseries.Open(timeseries.Config{Backend: "none"}, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	return &Counter{
+		db:      mem,
+		polls:   newPollCache(mem, 365*24*time.Hour, func(*store.Poll) bool { return true }),
+		metrics: newVoteMetrics(0),
+		series:  series,
+		ledger:  newLedger(dedup),
+		authors: newLedger(authors),
+		notes:   newPollNotifier(mem, notify.New()),
+	}, mem, nil
+}
+
+// flushFolded stores what a folding counter folded, the votes counted long
+// ago out of the latency
+func (c *Counter) flushFolded() {
+	c.countsLock.Lock()
+	c.created = nil
+	c.countsLock.Unlock()
+	c.doCount()
+}
@@ -0,0 +1,40 @@
+package count
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+)
+
+// markFlushed records that Run's loop just flushed
+func (c *Counter) markFlushed() {
+	atomic.StoreInt64(&c.flushed, time.Now().UnixNano())
+}
+
+// health returns the check petting systemd's watchdog: Run's loop keeps
+// flushing, so it isn't stuck, and q keeps a connection to an nsqd, each
+// allowed to stop for WatchdogStall
+func (c *Counter) health(q *nsq.Consumer) func() error {
+	stall := c.cfg.WatchdogStall
+	if stall <= 0 {
+		stall = 5 * time.Minute
+	}
+	var disconnected time.Time
+	return func() error {
+		now := time.Now()
+		if d := now.Sub(time.Unix(0, atomic.LoadInt64(&c.flushed))); d > stall {
+			return fmt.Errorf("nothing flushed for %s", d.Round(time.Second))
+		}
+		switch {
+		case q.Stats().Connections > 0:
+			disconnected = time.Time{}
+		case disconnected.IsZero():
+			disconnected = now
+		case now.Sub(disconnected) > stall:
+			return fmt.Errorf("no connection to nsqd for %s", now.Sub(disconnected).Round(time.Second))
+		}
+		return nil
+	}
+}
@@ -0,0 +1,96 @@
+// Package deadletter keeps the messages the pipeline can't process: a vote
+// the streamer fails to encode, a message the counter fails to decode or
+// keeps failing to count, or a vote for an option no poll has any more, e.g.
+// because its poll was deleted while the streamer still tracked it. Instead of being dropped they are
+// published as a Letter in JSON on the dead letter topic, with the reason, so
+// they can be looked into and replayed.
+package deadletter
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Topic is the NSQ topic dead letters are published on by default
+const Topic = "dead_letters"
+
+// Reasons a message is dead lettered for
+const (
+	// EncodeFailed is a vote the streamer couldn't encode with its codec
+	EncodeFailed = "encode_failed"
+	// DecodeFailed is a message the counter couldn't decode
+	DecodeFailed = "decode_failed"
+	// NoPoll is a vote for an option none of the polls has
+	NoPoll = "no_poll"
+	// MaxAttempts is a message the counter was given the most times allowed without counting it
+	MaxAttempts = "max_attempts"
+	// Expired is a vote whose tweet was older than the counter's vote TTL by the time it could be counted
+	Expired = "expired"
+)
+
+var (
+	letters = metrics.NewCounter("tweetreader_dead_letters_total",
+		"Messages that couldn't be processed, by stage and reason.")
+	lost = metrics.NewCounter("tweetreader_dead_letters_lost_total",
+		"Dead letters that couldn't be published and were only logged.")
+)
+
+// Publisher is the part of publish.Publisher dead letters are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Letter is a message that couldn't be processed
+type Letter struct {
+	Reason string `json:"reason"`
+	Error  string `json:"error,omitempty"`
+	// Stage is the command that gave up on the message, stream or count
+	Stage string `json:"stage"`
+	// Topic is the topic the message was, or would have been, published on
+	Topic string `json:"topic,omitempty"`
+	// Message is the message as received, Vote the vote as far as it is known
+	Message []byte      `json:"message,omitempty"`
+	Vote    *match.Vote `json:"vote,omitempty"`
+	Time    time.Time   `json:"time"`
+}
+
+// Sink sends the dead letters of a stage. A nil Sink only logs them.
+type Sink struct {
+	pub   Publisher
+	stage string
+	topic string
+}
+
+// New creates the sink of stage, which processes the messages of topic, publishing with pub
+func New(pub Publisher, stage, topic string) *Sink {
+	return &Sink{pub: pub, stage: stage, topic: topic}
+}
+
+// Send dead letters a message for reason: its body, when it got that far,
+// and the vote, when it was known. err is what went wrong, if anything did.
+func (s *Sink) Send(reason string, err error, body []byte, v *match.Vote) {
+	l := Letter{Reason: reason, Message: body, Vote: v, Time: time.Now().UTC()}
+	if err != nil {
+		l.Error = err.Error()
+	}
+	if s != nil {
+		l.Stage, l.Topic = s.stage, s.topic
+	}
+	letters.Inc("stage", l.Stage, "reason", reason)
+	if s == nil || s.pub == nil {
+		log.Println("dead letter:", reason, err)
+		return
+	}
+	b, merr := json.Marshal(l)
+	if merr == nil {
+		merr = s.pub.Publish(b)
+	}
+	if merr != nil {
+		log.Println("dead letter:", reason, err, "couldn't be published:", merr)
+		lost.Inc()
+	}
+}
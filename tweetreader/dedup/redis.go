@@ -0,0 +1,222 @@
+// Package dedup shares the votes counted between counters, in Redis. A vote
+// published twice, by streamers running side by side for availability or by
+// a spool replaying it, has the same message ID both times; the counters of a
+// topic each get some of the messages, and with a shared ledger the one
+// getting the second copy drops it like a redelivery of its own.
+//
+// Only the commands a ledger needs are spoken, over one connection: SET with
+// NX and PX records a vote unless it is there, expiring with the window.
+package dedup
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+)
+
+// ErrUnavailable is returned while Redis keeps failing and isn't asked
+var ErrUnavailable = errors.New("dedup: redis unavailable")
+
+// Config describes the Redis the ledger is kept in
+type Config struct {
+	// URL is redis://[user:password@]host[:port][/db], the port 6379 by default
+	URL string
+	// Prefix starts the keys, tweetreader:seen: by default
+	Prefix string
+	// Timeout bounds connecting and every command, 1s by default
+	Timeout time.Duration
+}
+
+// Redis is a ledger of the votes counted, kept in Redis
+type Redis struct {
+	addr, user, password string
+	db                   int
+	prefix               string
+	timeout              time.Duration
+	breaker              *breaker.Breaker
+
+	mu   sync.Mutex
+	conn net.Conn // nil until dialled, and after a failure
+	r    *bufio.Reader
+}
+
+// Open creates the ledger kept in the Redis of cfg, connecting when first used
+func Open(cfg Config) (*Redis, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("dedup: invalid redis URL %q, want redis://host:port/db", cfg.URL)
+	}
+	r := &Redis{addr: u.Host, prefix: cfg.Prefix, timeout: cfg.Timeout}
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.user = u.User.Username()
+		r.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if r.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("dedup: invalid redis database %q", db)
+		}
+	}
+	if r.prefix == "" {
+		r.prefix = "tweetreader:seen:"
+	}
+	if r.timeout <= 0 {
+		r.timeout = time.Second
+	}
+	r.breaker = breaker.New("redis", breaker.Config{Threshold: 3, Cooldown: 5 * time.Second, MaxCooldown: time.Minute})
+	return r, nil
+}
+
+// Seen records the vote with message ID id, cast by the streamer instance,
+// for window, and reports whether a counter already recorded it
+func (r *Redis) Seen(id, instance string, window time.Duration) (bool, error) {
+	if !r.breaker.Allow() {
+		return false, ErrUnavailable
+	}
+	ms := window.Milliseconds()
+	if ms < 1 {
+		ms = 1
+	}
+	if instance == "" {
+		instance = "-"
+	}
+	reply, err := r.do("SET", r.prefix+id, instance, "NX", "PX", strconv.FormatInt(ms, 10))
+	if err != nil {
+		r.breaker.Failure(err)
+		return false, err
+	}
+	r.breaker.Success()
+	// OK when it was set, nil when it was there
+	return reply == nil, nil
+}
+
+// Close closes the connection
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		return nil
+	}
+	err := r.conn.Close()
+	r.conn = nil
+	return err
+}
+
+// do sends a command and returns its reply, a string or nil; a failure
+// closes the connection, so the next command dials again
+func (r *Redis) do(args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.conn == nil {
+		if err := r.dial(); err != nil {
+			return nil, err
+		}
+	}
+	reply, err := r.command(args...)
+	if _, ok := err.(redisError); err != nil && !ok {
+		r.conn.Close()
+		r.conn = nil
+	}
+	return reply, err
+}
+
+// dial connects, authenticates and selects the database; mu must be held
+func (r *Redis) dial() error {
+	conn, err := net.DialTimeout("tcp", r.addr, r.timeout)
+	if err != nil {
+		return err
+	}
+	r.conn, r.r = conn, bufio.NewReader(conn)
+	err = func() error {
+		if r.password != "" {
+			args := []string{"AUTH", r.password}
+			if r.user != "" {
+				args = []string{"AUTH", r.user, r.password}
+			}
+			if _, err := r.command(args...); err != nil {
+				return err
+			}
+		}
+		if r.db != 0 {
+			if _, err := r.command("SELECT", strconv.Itoa(r.db)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}()
+	if err != nil {
+		conn.Close()
+		r.conn = nil
+	}
+	return err
+}
+
+// redisError is an error reply, the connection is still fine after one
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// command writes a command as an array of bulk strings and reads its reply
+func (r *Redis) command(args ...string) (interface{}, error) {
+	if err := r.conn.SetDeadline(time.Now().Add(r.timeout)); err != nil {
+		return nil, err
+	}
+	b := make([]byte, 0, 64)
+	b = append(b, '*')
+	b = strconv.AppendInt(b, int64(len(args)), 10)
+	b = append(b, "\r\n"...)
+	for _, a := range args {
+		b = append(b, '$')
+		b = strconv.AppendInt(b, int64(len(a)), 10)
+		b = append(b, "\r\n"...)
+		b = append(b, a...)
+		b = append(b, "\r\n"...)
+	}
+	if _, err := r.conn.Write(b); err != nil {
+		return nil, err
+	}
+	line, err := r.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+', ':':
+		return rest, nil
+	case '-':
+		return nil, redisError(rest)
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(r.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
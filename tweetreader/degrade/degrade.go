@@ -0,0 +1,349 @@
+// Package degrade sheds the streamer's optional work, a step at a time, while
+// it runs short of memory or the broker can't keep up, and takes it back up
+// once the pressure is off.
+//
+// The ladder is the steps in the order they are taken: looking up no more
+// authors for the enriched polls, sampling 1 in SampleEvery of every vote, and
+// dropping the votes for low priority polls. Every Interval the pressure of
+// each Source is read, 1 at its threshold: while any is at 1 or over, the next
+// step is taken; once all of them stayed under 0.8 for Hold, the last step
+// taken is undone, and so on down the ladder. A step at a time, so what a step
+// relieves shows before the next one is taken.
+package degrade
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Steps a ladder can take
+const (
+	Enrichment  = "enrichment"   // look up no more authors, see the enrich package
+	Sampling    = "sampling"     // keep 1 in SampleEvery of every vote, scaled like a sampled poll's
+	LowPriority = "low-priority" // drop the votes for low priority polls, of the options no other poll has
+)
+
+// Steps is the ladder taken unless configured otherwise, the cheapest loss first
+var Steps = []string{Enrichment, Sampling, LowPriority}
+
+// recoverBelow is the pressure every source has to stay under for a step to be undone
+const recoverBelow = 0.8
+
+var (
+	levelGauge = metrics.NewGauge("tweetreader_degradation_level",
+		"Steps of the degradation ladder taken, 0 when the streamer does all its work.")
+	pressures = metrics.NewGauge("tweetreader_degradation_pressure",
+		"Pressure of each source the degradation ladder watches, by source: 1 at its threshold.")
+	changes = metrics.NewCounter("tweetreader_degradation_changes_total",
+		"Steps of the degradation ladder taken or undone, by step and direction: up or down.")
+	dropped = metrics.NewCounter("tweetreader_degradation_dropped_votes_total",
+		"Votes not published while degraded, by step: sampling or low-priority.")
+)
+
+// Source is something the ladder watches, Pressure returning how close it is
+// to its threshold: 1 at it, over 1 beyond
+type Source struct {
+	Name     string
+	Pressure func() float64
+}
+
+// Memory is the source of the heap in use, at its threshold at limit bytes
+func Memory(limit uint64) Source {
+	return Source{Name: "memory", Pressure: func() float64 {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return float64(m.HeapAlloc) / float64(limit)
+	}}
+}
+
+// Config is the ladder and when its steps are taken
+type Config struct {
+	// Ladder is the steps in the order they are taken, see ParseLadder
+	Ladder  []string
+	Sources []Source
+	// SampleEvery is 1 in how many votes Sampling keeps, 10 by default
+	SampleEvery int
+	// Interval is how often the sources are read, 10s by default
+	Interval time.Duration
+	// Hold is how long the pressure stays off before a step is undone, a minute by default
+	Hold time.Duration
+	// Events is told of every step taken or undone, may be nil
+	Events *events.Bus
+}
+
+// Status is how degraded the streamer is
+type Status struct {
+	Level int `json:"level"`
+	// Steps are the steps taken, in order, and Ladder all of them
+	Steps  []string `json:"steps"`
+	Ladder []string `json:"ladder"`
+	// Since is when the level last changed
+	Since time.Time `json:"since,omitempty"`
+	// Pressure is what each source read last
+	Pressure map[string]float64 `json:"pressure"`
+}
+
+// Ladder takes and undoes the steps, and applies the sampling and low-priority ones to the votes
+type Ladder struct {
+	cfg Config
+
+	mu       sync.RWMutex
+	level    int
+	since    time.Time
+	calm     time.Time // since when every source is under recoverBelow, zero while one isn't
+	pressure map[string]float64
+	lowOnly  map[string]bool // the options only low priority polls have
+	now      func() time.Time
+}
+
+// ParseLadder reads a comma separated ladder, as enrichment,sampling, the
+// default one when s is empty
+func ParseLadder(s string) ([]string, error) {
+	if strings.TrimSpace(s) == "" {
+		return Steps, nil
+	}
+	var ladder []string
+	seen := make(map[string]bool)
+	for _, step := range strings.Split(s, ",") {
+		step = strings.TrimSpace(step)
+		switch step {
+		case Enrichment, Sampling, LowPriority:
+		default:
+			return nil, fmt.Errorf("degrade: unknown step %q, want %s", step, strings.Join(Steps, ", "))
+		}
+		if seen[step] {
+			return nil, fmt.Errorf("degrade: %s is in the ladder twice", step)
+		}
+		seen[step] = true
+		ladder = append(ladder, step)
+	}
+	return ladder, nil
+}
+
+// New creates a Ladder with none of its steps taken
+func New(cfg Config) *Ladder {
+	if cfg.SampleEvery <= 0 {
+		cfg.SampleEvery = 10
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 10 * time.Second
+	}
+	if cfg.Hold <= 0 {
+		cfg.Hold = time.Minute
+	}
+	levelGauge.Set(0)
+	return &Ladder{cfg: cfg, pressure: make(map[string]float64), now: time.Now}
+}
+
+// Watch adds s to the sources, before Start
+func (l *Ladder) Watch(s Source) {
+	l.cfg.Sources = append(l.cfg.Sources, s)
+}
+
+// Start reads the sources every Interval until stop is closed, it doesn't
+// when there are none
+func (l *Ladder) Start(stop <-chan struct{}) {
+	if len(l.cfg.Sources) == 0 || len(l.cfg.Ladder) == 0 {
+		return
+	}
+	log.Printf("degrade: watching %d sources, the ladder is %s", len(l.cfg.Sources), strings.Join(l.cfg.Ladder, ", "))
+	go func() {
+		ticker := time.NewTicker(l.cfg.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				l.check()
+			}
+		}
+	}()
+}
+
+// check reads the sources and takes or undoes a step
+func (l *Ladder) check() {
+	read := make(map[string]float64, len(l.cfg.Sources))
+	over, calm := "", true
+	for _, s := range l.cfg.Sources {
+		p := s.Pressure()
+		read[s.Name] = p
+		pressures.Set(p, "source", s.Name)
+		if p >= 1 && over == "" {
+			over = fmt.Sprintf("%s at %.2f of its threshold", s.Name, p)
+		}
+		if p >= recoverBelow {
+			calm = false
+		}
+	}
+	l.mu.Lock()
+	now := l.now()
+	l.pressure = read
+	var step, direction string
+	switch {
+	case over != "":
+		l.calm = time.Time{}
+		if l.level < len(l.cfg.Ladder) {
+			step, direction = l.cfg.Ladder[l.level], "up"
+			l.level++
+		}
+	case !calm:
+		l.calm = time.Time{}
+	case l.level == 0:
+	case l.calm.IsZero():
+		l.calm = now
+	case now.Sub(l.calm) >= l.cfg.Hold:
+		// the next step down waits for another Hold
+		l.level--
+		step, direction, l.calm = l.cfg.Ladder[l.level], "down", now
+	}
+	if step != "" {
+		l.since = now
+	}
+	level := l.level
+	l.mu.Unlock()
+	if step == "" {
+		return
+	}
+	levelGauge.Set(float64(level))
+	changes.Inc("step", step, "direction", direction)
+	var detail string
+	if direction == "up" {
+		detail = fmt.Sprintf("level %d of %d, %s: %s", level, len(l.cfg.Ladder), step, over)
+	} else {
+		detail = fmt.Sprintf("level %d of %d, %s undone: the pressure is off", level, len(l.cfg.Ladder), step)
+	}
+	log.Println("degrade:", detail)
+	l.cfg.Events.Emit(events.Degradation, detail)
+}
+
+// active reports whether step is taken, mu held
+func (l *Ladder) active(step string) bool {
+	for i := 0; i < l.level; i++ {
+		if l.cfg.Ladder[i] == step {
+			return true
+		}
+	}
+	return false
+}
+
+// Active reports whether step is taken
+func (l *Ladder) Active(step string) bool {
+	if l == nil {
+		return false
+	}
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	return l.active(step)
+}
+
+// Status returns the steps taken and the pressure of the sources
+func (l *Ladder) Status() Status {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	st := Status{Level: l.level, Steps: []string{}, Ladder: l.cfg.Ladder, Since: l.since, Pressure: make(map[string]float64, len(l.pressure))}
+	st.Steps = append(st.Steps, l.cfg.Ladder[:l.level]...)
+	for name, p := range l.pressure {
+		st.Pressure[name] = p
+	}
+	return st
+}
+
+// Update takes the options only low priority polls have from polls
+func (l *Ladder) Update(polls []store.Poll) {
+	low := make(map[string]bool)
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		for _, o := range p.Options {
+			if p.Priority == store.PriorityLow {
+				if _, ok := low[o]; !ok {
+					low[o] = true
+				}
+			} else {
+				low[o] = false
+			}
+		}
+	}
+	lowOnly := make(map[string]bool)
+	for o, only := range low {
+		if only {
+			lowOnly[o] = true
+		}
+	}
+	l.mu.Lock()
+	l.lowOnly = lowOnly
+	l.mu.Unlock()
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the low priority polls. When the polls can't be loaded the last ones are kept.
+func (l *Ladder) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("degrade: failed to load the polls, keeping the last low priority ones:", err)
+			return options, nil
+		}
+		l.Update(all)
+		return options, nil
+	}
+}
+
+// Keep reports whether v is published with the steps taken, scaling it up
+// when it is sampled. Which votes are kept depends on the tweet ID alone,
+// hashed apart from the polls' own sampling so both don't keep the same votes.
+func (l *Ladder) Keep(v *match.Vote) bool {
+	l.mu.RLock()
+	low := l.active(LowPriority) && l.lowOnly[v.Option]
+	sampling := l.active(Sampling)
+	l.mu.RUnlock()
+	if low {
+		dropped.Inc("step", LowPriority)
+		return false
+	}
+	n := l.cfg.SampleEvery
+	if !sampling || n <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte("degrade:" + v.ID))
+	if h.Sum64()%uint64(n) != 0 {
+		dropped.Inc("step", Sampling)
+		return false
+	}
+	if v.Scale < 1 {
+		v.Scale = 1
+	}
+	v.Scale *= n
+	return true
+}
+
+// Run passes on the votes from in Keep keeps, the returned channel is closed once in is
+func (l *Ladder) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if l.Keep(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,10 @@
+//go:build postgres
+// +build postgres
+
+package main
+
+// The PostgreSQL driver backs the postgres store and the timescale results backend.
+// It isn't linked by default so the common MongoDB-only build stays dependency free:
+//
+//	go build -tags postgres
+import _ "github.com/lib/pq"
@@ -0,0 +1,10 @@
+//go:build sqlite
+// +build sqlite
+
+package main
+
+// The SQLite driver backs the sqlite store used for local runs.
+// It needs cgo, so it is only linked when asked for:
+//
+//	go build -tags sqlite
+import _ "github.com/mattn/go-sqlite3"
@@ -0,0 +1,307 @@
+// Package enrich fills in the author profile of the votes for the polls asking
+// for it, when their tweets came without one.
+//
+// The v2 stream carries the authors in its includes, but leaves them out when
+// the expansion fails, and then a vote has an author ID but no handle, no
+// followers and no verified badge: the weighting counts it as 1 and a filter
+// on followers or verified fails it. The profiles are looked up with Twitter's
+// users lookup, whose rate limit is tight, so they are cached for TTL, the IDs
+// missing are looked up in batches of up to 100 and the lookups are spaced by
+// MinInterval. A vote waiting for a lookup holds back the votes after it, so
+// they are passed on in the order they came, at most Wait late.
+package enrich
+
+import (
+	"container/list"
+	"context"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// maxBatch is the most accounts users/lookup takes at once
+const maxBatch = 100
+
+var (
+	cacheLookups = metrics.NewCounter("tweetreader_enrich_cache_total",
+		"Author profiles the votes of enriched polls needed, by result: hit when cached, miss when looked up.")
+	cacheEntries = metrics.NewGauge("tweetreader_enrich_cache_entries",
+		"Author profiles cached, the unknown accounts too.")
+	lookups = metrics.NewCounter("tweetreader_enrich_lookups_total",
+		"Batched users lookups, by result: ok or failed.")
+	enriched = metrics.NewCounter("tweetreader_enrich_votes_total",
+		"Votes whose author profile was filled in.")
+)
+
+// Lookup returns the profiles of the accounts ids, by ID, the unknown ones left out
+type Lookup func(ctx context.Context, ids []string) (map[string]stream.Profile, error)
+
+// Config is how the profiles are looked up and cached
+type Config struct {
+	// Weigh weighs the enriched votes again, as the matcher does; nil keeps their weight
+	Weigh match.WeightFunc
+	// TTL is how long a profile is cached, an hour by default
+	TTL time.Duration
+	// Size is how many profiles are cached at most, 100000 by default
+	Size int
+	// Batch is how many accounts are looked up at once, at most and by default 100
+	Batch int
+	// Wait is how long a vote waits for others to fill its batch, a second by default
+	Wait time.Duration
+	// MinInterval is the least time between two lookups, 1s by default: the
+	// users lookup takes 900 requests every 15 minutes
+	MinInterval time.Duration
+	// Timeout bounds a lookup, 10s by default
+	Timeout time.Duration
+	// Off, when set, returns true while no authors are to be looked up, the
+	// votes passed on as they are, e.g. while the streamer is degraded
+	Off func() bool
+}
+
+// Enricher fills in the authors of the votes for the options of the polls with EnrichAuthors
+type Enricher struct {
+	cfg Config
+
+	mu      sync.RWMutex
+	options map[string]bool
+
+	// the cache, only used by Run's goroutine
+	cached    map[string]*list.Element
+	order     *list.List // of *profile, the least recently used first
+	lastQuery time.Time
+}
+
+// profile is a cached lookup, found false for an unknown or suspended account
+type profile struct {
+	stream.Profile
+	id    string
+	found bool
+	at    time.Time
+}
+
+// New creates an Enricher enriching no poll's votes until Update is called
+func New(cfg Config) *Enricher {
+	if cfg.TTL <= 0 {
+		cfg.TTL = time.Hour
+	}
+	if cfg.Size <= 0 {
+		cfg.Size = 100000
+	}
+	if cfg.Batch <= 0 || cfg.Batch > maxBatch {
+		cfg.Batch = maxBatch
+	}
+	if cfg.Wait <= 0 {
+		cfg.Wait = time.Second
+	}
+	if cfg.MinInterval <= 0 {
+		cfg.MinInterval = time.Second
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Enricher{cfg: cfg, cached: make(map[string]*list.Element), order: list.New()}
+}
+
+// Update takes the options of the tracked polls with EnrichAuthors from polls
+func (e *Enricher) Update(polls []store.Poll) {
+	options := make(map[string]bool)
+	for _, p := range polls {
+		if !p.EnrichAuthors || !p.Tracked() {
+			continue
+		}
+		for _, o := range p.Options {
+			options[o] = true
+		}
+	}
+	e.mu.Lock()
+	e.options = options
+	e.mu.Unlock()
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the polls enriching their votes. When the polls can't be loaded the last
+// ones are kept.
+func (e *Enricher) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("enrich: failed to load the polls, keeping the last ones enriched:", err)
+			return options, nil
+		}
+		e.Update(all)
+		return options, nil
+	}
+}
+
+// needs reports whether v is for an enriched poll and its author wasn't
+// delivered with it. Only Twitter's authors can be looked up.
+func (e *Enricher) needs(v *match.Vote) bool {
+	if v.User.ID == "" || v.User.ScreenName != "" || v.Count > 0 {
+		return false
+	}
+	if v.Source != "" && v.Source != stream.SourceTwitter {
+		return false
+	}
+	if e.cfg.Off != nil && e.cfg.Off() {
+		return false
+	}
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.options[v.Option]
+}
+
+// Run fills in the authors of the votes from in, looking them up with lookup,
+// and passes them on in order; the returned channel is closed once in is. A
+// vote whose author can't be looked up is passed on as it is.
+func (e *Enricher) Run(in <-chan match.Vote, lookup Lookup) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		var (
+			pending []match.Vote    // held back, in order
+			missing []string        // the authors of the pending votes to look up
+			wanted  map[string]bool // missing, as a set
+			timer   *time.Timer
+			due     <-chan time.Time
+		)
+		flush := func() {
+			if timer != nil {
+				timer.Stop()
+				timer, due = nil, nil
+			}
+			if len(missing) > 0 {
+				e.query(lookup, missing)
+			}
+			now := time.Now()
+			for _, v := range pending {
+				if e.needs(&v) {
+					if p := e.get(v.User.ID, now); p != nil {
+						e.apply(&v, p)
+					}
+				}
+				out <- v
+			}
+			pending, missing, wanted = nil, nil, nil
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				var p *profile
+				needed := e.needs(&v)
+				if needed {
+					if p = e.get(v.User.ID, time.Now()); p != nil {
+						cacheLookups.Inc("result", "hit")
+						e.apply(&v, p)
+					} else {
+						cacheLookups.Inc("result", "miss")
+					}
+				}
+				if !needed || p != nil {
+					if len(pending) == 0 {
+						out <- v
+					} else {
+						pending = append(pending, v)
+					}
+					continue
+				}
+				pending = append(pending, v)
+				if !wanted[v.User.ID] {
+					if wanted == nil {
+						wanted = make(map[string]bool)
+					}
+					wanted[v.User.ID] = true
+					missing = append(missing, v.User.ID)
+				}
+				if timer == nil {
+					timer = time.NewTimer(e.cfg.Wait)
+					due = timer.C
+				}
+				if len(missing) >= e.cfg.Batch {
+					flush()
+				}
+			case <-due:
+				timer, due = nil, nil
+				flush()
+			}
+		}
+	}()
+	return out
+}
+
+// get returns the cached profile of the account id at now, nil when it isn't cached
+func (e *Enricher) get(id string, now time.Time) *profile {
+	el, ok := e.cached[id]
+	if !ok {
+		return nil
+	}
+	p := el.Value.(*profile)
+	if now.Sub(p.at) >= e.cfg.TTL {
+		e.order.Remove(el)
+		delete(e.cached, id)
+		return nil
+	}
+	e.order.MoveToBack(el)
+	return p
+}
+
+// apply sets v's author to p, and weighs it again
+func (e *Enricher) apply(v *match.Vote, p *profile) {
+	if !p.found {
+		return
+	}
+	v.User.Name, v.User.ScreenName = p.Name, p.ScreenName
+	v.User.Verified, v.User.FollowersCount = p.Verified, p.FollowersCount
+	if e.cfg.Weigh != nil {
+		v.Weight = e.cfg.Weigh(v.Tweet)
+	}
+	enriched.Inc()
+}
+
+// query looks up ids, at most Batch, and caches what it found, waiting for
+// MinInterval since the last lookup first. When it fails nothing is cached,
+// the accounts are looked up again for their next votes.
+func (e *Enricher) query(lookup Lookup, ids []string) {
+	if wait := e.cfg.MinInterval - time.Since(e.lastQuery); wait > 0 {
+		time.Sleep(wait)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), e.cfg.Timeout)
+	defer cancel()
+	found, err := lookup(ctx, ids)
+	e.lastQuery = time.Now()
+	if err != nil {
+		lookups.Inc("result", "failed")
+		log.Printf("enrich: failed to look up %d authors: %v", len(ids), err)
+		return
+	}
+	lookups.Inc("result", "ok")
+	for _, id := range ids {
+		p, ok := found[id]
+		e.add(&profile{Profile: p, id: id, found: ok, at: e.lastQuery})
+	}
+	cacheEntries.Set(float64(e.order.Len()))
+}
+
+// add caches p, evicting the least recently used over Size
+func (e *Enricher) add(p *profile) {
+	if el, ok := e.cached[p.id]; ok {
+		e.order.Remove(el)
+	}
+	e.cached[p.id] = e.order.PushBack(p)
+	for e.order.Len() > e.cfg.Size {
+		old := e.order.Remove(e.order.Front()).(*profile)
+		delete(e.cached, old.id)
+	}
+}
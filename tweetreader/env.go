@@ -0,0 +1,124 @@
+package main
+
+import (
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// invalidEnv holds the variables read so far whose values didn't parse, and
+// were replaced by their defaults, for check to report
+var invalidEnv struct {
+	sync.Mutex
+	values map[string]string
+}
+
+// invalid records that the value v of key didn't parse
+func invalid(key, v string) {
+	invalidEnv.Lock()
+	defer invalidEnv.Unlock()
+	if invalidEnv.values == nil {
+		invalidEnv.values = make(map[string]string)
+	}
+	invalidEnv.values[key] = v
+}
+
+// invalidVariables returns the variables read so far that didn't parse, as
+// KEY="value", sorted
+func invalidVariables() []string {
+	invalidEnv.Lock()
+	defer invalidEnv.Unlock()
+	var vars []string
+	for key, v := range invalidEnv.values {
+		vars = append(vars, key+"="+strconv.Quote(v))
+	}
+	sort.Strings(vars)
+	return vars
+}
+
+// envString returns the value of the environment variable key or def when it is unset
+func envString(key, def string) string {
+	if v := getenv(key); v != "" {
+		return v
+	}
+	return def
+}
+
+// envDuration parses the environment variable key as a time.Duration
+func envDuration(key string, def time.Duration) time.Duration {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+	d, err := time.ParseDuration(v)
+	if err != nil {
+		invalid(key, v)
+		log.Printf("invalid %s %q, using %s", key, v, def)
+		return def
+	}
+	return d
+}
+
+// envInt64 parses the environment variable key as an int64
+func envInt64(key string, def int64) int64 {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+	n, err := strconv.ParseInt(v, 10, 64)
+	if err != nil {
+		invalid(key, v)
+		log.Printf("invalid %s %q, using %d", key, v, def)
+		return def
+	}
+	return n
+}
+
+// envFloat parses the environment variable key as a float64
+func envFloat(key string, def float64) float64 {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		invalid(key, v)
+		log.Printf("invalid %s %q, using %v", key, v, def)
+		return def
+	}
+	return f
+}
+
+// envBool parses the environment variable key as a bool
+func envBool(key string, def bool) bool {
+	v := getenv(key)
+	if v == "" {
+		return def
+	}
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		invalid(key, v)
+		log.Printf("invalid %s %q, using %t", key, v, def)
+		return def
+	}
+	return b
+}
+
+// envList splits the environment variable key as a comma separated list, dropping empty entries
+func envList(key string) []string {
+	return splitList(getenv(key))
+}
+
+// splitList splits s as a comma separated list, dropping empty entries
+func splitList(s string) []string {
+	var list []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			list = append(list, item)
+		}
+	}
+	return list
+}
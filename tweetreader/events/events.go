@@ -0,0 +1,161 @@
+// Package events is the in-process bus components report notable conditions on.
+// A runbook of rules, loaded from a file, turns those events into automated responses:
+// pausing the stream and paging someone when the Twitter credentials keep being rejected,
+// or snapshotting and pausing the counter when it can't write to the store.
+//
+// Components only emit events; the actions are registered by whoever owns what they act on,
+// so a rule naming an action that isn't available in a process is logged and skipped.
+package events
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Event kinds
+const (
+	StreamAuthFailure         = "stream.auth_failure"         // Twitter rejected the stream credentials
+	StreamDuplicateConnection = "stream.duplicate_connection" // another connection uses the credentials
+	StreamSLOSummary          = "stream.slo_summary"          // the streams' uptime, reconnects and gaps over the last report period, as JSON
+	CountStoreError           = "count.store_error"           // flushing tallies to the store failed, they are kept in memory
+	CountOverload             = "count.overload"              // the counter asked the streamers to ease off a poll
+	VoteSpike                 = "vote.spike"                  // an option is getting far more votes than usual
+	OptionSilent              = "option.silent"               // an option matches no vote while its siblings get them
+	BudgetExhausted           = "budget.exhausted"            // most of a component's attempts failed, ingestion is paused for a while
+	VoteLatency               = "vote.latency"                // votes are published later after their tweets than the latency budget
+	StreamDisconnected        = "stream.disconnected"         // the connection to Twitter ended, with the cause, and is made again
+	BackfillStarted           = "backfill.started"            // a backfill is searching for the votes missed
+	SpoolDraining             = "spool.draining"              // the votes spooled are published again
+	PollQuarantined           = "poll.quarantined"            // a poll's votes are dropped until it is released
+	Degradation               = "degradation.level"           // the streamer took or undid a step of its degradation ladder
+)
+
+// Actions a runbook can take
+const (
+	ActionLog            = "log"             // log the event, always available
+	ActionWebhook        = "webhook"         // POST the event to url, always available
+	ActionPauseStream    = "pause_stream"    // disconnect from Twitter for a while, in stream
+	ActionPausePublisher = "pause_publisher" // spool votes instead of publishing them for a while, in stream
+	ActionSnapshot       = "snapshot"        // save the counter state, in count
+	ActionPauseCounter   = "pause_counter"   // stop consuming votes for a while, in count
+)
+
+var actionsRun = metrics.NewCounter("tweetreader_runbook_actions_total",
+	"Runbook actions taken, by rule, action and result.")
+
+// Event is something a component reported
+type Event struct {
+	Kind   string    `json:"kind"`
+	Detail string    `json:"detail,omitempty"`
+	Time   time.Time `json:"time"`
+	// Rule is the rule that fired, set for the actions
+	Rule string `json:"rule,omitempty"`
+	// Instance is the process the event is from, set on the ops topic
+	Instance string `json:"instance,omitempty"`
+}
+
+// Action carries out one step of a rule for the event that fired it
+type Action func(step Step, e Event) error
+
+// queueSize is how many events can wait for the runbook before new ones are dropped
+const queueSize = 64
+
+// Bus runs the runbook's rules against the events emitted on it.
+// A nil *Bus is valid and ignores everything, for processes without a runbook.
+type Bus struct {
+	queue chan Event
+	done  chan struct{}
+
+	mu      sync.Mutex
+	rules   []*rule
+	actions map[string]Action
+}
+
+// NewBus starts a bus applying rules
+func NewBus(rules []Rule) *Bus {
+	b := &Bus{
+		queue:   make(chan Event, queueSize),
+		done:    make(chan struct{}),
+		actions: make(map[string]Action),
+	}
+	for _, r := range rules {
+		b.rules = append(b.rules, &rule{Rule: r})
+	}
+	b.Handle(ActionLog, func(step Step, e Event) error {
+		log.Printf("runbook: %s %s", e.Kind, e.Detail)
+		return nil
+	})
+	b.Handle(ActionWebhook, webhook)
+	go b.run()
+	return b
+}
+
+// Emit reports an event without blocking, it is dropped when the runbook is
+// too far behind. It is recorded as an operational event either way, see Record.
+func (b *Bus) Emit(kind, detail string) {
+	e := Event{Kind: kind, Detail: detail, Time: time.Now()}
+	record(e)
+	if b == nil {
+		return
+	}
+	select {
+	case b.queue <- e:
+	default:
+		log.Println("runbook: queue full, dropping", kind)
+	}
+}
+
+// Handle makes an action available to the rules, replacing any action with the same name
+func (b *Bus) Handle(name string, a Action) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.actions[name] = a
+}
+
+// Close stops the bus once the events already emitted have been handled
+func (b *Bus) Close() {
+	if b == nil {
+		return
+	}
+	close(b.queue)
+	<-b.done
+}
+
+func (b *Bus) run() {
+	defer close(b.done)
+	for e := range b.queue {
+		for _, r := range b.rules {
+			if r.observe(e) {
+				b.fire(r, e)
+			}
+		}
+	}
+}
+
+// fire runs the steps of r in order, a failed step doesn't stop the ones after it
+func (b *Bus) fire(r *rule, e Event) {
+	log.Printf("runbook: rule %q fired on %s", r.Name, e.Kind)
+	e.Rule = r.Name
+	for _, step := range r.Actions {
+		b.mu.Lock()
+		a, ok := b.actions[step.Do]
+		b.mu.Unlock()
+		if !ok {
+			log.Printf("runbook: rule %q: %s isn't available in this process", r.Name, step.Do)
+			actionsRun.Inc("rule", r.Name, "action", step.Do, "result", "unavailable")
+			continue
+		}
+		if err := a(step, e); err != nil {
+			log.Printf("runbook: rule %q: %s failed: %v", r.Name, step.Do, err)
+			actionsRun.Inc("rule", r.Name, "action", step.Do, "result", "error")
+			continue
+		}
+		actionsRun.Inc("rule", r.Name, "action", step.Do, "result", "ok")
+	}
+}
@@ -0,0 +1,113 @@
+package events
+
+import (
+	"encoding/json"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Every event is also an operational one, what the pipeline did: the last
+// opsKept are kept in memory for /admin/events, with or without a runbook,
+// and once PublishOps is called they are published on OpsTopic too, so the
+// on-call can put together what every process did during an incident.
+
+// OpsTopic is the NSQ topic the operational events are published on
+const OpsTopic = "ops"
+
+const (
+	opsKept      = 500 // events kept in memory, the oldest overwritten first
+	opsQueueSize = 256 // events waiting for the ops topic before new ones are dropped
+)
+
+var opsPublished = metrics.NewCounter("tweetreader_ops_events_total",
+	"Operational events for the ops topic, by result: published, dropped or failed.")
+
+var (
+	opsMu    sync.Mutex
+	opsLog   = make([]Event, opsKept)
+	opsNext  int // where the next event goes
+	opsFull  bool
+	opsQueue chan Event // to the ops topic, nil until PublishOps
+)
+
+// Record reports an operational event from a component without a bus, it is
+// kept and published like the ones emitted but no runbook rule sees it
+func Record(kind, detail string) {
+	record(Event{Kind: kind, Detail: detail, Time: time.Now()})
+}
+
+func record(e Event) {
+	opsMu.Lock()
+	defer opsMu.Unlock()
+	opsLog[opsNext] = e
+	opsNext++
+	if opsNext == len(opsLog) {
+		opsNext, opsFull = 0, true
+	}
+	if opsQueue == nil {
+		return
+	}
+	select {
+	case opsQueue <- e:
+	default:
+		opsPublished.Inc("result", "dropped")
+	}
+}
+
+// Recent returns the n newest operational events, the newest first, every one
+// kept when n is 0; with kind only the ones of that kind, or of its family
+// for a kind without a dot: stream is stream.auth_failure, stream.disconnected...
+func Recent(n int, kind string) []Event {
+	opsMu.Lock()
+	defer opsMu.Unlock()
+	kept := opsNext
+	if opsFull {
+		kept = len(opsLog)
+	}
+	out := []Event{}
+	for i := 1; i <= kept && (n <= 0 || len(out) < n); i++ {
+		e := opsLog[(opsNext-i+len(opsLog))%len(opsLog)]
+		if kind == "" || e.Kind == kind || strings.HasPrefix(e.Kind, kind+".") {
+			out = append(out, e)
+		}
+	}
+	return out
+}
+
+// PublishOps publishes the operational events recorded from now on with
+// publish, as JSON naming the instance they are from. It returns the func
+// stopping it once the events waiting were published.
+func PublishOps(instance string, publish func([]byte) error) (stop func()) {
+	queue := make(chan Event, opsQueueSize)
+	done := make(chan struct{})
+	opsMu.Lock()
+	opsQueue = queue
+	opsMu.Unlock()
+	go func() {
+		defer close(done)
+		for e := range queue {
+			e.Instance = instance
+			b, err := json.Marshal(e)
+			if err == nil {
+				err = publish(b)
+			}
+			if err != nil {
+				log.Printf("events: failed to publish %s on the %s topic: %v", e.Kind, OpsTopic, err)
+				opsPublished.Inc("result", "failed")
+				continue
+			}
+			opsPublished.Inc("result", "published")
+		}
+	}()
+	return func() {
+		opsMu.Lock()
+		opsQueue = nil
+		opsMu.Unlock()
+		close(queue)
+		<-done
+	}
+}
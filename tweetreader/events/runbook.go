@@ -0,0 +1,129 @@
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"time"
+)
+
+// Rule fires its actions when an event of kind On is seen Count times within Within.
+// After firing it stays quiet for Cooldown, so a condition that persists doesn't page every minute.
+type Rule struct {
+	Name     string   `json:"name"`
+	On       string   `json:"on"`
+	Count    int      `json:"count,omitempty"`  // 1 when unset
+	Within   Duration `json:"within,omitempty"` // any time when unset
+	Cooldown Duration `json:"cooldown,omitempty"`
+	Actions  []Step   `json:"actions"`
+}
+
+// Step is one action of a rule
+type Step struct {
+	Do  string   `json:"do"`
+	For Duration `json:"for,omitempty"` // how long pauses last
+	URL string   `json:"url,omitempty"` // where webhooks are sent
+}
+
+// Duration is a time.Duration written as a string such as "5m" in the runbook
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string
+func (d *Duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("durations are strings such as \"5m\": %v", err)
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+	*d = Duration(v)
+	return nil
+}
+
+// MarshalJSON writes the duration as a string
+func (d Duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(d).String())
+}
+
+// LoadRunbook reads the rules from the JSON file at path, a list of Rule
+func LoadRunbook(path string) ([]Rule, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var rules []Rule
+	if err := json.Unmarshal(b, &rules); err != nil {
+		return nil, fmt.Errorf("runbook %s: %v", path, err)
+	}
+	for i, r := range rules {
+		if err := r.validate(); err != nil {
+			return nil, fmt.Errorf("runbook %s: rule %d (%s): %v", path, i+1, r.Name, err)
+		}
+	}
+	return rules, nil
+}
+
+func (r *Rule) validate() error {
+	switch {
+	case r.Name == "":
+		return fmt.Errorf("name is required")
+	case r.On == "":
+		return fmt.Errorf("on is required")
+	case r.Count < 0:
+		return fmt.Errorf("count can't be negative")
+	case len(r.Actions) == 0:
+		return fmt.Errorf("no actions")
+	}
+	for _, s := range r.Actions {
+		switch s.Do {
+		case ActionLog, ActionSnapshot:
+		case ActionWebhook:
+			if s.URL == "" {
+				return fmt.Errorf("webhook needs a url")
+			}
+		case ActionPauseStream, ActionPausePublisher, ActionPauseCounter:
+			if s.For <= 0 {
+				return fmt.Errorf("%s needs for", s.Do)
+			}
+		default:
+			return fmt.Errorf("unknown action %q", s.Do)
+		}
+	}
+	return nil
+}
+
+// rule is a Rule with the events it has seen
+type rule struct {
+	Rule
+	seen  []time.Time
+	fired time.Time
+}
+
+// observe records e and reports whether the rule should fire
+func (r *rule) observe(e Event) bool {
+	if e.Kind != r.On {
+		return false
+	}
+	if !r.fired.IsZero() && e.Time.Sub(r.fired) < time.Duration(r.Cooldown) {
+		return false
+	}
+	r.seen = append(r.seen, e.Time)
+	if r.Within > 0 {
+		cutoff := e.Time.Add(-time.Duration(r.Within))
+		for len(r.seen) > 0 && r.seen[0].Before(cutoff) {
+			r.seen = r.seen[1:]
+		}
+	}
+	count := r.Count
+	if count == 0 {
+		count = 1
+	}
+	if len(r.seen) < count {
+		return false
+	}
+	r.seen = nil
+	r.fired = e.Time
+	return true
+}
@@ -0,0 +1,36 @@
+package events
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+)
+
+var webhookClient = &http.Client{Timeout: 10 * time.Second}
+
+// webhookBody is what a webhook step posts
+type webhookBody struct {
+	Event
+	Host string `json:"host"`
+}
+
+// webhook POSTs the event as JSON to the step's url
+func webhook(step Step, e Event) error {
+	host, _ := os.Hostname()
+	b, err := json.Marshal(webhookBody{Event: e, Host: host})
+	if err != nil {
+		return err
+	}
+	resp, err := webhookClient.Post(step.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", resp.Status)
+	}
+	return nil
+}
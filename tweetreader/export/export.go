@@ -0,0 +1,175 @@
+// Package export writes tables of results or votes for analysts, as CSV, as
+// JSON (one object per line) or as Parquet.
+package export
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Formats a table can be written in
+const (
+	CSV     = "csv"
+	JSON    = "json"
+	Parquet = "parquet"
+)
+
+// Kind is the type of a column's values
+type Kind int
+
+// Column kinds, each row holds a value of the Go type named
+const (
+	String Kind = iota // string
+	Int                // int64
+	Float              // float64
+	Bool               // bool
+	Time               // time.Time, written in UTC to the millisecond
+)
+
+// Column is a named column of a table
+type Column struct {
+	Name string
+	Kind Kind
+}
+
+// Table is what gets exported: rows of values in the order and of the kinds of the columns
+type Table struct {
+	Columns []Column
+	Rows    [][]interface{}
+}
+
+// Add appends a row, values must match the columns
+func (t *Table) Add(values ...interface{}) {
+	t.Rows = append(t.Rows, values)
+}
+
+// Write writes t to w in format
+func Write(w io.Writer, format string, t *Table) error {
+	if err := t.check(); err != nil {
+		return err
+	}
+	switch format {
+	case CSV:
+		return writeCSV(w, t)
+	case JSON:
+		return writeJSON(w, t)
+	case Parquet:
+		return writeParquet(w, t)
+	default:
+		return fmt.Errorf("export: unknown format %q, want csv, json or parquet", format)
+	}
+}
+
+// check makes sure every value is of its column's kind, so the writers don't have to
+func (t *Table) check() error {
+	for i, row := range t.Rows {
+		if len(row) != len(t.Columns) {
+			return fmt.Errorf("export: row %d has %d values for %d columns", i, len(row), len(t.Columns))
+		}
+		for j, v := range row {
+			var ok bool
+			switch t.Columns[j].Kind {
+			case String:
+				_, ok = v.(string)
+			case Int:
+				_, ok = v.(int64)
+			case Float:
+				_, ok = v.(float64)
+			case Bool:
+				_, ok = v.(bool)
+			case Time:
+				_, ok = v.(time.Time)
+			}
+			if !ok {
+				return fmt.Errorf("export: row %d: %T for column %s", i, v, t.Columns[j].Name)
+			}
+		}
+	}
+	return nil
+}
+
+// format is how a value is written as text
+func format(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	case time.Time:
+		return v.UTC().Format("2006-01-02T15:04:05.000Z07:00")
+	}
+	return fmt.Sprint(v)
+}
+
+func writeCSV(w io.Writer, t *Table) error {
+	cw := csv.NewWriter(w)
+	header := make([]string, len(t.Columns))
+	for i, c := range t.Columns {
+		header[i] = c.Name
+	}
+	if err := cw.Write(header); err != nil {
+		return err
+	}
+	record := make([]string, len(t.Columns))
+	for _, row := range t.Rows {
+		for i, v := range row {
+			record[i] = format(v)
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+func writeJSON(w io.Writer, t *Table) error {
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	// each row is an object with its keys in column order
+	for _, row := range t.Rows {
+		var obj orderedRow
+		obj.columns, obj.values = t.Columns, row
+		if err := enc.Encode(obj); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// orderedRow encodes a row as a JSON object, keys in column order
+type orderedRow struct {
+	columns []Column
+	values  []interface{}
+}
+
+func (r orderedRow) MarshalJSON() ([]byte, error) {
+	b := []byte{'{'}
+	for i, c := range r.columns {
+		if i > 0 {
+			b = append(b, ',')
+		}
+		key, _ := json.Marshal(c.Name)
+		b = append(b, key...)
+		b = append(b, ':')
+		v := r.values[i]
+		if tm, ok := v.(time.Time); ok {
+			v = format(tm)
+		}
+		value, err := json.Marshal(v)
+		if err != nil {
+			return nil, err
+		}
+		b = append(b, value...)
+	}
+	return append(b, '}'), nil
+}
@@ -0,0 +1,274 @@
+package export
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+)
+
+// The Parquet writer is the smallest subset of the format readers need: every
+// column is required and plain encoded, uncompressed, in a single data page of a
+// single row group. The metadata is Thrift's compact protocol, written by hand
+// like the other codecs so the build needs no Parquet or Thrift library.
+
+const parquetMagic = "PAR1"
+
+// Parquet physical types, converted types and enums used by the writer
+const (
+	pqBoolean   = 0
+	pqInt64     = 2
+	pqDouble    = 5
+	pqByteArray = 6
+
+	pqUTF8            = 0
+	pqTimestampMillis = 9
+
+	pqRequired     = 0
+	pqPlain        = 0
+	pqRLE          = 3
+	pqUncompressed = 0
+	pqDataPage     = 0
+)
+
+// physical returns the Parquet physical type and, when it has one, the converted type of kind
+func physical(kind Kind) (typ int32, converted int32, hasConverted bool) {
+	switch kind {
+	case Int:
+		return pqInt64, 0, false
+	case Float:
+		return pqDouble, 0, false
+	case Bool:
+		return pqBoolean, 0, false
+	case Time:
+		return pqInt64, pqTimestampMillis, true
+	}
+	return pqByteArray, pqUTF8, true
+}
+
+func writeParquet(w io.Writer, t *Table) error {
+	var file bytes.Buffer
+	file.WriteString(parquetMagic)
+	type chunk struct {
+		offset, size int64
+	}
+	chunks := make([]chunk, len(t.Columns))
+	for i, c := range t.Columns {
+		values := plainValues(c.Kind, t.Rows, i)
+		var header thrift
+		header.i32(1, pqDataPage)
+		header.i32(2, int32(len(values)))
+		header.i32(3, int32(len(values)))
+		header.beginStruct(5)
+		header.i32(1, int32(len(t.Rows)))
+		header.i32(2, pqPlain)
+		header.i32(3, pqRLE)
+		header.i32(4, pqRLE)
+		header.endStruct()
+		header.stop()
+		chunks[i].offset = int64(file.Len())
+		file.Write(header.b)
+		file.Write(values)
+		chunks[i].size = int64(file.Len()) - chunks[i].offset
+	}
+
+	var meta thrift
+	meta.i32(1, 1)
+	meta.beginList(2, thriftStruct, len(t.Columns)+1)
+	meta.beginElem()
+	meta.binary(4, "schema")
+	meta.i32(5, int32(len(t.Columns)))
+	meta.endElem()
+	for _, c := range t.Columns {
+		typ, converted, ok := physical(c.Kind)
+		meta.beginElem()
+		meta.i32(1, typ)
+		meta.i32(3, pqRequired)
+		meta.binary(4, c.Name)
+		if ok {
+			meta.i32(6, converted)
+		}
+		meta.endElem()
+	}
+	meta.i64(3, int64(len(t.Rows)))
+	meta.beginList(4, thriftStruct, 1)
+	meta.beginElem()
+	meta.beginList(1, thriftStruct, len(t.Columns))
+	var total int64
+	for i, c := range t.Columns {
+		typ, _, _ := physical(c.Kind)
+		meta.beginElem()
+		meta.i64(2, chunks[i].offset)
+		meta.beginStruct(3)
+		meta.i32(1, typ)
+		meta.beginList(2, thriftI32, 2)
+		meta.listI32(pqPlain)
+		meta.listI32(pqRLE)
+		meta.beginList(3, thriftBinary, 1)
+		meta.listBinary(c.Name)
+		meta.i32(4, pqUncompressed)
+		meta.i64(5, int64(len(t.Rows)))
+		meta.i64(6, chunks[i].size)
+		meta.i64(7, chunks[i].size)
+		meta.i64(9, chunks[i].offset)
+		meta.endStruct()
+		meta.endElem()
+		total += chunks[i].size
+	}
+	meta.i64(2, total)
+	meta.i64(3, int64(len(t.Rows)))
+	meta.endElem()
+	meta.binary(6, "twitter-poll export")
+	meta.stop()
+
+	file.Write(meta.b)
+	file.Write(appendUint32(nil, uint32(len(meta.b))))
+	file.WriteString(parquetMagic)
+	_, err := w.Write(file.Bytes())
+	return err
+}
+
+// plainValues plain encodes column i of rows
+func plainValues(kind Kind, rows [][]interface{}, i int) []byte {
+	var b []byte
+	var bits byte // booleans are packed 8 to a byte, first value in the lowest bit
+	for j, row := range rows {
+		switch v := row[i].(type) {
+		case string:
+			b = appendUint32(b, uint32(len(v)))
+			b = append(b, v...)
+		case int64:
+			b = appendUint64(b, uint64(v))
+		case float64:
+			b = appendUint64(b, math.Float64bits(v))
+		case time.Time:
+			b = appendUint64(b, uint64(v.UnixNano()/int64(time.Millisecond)))
+		case bool:
+			if v {
+				bits |= 1 << uint(j%8)
+			}
+			if j%8 == 7 {
+				b = append(b, bits)
+				bits = 0
+			}
+		}
+	}
+	if kind == Bool && len(rows)%8 != 0 {
+		b = append(b, bits)
+	}
+	return b
+}
+
+// Thrift compact protocol types
+const (
+	thriftI32    = 5
+	thriftI64    = 6
+	thriftBinary = 8
+	thriftList   = 9
+	thriftStruct = 12
+)
+
+// thrift writes a struct in Thrift's compact protocol. Field IDs are written
+// as deltas from the previous field of the same struct, so nested structs and
+// list elements keep their own.
+type thrift struct {
+	b    []byte
+	last []int16 // the last field ID, per struct being written
+}
+
+func (t *thrift) field(id int16, typ byte) {
+	if len(t.last) == 0 {
+		t.last = []int16{0}
+	}
+	last := &t.last[len(t.last)-1]
+	if delta := id - *last; delta > 0 && delta <= 15 {
+		t.b = append(t.b, byte(delta)<<4|typ)
+	} else {
+		t.b = append(t.b, typ)
+		t.b = appendVarint(t.b, zigzag(int64(id)))
+	}
+	*last = id
+}
+
+func (t *thrift) i32(id int16, v int32) {
+	t.field(id, thriftI32)
+	t.b = appendVarint(t.b, zigzag(int64(v)))
+}
+
+func (t *thrift) i64(id int16, v int64) {
+	t.field(id, thriftI64)
+	t.b = appendVarint(t.b, zigzag(v))
+}
+
+func (t *thrift) binary(id int16, s string) {
+	t.field(id, thriftBinary)
+	t.listBinary(s)
+}
+
+func (t *thrift) beginStruct(id int16) {
+	t.field(id, thriftStruct)
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) endStruct() {
+	t.stop()
+	t.last = t.last[:len(t.last)-1]
+}
+
+// stop ends the top level struct
+func (t *thrift) stop() {
+	t.b = append(t.b, 0)
+}
+
+func (t *thrift) beginList(id int16, elem byte, size int) {
+	t.field(id, thriftList)
+	if size < 15 {
+		t.b = append(t.b, byte(size)<<4|elem)
+	} else {
+		t.b = append(t.b, 0xf0|elem)
+		t.b = appendVarint(t.b, uint64(size))
+	}
+}
+
+// beginElem starts a struct element of a list
+func (t *thrift) beginElem() {
+	t.last = append(t.last, 0)
+}
+
+func (t *thrift) endElem() {
+	t.endStruct()
+}
+
+func (t *thrift) listI32(v int32) {
+	t.b = appendVarint(t.b, zigzag(int64(v)))
+}
+
+func (t *thrift) listBinary(s string) {
+	t.b = appendVarint(t.b, uint64(len(s)))
+	t.b = append(t.b, s...)
+}
+
+func appendUint32(b []byte, v uint32) []byte {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], v)
+	return append(b, n[:]...)
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var n [8]byte
+	binary.LittleEndian.PutUint64(n[:], v)
+	return append(b, n[:]...)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
@@ -0,0 +1,450 @@
+// Package expr compiles the filter expressions of polls, rules about each
+// tweet that the plain options can't express:
+//
+//	contains("go") AND NOT contains("pokemon go") AND lang == "en"
+//
+// An expression is conditions joined with AND, OR and NOT, in any case, and
+// grouped with parentheses; NOT binds tighter than AND, and AND than OR.
+// The conditions are:
+//
+//	contains("s")        the text has s, ignoring case
+//	word("s")            the text has s as whole words
+//	matches("re")        the text matches the regular expression re, see regexp
+//	hashtag("s")         the tweet has the hashtag s, with or without its #
+//	mentions("s")        the tweet mentions the account s, with or without its @
+//	retweet, quote, reply, verified
+//	                     the tweet is a retweet, a quote or a reply, or its author is verified
+//	lang, source, option, user, country == "s"
+//	                     the language of the tweet, where it was cast (twitter, sms...),
+//	                     the option voted for, the screen name of the author and the
+//	                     country code of where it was cast are s, ignoring case; also !=,
+//	                     and in ("s", "t") for any of a list
+//	followers > n        the author has more than n followers; also ==, !=, <, <= and >=
+//
+// Strings are quoted with double quotes, in which \" and \\ are a quote and a backslash.
+package expr
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// Expr is a compiled expression
+type Expr struct {
+	src  string
+	eval func(v *match.Vote) bool
+}
+
+// Compile parses src, failing with where it isn't valid
+func Compile(src string) (*Expr, error) {
+	p := &parser{lex: lexer{src: src}}
+	p.next()
+	eval, err := p.or()
+	if err == nil {
+		// a token the lexer failed on ends the expression
+		err = p.err
+	}
+	if err == nil && p.tok.kind != tokEOF {
+		err = p.errorf("unexpected %s", p.tok)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Expr{src: src, eval: eval}, nil
+}
+
+// Match reports whether the tweet of v meets e
+func (e *Expr) Match(v *match.Vote) bool {
+	return e.eval(v)
+}
+
+// String returns the source e was compiled from
+func (e *Expr) String() string {
+	return e.src
+}
+
+type tokKind int
+
+const (
+	tokEOF tokKind = iota
+	tokIdent
+	tokString
+	tokNumber
+	tokOp // == != < <= > >=
+	tokLParen
+	tokRParen
+	tokComma
+)
+
+type token struct {
+	kind tokKind
+	text string // the identifier, operator or number, or the unquoted string
+	pos  int    // of its first byte in the source
+}
+
+func (t token) String() string {
+	if t.kind == tokEOF {
+		return "the end"
+	}
+	return strconv.Quote(t.text)
+}
+
+// lexer splits an expression into tokens
+type lexer struct {
+	src string
+	pos int
+}
+
+func (l *lexer) next() (token, error) {
+	for l.pos < len(l.src) && strings.IndexByte(" \t\r\n", l.src[l.pos]) >= 0 {
+		l.pos++
+	}
+	start := l.pos
+	if l.pos == len(l.src) {
+		return token{kind: tokEOF, pos: start}, nil
+	}
+	c := l.src[l.pos]
+	switch {
+	case c == '(':
+		l.pos++
+		return token{kind: tokLParen, text: "(", pos: start}, nil
+	case c == ')':
+		l.pos++
+		return token{kind: tokRParen, text: ")", pos: start}, nil
+	case c == ',':
+		l.pos++
+		return token{kind: tokComma, text: ",", pos: start}, nil
+	case c == '"':
+		for l.pos++; l.pos < len(l.src) && l.src[l.pos] != '"'; l.pos++ {
+			if l.src[l.pos] == '\\' {
+				l.pos++
+			}
+		}
+		if l.pos >= len(l.src) {
+			return token{}, fmt.Errorf("expr: unterminated string at %d", start+1)
+		}
+		l.pos++
+		s, err := strconv.Unquote(l.src[start:l.pos])
+		if err != nil {
+			return token{}, fmt.Errorf("expr: invalid string at %d", start+1)
+		}
+		return token{kind: tokString, text: s, pos: start}, nil
+	case strings.IndexByte("=!<>", c) >= 0:
+		l.pos++
+		if l.pos < len(l.src) && l.src[l.pos] == '=' {
+			l.pos++
+		}
+		op := l.src[start:l.pos]
+		if op == "=" || op == "!" {
+			return token{}, fmt.Errorf("expr: invalid operator %q at %d, want == or !=", op, start+1)
+		}
+		return token{kind: tokOp, text: op, pos: start}, nil
+	case c >= '0' && c <= '9':
+		for l.pos < len(l.src) && (l.src[l.pos] >= '0' && l.src[l.pos] <= '9' || l.src[l.pos] == '.') {
+			l.pos++
+		}
+		return token{kind: tokNumber, text: l.src[start:l.pos], pos: start}, nil
+	case c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z':
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || l.src[l.pos] >= 'a' && l.src[l.pos] <= 'z' || l.src[l.pos] >= 'A' && l.src[l.pos] <= 'Z' || l.src[l.pos] >= '0' && l.src[l.pos] <= '9') {
+			l.pos++
+		}
+		return token{kind: tokIdent, text: l.src[start:l.pos], pos: start}, nil
+	}
+	return token{}, fmt.Errorf("expr: unexpected %q at %d", c, start+1)
+}
+
+// parser compiles an expression by recursive descent, each rule returning
+// the function evaluating what it parsed
+type parser struct {
+	lex lexer
+	tok token
+	err error // of the lexer, reported by the rule reading the token
+}
+
+func (p *parser) next() {
+	if p.err != nil {
+		return
+	}
+	p.tok, p.err = p.lex.next()
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	if p.err != nil {
+		return p.err
+	}
+	return fmt.Errorf("expr: "+format+" at %d", append(args, p.tok.pos+1)...)
+}
+
+// keyword reports whether the token is the keyword kw, in any case
+func (p *parser) keyword(kw string) bool {
+	return p.err == nil && p.tok.kind == tokIdent && strings.EqualFold(p.tok.text, kw)
+}
+
+type evalFunc = func(v *match.Vote) bool
+
+// or is and { OR and }
+func (p *parser) or() (evalFunc, error) {
+	left, err := p.and()
+	for err == nil && p.keyword("or") {
+		p.next()
+		var right evalFunc
+		if right, err = p.and(); err == nil {
+			l := left
+			left = func(v *match.Vote) bool { return l(v) || right(v) }
+		}
+	}
+	return left, err
+}
+
+// and is not { AND not }
+func (p *parser) and() (evalFunc, error) {
+	left, err := p.not()
+	for err == nil && p.keyword("and") {
+		p.next()
+		var right evalFunc
+		if right, err = p.not(); err == nil {
+			l := left
+			left = func(v *match.Vote) bool { return l(v) && right(v) }
+		}
+	}
+	return left, err
+}
+
+// not is NOT not | primary
+func (p *parser) not() (evalFunc, error) {
+	if !p.keyword("not") {
+		return p.primary()
+	}
+	p.next()
+	x, err := p.not()
+	if err != nil {
+		return nil, err
+	}
+	return func(v *match.Vote) bool { return !x(v) }, nil
+}
+
+// primary is ( or ) | function ( string ) | field op value | field in ( strings ) | flag
+func (p *parser) primary() (evalFunc, error) {
+	if p.err == nil && p.tok.kind == tokLParen {
+		p.next()
+		x, err := p.or()
+		if err != nil {
+			return nil, err
+		}
+		if p.err != nil || p.tok.kind != tokRParen {
+			return nil, p.errorf("want ) instead of %s", p.tok)
+		}
+		p.next()
+		return x, nil
+	}
+	if p.err != nil || p.tok.kind != tokIdent || p.keyword("and") || p.keyword("or") {
+		return nil, p.errorf("want a condition instead of %s", p.tok)
+	}
+	ident := p.tok
+	name := strings.ToLower(ident.text)
+	p.next()
+	if p.err == nil && p.tok.kind == tokLParen {
+		return p.function(ident, name)
+	}
+	if f, ok := flags[name]; ok {
+		return f, nil
+	}
+	if f, ok := stringFields[name]; ok {
+		return p.stringCondition(name, f)
+	}
+	if name == "followers" {
+		return p.numberCondition(name, func(v *match.Vote) float64 { return float64(v.User.FollowersCount) })
+	}
+	p.tok = ident
+	return nil, p.errorf("unknown condition %s", ident)
+}
+
+// function parses the string argument of the function name and its )
+func (p *parser) function(ident token, name string) (evalFunc, error) {
+	build, ok := functions[name]
+	if !ok {
+		p.tok = ident
+		return nil, p.errorf("unknown function %s", ident)
+	}
+	p.next()
+	if p.err != nil || p.tok.kind != tokString {
+		return nil, p.errorf("%s wants a string instead of %s", name, p.tok)
+	}
+	arg := p.tok
+	p.next()
+	if p.err != nil || p.tok.kind != tokRParen {
+		return nil, p.errorf("want ) instead of %s", p.tok)
+	}
+	p.next()
+	f, err := build(arg.text)
+	if err != nil {
+		p.tok = arg
+		return nil, p.errorf("invalid %s argument: %v", name, err)
+	}
+	return f, nil
+}
+
+// stringCondition parses the comparison of a string field
+func (p *parser) stringCondition(name string, field func(v *match.Vote) string) (evalFunc, error) {
+	if p.keyword("in") {
+		p.next()
+		if p.err != nil || p.tok.kind != tokLParen {
+			return nil, p.errorf("want ( after in instead of %s", p.tok)
+		}
+		var list []string
+		for {
+			p.next()
+			if p.err != nil || p.tok.kind != tokString {
+				return nil, p.errorf("want a string instead of %s", p.tok)
+			}
+			list = append(list, p.tok.text)
+			p.next()
+			if p.err == nil && p.tok.kind == tokRParen {
+				break
+			}
+			if p.err != nil || p.tok.kind != tokComma {
+				return nil, p.errorf("want , or ) instead of %s", p.tok)
+			}
+		}
+		p.next()
+		return func(v *match.Vote) bool {
+			s := field(v)
+			for _, item := range list {
+				if strings.EqualFold(s, item) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	}
+	if p.err != nil || p.tok.kind != tokOp || (p.tok.text != "==" && p.tok.text != "!=") {
+		return nil, p.errorf("%s wants ==, != or in instead of %s", name, p.tok)
+	}
+	equal := p.tok.text == "=="
+	p.next()
+	if p.err != nil || p.tok.kind != tokString {
+		return nil, p.errorf("%s is compared to a string, not %s", name, p.tok)
+	}
+	want := p.tok.text
+	p.next()
+	return func(v *match.Vote) bool { return strings.EqualFold(field(v), want) == equal }, nil
+}
+
+// numberCondition parses the comparison of a number field
+func (p *parser) numberCondition(name string, field func(v *match.Vote) float64) (evalFunc, error) {
+	if p.err != nil || p.tok.kind != tokOp {
+		return nil, p.errorf("%s wants a comparison instead of %s", name, p.tok)
+	}
+	op := p.tok.text
+	p.next()
+	if p.err != nil || p.tok.kind != tokNumber {
+		return nil, p.errorf("%s is compared to a number, not %s", name, p.tok)
+	}
+	n, err := strconv.ParseFloat(p.tok.text, 64)
+	if err != nil {
+		return nil, p.errorf("invalid number %s", p.tok)
+	}
+	p.next()
+	compare := map[string]func(a float64) bool{
+		"==": func(a float64) bool { return a == n },
+		"!=": func(a float64) bool { return a != n },
+		"<":  func(a float64) bool { return a < n },
+		"<=": func(a float64) bool { return a <= n },
+		">":  func(a float64) bool { return a > n },
+		">=": func(a float64) bool { return a >= n },
+	}[op]
+	return func(v *match.Vote) bool { return compare(field(v)) }, nil
+}
+
+// functions build the conditions of the functions from their argument
+var functions = map[string]func(arg string) (evalFunc, error){
+	"contains": func(arg string) (evalFunc, error) {
+		term := textnorm.Fold(arg)
+		if term == "" {
+			return nil, fmt.Errorf("empty")
+		}
+		return func(v *match.Vote) bool { return textnorm.Contains(textnorm.Fold(v.Text), term) }, nil
+	},
+	"word": func(arg string) (evalFunc, error) {
+		term := textnorm.Fold(arg)
+		if term == "" {
+			return nil, fmt.Errorf("empty")
+		}
+		return func(v *match.Vote) bool { return textnorm.IndexWord(textnorm.Fold(v.Text), term) >= 0 }, nil
+	},
+	"matches": func(arg string) (evalFunc, error) {
+		re, err := regexp.Compile(arg)
+		if err != nil {
+			return nil, err
+		}
+		return func(v *match.Vote) bool { return re.MatchString(v.Text) }, nil
+	},
+	"hashtag": func(arg string) (evalFunc, error) {
+		tag := strings.TrimPrefix(arg, "#")
+		if tag == "" {
+			return nil, fmt.Errorf("empty")
+		}
+		return func(v *match.Vote) bool {
+			if v.Entities == nil {
+				return inText(v, "#"+tag)
+			}
+			for _, h := range v.Entities.Hashtags {
+				if strings.EqualFold(h.Text, tag) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+	"mentions": func(arg string) (evalFunc, error) {
+		name := strings.TrimPrefix(arg, "@")
+		if name == "" {
+			return nil, fmt.Errorf("empty")
+		}
+		return func(v *match.Vote) bool {
+			if v.Entities == nil {
+				return inText(v, "@"+name)
+			}
+			for _, m := range v.Entities.UserMentions {
+				if strings.EqualFold(m.ScreenName, name) {
+					return true
+				}
+			}
+			return false
+		}, nil
+	},
+}
+
+// inText reports whether the text of v has the hashtag or the mention s as a
+// whole word, for the votes the streamer matched, which no longer carry the
+// entities of their tweet, see match.Vote
+func inText(v *match.Vote, s string) bool {
+	return textnorm.IndexWord(textnorm.Fold(v.Text), textnorm.Fold(s)) >= 0
+}
+
+// flags are the conditions that are a name alone
+var flags = map[string]evalFunc{
+	"retweet":  func(v *match.Vote) bool { return v.Retweet },
+	"quote":    func(v *match.Vote) bool { return v.Quote },
+	"reply":    func(v *match.Vote) bool { return v.Reply },
+	"verified": func(v *match.Vote) bool { return v.User.Verified },
+}
+
+// stringFields are the fields compared to strings
+var stringFields = map[string]func(v *match.Vote) string{
+	"lang":   func(v *match.Vote) string { return v.Lang },
+	"source": func(v *match.Vote) string { return v.Source },
+	"option": func(v *match.Vote) string { return v.Option },
+	"user":   func(v *match.Vote) string { return strings.TrimPrefix(v.User.ScreenName, "@") },
+	"country": func(v *match.Vote) string {
+		if v.Geo == nil {
+			return ""
+		}
+		return v.Geo.CountryCode
+	},
+}
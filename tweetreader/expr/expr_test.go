@@ -0,0 +1,130 @@
+package expr
+
+import (
+	"testing"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// testVote is a reply in English by a verified author from the UK, voting go
+func testVote() *match.Vote {
+	v := &match.Vote{Option: "go", Source: "twitter", Geo: &match.Geo{CountryCode: "GB"}, Reply: true}
+	v.Text = "Go is the best, #golang all the way @gophers"
+	v.Lang = "en"
+	v.User.ScreenName, v.User.Verified, v.User.FollowersCount = "ada", true, 1200
+	v.Entities = &stream.Entities{
+		Hashtags:     []stream.Hashtag{{Text: "golang"}},
+		UserMentions: []stream.Mention{{ScreenName: "gophers"}},
+	}
+	return v
+}
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`contains("go")`, true},
+		{`contains("GO IS")`, true},
+		{`contains("pokemon go")`, false},
+		{`contains("go") AND NOT contains("pokemon go") AND lang == "en"`, true},
+		{`contains("go") and not contains("best")`, false},
+		{`word("go")`, true},
+		{`word("gol")`, false},
+		{`matches("^Go is")`, true},
+		{`matches("^go is")`, false},
+		{`hashtag("#golang")`, true},
+		{`hashtag("GOLANG")`, true},
+		{`hashtag("rust")`, false},
+		{`mentions("@gophers")`, true},
+		{`mentions("rustaceans")`, false},
+		{`reply AND verified`, true},
+		{`retweet OR quote`, false},
+		{`lang == "EN"`, true},
+		{`lang != "en"`, false},
+		{`lang in ("fr", "en")`, true},
+		{`country in ("FR", "DE")`, false},
+		{`source == "twitter" AND option == "go" AND user == "ada"`, true},
+		{`followers > 1000`, true},
+		{`followers >= 1200 AND followers <= 1200 AND followers == 1200`, true},
+		{`followers < 1000 OR followers != 1200`, false},
+		// NOT binds tighter than AND, and AND than OR
+		{`NOT retweet AND reply`, true},
+		{`retweet AND reply OR verified`, true},
+		{`retweet AND (reply OR verified)`, false},
+		{`contains("say \"go\"") OR contains("\\")`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Match(testVote()); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+			if e.String() != tt.src {
+				t.Errorf("String = %q, want %q", e.String(), tt.src)
+			}
+		})
+	}
+}
+
+func TestMatchWithoutEntities(t *testing.T) {
+	// the votes the streamer matched no longer have the entities of their tweet
+	v := testVote()
+	v.Entities = nil
+	tests := []struct {
+		src  string
+		want bool
+	}{
+		{`hashtag("golang")`, true},
+		{`hashtag("#GoLang")`, true},
+		{`hashtag("go")`, false},
+		{`mentions("@gophers")`, true},
+		{`mentions("gopher")`, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := Compile(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := e.Match(v); got != tt.want {
+				t.Errorf("Match = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCompileFails(t *testing.T) {
+	tests := []string{
+		``,
+		`contains("go"`,
+		`contains(go)`,
+		`contains("")`,
+		`hashtag("#")`,
+		`matches("(")`,
+		`unknown("go")`,
+		`golang`,
+		`lang == 3`,
+		`lang > "en"`,
+		`lang in "en"`,
+		`lang in ("en" "fr")`,
+		`followers > "many"`,
+		`followers`,
+		`contains("go") AND`,
+		`contains("go") contains("rust")`,
+		`(retweet OR quote`,
+		`retweet)`,
+		`contains("unterminated)`,
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			if _, err := Compile(src); err == nil {
+				t.Errorf("compiled %q", src)
+			}
+		})
+	}
+}
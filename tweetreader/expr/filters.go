@@ -0,0 +1,245 @@
+package expr
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var (
+	filteredOut = metrics.NewCounter("tweetreader_expr_filtered_votes_total",
+		"Votes for a poll whose filter expression their tweet failed, counted once per poll.")
+	routedOut = metrics.NewCounter("tweetreader_expr_unrouted_votes_total",
+		"Votes for an option polls share left out of a poll whose route their tweet failed, counted once per poll.")
+	filterDropped = metrics.NewCounter("tweetreader_expr_dropped_votes_total",
+		"Votes dropped because the tweet failed the filter or the route of every poll having the option.")
+)
+
+// pollFilter is the compiled filter of a poll
+type pollFilter struct {
+	poll string
+	expr *Expr // nil when the filter doesn't compile, the poll takes no vote
+}
+
+// Filters evaluates the filters of the tracked polls on their votes. A vote
+// is for an option and the polls having it may filter differently: each vote
+// carries the polls whose filter its tweet failed in Filtered, for the
+// counter to leave it out of their results, and it is dropped when it failed
+// every poll's.
+//
+// The term of an option two tracked polls have, in any case, is a conflict:
+// by default its votes count for both, and a poll with a Route only counts
+// the ones whose tweet meets it, the way a filter is met. A vote failing the
+// route is in Filtered too, and one matching no route, with no poll taking
+// every vote, is dropped.
+type Filters struct {
+	mu        sync.RWMutex
+	of        map[string][]pollFilter // the filtered polls per option
+	routes    map[string][]pollFilter // the routed polls per option, for the shared options
+	closed    map[string]int          // the polls per option not taking every vote, filtered or routed
+	open      map[string]bool         // the options of tracked polls without a filter, nor a route for them
+	conflicts []Conflict
+	compiled  map[string]*Expr // by source, nil for the ones that don't compile
+}
+
+// Conflict is a term several tracked polls have as an option
+type Conflict struct {
+	Term string `json:"term"`
+	// Routed is true when some of the polls route its votes, false when they
+	// all count every vote for it
+	Routed bool           `json:"routed"`
+	Polls  []ConflictPoll `json:"polls"`
+}
+
+// ConflictPoll is a poll having the term of a Conflict
+type ConflictPoll struct {
+	ID     string `json:"id"`
+	Option string `json:"option"` // as the poll writes it
+	// Route is the poll's route, its votes for the term count for it when
+	// their tweet meets it; empty when it counts them all
+	Route string `json:"route,omitempty"`
+	// Invalid is true when the route doesn't compile, the poll takes none of them
+	Invalid bool `json:"invalid,omitempty"`
+}
+
+// NewFilters creates Filters filtering nothing until Update is called
+func NewFilters() *Filters {
+	return &Filters{compiled: make(map[string]*Expr)}
+}
+
+// Update compiles the filters and routes of the tracked polls, the ones
+// compiled before are kept. A filter that doesn't compile is logged once and
+// its poll takes no vote until it is fixed, a route that doesn't none for the
+// options it shares.
+func (f *Filters) Update(polls []store.Poll) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	of := make(map[string][]pollFilter)
+	routes := make(map[string][]pollFilter)
+	closed := make(map[string]int)
+	open := make(map[string]bool)
+	compiled := make(map[string]*Expr)
+	// compile returns the expression of src, nil when it doesn't compile, and
+	// the error when it was compiled for the first time
+	compile := func(src string) (*Expr, error) {
+		if e, ok := compiled[src]; ok {
+			return e, nil
+		}
+		e, ok := f.compiled[src]
+		var err error
+		if !ok {
+			e, err = Compile(src)
+		}
+		compiled[src] = e
+		return e, err
+	}
+	byTerm := make(map[string][]ConflictPoll)
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		seen := make(map[string]bool)
+		for _, o := range p.Options {
+			if term := strings.ToLower(o); !seen[term] {
+				seen[term] = true
+				byTerm[term] = append(byTerm[term], ConflictPoll{ID: p.ID, Option: o, Route: p.Route})
+			}
+		}
+	}
+	for i := range polls {
+		p := &polls[i]
+		if !p.Tracked() {
+			continue
+		}
+		var filter, route *Expr
+		if p.Filter != "" {
+			var err error
+			if filter, err = compile(p.Filter); err != nil {
+				log.Printf("expr: poll %s takes no votes, its filter is invalid: %v", p.ID, err)
+			}
+		}
+		if p.Route != "" {
+			var err error
+			if route, err = compile(p.Route); err != nil {
+				log.Printf("expr: poll %s takes no votes for the options it shares, its route is invalid: %v", p.ID, err)
+			}
+		}
+		seen := make(map[string]bool)
+		for _, o := range p.Options {
+			if seen[o] {
+				continue
+			}
+			seen[o] = true
+			routed := p.Route != "" && len(byTerm[strings.ToLower(o)]) > 1
+			if routed {
+				routes[o] = append(routes[o], pollFilter{poll: p.ID, expr: route})
+			}
+			if p.Filter != "" {
+				of[o] = append(of[o], pollFilter{poll: p.ID, expr: filter})
+			}
+			if routed || p.Filter != "" {
+				closed[o]++
+			} else {
+				open[o] = true
+			}
+		}
+	}
+	var conflicts []Conflict
+	for term, having := range byTerm {
+		if len(having) < 2 {
+			continue
+		}
+		c := Conflict{Term: term, Polls: having}
+		for i := range c.Polls {
+			if c.Polls[i].Route != "" {
+				c.Routed = true
+				c.Polls[i].Invalid = compiled[c.Polls[i].Route] == nil
+			}
+		}
+		conflicts = append(conflicts, c)
+	}
+	sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Term < conflicts[j].Term })
+	f.of, f.routes, f.closed, f.open, f.conflicts, f.compiled = of, routes, closed, open, conflicts, compiled
+}
+
+// Conflicts returns the terms several tracked polls have, by term, in lower case
+func (f *Filters) Conflicts() []Conflict {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+	return f.conflicts
+}
+
+// Options wraps a function loading the options so every load also compiles
+// the filters of the polls having them. When the polls can't be loaded the
+// last filters are kept.
+func (f *Filters) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("expr: failed to load the polls, keeping the last filters:", err)
+			return options, nil
+		}
+		f.Update(all)
+		return options, nil
+	}
+}
+
+// Filter sets the polls whose filter, or route, v's tweet fails on v. It
+// reports false when v failed those of all the polls having its option, and
+// is dropped.
+func (f *Filters) Filter(v *match.Vote) bool {
+	f.mu.RLock()
+	filters, routes, closed, open := f.of[v.Option], f.routes[v.Option], f.closed[v.Option], f.open[v.Option]
+	f.mu.RUnlock()
+	v.Filtered = nil
+	for _, pf := range filters {
+		if pf.expr == nil || !pf.expr.Match(v) {
+			filteredOut.Inc()
+			v.Filtered = append(v.Filtered, pf.poll)
+		}
+	}
+	for _, pf := range routes {
+		if (pf.expr == nil || !pf.expr.Match(v)) && !contains(v.Filtered, pf.poll) {
+			routedOut.Inc()
+			v.Filtered = append(v.Filtered, pf.poll)
+		}
+	}
+	if closed > 0 && len(v.Filtered) == closed && !open {
+		filterDropped.Inc()
+		return false
+	}
+	return true
+}
+
+// Run filters the votes from in and passes on the ones kept, the returned
+// channel is closed once in is
+func (f *Filters) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if f.Filter(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
+
+func contains(list []string, s string) bool {
+	for _, e := range list {
+		if e == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,314 @@
+// Package features turns stages of the streamer on and off while it runs,
+// for every poll or for some of them, without restarting it.
+//
+// Every flag has a default, what the streamer was started with, which the
+// FEATURE_FLAGS configuration can override. The admin API then sets a flag for
+// all polls or for one poll, a poll's setting winning over the one for all of
+// them. The settings are kept in a snapshot, which every streamer reads back
+// when it loads the options, so they last across restarts and reach the other
+// streamers with their next refresh.
+package features
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// snapshot is the name the settings are saved under
+const snapshot = "features"
+
+// The flags, each names a stage of the streamer
+const (
+	// ContentFilter drops the votes with blocked terms and masks the redacted ones
+	ContentFilter = "content_filter"
+	// Moderation holds the contested votes for review
+	Moderation = "moderation"
+)
+
+// Known are every flag
+var Known = []string{ContentFilter, Moderation}
+
+// ErrUnknown is returned for a flag that isn't one of Known
+var ErrUnknown = errors.New("features: unknown flag")
+
+var skipped = metrics.NewCounter("tweetreader_feature_skipped_votes_total",
+	"Votes a stage let through untouched because its flag is off for their polls, by flag.")
+
+// Setting is a flag set through the admin API, for Poll or for every poll when it is empty
+type Setting struct {
+	Flag    string    `json:"flag"`
+	Poll    string    `json:"poll,omitempty"`
+	Enabled bool      `json:"enabled"`
+	Since   time.Time `json:"since"`
+}
+
+// Config is what the flags are when nothing is set
+type Config struct {
+	// Defaults are the flags on or off when nothing is set, a flag missing is off
+	Defaults map[string]bool
+	// Snapshots keeps the settings across restarts and streamers, when set
+	Snapshots store.SnapshotStore
+}
+
+type key struct{ flag, poll string }
+
+// Flags tells whether a flag is on for a poll
+type Flags struct {
+	cfg Config
+
+	mu       sync.Mutex
+	polls    map[string][]string // the polls of each option
+	settings map[key]Setting
+	now      func() time.Time
+}
+
+// New creates the Flags of cfg, knowing no polls until Update is called
+func New(cfg Config) *Flags {
+	return &Flags{cfg: cfg, settings: make(map[key]Setting), now: time.Now}
+}
+
+// ParseDefaults parses flags turned on or off, as moderation=on,content_filter=off
+func ParseDefaults(s string) (map[string]bool, error) {
+	defaults := make(map[string]bool)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("features: %q isn't flag=on or flag=off", part)
+		}
+		name := part[:eq]
+		if !known(name) {
+			return nil, fmt.Errorf("features: unknown flag %q, want %s", name, strings.Join(Known, ", "))
+		}
+		switch part[eq+1:] {
+		case "on":
+			defaults[name] = true
+		case "off":
+			defaults[name] = false
+		default:
+			return nil, fmt.Errorf("features: %q isn't flag=on or flag=off", part)
+		}
+	}
+	return defaults, nil
+}
+
+func known(flag string) bool {
+	for _, k := range Known {
+		if k == flag {
+			return true
+		}
+	}
+	return false
+}
+
+// Update takes the options of polls
+func (f *Flags) Update(polls []store.Poll) {
+	byOption := make(map[string][]string)
+	for _, p := range polls {
+		for _, o := range p.Options {
+			byOption[o] = append(byOption[o], p.ID)
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.polls = byOption
+}
+
+// Load reads the settings back from the snapshot, keeping the ones known
+// when there is none or it can't be read
+func (f *Flags) Load() error {
+	if f.cfg.Snapshots == nil {
+		return nil
+	}
+	b, err := f.cfg.Snapshots.LoadSnapshot(snapshot)
+	if err == store.ErrNoSnapshot {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var list []Setting
+	if err := json.Unmarshal(b, &list); err != nil {
+		return err
+	}
+	settings := make(map[key]Setting, len(list))
+	for _, s := range list {
+		settings[key{s.Flag, s.Poll}] = s
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.settings = settings
+	return nil
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the polls and the settings. When they can't be loaded the last ones are kept.
+func (f *Flags) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if err := f.Load(); err != nil {
+			log.Println("features: failed to load the flags, keeping the last ones:", err)
+		}
+		if all, err := polls.Polls(); err != nil {
+			log.Println("features: failed to load the polls, keeping the last ones:", err)
+		} else {
+			f.Update(all)
+		}
+		return options, nil
+	}
+}
+
+// Set turns flag on or off for poll, for every poll when it is empty
+func (f *Flags) Set(flag, poll string, enabled bool) (Setting, error) {
+	if !known(flag) {
+		return Setting{}, ErrUnknown
+	}
+	s := Setting{Flag: flag, Poll: poll, Enabled: enabled, Since: f.now().UTC()}
+	f.mu.Lock()
+	f.settings[key{flag, poll}] = s
+	list := f.list()
+	f.mu.Unlock()
+	log.Printf("features: %s %s for %s", flag, onOff(enabled), pollName(poll))
+	return s, f.save(list)
+}
+
+// Clear forgets what flag was set to for poll, and reports whether it was set
+func (f *Flags) Clear(flag, poll string) (bool, error) {
+	if !known(flag) {
+		return false, ErrUnknown
+	}
+	f.mu.Lock()
+	if _, ok := f.settings[key{flag, poll}]; !ok {
+		f.mu.Unlock()
+		return false, nil
+	}
+	delete(f.settings, key{flag, poll})
+	list := f.list()
+	f.mu.Unlock()
+	log.Printf("features: %s back to its default for %s", flag, pollName(poll))
+	return true, f.save(list)
+}
+
+// List returns the settings, every poll's first and then by flag and poll
+func (f *Flags) List() []Setting {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.list()
+}
+
+func (f *Flags) list() []Setting {
+	list := make([]Setting, 0, len(f.settings))
+	for _, s := range f.settings {
+		list = append(list, s)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Poll != list[j].Poll {
+			return list[i].Poll < list[j].Poll
+		}
+		return list[i].Flag < list[j].Flag
+	})
+	return list
+}
+
+func (f *Flags) save(list []Setting) error {
+	if f.cfg.Snapshots == nil {
+		return nil
+	}
+	b, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+	return f.cfg.Snapshots.SaveSnapshot(snapshot, b)
+}
+
+// Defaults returns whether every flag is on when nothing is set
+func (f *Flags) Defaults() map[string]bool {
+	defaults := make(map[string]bool, len(Known))
+	for _, k := range Known {
+		defaults[k] = f.cfg.Defaults[k]
+	}
+	return defaults
+}
+
+// Enabled reports whether flag is on for poll: as set for it, else as set for
+// every poll, else its default
+func (f *Flags) Enabled(flag, poll string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.enabled(flag, poll)
+}
+
+func (f *Flags) enabled(flag, poll string) bool {
+	if s, ok := f.settings[key{flag, poll}]; ok {
+		return s.Enabled
+	}
+	if s, ok := f.settings[key{flag, ""}]; ok {
+		return s.Enabled
+	}
+	return f.cfg.Defaults[flag]
+}
+
+// For reports whether flag is on for v, on for any poll of its option; for an
+// option no poll has it is as set for every poll
+func (f *Flags) For(flag string, v *match.Vote) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	ids := f.polls[v.Option]
+	if len(ids) == 0 {
+		return f.enabled(flag, "")
+	}
+	for _, id := range ids {
+		if f.enabled(flag, id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run passes on the votes from in that keep lets through, only asking keep
+// about the votes flag is on for; the returned channel is closed once in is
+func (f *Flags) Run(flag string, in <-chan match.Vote, keep func(v *match.Vote) bool) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if !f.For(flag, &v) {
+				skipped.Inc("flag", flag)
+			} else if !keep(&v) {
+				continue
+			}
+			out <- v
+		}
+	}()
+	return out
+}
+
+func onOff(enabled bool) string {
+	if enabled {
+		return "on"
+	}
+	return "off"
+}
+
+func pollName(poll string) string {
+	if poll == "" {
+		return "every poll"
+	}
+	return "poll " + poll
+}
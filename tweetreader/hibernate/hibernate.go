@@ -0,0 +1,177 @@
+// Package hibernate takes the options of idle low priority polls out of the
+// stream's filter, so the polls getting votes stay under Twitter's limit of
+// track terms.
+//
+// A low priority poll hibernates once none of its options matched a vote for
+// Idle: its options, unless a poll that isn't hibernating has them too, leave
+// the filter with the next options load. Every WakeEvery since it fell asleep
+// it wakes for WakeFor, its options tracked again; a vote meanwhile keeps it
+// awake, otherwise it goes back to sleep. Votes the stream would have matched
+// while a poll hibernates are lost, which is why only low priority polls do.
+// What matched is only known to the streamer, every one decides on its own
+// and starts with every poll awake.
+package hibernate
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var hibernations = metrics.NewCounter("tweetreader_hibernations_total",
+	"Low priority polls whose options left the stream's filter for being idle.")
+
+// Config says when polls hibernate, a zero Idle never has them
+type Config struct {
+	Idle      time.Duration // how long a low priority poll's options go without a vote before it hibernates
+	WakeEvery time.Duration // how often a hibernating poll wakes, never when 0
+	WakeFor   time.Duration // how long it stays awake to get a vote
+}
+
+// Hibernator keeps track of the votes for the options and the polls hibernating
+type Hibernator struct {
+	cfg Config
+
+	mu      sync.Mutex
+	started time.Time            // the options count as matched at start
+	matched map[string]time.Time // the last vote for each option
+	asleep  map[string]time.Time // the hibernating polls, since they fell asleep
+	now     func() time.Time
+}
+
+// New creates a Hibernator with every poll awake
+func New(cfg Config) *Hibernator {
+	h := &Hibernator{cfg: cfg, started: time.Now(), matched: make(map[string]time.Time), asleep: make(map[string]time.Time), now: time.Now}
+	metrics.NewGaugeFunc("tweetreader_hibernating_polls", "Low priority polls whose options aren't tracked for being idle.", func() float64 {
+		h.mu.Lock()
+		defer h.mu.Unlock()
+		return float64(len(h.asleep))
+	})
+	return h
+}
+
+// Matched records a vote for option
+func (h *Hibernator) Matched(option string) {
+	h.mu.Lock()
+	h.matched[option] = h.now()
+	h.mu.Unlock()
+}
+
+// Run passes on the votes from in, recording their options, the returned channel is closed once in is
+func (h *Hibernator) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			h.Matched(v.Option)
+			out <- v
+		}
+	}()
+	return out
+}
+
+// Update puts the idle low priority polls to sleep and wakes the others,
+// returning the polls whose options are left out of the filter: the ones
+// asleep and not woken up to check for votes
+func (h *Hibernator) Update(polls []store.Poll) map[string]bool {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	now := h.now()
+	out := make(map[string]bool)
+	seen := make(map[string]bool, len(polls))
+	for _, p := range polls {
+		if h.cfg.Idle <= 0 || p.Priority != store.PriorityLow || !p.AcceptsVotes() {
+			continue
+		}
+		seen[p.ID] = true
+		last := h.started
+		for _, o := range p.Options {
+			if t := h.matched[o]; t.After(last) {
+				last = t
+			}
+		}
+		since, asleep := h.asleep[p.ID]
+		switch {
+		case now.Sub(last) < h.cfg.Idle:
+			if asleep {
+				log.Printf("hibernate: poll %s woke up, its options matched a vote", p.ID)
+				delete(h.asleep, p.ID)
+			}
+			continue
+		case !asleep:
+			log.Printf("hibernate: poll %s hibernates, its options matched no vote for %s", p.ID, h.cfg.Idle)
+			hibernations.Inc()
+			since = now
+			h.asleep[p.ID] = since
+		}
+		if !h.waking(since, now) {
+			out[p.ID] = true
+		}
+	}
+	// forget the polls gone, made normal priority or no longer accepting votes
+	for id := range h.asleep {
+		if !seen[id] {
+			delete(h.asleep, id)
+		}
+	}
+	return out
+}
+
+// waking reports whether a poll asleep since since is awake at now to get a vote
+func (h *Hibernator) waking(since, now time.Time) bool {
+	if h.cfg.WakeEvery <= 0 {
+		return false
+	}
+	slept := now.Sub(since)
+	return slept >= h.cfg.WakeEvery && slept%h.cfg.WakeEvery < h.cfg.WakeFor
+}
+
+// Options wraps a function loading the options so every load leaves out the
+// options whose polls all hibernate. When the polls can't be loaded every
+// option is kept.
+func (h *Hibernator) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("hibernate: failed to load the polls, tracking every option:", err)
+			return options, nil
+		}
+		out := h.Update(all)
+		if len(out) == 0 {
+			return options, nil
+		}
+		// an option stays while any poll with it is awake
+		awake := make(map[string]bool)
+		for _, p := range all {
+			if out[p.ID] {
+				continue
+			}
+			for _, o := range p.Options {
+				awake[o] = true
+			}
+		}
+		asleep := make(map[string]bool)
+		for _, p := range all {
+			if out[p.ID] {
+				for _, o := range p.Options {
+					asleep[o] = !awake[o]
+				}
+			}
+		}
+		kept := options[:0:0]
+		for _, o := range options {
+			if !asleep[o] {
+				kept = append(kept, o)
+			}
+		}
+		return kept, nil
+	}
+}
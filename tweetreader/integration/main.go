@@ -0,0 +1,378 @@
+//go:build integration
+// +build integration
+
+// Command integration runs the pipeline end to end against real MongoDB and
+// nsqd containers: it creates a poll, replays a file of tweets through
+// matching and publishing, counts them with the counter and checks the votes
+// nsqd took and the results stored match the tweets, then replays the file
+// again and checks the redelivered votes aren't counted twice.
+//
+//	go run -tags integration ./integration
+//
+// It needs docker, and starts its containers on a network of their own,
+// removing them when it is done. It exits non-zero when a check fails, so it
+// can run in CI to validate refactors such as a change of MongoDB driver.
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// options are words that never contain one another, so every tweet matches exactly what it names
+var options = []string{"alpha", "bravo", "charlie"}
+
+var (
+	mongoImage = flag.String("mongo-image", "mongo:4.4", "MongoDB image to run")
+	nsqImage   = flag.String("nsq-image", "nsqio/nsq:v1.2.1", "NSQ image to run nsqd and nsqlookupd from")
+	tweets     = flag.Int("tweets", 1000, "tweets to replay")
+	timeout    = flag.Duration("timeout", 2*time.Minute, "how long the containers may take to start and the votes to be counted")
+	keep       = flag.Bool("keep", false, "leave the containers running after the run, to look into a failure")
+	verbose    = flag.Bool("v", false, "show the output of docker and the pipeline's commands")
+)
+
+func main() {
+	flag.Parse()
+	env, err := start()
+	if err == nil {
+		err = run(env)
+	}
+	if env != nil && !*keep {
+		env.remove()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "integration: FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "integration: PASS")
+}
+
+// environment is the containers and the built twitter-poll binary a run uses
+type environment struct {
+	name       string // of the docker network and the prefix of the containers
+	dir        string
+	bin        string
+	containers []string
+	network    bool
+
+	mongoPort, nsqdPort, nsqdHTTPPort, lookupdPort int
+}
+
+// start builds twitter-poll and starts MongoDB, nsqlookupd and nsqd. The
+// containers publish fixed host ports, nsqd has to broadcast the port the
+// counter reaches it on.
+func start() (*environment, error) {
+	dir, err := ioutil.TempDir("", "tweetreader-integration")
+	if err != nil {
+		return nil, err
+	}
+	env := &environment{name: fmt.Sprintf("tweetreader-it-%d", os.Getpid()), dir: dir, bin: filepath.Join(dir, "twitter-poll")}
+	logf("building twitter-poll")
+	if out, err := exec.Command("go", "build", "-o", env.bin, "github.com/olawolu/twitter-polls/tweetreader").CombinedOutput(); err != nil {
+		return env, fmt.Errorf("go build: %v\n%s", err, out)
+	}
+	for _, p := range []*int{&env.mongoPort, &env.nsqdPort, &env.nsqdHTTPPort, &env.lookupdPort} {
+		if *p, err = freePort(); err != nil {
+			return env, err
+		}
+	}
+	if _, err := docker("network", "create", env.name); err != nil {
+		return env, err
+	}
+	env.network = true
+	lookupd := env.name + "-nsqlookupd"
+	err = env.run("-p", fmt.Sprintf("127.0.0.1:%d:4161", env.lookupdPort), "--name", lookupd, *nsqImage, "/nsqlookupd")
+	if err != nil {
+		return env, err
+	}
+	err = env.run("-p", fmt.Sprintf("127.0.0.1:%d:%d", env.nsqdPort, env.nsqdPort),
+		"-p", fmt.Sprintf("127.0.0.1:%d:%d", env.nsqdHTTPPort, env.nsqdHTTPPort),
+		*nsqImage, "/nsqd",
+		fmt.Sprintf("--tcp-address=0.0.0.0:%d", env.nsqdPort),
+		fmt.Sprintf("--http-address=0.0.0.0:%d", env.nsqdHTTPPort),
+		"--broadcast-address=127.0.0.1",
+		"--lookupd-tcp-address="+lookupd+":4160")
+	if err != nil {
+		return env, err
+	}
+	if err := env.run("-p", fmt.Sprintf("127.0.0.1:%d:27017", env.mongoPort), *mongoImage); err != nil {
+		return env, err
+	}
+	logf("waiting for the containers")
+	err = waitFor(*timeout, func() error {
+		for _, port := range []int{env.nsqdHTTPPort, env.lookupdPort} {
+			resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/ping", port))
+			if err != nil {
+				return err
+			}
+			resp.Body.Close()
+		}
+		c, err := net.Dial("tcp", fmt.Sprintf("127.0.0.1:%d", env.mongoPort))
+		if err != nil {
+			return err
+		}
+		return c.Close()
+	})
+	return env, err
+}
+
+// run starts a container on the environment's network
+func (env *environment) run(args ...string) error {
+	id, err := docker(append([]string{"run", "-d", "--network", env.name}, args...)...)
+	if err != nil {
+		return err
+	}
+	env.containers = append(env.containers, id)
+	return nil
+}
+
+// remove removes the containers, the network and the binary
+func (env *environment) remove() {
+	if len(env.containers) > 0 {
+		docker(append([]string{"rm", "-f"}, env.containers...)...)
+	}
+	if env.network {
+		docker("network", "rm", env.name)
+	}
+	os.RemoveAll(env.dir)
+}
+
+// command returns the twitter-poll command args, configured for the containers
+func (env *environment) command(args ...string) *exec.Cmd {
+	cmd := exec.Command(env.bin, args...)
+	cmd.Env = append(os.Environ(),
+		"STORE=mongo",
+		fmt.Sprintf("DBHOST=127.0.0.1:%d", env.mongoPort),
+		fmt.Sprintf("NSQD_ADDR=127.0.0.1:%d", env.nsqdPort),
+		fmt.Sprintf("NSQLOOKUPD_ADDR=127.0.0.1:%d", env.lookupdPort),
+		"SPOOL_DIR="+filepath.Join(env.dir, "spool"),
+		"VOTE_CODEC=json",
+	)
+	if *verbose {
+		cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	}
+	return cmd
+}
+
+func run(env *environment) error {
+	db, err := store.Open("mongo", fmt.Sprintf("127.0.0.1:%d", env.mongoPort), store.DialOptions{})
+	if err != nil {
+		return fmt.Errorf("dialing MongoDB: %v", err)
+	}
+	defer db.Close()
+	title := "Integration " + env.name
+	if err := db.CreatePoll(&store.Poll{Title: title, Options: options, Status: store.StatusActive}); err != nil {
+		return fmt.Errorf("creating the poll: %v", err)
+	}
+
+	file := filepath.Join(env.dir, "tweets.ndjson")
+	want, err := writeTweets(file, *tweets)
+	if err != nil {
+		return err
+	}
+
+	metricsPort, err := freePort()
+	if err != nil {
+		return err
+	}
+	counter := env.command("count", "-metrics", fmt.Sprintf("127.0.0.1:%d", metricsPort), "-timeseries", "none")
+	if err := counter.Start(); err != nil {
+		return fmt.Errorf("starting the counter: %v", err)
+	}
+	defer func() {
+		counter.Process.Signal(syscall.SIGTERM)
+		counter.Wait()
+	}()
+
+	logf("replaying %d tweets", *tweets)
+	if err := env.command("replay", file).Run(); err != nil {
+		return fmt.Errorf("replay: %v", err)
+	}
+	published, err := topicMessages(env.nsqdHTTPPort, "votes")
+	if err != nil {
+		return err
+	}
+	if total := sum(want); published != total {
+		return fmt.Errorf("nsqd took %d votes, %d were replayed", published, total)
+	}
+	logf("nsqd took %d votes, waiting for them to be counted", published)
+	if err := waitForResults(db, title, want); err != nil {
+		return err
+	}
+
+	logf("replaying the tweets again, the counter must skip them")
+	if err := env.command("replay", file).Run(); err != nil {
+		return fmt.Errorf("second replay: %v", err)
+	}
+	if err := waitFor(*timeout, func() error {
+		n, err := topicMessages(env.nsqdHTTPPort, "votes")
+		if err == nil && n != 2*published {
+			err = fmt.Errorf("nsqd took %d votes, %d were replayed", n, 2*published)
+		}
+		return err
+	}); err != nil {
+		return err
+	}
+	// two flushes of the counter, so anything counted twice would show
+	time.Sleep(3 * time.Second)
+	return checkResults(db, title, want)
+}
+
+// writeTweets writes n tweets each naming one option to file, returning the votes each option gets
+func writeTweets(file string, n int) (map[string]int, error) {
+	f, err := os.Create(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	want := make(map[string]int)
+	var id int64 = 1290000000000000000
+	for i := 0; i < n; i++ {
+		option := options[i%len(options)]
+		t := stream.Tweet{ID: strconv.FormatInt(id+int64(i), 10), CreatedAt: time.Now().Format(time.RubyDate), Text: "voting for " + option}
+		t.User.ScreenName = "integration" + strconv.Itoa(i)
+		if err := enc.Encode(t); err != nil {
+			return nil, err
+		}
+		want[option]++
+	}
+	return want, w.Flush()
+}
+
+// waitForResults waits until the poll's results are want, failing early when they run ahead
+func waitForResults(db store.Backend, title string, want map[string]int) error {
+	return waitFor(*timeout, func() error {
+		p, err := pollTitled(db, title)
+		if err != nil {
+			return err
+		}
+		for _, option := range options {
+			if got := p.Results[option]; got != want[option] {
+				return fmt.Errorf("%s: counted %d votes, %d were cast", option, got, want[option])
+			}
+		}
+		return nil
+	})
+}
+
+// checkResults checks the poll's results are want
+func checkResults(db store.Backend, title string, want map[string]int) error {
+	p, err := pollTitled(db, title)
+	if err != nil {
+		return err
+	}
+	for _, option := range options {
+		if got := p.Results[option]; got != want[option] {
+			return fmt.Errorf("%s: counted %d votes, %d were cast", option, got, want[option])
+		}
+	}
+	return nil
+}
+
+func pollTitled(db store.Backend, title string) (store.Poll, error) {
+	polls, err := db.Polls()
+	if err != nil {
+		return store.Poll{}, err
+	}
+	for _, p := range polls {
+		if p.Title == title {
+			return p, nil
+		}
+	}
+	return store.Poll{}, fmt.Errorf("poll %q is gone", title)
+}
+
+// topicMessages returns how many messages nsqd took on topic, from its stats
+func topicMessages(httpPort int, topic string) (int, error) {
+	resp, err := http.Get(fmt.Sprintf("http://127.0.0.1:%d/stats?format=json&topic=%s", httpPort, topic))
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return 0, err
+	}
+	var stats struct {
+		Topics []struct {
+			Name         string `json:"topic_name"`
+			MessageCount int    `json:"message_count"`
+		} `json:"topics"`
+	}
+	if err := json.Unmarshal(b, &stats); err != nil {
+		return 0, fmt.Errorf("decoding the nsqd stats: %v", err)
+	}
+	for _, t := range stats.Topics {
+		if t.Name == topic {
+			return t.MessageCount, nil
+		}
+	}
+	return 0, nil
+}
+
+// waitFor calls check every half second until it succeeds, returning its last error after d
+func waitFor(d time.Duration, check func() error) error {
+	deadline := time.Now().Add(d)
+	for {
+		err := check()
+		if err == nil || time.Now().After(deadline) {
+			return err
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+}
+
+// docker runs the docker command, returning its trimmed output
+func docker(args ...string) (string, error) {
+	if *verbose {
+		logf("docker %s", strings.Join(args, " "))
+	}
+	out, err := exec.Command("docker", args...).Output()
+	if err != nil {
+		if ee, ok := err.(*exec.ExitError); ok {
+			return "", fmt.Errorf("docker %s: %v: %s", args[0], err, strings.TrimSpace(string(ee.Stderr)))
+		}
+		return "", fmt.Errorf("docker %s: %v", args[0], err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// freePort returns a port nothing listens on now
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+func sum(counts map[string]int) int {
+	n := 0
+	for _, c := range counts {
+		n += c
+	}
+	return n
+}
+
+func logf(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "integration: "+format+"\n", args...)
+}
@@ -0,0 +1,167 @@
+// Package lang tells the language of the short texts votes are cast with.
+//
+// Twitter labels every tweet with its language, the other sources don't, so
+// their texts are guessed at: by their script when only one language is
+// written in it, by the most common words of the language otherwise. It only
+// knows a few dozen languages and would rather say Undetermined than guess
+// wrong, a text of a few words often is.
+package lang
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Undetermined is the code of texts whose language can't be told, as Twitter has it
+const Undetermined = "und"
+
+// scripts are the writing systems one language can be told by, the first
+// match wins so Japanese kana come before the Han characters it shares with Chinese
+var scripts = []struct {
+	table *unicode.RangeTable
+	lang  string
+}{
+	{unicode.Hiragana, "ja"},
+	{unicode.Katakana, "ja"},
+	{unicode.Hangul, "ko"},
+	{unicode.Han, "zh"},
+	{unicode.Greek, "el"},
+	{unicode.Hebrew, "he"},
+	{unicode.Thai, "th"},
+	{unicode.Devanagari, "hi"},
+	{unicode.Bengali, "bn"},
+	{unicode.Tamil, "ta"},
+	{unicode.Telugu, "te"},
+	{unicode.Gujarati, "gu"},
+	{unicode.Gurmukhi, "pa"},
+	{unicode.Kannada, "kn"},
+	{unicode.Malayalam, "ml"},
+	{unicode.Georgian, "ka"},
+	{unicode.Armenian, "hy"},
+}
+
+// letters that tell languages sharing a script apart
+var (
+	ukrainian = "іїєґ"
+	persian   = "پچژگکی"
+	urdu      = "ٹڈڑںھے"
+)
+
+// words are common words of the languages written in the Latin alphabet,
+// ones the other languages here seldom use
+var words = map[string][]string{
+	"en": {"the", "and", "is", "are", "was", "this", "that", "with", "for", "you", "have", "not", "what", "my", "it's", "i'm", "be", "of", "to", "will"},
+	"es": {"el", "los", "las", "que", "y", "es", "del", "por", "con", "una", "para", "mi", "muy", "pero", "está", "como", "más", "yo", "al", "gracias"},
+	"pt": {"o", "os", "que", "não", "uma", "com", "para", "é", "do", "da", "dos", "das", "mais", "você", "muito", "isso", "está", "mas", "eu", "obrigado"},
+	"fr": {"le", "les", "et", "est", "une", "des", "pour", "pas", "je", "qui", "dans", "sur", "avec", "ce", "du", "au", "très", "mais", "c'est", "merci"},
+	"de": {"der", "die", "das", "und", "ist", "nicht", "ich", "mit", "ein", "eine", "sie", "auf", "für", "auch", "es", "zu", "den", "dem", "wir", "danke"},
+	"it": {"il", "gli", "che", "è", "di", "non", "per", "una", "sono", "con", "della", "anche", "ma", "io", "questo", "molto", "lo", "ho", "nel", "grazie"},
+	"nl": {"de", "het", "een", "en", "is", "niet", "van", "ik", "je", "dat", "op", "met", "voor", "zijn", "wat", "ook", "maar", "naar", "dit", "bedankt"},
+	"tr": {"ve", "bir", "bu", "da", "de", "için", "ile", "çok", "ne", "ben", "sen", "mi", "var", "yok", "daha", "gibi", "ama", "olarak", "değil", "teşekkürler"},
+	"id": {"yang", "dan", "di", "ini", "itu", "tidak", "aku", "saya", "ada", "dengan", "untuk", "dari", "akan", "juga", "bisa", "sudah", "kita", "apa", "ke", "terima"},
+	"sv": {"och", "att", "det", "som", "är", "jag", "inte", "på", "en", "för", "med", "har", "den", "av", "till", "om", "vi", "men", "så", "tack"},
+	"pl": {"i", "w", "nie", "na", "się", "jest", "to", "że", "z", "do", "jak", "ale", "co", "tak", "jestem", "też", "już", "dla", "czy", "dzięki"},
+}
+
+// wordLangs are the languages of each of the words
+var wordLangs = func() map[string][]string {
+	m := make(map[string][]string)
+	for l, ws := range words {
+		for _, w := range ws {
+			m[w] = append(m[w], l)
+		}
+	}
+	return m
+}()
+
+// Detect returns the ISO 639-1 code of the language of text, or Undetermined.
+// Mentions, URLs and hashtags are left out, they are names more than words.
+func Detect(text string) string {
+	var kept []string
+	for _, w := range strings.Fields(text) {
+		if strings.HasPrefix(w, "@") || strings.HasPrefix(w, "#") || strings.Contains(w, "://") {
+			continue
+		}
+		kept = append(kept, w)
+	}
+	text = strings.Join(kept, " ")
+
+	var latin, cyrillic, arabic int
+	others := make(map[string]int)
+	for _, r := range text {
+		switch {
+		case !unicode.IsLetter(r):
+		case unicode.Is(unicode.Latin, r):
+			latin++
+		case unicode.Is(unicode.Cyrillic, r):
+			cyrillic++
+		case unicode.Is(unicode.Arabic, r):
+			arabic++
+		default:
+			for _, s := range scripts {
+				if unicode.Is(s.table, r) {
+					others[s.lang]++
+					break
+				}
+			}
+		}
+	}
+	best, most := "", latin
+	if cyrillic > most {
+		best, most = "ru", cyrillic
+		if strings.ContainsAny(strings.ToLower(text), ukrainian) {
+			best = "uk"
+		}
+	}
+	if arabic > most {
+		best, most = "ar", arabic
+		switch {
+		case strings.ContainsAny(text, urdu):
+			best = "ur"
+		case strings.ContainsAny(text, persian):
+			best = "fa"
+		}
+	}
+	// kana are written along with Han characters, any of them makes the text Japanese
+	if others["ja"] > 0 && others["ja"]+others["zh"] > most {
+		return "ja"
+	}
+	for _, s := range scripts {
+		if n := others[s.lang]; n > most {
+			best, most = s.lang, n
+		}
+	}
+	if most == 0 {
+		return Undetermined
+	}
+	if best != "" {
+		return best
+	}
+	return latinLang(text)
+}
+
+// latinLang tells the language of a text in the Latin alphabet by its common
+// words, Undetermined when none has more of them than the others
+func latinLang(text string) string {
+	scores := make(map[string]int)
+	for _, w := range strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	}) {
+		for _, l := range wordLangs[strings.Trim(w, "'")] {
+			scores[l]++
+		}
+	}
+	best, most, tied := Undetermined, 0, false
+	for l, n := range scores {
+		switch {
+		case n > most:
+			best, most, tied = l, n, false
+		case n == most:
+			tied = true
+		}
+	}
+	if tied {
+		return Undetermined
+	}
+	return best
+}
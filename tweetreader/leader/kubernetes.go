@@ -0,0 +1,217 @@
+package leader
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// serviceAccount is where Kubernetes mounts a pod's credentials
+const serviceAccount = "/var/run/secrets/kubernetes.io/serviceaccount"
+
+// microTime is how the Kubernetes API writes a Lease's times
+const microTime = "2006-01-02T15:04:05.000000Z07:00"
+
+// Kubernetes keeps the leader leases as Lease objects of the Kubernetes API,
+// coordination.k8s.io/v1, so a pod elects its leader without a store. It
+// implements store.LeaderStore; an update racing another replica's fails on
+// the Lease's resourceVersion and the replica doesn't lead.
+type Kubernetes struct {
+	url       string // of the API server
+	namespace string
+	token     string // file of the bearer token
+	client    *http.Client
+}
+
+// InCluster returns the leases of the namespace, the pod's own when empty,
+// reached with the pod's service account. It fails out of a cluster.
+func InCluster(namespace string) (*Kubernetes, error) {
+	host, port := os.Getenv("KUBERNETES_SERVICE_HOST"), os.Getenv("KUBERNETES_SERVICE_PORT")
+	if host == "" || port == "" {
+		return nil, errors.New("leader: not running in a Kubernetes cluster, KUBERNETES_SERVICE_HOST and _PORT are unset")
+	}
+	ca, err := ioutil.ReadFile(serviceAccount + "/ca.crt")
+	if err != nil {
+		return nil, fmt.Errorf("leader: failed to read the cluster's CA: %v", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(ca) {
+		return nil, fmt.Errorf("leader: no certificate in %s/ca.crt", serviceAccount)
+	}
+	if namespace == "" {
+		b, err := ioutil.ReadFile(serviceAccount + "/namespace")
+		if err != nil {
+			return nil, fmt.Errorf("leader: failed to read the pod's namespace: %v", err)
+		}
+		namespace = strings.TrimSpace(string(b))
+	}
+	return &Kubernetes{
+		url:       "https://" + net.JoinHostPort(host, port),
+		namespace: namespace,
+		token:     serviceAccount + "/token",
+		client: &http.Client{
+			Timeout:   10 * time.Second,
+			Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}},
+		},
+	}, nil
+}
+
+// lease is the part of a Lease object the election reads and writes
+type lease struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Metadata   struct {
+		Name            string `json:"name"`
+		Namespace       string `json:"namespace,omitempty"`
+		ResourceVersion string `json:"resourceVersion,omitempty"`
+	} `json:"metadata"`
+	Spec struct {
+		HolderIdentity       string `json:"holderIdentity"`
+		LeaseDurationSeconds int    `json:"leaseDurationSeconds"`
+		AcquireTime          string `json:"acquireTime,omitempty"`
+		RenewTime            string `json:"renewTime,omitempty"`
+		LeaseTransitions     int    `json:"leaseTransitions"`
+	} `json:"spec"`
+}
+
+// expires returns when the lease runs out, zero when nobody holds it
+func (l *lease) expires() time.Time {
+	if l.Spec.HolderIdentity == "" {
+		return time.Time{}
+	}
+	renewed, err := time.Parse(time.RFC3339Nano, l.Spec.RenewTime)
+	if err != nil {
+		return time.Time{}
+	}
+	return renewed.Add(time.Duration(l.Spec.LeaseDurationSeconds) * time.Second)
+}
+
+// errConflict is the API refusing a write another replica's got ahead of
+var errConflict = errors.New("leader: conflicting lease update")
+
+// AcquireLeadership takes or renews the Lease called name for holder
+func (k *Kubernetes) AcquireLeadership(name, holder string, now, expires time.Time) (bool, error) {
+	l, err := k.get(name)
+	if err != nil {
+		return false, err
+	}
+	created := l == nil
+	if created {
+		l = &lease{APIVersion: "coordination.k8s.io/v1", Kind: "Lease"}
+		l.Metadata.Name, l.Metadata.Namespace = name, k.namespace
+	} else if l.Spec.HolderIdentity != holder && l.expires().After(now) {
+		return false, nil
+	}
+	if l.Spec.HolderIdentity != holder {
+		if !created {
+			l.Spec.LeaseTransitions++
+		}
+		l.Spec.HolderIdentity = holder
+		l.Spec.AcquireTime = now.UTC().Format(microTime)
+	}
+	l.Spec.RenewTime = now.UTC().Format(microTime)
+	l.Spec.LeaseDurationSeconds = int(math.Ceil(expires.Sub(now).Seconds()))
+	switch err := k.put(l, created); err {
+	case nil:
+		return true, nil
+	case errConflict:
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// ReleaseLeadership empties the holder of the Lease called name, if it is holder
+func (k *Kubernetes) ReleaseLeadership(name, holder string) error {
+	l, err := k.get(name)
+	if err != nil || l == nil || l.Spec.HolderIdentity != holder {
+		return err
+	}
+	l.Spec.HolderIdentity = ""
+	if err := k.put(l, false); err != errConflict {
+		return err
+	}
+	return nil
+}
+
+func (k *Kubernetes) leases() string {
+	return fmt.Sprintf("%s/apis/coordination.k8s.io/v1/namespaces/%s/leases", k.url, k.namespace)
+}
+
+// get returns the Lease called name, nil when there is none
+func (k *Kubernetes) get(name string) (*lease, error) {
+	resp, err := k.do("GET", k.leases()+"/"+name, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var l lease
+		if err := json.NewDecoder(resp.Body).Decode(&l); err != nil {
+			return nil, fmt.Errorf("leader: unreadable lease %s: %v", name, err)
+		}
+		return &l, nil
+	case http.StatusNotFound:
+		return nil, nil
+	}
+	return nil, apiError(resp)
+}
+
+// put creates or replaces l, failing with errConflict when it changed since it was read
+func (k *Kubernetes) put(l *lease, create bool) error {
+	b, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	method, url := "PUT", k.leases()+"/"+l.Metadata.Name
+	if create {
+		method, url = "POST", k.leases()
+	}
+	resp, err := k.do(method, url, b)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusCreated:
+		return nil
+	case http.StatusConflict:
+		return errConflict
+	}
+	return apiError(resp)
+}
+
+// do sends a request with the service account's token, read every time as
+// Kubernetes rotates it
+func (k *Kubernetes) do(method, url string, body []byte) (*http.Response, error) {
+	token, err := ioutil.ReadFile(k.token)
+	if err != nil {
+		return nil, fmt.Errorf("leader: failed to read the service account token: %v", err)
+	}
+	req, err := http.NewRequest(method, url, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	req.Header.Set("Accept", "application/json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	return k.client.Do(req)
+}
+
+func apiError(resp *http.Response) error {
+	b, _ := ioutil.ReadAll(resp.Body)
+	return fmt.Errorf("leader: kubernetes API answered %s: %s", resp.Status, strings.TrimSpace(string(b)))
+}
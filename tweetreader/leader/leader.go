@@ -0,0 +1,139 @@
+// Package leader elects one of several replicas to do what only one may, such
+// as holding the single stream Twitter allows per set of credentials.
+//
+// The leader holds a lease in the store and renews it every third of its
+// ttl. The standbys try to take the lease as often, so when the leader stops
+// they take over within a third of the ttl, and when it dies within the ttl.
+// A leader that can't renew steps down before its lease runs out, so two
+// replicas never both believe they lead.
+package leader
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var (
+	leading = metrics.NewGauge("tweetreader_leader",
+		"1 while this replica is the leader, 0 while it is a standby.")
+	transitions = metrics.NewCounter("tweetreader_leader_transitions_total",
+		"Times this replica became the leader or stepped down.")
+)
+
+// Elector campaigns for the lease called name on behalf of id
+type Elector struct {
+	leaders store.LeaderStore
+	name    string
+	id      string
+	ttl     time.Duration
+
+	elected chan struct{}
+	lost    chan struct{}
+	stop    chan struct{}
+	done    chan struct{}
+
+	mu    sync.Mutex
+	until time.Time // when the lease last acquired runs out, zero while a standby
+}
+
+// New creates an Elector for the lease name held by id for ttl at a time
+func New(leaders store.LeaderStore, name, id string, ttl time.Duration) *Elector {
+	leading.Set(0)
+	return &Elector{
+		leaders: leaders,
+		name:    name,
+		id:      id,
+		ttl:     ttl,
+		elected: make(chan struct{}),
+		lost:    make(chan struct{}),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+}
+
+// Start campaigns until Stop, or until the leadership is lost once held
+func (e *Elector) Start() {
+	go func() {
+		defer close(e.done)
+		tick := time.NewTicker(e.ttl / 3)
+		defer tick.Stop()
+		for {
+			if !e.campaign() {
+				return
+			}
+			select {
+			case <-e.stop:
+				return
+			case <-tick.C:
+			}
+		}
+	}()
+}
+
+// campaign tries to acquire or renew the lease, returning false once the leadership is lost
+func (e *Elector) campaign() bool {
+	now := time.Now()
+	ok, err := e.leaders.AcquireLeadership(e.name, e.id, now, now.Add(e.ttl))
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	wasLeader := !e.until.IsZero()
+	switch {
+	case err == nil && ok:
+		e.until = now.Add(e.ttl)
+		if !wasLeader {
+			log.Printf("leader: %s is now the %s leader", e.id, e.name)
+			leading.Set(1)
+			transitions.Inc()
+			close(e.elected)
+		}
+		return true
+	case !wasLeader:
+		if err != nil {
+			log.Println("leader: failed to campaign:", err)
+		}
+		return true
+	case err == nil:
+		log.Printf("leader: %s lost the %s lease to another replica", e.id, e.name)
+	case time.Until(e.until) > e.ttl/3:
+		// another try before the lease runs out
+		log.Println("leader: failed to renew the lease:", err)
+		return true
+	default:
+		log.Printf("leader: %s stepping down, the %s lease runs out before it can be renewed: %v", e.id, e.name, err)
+	}
+	e.until = time.Time{}
+	leading.Set(0)
+	transitions.Inc()
+	close(e.lost)
+	return false
+}
+
+// Elected is closed once this replica leads
+func (e *Elector) Elected() <-chan struct{} {
+	return e.elected
+}
+
+// Lost is closed when this replica stops leading, it doesn't campaign again
+func (e *Elector) Lost() <-chan struct{} {
+	return e.lost
+}
+
+// Stop stops campaigning and releases the lease if it is held, so a standby takes over right away
+func (e *Elector) Stop() {
+	close(e.stop)
+	<-e.done
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if e.until.IsZero() {
+		return
+	}
+	if err := e.leaders.ReleaseLeadership(e.name, e.id); err != nil {
+		log.Println("leader: failed to release the lease, a standby takes over once it expires:", err)
+	}
+	e.until = time.Time{}
+	leading.Set(0)
+}
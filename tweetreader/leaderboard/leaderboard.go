@@ -0,0 +1,320 @@
+// Package leaderboard keeps every poll's results in a Redis sorted set, an
+// option per member scored by its votes, for the REST API to serve the top
+// options of busy polls without asking MongoDB.
+//
+// The counter adds what each flush wrote to the results to the options
+// already in a set, and every so often rebuilds the sets from the stored
+// results, so a set Redis lost or another counter's flush racing a rebuild is
+// set right. Until a poll's set is built there is none, rather than one
+// holding only the latest votes. A set expires once its poll wasn't rebuilt
+// for TTL, so the polls removed from the store don't linger.
+//
+// Only the commands a leaderboard needs are spoken, pipelined over one
+// connection.
+package leaderboard
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+)
+
+// ErrUnavailable is returned while Redis keeps failing and isn't asked
+var ErrUnavailable = errors.New("leaderboard: redis unavailable")
+
+// Config describes the Redis the leaderboards are kept in
+type Config struct {
+	// URL is redis://[user:password@]host[:port][/db], the port 6379 by default
+	URL string
+	// Prefix starts the keys, a poll's set is the prefix and its ID;
+	// tweetreader:leaderboard: by default
+	Prefix string
+	// TTL is how long a set lasts without being rebuilt, 1h by default
+	TTL time.Duration
+	// Timeout bounds connecting and every pipeline, 1s by default
+	Timeout time.Duration
+}
+
+// Board is the polls' leaderboards, kept in Redis
+type Board struct {
+	addr, user, password string
+	db                   int
+	prefix               string
+	ttl                  time.Duration
+	timeout              time.Duration
+	breaker              *breaker.Breaker
+
+	mu   sync.Mutex
+	conn net.Conn // nil until dialled, and after a failure
+	r    *bufio.Reader
+}
+
+// Open creates the leaderboards kept in the Redis of cfg, connecting when first used
+func Open(cfg Config) (*Board, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "redis" || u.Hostname() == "" {
+		return nil, fmt.Errorf("leaderboard: invalid redis URL %q, want redis://host:port/db", cfg.URL)
+	}
+	b := &Board{addr: u.Host, prefix: cfg.Prefix, ttl: cfg.TTL, timeout: cfg.Timeout}
+	if u.Port() == "" {
+		b.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		b.user = u.User.Username()
+		b.password, _ = u.User.Password()
+	}
+	if db := strings.Trim(u.Path, "/"); db != "" {
+		if b.db, err = strconv.Atoi(db); err != nil {
+			return nil, fmt.Errorf("leaderboard: invalid redis database %q", db)
+		}
+	}
+	if b.prefix == "" {
+		b.prefix = "tweetreader:leaderboard:"
+	}
+	if b.ttl <= 0 {
+		b.ttl = time.Hour
+	}
+	if b.timeout <= 0 {
+		b.timeout = time.Second
+	}
+	b.breaker = breaker.New("leaderboard", breaker.Config{Threshold: 3, Cooldown: 5 * time.Second, MaxCooldown: time.Minute})
+	return b, nil
+}
+
+// Add adds the votes of deltas, by poll and option, to the polls' sets. The
+// options a set doesn't have are left out, and so are the polls without one.
+func (b *Board) Add(deltas map[string]map[string]int) error {
+	var cmds [][]string
+	for poll, options := range deltas {
+		for option, n := range options {
+			if n != 0 {
+				cmds = append(cmds, []string{"ZADD", b.prefix + poll, "XX", "INCR", strconv.Itoa(n), option})
+			}
+		}
+	}
+	if len(cmds) == 0 {
+		return nil
+	}
+	return b.run(cmds)
+}
+
+// Set replaces the set of poll with its options scored by results, at
+// once, and has it last another TTL
+func (b *Board) Set(poll string, options []string, results map[string]int) error {
+	key := b.prefix + poll
+	cmds := [][]string{{"MULTI"}, {"DEL", key}}
+	if len(options) > 0 {
+		zadd := []string{"ZADD", key}
+		for _, o := range options {
+			zadd = append(zadd, strconv.Itoa(results[o]), o)
+		}
+		cmds = append(cmds, zadd, []string{"PEXPIRE", key, strconv.FormatInt(b.ttl.Milliseconds(), 10)})
+	}
+	return b.run(append(cmds, []string{"EXEC"}))
+}
+
+// Remove drops the set of poll
+func (b *Board) Remove(poll string) error {
+	return b.run([][]string{{"DEL", b.prefix + poll}})
+}
+
+// Close closes the connection
+func (b *Board) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		return nil
+	}
+	err := b.conn.Close()
+	b.conn = nil
+	return err
+}
+
+// run sends cmds in one pipeline through the breaker, failing with the
+// first error reply
+func (b *Board) run(cmds [][]string) error {
+	if !b.breaker.Allow() {
+		return ErrUnavailable
+	}
+	replies, err := b.pipeline(cmds)
+	if err != nil {
+		b.breaker.Failure(err)
+		return err
+	}
+	b.breaker.Success()
+	for _, reply := range replies {
+		if err := replyError(reply); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// replyError returns the error reply in reply, which the replies of EXEC hold
+func replyError(reply interface{}) error {
+	switch r := reply.(type) {
+	case redisError:
+		return r
+	case []interface{}:
+		for _, v := range r {
+			if err := replyError(v); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// pipeline writes cmds at once and reads their replies; a failure closes the
+// connection, so the next pipeline dials again
+func (b *Board) pipeline(cmds [][]string) ([]interface{}, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.conn == nil {
+		if err := b.dial(); err != nil {
+			return nil, err
+		}
+	}
+	replies, err := b.send(cmds)
+	if err != nil {
+		b.conn.Close()
+		b.conn = nil
+	}
+	return replies, err
+}
+
+// dial connects, authenticates and selects the database; mu must be held
+func (b *Board) dial() error {
+	conn, err := net.DialTimeout("tcp", b.addr, b.timeout)
+	if err != nil {
+		return err
+	}
+	b.conn, b.r = conn, bufio.NewReader(conn)
+	var setup [][]string
+	if b.password != "" {
+		if b.user != "" {
+			setup = append(setup, []string{"AUTH", b.user, b.password})
+		} else {
+			setup = append(setup, []string{"AUTH", b.password})
+		}
+	}
+	if b.db != 0 {
+		setup = append(setup, []string{"SELECT", strconv.Itoa(b.db)})
+	}
+	if len(setup) == 0 {
+		return nil
+	}
+	replies, err := b.send(setup)
+	if err == nil {
+		for _, reply := range replies {
+			if err = replyError(reply); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		conn.Close()
+		b.conn = nil
+	}
+	return err
+}
+
+// redisError is an error reply, the connection is still fine after one,
+// and the pipeline goes on
+type redisError string
+
+func (e redisError) Error() string { return "redis: " + string(e) }
+
+// send writes every command as an array of bulk strings, then reads a reply
+// for each; the error replies are among the replies
+func (b *Board) send(cmds [][]string) ([]interface{}, error) {
+	if err := b.conn.SetDeadline(time.Now().Add(b.timeout)); err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, 64*len(cmds))
+	for _, args := range cmds {
+		buf = append(buf, '*')
+		buf = strconv.AppendInt(buf, int64(len(args)), 10)
+		buf = append(buf, "\r\n"...)
+		for _, a := range args {
+			buf = append(buf, '$')
+			buf = strconv.AppendInt(buf, int64(len(a)), 10)
+			buf = append(buf, "\r\n"...)
+			buf = append(buf, a...)
+			buf = append(buf, "\r\n"...)
+		}
+	}
+	if _, err := b.conn.Write(buf); err != nil {
+		return nil, err
+	}
+	replies := make([]interface{}, len(cmds))
+	for i := range cmds {
+		reply, err := b.reply()
+		if err != nil {
+			return nil, err
+		}
+		replies[i] = reply
+	}
+	return replies, nil
+}
+
+// reply reads a reply: a string, nil, a redisError or an array of replies
+func (b *Board) reply() (interface{}, error) {
+	line, err := b.r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimSuffix(line, "\r\n")
+	if line == "" {
+		return nil, errors.New("redis: empty reply")
+	}
+	switch kind, rest := line[0], line[1:]; kind {
+	case '+', ':', ',':
+		return rest, nil
+	case '-':
+		return redisError(rest), nil
+	case '_':
+		return nil, nil
+	case '$':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		data := make([]byte, n+2)
+		if _, err := io.ReadFull(b.r, data); err != nil {
+			return nil, err
+		}
+		return string(data[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(rest)
+		if err != nil {
+			return nil, fmt.Errorf("redis: bad reply %q", line)
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		list := make([]interface{}, n)
+		for i := range list {
+			if list[i], err = b.reply(); err != nil {
+				return nil, err
+			}
+		}
+		return list, nil
+	}
+	return nil, fmt.Errorf("redis: unexpected reply %q", line)
+}
@@ -1,120 +1,107 @@
 package main
 
 import (
-	"encoding/json"
+	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"sync"
-	"syscall"
-	"time"
-
-	"github.com/nsqio/go-nsq"
-
-	"gopkg.in/mgo.v2"
-)
-
-var (
-	dbHost = os.Getenv("DBHOST")
-	db     *mgo.Session
+	"strings"
 )
 
-// poll contains the options for a poll object
-type poll struct {
-	Options []string
+// command is a twitter-poll subcommand.
+// Each command parses its own flags from args; configuration that is shared
+// between commands (credentials, addresses) still comes from the environment.
+type command struct {
+	name    string
+	usage   string
+	summary string
+	run     func(args []string) error
 }
 
-// connect to the database
-func dialdb() error {
-	var err error
-	log.Printf("dialing mongodb: %s", dbHost)
-	db, err = mgo.Dial(dbHost)
-	return err
-}
-
-// disconenct from the database
-func closedb() {
-	db.Close()
-	log.Println("closed database connection")
-}
+var commands []*command
 
-// loadOptions
-func loadOptions() ([]string, error) {
-	var options []string
-	var p poll
+// optionalCommands are registered by files behind build tags, e.g. grpc
+var optionalCommands []*command
 
-	// query the polls collection in ballots without filter *Find(nil)*
-	// and return an iterator capable of going over the returned polls.
-	iter := db.DB("ballots").C("polls").Find(nil).Iter()
-	// loop over the results and load the options into the options slice
-	for iter.Next(&p) {
-		options = append(options, p.Options...)
+func init() {
+	commands = []*command{
+		{name: "stream", usage: "stream", summary: "read votes from the Twitter stream and publish them (default)", run: runStream},
+		{name: "count", usage: "count", summary: "consume votes from NSQ and tally them into the polls", run: runCount},
+		{name: "backfill", usage: "backfill [-since-id id]", summary: "catch up on missed votes with the search API", run: runBackfill},
+		{name: "replay", usage: "replay [-topic votes] file...", summary: "replay tweets from NDJSON files through matching and publishing", run: runReplay},
+		{name: "local", usage: "local [-db file] file...", summary: "replay and count NDJSON tweets into a SQLite file, with no other services", run: runLocal},
+		{name: "bench", usage: "bench [-rate 50000] [-steps 10]", summary: "find the highest vote rate the pipeline sustains in one process", run: runBench},
+		{name: "top", usage: "top [-addr http://localhost:8082] [-every 2s]", summary: "watch the vote rates, stream health and errors of a running streamer", run: runTop},
+		{name: "polls", usage: "polls list|create|status|delete", summary: "manage poll documents", run: runPolls},
+		{name: "review", usage: "review [-addr :8084]", summary: "keep the votes held for moderation and serve the API approving or rejecting them", run: runReview},
+		{name: "retention", usage: "retention [-tweets 720h] [-archive-closed-after 720h] [-every 24h]", summary: "delete old votes and history, and archive and compact the polls that are over", run: runRetention},
+		{name: "relay", usage: "relay [-batch 100] [-every 1s]", summary: "publish the votes streamers running with -outbox added to the outbox", run: runRelay},
+		{name: "rules", usage: "rules [-dry-run] [-every 1m]", summary: "repair the drift of the v2 filtered stream rules from the poll options", run: runRules},
+		{name: "export", usage: "export -poll id [-format csv|json|parquet] [-votes file -archive url]", summary: "export a poll's tallies and votes for analysis", run: runExport},
+		{name: "warehouse", usage: "warehouse -sink bigquery://project/dataset|url [-every 1h]", summary: "load the votes and the polls' results into BigQuery or CSV files for a warehouse", run: runWarehouse},
+		{name: "summaries", usage: "summaries [-account name] [-template text] [-polls id,...] [-every 5m] [-dry-run]", summary: "tweet the standings of the running polls every so often", run: runSummaries},
+		{name: "certificate", usage: "certificate -poll id | -verify file [-key public.pem]", summary: "write or check the signed certificate of a closed poll's results", run: runCertificate},
+		{name: "recount", usage: "recount -poll id[,id...] [-dry-run]", summary: "fold a poll's logged votes into its results again, after a fix to counting", run: runRecount},
+		{name: "rescore", usage: "rescore -poll id -archive url [-from time] [-to time] [-dry-run]", summary: "match a poll's archived tweets again after its options were edited and correct its results", run: runRescore},
+		{name: "check", usage: "check [-skip twitter,store,nsq] [-timeout 10s]", summary: "verify the configuration, Twitter credentials, store and NSQ, failing when any is broken", run: runCheck},
+		{name: "help", usage: "help", summary: "show this help", run: runHelp},
 	}
-	iter.Close()
-	return options, iter.Err()
 }
 
-// publsihvotes takes in a votes channel which is a recieve
-func publishVotes(votes <-chan tweet) <-chan struct{} {
-	stopchan := make(chan struct{}, 1)
-	pub, err := nsq.NewProducer("localhost:4150", nsq.NewConfig())
-	if err != nil {
-		log.Println(err)
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: twitter-poll <command> [flags]")
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, "commands:")
+	for _, c := range commands {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", c.name, c.summary)
 	}
-	go func() {
-		for vote := range votes {
-			log.Println(vote)
-			b, err := json.Marshal(vote)
-			if err != nil {
-				log.Println("Marshall error: ", err)
+	fmt.Fprintln(os.Stderr)
+	fmt.Fprintln(os.Stderr, `run "twitter-poll <command> -h" for the flags of a command`)
+}
+
+func runHelp(args []string) error {
+	usage()
+	return nil
+}
+
+// newFlagSet creates the flag set for a command with a usage line matching the help output
+func newFlagSet(c string) *flag.FlagSet {
+	fs := flag.NewFlagSet(c, flag.ExitOnError)
+	fs.Usage = func() {
+		for _, cmd := range commands {
+			if cmd.name == c {
+				fmt.Fprintf(os.Stderr, "usage: twitter-poll %s\n\n%s\n\n", cmd.usage, cmd.summary)
 			}
-			pub.Publish("votes", b) // publish votes
 		}
-		log.Println("Publisher: Stopping")
-		pub.Stop()
-		log.Println("Publisher: Stopped")
-		stopchan <- struct{}{}
-	}()
-	return stopchan
+		fs.PrintDefaults()
+	}
+	return fs
 }
 
 func main() {
-	var stoplock sync.Mutex // protects stop
-	stop := false
-	stopChan := make(chan struct{}, 1)
-	signalChan := make(chan os.Signal, 1)
-	go func() {
-		<-signalChan
-		stoplock.Lock()
-		stop = true
-		stoplock.Unlock()
-		log.Println("Stopping...")
-		stopChan <- struct{}{}
-		closeConn()
-	}()
-	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
-	if err := dialdb(); err != nil {
-		log.Fatalln("failed to dial MongoDB:", err)
+	loadConfig()
+	commands = append(commands, optionalCommands...)
+	args := os.Args[1:]
+	// with no command (or only flags) keep behaving like the old single-purpose tweetreader
+	name := "stream"
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
 	}
-	defer closedb()
-
-	// start things
-	votes := make(chan tweet) // channel for votes
-	publisherStoppedChan := publishVotes(votes)
-	twitterStoppedChan := startTwitterStream(stopChan, votes)
-	go func() {
-		for {
-			time.Sleep(1 * time.Minute)
-			closeConn()
-			stoplock.Lock()
-			if stop {
-				stoplock.Unlock()
-				return
+	for _, c := range commands {
+		if c.name == name {
+			if err := loadSecrets(); err != nil {
+				log.Fatalln("failed to load secrets:", err)
+			}
+			if err := registerCodecs(); err != nil {
+				log.Fatalln(err)
 			}
-			stoplock.Unlock()
+			if err := c.run(args); err != nil {
+				log.Fatalln(name+":", err)
+			}
+			return
 		}
-	}()
-	<-twitterStoppedChan
-	close(votes)
-	<-publisherStoppedChan
+	}
+	fmt.Fprintf(os.Stderr, "unknown command %q\n\n", name)
+	usage()
+	os.Exit(2)
 }
@@ -0,0 +1,31 @@
+package match
+
+import "github.com/olawolu/twitter-polls/tweetreader/stream"
+
+// Geo is where a vote came from, flattened from the tweet's coordinates and place
+type Geo struct {
+	Longitude float64 `json:"longitude"`
+	Latitude  float64 `json:"latitude"`
+	// Exact is true when the tweet had coordinates, false for the centre of its place
+	Exact       bool   `json:"exact,omitempty"`
+	PlaceID     string `json:"place_id,omitempty"`
+	Place       string `json:"place,omitempty"`
+	CountryCode string `json:"country_code,omitempty"`
+	Country     string `json:"country,omitempty"`
+	Region      string `json:"region,omitempty"`
+}
+
+// geoOf returns the location of t, or nil when the tweet has none
+func geoOf(t *stream.Tweet) *Geo {
+	longitude, latitude, ok := t.Location()
+	if !ok {
+		return nil
+	}
+	g := &Geo{Longitude: longitude, Latitude: latitude, Exact: t.Coordinates != nil}
+	if p := t.Place; p != nil {
+		g.PlaceID, g.Place = p.ID, p.FullName
+		g.CountryCode, g.Country = p.CountryCode, p.Country
+		g.Region = p.Region()
+	}
+	return g
+}
@@ -0,0 +1,26 @@
+package match
+
+import (
+	"strings"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// hashtagKey is how an option and a hashtag are compared:
+// without the #, spaces or case, so "#OptionA" is a hashtag for "Option A"
+func hashtagKey(s string) string {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "#")
+	return strings.ToLower(strings.Join(strings.Fields(s), ""))
+}
+
+// hashtags returns the keys of the hashtags Twitter found in t
+func hashtags(t *stream.Tweet) map[string]bool {
+	if t.Entities == nil || len(t.Entities.Hashtags) == 0 {
+		return nil
+	}
+	tags := make(map[string]bool, len(t.Entities.Hashtags))
+	for _, h := range t.Entities.Hashtags {
+		tags[hashtagKey(h.Text)] = true
+	}
+	return tags
+}
@@ -0,0 +1,72 @@
+package match
+
+import (
+	"unicode/utf8"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// snippetContext is how many characters of text a snippet keeps on each side of the term
+const snippetContext = 40
+
+// Hit is where the option of a vote is in its tweet, so moderation tools and
+// dashboards can show why the tweet counted without keeping it. Offsets count
+// characters (code points), like the indices of Twitter's entities, in the
+// text the option was found in: the tweet's, or the retweeted or quoted one's when the vote is Embedded.
+type Hit struct {
+	Term  string `json:"term"` // the option as the tweet writes it
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	// Snippet is the text around the term, starting at SnippetStart
+	Snippet      string `json:"snippet"`
+	SnippetStart int    `json:"snippet_start"`
+}
+
+// hitIn returns where option is in text: the option itself, a phrase's words,
+// its hashtag or, for a handle, the mention. It is nil when Twitter's entities
+// or the account replied to are the only place the tweet has it.
+func (m *Matcher) hitIn(option, text string) *Hit {
+	folded, offsets := textnorm.FoldOffsets(text)
+	term := textnorm.Fold(option)
+	start, end := -1, -1
+	if stream.IsHandle(option) {
+		if i := textnorm.IndexWord(folded, term); i >= 0 {
+			start, end = i, i+len(term)
+		}
+	} else if p := textnorm.NewPhrase(term, m.phraseGap); p != nil {
+		start, end = p.Span(folded)
+	} else if i := textnorm.Index(folded, term); i >= 0 {
+		start, end = i, i+len(term)
+	}
+	if start < 0 {
+		if tag := "#" + hashtagKey(option); len(tag) > 1 {
+			if i := textnorm.Index(folded, tag); i >= 0 {
+				start, end = i, i+len(tag)
+			}
+		}
+	}
+	if start < 0 {
+		return nil
+	}
+	return newHit(text, offsets[start], offsets[end])
+}
+
+// newHit is the Hit of text[start:end]
+func newHit(text string, start, end int) *Hit {
+	from := start
+	for n := 0; n < snippetContext && from > 0; n++ {
+		_, size := utf8.DecodeLastRuneInString(text[:from])
+		from -= size
+	}
+	to := end
+	for n := 0; n < snippetContext && to < len(text); n++ {
+		_, size := utf8.DecodeRuneInString(text[to:])
+		to += size
+	}
+	h := &Hit{Term: text[start:end], Snippet: text[from:to]}
+	h.SnippetStart = utf8.RuneCountInString(text[:from])
+	h.Start = h.SnippetStart + utf8.RuneCountInString(text[from:start])
+	h.End = h.Start + utf8.RuneCountInString(h.Term)
+	return h
+}
@@ -0,0 +1,540 @@
+// Package match turns tweets into votes for the poll options they mention.
+package match
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/lang"
+	"github.com/olawolu/twitter-polls/tweetreader/stem"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// Vote is published once for every option a tweet mentions.
+// The tweet fields are kept at the top level of the message,
+// so consumers that only care about the tweet can keep decoding it as before.
+// The raw coordinates and place are replaced by Geo, and the entities by Hashtag.
+// Text is the whole text of the tweet, and the tweets it retweets or quotes are left out.
+type Vote struct {
+	stream.Tweet
+	Option string  `json:"option"`
+	Weight float64 `json:"weight"`
+	Geo    *Geo    `json:"geo,omitempty"`
+	// Hashtag is true when the tweet has the option as a hashtag, not just in its text
+	Hashtag bool `json:"hashtag,omitempty"`
+	// MessageID is the same every time this vote is published, so consumers can drop redeliveries
+	MessageID string `json:"message_id,omitempty"`
+	// Suspect is true when the vote was cast during a spike of votes for its option
+	Suspect bool `json:"suspect,omitempty"`
+	// Scale is how many votes this one stands for, when its poll is sampled
+	// 1 in Scale; 0 means 1
+	Scale int `json:"scale,omitempty"`
+	// Retweet, Quote and Reply tell what kind of tweet cast the vote, a quote can also be a reply
+	Retweet bool `json:"retweet,omitempty"`
+	Quote   bool `json:"quote,omitempty"`
+	Reply   bool `json:"reply,omitempty"`
+	// Embedded is true when the option is only in the retweeted or quoted tweet
+	Embedded bool `json:"embedded,omitempty"`
+	// CaseFolded is true when the tweet only has the option in another case,
+	// and Partial when only inside longer words, for the polls matching strictly
+	CaseFolded bool `json:"case_folded,omitempty"`
+	Partial    bool `json:"partial,omitempty"`
+	// Folded is set when the tweet only has the option without its diacritics,
+	// FoldDiacritics, or in another alphabet, FoldTransliterate, for the polls folding text
+	Folded string `json:"folded,omitempty"`
+	// Stemmed is set when the tweet has the option in another form of its
+	// words, stem.Plural or stem.Full, for the options matched fuzzily: only
+	// that way when Partial isn't set, else also inside longer words
+	Stemmed string `json:"stemmed,omitempty"`
+	// Hit is the term that made the tweet a vote, where it is and the text around it
+	Hit *Hit `json:"hit,omitempty"`
+	// Tenants are the tenants whose tracked polls have the option, for polls
+	// read from the tenants' own collections
+	Tenants []string `json:"tenants,omitempty"`
+	// Source is where the vote was cast, one of the stream.Source constants;
+	// votes from streamers that predate it are from stream.SourceTwitter
+	Source string `json:"source,omitempty"`
+	// Count is how many votes this one adds up when the streamer aggregates
+	// the votes for an option over Window seconds before publishing them, each
+	// as many as its Scale; 0 for the vote of a single tweet. An aggregated
+	// vote carries no tweet, only the newest CreatedAt of the votes in it.
+	Count  int `json:"count,omitempty"`
+	Window int `json:"window,omitempty"`
+	// Instance is the streamer that matched the vote. Streamers running side
+	// by side for availability match the same tweets, their votes having the
+	// same MessageID; counters sharing a dedup store count one of them.
+	Instance string `json:"instance,omitempty"`
+	// Filtered are the tracked polls having the option whose filter
+	// expression, or route when another tracked poll has the option too, the
+	// tweet failed, the vote isn't counted for them
+	Filtered []string `json:"filtered,omitempty"`
+	// Versions are the versions of the options of the tracked polls having
+	// the option the vote was matched against, see VersionTag
+	Versions []string `json:"versions,omitempty"`
+	// Options is how many options the tweet voted for, the streamer uses it
+	// to hold back contested votes and it isn't part of the vote message
+	Options int `json:"-"`
+}
+
+// MessageID is the idempotency key of the vote a tweet casts for option
+func MessageID(tweetID, option string) string {
+	return tweetID + "/" + option
+}
+
+// Matcher finds the options mentioned in a tweet.
+// The options are swapped out with Update every time the stream reconnects.
+type Matcher struct {
+	weigh     WeightFunc
+	embedded  bool // also match the text of retweeted and quoted tweets
+	phraseGap int  // words allowed between the words of a phrase
+	normalize NormalizeFunc
+	shadow    *Matcher // matches every tweet too, see Shadow
+	compare   func(t stream.Tweet, votes, shadowed []Vote)
+
+	mu         sync.RWMutex
+	options    []string
+	folded     *textnorm.Set     // options folded with textnorm.Fold, by index
+	phrases    []phrase          // options of several words, which the set skips
+	tagged     map[string][]int  // indexes of the options by hashtagKey
+	handles    map[string][]int  // indexes of the handle options by stream.HandleKey
+	embeddedOf map[string]bool   // options matched in retweeted and quoted tweets even without embedded
+	foldOf     map[string]string // options also matched folded, see FoldFor
+	folds      []foldSet
+	stemOf     map[string]Stemming // options also matched stemmed, see StemFor
+	stems      []stemSet           // the plural ones first
+}
+
+// Folding modes, the mode of the polls whose options are found when the text reads
+// the same once folded, the store.Poll Folding constants
+const (
+	FoldDiacritics    = "diacritics"
+	FoldTransliterate = "transliterate"
+)
+
+// foldSet finds the options of some polls in the text folded as mode, see FoldFor
+type foldSet struct {
+	mode    string
+	fold    func(string) string
+	set     *textnorm.Set
+	phrases []phrase
+}
+
+// Stemming is how an option is also found in the other forms of its words
+type Stemming struct {
+	Level    string // stem.Plural or stem.Full
+	Language string // of the stemmer, see stem.For
+}
+
+// stemSet finds the options stemmed at level in language in the stemmed words of the text, see StemFor
+type stemSet struct {
+	Stemming
+	stem    stem.Func
+	words   map[string][]int // the indexes of the options of one word, by stem
+	phrases []phrase         // the options of several, their words stemmed
+}
+
+// NewMatcher creates a Matcher weighing votes with weigh, which may be nil to weigh every vote as 1
+func NewMatcher(weigh WeightFunc) *Matcher {
+	if weigh == nil {
+		weigh = func(stream.Tweet) float64 { return 1 }
+	}
+	return &Matcher{weigh: weigh}
+}
+
+// ScanEmbedded makes the matcher also look for options in the tweets a tweet retweets or quotes.
+// It must be called before the matcher is used.
+func (m *Matcher) ScanEmbedded(scan bool) {
+	m.embedded = scan
+}
+
+// PhraseGap lets up to gap other words, at most textnorm.MaxPhraseGap, stand
+// between the words of a phrase option. It must be called before Update.
+func (m *Matcher) PhraseGap(gap int) {
+	m.phraseGap = gap
+}
+
+// Normalize has the options and the texts of the tweets run through normalize,
+// once folded, before they are matched; nil leaves them folded. It must be called before Update.
+func (m *Matcher) Normalize(normalize NormalizeFunc) {
+	m.normalize = normalize
+}
+
+// fold folds s with textnorm.Fold then normalizes it
+func (m *Matcher) fold(s string) string {
+	s = textnorm.Fold(s)
+	if m.normalize != nil {
+		s = m.normalize(s)
+	}
+	return s
+}
+
+// phrase is an option the set can't find, see textnorm.PhraseWords
+type phrase struct {
+	option int
+	*textnorm.Phrase
+}
+
+// ScanEmbeddedFor makes the matcher look for options in the tweets a tweet
+// retweets or quotes, for these options only, replacing the ones set before
+func (m *Matcher) ScanEmbeddedFor(options []string) {
+	set := make(map[string]bool, len(options))
+	for _, o := range options {
+		set[o] = true
+	}
+	m.mu.Lock()
+	m.embeddedOf = set
+	m.mu.Unlock()
+	if m.shadow != nil {
+		m.shadow.ScanEmbeddedFor(options)
+	}
+}
+
+// FoldFor makes the matcher also find options once the texts are folded, by
+// option: FoldDiacritics, or FoldTransliterate which folds diacritics too,
+// replacing the ones set before. It takes effect with the next Update.
+func (m *Matcher) FoldFor(modes map[string]string) {
+	m.mu.Lock()
+	m.foldOf = modes
+	m.mu.Unlock()
+	if m.shadow != nil {
+		m.shadow.FoldFor(modes)
+	}
+}
+
+// StemFor makes the matcher also find options in the other forms of their
+// words, by option, replacing the ones set before. Options stemmed fully are
+// tagged stem.Plural when their plural or singular is enough to find them.
+// It takes effect with the next Update.
+func (m *Matcher) StemFor(stemming map[string]Stemming) {
+	m.mu.Lock()
+	m.stemOf = stemming
+	m.mu.Unlock()
+	if m.shadow != nil {
+		m.shadow.StemFor(stemming)
+	}
+}
+
+// Update replaces the options being matched. Handle options, see stream.IsHandle,
+// are matched in the accounts a tweet mentions or replies to rather than in its text.
+// Options of several words are matched as phrases, their words in order and
+// whole, where Twitter only tracks tweets having all of them.
+func (m *Matcher) Update(options []string) {
+	if m.shadow != nil {
+		m.shadow.Update(options)
+	}
+	folded := make([]string, len(options)) // "" for handles and phrases, which the set skips
+	tagged := make(map[string][]int, len(options))
+	handles := make(map[string][]int)
+	var phrases []phrase
+	for i, o := range options {
+		if stream.IsHandle(o) {
+			key := stream.HandleKey(o)
+			handles[key] = append(handles[key], i)
+			continue
+		}
+		if key := hashtagKey(o); key != "" {
+			tagged[key] = append(tagged[key], i)
+		}
+		if p := textnorm.NewPhrase(m.fold(o), m.phraseGap); p != nil {
+			phrases = append(phrases, phrase{i, p})
+			continue
+		}
+		folded[i] = m.fold(o)
+	}
+	set := textnorm.NewSet(folded)
+	m.mu.RLock()
+	foldOf, stemOf := m.foldOf, m.stemOf
+	m.mu.RUnlock()
+	var folds []foldSet
+	for _, f := range []foldSet{{mode: FoldDiacritics, fold: textnorm.Unaccent}, {mode: FoldTransliterate, fold: textnorm.Transliterate}} {
+		terms := make([]string, len(options))
+		used := false
+		for i, o := range options {
+			if mode := foldOf[o]; mode == "" || f.mode == FoldTransliterate && mode != FoldTransliterate || stream.IsHandle(o) {
+				continue
+			}
+			term := f.fold(m.fold(o))
+			if p := textnorm.NewPhrase(term, m.phraseGap); p != nil {
+				f.phrases = append(f.phrases, phrase{i, p})
+			} else {
+				terms[i] = term
+			}
+			used = true
+		}
+		if used {
+			f.set = textnorm.NewSet(terms)
+			folds = append(folds, f)
+		}
+	}
+	stems := m.stemSets(options, stemOf)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.options, m.folded, m.phrases, m.tagged, m.handles, m.folds, m.stems = options, set, phrases, tagged, handles, folds, stems
+}
+
+// stemSets returns the sets finding the options of stemOf stemmed, the
+// plural ones before the others. Fully stemmed options are in both.
+func (m *Matcher) stemSets(options []string, stemOf map[string]Stemming) []stemSet {
+	var sets []stemSet
+	add := func(i int, s Stemming) {
+		f := stem.For(s.Language, s.Level)
+		if f == nil {
+			return
+		}
+		words := textnorm.SplitWords(m.fold(options[i]))
+		if len(words) == 0 || textnorm.HasEmoji(options[i]) {
+			return
+		}
+		j := 0
+		for j < len(sets) && sets[j].Stemming != s {
+			j++
+		}
+		if j == len(sets) {
+			sets = append(sets, stemSet{Stemming: s, stem: f, words: make(map[string][]int)})
+		}
+		stemmed := make([]string, len(words))
+		for k, w := range words {
+			stemmed[k] = f(w.Text)
+		}
+		if len(stemmed) == 1 {
+			sets[j].words[stemmed[0]] = append(sets[j].words[stemmed[0]], i)
+		} else if p := textnorm.NewPhrase(strings.Join(stemmed, " "), m.phraseGap); p != nil {
+			sets[j].phrases = append(sets[j].phrases, phrase{i, p})
+		}
+	}
+	for _, level := range []string{stem.Plural, stem.Full} {
+		for i, o := range options {
+			s, ok := stemOf[o]
+			if !ok || stream.IsHandle(o) || s.Level != stem.Full && s.Level != stem.Plural {
+				continue
+			}
+			if s.Language == "" {
+				s.Language = stem.English
+			}
+			if level == stem.Plural || s.Level == stem.Full {
+				add(i, Stemming{Level: level, Language: s.Language})
+			}
+		}
+	}
+	return sets
+}
+
+// Options returns the options being matched
+func (m *Matcher) Options() []string {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.options
+}
+
+// Match returns a vote for every option t mentions
+func (m *Matcher) Match(t stream.Tweet) []Vote {
+	votes := m.match(t)
+	if m.shadow != nil {
+		m.compare(t, votes, m.shadow.Match(t))
+	}
+	return votes
+}
+
+func (m *Matcher) match(t stream.Tweet) []Vote {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	var votes []Vote
+	t.Expand()
+	geo := geoOf(&t)
+	retweet, quote, reply := t.RetweetedStatus != nil, t.QuotedStatus != nil, t.InReplyToStatusID != ""
+	// every option the tweet mentions or has as a hashtag counts as a vote,
+	// the texts are scanned once for all of them
+	var found []bool // by option, nil while nothing is found
+	var tagged, embedded map[int]bool
+	var stemmedBy map[int]string
+	var stemmedOnly map[int]bool
+	mark := func(i int) {
+		if found == nil {
+			found = make([]bool, len(m.options))
+		}
+		found[i] = true
+	}
+	tag := func(i int) {
+		if tagged == nil {
+			tagged = make(map[int]bool)
+		}
+		tagged[i] = true
+	}
+	var foldedBy map[int]string // the options only found once folded, and how
+	fold := func(mark func(i int)) func(i int, mode string) {
+		return func(i int, mode string) {
+			if found != nil && found[i] || embedded[i] || foldedBy[i] != "" || stemmedBy[i] != "" {
+				return
+			}
+			if foldedBy == nil {
+				foldedBy = make(map[int]string)
+			}
+			foldedBy[i] = mode
+			mark(i)
+		}
+	}
+	// the options found stemmed, and how, as whole words when they are only
+	// found stemmed; the inner tweets don't count for the options found in t
+	stemmed := func(mark func(i int), inner bool) func(i int, level string) {
+		return func(i int, level string) {
+			own := found != nil && found[i]
+			if inner && own || embedded[i] || foldedBy[i] != "" || stemmedBy[i] != "" {
+				return
+			}
+			if stemmedBy == nil {
+				stemmedBy, stemmedOnly = make(map[int]string), make(map[int]bool)
+			}
+			stemmedBy[i] = level
+			if !own {
+				stemmedOnly[i] = true
+				mark(i)
+			}
+		}
+	}
+	m.scan(&t, mark, tag, fold(mark), stemmed(mark, false))
+	if m.embedded || len(m.embeddedOf) > 0 {
+		// options only found in the retweeted or quoted tweet are marked embedded
+		markEmbedded := func(i int) {
+			if (m.embedded || m.embeddedOf[m.options[i]]) && (found == nil || !found[i]) {
+				if embedded == nil {
+					embedded = make(map[int]bool)
+				}
+				embedded[i] = true
+			}
+		}
+		for _, e := range []*stream.Tweet{t.RetweetedStatus, t.QuotedStatus} {
+			if e != nil {
+				m.scan(e, markEmbedded, func(i int) {
+					if embedded[i] {
+						tag(i)
+					}
+				}, fold(markEmbedded), stemmed(markEmbedded, true))
+			}
+		}
+		for i := range embedded {
+			mark(i)
+		}
+	}
+	if found == nil {
+		return nil
+	}
+	if t.Lang == "" {
+		// only Twitter says, the other sources' texts are detected
+		t.Lang = lang.Detect(t.Text)
+	}
+	own, inner := []string{t.Text}, []string(nil) // the texts the options were found in
+	for _, e := range []*stream.Tweet{t.RetweetedStatus, t.QuotedStatus} {
+		if e != nil {
+			inner = append(inner, e.Text)
+		}
+	}
+	t.Coordinates, t.Place, t.Entities = nil, nil, nil
+	t.RetweetedStatus, t.QuotedStatus = nil, nil
+	weight := m.weigh(t)
+	options := 0
+	for _, f := range found {
+		if f {
+			options++
+		}
+	}
+	for i, option := range m.options {
+		if found[i] {
+			texts := own
+			if embedded[i] {
+				texts = inner
+			}
+			caseFolded, partial := m.strictness(option, texts, tagged[i])
+			stemmed := stemmedBy[i]
+			switch {
+			case stemmedOnly[i]:
+				partial = false // stemmed words are whole
+			case foldedBy[i] != "" && partial:
+				partial = !m.foldedWhole(option, texts, foldedBy[i])
+			case !partial:
+				stemmed = "" // found as written
+			}
+			var hit *Hit
+			for _, text := range texts {
+				if hit = m.hitIn(option, text); hit != nil {
+					break
+				}
+			}
+			votes = append(votes, Vote{Tweet: t, Option: option, Weight: weight, Geo: geo, Hashtag: tagged[i], MessageID: MessageID(t.ID, option),
+				Retweet: retweet, Quote: quote, Reply: reply, Embedded: embedded[i], CaseFolded: caseFolded, Partial: partial, Folded: foldedBy[i], Stemmed: stemmed, Hit: hit, Options: options})
+		}
+	}
+	return votes
+}
+
+// scan calls mark for the index of every option in the text of t, among its
+// hashtags or among the accounts it mentions, and tag as well for the ones among
+// its hashtags. Options only in its text once folded, see FoldFor, are passed to
+// fold after the others, then the ones only in its words stemmed, see StemFor,
+// to stemmed. Must be called with mu held.
+func (m *Matcher) scan(t *stream.Tweet, mark, tag func(i int), fold func(i int, mode string), stemmed func(i int, level string)) {
+	for h := range hashtags(t) {
+		for _, i := range m.tagged[h] {
+			mark(i)
+			tag(i)
+		}
+	}
+	for name := range mentions(t) {
+		for _, i := range m.handles[name] {
+			mark(i)
+		}
+	}
+	if m.folded == nil {
+		return
+	}
+	text := m.fold(t.Text)
+	m.folded.Find(text, mark)
+	if len(m.phrases) > 0 {
+		words := textnorm.SplitWords(text) // once for all the phrases
+		for _, p := range m.phrases {
+			if p.Find(words) >= 0 {
+				mark(p.option)
+			}
+		}
+	}
+	for _, f := range m.folds {
+		folded := f.fold(text)
+		f.set.Find(folded, func(i int) { fold(i, f.mode) })
+		if len(f.phrases) > 0 {
+			words := textnorm.SplitWords(folded)
+			for _, p := range f.phrases {
+				if p.Find(words) >= 0 {
+					fold(p.option, f.mode)
+				}
+			}
+		}
+	}
+	if len(m.stems) > 0 {
+		words := textnorm.SplitWords(text)
+		stems := make([]textnorm.Word, len(words))
+		for _, s := range m.stems {
+			for j, w := range words {
+				stems[j] = textnorm.Word{Text: s.stem(w.Text), Start: w.Start}
+				for _, i := range s.words[stems[j].Text] {
+					stemmed(i, s.Level)
+				}
+			}
+			for _, p := range s.phrases {
+				if p.Find(stems) >= 0 {
+					stemmed(p.option, s.Level)
+				}
+			}
+		}
+	}
+}
+
+// Run matches every tweet received on tweets and sends the votes on votes.
+// votes is closed once tweets is closed.
+func (m *Matcher) Run(tweets <-chan stream.Tweet, votes chan<- Vote) {
+	defer close(votes)
+	for t := range tweets {
+		for _, v := range m.Match(t) {
+			votes <- v
+		}
+	}
+}
@@ -0,0 +1,201 @@
+package match
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stem"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// benchOptions returns n options of the same length, as the bench command's
+func benchOptions(n int) []string {
+	options := make([]string, n)
+	for i := range options {
+		options[i] = fmt.Sprintf("bench%c%c%c", 'a'+i/676%26, 'a'+i/26%26, 'a'+i%26)
+	}
+	return options
+}
+
+func BenchmarkMatch(b *testing.B) {
+	for _, n := range []int{10, 100, 500, 5000} {
+		b.Run(fmt.Sprintf("options=%d", n), func(b *testing.B) {
+			options := benchOptions(n)
+			m := NewMatcher(nil)
+			m.Update(options)
+			tweets := make([]stream.Tweet, 64)
+			for i := range tweets {
+				text := "voting for " + options[i*7%n]
+				if i%10 == 0 {
+					text += " and " + options[i*13%n]
+				}
+				tweets[i] = stream.Tweet{ID: fmt.Sprint(i), Text: text}
+			}
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if len(m.Match(tweets[i%len(tweets)])) == 0 {
+					b.Fatal("no vote")
+				}
+			}
+		})
+	}
+}
+
+// describe is the option of v and how the tweet had it, the way TestMatch
+// expects the votes
+func describe(v Vote) string {
+	d := []string{v.Option}
+	for _, f := range []struct {
+		set  bool
+		name string
+	}{{v.Hashtag, "hashtag"}, {v.Retweet, "retweet"}, {v.Quote, "quote"}, {v.Reply, "reply"}, {v.Embedded, "embedded"},
+		{v.CaseFolded, "case-folded"}, {v.Partial, "partial"}, {v.Folded != "", "folded=" + v.Folded}, {v.Stemmed != "", "stemmed=" + v.Stemmed}} {
+		if f.set {
+			d = append(d, f.name)
+		}
+	}
+	if v.Hit != nil {
+		d = append(d, "hit="+v.Hit.Term)
+	}
+	return strings.Join(d, " ")
+}
+
+func TestMatch(t *testing.T) {
+	tagged := func(tags ...string) *stream.Entities {
+		e := &stream.Entities{}
+		for _, tag := range tags {
+			e.Hashtags = append(e.Hashtags, stream.Hashtag{Text: tag})
+		}
+		return e
+	}
+	mentioning := func(names ...string) *stream.Entities {
+		e := &stream.Entities{}
+		for _, n := range names {
+			e.UserMentions = append(e.UserMentions, stream.Mention{ScreenName: n})
+		}
+		return e
+	}
+	by := func(screenName string, t stream.Tweet) stream.Tweet {
+		t.User.ScreenName = screenName
+		return t
+	}
+	tests := []struct {
+		name    string
+		options []string
+		config  func(m *Matcher) // before Update
+		tweet   stream.Tweet
+		votes   []string // described
+	}{
+		{name: "an option in the text", options: []string{"cats", "dogs"},
+			tweet: stream.Tweet{Text: "team cats all the way"}, votes: []string{"cats hit=cats"}},
+		{name: "every option in the text", options: []string{"cats", "dogs"},
+			tweet: stream.Tweet{Text: "dogs > cats"}, votes: []string{"cats hit=cats", "dogs hit=dogs"}},
+		{name: "no option", options: []string{"cats", "dogs"},
+			tweet: stream.Tweet{Text: "birds"}},
+		{name: "in another case", options: []string{"cats"},
+			tweet: stream.Tweet{Text: "CATS!"}, votes: []string{"cats case-folded hit=CATS"}},
+		{name: "inside a longer word", options: []string{"cat"},
+			tweet: stream.Tweet{Text: "concatenate"}, votes: []string{"cat partial hit=cat"}},
+
+		// hashtags, from the entities
+		{name: "a hashtag for an option of several words", options: []string{"Option A"},
+			tweet: stream.Tweet{Text: "voting #OptionA", Entities: tagged("OptionA")}, votes: []string{"Option A hashtag hit=#OptionA"}},
+		{name: "a hashtag in another case", options: []string{"cats"},
+			tweet: stream.Tweet{Text: "#CATS", Entities: tagged("CATS")}, votes: []string{"cats hashtag case-folded hit=CATS"}},
+		{name: "a hashtag Twitter didn't find isn't one", options: []string{"cats"},
+			tweet: stream.Tweet{Text: "#cats"}, votes: []string{"cats hit=cats"}},
+
+		// emoji
+		{name: "an emoji option", options: []string{"🍕", "🌮"},
+			tweet: stream.Tweet{Text: "tonight it's 🍕🍕"}, votes: []string{"🍕 hit=🍕"}},
+		{name: "an emoji and a word", options: []string{"🍕 party"},
+			tweet: stream.Tweet{Text: "join the 🍕 party"}, votes: []string{"🍕 party hit=🍕 party"}},
+
+		// truncated tweets, matched in their whole text
+		{name: "in the extended tweet", options: []string{"dogs"},
+			tweet: stream.Tweet{Text: "a very long tweet…", Truncated: true, ExtendedTweet: &stream.ExtendedTweet{FullText: "a very long tweet, and in the end: dogs"}},
+			votes: []string{"dogs hit=dogs"}},
+		{name: "a hashtag in the extended tweet's entities", options: []string{"dogs"},
+			tweet: stream.Tweet{Text: "a very long tweet…", Truncated: true, ExtendedTweet: &stream.ExtendedTweet{FullText: "a very long tweet #dogs", Entities: tagged("dogs")}},
+			votes: []string{"dogs hashtag hit=dogs"}},
+		{name: "in the full text of a searched tweet", options: []string{"dogs"},
+			tweet: stream.Tweet{Text: "a very long tweet…", Truncated: true, FullText: "a very long tweet about dogs"}, votes: []string{"dogs hit=dogs"}},
+
+		// retweets, quotes and replies
+		{name: "only in the quoted tweet", options: []string{"cats"},
+			tweet: stream.Tweet{Text: "so true", QuotedStatus: &stream.Tweet{Text: "cats rule"}}},
+		{name: "only in the quoted tweet, scanned", options: []string{"cats"}, config: func(m *Matcher) { m.ScanEmbedded(true) },
+			tweet: stream.Tweet{Text: "so true", QuotedStatus: &stream.Tweet{Text: "cats rule"}}, votes: []string{"cats quote embedded hit=cats"}},
+		{name: "in the retweet, scanned for its option", options: []string{"cats", "dogs"}, config: func(m *Matcher) { m.ScanEmbeddedFor([]string{"dogs"}) },
+			tweet: stream.Tweet{Text: "RT @pets: …", RetweetedStatus: &stream.Tweet{Text: "cats and dogs"}}, votes: []string{"dogs retweet embedded hit=dogs"}},
+		{name: "in the quote and the quoted tweet", options: []string{"cats"}, config: func(m *Matcher) { m.ScanEmbedded(true) },
+			tweet: stream.Tweet{Text: "cats, obviously", QuotedStatus: &stream.Tweet{Text: "cats or dogs?"}}, votes: []string{"cats quote hit=cats"}},
+		{name: "a reply", options: []string{"cats"},
+			tweet: stream.Tweet{Text: "cats", InReplyToStatusID: "1"}, votes: []string{"cats reply hit=cats"}},
+
+		// handles, in the mentions
+		{name: "a handle mentioned", options: []string{"@nasa"},
+			tweet: stream.Tweet{Text: "go @NASA", Entities: mentioning("NASA")}, votes: []string{"@nasa hit=@NASA"}},
+		{name: "a handle replied to", options: []string{"@nasa"},
+			tweet: stream.Tweet{Text: "great launch", InReplyToStatusID: "1", InReplyToScreenName: "NASA"}, votes: []string{"@nasa reply"}},
+		{name: "a handle only in the text", options: []string{"@nasa"},
+			tweet: stream.Tweet{Text: "go @nasa"}},
+		{name: "a handle mentioning itself", options: []string{"@nasa"},
+			tweet: by("NASA", stream.Tweet{Text: "follow @nasa", Entities: mentioning("nasa")})},
+
+		// phrases, their words in order
+		{name: "a phrase", options: []string{"ice cream"},
+			tweet: stream.Tweet{Text: "Ice  cream, please"}, votes: []string{"ice cream case-folded hit=Ice  cream"}},
+		{name: "a phrase out of order", options: []string{"ice cream"},
+			tweet: stream.Tweet{Text: "cream on ice"}},
+		{name: "a phrase with a word between", options: []string{"ice cream"},
+			tweet: stream.Tweet{Text: "ice cold cream"}},
+		{name: "a phrase with a word between, in the gap", options: []string{"ice cream"}, config: func(m *Matcher) { m.PhraseGap(1) },
+			tweet: stream.Tweet{Text: "ice cold cream"}, votes: []string{"ice cream hit=ice cold cream"}},
+		{name: "a phrase inside words", options: []string{"ice cream"},
+			tweet: stream.Tweet{Text: "nice creamy"}},
+
+		// folding
+		{name: "without its diacritics, not folded", options: []string{"café"},
+			tweet: stream.Tweet{Text: "cafe au lait"}},
+		{name: "without its diacritics", options: []string{"café"}, config: func(m *Matcher) { m.FoldFor(map[string]string{"café": FoldDiacritics}) },
+			tweet: stream.Tweet{Text: "cafe au lait"}, votes: []string{"café case-folded folded=diacritics"}},
+		{name: "inside a longer word, folded", options: []string{"café"}, config: func(m *Matcher) { m.FoldFor(map[string]string{"café": FoldDiacritics}) },
+			tweet: stream.Tweet{Text: "cafeteria"}, votes: []string{"café case-folded partial folded=diacritics"}},
+		{name: "with its diacritics, folded", options: []string{"café"}, config: func(m *Matcher) { m.FoldFor(map[string]string{"café": FoldDiacritics}) },
+			tweet: stream.Tweet{Text: "café au lait"}, votes: []string{"café hit=café"}},
+		{name: "in another alphabet", options: []string{"Москва"}, config: func(m *Matcher) { m.FoldFor(map[string]string{"Москва": FoldTransliterate}) },
+			tweet: stream.Tweet{Text: "I love Moskva"}, votes: []string{"Москва case-folded folded=transliterate"}},
+
+		// stemming
+		{name: "a plural, not stemmed", options: []string{"puppy"},
+			tweet: stream.Tweet{Text: "puppies!"}},
+		{name: "a plural", options: []string{"puppy"}, config: func(m *Matcher) { m.StemFor(map[string]Stemming{"puppy": {Level: stem.Plural}}) },
+			tweet: stream.Tweet{Text: "puppies!"}, votes: []string{"puppy case-folded stemmed=plural"}},
+		{name: "another form, stemmed as plurals", options: []string{"argue"}, config: func(m *Matcher) { m.StemFor(map[string]Stemming{"argue": {Level: stem.Plural}}) },
+			tweet: stream.Tweet{Text: "stop arguing"}},
+		{name: "another form", options: []string{"argue"}, config: func(m *Matcher) { m.StemFor(map[string]Stemming{"argue": {Level: stem.Full}}) },
+			tweet: stream.Tweet{Text: "stop arguing"}, votes: []string{"argue case-folded stemmed=stem"}},
+		{name: "as written, stemmed", options: []string{"vote"}, config: func(m *Matcher) { m.StemFor(map[string]Stemming{"vote": {Level: stem.Full}}) },
+			tweet: stream.Tweet{Text: "vote now"}, votes: []string{"vote hit=vote"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := NewMatcher(nil)
+			if tt.config != nil {
+				tt.config(m)
+			}
+			m.Update(tt.options)
+			var votes []string
+			for _, v := range m.Match(tt.tweet) {
+				votes = append(votes, describe(v))
+			}
+			if !reflect.DeepEqual(votes, tt.votes) {
+				t.Errorf("votes %q, want %q", votes, tt.votes)
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package match
+
+import "github.com/olawolu/twitter-polls/tweetreader/stream"
+
+// mentions returns the keys of the accounts t mentions or replies to, see
+// stream.HandleKey, leaving out its author: an account can't vote for itself
+func mentions(t *stream.Tweet) map[string]bool {
+	var names []string
+	if t.Entities != nil {
+		for _, m := range t.Entities.UserMentions {
+			names = append(names, m.ScreenName)
+		}
+	}
+	if t.InReplyToScreenName != "" {
+		names = append(names, t.InReplyToScreenName)
+	}
+	if len(names) == 0 {
+		return nil
+	}
+	author := stream.HandleKey(t.User.ScreenName)
+	keys := make(map[string]bool, len(names))
+	for _, n := range names {
+		if key := stream.HandleKey(n); key != author {
+			keys[key] = true
+		}
+	}
+	return keys
+}
@@ -0,0 +1,47 @@
+package match
+
+import (
+	"fmt"
+	"log"
+	"plugin"
+)
+
+// NormalizeFunc rewrites folded text for matching, e.g. stemming or
+// transliterating it; options and tweets go through the same function
+type NormalizeFunc func(text string) string
+
+// LoadNormalizer opens the Go plugin at path and returns the function it exports
+// as Normalize, a func(string) string. The plugin has to be built with
+// go build -buildmode=plugin by the same Go toolchain as the streamer, which
+// needs cgo. A plugin that panics leaves the text as it was folded.
+func LoadNormalizer(path string) (NormalizeFunc, error) {
+	p, err := plugin.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("match: %v", err)
+	}
+	sym, err := p.Lookup("Normalize")
+	if err != nil {
+		return nil, fmt.Errorf("match: %s: %v", path, err)
+	}
+	var normalize func(string) string
+	switch f := sym.(type) {
+	case func(string) string:
+		normalize = f
+	case *func(string) string:
+		normalize = *f
+	default:
+		return nil, fmt.Errorf("match: %s: Normalize is a %T, want a func(string) string", path, sym)
+	}
+	if normalize == nil {
+		return nil, fmt.Errorf("match: %s: Normalize is nil", path)
+	}
+	return func(text string) (out string) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Println("match: normalize plugin panicked:", r)
+				out = text
+			}
+		}()
+		return normalize(text)
+	}, nil
+}
@@ -0,0 +1,12 @@
+package match
+
+import "github.com/olawolu/twitter-polls/tweetreader/stream"
+
+// Shadow has s match every tweet m matches, as it is configured, and m hand
+// the votes of both to compare. s is given the options and the per option
+// settings m is given, its votes go no further than compare, so a matcher
+// configured differently can be tried on the live tweets before it replaces
+// m. It has to be called before m matches or is updated.
+func (m *Matcher) Shadow(s *Matcher, compare func(t stream.Tweet, votes, shadowed []Vote)) {
+	m.shadow, m.compare = s, compare
+}
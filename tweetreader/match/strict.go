@@ -0,0 +1,55 @@
+package match
+
+import (
+	"strings"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// strictness tells the polls matching an option in the case it is written, or
+// only as whole words, whether the tweet has option that way in one of texts,
+// or as a hashtag when tagged. Handles are matched in the mentions, whatever
+// their case, and phrases are always whole words.
+func (m *Matcher) strictness(option string, texts []string, tagged bool) (caseFolded, partial bool) {
+	if stream.IsHandle(option) {
+		return false, false
+	}
+	sameCase, whole := false, tagged
+	if tagged {
+		tag := "#" + strings.Join(strings.Fields(strings.TrimPrefix(strings.TrimSpace(option), "#")), "")
+		for _, text := range texts {
+			sameCase = sameCase || strings.Contains(textnorm.Strip(text), textnorm.Strip(tag))
+		}
+	}
+	if p := textnorm.NewPhrase(textnorm.Strip(option), m.phraseGap); p != nil {
+		for _, text := range texts {
+			sameCase = sameCase || p.Index(textnorm.Strip(text)) >= 0
+		}
+		return !sameCase, false
+	}
+	folded, stripped := textnorm.Fold(option), textnorm.Strip(option)
+	for _, text := range texts {
+		sameCase = sameCase || textnorm.Contains(textnorm.Strip(text), stripped)
+		whole = whole || textnorm.IndexWord(textnorm.Fold(text), folded) >= 0
+	}
+	return !sameCase, !whole
+}
+
+// foldedWhole reports whether one of texts has option as whole words once
+// both are folded as mode, for the options only found folded. Must be called
+// with mu held.
+func (m *Matcher) foldedWhole(option string, texts []string, mode string) bool {
+	for _, f := range m.folds {
+		if f.mode != mode {
+			continue
+		}
+		term := f.fold(m.fold(option))
+		for _, text := range texts {
+			if textnorm.IndexWord(f.fold(m.fold(text)), term) >= 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,23 @@
+package match
+
+import (
+	"strconv"
+	"strings"
+)
+
+// VersionTag is the entry of Vote.Versions for version of the options of poll
+func VersionTag(poll string, version int) string {
+	return poll + ":" + strconv.Itoa(version)
+}
+
+// PollVersion returns the version of poll's options in a vote's Versions, 0
+// when the streamer didn't tag it
+func PollVersion(versions []string, poll string) int {
+	for _, tag := range versions {
+		if i := strings.LastIndexByte(tag, ':'); i >= 0 && tag[:i] == poll {
+			version, _ := strconv.Atoi(tag[i+1:])
+			return version
+		}
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package match
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// WeightFunc decides how much a vote from the author of t counts for
+type WeightFunc func(t stream.Tweet) float64
+
+// followerTier applies a multiplier to authors with at least min followers
+type followerTier struct {
+	min    int
+	factor float64
+}
+
+// ParseWeighting builds a WeightFunc from a comma separated spec like
+//
+//	verified=2,followers:1000=1.5,followers:100000=3
+//
+// verified multiplies the weight of verified authors, and the highest
+// followers tier the author reaches is applied on top of that.
+// An empty spec weighs every vote as 1.
+func ParseWeighting(spec string) (WeightFunc, error) {
+	verified := 1.0
+	var tiers []followerTier
+	for _, rule := range strings.Split(spec, ",") {
+		rule = strings.TrimSpace(rule)
+		if rule == "" {
+			continue
+		}
+		kv := strings.SplitN(rule, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("weighting rule %q: expected key=factor", rule)
+		}
+		factor, err := strconv.ParseFloat(kv[1], 64)
+		if err != nil || factor < 0 {
+			return nil, fmt.Errorf("weighting rule %q: invalid factor", rule)
+		}
+		switch key := kv[0]; {
+		case key == "verified":
+			verified = factor
+		case strings.HasPrefix(key, "followers:"):
+			min, err := strconv.Atoi(strings.TrimPrefix(key, "followers:"))
+			if err != nil {
+				return nil, fmt.Errorf("weighting rule %q: invalid follower count", rule)
+			}
+			tiers = append(tiers, followerTier{min: min, factor: factor})
+		default:
+			return nil, fmt.Errorf("weighting rule %q: unknown signal %q", rule, key)
+		}
+	}
+	// highest tier first so the first match wins
+	sort.Slice(tiers, func(i, j int) bool { return tiers[i].min > tiers[j].min })
+
+	return func(t stream.Tweet) float64 {
+		w := 1.0
+		if t.User.Verified {
+			w *= verified
+		}
+		for _, tier := range tiers {
+			if t.User.FollowersCount >= tier.min {
+				w *= tier.factor
+				break
+			}
+		}
+		return w
+	}, nil
+}
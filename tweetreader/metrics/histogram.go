@@ -0,0 +1,60 @@
+package metrics
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"sync"
+)
+
+// Histogram is a Prometheus histogram without labels
+type Histogram struct {
+	name, help string
+	buckets    []float64 // upper bounds, ascending, +Inf is implied
+
+	mu     sync.Mutex
+	counts []float64 // per bucket, not cumulative, the last one is +Inf
+	sum    float64
+}
+
+// NewHistogram registers a histogram with the given bucket upper bounds, in ascending order
+func NewHistogram(name, help string, buckets []float64) *Histogram {
+	h := &Histogram{name: name, help: help, buckets: buckets, counts: make([]float64, len(buckets)+1)}
+	register(h)
+	return h
+}
+
+// Observe records v
+func (h *Histogram) Observe(v float64) {
+	i := 0
+	for i < len(h.buckets) && v > h.buckets[i] {
+		i++
+	}
+	h.mu.Lock()
+	h.counts[i]++
+	h.sum += v
+	h.mu.Unlock()
+}
+
+func (h *Histogram) write(w *strings.Builder) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s histogram\n", h.name, h.help, h.name)
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	var total float64
+	for i, n := range h.counts {
+		total += n
+		le := math.Inf(1)
+		if i < len(h.buckets) {
+			le = h.buckets[i]
+		}
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %v\n", h.name, formatBound(le), total)
+	}
+	fmt.Fprintf(w, "%s_sum %v\n%s_count %v\n", h.name, h.sum, h.name, total)
+}
+
+func formatBound(f float64) string {
+	if math.IsInf(f, 1) {
+		return "+Inf"
+	}
+	return fmt.Sprint(f)
+}
@@ -0,0 +1,155 @@
+// Package metrics is a small Prometheus text-format registry
+// for the counters, gauges and histograms tweetreader exports.
+package metrics
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Metric is a Prometheus counter or gauge, optionally split by labels.
+// It only implements what tweetreader needs, which is rendering the text exposition format.
+type Metric struct {
+	name, help, typ string
+	fn              func() float64 // computes the value at scrape time, if set
+
+	mu     sync.Mutex
+	values map[string]float64 // keyed by the rendered label set
+}
+
+// collector is anything that renders itself in the exposition format
+type collector interface {
+	write(w *strings.Builder)
+}
+
+// registry holds every metric in the order it was registered
+var registry struct {
+	sync.Mutex
+	metrics []collector
+}
+
+func register(m collector) {
+	registry.Lock()
+	defer registry.Unlock()
+	registry.metrics = append(registry.metrics, m)
+}
+
+func registerMetric(m *Metric) *Metric {
+	register(m)
+	return m
+}
+
+// NewCounter registers a metric that only goes up
+func NewCounter(name, help string) *Metric {
+	return registerMetric(&Metric{name: name, help: help, typ: "counter", values: make(map[string]float64)})
+}
+
+// NewGauge registers a metric that can go up and down
+func NewGauge(name, help string) *Metric {
+	return registerMetric(&Metric{name: name, help: help, typ: "gauge", values: make(map[string]float64)})
+}
+
+// NewGaugeFunc registers a gauge whose value is computed by fn on every scrape
+func NewGaugeFunc(name, help string, fn func() float64) *Metric {
+	return registerMetric(&Metric{name: name, help: help, typ: "gauge", fn: fn})
+}
+
+// Add adds v to the series identified by labels, given as name, value pairs
+func (m *Metric) Add(v float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	m.values[key] += v
+	m.mu.Unlock()
+}
+
+// Inc adds one to the series identified by labels
+func (m *Metric) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Set sets the series identified by labels to v
+func (m *Metric) Set(v float64, labels ...string) {
+	key := renderLabels(labels)
+	m.mu.Lock()
+	m.values[key] = v
+	m.mu.Unlock()
+}
+
+// Value returns the current value of the series identified by labels
+func (m *Metric) Value(labels ...string) float64 {
+	if m.fn != nil {
+		return m.fn()
+	}
+	key := renderLabels(labels)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.values[key]
+}
+
+func (m *Metric) write(w *strings.Builder) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", m.name, m.help, m.name, m.typ)
+	if m.fn != nil {
+		fmt.Fprintf(w, "%s %v\n", m.name, m.fn())
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fmt.Fprintf(w, "%s%s %v\n", m.name, k, m.values[k])
+	}
+}
+
+func renderLabels(labels []string) string {
+	if len(labels) == 0 {
+		return ""
+	}
+	escape := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+	var b strings.Builder
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		fmt.Fprintf(&b, `%s="%s"`, labels[i], escape.Replace(labels[i+1]))
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// Dump returns every series of every registered metric as a line, without
+// the HELP and TYPE comments, e.g. to log them
+func Dump() []string {
+	var b strings.Builder
+	registry.Lock()
+	for _, m := range registry.metrics {
+		m.write(&b)
+	}
+	registry.Unlock()
+	var lines []string
+	for _, line := range strings.Split(b.String(), "\n") {
+		if line != "" && !strings.HasPrefix(line, "#") {
+			lines = append(lines, line)
+		}
+	}
+	return lines
+}
+
+// Handler serves every registered metric to Prometheus
+func Handler(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+	registry.Lock()
+	for _, m := range registry.metrics {
+		m.write(&b)
+	}
+	registry.Unlock()
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	fmt.Fprint(w, b.String())
+}
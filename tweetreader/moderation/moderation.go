@@ -0,0 +1,152 @@
+// Package moderation holds back contested votes for review instead of letting
+// them be counted straight away. A vote is contested when its tweet voted for
+// several options, when it comes from a low-trust account or when the spike
+// detector tagged it suspect. The streamer publishes those to the pending
+// topic, the review command keeps them until a moderator approves a vote,
+// which publishes it on the votes topic to be counted, or rejects it.
+package moderation
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Topic is the NSQ topic held votes are published on
+const Topic = "pending"
+
+// Reasons a vote is held for
+const (
+	// Multiple is a vote from a tweet voting for more than one option
+	Multiple = "multiple"
+	// LowTrust is a vote from an unverified account with few followers
+	LowTrust = "low_trust"
+	// Suspect is a vote the spike detector tagged suspect
+	Suspect = "suspect"
+)
+
+// Reasons are every reason a vote can be held for
+var Reasons = []string{Multiple, LowTrust, Suspect}
+
+var (
+	held = metrics.NewCounter("tweetreader_moderation_held_total",
+		"Votes held for review, by the first reason they were held for.")
+	holdErrors = metrics.NewCounter("tweetreader_moderation_publish_errors_total",
+		"Votes that couldn't be published to the pending topic and were let through.")
+)
+
+// Publisher is the part of publish.Publisher held votes are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Config is which votes are held and where they go
+type Config struct {
+	// Reasons are the reasons votes are held for, all of them when empty
+	Reasons []string
+	// MinFollowers is how many followers an unverified account needs not to be
+	// low trust, 10 by default
+	MinFollowers int
+	// Pending publishes the held votes as Pending in JSON, when nil they are
+	// only logged and let through, for dry runs
+	Pending Publisher
+}
+
+// Pending is a vote waiting for review
+type Pending struct {
+	Vote     match.Vote `json:"vote"`
+	Reasons  []string   `json:"reasons"`
+	QueuedAt time.Time  `json:"queued_at"`
+}
+
+// ID is what a moderator names the pending vote by, its message ID
+func (p *Pending) ID() string {
+	return p.Vote.MessageID
+}
+
+// Moderator holds back the contested votes
+type Moderator struct {
+	reasons      map[string]bool
+	minFollowers int
+	pending      Publisher
+}
+
+// New creates a moderator holding the votes cfg asks for
+func New(cfg Config) (*Moderator, error) {
+	if len(cfg.Reasons) == 0 {
+		cfg.Reasons = Reasons
+	}
+	if cfg.MinFollowers == 0 {
+		cfg.MinFollowers = 10
+	}
+	m := &Moderator{reasons: make(map[string]bool), minFollowers: cfg.MinFollowers, pending: cfg.Pending}
+	for _, r := range cfg.Reasons {
+		switch r {
+		case Multiple, LowTrust, Suspect:
+			m.reasons[r] = true
+		default:
+			return nil, fmt.Errorf("unknown moderation reason %q, want multiple, low_trust or suspect", r)
+		}
+	}
+	return m, nil
+}
+
+// Contested returns the reasons v is held for, none when it can be counted
+func (m *Moderator) Contested(v *match.Vote) []string {
+	var reasons []string
+	if m.reasons[Multiple] && v.Options > 1 {
+		reasons = append(reasons, Multiple)
+	}
+	if m.reasons[LowTrust] && !v.User.Verified && v.User.FollowersCount < m.minFollowers {
+		reasons = append(reasons, LowTrust)
+	}
+	if m.reasons[Suspect] && v.Suspect {
+		reasons = append(reasons, Suspect)
+	}
+	return reasons
+}
+
+// hold publishes v for review, it reports false when v has to be let through
+func (m *Moderator) hold(v match.Vote, reasons []string) bool {
+	if m.pending == nil {
+		log.Println("moderation: would hold", v.MessageID, "for", reasons)
+		return false
+	}
+	b, err := json.Marshal(Pending{Vote: v, Reasons: reasons, QueuedAt: time.Now().UTC()})
+	if err == nil {
+		err = m.pending.Publish(b)
+	}
+	if err != nil {
+		// counting a contested vote beats losing it
+		log.Println("moderation: failed to hold", v.MessageID, "letting it through:", err)
+		holdErrors.Inc()
+		return false
+	}
+	held.Inc("reason", reasons[0])
+	return true
+}
+
+// Keep reports whether v goes on to be counted, holding it when contested
+func (m *Moderator) Keep(v *match.Vote) bool {
+	reasons := m.Contested(v)
+	return len(reasons) == 0 || !m.hold(*v, reasons)
+}
+
+// Run holds the contested votes from in and passes on the others, the
+// returned channel is closed once in is
+func (m *Moderator) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if m.Keep(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,186 @@
+package moderation
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// snapshotName is what the review queue is saved under
+const snapshotName = "moderation"
+
+// ErrNotPending is returned when deciding on a vote that isn't waiting for review
+var ErrNotPending = errors.New("no such pending vote")
+
+// errFull makes NSQ redeliver a held vote later, once moderators made room
+var errFull = errors.New("moderation: review queue full")
+
+var decisions = metrics.NewCounter("tweetreader_moderation_decisions_total",
+	"Held votes approved or rejected, by decision.")
+
+// Queue keeps the held votes until they are decided on. It is saved to the
+// store on every change, so restarts don't lose votes, and NSQ only forgets a
+// held vote once it is saved.
+type Queue struct {
+	max       int
+	snapshots store.SnapshotStore
+
+	mu      sync.Mutex
+	pending map[string]Pending
+}
+
+// NewQueue creates a queue of up to max votes, 10000 when max isn't positive,
+// saved in snapshots, which may be nil to keep them in memory only
+func NewQueue(max int, snapshots store.SnapshotStore) *Queue {
+	if max <= 0 {
+		max = 10000
+	}
+	q := &Queue{max: max, snapshots: snapshots, pending: make(map[string]Pending)}
+	metrics.NewGaugeFunc("tweetreader_moderation_pending", "Held votes waiting for review.", func() float64 {
+		q.mu.Lock()
+		defer q.mu.Unlock()
+		return float64(len(q.pending))
+	})
+	return q
+}
+
+// Load restores the votes saved by the last run
+func (q *Queue) Load() error {
+	if q.snapshots == nil {
+		return nil
+	}
+	b, err := q.snapshots.LoadSnapshot(snapshotName)
+	if err == store.ErrNoSnapshot {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var saved []Pending
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return err
+	}
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, p := range saved {
+		q.pending[p.ID()] = p
+	}
+	return nil
+}
+
+// save writes the queue to the store, must be called with mu held
+func (q *Queue) save() error {
+	if q.snapshots == nil {
+		return nil
+	}
+	b, err := json.Marshal(q.sorted())
+	if err != nil {
+		return err
+	}
+	return q.snapshots.SaveSnapshot(snapshotName, b)
+}
+
+// sorted returns the pending votes, oldest first, must be called with mu held
+func (q *Queue) sorted() []Pending {
+	all := make([]Pending, 0, len(q.pending))
+	for _, p := range q.pending {
+		all = append(all, p)
+	}
+	sort.Slice(all, func(i, j int) bool {
+		if !all[i].QueuedAt.Equal(all[j].QueuedAt) {
+			return all[i].QueuedAt.Before(all[j].QueuedAt)
+		}
+		return all[i].ID() < all[j].ID()
+	})
+	return all
+}
+
+// Add queues p, a redelivered vote replaces the one queued
+func (q *Queue) Add(p Pending) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	id := p.ID()
+	old, queued := q.pending[id]
+	if !queued && len(q.pending) >= q.max {
+		return errFull
+	}
+	q.pending[id] = p
+	if err := q.save(); err != nil {
+		if queued {
+			q.pending[id] = old
+		} else {
+			delete(q.pending, id)
+		}
+		return err
+	}
+	return nil
+}
+
+// HandleMessage queues a vote published on the pending topic, it makes Queue
+// an nsq.Handler. Errors requeue the message.
+func (q *Queue) HandleMessage(m *nsq.Message) error {
+	var p Pending
+	if err := json.Unmarshal(m.Body, &p); err != nil || p.ID() == "" {
+		log.Println("moderation: dropping an invalid pending vote:", err)
+		return nil
+	}
+	return q.Add(p)
+}
+
+// List returns up to limit pending votes, oldest first, every one when limit
+// isn't positive, and how many are pending
+func (q *Queue) List(limit int) ([]Pending, int) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	all := q.sorted()
+	if limit > 0 && len(all) > limit {
+		return all[:limit], len(q.pending)
+	}
+	return all, len(q.pending)
+}
+
+// Approve counts the vote named id: it is handed to publish, which sends it
+// to the votes topic, and forgotten once that succeeds
+func (q *Queue) Approve(id string, publish func(v *match.Vote) error) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	p, ok := q.pending[id]
+	if !ok {
+		return ErrNotPending
+	}
+	if err := publish(&p.Vote); err != nil {
+		return err
+	}
+	q.forget(id, "approved")
+	return nil
+}
+
+// Reject forgets the vote named id without counting it
+func (q *Queue) Reject(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if _, ok := q.pending[id]; !ok {
+		return ErrNotPending
+	}
+	q.forget(id, "rejected")
+	return nil
+}
+
+// forget drops a decided vote, must be called with mu held. A vote that was
+// published but couldn't be dropped from the saved queue is counted once,
+// the counter drops the second delivery by its message ID.
+func (q *Queue) forget(id, decision string) {
+	delete(q.pending, id)
+	decisions.Inc("decision", decision)
+	if err := q.save(); err != nil {
+		log.Println("moderation: failed to save the review queue:", err)
+	}
+}
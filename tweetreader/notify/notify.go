@@ -0,0 +1,103 @@
+// Package notify posts messages about polls to Slack and Discord channels
+// through their incoming webhooks.
+package notify
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"time"
+)
+
+// Supported webhook kinds
+const (
+	Slack   = "slack"
+	Discord = "discord"
+)
+
+// Message is a text for one webhook
+type Message struct {
+	Kind string
+	URL  string
+	Text string
+}
+
+// Notifier posts messages in the background so a slow webhook never holds up the caller.
+// A nil Notifier drops every message.
+type Notifier struct {
+	client *http.Client
+	queue  chan Message
+	done   chan struct{}
+}
+
+// New starts a notifier posting up to 64 queued messages at a time
+func New() *Notifier {
+	n := &Notifier{
+		client: &http.Client{Timeout: 10 * time.Second},
+		queue:  make(chan Message, 64),
+		done:   make(chan struct{}),
+	}
+	go n.run()
+	return n
+}
+
+// Send queues m, dropping it when the queue is full
+func (n *Notifier) Send(m Message) {
+	if n == nil {
+		return
+	}
+	select {
+	case n.queue <- m:
+	default:
+		log.Printf("notify: queue full, dropping a %s message", m.Kind)
+	}
+}
+
+// Close posts what is queued and stops
+func (n *Notifier) Close() {
+	if n == nil {
+		return
+	}
+	close(n.queue)
+	<-n.done
+}
+
+func (n *Notifier) run() {
+	defer close(n.done)
+	for m := range n.queue {
+		if err := n.post(m); err != nil {
+			log.Printf("notify: %s: %v", m.Kind, err)
+		}
+	}
+}
+
+// post sends one message in the payload the webhook's kind expects
+func (n *Notifier) post(m Message) error {
+	var payload interface{}
+	switch m.Kind {
+	case Slack:
+		payload = map[string]string{"text": m.Text}
+	case Discord:
+		payload = map[string]string{"content": m.Text}
+	default:
+		return fmt.Errorf("unknown webhook kind %q", m.Kind)
+	}
+	b, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	resp, err := n.client.Post(m.URL, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("webhook answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
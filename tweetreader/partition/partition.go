@@ -0,0 +1,112 @@
+// Package partition splits the votes between topics by poll, so every
+// poll's votes are counted by a single counter and the counters never add to
+// the same poll at once.
+//
+// A poll belongs to one of n partitions by a consistent hash of its ID: every
+// streamer and counter computes the same partition without talking to the
+// others, and going from n to n+1 partitions only moves a poll in n+1 to the
+// new one. A vote is for an option, which may be in polls of several
+// partitions; it is published once to each of their topics, and the counter
+// of a partition only counts it for the polls of its own.
+package partition
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Of returns the partition of the poll id out of n, from 0 to n-1
+func Of(id string, n int) int {
+	if n <= 1 {
+		return 0
+	}
+	h := fnv.New64a()
+	h.Write([]byte(id))
+	// jump consistent hashing, Lamping and Veach
+	key, b, j := h.Sum64(), int64(-1), int64(0)
+	for j < int64(n) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// Topic returns the topic the votes of partition k of topic are published to
+func Topic(topic string, k int) string {
+	return fmt.Sprintf("%s-%d", topic, k)
+}
+
+// Partitioner routes the votes to the topics of their polls' partitions
+type Partitioner struct {
+	topic string
+	n     int
+
+	mu sync.RWMutex
+	of map[string][]int // the partitions per option, sorted
+}
+
+// New creates a Partitioner routing the votes of topic to n partitions of it,
+// routing none until the options are loaded
+func New(topic string, n int) *Partitioner {
+	return &Partitioner{topic: topic, n: n}
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the partitions of the polls having them. When the polls can't be loaded the
+// last ones loaded are kept.
+func (p *Partitioner) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("partition: failed to load the polls, keeping the last known:", err)
+			return options, nil
+		}
+		seen := make(map[string]map[int]bool)
+		for _, poll := range all {
+			if !poll.Tracked() {
+				continue
+			}
+			k := Of(poll.ID, p.n)
+			for _, o := range poll.Options {
+				if seen[o] == nil {
+					seen[o] = make(map[int]bool)
+				}
+				seen[o][k] = true
+			}
+		}
+		of := make(map[string][]int, len(seen))
+		for o, ks := range seen {
+			for k := range ks {
+				of[o] = append(of[o], k)
+			}
+			sort.Ints(of[o])
+		}
+		p.mu.Lock()
+		p.of = of
+		p.mu.Unlock()
+		return options, nil
+	}
+}
+
+// Route returns the topics of the partitions of v's polls, none for an option
+// no tracked poll has
+func (p *Partitioner) Route(v *match.Vote) []string {
+	p.mu.RLock()
+	defer p.mu.RUnlock()
+	var topics []string
+	for _, k := range p.of[v.Option] {
+		topics = append(topics, Topic(p.topic, k))
+	}
+	return topics
+}
@@ -0,0 +1,157 @@
+// Package privacy keeps the votes for private polls from identifying their
+// authors: before a vote leaves the streamer its text and hit snippet are
+// dropped, as is the author's ID, and the author's screen name is replaced
+// with an HMAC of it.
+//
+// The HMAC key is derived from a secret and the current rotation period, so
+// within a period every vote of an author carries the same hash and the
+// author's votes can still be deduplicated, while hashes from different
+// periods can't be linked to each other or, without the secret, to anyone.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"log"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var anonymized = metrics.NewCounter("tweetreader_private_votes_total",
+	"Votes for private polls whose author was hashed and text dropped.")
+
+// Hasher hashes author identifiers with a key that rotates every period
+type Hasher struct {
+	rotation time.Duration
+
+	mu     sync.Mutex
+	secret []byte
+	period int64  // of key
+	key    []byte // derived from secret for period
+}
+
+// NewHasher creates a Hasher deriving a key from secret every rotation. Without
+// a secret it uses a random one, so hashes differ between processes.
+func NewHasher(secret string, rotation time.Duration) *Hasher {
+	h := &Hasher{rotation: rotation}
+	h.SetSecret(secret)
+	return h
+}
+
+// SetSecret replaces the secret, the hashes change right away
+func (h *Hasher) SetSecret(secret string) {
+	s := []byte(secret)
+	if len(s) == 0 {
+		s = make([]byte, 32)
+		if _, err := rand.Read(s); err != nil {
+			panic(err)
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.secret, h.key = s, nil
+}
+
+// Hash returns the hash of id for the period now is in
+func (h *Hasher) Hash(id string, now time.Time) string {
+	h.mu.Lock()
+	period := now.UnixNano() / int64(h.rotation)
+	if h.key == nil || period != h.period {
+		h.key, h.period = sign(h.secret, strconv.FormatInt(period, 10)), period
+	}
+	key := h.key
+	h.mu.Unlock()
+	return hex.EncodeToString(sign(key, id)[:16])
+}
+
+func sign(key []byte, msg string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// Filter anonymizes the votes for the options of private polls
+type Filter struct {
+	hasher *Hasher
+
+	mu      sync.RWMutex
+	loaded  bool            // false until the polls were first loaded
+	private map[string]bool // options of private polls
+}
+
+// NewFilter creates a Filter hashing authors with hasher. Until Update is
+// first called it doesn't know which polls are private and anonymizes every vote.
+func NewFilter(hasher *Hasher) *Filter {
+	return &Filter{hasher: hasher}
+}
+
+// Update takes the private options from polls. An option in both a private
+// and a public poll is private: its votes are the same for both.
+func (f *Filter) Update(polls []store.Poll) {
+	private := make(map[string]bool)
+	for _, p := range polls {
+		if p.Private {
+			for _, o := range p.Options {
+				private[o] = true
+			}
+		}
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.loaded, f.private = true, private
+}
+
+// Options wraps a function loading the options so every load also updates
+// which of them are private. When the polls can't be loaded the last private
+// options are kept.
+func (f *Filter) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("privacy: failed to load the polls, keeping the last private options:", err)
+			return options, nil
+		}
+		f.Update(all)
+		return options, nil
+	}
+}
+
+// Apply anonymizes v if its option is private
+func (f *Filter) Apply(v *match.Vote) {
+	f.mu.RLock()
+	private := !f.loaded || f.private[v.Option]
+	f.mu.RUnlock()
+	if !private {
+		return
+	}
+	v.User.ScreenName = f.hasher.Hash(v.User.ScreenName, time.Now())
+	v.User.ID, v.User.Name, v.Text = "", "", ""
+	if v.Hit != nil {
+		v.Hit.Snippet = ""
+	}
+	anonymized.Inc()
+}
+
+// Run anonymizes the votes from in, the returned channel is closed once in is
+func (f *Filter) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			f.Apply(&v)
+			out <- v
+		}
+	}()
+	return out
+}
@@ -0,0 +1,107 @@
+package publish
+
+import (
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/slo"
+)
+
+// Votes are published to nsqd without waiting for it to answer each one: the
+// answers come back asynchronously, tied to their vote, so a failure is
+// retried for that vote alone and what took long to be acknowledged shows in
+// the publish latency.
+
+// AsyncPublisher is a Publisher that can also publish without waiting for the
+// broker, calling done with the outcome once it answered. done is called once,
+// from another goroutine, and mustn't block.
+type AsyncPublisher interface {
+	Publisher
+	PublishAsync(b []byte, done func(error))
+}
+
+const (
+	// publishAttempts is how often a vote is published before it is spooled
+	publishAttempts = 3
+	// maxInFlight caps the votes published but not acknowledged yet, the next
+	// waits for an answer
+	maxInFlight = 1024
+	// ackTimeout is how long stopping waits for the votes in flight to be acknowledged
+	ackTimeout = 10 * time.Second
+)
+
+// Publish latency runs from a vote being handed to the broker to the broker acknowledging it
+var latencyBuckets = []float64{0.001, 0.0025, 0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5}
+
+var (
+	publishFailures = metrics.NewCounter("tweetreader_publish_failures_total",
+		"Votes the broker failed to acknowledge, by what was done with them: retried or spooled.")
+
+	latency     = slo.NewLatency(latencyBuckets)
+	latencyHist *metrics.Histogram
+	latencyOnce sync.Once
+)
+
+// registerLatency exports the publish latency metrics, only in processes publishing to nsqd
+func registerLatency() {
+	latencyOnce.Do(func() {
+		latencyHist = metrics.NewHistogram("tweetreader_publish_latency_seconds",
+			"Time from a message being published to nsqd acknowledging it.", latencyBuckets)
+		metrics.NewGaugeFunc("tweetreader_publish_latency_p50_seconds",
+			"Median publish latency within the SLO window, 0 when nothing was published.",
+			func() float64 { return latency.Quantile(0.5) })
+		metrics.NewGaugeFunc("tweetreader_publish_latency_p99_seconds",
+			"99th percentile of the publish latency within the SLO window, 0 when nothing was published.",
+			func() float64 { return latency.Quantile(0.99) })
+	})
+}
+
+// observeLatency records a publish acknowledged after d
+func observeLatency(d time.Duration) {
+	latency.Observe(d)
+	latencyHist.Observe(d.Seconds())
+}
+
+// ack is the broker's answer to the publish of a vote
+type ack struct {
+	id      string // the vote's message ID, for the logs
+	body    []byte
+	attempt int
+	created time.Time // when the vote's tweet was posted, for the vote latency
+	err     error
+}
+
+// acks collects the answers as they come, without ever blocking the goroutine
+// calling done, which may be the one a synchronous publish waits on
+type acks struct {
+	mu    sync.Mutex
+	done  []ack
+	ready chan struct{}
+}
+
+func newAcks() *acks {
+	return &acks{ready: make(chan struct{}, 1)}
+}
+
+// publish publishes body with pub, its answer is added to a
+func (a *acks) publish(pub AsyncPublisher, id string, body []byte, attempt int, created time.Time) {
+	pub.PublishAsync(body, func(err error) {
+		a.mu.Lock()
+		a.done = append(a.done, ack{id: id, body: body, attempt: attempt, created: created, err: err})
+		a.mu.Unlock()
+		select {
+		case a.ready <- struct{}{}:
+		default:
+		}
+	})
+}
+
+// take returns the answers added since the last call
+func (a *acks) take() []ack {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	done := a.done
+	a.done = nil
+	return done
+}
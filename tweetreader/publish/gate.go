@@ -0,0 +1,43 @@
+package publish
+
+import (
+	"sync"
+	"time"
+)
+
+// Gate decides whether votes go straight to the broker or to the spool.
+// A pause always has a deadline so a forgotten maintenance window
+// can't hold votes back forever.
+type Gate struct {
+	mu    sync.Mutex
+	until time.Time
+}
+
+// Pause holds back publishing for d
+func (g *Gate) Pause(d time.Duration) time.Time {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.until = time.Now().Add(d)
+	return g.until
+}
+
+// Resume lifts any pause straight away
+func (g *Gate) Resume() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.until = time.Time{}
+}
+
+// Paused reports whether publishing is currently paused, and until when
+func (g *Gate) Paused() (bool, time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.until.IsZero() {
+		return false, time.Time{}
+	}
+	if time.Now().After(g.until) {
+		g.until = time.Time{}
+		return false, time.Time{}
+	}
+	return true, g.until
+}
@@ -0,0 +1,145 @@
+package publish
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// KafkaConfig describes the Kafka topic votes are published to and the REST
+// Proxy in front of it
+type KafkaConfig struct {
+	// URL is the REST Proxy's, e.g. http://kafka-rest:8082
+	URL   string
+	Topic string
+	// Username and Password are sent with basic auth, when set
+	Username, Password string
+	TLS                *tls.Config
+	// Timeout bounds every request, 10s by default
+	Timeout time.Duration
+	// Keys returns the IDs of the polls a vote is for, its records are keyed by
+	Keys func(v *match.Vote) []string
+}
+
+// Kafka publishes votes to a Kafka topic through a Confluent REST Proxy, v2
+// API, so no Kafka client is linked in. A vote is a record per poll it is
+// for, keyed by the poll's ID, so every poll's votes land on one partition in
+// the order they were published. A vote for no poll Keys knows of, or one
+// that can't be decoded, is a single record without a key.
+type Kafka struct {
+	url                string // of the topic
+	username, password string
+	keys               func(v *match.Vote) []string
+	client             *http.Client
+}
+
+// NewKafka creates a publisher for the topic of cfg
+func NewKafka(cfg KafkaConfig) (*Kafka, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("publish: invalid kafka REST proxy URL %q", cfg.URL)
+	}
+	if cfg.Topic == "" {
+		return nil, fmt.Errorf("publish: no kafka topic")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &Kafka{
+		url:      strings.TrimSuffix(cfg.URL, "/") + "/topics/" + url.PathEscape(cfg.Topic),
+		username: cfg.Username,
+		password: cfg.Password,
+		keys:     cfg.Keys,
+		client: &http.Client{
+			Timeout:   cfg.Timeout,
+			Transport: &http.Transport{Proxy: http.ProxyFromEnvironment, TLSClientConfig: cfg.TLS},
+		},
+	}, nil
+}
+
+// kafkaRecord is a record of a produce request, its value base64 encoded by
+// encoding/json
+type kafkaRecord struct {
+	Key   []byte `json:"key,omitempty"`
+	Value []byte `json:"value"`
+}
+
+// kafkaOffsets is the answer to a produce request, a record failing has an
+// error instead of an offset
+type kafkaOffsets struct {
+	Offsets []struct {
+		ErrorCode *int   `json:"error_code"`
+		Error     string `json:"error"`
+	} `json:"offsets"`
+}
+
+// records returns the records of the vote b, one per poll it is for
+func (k *Kafka) records(b []byte) []kafkaRecord {
+	if k.keys == nil {
+		return []kafkaRecord{{Value: b}}
+	}
+	var v match.Vote
+	if _, err := codec.Decode(b, &v); err != nil {
+		return []kafkaRecord{{Value: b}}
+	}
+	keys := k.keys(&v)
+	if len(keys) == 0 {
+		return []kafkaRecord{{Value: b}}
+	}
+	records := make([]kafkaRecord, len(keys))
+	for i, key := range keys {
+		records[i] = kafkaRecord{Key: []byte(key), Value: b}
+	}
+	return records
+}
+
+// Publish produces the records of the vote b in a single request. When one
+// fails, publishing b again produces the others a second time.
+func (k *Kafka) Publish(b []byte) error {
+	payload, err := json.Marshal(map[string][]kafkaRecord{"records": k.records(b)})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", k.url, bytes.NewReader(payload))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/vnd.kafka.binary.v2+json")
+	req.Header.Set("Accept", "application/vnd.kafka.v2+json")
+	if k.username != "" {
+		req.SetBasicAuth(k.username, k.password)
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	answer, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("publish: kafka REST proxy answered %s: %s", resp.Status, strings.TrimSpace(string(answer)))
+	}
+	var offsets kafkaOffsets
+	if err := json.Unmarshal(answer, &offsets); err != nil {
+		return fmt.Errorf("publish: unreadable kafka REST proxy answer: %v", err)
+	}
+	for _, o := range offsets.Offsets {
+		if o.ErrorCode != nil {
+			return fmt.Errorf("publish: kafka refused the record (%d): %s", *o.ErrorCode, o.Error)
+		}
+	}
+	return nil
+}
+
+// Stop closes the idle connections to the proxy
+func (k *Kafka) Stop() {
+	k.client.CloseIdleConnections()
+}
@@ -0,0 +1,71 @@
+package publish
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+func TestKafkaKeysTheRecordsByPoll(t *testing.T) {
+	var got []kafkaRecord
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var body struct {
+			Records []kafkaRecord `json:"records"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Errorf("unreadable produce request: %v", err)
+		}
+		got = body.Records
+		w.Write([]byte(`{"offsets":[{"partition":0,"offset":1}]}`))
+	}))
+	defer proxy.Close()
+
+	polls := map[string][]string{"cats": {"p1", "p2"}, "dogs": {"p3"}}
+	keys := func(v *match.Vote) []string { return polls[v.Option] }
+	vote := func(option string) []byte {
+		b, err := codec.Encode(codec.MsgPack, &match.Vote{Option: option})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return b
+	}
+	tests := []struct {
+		name string
+		keys func(v *match.Vote) []string
+		b    []byte
+		want []string // the keys of the records, "" for none
+	}{
+		{"a vote for one poll", keys, vote("dogs"), []string{"p3"}},
+		{"a vote for two polls is a record for each", keys, vote("cats"), []string{"p1", "p2"}},
+		{"a vote for no known poll", keys, vote("birds"), []string{""}},
+		{"a message that isn't a vote", keys, []byte{0, 9, 'x'}, []string{""}},
+		{"without keys", nil, vote("cats"), []string{""}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			k, err := NewKafka(KafkaConfig{URL: proxy.URL, Topic: "votes", Keys: tt.keys})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer k.Stop()
+			if err := k.Publish(tt.b); err != nil {
+				t.Fatal(err)
+			}
+			var keys []string
+			for _, r := range got {
+				keys = append(keys, string(r.Key))
+				if !reflect.DeepEqual(r.Value, tt.b) {
+					t.Errorf("published %q, want the message as is", r.Value)
+				}
+			}
+			if !reflect.DeepEqual(keys, tt.want) {
+				t.Errorf("records keyed %q, want %q", keys, tt.want)
+			}
+		})
+	}
+}
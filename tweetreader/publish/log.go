@@ -0,0 +1,50 @@
+package publish
+
+import (
+	"log"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// Log logs every vote instead of publishing it, for dry runs that check what
+// new options would match before they go live
+type Log struct {
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// NewLog creates a publisher that only logs
+func NewLog() *Log {
+	return &Log{counts: make(map[string]int)}
+}
+
+// Publish decodes the vote and logs it
+func (l *Log) Publish(b []byte) error {
+	var v match.Vote
+	if _, err := codec.Decode(b, &v); err != nil {
+		return err
+	}
+	l.mu.Lock()
+	l.counts[v.Option]++
+	l.mu.Unlock()
+	log.Printf("dry run: vote for %q from @%s (tweet %s): %s", v.Option, v.User.ScreenName, v.ID, strings.Join(strings.Fields(v.Text), " "))
+	return nil
+}
+
+// Stop logs how many votes every option got
+func (l *Log) Stop() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	options := make([]string, 0, len(l.counts))
+	for o := range l.counts {
+		options = append(options, o)
+	}
+	sort.Slice(options, func(i, j int) bool { return l.counts[options[i]] > l.counts[options[j]] })
+	for _, o := range options {
+		log.Printf("dry run: %q got %d votes", o, l.counts[o])
+	}
+}
@@ -0,0 +1,31 @@
+package publish
+
+import "sync"
+
+// Memory hands votes to a consumer in the same process, for running the pipeline without NSQ
+type Memory struct {
+	messages chan []byte
+	once     sync.Once
+}
+
+// NewMemory creates an in-memory publisher buffering up to size messages.
+// Publish blocks while the buffer is full, so a slow consumer slows the producer down.
+func NewMemory(size int) *Memory {
+	return &Memory{messages: make(chan []byte, size)}
+}
+
+// Publish queues a message for the consumer, it must not be called after Stop
+func (m *Memory) Publish(b []byte) error {
+	m.messages <- b
+	return nil
+}
+
+// Messages is where the consumer reads the published messages, it is closed by Stop
+func (m *Memory) Messages() <-chan []byte {
+	return m.messages
+}
+
+// Stop closes the message channel once the consumer has read what is queued
+func (m *Memory) Stop() {
+	m.once.Do(func() { close(m.messages) })
+}
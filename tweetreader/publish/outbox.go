@@ -0,0 +1,167 @@
+package publish
+
+import (
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var (
+	outboxAdded = metrics.NewCounter("tweetreader_outbox_added_total",
+		"Vote messages added to the outbox, by topic.")
+	outboxRelayed = metrics.NewCounter("tweetreader_outbox_relayed_total",
+		"Vote messages the relay published from the outbox and the broker acknowledged, by topic.")
+	outboxFailures = metrics.NewCounter("tweetreader_outbox_relay_failures_total",
+		"Relay passes stopped by a failure to publish or to remove the published messages.")
+	outboxPending = metrics.NewGauge("tweetreader_outbox_pending",
+		"Vote messages waiting in the outbox at the relay's last pass.")
+	outboxLag = metrics.NewGauge("tweetreader_outbox_lag_seconds",
+		"Age of the oldest message waiting in the outbox at the relay's last pass.")
+)
+
+// Outbox adds the messages to the store's outbox for a Relay to publish,
+// instead of publishing them to the broker itself
+type Outbox struct {
+	store store.OutboxStore
+	topic string
+}
+
+// NewOutbox creates a publisher adding the messages for topic to the outbox of s
+func NewOutbox(s store.OutboxStore, topic string) *Outbox {
+	return &Outbox{store: s, topic: topic}
+}
+
+// Publish adds a single message to the outbox
+func (o *Outbox) Publish(b []byte) error {
+	if err := o.store.AddToOutbox(o.topic, b); err != nil {
+		return err
+	}
+	outboxAdded.Inc("topic", o.topic)
+	return nil
+}
+
+// Stop does nothing, the store is closed by its owner
+func (o *Outbox) Stop() {}
+
+// RelayConfig tunes a Relay, zero values keep the defaults
+type RelayConfig struct {
+	// Batch is how many messages are read from the outbox at once, 100 by default
+	Batch int
+	// Every is how long the relay waits once the outbox is empty, 1s by default
+	Every time.Duration
+}
+
+// Relay publishes the messages of an outbox to the broker, in the order they
+// were added, and removes them once the broker has acknowledged them. A
+// message published but not removed, because the relay died in between, is
+// published again, so consumers see each message at least once and skip the
+// duplicates by their vote's ID.
+type Relay struct {
+	outbox store.OutboxStore
+	open   func(topic string) (Publisher, error)
+	cfg    RelayConfig
+	pubs   map[string]Publisher
+}
+
+// NewRelay creates a relay of outbox publishing each topic with the publisher open creates for it
+func NewRelay(outbox store.OutboxStore, open func(topic string) (Publisher, error), cfg RelayConfig) *Relay {
+	if cfg.Batch <= 0 {
+		cfg.Batch = 100
+	}
+	if cfg.Every <= 0 {
+		cfg.Every = time.Second
+	}
+	return &Relay{outbox: outbox, open: open, cfg: cfg, pubs: make(map[string]Publisher)}
+}
+
+// Relay publishes the outbox's messages until it is empty, returning how
+// many were. It stops at the first message that fails, which is left in the
+// outbox with the ones after it.
+func (r *Relay) Relay() (int, error) {
+	relayed := 0
+	defer r.observe()
+	for {
+		msgs, err := r.outbox.PendingOutbox(r.cfg.Batch)
+		if err != nil {
+			return relayed, err
+		}
+		done := make([]string, 0, len(msgs))
+		var failed error
+		for _, msg := range msgs {
+			if failed = r.publish(msg); failed != nil {
+				break
+			}
+			done = append(done, msg.ID)
+		}
+		if err := r.outbox.RemoveFromOutbox(done); err != nil {
+			// published again by the next pass
+			outboxFailures.Inc()
+			return relayed, err
+		}
+		relayed += len(done)
+		if failed != nil {
+			outboxFailures.Inc()
+			return relayed, failed
+		}
+		if len(msgs) < r.cfg.Batch {
+			return relayed, nil
+		}
+	}
+}
+
+// publish publishes msg to its topic, opening the topic's publisher the first time
+func (r *Relay) publish(msg store.OutboxMessage) error {
+	pub, ok := r.pubs[msg.Topic]
+	if !ok {
+		var err error
+		if pub, err = r.open(msg.Topic); err != nil {
+			return err
+		}
+		r.pubs[msg.Topic] = pub
+	}
+	if err := pub.Publish(msg.Body); err != nil {
+		return err
+	}
+	outboxRelayed.Inc("topic", msg.Topic)
+	return nil
+}
+
+// observe sets the pending and lag gauges from what the outbox has left
+func (r *Relay) observe() {
+	n, err := r.outbox.OutboxSize()
+	if err != nil {
+		return
+	}
+	outboxPending.Set(float64(n))
+	lag := 0.0
+	if n > 0 {
+		if oldest, err := r.outbox.PendingOutbox(1); err == nil && len(oldest) == 1 {
+			lag = time.Since(oldest[0].Added).Seconds()
+		}
+	}
+	outboxLag.Set(lag)
+}
+
+// Run relays the outbox, waiting Every whenever it is empty or a pass failed,
+// until stop is closed
+func (r *Relay) Run(stop <-chan struct{}) {
+	for {
+		if n, err := r.Relay(); err != nil {
+			log.Printf("relay: failed after %d messages, retrying in %v: %v", n, r.cfg.Every, err)
+		}
+		select {
+		case <-stop:
+			return
+		case <-time.After(r.cfg.Every):
+		}
+	}
+}
+
+// Stop stops the publishers of the topics, call it once Run has returned
+func (r *Relay) Stop() {
+	for _, pub := range r.pubs {
+		pub.Stop()
+	}
+}
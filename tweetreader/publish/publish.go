@@ -0,0 +1,279 @@
+// Package publish sends votes on to the broker.
+package publish
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+	"github.com/olawolu/twitter-polls/tweetreader/budget"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/deadletter"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// Publisher delivers encoded vote messages to a broker
+type Publisher interface {
+	Publish(b []byte) error
+	Stop()
+}
+
+// NSQ publishes votes to a topic on nsqd. A producer stuck disconnected, as
+// they can be after nsqd restarts, is replaced by a new one, see Watch.
+type NSQ struct {
+	addr     string
+	cfg      *nsq.Config
+	topic    string
+	acks     chan *nsq.ProducerTransaction // the answers to PublishAsync
+	stopOnce sync.Once
+	stop     chan struct{}
+
+	mu       sync.Mutex
+	producer *nsq.Producer
+	retired  sync.WaitGroup // the producers replaced, while they stop
+}
+
+// NewNSQ creates a publisher for topic on the nsqd at addr, connecting with cfg
+func NewNSQ(addr, topic string, cfg *nsq.Config) (*NSQ, error) {
+	producer, err := nsq.NewProducer(addr, cfg)
+	if err != nil {
+		return nil, err
+	}
+	registerLatency()
+	n := &NSQ{addr: addr, cfg: cfg, topic: topic, producer: producer,
+		acks: make(chan *nsq.ProducerTransaction, maxInFlight), stop: make(chan struct{})}
+	go n.answer()
+	return n, nil
+}
+
+// current returns the producer publishing now
+func (n *NSQ) current() *nsq.Producer {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.producer
+}
+
+// Publish publishes a single message
+func (n *NSQ) Publish(b []byte) error {
+	start := time.Now()
+	p := n.current()
+	err := p.Publish(n.topic, b)
+	if err == nil {
+		observeLatency(time.Since(start))
+	}
+	n.failed(p, err)
+	return err
+}
+
+// PublishAsync publishes a single message without waiting for nsqd to acknowledge it
+func (n *NSQ) PublishAsync(b []byte, done func(error)) {
+	p := n.current()
+	if err := p.PublishAsync(n.topic, b, n.acks, done, time.Now()); err != nil {
+		n.failed(p, err)
+		done(err)
+	}
+}
+
+// answer tells the messages published with PublishAsync nsqd's answer, until Stop
+func (n *NSQ) answer() {
+	for t := range n.acks {
+		if t.Error == nil {
+			observeLatency(time.Since(t.Args[1].(time.Time)))
+		}
+		t.Args[0].(func(error))(t.Error)
+	}
+}
+
+// DeferredPublish publishes a single message nsqd delivers after delay
+func (n *NSQ) DeferredPublish(b []byte, delay time.Duration) error {
+	p := n.current()
+	err := p.DeferredPublish(n.topic, delay, b)
+	n.failed(p, err)
+	return err
+}
+
+// Stop disconnects from nsqd, the messages not acknowledged yet fail
+func (n *NSQ) Stop() {
+	n.stopOnce.Do(func() {
+		close(n.stop)
+		// a producer has answered every message once stopped
+		n.current().Stop()
+		n.retired.Wait()
+		close(n.acks)
+	})
+}
+
+// Run publishes every vote received on votes until the channel is closed.
+// While the gate is paused, votes are spooled to disk instead of published
+// and the spool is drained back to the broker once publishing resumes.
+// Votes are encoded with c.
+// When br is set, repeated publish failures open it and votes are spooled
+// until a probe, draining the spool or publishing a vote, succeeds.
+// Votes that can't be encoded are sent to dead.
+// With a Router every vote is addressed to its topics before it is published or spooled.
+// An AsyncPublisher publishes the votes without waiting for each answer: a vote
+// the broker failed to acknowledge is published again, up to publishAttempts
+// times, and then spooled.
+// The returned channel is signalled once the publisher has stopped.
+func Run(votes <-chan match.Vote, pub Publisher, gate *Gate, sp *Spool, c codec.Codec, br *breaker.Breaker, dead *deadletter.Sink) <-chan struct{} {
+	stopchan := make(chan struct{}, 1)
+	registerSLO()
+	registerVoteLatency()
+	router, _ := pub.(*Router)
+	drain := func() error {
+		if sp.Size() == 0 {
+			return nil
+		}
+		log.Println("Publisher: draining spool")
+		events.Record(events.SpoolDraining, fmt.Sprintf("%d bytes spooled", sp.Size()))
+		err := sp.Drain(pub.Publish)
+		if err != nil {
+			budget.Logf("publish", "Publisher: failed to drain spool: %v", err)
+		}
+		return err
+	}
+	// spool keeps a vote the broker can't take now, it is lost if the spool is full
+	spool := func(b []byte) {
+		err := sp.Write(b)
+		ingestion.Observe(err == nil)
+		if err != nil {
+			budget.Logf("publish", "Publisher: failed to spool: %v", err)
+		}
+	}
+	async, _ := pub.(AsyncPublisher)
+	answers := newAcks()
+	inFlight := 0
+	// acknowledged handles the broker's answers, retrying the failures unless stopping
+	acknowledged := func(stopping bool) {
+		for _, a := range answers.take() {
+			inFlight--
+			if a.err == nil {
+				br.Success()
+				budget.Observe("publish", true)
+				ingestion.Observe(true)
+				observeVoteLatency(a.created, time.Now())
+				continue
+			}
+			budget.Logf("publish", "Publisher: failed to publish vote %s (attempt %d): %v", a.id, a.attempt, a.err)
+			br.Failure(a.err)
+			budget.Observe("publish", false)
+			if !stopping && a.attempt < publishAttempts && br.Allow() {
+				publishFailures.Inc("outcome", "retried")
+				inFlight++
+				answers.publish(async, a.id, a.body, a.attempt+1, a.created)
+				continue
+			}
+			publishFailures.Inc("outcome", "spooled")
+			spool(a.body)
+		}
+	}
+	go func() {
+		ticker := time.NewTicker(1 * time.Second)
+		defer ticker.Stop()
+	loop:
+		for {
+			select {
+			case vote, ok := <-votes:
+				if !ok {
+					break loop
+				}
+				b, err := codec.Encode(c, &vote)
+				if err != nil {
+					log.Println("Marshall error: ", err)
+					ingestion.Observe(false)
+					dead.Send(deadletter.EncodeFailed, err, nil, &vote)
+					continue
+				}
+				if router != nil {
+					b = router.Address(&vote, b)
+				}
+				if paused, _ := gate.Paused(); paused {
+					if err := sp.Write(b); err == nil {
+						ingestion.Observe(true)
+						continue
+					}
+					// never drop a vote because the spool filled up,
+					// end the maintenance window early instead
+					log.Println("Publisher: spool full, resuming publishing")
+					gate.Resume()
+				}
+				if !br.Allow() {
+					// not published either, the broker is failing
+					budget.Observe("publish", false)
+					spool(b)
+					continue
+				}
+				if err := drain(); err != nil {
+					br.Failure(err)
+					budget.Observe("publish", false)
+					spool(b)
+					continue
+				}
+				// publish votes
+				if async != nil {
+					for inFlight >= maxInFlight {
+						<-answers.ready
+						acknowledged(false)
+					}
+					inFlight++
+					answers.publish(async, messageID(&vote), b, 1, createdAt(&vote))
+					continue
+				}
+				if err := pub.Publish(b); err != nil {
+					budget.Logf("publish", "Publisher: failed to publish: %v", err)
+					br.Failure(err)
+					budget.Observe("publish", false)
+					spool(b)
+					continue
+				}
+				br.Success()
+				budget.Observe("publish", true)
+				ingestion.Observe(true)
+				observeVoteLatency(createdAt(&vote), time.Now())
+			case <-answers.ready:
+				acknowledged(false)
+			case <-ticker.C:
+				// with nothing spooled the next vote is the probe
+				if paused, _ := gate.Paused(); !paused && sp.Size() > 0 && br.Allow() {
+					if err := drain(); err != nil {
+						br.Failure(err)
+					} else {
+						br.Success()
+					}
+				}
+			}
+		}
+		// the votes in flight are spooled when they fail now, or when they aren't answered in time
+		timeout := time.After(ackTimeout)
+		for inFlight > 0 {
+			select {
+			case <-answers.ready:
+				acknowledged(true)
+			case <-timeout:
+				log.Printf("Publisher: %d votes not acknowledged in %s", inFlight, ackTimeout)
+				inFlight = 0
+			}
+		}
+		if paused, _ := gate.Paused(); !paused && br.State() != breaker.Open {
+			drain()
+		}
+		log.Println("Publisher: Stopping")
+		pub.Stop()
+		log.Println("Publisher: Stopped")
+		stopchan <- struct{}{}
+	}()
+	return stopchan
+}
+
+// messageID returns the vote's message ID, what its failures are logged with
+func messageID(v *match.Vote) string {
+	if v.MessageID != "" {
+		return v.MessageID
+	}
+	return match.MessageID(v.ID, v.Option)
+}
@@ -0,0 +1,100 @@
+package publish
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// What a Queue does with a vote when it is full
+const (
+	// Block waits for room, slowing the matcher and the stream reader down
+	Block = "block"
+	// DropOldest makes room by dropping the vote that waited longest
+	DropOldest = "drop-oldest"
+	// DropNew drops the vote that doesn't fit
+	DropNew = "drop-new"
+)
+
+var (
+	queueDropped = metrics.NewCounter("tweetreader_votes_queue_dropped_total",
+		"Votes dropped because the queue in front of the publisher was full, by overflow policy.")
+	queueBlocked = metrics.NewCounter("tweetreader_votes_queue_blocked_seconds_total",
+		"Time the matcher waited for room in the queue in front of the publisher.")
+)
+
+// Queue buffers votes between the matcher and the publisher, so a slow broker
+// doesn't hold up reading the stream until the buffer is full, and then applies
+// its overflow policy
+type Queue struct {
+	out    chan match.Vote
+	policy string
+}
+
+// NewQueue creates a queue holding up to size votes, applying policy when it is full
+func NewQueue(size int, policy string) (*Queue, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("publish: queue size must be positive")
+	}
+	switch policy {
+	case Block, DropOldest, DropNew:
+	default:
+		return nil, fmt.Errorf("publish: unknown overflow policy %q, want %s, %s or %s", policy, Block, DropOldest, DropNew)
+	}
+	q := &Queue{out: make(chan match.Vote, size), policy: policy}
+	metrics.NewGaugeFunc("tweetreader_votes_queue_depth", "Votes waiting in the queue in front of the publisher.", func() float64 {
+		return float64(len(q.out))
+	})
+	metrics.NewGaugeFunc("tweetreader_votes_queue_capacity", "Votes the queue in front of the publisher holds.", func() float64 {
+		return float64(cap(q.out))
+	})
+	return q, nil
+}
+
+// Fill returns the share of the queue taken, 1 when full
+func (q *Queue) Fill() float64 {
+	return float64(len(q.out)) / float64(cap(q.out))
+}
+
+// Run queues the votes from in on the returned channel, which is closed once in is
+func (q *Queue) Run(in <-chan match.Vote) <-chan match.Vote {
+	go func() {
+		defer close(q.out)
+		for v := range in {
+			q.put(v)
+		}
+	}()
+	return q.out
+}
+
+// put adds v, Run is the only sender so room made here stays free
+func (q *Queue) put(v match.Vote) {
+	select {
+	case q.out <- v:
+		return
+	default:
+	}
+	switch q.policy {
+	case DropNew:
+		queueDropped.Inc("policy", q.policy)
+	case DropOldest:
+		for {
+			select {
+			case q.out <- v:
+				return
+			default:
+			}
+			select {
+			case <-q.out:
+				queueDropped.Inc("policy", q.policy)
+			default:
+			}
+		}
+	default:
+		start := time.Now()
+		q.out <- v
+		queueBlocked.Add(time.Since(start).Seconds())
+	}
+}
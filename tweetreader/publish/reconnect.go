@@ -0,0 +1,95 @@
+package publish
+
+import (
+	"log"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// go-nsq's producer connects on the first publish and again on the first one
+// after losing its connection. When nsqd restarts while messages are in
+// flight it can be left disconnected for good, failing every publish with
+// ErrNotConnected, so such a producer is replaced by a new one. Run spools the
+// votes while publishing fails and drains the spool once it works again.
+
+var (
+	nsqdUp = metrics.NewGauge("tweetreader_publish_nsqd_up",
+		"Whether the last health check of the connection to nsqd succeeded, by topic.")
+	producerReplacements = metrics.NewCounter("tweetreader_publish_producer_replacements_total",
+		"Producers replaced because they were stuck disconnected from nsqd, by topic.")
+)
+
+// failed replaces p, the producer that failed with err, when it is stuck
+// disconnected; a publish racing it already used the new one
+func (n *NSQ) failed(p *nsq.Producer, err error) {
+	if err != nsq.ErrNotConnected && err != nsq.ErrStopped {
+		return
+	}
+	select {
+	case <-n.stop:
+		return
+	default:
+	}
+	fresh, ferr := nsq.NewProducer(n.addr, n.cfg)
+	if ferr != nil {
+		log.Printf("Publisher: failed to replace the %s producer: %v", n.topic, ferr)
+		return
+	}
+	n.mu.Lock()
+	if n.producer != p {
+		n.mu.Unlock()
+		return
+	}
+	n.producer = fresh
+	n.retired.Add(1)
+	n.mu.Unlock()
+	log.Printf("Publisher: the %s producer is stuck disconnected from nsqd (%v), replaced it", n.topic, err)
+	producerReplacements.Inc("topic", n.topic)
+	go func() {
+		defer n.retired.Done()
+		p.Stop()
+	}()
+}
+
+// Watch checks the connection to nsqd every interval until Stop, connecting
+// again when it was lost, so publishing works again before the next vote
+// comes, and replacing the producer when it is stuck disconnected
+func (n *NSQ) Watch(interval time.Duration) {
+	if interval <= 0 {
+		return
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		up := true
+		for {
+			select {
+			case <-n.stop:
+				return
+			case <-t.C:
+			}
+			p := n.current()
+			err := p.Ping()
+			nsqdUp.Set(boolValue(err == nil), "topic", n.topic)
+			if err != nil {
+				if up {
+					log.Printf("Publisher: nsqd unreachable for %s: %v", n.topic, err)
+				}
+				n.failed(p, err)
+			} else if !up {
+				log.Printf("Publisher: connected to nsqd again for %s", n.topic)
+			}
+			up = err == nil
+		}
+	}()
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,189 @@
+package publish
+
+import (
+	"errors"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var routed = metrics.NewCounter("tweetreader_routed_votes_total",
+	"Votes published to the topics they were routed to, by topic.")
+
+// routedMark starts the messages a Router addresses: a byte neither JSON
+// nor a framed codec message starts with, see codec, then the topics.
+//
+//	0x01 count (len(topic) topic)... message
+//
+// The address is only ever seen by the Router, it is stripped before the
+// message is published, and it survives the spool.
+const routedMark = 0x01
+
+// errBadAddress is returned for a routed message that is cut short
+var errBadAddress = errors.New("publish: routed message with a bad address")
+
+// Router publishes each vote to the topics its route picks, opening a
+// publisher per topic the first time it is used. Votes it has no route for
+// go to the default publisher.
+type Router struct {
+	def   Publisher
+	route func(v *match.Vote) []string
+	open  func(topic string) (Publisher, error)
+
+	mu   sync.Mutex
+	pubs map[string]Publisher
+}
+
+// NewRouter creates a Router publishing on the topics route returns for a
+// vote, with the publishers open creates, and with def when route returns none
+func NewRouter(def Publisher, route func(v *match.Vote) []string, open func(topic string) (Publisher, error)) *Router {
+	return &Router{def: def, route: route, open: open, pubs: make(map[string]Publisher)}
+}
+
+// Address prefixes the encoded vote b with the topics of v
+func (r *Router) Address(v *match.Vote, b []byte) []byte {
+	topics := r.route(v)
+	if len(topics) == 0 {
+		return b
+	}
+	if len(topics) > 255 {
+		topics = topics[:255]
+	}
+	n := 2 + len(b)
+	for _, t := range topics {
+		n += 1 + len(t)
+	}
+	out := make([]byte, 0, n)
+	out = append(out, routedMark, byte(len(topics)))
+	for _, t := range topics {
+		if len(t) > 255 {
+			t = t[:255]
+		}
+		out = append(out, byte(len(t)))
+		out = append(out, t...)
+	}
+	return append(out, b...)
+}
+
+// Publish publishes b on the topics it is addressed to, or with the default
+// publisher. A failure stops at the topic that failed, and publishing the
+// message again publishes it to the topics before it a second time.
+func (r *Router) Publish(b []byte) error {
+	topics, rest, err := parseAddress(b)
+	if err != nil {
+		return err
+	}
+	if topics == nil {
+		return r.def.Publish(b)
+	}
+	for _, t := range topics {
+		pub, err := r.publisher(t)
+		if err != nil {
+			return err
+		}
+		if err := pub.Publish(rest); err != nil {
+			return err
+		}
+		routed.Inc("topic", t)
+	}
+	return nil
+}
+
+// PublishAsync publishes b as Publish does, without waiting for the answers
+// of the publishers that are AsyncPublishers. Every topic is published to, and
+// done is told the first failure once they all answered; publishing the
+// message again publishes it to the topics that took it a second time.
+func (r *Router) PublishAsync(b []byte, done func(error)) {
+	topics, rest, err := parseAddress(b)
+	if err != nil {
+		done(err)
+		return
+	}
+	if topics == nil {
+		topics, rest = []string{""}, b
+	}
+	if len(topics) == 0 {
+		done(nil)
+		return
+	}
+	var mu sync.Mutex
+	left := len(topics)
+	var failed error
+	answered := func(topic string, err error) {
+		mu.Lock()
+		if err != nil && failed == nil {
+			failed = err
+		}
+		left--
+		last, first := left == 0, failed
+		mu.Unlock()
+		if err == nil && topic != "" {
+			routed.Inc("topic", topic)
+		}
+		if last {
+			done(first)
+		}
+	}
+	for _, t := range topics {
+		t := t
+		pub := r.def
+		if t != "" {
+			if pub, err = r.publisher(t); err != nil {
+				answered(t, err)
+				continue
+			}
+		}
+		if async, ok := pub.(AsyncPublisher); ok {
+			async.PublishAsync(rest, func(err error) { answered(t, err) })
+			continue
+		}
+		answered(t, pub.Publish(rest))
+	}
+}
+
+// parseAddress splits a message the Router addressed into its topics and the
+// message, nil topics when b isn't addressed
+func parseAddress(b []byte) ([]string, []byte, error) {
+	if len(b) == 0 || b[0] != routedMark {
+		return nil, b, nil
+	}
+	if len(b) < 2 {
+		return nil, nil, errBadAddress
+	}
+	count, rest := int(b[1]), b[2:]
+	topics := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+			return nil, nil, errBadAddress
+		}
+		topics = append(topics, string(rest[1:1+int(rest[0])]))
+		rest = rest[1+int(rest[0]):]
+	}
+	return topics, rest, nil
+}
+
+// publisher returns the publisher of topic, opening it the first time
+func (r *Router) publisher(topic string) (Publisher, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pub, ok := r.pubs[topic]; ok {
+		return pub, nil
+	}
+	pub, err := r.open(topic)
+	if err != nil {
+		return nil, err
+	}
+	r.pubs[topic] = pub
+	return pub, nil
+}
+
+// Stop stops the default publisher and the ones opened for the topics
+func (r *Router) Stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, pub := range r.pubs {
+		pub.Stop()
+	}
+	r.def.Stop()
+}
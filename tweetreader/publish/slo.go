@@ -0,0 +1,22 @@
+package publish
+
+import (
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/slo"
+)
+
+// ingestion is the share of matched votes that reached the broker or the spool
+var (
+	ingestion     slo.Ratio
+	ingestionOnce sync.Once
+)
+
+// registerSLO exports the ingestion ratio, only in processes that publish
+func registerSLO() {
+	ingestionOnce.Do(func() {
+		metrics.NewGaugeFunc("tweetreader_slo_vote_ingestion_ratio",
+			"Share of matched votes published or spooled within the SLO window, 1 when there were none.", ingestion.Value)
+	})
+}
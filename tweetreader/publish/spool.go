@@ -0,0 +1,220 @@
+package publish
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ErrSpoolFull is returned when a write would take the spool past its size limit
+var ErrSpoolFull = errors.New("spool is full")
+
+// offsetEvery is how many messages a drain delivers between two saves of its offset
+const offsetEvery = 100
+
+// Spool is an append-only file of encoded vote messages.
+// Votes are written to it while publishing is paused and replayed
+// to the broker once publishing resumes, so a broker maintenance window
+// doesn't lose any live votes. A drain saves how far it got next to the
+// file as it goes, so one cut short by a crash isn't replayed from the start.
+type Spool struct {
+	mu   sync.Mutex
+	path string
+	max  int64 // maximum size of the spool file in bytes, 0 means unbounded
+	size int64
+	f    *os.File
+}
+
+// OpenSpool opens (or creates) the spool file in dir.
+// Anything left over from a previous run is kept and replayed on the next drain.
+func OpenSpool(dir string, max int64) (*Spool, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Spool{path: filepath.Join(dir, "votes.spool"), max: max}
+	if err := s.recover(); err != nil {
+		return nil, err
+	}
+	if err := s.open(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// offsetPath is where a drain saves how many bytes of the spool it delivered
+func (s *Spool) offsetPath() string {
+	return s.path + ".offset"
+}
+
+// recover drops what a drain cut short by a crash had delivered already
+func (s *Spool) recover() error {
+	b, err := ioutil.ReadFile(s.offsetPath())
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	offset, err := strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	if err == nil && offset > 0 {
+		return s.dropDelivered(offset)
+	}
+	return os.Remove(s.offsetPath())
+}
+
+// dropDelivered rewrites the spool without its first offset bytes
+func (s *Spool) dropDelivered(offset int64) error {
+	r, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		return os.Remove(s.offsetPath())
+	}
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	if _, err := r.Seek(offset, io.SeekStart); err != nil {
+		return err
+	}
+	tmp := s.path + ".tmp"
+	w, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	r.Close()
+	// without the offset a crash now replays the spool again, rather than
+	// dropping offset bytes from one that was rewritten already
+	if err := os.Remove(s.offsetPath()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+func (s *Spool) open() error {
+	f, err := os.OpenFile(s.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	s.f = f
+	s.size = info.Size()
+	return nil
+}
+
+// Write appends a single message to the spool
+func (s *Spool) Write(b []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n := int64(len(b) + 1)
+	if s.max > 0 && s.size+n > s.max {
+		return ErrSpoolFull
+	}
+	if _, err := s.f.Write(append(b, '\n')); err != nil {
+		return err
+	}
+	s.size += n
+	return nil
+}
+
+// Size returns the number of bytes currently held in the spool
+func (s *Spool) Size() int64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.size
+}
+
+// Drain passes every spooled message to fn in the order they were written.
+// If fn fails, or the spool can't be read, the failed message and everything
+// after it stay in the spool.
+func (s *Spool) Drain(fn func([]byte) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.size == 0 {
+		return nil
+	}
+	r, err := os.Open(s.path)
+	if err != nil {
+		// the spool is still open for writing, the next drain tries again
+		return err
+	}
+	var (
+		br        = bufio.NewReader(r)
+		failed    error
+		delivered int64 // bytes of the spool delivered
+		sinceSave int
+	)
+	for {
+		line, err := br.ReadBytes('\n')
+		if err != nil && err != io.EOF {
+			failed = err
+			break
+		}
+		if msg := bytes.TrimSuffix(line, []byte{'\n'}); len(msg) > 0 {
+			if failed = fn(msg); failed != nil {
+				break
+			}
+			if sinceSave++; sinceSave == offsetEvery {
+				sinceSave = 0
+				s.saveOffset(delivered + int64(len(line)))
+			}
+		}
+		delivered += int64(len(line))
+		if err == io.EOF {
+			break
+		}
+	}
+	r.Close()
+	if delivered == 0 {
+		return failed
+	}
+
+	// what wasn't delivered is everything after delivered, a crash from here
+	// on may replay it but never what was delivered
+	s.f.Close()
+	if failed == nil {
+		err = os.Truncate(s.path, 0)
+		if err == nil {
+			os.Remove(s.offsetPath())
+		}
+	} else {
+		err = s.dropDelivered(delivered)
+	}
+	// reopened whatever happened, so writing to the spool goes on
+	if openErr := s.open(); err == nil {
+		err = openErr
+	}
+	if err != nil {
+		return err
+	}
+	return failed
+}
+
+// saveOffset saves how many bytes of the spool a drain delivered, for
+// recover; a drain that fails to is only replayed from further back
+func (s *Spool) saveOffset(delivered int64) {
+	ioutil.WriteFile(s.offsetPath(), []byte(strconv.FormatInt(delivered, 10)), 0644)
+}
+
+// Close closes the spool file
+func (s *Spool) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.f.Close()
+}
@@ -0,0 +1,132 @@
+package publish
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+)
+
+// long is a message longer than a line a bufio.Scanner reads by default
+var long = strings.Repeat("x", 2<<20)
+
+func TestSpoolDrain(t *testing.T) {
+	tests := []struct {
+		name     string
+		messages []string
+		failAt   int    // the message fn fails on the first drain, -1 for none
+		offset   string // saved by a drain cut short before the spool is opened, "" for none
+		first    []string
+		kept     int64 // bytes left after the first drain
+	}{
+		{"every message delivered", []string{"a", "bb", "ccc"}, -1, "", []string{"a", "bb", "ccc"}, 0},
+		{"the failed message and the rest kept", []string{"a", "bb", "ccc"}, 1, "", []string{"a"}, 7},
+		{"failing on the first keeps all", []string{"a", "bb"}, 0, "", nil, 5},
+		{"what a crashed drain delivered dropped", []string{"a", "bb", "ccc"}, -1, "2", []string{"bb", "ccc"}, 0},
+		{"an unreadable offset replays all", []string{"a", "bb"}, -1, "junk", []string{"a", "bb"}, 0},
+		{"a message over a megabyte delivered", []string{"a", long, "bb"}, -1, "", []string{"a", long, "bb"}, 0},
+		{"a message over a megabyte kept", []string{"a", long, "bb"}, 1, "", []string{"a"}, int64(len(long) + 4)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			sp, err := OpenSpool(dir, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, m := range tt.messages {
+				if err := sp.Write([]byte(m)); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if tt.offset != "" {
+				sp.Close()
+				if err := ioutil.WriteFile(sp.offsetPath(), []byte(tt.offset), 0644); err != nil {
+					t.Fatal(err)
+				}
+				if sp, err = OpenSpool(dir, 0); err != nil {
+					t.Fatal(err)
+				}
+			}
+			defer sp.Close()
+
+			var got []string
+			calls := 0
+			err = sp.Drain(func(b []byte) error {
+				if calls++; calls-1 == tt.failAt {
+					return errors.New("broker down")
+				}
+				got = append(got, string(b))
+				return nil
+			})
+			if (err != nil) != (tt.failAt >= 0) {
+				t.Fatalf("Drain = %v", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.first) {
+				t.Errorf("delivered %q, want %q", got, tt.first)
+			}
+			if sp.Size() != tt.kept {
+				t.Errorf("%d bytes kept, want %d", sp.Size(), tt.kept)
+			}
+
+			// the next drain delivers what was kept, and messages written meanwhile after it
+			if err := sp.Write([]byte("later")); err != nil {
+				t.Fatal(err)
+			}
+			var second []string
+			if err := sp.Drain(func(b []byte) error { second = append(second, string(b)); return nil }); err != nil {
+				t.Fatal(err)
+			}
+			want := []string{"later"}
+			if tt.failAt >= 0 {
+				want = append(append([]string(nil), tt.messages[tt.failAt:]...), "later")
+			}
+			if fmt.Sprint(second) != fmt.Sprint(want) {
+				t.Errorf("then delivered %q, want %q", second, want)
+			}
+			if sp.Size() != 0 {
+				t.Errorf("%d bytes left once drained", sp.Size())
+			}
+		})
+	}
+}
+
+func TestSpoolWritesAfterAFailedDrain(t *testing.T) {
+	sp, err := OpenSpool(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	if err := sp.Write([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	// the spool can't be opened to be read
+	if err := os.Remove(sp.path); err != nil {
+		t.Fatal(err)
+	}
+	if err := sp.Drain(func([]byte) error { return nil }); err == nil {
+		t.Fatal("Drain of a missing spool succeeded")
+	}
+	if err := sp.Write([]byte("b")); err != nil {
+		t.Errorf("Write after a failed drain: %v", err)
+	}
+}
+
+func TestSpoolFull(t *testing.T) {
+	sp, err := OpenSpool(t.TempDir(), 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sp.Close()
+	// each takes 5 bytes with its newline
+	for i, want := range []error{nil, nil, ErrSpoolFull} {
+		if err := sp.Write([]byte("abcd")); err != want {
+			t.Fatalf("write %d: %v, want %v", i, err, want)
+		}
+	}
+	if sp.Size() != 10 {
+		t.Errorf("%d bytes spooled, want 10", sp.Size())
+	}
+}
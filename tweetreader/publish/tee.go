@@ -0,0 +1,27 @@
+package publish
+
+import "github.com/olawolu/twitter-polls/tweetreader/match"
+
+// Tee copies every vote from in to n channels, one per publisher, each
+// buffering up to buffer votes. A publisher whose broker is down spools its
+// copies and keeps taking them, so it doesn't hold the others up; one slow to
+// answer does once its buffer is full. The channels are closed once in is.
+func Tee(in <-chan match.Vote, n, buffer int) []<-chan match.Vote {
+	outs := make([]chan match.Vote, n)
+	recv := make([]<-chan match.Vote, n)
+	for i := range outs {
+		outs[i] = make(chan match.Vote, buffer)
+		recv[i] = outs[i]
+	}
+	go func() {
+		for v := range in {
+			for _, out := range outs {
+				out <- v
+			}
+		}
+		for _, out := range outs {
+			close(out)
+		}
+	}()
+	return recv
+}
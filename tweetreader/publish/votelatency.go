@@ -0,0 +1,114 @@
+package publish
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/slo"
+)
+
+// Vote latency runs from a tweet being posted to the broker acknowledging its vote.
+// The timestamps are to the second, so the buckets start at a second.
+var voteLatencyBuckets = []float64{1, 2, 5, 10, 30, 60, 120, 300, 600}
+
+// budgetCheckEvery is how often the vote latency is compared to the budget
+const budgetCheckEvery = 10 * time.Second
+
+var (
+	voteLatency     = slo.NewLatency(voteLatencyBuckets)
+	voteLatencyHist *metrics.Histogram
+	voteLatencyOnce sync.Once
+	overBudget      *metrics.Metric
+
+	latencyBudget struct {
+		sync.Mutex
+		max      time.Duration
+		over     func(p95 time.Duration)
+		checked  time.Time
+		exceeded bool
+	}
+)
+
+// registerVoteLatency exports the vote latency metrics, only in processes that publish votes
+func registerVoteLatency() {
+	voteLatencyOnce.Do(func() {
+		voteLatencyHist = metrics.NewHistogram("tweetreader_vote_latency_seconds",
+			"Time from a tweet being posted to the broker acknowledging its vote.", voteLatencyBuckets)
+		overBudget = metrics.NewGauge("tweetreader_vote_latency_over_budget",
+			"1 while the 95th percentile of the vote latency is over the latency budget.")
+		for _, q := range []struct {
+			name string
+			q    float64
+			help string
+		}{
+			{"p50", 0.5, "Median"},
+			{"p95", 0.95, "95th percentile"},
+			{"p99", 0.99, "99th percentile"},
+		} {
+			q := q
+			metrics.NewGaugeFunc("tweetreader_vote_latency_"+q.name+"_seconds",
+				q.help+" of the vote latency within the SLO window, 0 when nothing was published.",
+				func() float64 { return voteLatency.Quantile(q.q) })
+		}
+	})
+}
+
+// SetLatencyBudget has over called, on its own goroutine, once the 95th
+// percentile of the vote latency within the SLO window goes over max, and
+// again only after it went back within; 0 never calls it
+func SetLatencyBudget(max time.Duration, over func(p95 time.Duration)) {
+	latencyBudget.Lock()
+	defer latencyBudget.Unlock()
+	latencyBudget.max, latencyBudget.over = max, over
+}
+
+// createdAt returns when the tweet of v was posted, zero when it can't be told
+func createdAt(v *match.Vote) time.Time {
+	at, err := time.Parse(time.RubyDate, v.CreatedAt)
+	if err != nil {
+		return time.Time{}
+	}
+	return at
+}
+
+// observeVoteLatency records that the vote of a tweet posted at created was
+// acknowledged now, and checks the budget every budgetCheckEvery
+func observeVoteLatency(created, now time.Time) {
+	if created.IsZero() {
+		return
+	}
+	d := now.Sub(created)
+	if d < 0 {
+		d = 0
+	}
+	voteLatency.Observe(d)
+	voteLatencyHist.Observe(d.Seconds())
+
+	latencyBudget.Lock()
+	if latencyBudget.max <= 0 || now.Sub(latencyBudget.checked) < budgetCheckEvery {
+		latencyBudget.Unlock()
+		return
+	}
+	latencyBudget.checked = now
+	p95 := time.Duration(voteLatency.Quantile(0.95) * float64(time.Second))
+	exceeded := p95 > latencyBudget.max
+	warn := exceeded && !latencyBudget.exceeded
+	recovered := !exceeded && latencyBudget.exceeded
+	latencyBudget.exceeded = exceeded
+	over := latencyBudget.over
+	latencyBudget.Unlock()
+	if exceeded {
+		overBudget.Set(1)
+	} else {
+		overBudget.Set(0)
+	}
+	if recovered {
+		log.Printf("Publisher: votes are published within the latency budget again, %s at the 95th percentile", p95)
+	}
+	if warn && over != nil {
+		go over(p95)
+	}
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// publishers are the backends -publishers picks from; nsq is the one the
+// counter consumes, and -outbox, -partitions and -tenant-topics apply to it
+var publishers = []string{"nsq", "kafka"}
+
+// sidePublisher is a backend the votes are published to besides NSQ, with a
+// breaker and a spool of its own so its outages don't touch the others
+type sidePublisher struct {
+	name    string
+	pub     publish.Publisher
+	spool   *publish.Spool
+	breaker *breaker.Breaker
+}
+
+// openSidePublishers opens the backends of names but nsq, spooling to their
+// own directories in dir, keying each vote by the polls keys has for it where
+// the backend keys its messages. The spools must be closed once they are stopped.
+func openSidePublishers(names []string, dir string, keys *pollKeys) ([]sidePublisher, error) {
+	known := make(map[string]bool)
+	for _, name := range publishers {
+		known[name] = true
+	}
+	seen := make(map[string]bool)
+	var side []sidePublisher
+	for _, name := range names {
+		if !known[name] {
+			return nil, fmt.Errorf("invalid -publishers %q, want %s", name, strings.Join(publishers, ", "))
+		}
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+		if name == "nsq" {
+			continue
+		}
+		pub, err := newSidePublisher(name, keys)
+		if err != nil {
+			return nil, fmt.Errorf("failed to create the %s publisher: %v", name, err)
+		}
+		sp, err := publish.OpenSpool(filepath.Join(dir, name), spoolMaxBytes)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open the %s spool: %v", name, err)
+		}
+		side = append(side, sidePublisher{name: name, pub: faults.Publisher(pub), spool: sp, breaker: newBreaker(name, 30*time.Second)})
+	}
+	if !seen["nsq"] {
+		return nil, fmt.Errorf("-publishers must include nsq, the counter consumes the votes there")
+	}
+	return side, nil
+}
+
+// newSidePublisher creates the publisher of the backend called name
+func newSidePublisher(name string, keys *pollKeys) (publish.Publisher, error) {
+	switch name {
+	case "kafka":
+		url := envString("KAFKA_REST_URL", "")
+		if url == "" {
+			return nil, fmt.Errorf("KAFKA_REST_URL, the URL of the Kafka REST Proxy, isn't set")
+		}
+		t, err := tlsConfig("KAFKA")
+		if err != nil {
+			return nil, err
+		}
+		return publish.NewKafka(publish.KafkaConfig{
+			URL:      url,
+			Topic:    envString("KAFKA_TOPIC", "votes"),
+			Username: secret("KAFKA_USERNAME"),
+			Password: secret("KAFKA_PASSWORD"),
+			TLS:      t,
+			Timeout:  envDuration("KAFKA_TIMEOUT", 10*time.Second),
+			Keys:     keys.Keys,
+		})
+	}
+	return nil, fmt.Errorf("unknown publisher %q", name)
+}
+
+// pollKeys knows the tracked polls having each option, the keys of the
+// messages of a vote where a backend keys them
+type pollKeys struct {
+	mu sync.RWMutex
+	of map[string][]string // poll IDs per option, sorted
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the polls having them. When the polls can't be loaded the last ones loaded
+// are kept.
+func (k *pollKeys) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("Failed to load the polls to key the votes by, keeping the last known:", err)
+			return options, nil
+		}
+		of := make(map[string][]string)
+		for _, poll := range all {
+			if !poll.Tracked() {
+				continue
+			}
+			for _, o := range poll.Options {
+				of[o] = append(of[o], poll.ID)
+			}
+		}
+		for _, ids := range of {
+			sort.Strings(ids)
+		}
+		k.mu.Lock()
+		k.of = of
+		k.mu.Unlock()
+		return options, nil
+	}
+}
+
+// Keys returns the IDs of the tracked polls having v's option
+func (k *pollKeys) Keys(v *match.Vote) []string {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.of[v.Option]
+}
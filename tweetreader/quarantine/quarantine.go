@@ -0,0 +1,296 @@
+// Package quarantine keeps a poll whose options draw a flood of votes, say a
+// keyword spammers use, from crowding out the other polls sharing the stream.
+//
+// Every poll can be capped to a rate of published votes, its votes over the cap
+// are dropped, and a poll can be quarantined: its votes are dropped at once and
+// its options, unless another poll has them too, leave the stream's filter with
+// the next refresh, so the other polls don't even see a reconnect. A poll is
+// quarantined through the admin API, or automatically once too many of its
+// votes went over the cap within a minute, and stays so until released. The
+// quarantined polls are kept in a snapshot, which every streamer reads back
+// when it loads the options.
+package quarantine
+
+import (
+	"encoding/json"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/ratelimit"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// snapshot is the name the quarantined polls are saved under
+const snapshot = "quarantine"
+
+// Reasons a poll is quarantined for
+const (
+	// Manual is a poll quarantined through the admin API
+	Manual = "manual"
+	// RateCap is a poll quarantined for going over its cap too often
+	RateCap = "rate_cap"
+)
+
+var (
+	capped = metrics.NewCounter("tweetreader_poll_capped_votes_total",
+		"Votes dropped for going over their poll's rate cap, by poll.")
+	dropped = metrics.NewCounter("tweetreader_quarantined_votes_total",
+		"Votes dropped because every poll of their option is quarantined.")
+	quarantines = metrics.NewCounter("tweetreader_quarantines_total",
+		"Polls quarantined, by reason.")
+)
+
+// Config caps the polls, a zero Rate caps none
+type Config struct {
+	Rate  float64 // votes a second each poll publishes at most
+	Burst int     // votes over the rate a poll may publish at once, Rate by default
+	// AutoAfter quarantines a poll once this many of its votes went over the
+	// cap within a minute, 0 never does
+	AutoAfter int
+	// Snapshots keeps the quarantined polls across restarts and streamers, when set
+	Snapshots store.SnapshotStore
+	// Events is told of the polls quarantined, may be nil
+	Events *events.Bus
+}
+
+// Entry is a quarantined poll
+type Entry struct {
+	Poll   string    `json:"poll"`
+	Reason string    `json:"reason"`
+	Since  time.Time `json:"since"`
+}
+
+// Guard caps and quarantines the polls
+type Guard struct {
+	cfg     Config
+	limiter *ratelimit.Limiter
+
+	mu          sync.Mutex
+	polls       map[string][]string // the polls of each option
+	quarantined map[string]Entry
+	over        map[string]int // votes over the cap this minute, per poll
+	window      time.Time
+	now         func() time.Time
+}
+
+// New creates a Guard that knows no polls until Update is called
+func New(cfg Config) *Guard {
+	g := &Guard{
+		cfg:         cfg,
+		limiter:     ratelimit.New(cfg.Rate, cfg.Burst),
+		quarantined: make(map[string]Entry),
+		over:        make(map[string]int),
+		now:         time.Now,
+	}
+	metrics.NewGaugeFunc("tweetreader_quarantined_polls", "Polls whose votes are dropped.", func() float64 {
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		return float64(len(g.quarantined))
+	})
+	return g
+}
+
+// Update takes the options of polls
+func (g *Guard) Update(polls []store.Poll) {
+	byOption := make(map[string][]string)
+	for _, p := range polls {
+		for _, o := range p.Options {
+			byOption[o] = append(byOption[o], p.ID)
+		}
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.polls = byOption
+}
+
+// Load reads the quarantined polls back from the snapshot, keeping the ones
+// known when there is none or it can't be read
+func (g *Guard) Load() error {
+	if g.cfg.Snapshots == nil {
+		return nil
+	}
+	b, err := g.cfg.Snapshots.LoadSnapshot(snapshot)
+	if err == store.ErrNoSnapshot {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []Entry
+	if err := json.Unmarshal(b, &entries); err != nil {
+		return err
+	}
+	quarantined := make(map[string]Entry, len(entries))
+	for _, e := range entries {
+		quarantined[e.Poll] = e
+	}
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.quarantined = quarantined
+	return nil
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the polls and the quarantined ones, and leaves out the options whose polls
+// are all quarantined. When the polls can't be loaded the last ones are kept.
+func (g *Guard) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		if err := g.Load(); err != nil {
+			log.Println("quarantine: failed to load the quarantined polls, keeping the last ones:", err)
+		}
+		if all, err := polls.Polls(); err != nil {
+			log.Println("quarantine: failed to load the polls, keeping the last ones:", err)
+		} else {
+			g.Update(all)
+		}
+		g.mu.Lock()
+		defer g.mu.Unlock()
+		kept := options[:0:0]
+		for _, o := range options {
+			if ids := g.polls[o]; len(ids) > 0 && len(g.live(ids)) == 0 {
+				continue
+			}
+			kept = append(kept, o)
+		}
+		return kept, nil
+	}
+}
+
+// live returns the polls of ids that aren't quarantined
+func (g *Guard) live(ids []string) []string {
+	var live []string
+	for _, id := range ids {
+		if _, ok := g.quarantined[id]; !ok {
+			live = append(live, id)
+		}
+	}
+	return live
+}
+
+// Quarantine quarantines the poll id for reason, and reports whether it wasn't already
+func (g *Guard) Quarantine(id, reason string) (Entry, bool, error) {
+	g.mu.Lock()
+	if e, ok := g.quarantined[id]; ok {
+		g.mu.Unlock()
+		return e, false, nil
+	}
+	e := Entry{Poll: id, Reason: reason, Since: g.now().UTC()}
+	g.quarantined[id] = e
+	entries := g.entries()
+	g.mu.Unlock()
+	log.Printf("quarantine: poll %s quarantined (%s)", id, reason)
+	quarantines.Inc("reason", reason)
+	g.cfg.Events.Emit(events.PollQuarantined, id+": "+reason)
+	return e, true, g.save(entries)
+}
+
+// Release lets the poll id's votes through again, and reports whether it was quarantined
+func (g *Guard) Release(id string) (bool, error) {
+	g.mu.Lock()
+	if _, ok := g.quarantined[id]; !ok {
+		g.mu.Unlock()
+		return false, nil
+	}
+	delete(g.quarantined, id)
+	delete(g.over, id)
+	entries := g.entries()
+	g.mu.Unlock()
+	log.Printf("quarantine: poll %s released", id)
+	return true, g.save(entries)
+}
+
+// List returns the quarantined polls, the longest quarantined first
+func (g *Guard) List() []Entry {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return g.entries()
+}
+
+func (g *Guard) entries() []Entry {
+	entries := make([]Entry, 0, len(g.quarantined))
+	for _, e := range g.quarantined {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Since.Before(entries[j].Since) })
+	return entries
+}
+
+func (g *Guard) save(entries []Entry) error {
+	if g.cfg.Snapshots == nil {
+		return nil
+	}
+	b, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	return g.cfg.Snapshots.SaveSnapshot(snapshot, b)
+}
+
+// Keep reports whether v is published: a vote is dropped when every poll of
+// its option is quarantined, or over its cap. Votes for options no poll has
+// are let through, the counter dead letters them.
+func (g *Guard) Keep(v *match.Vote) bool {
+	g.mu.Lock()
+	ids := g.polls[v.Option]
+	if len(ids) == 0 {
+		g.mu.Unlock()
+		return true
+	}
+	live := g.live(ids)
+	if len(live) == 0 {
+		g.mu.Unlock()
+		dropped.Inc()
+		return false
+	}
+	if g.limiter == nil {
+		g.mu.Unlock()
+		return true
+	}
+	if now := g.now(); now.Sub(g.window) >= time.Minute {
+		g.window = now
+		g.over = make(map[string]int)
+	}
+	kept := false
+	var overflowing []string
+	for _, id := range live {
+		if ok, _ := g.limiter.Allow(id); ok {
+			kept = true
+			continue
+		}
+		capped.Inc("poll", id)
+		g.over[id]++
+		if g.cfg.AutoAfter > 0 && g.over[id] == g.cfg.AutoAfter {
+			overflowing = append(overflowing, id)
+		}
+	}
+	g.mu.Unlock()
+	for _, id := range overflowing {
+		if _, _, err := g.Quarantine(id, RateCap); err != nil {
+			log.Println("quarantine: failed to save the quarantined polls:", err)
+		}
+	}
+	return kept
+}
+
+// Run passes on the kept votes from in, the returned channel is closed once in is
+func (g *Guard) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if g.Keep(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
@@ -0,0 +1,106 @@
+// Package ratelimit holds back HTTP clients sending more requests than their
+// share: every client, an IP address or an API key, has a token bucket that
+// refills at the limiter's rate up to its burst, and a request takes a token.
+package ratelimit
+
+import (
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// sweepEvery is how often the buckets that refilled are dropped, so
+// clients that went away don't keep memory
+const sweepEvery = time.Minute
+
+// Limiter is a token bucket per client
+type Limiter struct {
+	rate  float64 // tokens a second
+	burst float64
+
+	mu        sync.Mutex
+	buckets   map[string]*bucket
+	lastSweep time.Time
+	now       func() time.Time
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// New returns a limiter of rate requests a second and bursts of up to burst,
+// nil when rate isn't positive, which allows everything
+func New(rate float64, burst int) *Limiter {
+	if rate <= 0 {
+		return nil
+	}
+	if burst < 1 {
+		burst = int(math.Ceil(rate))
+	}
+	return &Limiter{rate: rate, burst: float64(burst), buckets: make(map[string]*bucket), now: time.Now}
+}
+
+// Allow takes a token from the bucket of client. When it is empty it returns
+// false and how long until the next token.
+func (l *Limiter) Allow(client string) (bool, time.Duration) {
+	if l == nil {
+		return true, 0
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := l.now()
+	if now.Sub(l.lastSweep) >= sweepEvery {
+		l.sweep(now)
+	}
+	b, ok := l.buckets[client]
+	if !ok {
+		b = &bucket{tokens: l.burst, last: now}
+		l.buckets[client] = b
+	}
+	b.tokens = math.Min(l.burst, b.tokens+now.Sub(b.last).Seconds()*l.rate)
+	b.last = now
+	if b.tokens < 1 {
+		return false, time.Duration((1 - b.tokens) / l.rate * float64(time.Second))
+	}
+	b.tokens--
+	return true, 0
+}
+
+func (l *Limiter) sweep(now time.Time) {
+	for client, b := range l.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*l.rate >= l.burst {
+			delete(l.buckets, client)
+		}
+	}
+	l.lastSweep = now
+}
+
+// ClientIP returns the address r came from. Behind a proxy that appends to
+// X-Forwarded-For, trustForwarded takes the address the proxy saw instead.
+func ClientIP(r *http.Request, trustForwarded bool) string {
+	if trustForwarded {
+		if fwd := r.Header.Get("X-Forwarded-For"); fwd != "" {
+			hops := strings.Split(fwd, ",")
+			return strings.TrimSpace(hops[len(hops)-1]) // the earlier hops are whatever the client sent
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// RetryAfter is the Retry-After header for wait: whole seconds, at least 1
+func RetryAfter(wait time.Duration) string {
+	s := int(math.Ceil(wait.Seconds()))
+	if s < 1 {
+		s = 1
+	}
+	return strconv.Itoa(s)
+}
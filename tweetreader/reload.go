@@ -0,0 +1,114 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+)
+
+// pollEventsGap is the least time between two reconnects for poll or shard changes,
+// editing a handful of polls at once costs one or two reconnects, not one each
+const pollEventsGap = 10 * time.Second
+
+// reloader reconnects the sources so they track the latest options: every
+// refresh interval, and whenever a reload is requested
+type reloader struct {
+	src      sources
+	refresh  *clock.Interval
+	clock    clock.Clock
+	requests chan string // why
+	stop     chan struct{}
+}
+
+// newReloader creates a reloader of src, which doesn't reconnect until
+// started: the requests made meanwhile are merged into one for once it is
+func newReloader(src sources, refresh *clock.Interval, clk clock.Clock) *reloader {
+	return &reloader{src: src, refresh: refresh, clock: clock.Or(clk), requests: make(chan string, 1), stop: make(chan struct{})}
+}
+
+// start reconnects every refresh, never while it is 0, and on request until
+// stopped; a refresh changed while running starts over with the new interval
+func (r *reloader) start() {
+	go func() {
+		for {
+			// before reading it, so a change in between isn't missed
+			changed := r.refresh.Changed()
+			var ticker clock.Ticker
+			var ticks <-chan time.Time
+			if d := r.refresh.Get(); d > 0 {
+				ticker = r.clock.NewTicker(d)
+				ticks = ticker.C()
+			}
+			running := r.wait(ticks, changed)
+			if ticker != nil {
+				ticker.Stop()
+			}
+			if !running {
+				return
+			}
+		}
+	}()
+}
+
+// wait reconnects on every tick and request until the refresh interval
+// changed, and reports false once stopped
+func (r *reloader) wait(ticks <-chan time.Time, changed <-chan struct{}) bool {
+	for {
+		select {
+		case <-r.stop:
+			return false
+		case <-changed:
+			return true
+		case <-ticks:
+			r.src.Reconnect()
+		case why := <-r.requests:
+			log.Println(why + ", reconnecting to Twitter")
+			r.src.Reconnect()
+			select {
+			case <-r.stop:
+				return false
+			case <-r.clock.After(pollEventsGap):
+			}
+		}
+	}
+}
+
+// request asks for a reconnect because of why, requests arriving while one is pending are merged into it
+func (r *reloader) request(why string) {
+	select {
+	case r.requests <- why:
+	default:
+	}
+}
+
+// Stop stops reconnecting
+func (r *reloader) Stop() {
+	close(r.stop)
+}
+
+// watchPollEvents requests a reload every time the rest-api announces a poll change.
+// Every streamer has to see every change, so each listens on its own ephemeral channel.
+func watchPollEvents(lookupdAddr string, reload func(why string)) (*nsq.Consumer, error) {
+	cfg, err := nsqConfig()
+	if err != nil {
+		return nil, err
+	}
+	host, _ := os.Hostname()
+	q, err := nsq.NewConsumer("poll_events", fmt.Sprintf("stream-%s-%d#ephemeral", host, os.Getpid()), cfg)
+	if err != nil {
+		return nil, err
+	}
+	q.AddHandler(nsq.HandlerFunc(func(m *nsq.Message) error {
+		reload("Polls changed")
+		return nil
+	}))
+	if err := q.ConnectToNSQLookupd(lookupdAddr); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
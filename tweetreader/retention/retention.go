@@ -0,0 +1,217 @@
+// Package retention keeps the ballots database from growing unbounded. A
+// sweep deletes the counted tweets, the time series buckets and the
+// results_history entries older than the ages configured, optionally storing
+// the tweets in an archive first, archives the polls that have been closed
+// for long enough, and compacts the archived polls down to their results and
+// the last entry of their history.
+package retention
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// snapshot is the name the sweeps' state is saved under
+const snapshot = "retention"
+
+var (
+	deleted = metrics.NewCounter("tweetreader_retention_deleted_total",
+		"Documents or rows deleted for being older than their retention, by kind.")
+	archivedPolls = metrics.NewCounter("tweetreader_retention_archived_polls_total",
+		"Closed polls archived for having been closed longer than allowed.")
+	compacted = metrics.NewCounter("tweetreader_retention_compacted_polls_total",
+		"Archived polls whose history was compacted.")
+)
+
+// Config is what a sweep keeps, a zero age keeps that kind of data forever
+type Config struct {
+	Tweets  time.Duration // counted tweets older than this are deleted
+	Series  time.Duration // time series buckets older than this are deleted
+	History time.Duration // results_history entries older than this are deleted
+	// ArchiveAfter archives the polls closed for this long. A poll's closing
+	// isn't recorded, it counts from the first sweep that found it closed.
+	ArchiveAfter time.Duration
+	// Archive, when set, stores the expired tweets before they are deleted, as
+	// gzipped NDJSON under expired/tweets/
+	Archive archive.Sink
+}
+
+// Report is what a sweep did
+type Report struct {
+	Tweets    int      `json:"tweets"`
+	Series    int      `json:"series"`
+	Archived  []string `json:"archived,omitempty"`
+	Compacted []string `json:"compacted,omitempty"`
+}
+
+// state is what the sweeps remember across runs
+type state struct {
+	// Closed is when each closed poll was first found closed
+	Closed map[string]time.Time `json:"closed"`
+	// Compacted are the archived polls already compacted
+	Compacted map[string]bool `json:"compacted"`
+}
+
+// Sweep applies cfg to db as of now. It carries on past the failures, which
+// are logged, and returns the first one.
+func Sweep(db store.Backend, cfg Config, now time.Time) (Report, error) {
+	var r Report
+	var first error
+	fail := func(what string, err error) {
+		log.Printf("retention: failed to %s: %v", what, err)
+		if first == nil {
+			first = fmt.Errorf("%s: %v", what, err)
+		}
+	}
+	rs, ok := db.(store.RetentionStore)
+	if !ok {
+		log.Println("retention: this store can't expire tweets, time series or compact polls")
+	}
+
+	if ok && cfg.Tweets > 0 {
+		var keep func(docs [][]byte) error
+		if cfg.Archive != nil {
+			batch := 0
+			keep = func(docs [][]byte) error {
+				batch++
+				key := fmt.Sprintf("expired/tweets/%s-%04d.ndjson.gz", now.UTC().Format("20060102T150405Z"), batch)
+				return put(cfg.Archive, key, docs)
+			}
+		}
+		n, err := rs.ExpireTweets(now.Add(-cfg.Tweets), keep)
+		r.Tweets = n
+		deleted.Add(float64(n), "kind", "tweets")
+		if err != nil {
+			fail("expire the tweets", err)
+		}
+	}
+	if ok && cfg.Series > 0 {
+		n, err := rs.ExpireSeries(now.Add(-cfg.Series))
+		r.Series = n
+		deleted.Add(float64(n), "kind", "time_series")
+		if err != nil {
+			fail("expire the time series", err)
+		}
+	}
+	if cfg.History > 0 {
+		if h, ok := db.(store.HistoryStore); ok {
+			// the zero time thins nothing
+			if err := h.CompactHistory(now.Add(-cfg.History), time.Time{}, 0); err != nil {
+				fail("expire the results history", err)
+			}
+		}
+	}
+
+	polls, err := db.Polls()
+	if err != nil {
+		fail("load the polls", err)
+		return r, first
+	}
+	st := load(db)
+	seen := make(map[string]bool, len(polls))
+	for _, p := range polls {
+		seen[p.ID] = true
+		switch p.State() {
+		case store.StatusClosed:
+			since, known := st.Closed[p.ID]
+			if !known {
+				st.Closed[p.ID] = now
+				continue
+			}
+			if cfg.ArchiveAfter <= 0 || now.Sub(since) < cfg.ArchiveAfter {
+				continue
+			}
+			if _, err := store.Transition(db, p.ID, store.StatusArchived); err != nil {
+				fail("archive poll "+p.ID, err)
+				continue
+			}
+			log.Printf("retention: archived poll %s, closed since %s", p.ID, since.Format(time.RFC3339))
+			archivedPolls.Inc()
+			r.Archived = append(r.Archived, p.ID)
+			fallthrough
+		case store.StatusArchived:
+			delete(st.Closed, p.ID)
+			if !ok || st.Compacted[p.ID] {
+				continue
+			}
+			if err := rs.CompactPoll(p.ID); err != nil {
+				fail("compact poll "+p.ID, err)
+				continue
+			}
+			compacted.Inc()
+			st.Compacted[p.ID] = true
+			r.Compacted = append(r.Compacted, p.ID)
+		default:
+			// reopened, or a draft going active
+			delete(st.Closed, p.ID)
+			delete(st.Compacted, p.ID)
+		}
+	}
+	// forget the polls that were deleted
+	for id := range st.Closed {
+		if !seen[id] {
+			delete(st.Closed, id)
+		}
+	}
+	for id := range st.Compacted {
+		if !seen[id] {
+			delete(st.Compacted, id)
+		}
+	}
+	if err := save(db, st); err != nil {
+		fail("save the retention state", err)
+	}
+	return r, first
+}
+
+// load reads the sweeps' state, starting afresh when there is none or it can't be read
+func load(db store.SnapshotStore) state {
+	st := state{Closed: make(map[string]time.Time), Compacted: make(map[string]bool)}
+	b, err := db.LoadSnapshot(snapshot)
+	if err != nil {
+		if err != store.ErrNoSnapshot {
+			log.Println("retention: failed to load the state, starting afresh:", err)
+		}
+		return st
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		log.Println("retention: failed to decode the state, starting afresh:", err)
+	}
+	if st.Closed == nil {
+		st.Closed = make(map[string]time.Time)
+	}
+	if st.Compacted == nil {
+		st.Compacted = make(map[string]bool)
+	}
+	return st
+}
+
+func save(db store.SnapshotStore, st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return db.SaveSnapshot(snapshot, b)
+}
+
+// put stores docs in the sink under key as gzipped NDJSON
+func put(sink archive.Sink, key string, docs [][]byte) error {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	for _, d := range docs {
+		gz.Write(d)
+		gz.Write([]byte("\n"))
+	}
+	if err := gz.Close(); err != nil {
+		return err
+	}
+	return sink.Put(key, buf.Bytes())
+}
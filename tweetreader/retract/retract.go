@@ -0,0 +1,170 @@
+// Package retract takes back the votes of the tweets Twitter asks to stop
+// showing: the tweets deleted or withheld, and the tweets of the authors who
+// protected their account or had it deleted, suspended or withheld. The
+// streamer publishes a Retraction naming the tweets on Topic, and the
+// counters take their votes back out of the results.
+//
+// The compliance messages about an author only carry the author's ID, so an
+// Index remembers the tweets of every author it passed votes of for Window.
+// The older tweets, and the ones of a previous run, can't be named; a deleted
+// or withheld tweet is named by the message itself and always retracted.
+package retract
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// Topic is the NSQ topic retractions are published on
+const Topic = "retractions"
+
+var (
+	published = metrics.NewCounter("tweetreader_retractions_total",
+		"Retractions published for the compliance messages from Twitter, by kind.")
+	unnamed = metrics.NewCounter("tweetreader_retractions_unknown_author_total",
+		"Compliance messages about authors none of whose tweets were remembered, by kind.")
+)
+
+// Retraction names the tweets whose votes are taken back
+type Retraction struct {
+	Kind   string    `json:"kind"` // one of the stream compliance kinds, e.g. stream.StatusDeleted
+	Tweets []string  `json:"tweets"`
+	Time   time.Time `json:"time"`
+}
+
+// Publisher is the part of publish.Publisher retractions are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Config says how long tweets are remembered and where retractions go
+type Config struct {
+	// Window is how long the tweets of an author are remembered, 24h by default
+	Window time.Duration
+	// MaxTweets is the most tweets remembered, the oldest are forgotten
+	// first; 100000 by default
+	MaxTweets int
+	// Publisher, if set, publishes every Retraction in JSON, they are only
+	// logged when nil
+	Publisher Publisher
+}
+
+// seen is a tweet remembered
+type seen struct {
+	user, tweet string
+	at          time.Time
+}
+
+// Index remembers the tweets of the authors of the votes
+type Index struct {
+	cfg Config
+
+	mu     sync.Mutex
+	byUser map[string][]string // the tweets of each author, oldest first
+	order  []seen              // every tweet remembered, oldest first
+	now    func() time.Time
+}
+
+// New creates an Index with cfg
+func New(cfg Config) *Index {
+	if cfg.Window <= 0 {
+		cfg.Window = 24 * time.Hour
+	}
+	if cfg.MaxTweets <= 0 {
+		cfg.MaxTweets = 100000
+	}
+	return &Index{cfg: cfg, byUser: make(map[string][]string), now: time.Now}
+}
+
+// remember records that user posted tweet
+func (x *Index) remember(user, tweet string) {
+	if user == "" || tweet == "" {
+		return
+	}
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	now := x.now()
+	x.expire(now)
+	tweets := x.byUser[user]
+	if n := len(tweets); n > 0 && tweets[n-1] == tweet {
+		// the votes of a tweet naming several options come one after the other
+		return
+	}
+	x.byUser[user] = append(tweets, tweet)
+	x.order = append(x.order, seen{user: user, tweet: tweet, at: now})
+}
+
+// expire forgets the tweets older than Window, and the oldest over MaxTweets
+func (x *Index) expire(now time.Time) {
+	for len(x.order) > 0 && (len(x.order) >= x.cfg.MaxTweets || now.Sub(x.order[0].at) > x.cfg.Window) {
+		s := x.order[0]
+		x.order = x.order[1:]
+		// the author's tweets are gone already when they were retracted
+		if tweets := x.byUser[s.user]; len(tweets) > 0 && tweets[0] == s.tweet {
+			if len(tweets) == 1 {
+				delete(x.byUser, s.user)
+			} else {
+				x.byUser[s.user] = tweets[1:]
+			}
+		}
+	}
+}
+
+// forget returns the tweets remembered of user, and forgets them
+func (x *Index) forget(user string) []string {
+	x.mu.Lock()
+	defer x.mu.Unlock()
+	tweets := x.byUser[user]
+	delete(x.byUser, user)
+	return tweets
+}
+
+// Run passes on the votes from in, remembering their tweets by author, the
+// returned channel is closed once in is
+func (x *Index) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			x.remember(v.User.ID, v.ID)
+			out <- v
+		}
+	}()
+	return out
+}
+
+// Handle publishes the retraction of the tweets c is about, it doesn't block
+// so it can be the Compliance of a stream
+func (x *Index) Handle(c stream.Compliance) {
+	var tweets []string
+	if c.TweetID != "" {
+		tweets = []string{c.TweetID}
+	} else if tweets = x.forget(c.UserID); len(tweets) == 0 {
+		unnamed.Inc("kind", c.Kind)
+		return
+	}
+	r := Retraction{Kind: c.Kind, Tweets: tweets, Time: x.now()}
+	published.Inc("kind", c.Kind)
+	if c.TweetID == "" {
+		log.Printf("retracting the %d tweets remembered of an author: %s", len(tweets), c.Kind)
+	}
+	if x.cfg.Publisher == nil {
+		return
+	}
+	b, err := json.Marshal(r)
+	if err != nil {
+		log.Println("failed to encode the retraction:", err)
+		return
+	}
+	go func() {
+		if err := x.cfg.Publisher.Publish(b); err != nil {
+			log.Println("failed to publish the retraction:", err)
+		}
+	}()
+}
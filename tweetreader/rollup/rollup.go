@@ -0,0 +1,185 @@
+// Package rollup adds up the votes for an option over a short window and
+// publishes a single vote counting them all, {option, count, window}, in
+// place of one message per tweet, so a spike costs the broker and the
+// counter a message an option every window rather than one a tweet.
+//
+// Only votes that count the same for every poll are added up: the ones for
+// an option, from a source, in a language and with the same match.Vote flags
+// the polls filter on. An added up vote carries no tweet, so the votes with
+// coordinates, for geo filtered polls, and the votes for the options of
+// polls that need each tweet, counting unique authors or computing custom
+// results from the text, are published one by one as before.
+package rollup
+
+import (
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/aggregate"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var rolledUp = metrics.NewCounter("tweetreader_rolled_up_votes_total",
+	"Votes added up into the votes published once every rollup window.")
+
+// key is what the votes added up together share
+type key struct {
+	option, source, lang, folded, stemmed, tenants    string
+	filtered, versions                                string
+	hashtag, suspect, retweet, quote, reply, embedded bool
+	caseFolded, partial                               bool
+}
+
+func keyOf(v match.Vote) key {
+	return key{
+		option: v.Option, source: v.Source, lang: v.Lang, folded: v.Folded, stemmed: v.Stemmed, tenants: strings.Join(v.Tenants, ","),
+		filtered: strings.Join(v.Filtered, ","), hashtag: v.Hashtag, suspect: v.Suspect, retweet: v.Retweet, quote: v.Quote, reply: v.Reply, embedded: v.Embedded,
+		caseFolded: v.CaseFolded, partial: v.Partial, versions: strings.Join(v.Versions, ","),
+	}
+}
+
+// sum is the votes added up for a key so far
+type sum struct {
+	first    match.Vote // the flags of the key
+	count    int
+	weighted float64
+	missed   float64 // the tweets Twitter didn't deliver for each vote, added up
+	created  string  // of the last vote added
+}
+
+// Rollup adds up the votes over its window
+type Rollup struct {
+	window time.Duration
+	id     string // of the streamer, keeps the message IDs of streamers apart
+
+	mu         sync.Mutex
+	individual map[string]bool // the options whose votes are published one by one
+}
+
+// New creates a Rollup adding up the votes over window, the streamer id
+// being part of the message IDs of the votes it publishes
+func New(window time.Duration, id string) *Rollup {
+	return &Rollup{window: window, id: id}
+}
+
+// Options wraps a function loading the options so every load also picks up
+// which of them are in polls needing their votes one by one. When the polls
+// can't be loaded the last ones loaded are kept.
+func (r *Rollup) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("rollup: failed to load the polls, keeping the last known:", err)
+			return options, nil
+		}
+		individual := make(map[string]bool)
+		for _, p := range all {
+			if !p.Tracked() || !p.UniqueAuthors() && len(aggregate.For(p.Type)) == 0 {
+				continue
+			}
+			for _, o := range p.Options {
+				individual[o] = true
+			}
+		}
+		r.mu.Lock()
+		r.individual = individual
+		r.mu.Unlock()
+		return options, nil
+	}
+}
+
+// alone reports whether v is published one by one
+func (r *Rollup) alone(v match.Vote) bool {
+	if v.Geo != nil || v.Option == "" || v.Count > 0 {
+		return true
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.individual[v.Option]
+}
+
+// Run adds up the votes from in and passes the sums on every window, the
+// returned channel is closed once in is, after the last sums
+func (r *Rollup) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		ticker := time.NewTicker(r.window)
+		defer ticker.Stop()
+		sums := make(map[key]*sum)
+		var order []key // of the sums, so they go out in the order they started
+		start := time.Now()
+		flush := func() {
+			for i, k := range order {
+				out <- r.vote(sums[k], start, i)
+			}
+			sums, order, start = make(map[key]*sum), nil, time.Now()
+		}
+		for {
+			select {
+			case v, ok := <-in:
+				if !ok {
+					flush()
+					return
+				}
+				if r.alone(v) {
+					out <- v
+					continue
+				}
+				k := keyOf(v)
+				s := sums[k]
+				if s == nil {
+					s = &sum{first: v}
+					sums[k] = s
+					order = append(order, k)
+				}
+				n := 1
+				if v.Scale > 1 {
+					n = v.Scale
+				}
+				w := v.Weight
+				if w == 0 {
+					w = 1
+				}
+				s.count += n
+				s.weighted += w * float64(n)
+				if f := v.Undelivered; f > 0 && f < 1 {
+					s.missed += f / (1 - f) * float64(n)
+				}
+				s.created = v.CreatedAt
+				rolledUp.Inc()
+			case <-ticker.C:
+				flush()
+			}
+		}
+	}()
+	return out
+}
+
+// vote is the vote adding up s, the i-th sum of the window from start
+func (r *Rollup) vote(s *sum, start time.Time, i int) match.Vote {
+	f := s.first
+	v := match.Vote{
+		Option: f.Option, Weight: s.weighted / float64(s.count), Hashtag: f.Hashtag, Suspect: f.Suspect,
+		Retweet: f.Retweet, Quote: f.Quote, Reply: f.Reply, Embedded: f.Embedded, CaseFolded: f.CaseFolded, Partial: f.Partial,
+		Folded: f.Folded, Stemmed: f.Stemmed, Tenants: f.Tenants, Source: f.Source, Filtered: f.Filtered, Versions: f.Versions,
+		Count: s.count, Window: int(r.window / time.Second),
+	}
+	v.CreatedAt, v.Lang = s.created, f.Lang
+	// the share undelivered that makes the counter estimate as many tweets missed
+	if m := s.missed / float64(s.count); m > 0 {
+		v.Undelivered = m / (1 + m)
+	}
+	// the same every time the message is published, redeliveries are dropped
+	v.MessageID = fmt.Sprintf("rollup:%s:%d:%d", r.id, start.UnixNano(), i)
+	return v
+}
@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+)
+
+// openRunbook starts the event bus with the rules in RUNBOOK_FILE, or returns nil when it isn't set
+func openRunbook() (*events.Bus, error) {
+	path := os.Getenv("RUNBOOK_FILE")
+	if path == "" {
+		return nil, nil
+	}
+	rules, err := events.LoadRunbook(path)
+	if err != nil {
+		return nil, fmt.Errorf("invalid RUNBOOK_FILE: %v", err)
+	}
+	return events.NewBus(rules), nil
+}
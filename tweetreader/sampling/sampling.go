@@ -0,0 +1,110 @@
+// Package sampling thins out the votes for polls too busy to count every one.
+//
+// A poll with SampleEvery N gets 1 in N of the votes for its options published,
+// each marked as standing for N votes, so the counter scales the tallies back up
+// and the results stay an estimate of the real totals while the broker and the
+// store see a tenth, or a hundredth, of the traffic. Which votes are kept
+// depends on the tweet ID alone, so every replica and every retry keeps the same.
+package sampling
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var sampledOut = metrics.NewCounter("tweetreader_sampled_out_total",
+	"Votes for sampled polls that weren't published.")
+
+// Sampler keeps 1 in N of the votes for the options of sampled polls
+type Sampler struct {
+	mu    sync.RWMutex
+	every map[string]int // N per option, only for sampled options
+}
+
+// New creates a Sampler keeping every vote until Update is called
+func New() *Sampler {
+	return &Sampler{}
+}
+
+// Update takes the sampled options from polls. An option in several polls is
+// sampled at the lowest N: its votes are the same for all of them, and every
+// poll gets an estimate scaled by the same N.
+func (s *Sampler) Update(polls []store.Poll) {
+	every := make(map[string]int)
+	rates := make(map[string]int) // the lowest N per option of any poll, 1 for unsampled polls
+	for _, p := range polls {
+		n := p.SampleEvery
+		if n < 1 {
+			n = 1
+		}
+		for _, o := range p.Options {
+			if cur, ok := rates[o]; !ok || n < cur {
+				rates[o] = n
+			}
+		}
+	}
+	for o, n := range rates {
+		if n > 1 {
+			every[o] = n
+		}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.every = every
+}
+
+// Options wraps a function loading the options so every load also updates
+// which of them are sampled. When the polls can't be loaded the last sampled
+// options are kept.
+func (s *Sampler) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("sampling: failed to load the polls, keeping the last sampled options:", err)
+			return options, nil
+		}
+		s.Update(all)
+		return options, nil
+	}
+}
+
+// Keep reports whether v is published, setting its Scale when it is sampled
+func (s *Sampler) Keep(v *match.Vote) bool {
+	s.mu.RLock()
+	n := s.every[v.Option]
+	s.mu.RUnlock()
+	if n <= 1 {
+		return true
+	}
+	h := fnv.New64a()
+	h.Write([]byte(v.ID))
+	if h.Sum64()%uint64(n) != 0 {
+		sampledOut.Inc()
+		return false
+	}
+	v.Scale = n
+	return true
+}
+
+// Run passes on the kept votes from in, the returned channel is closed once in is
+func (s *Sampler) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if s.Keep(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
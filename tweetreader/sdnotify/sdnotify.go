@@ -0,0 +1,105 @@
+// Package sdnotify tells systemd how the daemon is doing, see sd_notify(3):
+// that it is ready, once it has started, and that it is still healthy, by
+// petting the watchdog. With WatchdogSec= in the unit, systemd restarts the
+// daemon when it stops petting, because it hung or found itself unhealthy.
+// Outside systemd, without NOTIFY_SOCKET, every call does nothing.
+package sdnotify
+
+import (
+	"log"
+	"net"
+	"os"
+	"strconv"
+	"time"
+)
+
+// Notification states
+const (
+	Ready    = "READY=1"
+	Stopping = "STOPPING=1"
+	Watchdog = "WATCHDOG=1"
+)
+
+// Notify sends systemd the states, one per line, and reports whether there
+// was a socket to send them to
+func Notify(states ...string) (bool, error) {
+	socket := os.Getenv("NOTIFY_SOCKET")
+	if socket == "" {
+		return false, nil
+	}
+	if socket[0] == '@' {
+		socket = "\x00" + socket[1:] // an abstract socket
+	}
+	conn, err := net.DialUnix("unixgram", nil, &net.UnixAddr{Name: socket, Net: "unixgram"})
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	var msg []byte
+	for i, s := range states {
+		if i > 0 {
+			msg = append(msg, '\n')
+		}
+		msg = append(msg, s...)
+	}
+	if _, err := conn.Write(msg); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// Status is the STATUS= state describing the daemon in systemctl status
+func Status(s string) string {
+	return "STATUS=" + s
+}
+
+// WatchdogInterval returns how often systemd expects the watchdog to be
+// petted, its WatchdogSec=, 0 when it doesn't watch this process
+func WatchdogInterval() time.Duration {
+	usec, err := strconv.ParseInt(os.Getenv("WATCHDOG_USEC"), 10, 64)
+	if err != nil || usec <= 0 {
+		return 0
+	}
+	if pid := os.Getenv("WATCHDOG_PID"); pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return 0
+	}
+	return time.Duration(usec) * time.Microsecond
+}
+
+// Watch pets the watchdog twice every WatchdogInterval while healthy returns
+// nil, until stop is closed; it returns at once when systemd doesn't watch.
+// While healthy returns an error the watchdog isn't petted, the error goes
+// in the status, and systemd restarts the daemon once the interval is up.
+func Watch(healthy func() error, stop <-chan struct{}) {
+	every := WatchdogInterval() / 2
+	if every <= 0 {
+		return
+	}
+	tick := time.NewTicker(every)
+	defer tick.Stop()
+	sick := false
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+		}
+		if err := healthy(); err != nil {
+			if !sick {
+				log.Println("sdnotify: unhealthy, not petting the watchdog:", err)
+				Notify(Status("unhealthy: " + err.Error()))
+			}
+			sick = true
+			continue
+		}
+		states := []string{Watchdog}
+		if sick {
+			log.Println("sdnotify: healthy again")
+			states = append(states, Status("running"))
+		}
+		sick = false
+		if _, err := Notify(states...); err != nil {
+			log.Println("sdnotify: failed to pet the watchdog:", err)
+		}
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log"
+	"os"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/secrets"
+)
+
+// fetched holds the values of the secret in SECRETS_PATH, nil when SECRETS_BACKEND isn't set
+var fetched struct {
+	sync.Mutex
+	provider secrets.Provider
+	values   map[string]string
+}
+
+// loadSecrets fetches the secret configured in SECRETS_BACKEND and SECRETS_PATH
+func loadSecrets() error {
+	backend := os.Getenv("SECRETS_BACKEND")
+	if backend == "" {
+		return nil
+	}
+	p, err := secrets.Open(secrets.Config{Backend: backend, Path: os.Getenv("SECRETS_PATH")})
+	if err != nil {
+		return err
+	}
+	values, err := p.Fetch()
+	if err != nil {
+		return err
+	}
+	fetched.Lock()
+	fetched.provider, fetched.values = p, values
+	fetched.Unlock()
+	log.Printf("Loaded %d secrets from %s", len(values), backend)
+	return nil
+}
+
+// secret returns the value of key in the fetched secret, falling back to the environment
+func secret(key string) string {
+	fetched.Lock()
+	v, ok := fetched.values[key]
+	fetched.Unlock()
+	if ok {
+		return v
+	}
+	return os.Getenv(key)
+}
+
+// watchSecrets fetches the secret again every SECRETS_REFRESH and calls changed
+// when any value differs. On errors the last values are kept.
+func watchSecrets(changed func()) {
+	fetched.Lock()
+	p := fetched.provider
+	fetched.Unlock()
+	if p == nil {
+		return
+	}
+	every := envDuration("SECRETS_REFRESH", 5*time.Minute)
+	if every <= 0 {
+		return
+	}
+	go func() {
+		for range time.Tick(every) {
+			if refreshSecrets() {
+				changed()
+			}
+		}
+	}()
+}
+
+// refreshSecrets fetches the secret again, reporting whether any value changed.
+// On errors the last values are kept.
+func refreshSecrets() bool {
+	fetched.Lock()
+	p := fetched.provider
+	fetched.Unlock()
+	if p == nil {
+		return false
+	}
+	values, err := p.Fetch()
+	if err != nil {
+		log.Println("failed to refresh secrets, keeping the last ones:", err)
+		return false
+	}
+	fetched.Lock()
+	same := reflect.DeepEqual(values, fetched.values)
+	fetched.values = values
+	fetched.Unlock()
+	if !same {
+		log.Println("Secrets changed")
+		// the next votes are encrypted with the new current key
+		updateVoteKeys()
+	}
+	return !same
+}
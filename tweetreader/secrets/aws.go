@@ -0,0 +1,81 @@
+package secrets
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/sigv4"
+)
+
+// awsSecret reads a secret from AWS Secrets Manager. The secret string must be
+// a JSON object. Requests are signed with the keys in AWS_ACCESS_KEY_ID,
+// AWS_SECRET_ACCESS_KEY and, for temporary credentials, AWS_SESSION_TOKEN.
+type awsSecret struct {
+	id       string
+	region   string
+	endpoint string
+	creds    sigv4.Credentials
+}
+
+func newAWS(id string) (*awsSecret, error) {
+	a := &awsSecret{
+		id:     id,
+		region: os.Getenv("AWS_REGION"),
+		creds: sigv4.Credentials{
+			AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+			SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+			SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+		},
+	}
+	if a.region == "" {
+		a.region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+	if a.region == "" || a.creds.AccessKey == "" || a.creds.SecretKey == "" {
+		return nil, fmt.Errorf("secrets: aws needs AWS_REGION, AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY")
+	}
+	a.endpoint = "https://secretsmanager." + a.region + ".amazonaws.com/"
+	return a, nil
+}
+
+func (a *awsSecret) Fetch() (map[string]string, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": a.id})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("POST", a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	sigv4.Sign(req, body, a.creds, a.region, "secretsmanager", time.Now())
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: aws: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("secrets: aws: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secrets: aws answered %s: %s", resp.Status, bytes.TrimSpace(b))
+	}
+	var r struct {
+		SecretString string `json:"SecretString"`
+	}
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("secrets: aws: %v", err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal([]byte(r.SecretString), &data); err != nil {
+		return nil, fmt.Errorf("secrets: aws: %s isn't a JSON object: %v", a.id, err)
+	}
+	return stringValues(data), nil
+}
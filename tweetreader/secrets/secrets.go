@@ -0,0 +1,41 @@
+// Package secrets fetches credentials from a secrets manager, so they don't
+// have to be handed to the process in its environment.
+// A secret is a set of key/value pairs, named like the environment variables
+// they stand in for, e.g. TWITTER_KEY or POSTGRES_URL.
+package secrets
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+)
+
+// Provider fetches the current values of one secret
+type Provider interface {
+	Fetch() (map[string]string, error)
+}
+
+// Config picks the secrets manager and the secret in it
+type Config struct {
+	// Backend is vault or aws
+	Backend string
+	// Path is the Vault path (secret/data/twitter-poll for KV v2) or the AWS secret ID or ARN
+	Path string
+}
+
+var client = &http.Client{Timeout: 10 * time.Second}
+
+// Open creates the provider described by cfg, reading the backend's own settings from the environment
+func Open(cfg Config) (Provider, error) {
+	if cfg.Path == "" {
+		return nil, fmt.Errorf("secrets: %s needs the path of the secret", cfg.Backend)
+	}
+	switch cfg.Backend {
+	case "vault":
+		return newVault(cfg.Path)
+	case "aws":
+		return newAWS(cfg.Path)
+	default:
+		return nil, fmt.Errorf("secrets: unknown backend %q, want vault or aws", cfg.Backend)
+	}
+}
@@ -0,0 +1,84 @@
+package secrets
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+)
+
+// vault reads a secret from HashiCorp Vault's KV engine, version 1 or 2,
+// authenticating with VAULT_TOKEN at VAULT_ADDR
+type vault struct {
+	url   string
+	token string
+}
+
+func newVault(path string) (*vault, error) {
+	addr, token := os.Getenv("VAULT_ADDR"), os.Getenv("VAULT_TOKEN")
+	if addr == "" || token == "" {
+		return nil, fmt.Errorf("secrets: vault needs VAULT_ADDR and VAULT_TOKEN")
+	}
+	return &vault{url: strings.TrimRight(addr, "/") + "/v1/" + strings.TrimLeft(path, "/"), token: token}, nil
+}
+
+// vaultResponse covers both KV versions: version 2 nests the values in data.data
+type vaultResponse struct {
+	Data struct {
+		Data     map[string]interface{} `json:"data"`
+		Metadata map[string]interface{} `json:"metadata"`
+	} `json:"data"`
+}
+
+func (v *vault) Fetch() (map[string]string, error) {
+	req, err := http.NewRequest("GET", v.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("secrets: vault: %v", err)
+	}
+	defer resp.Body.Close()
+	b, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, fmt.Errorf("secrets: vault: %v", err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("secrets: vault answered %s", resp.Status)
+	}
+	var r vaultResponse
+	if err := json.Unmarshal(b, &r); err != nil {
+		return nil, fmt.Errorf("secrets: vault: %v", err)
+	}
+	data := r.Data.Data
+	if r.Data.Metadata == nil {
+		// version 1 keeps the values directly in data
+		var v1 struct {
+			Data map[string]interface{} `json:"data"`
+		}
+		if err := json.Unmarshal(b, &v1); err != nil {
+			return nil, fmt.Errorf("secrets: vault: %v", err)
+		}
+		data = v1.Data
+	}
+	return stringValues(data), nil
+}
+
+// stringValues keeps the values that are strings, numbers and booleans are formatted
+func stringValues(data map[string]interface{}) map[string]string {
+	values := make(map[string]string, len(data))
+	for k, v := range data {
+		switch v := v.(type) {
+		case string:
+			values[k] = v
+		case float64, bool:
+			values[k] = fmt.Sprint(v)
+		}
+	}
+	return values
+}
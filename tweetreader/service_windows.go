@@ -0,0 +1,215 @@
+//go:build windows && service
+// +build windows,service
+
+package main
+
+// The service command runs the daemons as Windows services. It needs the
+// Windows service API, so it is only linked when asked for:
+//
+//	GOOS=windows go build -tags service
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+
+	"golang.org/x/sys/windows/registry"
+	"golang.org/x/sys/windows/svc"
+	"golang.org/x/sys/windows/svc/mgr"
+
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+)
+
+func init() {
+	optionalCommands = append(optionalCommands, &command{
+		name: "service", usage: "service install|remove|run [-name twitter-poll-<command>] [-config file] [-log file] <command> [flags]",
+		summary: "install, remove or run a command as a Windows service", run: runService,
+	})
+}
+
+// runService installs, removes or runs a command as the Windows service named
+func runService(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("want install, remove or run")
+	}
+	action, args := args[0], args[1:]
+	fs := newFlagSet("service")
+	var (
+		name   = fs.String("name", "", "name of the service, twitter-poll-<command> by default")
+		config = fs.String("config", "", "CONFIG_FILE the service reads its environment from, when installing")
+		logTo  = fs.String("log", "", "file the service logs to, when installing; a service has no stderr")
+	)
+	fs.Parse(args)
+	if fs.NArg() == 0 && action != "remove" {
+		return fmt.Errorf("want the command to run as a service, e.g. stream")
+	}
+	cmd := fs.Arg(0)
+	if *name == "" {
+		if cmd == "" {
+			return fmt.Errorf("-name is needed to remove a service")
+		}
+		*name = "twitter-poll-" + cmd
+	}
+	switch action {
+	case "install":
+		return installService(*name, *config, *logTo, fs.Args())
+	case "remove":
+		return removeService(*name)
+	case "run":
+		return runAsService(*name, *logTo, fs.Args())
+	}
+	return fmt.Errorf("invalid service action %q, want install, remove or run", action)
+}
+
+// installService installs the service running args, the command and its
+// flags, restarted by the service control manager when it fails
+func installService(name, config, logTo string, args []string) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return err
+	}
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+	if s, err := m.OpenService(name); err == nil {
+		s.Close()
+		return fmt.Errorf("service %s already exists", name)
+	}
+	run := []string{"service", "run", "-name", name}
+	if logTo != "" {
+		if logTo, err = filepath.Abs(logTo); err != nil {
+			return err
+		}
+		run = append(run, "-log", logTo)
+	}
+	s, err := m.CreateService(name, exe, mgr.Config{
+		DisplayName: "twitter-poll " + args[0],
+		Description: "twitter-poll " + args[0] + ", " + summaryOf(args[0]),
+		StartType:   mgr.StartAutomatic,
+	}, append(run, args...)...)
+	if err != nil {
+		return fmt.Errorf("failed to create service %s: %v", name, err)
+	}
+	defer s.Close()
+	if err := s.SetRecoveryActions([]mgr.RecoveryAction{
+		{Type: mgr.ServiceRestart, Delay: 5 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: 30 * time.Second},
+		{Type: mgr.ServiceRestart, Delay: time.Minute},
+	}, uint32((24 * time.Hour).Seconds())); err != nil {
+		log.Println("failed to set the service to restart on failure:", err)
+	}
+	if config != "" {
+		// the environment the service control manager starts the service with
+		if config, err = filepath.Abs(config); err != nil {
+			return err
+		}
+		k, err := registry.OpenKey(registry.LOCAL_MACHINE, `SYSTEM\CurrentControlSet\Services\`+name, registry.SET_VALUE)
+		if err != nil {
+			return fmt.Errorf("failed to set the environment of service %s: %v", name, err)
+		}
+		defer k.Close()
+		if err := k.SetStringsValue("Environment", []string{"CONFIG_FILE=" + config}); err != nil {
+			return fmt.Errorf("failed to set the environment of service %s: %v", name, err)
+		}
+	}
+	log.Printf("Installed service %s, start it with: sc start %s", name, name)
+	return nil
+}
+
+// removeService removes the service, stopped or not
+func removeService(name string) error {
+	m, err := mgr.Connect()
+	if err != nil {
+		return fmt.Errorf("failed to connect to the service control manager: %v", err)
+	}
+	defer m.Disconnect()
+	s, err := m.OpenService(name)
+	if err != nil {
+		return fmt.Errorf("no service %s: %v", name, err)
+	}
+	defer s.Close()
+	if err := s.Delete(); err != nil {
+		return fmt.Errorf("failed to remove service %s: %v", name, err)
+	}
+	log.Printf("Removed service %s, it goes once it stops", name)
+	return nil
+}
+
+// runAsService runs the command in args as the service, stopping it as a
+// SIGTERM would when the service is stopped or Windows shuts down
+func runAsService(name, logTo string, args []string) error {
+	inService, err := svc.IsWindowsService()
+	if err != nil {
+		return err
+	}
+	if !inService {
+		return fmt.Errorf("service run is for the service control manager, run %s directly instead", args[0])
+	}
+	if logTo != "" {
+		f, err := os.OpenFile(logTo, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		log.SetOutput(f)
+	}
+	var c *command
+	for _, cmd := range commands {
+		if cmd.name == args[0] && cmd.name != "service" {
+			c = cmd
+		}
+	}
+	if c == nil {
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+	return svc.Run(name, &serviceHandler{run: func() error { return c.run(args[1:]) }})
+}
+
+// serviceHandler answers the service control manager while run runs
+type serviceHandler struct {
+	run func() error
+}
+
+// Execute runs the command until it returns, asking it to stop on a stop or
+// a shutdown; a command failing fails the service, which is then restarted
+func (h *serviceHandler) Execute(args []string, requests <-chan svc.ChangeRequest, status chan<- svc.Status) (bool, uint32) {
+	const accepts = svc.AcceptStop | svc.AcceptShutdown
+	status <- svc.Status{State: svc.StartPending}
+	done := make(chan error, 1)
+	go func() { done <- h.run() }()
+	status <- svc.Status{State: svc.Running, Accepts: accepts}
+	for {
+		select {
+		case err := <-done:
+			status <- svc.Status{State: svc.StopPending}
+			if err != nil {
+				log.Println("service:", err)
+				return true, 1
+			}
+			return false, 0
+		case r := <-requests:
+			switch r.Cmd {
+			case svc.Interrogate:
+				status <- r.CurrentStatus
+			case svc.Stop, svc.Shutdown:
+				log.Println("service: stopping")
+				status <- svc.Status{State: svc.StopPending, WaitHint: uint32(time.Minute.Milliseconds())}
+				shutdown.Request()
+			}
+		}
+	}
+}
+
+// summaryOf returns the summary of the command named
+func summaryOf(name string) string {
+	for _, c := range commands {
+		if c.name == name {
+			return c.summary
+		}
+	}
+	return ""
+}
@@ -0,0 +1,118 @@
+// Package shadow compares the votes of a shadow matcher, configured the way
+// matching may be changed to, with the votes of the matcher in use, on the
+// same tweets. Only the primary's votes are published; how often the two
+// disagree, by tweet and by vote, is counted so a change can be judged on
+// the live stream before it is made.
+package shadow
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// Outcomes of a vote of either matcher
+const (
+	// Both is a vote both matchers cast
+	Both = "both"
+	// PrimaryOnly is a vote only the matcher in use cast
+	PrimaryOnly = "primary_only"
+	// ShadowOnly is a vote only the shadow matcher cast
+	ShadowOnly = "shadow_only"
+)
+
+var (
+	tweets = metrics.NewCounter("tweetreader_shadow_tweets_total",
+		"Tweets either the primary or the shadow matcher found votes in, by whether they agree on the options voted for.")
+	votes = metrics.NewCounter("tweetreader_shadow_votes_total",
+		"Votes cast by the primary or the shadow matcher, by outcome: both, primary_only or shadow_only.")
+)
+
+// Config is what is held against the shadow matcher's votes besides its own
+// configuration
+type Config struct {
+	// Exact leaves out the shadow votes only found inside longer words, as
+	// if every option of every poll matched exactly
+	Exact bool
+	// CaseSensitive leaves out the shadow votes only found in another case
+	CaseSensitive bool
+	// LogEvery is how often a tweet the matchers disagree on is logged at
+	// most, a minute by default
+	LogEvery time.Duration
+}
+
+// Comparer counts the disagreements of the shadow matcher
+type Comparer struct {
+	cfg Config
+
+	mu       sync.Mutex
+	logged   time.Time
+	unlogged int // disagreements since the last one logged
+	now      func() time.Time
+}
+
+// New creates a Comparer with cfg
+func New(cfg Config) *Comparer {
+	if cfg.LogEvery <= 0 {
+		cfg.LogEvery = time.Minute
+	}
+	return &Comparer{cfg: cfg, now: time.Now}
+}
+
+// counts reports whether the shadow vote v is cast with the Config
+func (c *Comparer) counts(v match.Vote) bool {
+	if c.cfg.Exact && v.Partial && v.Stemmed == "" {
+		return false
+	}
+	return !c.cfg.CaseSensitive || !v.CaseFolded
+}
+
+// Compare counts how the shadow's votes for t differ from the primary's, it
+// is the compare of match.Matcher.Shadow
+func (c *Comparer) Compare(t stream.Tweet, primary, shadowed []match.Vote) {
+	cast := make(map[string]bool, len(shadowed))
+	for _, v := range shadowed {
+		if c.counts(v) {
+			cast[v.Option] = true
+		}
+	}
+	if len(primary) == 0 && len(cast) == 0 {
+		return
+	}
+	var missed, added []string
+	for _, v := range primary {
+		if cast[v.Option] {
+			votes.Inc("outcome", Both)
+			delete(cast, v.Option)
+			continue
+		}
+		votes.Inc("outcome", PrimaryOnly)
+		missed = append(missed, v.Option)
+	}
+	for o := range cast {
+		votes.Inc("outcome", ShadowOnly)
+		added = append(added, o)
+	}
+	if len(missed) == 0 && len(added) == 0 {
+		tweets.Inc("agreement", "same")
+		return
+	}
+	tweets.Inc("agreement", "different")
+	c.mu.Lock()
+	now := c.now()
+	if now.Sub(c.logged) < c.cfg.LogEvery {
+		c.unlogged++
+		c.mu.Unlock()
+		return
+	}
+	skipped := c.unlogged
+	c.logged, c.unlogged = now, 0
+	c.mu.Unlock()
+	log.Printf("shadow: tweet %s, only the primary voted for [%s], only the shadow for [%s] (%d more disagreements since the last logged)",
+		t.ID, strings.Join(missed, ", "), strings.Join(added, ", "), skipped)
+}
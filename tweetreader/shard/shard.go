@@ -0,0 +1,192 @@
+// Package shard splits the poll options between several streamers, so together
+// they can track more options than one connection to Twitter accepts.
+//
+// Every streamer holds a lease in the store and renews it while it runs. The
+// streamers whose leases are valid are the members, and each option belongs to
+// exactly one of them by rendezvous hashing: every member computes the same
+// owner without talking to the others, and a member joining or leaving only
+// moves the options it gains or loses. While the members disagree during a
+// change an option may briefly be tracked twice, the counter drops the
+// duplicate votes by their message ID.
+package shard
+
+import (
+	"hash/fnv"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Group is the lease group the streamers join
+const Group = "streamers"
+
+var (
+	membersGauge = metrics.NewGauge("tweetreader_shard_members",
+		"Streamers splitting the options between them, as this one sees it.")
+	leaseErrors = metrics.NewCounter("tweetreader_shard_lease_errors_total",
+		"Failed attempts to renew the lease or list the other streamers.")
+)
+
+// Coordinator keeps this streamer's lease and tells which options are its own
+type Coordinator struct {
+	leases store.LeaseStore
+	id     string
+	ttl    time.Duration
+	stop   chan struct{}
+	done   chan struct{}
+
+	mu      sync.RWMutex
+	members []string // sorted, always including id
+}
+
+// New creates a Coordinator for the streamer id, whose lease lasts ttl
+func New(leases store.LeaseStore, id string, ttl time.Duration) *Coordinator {
+	membersGauge.Set(1)
+	return &Coordinator{
+		leases:  leases,
+		id:      id,
+		ttl:     ttl,
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+		members: []string{id},
+	}
+}
+
+// Start joins the group and keeps renewing the lease every third of its ttl,
+// calling changed whenever the members change. Until the store answers the
+// streamer carries on with the members it last saw, alone at first.
+func (c *Coordinator) Start(changed func()) {
+	c.renew()
+	go func() {
+		defer close(c.done)
+		tick := time.NewTicker(c.ttl / 3)
+		defer tick.Stop()
+		for {
+			select {
+			case <-c.stop:
+				return
+			case <-tick.C:
+				if c.renew() {
+					changed()
+				}
+			}
+		}
+	}()
+}
+
+// renew extends the lease and reloads the members, reporting whether they changed
+func (c *Coordinator) renew() bool {
+	now := time.Now()
+	if err := c.leases.RenewLease(Group, c.id, now.Add(c.ttl)); err != nil {
+		leaseErrors.Inc()
+		log.Println("shard: failed to renew the lease, keeping the last members:", err)
+		return false
+	}
+	members, err := c.leases.Leases(Group, now)
+	if err != nil {
+		leaseErrors.Inc()
+		log.Println("shard: failed to list the streamers, keeping the last members:", err)
+		return false
+	}
+	sort.Strings(members) // the database may collate differently
+	members = withMember(members, c.id)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if equal(members, c.members) {
+		return false
+	}
+	log.Printf("shard: %d streamers: %v", len(members), members)
+	c.members = members
+	membersGauge.Set(float64(len(members)))
+	return true
+}
+
+// Stop stops renewing and gives the lease up, so the other members take over
+// this streamer's options without waiting for it to expire
+func (c *Coordinator) Stop() {
+	close(c.stop)
+	<-c.done
+	if err := c.leases.ReleaseLease(Group, c.id); err != nil {
+		log.Println("shard: failed to release the lease, it expires in", c.ttl, err)
+	}
+}
+
+// Owns reports whether option belongs to this streamer
+func (c *Coordinator) Owns(option string) bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return owner(c.members, option) == c.id
+}
+
+// Options wraps a function loading every option so it only returns this streamer's
+func (c *Coordinator) Options(load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		all, err := load()
+		if err != nil {
+			return nil, err
+		}
+		var own []string
+		for _, o := range all {
+			if c.Owns(o) {
+				own = append(own, o)
+			}
+		}
+		log.Printf("shard: tracking %d of %d options", len(own), len(all))
+		return own, nil
+	}
+}
+
+// owner is the member with the highest score for option
+func owner(members []string, option string) string {
+	o := hash(option)
+	var best string
+	var bestScore uint64
+	for _, m := range members {
+		if s := mix(hash(m) ^ o); best == "" || s > bestScore {
+			best, bestScore = m, s
+		}
+	}
+	return best
+}
+
+func hash(s string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(s))
+	return h.Sum64()
+}
+
+// mix is splitmix64's finalizer, FNV alone spreads similar names like opt1, opt2 poorly
+func mix(x uint64) uint64 {
+	x ^= x >> 30
+	x *= 0xbf58476d1ce4e5b9
+	x ^= x >> 27
+	x *= 0x94d049bb133111eb
+	return x ^ x>>31
+}
+
+func withMember(members []string, id string) []string {
+	i := sort.SearchStrings(members, id)
+	if i < len(members) && members[i] == id {
+		return members
+	}
+	members = append(members, "")
+	copy(members[i+1:], members[i:])
+	members[i] = id
+	return members
+}
+
+func equal(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,37 @@
+package shutdown
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+)
+
+// notified are the channels Notify was given, told about a Request
+var notified struct {
+	sync.Mutex
+	chans []chan<- os.Signal
+}
+
+// Notify relays SIGINT and SIGTERM to c, and any other signal given, like
+// signal.Notify, and each Request as a SIGTERM
+func Notify(c chan<- os.Signal, sig ...os.Signal) {
+	signal.Notify(c, append([]os.Signal{syscall.SIGINT, syscall.SIGTERM}, sig...)...)
+	notified.Lock()
+	notified.chans = append(notified.chans, c)
+	notified.Unlock()
+}
+
+// Request stops the process as a SIGTERM would, for what stops it without a
+// signal, such as the Windows service control manager. Like a signal it is
+// dropped for a channel that isn't ready to take it.
+func Request() {
+	notified.Lock()
+	defer notified.Unlock()
+	for _, c := range notified.chans {
+		select {
+		case c <- syscall.SIGTERM:
+		default:
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package shutdown
+
+import (
+	"log"
+	"net/http"
+	"sync/atomic"
+	"time"
+)
+
+// Readiness backs a readiness probe: ready until the process drains, so a
+// load balancer or Kubernetes takes it out of rotation before it stops
+type Readiness struct {
+	// Degraded, when set, returns why the process can't do its work for now,
+	// not ready until it returns nil again
+	Degraded func() error
+	// Notes, when set, returns what the answer adds while ready, e.g. the
+	// work the process sheds; nothing when empty
+	Notes func() string
+
+	draining int32
+}
+
+// Drain reports not ready from now on and waits delay, for the probes to
+// notice before anything stops
+func (r *Readiness) Drain(delay time.Duration) {
+	atomic.StoreInt32(&r.draining, 1)
+	if delay > 0 {
+		log.Println("Not ready, draining in", delay)
+		time.Sleep(delay)
+	}
+}
+
+// Ready reports whether the process hasn't started draining
+func (r *Readiness) Ready() bool {
+	return atomic.LoadInt32(&r.draining) == 0
+}
+
+// ServeHTTP answers the readiness probe, 503 once draining or while degraded
+func (r *Readiness) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if !r.Ready() {
+		http.Error(w, "draining", http.StatusServiceUnavailable)
+		return
+	}
+	if r.Degraded != nil {
+		if err := r.Degraded(); err != nil {
+			http.Error(w, "degraded: "+err.Error(), http.StatusServiceUnavailable)
+			return
+		}
+	}
+	if r.Notes != nil {
+		if notes := r.Notes(); notes != "" {
+			w.Write([]byte("ok, " + notes + "\n"))
+			return
+		}
+	}
+	w.Write([]byte("ok\n"))
+}
+
+// Live answers the liveness probe, fine for as long as the process serves it
+func Live(w http.ResponseWriter, req *http.Request) {
+	w.Write([]byte("ok\n"))
+}
@@ -0,0 +1,146 @@
+// Package shutdown stops a pipeline's stages one after the other.
+// Each stage gets its own timeout, so one stuck stage can't hold the
+// process forever, and the outcome of every stage is reported at the end.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// ErrTimeout is reported for a stage that didn't stop within its timeout
+var ErrTimeout = errors.New("timed out")
+
+// ErrSkipped is reported for a chained stage whose previous stage didn't stop cleanly
+var ErrSkipped = errors.New("skipped")
+
+// Stop stops one stage, it should return once ctx is done at the latest
+type Stop func(ctx context.Context) error
+
+type stage struct {
+	name    string
+	timeout time.Duration
+	stop    Stop
+	chained bool
+}
+
+// Coordinator stops stages in the order they were added
+type Coordinator struct {
+	// Grace, when set, is how long the whole shutdown may take, e.g. the
+	// grace period Kubernetes waits after SIGTERM: a stage gets its timeout
+	// or what is left of Grace, whichever is shorter, and once Grace is up
+	// the stages left time out without being run.
+	Grace  time.Duration
+	stages []stage
+}
+
+// Add appends a stage, stopped after every stage added before it
+func (c *Coordinator) Add(name string, timeout time.Duration, stop Stop) {
+	c.stages = append(c.stages, stage{name: name, timeout: timeout, stop: stop})
+}
+
+// Chain is like Add, but the stage is skipped when the one before it didn't stop cleanly.
+// It is for stages that must not start while the previous one may still be running.
+func (c *Coordinator) Chain(name string, timeout time.Duration, stop Stop) {
+	c.stages = append(c.stages, stage{name: name, timeout: timeout, stop: stop, chained: true})
+}
+
+// Shutdown stops every stage in order. A stage that fails or times out
+// doesn't stop the ones after it, they still get their chance to flush.
+func (c *Coordinator) Shutdown() Report {
+	report := make(Report, 0, len(c.stages))
+	deadline := time.Now().Add(c.Grace)
+	for i, s := range c.stages {
+		if s.chained && i > 0 && report[i-1].Err != nil {
+			report = append(report, Result{Stage: s.name, Err: ErrSkipped})
+			continue
+		}
+		if c.Grace > 0 {
+			left := time.Until(deadline)
+			if left <= 0 {
+				report = append(report, Result{Stage: s.name, Err: ErrTimeout})
+				continue
+			}
+			if left < s.timeout {
+				s.timeout = left
+			}
+		}
+		start := time.Now()
+		err := s.run()
+		report = append(report, Result{Stage: s.name, Took: time.Since(start), Err: err})
+	}
+	return report
+}
+
+func (s stage) run() error {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+	done := make(chan error, 1)
+	go func() { done <- s.stop(ctx) }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ErrTimeout
+	}
+}
+
+// Wait returns a Stop that waits for ch to be closed or receive
+func Wait(ch <-chan struct{}) Stop {
+	return func(ctx context.Context) error {
+		select {
+		case <-ch:
+			return nil
+		case <-ctx.Done():
+			return ErrTimeout
+		}
+	}
+}
+
+// Result is how stopping one stage went
+type Result struct {
+	Stage string
+	Took  time.Duration
+	Err   error
+}
+
+// Report lists the result of every stage, in the order they were stopped
+type Report []Result
+
+// OK reports whether every stage stopped cleanly
+func (r Report) OK() bool {
+	for _, res := range r {
+		if res.Err != nil {
+			return false
+		}
+	}
+	return true
+}
+
+func (r Report) String() string {
+	parts := make([]string, len(r))
+	for i, res := range r {
+		switch res.Err {
+		case nil:
+			parts[i] = fmt.Sprintf("%s stopped in %s", res.Stage, res.Took.Round(time.Millisecond))
+		case ErrSkipped:
+			parts[i] = res.Stage + " skipped"
+		default:
+			parts[i] = fmt.Sprintf("%s %v after %s", res.Stage, res.Err, res.Took.Round(time.Millisecond))
+		}
+	}
+	return strings.Join(parts, ", ")
+}
+
+// Log writes the report as one line
+func (r Report) Log() {
+	if r.OK() {
+		log.Println("Shutdown complete:", r)
+		return
+	}
+	log.Println("Shutdown incomplete:", r)
+}
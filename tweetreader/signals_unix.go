@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// daemonSignals are the signals handleDaemonSignals handles
+var daemonSignals = []os.Signal{syscall.SIGHUP, syscall.SIGUSR1}
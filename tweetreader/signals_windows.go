@@ -0,0 +1,10 @@
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// daemonSignals are the signals handleDaemonSignals handles. Windows has no
+// SIGUSR1, and only sends a console's SIGHUP; the admin API refreshes instead.
+var daemonSignals = []os.Signal{syscall.SIGHUP}
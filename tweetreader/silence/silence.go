@@ -0,0 +1,353 @@
+// Package silence warns about options that stop matching while the others
+// keep getting votes, which usually means Twitter dropped the term from the
+// stream's filter, over its limits, or that it is misspelled.
+//
+// Every vote records when its option last matched. A tracked option goes
+// silent once it matched nothing for After, counted from when it was first
+// tracked, while its siblings, the other options of its polls, got at least
+// MinVotes over that time; the options of polls with no other option are
+// compared to every option tracked. Each silent spell is alerted once, and
+// logged when the option matches again. What matched is only known to the
+// streamer, every one watches the options it tracks.
+package silence
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// buckets is how many buckets the votes of the last After are counted in
+const buckets = 10
+
+var alerts = metrics.NewCounter("tweetreader_silence_alerts_total",
+	"Options alerted for matching no vote while their siblings got them.")
+
+// Config says when options go silent, a zero After only tracks their last match
+type Config struct {
+	// After is how long an option goes without a vote before it is silent
+	After time.Duration
+	// MinVotes is the fewest votes its siblings get meanwhile, 20 by default
+	MinVotes int
+	// Tracked returns the options the streams track now
+	Tracked func() []string
+	// Events, if set, is told about every silent option as events.OptionSilent
+	Events *events.Bus
+	// Alerts, if set, publishes every silent option as an Alert in JSON
+	Alerts Publisher
+}
+
+// Publisher is the part of publish.Publisher alerts are sent with
+type Publisher interface {
+	Publish(b []byte) error
+}
+
+// Alert describes an option gone silent
+type Alert struct {
+	Kind   string `json:"kind"` // option_silent
+	Option string `json:"option"`
+	// LastMatch is when the option last matched a vote, nil when it never did
+	LastMatch *time.Time `json:"last_match"`
+	// Tracked is since when the streams track it
+	Tracked      time.Time `json:"tracked"`
+	SiblingVotes int       `json:"sibling_votes"` // over the last After
+	After        string    `json:"after"`         // e.g. 30m0s
+	Time         time.Time `json:"time"`
+}
+
+// Option is what a Watcher knows of a tracked option
+type Option struct {
+	Option    string     `json:"option"`
+	Tracked   time.Time  `json:"tracked"`
+	LastMatch *time.Time `json:"last_match,omitempty"`
+	Silent    bool       `json:"silent,omitempty"`
+}
+
+// Watcher keeps track of the last match of every option
+type Watcher struct {
+	cfg   Config
+	width time.Duration // of a bucket
+
+	mu       sync.Mutex
+	options  map[string]*state
+	siblings map[string][]string // the other options of the polls having each option
+	now      func() time.Time
+}
+
+// state is the state of one option
+type state struct {
+	tracked time.Time // when the streams started tracking it, zero until they do
+	last    time.Time // its last vote
+	votes   [buckets]int
+	epochs  [buckets]int64 // of the votes in each bucket
+	silent  bool
+}
+
+// New creates a Watcher with cfg
+func New(cfg Config) *Watcher {
+	if cfg.MinVotes <= 0 {
+		cfg.MinVotes = 20
+	}
+	w := &Watcher{cfg: cfg, width: cfg.After / buckets, options: make(map[string]*state), now: time.Now}
+	if w.width <= 0 {
+		w.width = time.Minute
+	}
+	metrics.NewGaugeFunc("tweetreader_silent_options", "Tracked options matching no vote while their siblings get them.", func() float64 {
+		w.mu.Lock()
+		defer w.mu.Unlock()
+		n := 0
+		for _, s := range w.options {
+			if s.silent {
+				n++
+			}
+		}
+		return float64(n)
+	})
+	return w
+}
+
+// epoch is the bucket at t
+func (w *Watcher) epoch(t time.Time) int64 {
+	return t.UnixNano() / int64(w.width)
+}
+
+// Matched records a vote for option
+func (w *Watcher) Matched(option string) {
+	w.mu.Lock()
+	now := w.now()
+	s := w.options[option]
+	if s == nil {
+		s = &state{}
+		w.options[option] = s
+	}
+	s.last = now
+	e := w.epoch(now)
+	if i := e % buckets; s.epochs[i] != e {
+		s.epochs[i], s.votes[i] = e, 0
+	}
+	s.votes[e%buckets]++
+	resumed := s.silent
+	s.silent = false
+	w.mu.Unlock()
+	if resumed {
+		log.Printf("silence: option %s matches votes again", option)
+	}
+}
+
+// recent is how many votes s got over the last After at epoch e
+func (s *state) recent(e int64) int {
+	n := 0
+	for i, at := range s.epochs {
+		if at > e-buckets {
+			n += s.votes[i]
+		}
+	}
+	return n
+}
+
+// Run passes on the votes from in, recording their options, the returned channel is closed once in is
+func (w *Watcher) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			w.Matched(v.Option)
+			out <- v
+		}
+	}()
+	return out
+}
+
+// Update learns the siblings of the options from the tracked polls
+func (w *Watcher) Update(polls []store.Poll) {
+	siblings := make(map[string][]string)
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		for _, o := range p.Options {
+			for _, sibling := range p.Options {
+				if sibling != o {
+					siblings[o] = append(siblings[o], sibling)
+				}
+			}
+		}
+	}
+	w.mu.Lock()
+	w.siblings = siblings
+	w.mu.Unlock()
+}
+
+// Options wraps a function loading the options so every load also updates
+// the siblings of the options. When the polls can't be loaded the last ones
+// are kept.
+func (w *Watcher) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("silence: failed to load the polls, keeping the last siblings:", err)
+			return options, nil
+		}
+		w.Update(all)
+		return options, nil
+	}
+}
+
+// Check alerts the tracked options gone silent since the last check, and
+// forgets the ones no longer tracked
+func (w *Watcher) Check() {
+	if w.cfg.After <= 0 {
+		return
+	}
+	tracked := w.track()
+	w.mu.Lock()
+	now := w.now()
+	e := w.epoch(now)
+	var silent []Alert
+	for _, o := range tracked {
+		s := w.options[o]
+		quiet := s.tracked
+		if s.last.After(quiet) {
+			quiet = s.last
+		}
+		if s.silent || now.Sub(quiet) < w.cfg.After {
+			continue
+		}
+		siblings := w.siblings[o]
+		if len(siblings) == 0 {
+			siblings = tracked
+		}
+		votes := 0
+		counted := map[string]bool{o: true}
+		for _, sibling := range siblings {
+			if ss := w.options[sibling]; !counted[sibling] && ss != nil {
+				counted[sibling] = true
+				votes += ss.recent(e)
+			}
+		}
+		if votes < w.cfg.MinVotes {
+			continue
+		}
+		s.silent = true
+		a := Alert{Kind: "option_silent", Option: o, Tracked: s.tracked, SiblingVotes: votes, After: w.cfg.After.String(), Time: now}
+		if !s.last.IsZero() {
+			last := s.last
+			a.LastMatch = &last
+		}
+		silent = append(silent, a)
+	}
+	w.mu.Unlock()
+	for _, a := range silent {
+		w.alert(a)
+	}
+}
+
+// track starts tracking the options the streams track from now on, and
+// forgets the others; it returns the tracked options
+func (w *Watcher) track() []string {
+	var all []string
+	if w.cfg.Tracked != nil {
+		all = w.cfg.Tracked()
+	}
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	now := w.now()
+	// the streams of several accounts may track the same options
+	tracked := all[:0:0]
+	seen := make(map[string]bool, len(all))
+	for _, o := range all {
+		if seen[o] {
+			continue
+		}
+		seen[o] = true
+		tracked = append(tracked, o)
+		s := w.options[o]
+		if s == nil {
+			s = &state{}
+			w.options[o] = s
+		}
+		if s.tracked.IsZero() {
+			s.tracked = now
+		}
+	}
+	for o := range w.options {
+		if !seen[o] {
+			delete(w.options, o)
+		}
+	}
+	return tracked
+}
+
+// Watch checks the options every tenth of After until stop is closed, it
+// returns at once when After is 0
+func (w *Watcher) Watch(stop <-chan struct{}) {
+	if w.cfg.After <= 0 {
+		return
+	}
+	tick := time.NewTicker(w.width)
+	defer tick.Stop()
+	w.track()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-tick.C:
+			w.Check()
+		}
+	}
+}
+
+// List returns the tracked options by name, with when they last matched
+func (w *Watcher) List() []Option {
+	tracked := w.track()
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	list := make([]Option, 0, len(tracked))
+	for _, o := range tracked {
+		s := w.options[o]
+		opt := Option{Option: o, Tracked: s.tracked, Silent: s.silent}
+		if !s.last.IsZero() {
+			last := s.last
+			opt.LastMatch = &last
+		}
+		list = append(list, opt)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Option < list[j].Option })
+	return list
+}
+
+func (w *Watcher) alert(a Alert) {
+	alerts.Inc()
+	last := "never"
+	if a.LastMatch != nil {
+		last = a.LastMatch.Format(time.RFC3339)
+	}
+	detail := fmt.Sprintf("option %s: no vote for %s while its siblings got %d, last matched %s", a.Option, a.After, a.SiblingVotes, last)
+	log.Println("silent option:", detail)
+	w.cfg.Events.Emit(events.OptionSilent, detail)
+	if w.cfg.Alerts == nil {
+		return
+	}
+	b, err := json.Marshal(a)
+	if err != nil {
+		log.Println("failed to encode the alert:", err)
+		return
+	}
+	go func() {
+		if err := w.cfg.Alerts.Publish(b); err != nil {
+			log.Println("failed to publish the alert:", err)
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+// Latency keeps a histogram of the durations seen within the window to compute quantiles from
+type Latency struct {
+	buckets []float64 // upper bounds in seconds, ascending
+
+	mu     sync.Mutex
+	seq    [slots]int64
+	counts [slots][]float64 // per slot and bucket, the last bucket is unbounded
+}
+
+// NewLatency tracks durations in buckets with the given upper bounds, in seconds
+func NewLatency(buckets []float64) *Latency {
+	l := &Latency{buckets: buckets}
+	for i := range l.counts {
+		l.counts[i] = make([]float64, len(buckets)+1)
+	}
+	return l
+}
+
+// Observe records a duration
+func (l *Latency) Observe(d time.Duration) {
+	s := d.Seconds()
+	b := 0
+	for b < len(l.buckets) && s > l.buckets[b] {
+		b++
+	}
+	i, seq := slot(time.Now())
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.seq[i] != seq {
+		l.seq[i] = seq
+		for j := range l.counts[i] {
+			l.counts[i][j] = 0
+		}
+	}
+	l.counts[i][b]++
+}
+
+// Quantile returns the q quantile (0.99 for p99) of the durations within the window in seconds,
+// interpolated within its bucket like histogram_quantile does. It is 0 when nothing was observed
+// and the largest bucket bound when the quantile falls beyond it.
+func (l *Latency) Quantile(q float64) float64 {
+	_, seq := slot(time.Now())
+	sum := make([]float64, len(l.buckets)+1)
+	var total float64
+	l.mu.Lock()
+	for i := range l.seq {
+		if seq-l.seq[i] < slots {
+			for b, n := range l.counts[i] {
+				sum[b] += n
+				total += n
+			}
+		}
+	}
+	l.mu.Unlock()
+	if total == 0 || len(l.buckets) == 0 {
+		return 0
+	}
+	rank := q * total
+	var seen float64
+	for b, n := range sum {
+		if seen+n < rank || n == 0 {
+			seen += n
+			continue
+		}
+		if b == len(l.buckets) {
+			break
+		}
+		var lower float64
+		if b > 0 {
+			lower = l.buckets[b-1]
+		}
+		return lower + (l.buckets[b]-lower)*(rank-seen)/n
+	}
+	return l.buckets[len(l.buckets)-1]
+}
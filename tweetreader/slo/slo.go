@@ -0,0 +1,63 @@
+// Package slo tracks service level indicators over a sliding window,
+// so they can be exported as ready-made gauges and alerting rules compare
+// a single number to the objective instead of doing rate arithmetic in PromQL.
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+// Window is how far back every indicator looks
+const Window = 5 * time.Minute
+
+// slots is how many parts Window is split into, the oldest part is dropped as time moves on
+const slots = 10
+
+const slotWidth = Window / slots
+
+// slot returns the index of the slot t falls in and its sequence number
+func slot(t time.Time) (int, int64) {
+	n := t.UnixNano() / int64(slotWidth)
+	return int(n % slots), n
+}
+
+// Ratio is the share of attempts that succeeded within the window
+type Ratio struct {
+	mu    sync.Mutex
+	seq   [slots]int64
+	ok    [slots]float64
+	total [slots]float64
+}
+
+// Observe records an attempt
+func (r *Ratio) Observe(ok bool) {
+	i, seq := slot(time.Now())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.seq[i] != seq {
+		r.seq[i], r.ok[i], r.total[i] = seq, 0, 0
+	}
+	r.total[i]++
+	if ok {
+		r.ok[i]++
+	}
+}
+
+// Value returns the success ratio within the window, 1 when nothing was attempted
+func (r *Ratio) Value() float64 {
+	_, seq := slot(time.Now())
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var ok, total float64
+	for i := range r.seq {
+		if seq-r.seq[i] < slots {
+			ok += r.ok[i]
+			total += r.total[i]
+		}
+	}
+	if total == 0 {
+		return 1
+	}
+	return ok / total
+}
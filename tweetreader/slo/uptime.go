@@ -0,0 +1,82 @@
+package slo
+
+import (
+	"sync"
+	"time"
+)
+
+// Uptime is the share of the window something was up, such as the Twitter stream being connected
+type Uptime struct {
+	mu      sync.Mutex
+	started time.Time
+	up      bool     // current state
+	changes []change // state changes within the window, oldest first
+	before  bool     // state at the start of the window
+}
+
+type change struct {
+	at time.Time
+	up bool
+}
+
+// NewUptime starts tracking, down until Set says otherwise
+func NewUptime() *Uptime {
+	return &Uptime{started: time.Now()}
+}
+
+// Set records the current state
+func (u *Uptime) Set(up bool) {
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if up == u.up {
+		return
+	}
+	u.up = up
+	u.changes = append(u.changes, change{at: now, up: up})
+	u.prune(now)
+}
+
+// prune forgets the changes that happened before the window, must be called with mu held
+func (u *Uptime) prune(now time.Time) {
+	cutoff := now.Add(-Window)
+	for len(u.changes) > 0 && u.changes[0].at.Before(cutoff) {
+		u.before = u.changes[0].up
+		u.changes = u.changes[1:]
+	}
+}
+
+// Up reports the current state
+func (u *Uptime) Up() bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.up
+}
+
+// Value returns the share of the window, or of the time since tracking started if shorter, spent up
+func (u *Uptime) Value() float64 {
+	now := time.Now()
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	u.prune(now)
+	from := now.Add(-Window)
+	if u.started.After(from) {
+		from = u.started
+	}
+	span := now.Sub(from)
+	if span <= 0 {
+		return 0
+	}
+	var upFor time.Duration
+	state, at := u.before, from
+	for _, c := range u.changes {
+		if state {
+			upFor += c.at.Sub(at)
+		}
+		state, at = c.up, c.at
+	}
+	if state {
+		upFor += now.Sub(at)
+	}
+	return upFor.Seconds() / span.Seconds()
+}
@@ -0,0 +1,284 @@
+//go:build soak
+// +build soak
+
+// Command soak drives the counter with a fake stream for hours and checks that
+// no votes are lost or double counted and that memory stays bounded, while the
+// stream redelivers votes and the counter is restarted from its snapshots.
+//
+//	go run -tags soak ./soak -duration 4h -rate 500
+//
+// It exits non-zero as soon as an invariant is broken.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math/rand"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/count"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+	"github.com/olawolu/twitter-polls/tweetreader/timeseries"
+)
+
+// options are words that never contain one another, so every tweet matches exactly what it names
+var options = []string{"alpha", "bravo", "charlie", "delta", "echo", "foxtrot", "golf", "hotel", "india", "juliett"}
+
+// inFlight is how many of the latest votes are redelivered after a restart
+const inFlight = 1000
+
+var (
+	duration  = flag.Duration("duration", time.Hour, "how long to run")
+	rate      = flag.Int("rate", 500, "tweets per second from the fake stream")
+	redeliver = flag.Float64("redeliver", 0.01, "fraction of votes published twice, like an NSQ redelivery")
+	restart   = flag.Duration("restart-every", 10*time.Minute, "restart the counter this often (0 to never restart)")
+	report    = flag.Duration("report", 30*time.Second, "how often progress is reported and memory checked")
+	maxHeap   = flag.Int("max-heap-mb", 256, "fail if the live heap grows past this many MiB")
+	verbose   = flag.Bool("v", false, "show the counter's logs")
+	codecName = flag.String("codec", "json", "codec the fake stream publishes votes with")
+)
+
+func main() {
+	flag.Parse()
+	if !*verbose {
+		log.SetOutput(ioutil.Discard)
+	}
+	if err := soak(); err != nil {
+		fmt.Fprintln(os.Stderr, "soak: FAIL:", err)
+		os.Exit(1)
+	}
+	fmt.Fprintln(os.Stderr, "soak: PASS")
+}
+
+func soak() error {
+	c, err := codec.Lookup(*codecName)
+	if err != nil {
+		return err
+	}
+	db := newMemStore(options)
+	matcher := match.NewMatcher(nil)
+	matcher.Update(options)
+	injected := make(map[string]int)
+
+	cnt := startCounter(db)
+	var recent [][]byte // the last votes published, replayed after each restart
+	start := time.Now()
+	var tweets, duplicates int
+	var id int64 = 1290000000000000000
+
+	tick := time.NewTicker(time.Second / time.Duration(*rate))
+	defer tick.Stop()
+	reports := time.NewTicker(*report)
+	defer reports.Stop()
+	restarts := make(<-chan time.Time)
+	if *restart > 0 {
+		t := time.NewTicker(*restart)
+		defer t.Stop()
+		restarts = t.C
+	}
+	deadline := time.After(*duration)
+
+	for {
+		select {
+		case <-tick.C:
+			id++
+			t := fakeTweet(id)
+			for _, v := range matcher.Match(t) {
+				b, err := codec.Encode(c, &v)
+				if err != nil {
+					return err
+				}
+				injected[v.Option]++
+				cnt.pub.Publish(b)
+				if rand.Float64() < *redeliver {
+					cnt.pub.Publish(b)
+					duplicates++
+				}
+				if len(recent) == inFlight {
+					recent = recent[1:]
+				}
+				recent = append(recent, b)
+			}
+			tweets++
+		case <-restarts:
+			if err := cnt.stop(); err != nil {
+				return err
+			}
+			if err := db.check(injected, false); err != nil {
+				return fmt.Errorf("after restart: %v", err)
+			}
+			cnt = startCounter(db)
+			// the broker redelivers what was in flight, the snapshot must recognise it
+			for _, b := range recent {
+				cnt.pub.Publish(b)
+				duplicates++
+			}
+			fmt.Fprintf(os.Stderr, "soak: restarted counter, replayed %d votes\n", len(recent))
+		case <-reports.C:
+			var mem runtime.MemStats
+			runtime.GC()
+			runtime.ReadMemStats(&mem)
+			heap := mem.HeapAlloc >> 20
+			fmt.Fprintf(os.Stderr, "soak: %s tweets=%d duplicates=%d heap=%dMiB goroutines=%d\n",
+				time.Since(start).Round(time.Second), tweets, duplicates, heap, runtime.NumGoroutine())
+			if heap > uint64(*maxHeap) {
+				return fmt.Errorf("heap is %dMiB, limit is %dMiB", heap, *maxHeap)
+			}
+			if err := db.check(injected, true); err != nil {
+				return err
+			}
+		case <-deadline:
+			if err := cnt.stop(); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "soak: %d tweets, %d duplicates injected\n", tweets, duplicates)
+			return db.check(injected, false)
+		}
+	}
+}
+
+// fakeTweet names one or two options, the way a real vote sometimes does
+func fakeTweet(id int64) stream.Tweet {
+	text := "voting for " + options[rand.Intn(len(options))]
+	if rand.Intn(10) == 0 {
+		text += " and " + options[rand.Intn(len(options))]
+	}
+	t := stream.Tweet{ID: strconv.FormatInt(id, 10), CreatedAt: time.Now().Format(time.RubyDate), Text: text}
+	t.User.ScreenName = "soak" + strconv.Itoa(rand.Intn(10000))
+	return t
+}
+
+// counter is one run of the counter fed through an in-memory broker
+type counter struct {
+	pub  *publish.Memory
+	done chan error
+}
+
+func startCounter(db store.Backend) *counter {
+	c := &counter{pub: publish.NewMemory(1024), done: make(chan error, 1)}
+	go func() {
+		c.done <- count.RunLocal(count.Config{
+			MetricsAddr:      "127.0.0.1:0",
+			MetricsMaxSeries: 1000,
+			UpdateInterval:   time.Second,
+			PollCacheTTL:     5 * time.Minute,
+			DedupWindow:      time.Hour,
+			SnapshotInterval: 30 * time.Second,
+			TimeSeries:       timeseries.Config{Backend: "none"},
+		}, db, c.pub.Messages())
+	}()
+	return c
+}
+
+// stop waits for the counter to flush everything it was given
+func (c *counter) stop() error {
+	c.pub.Stop()
+	return <-c.done
+}
+
+// memStore is a store.Backend holding a single poll in memory
+type memStore struct {
+	mu        sync.Mutex
+	poll      store.Poll
+	snapshots map[string][]byte
+}
+
+func newMemStore(options []string) *memStore {
+	return &memStore{
+		poll:      store.Poll{ID: "soak", Title: "Soak", Options: options, Results: make(map[string]int), Status: "active"},
+		snapshots: make(map[string][]byte),
+	}
+}
+
+// check compares the stored results with what was injected.
+// While votes are in flight the results may lag behind, but never run ahead.
+func (m *memStore) check(injected map[string]int, inFlight bool) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, option := range options {
+		got, want := m.poll.Results[option], injected[option]
+		if got > want {
+			return fmt.Errorf("%s: counted %d votes, only %d were cast", option, got, want)
+		}
+		if !inFlight && got != want {
+			return fmt.Errorf("%s: counted %d votes, %d were cast", option, got, want)
+		}
+	}
+	return nil
+}
+
+func (m *memStore) LoadOptions() ([]string, error) { return m.poll.Options, nil }
+func (m *memStore) Close()                         {}
+
+func (m *memStore) Polls() ([]store.Poll, error) {
+	p, err := m.Poll(m.poll.ID)
+	return []store.Poll{p}, err
+}
+
+func (m *memStore) Poll(id string) (store.Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if id != m.poll.ID {
+		return store.Poll{}, store.ErrNotFound
+	}
+	p := m.poll
+	p.Results = make(map[string]int, len(m.poll.Results))
+	for option, n := range m.poll.Results {
+		p.Results[option] = n
+	}
+	return p, nil
+}
+
+func (m *memStore) CreatePoll(p *store.Poll) error { return fmt.Errorf("soak: polls are fixed") }
+func (m *memStore) DeletePoll(id string) error     { return fmt.Errorf("soak: polls are fixed") }
+func (m *memStore) SetPollStatus(id, from, to string) error {
+	return fmt.Errorf("soak: polls are fixed")
+}
+
+func (m *memStore) AddResults(pollID string, counts map[string]int, weighted map[string]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for option, n := range counts {
+		m.poll.Results[option] += n
+	}
+	return nil
+}
+
+func (m *memStore) AddGeoResults(pollID string, counts map[string]map[string]int) error { return nil }
+
+func (m *memStore) AddSourceResults(pollID string, counts map[string]map[string]int) error {
+	return nil
+}
+
+func (m *memStore) AddLanguageResults(pollID string, counts map[string]map[string]int) error {
+	return nil
+}
+
+func (m *memStore) AddMetrics(pollID string, metrics map[string]map[string]float64) error { return nil }
+
+func (m *memStore) SaveSnapshot(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[name] = data
+	return nil
+}
+
+func (m *memStore) LoadSnapshot(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.snapshots[name]
+	if !ok {
+		return nil, store.ErrNoSnapshot
+	}
+	return data, nil
+}
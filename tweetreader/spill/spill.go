@@ -0,0 +1,291 @@
+// Package spill keeps the entries an in-memory cache evicts on local disk
+// until they expire, so a cache with a memory limit still remembers what it
+// saw within its window, only more slowly.
+//
+// The entries are appended to files of a segment of the window, by the time
+// they were seen, each split in buckets by the key's hash. A segment that
+// fell out of the window is deleted whole. A Bloom filter per segment, kept
+// in memory and rebuilt from the files when opened, answers most lookups for
+// keys that were never spilled without reading the disk; the others read one
+// bucket of each segment that may have the key.
+package spill
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	segments = 8       // how many parts the window is split into
+	buckets  = 16      // files a segment is split into
+	bloomLen = 1 << 17 // words of a segment's Bloom filter, 8M bits or 1 MiB
+	hashes   = 4       // bits a key sets in a Bloom filter
+)
+
+// Set is the spilled entries of a cache, kept in a directory
+type Set struct {
+	dir    string
+	window time.Duration
+	width  time.Duration // of a segment
+
+	mu    sync.Mutex
+	segs  map[int64]*segment // by start, in unix nanoseconds
+	files map[string]*os.File
+}
+
+// segment is the entries seen within width of its start
+type segment struct {
+	bloom []uint64
+}
+
+// Open opens the entries spilled to dir by an earlier run, deleting the ones
+// older than window, and spills the new ones there
+func Open(dir string, window time.Duration) (*Set, error) {
+	if window <= 0 {
+		return nil, fmt.Errorf("spill: a window is needed")
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	s := &Set{
+		dir:    dir,
+		window: window,
+		width:  window / segments,
+		segs:   make(map[int64]*segment),
+		files:  make(map[string]*os.File),
+	}
+	if s.width <= 0 {
+		s.width = 1
+	}
+	names, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	oldest := time.Now().Add(-window).UnixNano()
+	for _, fi := range names {
+		start, _, ok := parseName(fi.Name())
+		if !ok {
+			continue
+		}
+		path := filepath.Join(dir, fi.Name())
+		if start+int64(s.width) <= oldest {
+			os.Remove(path)
+			continue
+		}
+		seg := s.segment(start)
+		err := scan(path, func(key string, at int64) { seg.add(key) })
+		if err != nil {
+			return nil, fmt.Errorf("spill: %v", err)
+		}
+	}
+	return s, nil
+}
+
+// Add spills key, seen at at
+func (s *Set) Add(key string, at time.Time) error {
+	n := at.UnixNano()
+	start := n - n%int64(s.width)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	f, err := s.file(start, bucket(key))
+	if err != nil {
+		return err
+	}
+	// one write a line, so a reader never sees half of one
+	if _, err := f.WriteString(strconv.Quote(key) + " " + strconv.FormatInt(n, 10) + "\n"); err != nil {
+		return err
+	}
+	s.segment(start).add(key)
+	return nil
+}
+
+// Get returns when key was last seen, if it was spilled and not pruned yet
+func (s *Set) Get(key string) (time.Time, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	starts := make([]int64, 0, len(s.segs))
+	for start, seg := range s.segs {
+		if seg.has(key) {
+			starts = append(starts, start)
+		}
+	}
+	// the newest first, a key spilled again was seen again
+	sort.Slice(starts, func(i, j int) bool { return starts[i] > starts[j] })
+	b := bucket(key)
+	for _, start := range starts {
+		var found int64
+		err := scan(filepath.Join(s.dir, name(start, b)), func(k string, at int64) {
+			if k == key && at > found {
+				found = at
+			}
+		})
+		if err != nil && !os.IsNotExist(err) {
+			return time.Time{}, false, fmt.Errorf("spill: %v", err)
+		}
+		if found != 0 {
+			return time.Unix(0, found), true, nil
+		}
+	}
+	return time.Time{}, false, nil
+}
+
+// Prune deletes the segments that fell out of the window before now
+func (s *Set) Prune(now time.Time) error {
+	oldest := now.Add(-s.window).UnixNano()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failed error
+	for start := range s.segs {
+		if start+int64(s.width) > oldest {
+			continue
+		}
+		delete(s.segs, start)
+		for b := 0; b < buckets; b++ {
+			n := name(start, b)
+			if f, ok := s.files[n]; ok {
+				f.Close()
+				delete(s.files, n)
+			}
+			if err := os.Remove(filepath.Join(s.dir, n)); err != nil && !os.IsNotExist(err) {
+				failed = err
+			}
+		}
+	}
+	return failed
+}
+
+// Close closes the files, the entries stay for the next Open
+func (s *Set) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var failed error
+	for n, f := range s.files {
+		if err := f.Close(); err != nil {
+			failed = err
+		}
+		delete(s.files, n)
+	}
+	return failed
+}
+
+// segment returns the segment starting at start, mu held
+func (s *Set) segment(start int64) *segment {
+	seg, ok := s.segs[start]
+	if !ok {
+		seg = &segment{bloom: make([]uint64, bloomLen)}
+		s.segs[start] = seg
+	}
+	return seg
+}
+
+// file returns the file of a segment's bucket, opened to append to; mu held
+func (s *Set) file(start int64, b int) (*os.File, error) {
+	n := name(start, b)
+	if f, ok := s.files[n]; ok {
+		return f, nil
+	}
+	f, err := os.OpenFile(filepath.Join(s.dir, n), os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, err
+	}
+	s.files[n] = f
+	return f, nil
+}
+
+// add sets the bits of key
+func (seg *segment) add(key string) {
+	h1, h2 := hash(key)
+	for i := uint32(0); i < hashes; i++ {
+		bit := (h1 + i*h2) % (bloomLen * 64)
+		seg.bloom[bit/64] |= 1 << (bit % 64)
+	}
+}
+
+// has reports whether key may be in the segment
+func (seg *segment) has(key string) bool {
+	h1, h2 := hash(key)
+	for i := uint32(0); i < hashes; i++ {
+		bit := (h1 + i*h2) % (bloomLen * 64)
+		if seg.bloom[bit/64]&(1<<(bit%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func hash(key string) (uint32, uint32) {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	sum := h.Sum64()
+	return uint32(sum), uint32(sum>>32) | 1
+}
+
+// bucket returns the bucket of key
+func bucket(key string) int {
+	h1, _ := hash(key)
+	return int(h1 % buckets)
+}
+
+// name returns the name of the file of a segment's bucket
+func name(start int64, b int) string {
+	return fmt.Sprintf("%d-%02d.spill", start, b)
+}
+
+// parseName is the reverse of name
+func parseName(n string) (int64, int, bool) {
+	if !strings.HasSuffix(n, ".spill") {
+		return 0, 0, false
+	}
+	n = strings.TrimSuffix(n, ".spill")
+	i := strings.LastIndexByte(n, '-')
+	if i < 0 {
+		return 0, 0, false
+	}
+	start, err := strconv.ParseInt(n[:i], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	b, err := strconv.Atoi(n[i+1:])
+	if err != nil || b < 0 || b >= buckets {
+		return 0, 0, false
+	}
+	return start, b, true
+}
+
+// scan calls fn with every entry of the file at path, a line cut short by a
+// crash is skipped
+func scan(path string, fn func(key string, at int64)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	sc := bufio.NewScanner(f)
+	sc.Buffer(make([]byte, 0, 4096), 1<<20)
+	for sc.Scan() {
+		line := sc.Text()
+		i := strings.LastIndexByte(line, ' ')
+		if i < 0 {
+			continue
+		}
+		key, err := strconv.Unquote(line[:i])
+		if err != nil {
+			continue
+		}
+		at, err := strconv.ParseInt(line[i+1:], 10, 64)
+		if err != nil {
+			continue
+		}
+		fn(key, at)
+	}
+	return sc.Err()
+}
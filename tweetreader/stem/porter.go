@@ -0,0 +1,239 @@
+package stem
+
+import "github.com/olawolu/twitter-polls/tweetreader/textnorm"
+
+// englishPlural turns English plurals into singulars: "votes" reads "vote",
+// "cities" "city" and "boxes" "box", "bus" and "glass" are left alone
+func englishPlural(word string) string {
+	word = asciiOnly(word)
+	n := len(word)
+	switch {
+	case n <= 3:
+	case hasSuffix(word, "ies") && n > 4:
+		return word[:n-3] + "y"
+	case hasSuffix(word, "sses"), hasSuffix(word, "xes"), hasSuffix(word, "zes"), hasSuffix(word, "ches"), hasSuffix(word, "shes"):
+		return word[:n-2]
+	case hasSuffix(word, "ss"), hasSuffix(word, "us"), hasSuffix(word, "is"):
+	case hasSuffix(word, "s"):
+		return word[:n-1]
+	}
+	return word
+}
+
+// asciiOnly returns word when it is all lowercase ASCII letters, the only
+// ones the English stemmers change, else word without its diacritics
+func asciiOnly(word string) string {
+	for i := 0; i < len(word); i++ {
+		if word[i] >= 0x80 {
+			return textnorm.Unaccent(word)
+		}
+	}
+	return word
+}
+
+func hasSuffix(s, suffix string) bool {
+	return len(s) >= len(suffix) && s[len(s)-len(suffix):] == suffix
+}
+
+// porter stems an English word with M.F. Porter's algorithm, "An algorithm for
+// suffix stripping", 1980: "voting", "voted" and "votes" all read "vote"
+func porter(word string) string {
+	word = asciiOnly(word)
+	if len(word) <= 2 {
+		return word
+	}
+	for i := 0; i < len(word); i++ {
+		if word[i] < 'a' || word[i] > 'z' {
+			return word
+		}
+	}
+	w := porterWord(word)
+	w.step1ab()
+	w.step1c()
+	w.replace(0, porterStep2)
+	w.replace(0, porterStep3)
+	w.step4()
+	w.step5()
+	return string(w)
+}
+
+// porterWord is a word being stemmed, the methods taking a length are about
+// the stem of the word that long
+type porterWord []byte
+
+// consonant reports whether the letter at i is one, y is after a consonant
+func (w porterWord) consonant(i int) bool {
+	switch w[i] {
+	case 'a', 'e', 'i', 'o', 'u':
+		return false
+	case 'y':
+		return i == 0 || !w.consonant(i-1)
+	}
+	return true
+}
+
+// measure counts the vowel-consonant sequences of the first n letters, the m of [C](VC)^m[V]
+func (w porterWord) measure(n int) int {
+	m, i := 0, 0
+	for i < n && w.consonant(i) {
+		i++
+	}
+	for i < n {
+		for i < n && !w.consonant(i) {
+			i++
+		}
+		if i == n {
+			break
+		}
+		for i < n && w.consonant(i) {
+			i++
+		}
+		m++
+	}
+	return m
+}
+
+// hasVowel reports whether the first n letters have a vowel
+func (w porterWord) hasVowel(n int) bool {
+	for i := 0; i < n; i++ {
+		if !w.consonant(i) {
+			return true
+		}
+	}
+	return false
+}
+
+// doubleConsonant reports whether the first n letters end in the same consonant twice
+func (w porterWord) doubleConsonant(n int) bool {
+	return n >= 2 && w[n-1] == w[n-2] && w.consonant(n-1)
+}
+
+// cvc reports whether the first n letters end consonant, vowel, consonant,
+// the last not w, x or y: "hop" but not "snow"
+func (w porterWord) cvc(n int) bool {
+	if n < 3 || !w.consonant(n-1) || w.consonant(n-2) || !w.consonant(n-3) {
+		return false
+	}
+	switch w[n-1] {
+	case 'w', 'x', 'y':
+		return false
+	}
+	return true
+}
+
+func (w porterWord) ends(suffix string) bool {
+	return hasSuffix(string(w), suffix)
+}
+
+// set replaces the last cut letters with to
+func (w *porterWord) set(cut int, to string) {
+	*w = append((*w)[:len(*w)-cut], to...)
+}
+
+// porterSuffix replaces suffix with to when the measure of the stem is above the step's
+type porterSuffix struct {
+	suffix, to string
+}
+
+var porterStep2 = []porterSuffix{
+	{"ational", "ate"}, {"tional", "tion"}, {"enci", "ence"}, {"anci", "ance"}, {"izer", "ize"},
+	{"bli", "ble"}, {"alli", "al"}, {"entli", "ent"}, {"eli", "e"}, {"ousli", "ous"},
+	{"ization", "ize"}, {"ation", "ate"}, {"ator", "ate"}, {"alism", "al"}, {"iveness", "ive"},
+	{"fulness", "ful"}, {"ousness", "ous"}, {"aliti", "al"}, {"iviti", "ive"}, {"biliti", "ble"},
+	{"logi", "log"},
+}
+
+var porterStep3 = []porterSuffix{
+	{"icate", "ic"}, {"ative", ""}, {"alize", "al"}, {"iciti", "ic"}, {"ical", "ic"}, {"ful", ""}, {"ness", ""},
+}
+
+var porterStep4 = []string{
+	"al", "ance", "ence", "er", "ic", "able", "ible", "ant", "ement", "ment", "ent",
+	"ion", "ou", "ism", "ate", "iti", "ous", "ive", "ize",
+}
+
+// replace replaces the first of suffixes the word ends in, longest first
+// where they overlap, when the measure of the stem is above min
+func (w *porterWord) replace(min int, suffixes []porterSuffix) {
+	for _, s := range suffixes {
+		if w.ends(s.suffix) {
+			if n := len(*w) - len(s.suffix); w.measure(n) > min {
+				w.set(len(s.suffix), s.to)
+			}
+			return
+		}
+	}
+}
+
+// step1ab removes plurals and -ed or -ing
+func (w *porterWord) step1ab() {
+	switch {
+	case w.ends("sses"), w.ends("ies"):
+		w.set(2, "")
+	case w.ends("ss"):
+	case w.ends("s"):
+		w.set(1, "")
+	}
+	n := len(*w)
+	switch {
+	case w.ends("eed"):
+		if w.measure(n-3) > 0 {
+			w.set(1, "")
+		}
+		return
+	case w.ends("ed") && w.hasVowel(n-2):
+		w.set(2, "")
+	case w.ends("ing") && w.hasVowel(n-3):
+		w.set(3, "")
+	default:
+		return
+	}
+	n = len(*w)
+	switch {
+	case w.ends("at"), w.ends("bl"), w.ends("iz"):
+		w.set(0, "e")
+	case w.doubleConsonant(n):
+		switch (*w)[n-1] {
+		case 'l', 's', 'z':
+		default:
+			w.set(1, "")
+		}
+	case w.measure(n) == 1 && w.cvc(n):
+		w.set(0, "e")
+	}
+}
+
+// step1c turns a final y into i when there is another vowel
+func (w *porterWord) step1c() {
+	if w.ends("y") && w.hasVowel(len(*w)-1) {
+		w.set(1, "i")
+	}
+}
+
+// step4 removes -ant, -ence and the like from stems of measure 2 and more
+func (w *porterWord) step4() {
+	for _, s := range porterStep4 {
+		if !w.ends(s) {
+			continue
+		}
+		n := len(*w) - len(s)
+		if w.measure(n) > 1 && (s != "ion" || n > 0 && ((*w)[n-1] == 's' || (*w)[n-1] == 't')) {
+			w.set(len(s), "")
+		}
+		return
+	}
+}
+
+// step5 removes a final e and turns a final ll into l on long enough stems
+func (w *porterWord) step5() {
+	n := len(*w)
+	if w.ends("e") {
+		if m := w.measure(n - 1); m > 1 || m == 1 && !w.cvc(n-1) {
+			w.set(1, "")
+		}
+	}
+	n = len(*w)
+	if w.measure(n) > 1 && w.doubleConsonant(n) && (*w)[n-1] == 'l' {
+		w.set(1, "")
+	}
+}
@@ -0,0 +1,176 @@
+// Package stem reduces the words of votes to their stems, so an option of a
+// poll can also be found in the other forms of its words: "voting", "voted"
+// and "votes" all read "vote".
+//
+// English is stemmed with Porter's algorithm, the other languages by
+// stripping their most common endings, after dropping their diacritics. The
+// stems aren't words, only the same for the forms of one, and stemmers get
+// some words wrong the way any of them does: "news" reads "new".
+package stem
+
+import (
+	"sort"
+	"unicode/utf8"
+
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// Levels of fuzziness, how far from the way they are written words are found
+const (
+	// Plural finds the singular and plural forms of a word, "vote" and "votes"
+	Plural = "plural"
+	// Full finds all the forms of a word, "vote", "votes", "voted" and "voting"
+	Full = "stem"
+)
+
+// English is the language of the stemmer used when none is given
+const English = "en"
+
+// Func reduces a word, already folded with textnorm.Fold, to its stem
+type Func func(word string) string
+
+// For returns the stemmer of language, English when empty, at level, nil
+// when there is no stemmer for either
+func For(language, level string) Func {
+	if language == "" {
+		language = English
+	}
+	if language == English {
+		switch level {
+		case Plural:
+			return englishPlural
+		case Full:
+			return porter
+		}
+		return nil
+	}
+	l, ok := languages[language]
+	if !ok {
+		return nil
+	}
+	switch level {
+	case Plural:
+		return l.singular
+	case Full:
+		return l.stem
+	}
+	return nil
+}
+
+// Languages returns the ISO 639-1 codes of the languages there are stemmers for, sorted
+func Languages() []string {
+	codes := []string{English}
+	for code := range languages {
+		codes = append(codes, code)
+	}
+	sort.Strings(codes)
+	return codes
+}
+
+// rule replaces the suffix of a word that leaves at least min letters of it
+type rule struct {
+	suffix, with string
+	min          int
+}
+
+// rules are tried in order, the first that applies is the only one to
+type rules []rule
+
+func (rs rules) apply(word string) (string, bool) {
+	for _, r := range rs {
+		if n := len(word) - len(r.suffix); n >= 0 && word[n:] == r.suffix && utf8.RuneCountInString(word[:n]) >= r.min {
+			return word[:n] + r.with, true
+		}
+	}
+	return word, false
+}
+
+// language stems the words of a language without a stemmer of its own
+type language struct {
+	// plural turns plurals into singulars
+	plural rules
+	// endings strip the endings of the other forms of words, verbs mostly
+	endings rules
+	// vowels strip the final vowel the forms of a word don't share, "voto" and "vota"
+	vowels rules
+	// undouble turns the double consonant the endings leave behind into one, "stemm"
+	undouble bool
+}
+
+// singular returns word without its plural ending or final vowel
+func (l *language) singular(word string) string {
+	word = textnorm.Unaccent(word)
+	word, _ = l.plural.apply(word)
+	word, _ = l.vowels.apply(word)
+	return l.single(word)
+}
+
+// stem returns word without its ending, or its plural one when it has none,
+// and its final vowel
+func (l *language) stem(word string) string {
+	word = textnorm.Unaccent(word)
+	stemmed, ok := l.endings.apply(word)
+	if !ok {
+		stemmed, _ = l.plural.apply(word)
+	}
+	stemmed, _ = l.vowels.apply(stemmed)
+	return l.single(stemmed)
+}
+
+// single turns the double consonant word ends in into one, for the languages undoubling them
+func (l *language) single(word string) string {
+	if n := len(word); l.undouble && n > 3 && word[n-1] == word[n-2] && !isVowel(word[n-1]) {
+		return word[:n-1]
+	}
+	return word
+}
+
+func isVowel(b byte) bool {
+	switch b {
+	case 'a', 'e', 'i', 'o', 'u', 'y':
+		return true
+	}
+	return false
+}
+
+// endings makes the rules stripping each of suffixes, longest first, leaving at least min letters
+func endings(min int, suffixes ...string) rules {
+	rs := make(rules, len(suffixes))
+	for i, s := range suffixes {
+		rs[i] = rule{s, "", min}
+	}
+	sort.SliceStable(rs, func(i, j int) bool { return len(rs[i].suffix) > len(rs[j].suffix) })
+	return rs
+}
+
+// languages are the stemmers of the languages other than English, by ISO 639-1 code
+var languages = map[string]*language{
+	"es": {
+		plural:  append(rules{{"iones", "ion", 2}, {"ces", "z", 2}}, endings(3, "es", "s")...),
+		endings: endings(3, "aciones", "acion", "iendo", "ando", "ieron", "aron", "ados", "adas", "idos", "idas", "amos", "emos", "imos", "aban", "ado", "ada", "ido", "ida", "aba", "ais", "ar", "er", "ir", "an", "en", "as"),
+		vowels:  endings(3, "a", "e", "o"),
+	},
+	"pt": {
+		plural:  append(rules{{"oes", "ao", 2}, {"aes", "ao", 2}, {"ns", "m", 2}}, endings(3, "es", "s")...),
+		endings: endings(3, "acoes", "acao", "ando", "endo", "indo", "aram", "eram", "iram", "ados", "adas", "idos", "idas", "amos", "emos", "imos", "ado", "ada", "ido", "ida", "ava", "ar", "er", "ir", "ou", "am", "em"),
+		vowels:  endings(3, "a", "e", "o"),
+	},
+	"fr": {
+		plural:  append(rules{{"eaux", "eau", 2}, {"aux", "al", 2}}, endings(3, "x", "s")...),
+		endings: endings(3, "erions", "eraient", "erons", "eront", "erais", "erait", "eriez", "assent", "aient", "ations", "ation", "antes", "ants", "ante", "ant", "ions", "iez", "ons", "ent", "era", "ees", "ee", "es", "ez", "er", "ir", "ais", "ait"),
+		vowels:  endings(3, "e"),
+	},
+	"it": {
+		endings: endings(3, "azioni", "azione", "iamo", "ando", "endo", "ato", "ata", "ati", "ate", "ito", "ita", "iti", "ite", "uto", "uta", "uti", "ute", "are", "ere", "ire", "ano", "ono", "ava"),
+		vowels:  endings(3, "a", "e", "i", "o"),
+	},
+	"de": {
+		plural:  endings(3, "ern", "en", "er", "es", "e", "n", "s"),
+		endings: endings(3, "ungen", "ung", "est", "end", "ten", "tet", "te", "st", "et", "t", "ern", "en", "er", "es", "e", "n", "s"),
+	},
+	"nl": {
+		plural:   endings(3, "en", "s"),
+		endings:  endings(3, "ende", "end", "ten", "den", "te", "de", "en", "t", "s"),
+		undouble: true,
+	},
+}
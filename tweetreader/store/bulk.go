@@ -0,0 +1,202 @@
+package store
+
+import (
+	"io"
+	"net"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// BulkResultStore is implemented by stores that add up the results of many
+// polls in one round trip, instead of an update per poll and kind of result
+type BulkResultStore interface {
+	// AddResultsBulk adds every update to its poll's results, in order, and
+	// returns how many of the first were added when it fails; an update for a
+	// poll that is gone is skipped
+	AddResultsBulk(updates []ResultUpdate) (int, error)
+}
+
+// ResultUpdate is what to add to a poll's results
+type ResultUpdate struct {
+	Poll string
+	Tallies
+}
+
+// Add adds u's tallies to the ones in t
+func (t *Tallies) Add(u Tallies) {
+	t.Results = addCounts(t.Results, u.Results)
+	if len(u.WeightedResults) > 0 && t.WeightedResults == nil {
+		t.WeightedResults = make(map[string]float64)
+	}
+	for o, w := range u.WeightedResults {
+		t.WeightedResults[o] += w
+	}
+	t.GeoResults = addBreakdown(t.GeoResults, u.GeoResults)
+	t.SourceResults = addBreakdown(t.SourceResults, u.SourceResults)
+	t.LanguageResults = addBreakdown(t.LanguageResults, u.LanguageResults)
+	if len(u.Metrics) > 0 && t.Metrics == nil {
+		t.Metrics = make(map[string]map[string]float64)
+	}
+	for name, options := range u.Metrics {
+		if t.Metrics[name] == nil {
+			t.Metrics[name] = make(map[string]float64)
+		}
+		for o, v := range options {
+			t.Metrics[name][o] += v
+		}
+	}
+}
+
+func addCounts(to, counts map[string]int) map[string]int {
+	if len(counts) > 0 && to == nil {
+		to = make(map[string]int)
+	}
+	for o, n := range counts {
+		to[o] += n
+	}
+	return to
+}
+
+func addBreakdown(to, counts map[string]map[string]int) map[string]map[string]int {
+	if len(counts) > 0 && to == nil {
+		to = make(map[string]map[string]int)
+	}
+	for k, options := range counts {
+		to[k] = addCounts(to[k], options)
+	}
+	return to
+}
+
+// IsTransient reports whether err is a failure to reach the database or a
+// replica set electing a primary, which writing again shortly may get past
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	switch e := err.(type) {
+	case *mgo.LastError:
+		return transientCode(e.Code) || strings.HasPrefix(e.Err, "not master")
+	case *mgo.QueryError:
+		return transientCode(e.Code)
+	case *mgo.BulkError:
+		for _, c := range e.Cases() {
+			if !IsTransient(c.Err) {
+				return false
+			}
+		}
+		return true
+	}
+	msg := err.Error()
+	return strings.Contains(msg, "no reachable servers") || strings.Contains(msg, "Closed explicitly") || strings.Contains(msg, "i/o timeout")
+}
+
+// transientCode reports whether a MongoDB error code is about the database
+// being unreachable or between primaries
+func transientCode(code int) bool {
+	switch code {
+	case 6, 7, 89, 91, 189, 9001, 10107, 11600, 11602, 13435, 13436:
+		// HostUnreachable, HostNotFound, NetworkTimeout, ShutdownInProgress, PrimarySteppedDown,
+		// SocketException, NotMaster, InterruptedAtShutdown, InterruptedDueToReplStateChange,
+		// NotMasterNoSlaveOk, NotMasterOrSecondary
+		return true
+	}
+	return false
+}
+
+// incOf returns the $inc adding t to a poll document
+func incOf(t Tallies) bson.M {
+	inc := bson.M{}
+	for option, n := range t.Results {
+		inc["results."+option] = n
+	}
+	for option, w := range t.WeightedResults {
+		inc["weighted_results."+option] = w
+	}
+	for field, counts := range map[string]map[string]map[string]int{"geo_results": t.GeoResults, "source_results": t.SourceResults, "language_results": t.LanguageResults} {
+		for k, options := range counts {
+			for option, n := range options {
+				inc[field+"."+fieldKey(k)+"."+option] = n
+			}
+		}
+	}
+	for name, options := range t.Metrics {
+		for option, v := range options {
+			inc["metrics."+fieldKey(name)+"."+option] = v
+		}
+	}
+	return inc
+}
+
+// AddResultsBulk adds the updates with one ordered bulk write per run of
+// updates for polls in the same collection, a single $inc per poll
+func (m *Mongo) AddResultsBulk(updates []ResultUpdate) (int, error) {
+	type op struct {
+		index int // in updates
+		id    bson.ObjectId
+		inc   bson.M
+		coll  *mgo.Collection
+	}
+	var ops []op
+	for i, u := range updates {
+		if !bson.IsObjectIdHex(u.Poll) {
+			continue // no such poll
+		}
+		if inc := incOf(u.Tallies); len(inc) > 0 {
+			id := bson.ObjectIdHex(u.Poll)
+			ops = append(ops, op{index: i, id: id, inc: inc, coll: m.pollsOf(id)})
+		}
+	}
+	for start := 0; start < len(ops); {
+		end := start + 1
+		for end < len(ops) && ops[end].coll.FullName == ops[start].coll.FullName {
+			end++
+		}
+		bulk := ops[start].coll.Bulk()
+		for _, o := range ops[start:end] {
+			bulk.Update(bson.M{"_id": o.id}, bson.M{"$inc": o.inc})
+		}
+		if _, err := bulk.Run(); err != nil {
+			// the ordered write stopped at the first update failing, the ones before it were written
+			failed := start
+			if be, ok := err.(*mgo.BulkError); ok {
+				first := -1
+				for _, c := range be.Cases() {
+					if c.Index >= 0 && (first < 0 || c.Index < first) {
+						first = c.Index
+					}
+				}
+				if first >= 0 {
+					failed += first
+				}
+			}
+			return ops[failed].index, err
+		}
+		start = end
+	}
+	return len(updates), nil
+}
+
+// AddResultsBulk adds the updates one after the other
+func (m *Memory) AddResultsBulk(updates []ResultUpdate) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, u := range updates {
+		p := m.find(u.Poll)
+		if p == nil {
+			continue
+		}
+		t := TalliesOf(*p)
+		t.Add(u.Tallies)
+		p.Results, p.WeightedResults, p.GeoResults = t.Results, t.WeightedResults, t.GeoResults
+		p.SourceResults, p.LanguageResults, p.Metrics = t.SourceResults, t.LanguageResults, t.Metrics
+	}
+	return len(updates), nil
+}
@@ -0,0 +1,108 @@
+package store
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	optionsStale = metrics.NewCounter("tweetreader_options_stale_total",
+		"Stream attempts that fell back to cached options because loading them failed.")
+	optionsLoadErrors = metrics.NewCounter("tweetreader_options_load_errors_total",
+		"Failed attempts to load poll options from the database.")
+)
+
+// OptionsCache holds the last option set that was loaded successfully.
+// A failed query shouldn't stop the stream, so until the database
+// comes back we keep tracking the options we already know about.
+type OptionsCache struct {
+	store Store
+
+	mu       sync.RWMutex
+	options  []string
+	loadedAt time.Time
+}
+
+// caches are all the option caches, the gauges cover every one of them:
+// a process streaming for several Twitter accounts has a cache per account
+var caches struct {
+	sync.Mutex
+	all []*OptionsCache
+}
+
+// NewOptionsCache caches the options loaded from s
+func NewOptionsCache(s Store) *OptionsCache {
+	c := &OptionsCache{store: s}
+	caches.Lock()
+	defer caches.Unlock()
+	if len(caches.all) == 0 {
+		metrics.NewGaugeFunc("tweetreader_options_age_seconds",
+			"Seconds since poll options were last loaded successfully, for the stalest cache.", cachesAge)
+		metrics.NewGaugeFunc("tweetreader_options_tracked",
+			"Number of options currently tracked.", cachesTracked)
+	}
+	caches.all = append(caches.all, c)
+	return c
+}
+
+func cachesAge() float64 {
+	caches.Lock()
+	defer caches.Unlock()
+	var oldest float64
+	for _, c := range caches.all {
+		if age := c.age(); age > oldest {
+			oldest = age
+		}
+	}
+	return oldest
+}
+
+func cachesTracked() float64 {
+	caches.Lock()
+	defer caches.Unlock()
+	var n int
+	for _, c := range caches.all {
+		n += len(c.Get())
+	}
+	return float64(n)
+}
+
+// Refresh reloads the options, falling back to the cached set when the load fails.
+// It only returns an error when there is nothing cached to fall back on.
+func (c *OptionsCache) Refresh() ([]string, error) {
+	loaded, err := c.store.LoadOptions()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err == nil {
+		c.options = loaded
+		c.loadedAt = time.Now()
+		return c.options, nil
+	}
+	optionsLoadErrors.Inc()
+	if c.loadedAt.IsZero() {
+		return nil, err
+	}
+	optionsStale.Inc()
+	log.Printf("WARNING: failed to load options (%s), streaming with options from %s ago",
+		err, time.Since(c.loadedAt).Round(time.Second))
+	return c.options, nil
+}
+
+// Get returns the options currently tracked
+func (c *OptionsCache) Get() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.options
+}
+
+func (c *OptionsCache) age() float64 {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if c.loadedAt.IsZero() {
+		return 0
+	}
+	return time.Since(c.loadedAt).Seconds()
+}
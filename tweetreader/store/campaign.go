@@ -0,0 +1,46 @@
+package store
+
+import (
+	"errors"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ErrNoCampaign is returned when a campaign doesn't exist
+var ErrNoCampaign = errors.New("campaign not found")
+
+// Campaign groups a series of related polls, the ones whose Campaign is its
+// ID, so they are reported on together and their channels are told about the
+// votes of all of them
+type Campaign struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+	// Notifications are the chat channels told about the milestones of the
+	// votes of all the campaign's polls and their progress
+	Notifications []Notification `json:"notifications,omitempty"`
+}
+
+// CampaignStore is implemented by the stores keeping campaigns
+type CampaignStore interface {
+	// Campaign returns the campaign id, or ErrNoCampaign
+	Campaign(id string) (Campaign, error)
+}
+
+// campaignDoc is a document of the campaigns collection, the rest-api keeps it
+type campaignDoc struct {
+	ID            string         `bson:"_id"`
+	Title         string         `bson:"title"`
+	Notifications []Notification `bson:"notifications,omitempty"`
+}
+
+// Campaign reads a campaign from the campaigns collection
+func (m *Mongo) Campaign(id string) (Campaign, error) {
+	var d campaignDoc
+	if err := m.session.DB(m.db).C("campaigns").FindId(id).One(&d); err != nil {
+		if err == mgo.ErrNotFound {
+			return Campaign{}, ErrNoCampaign
+		}
+		return Campaign{}, err
+	}
+	return Campaign{ID: d.ID, Title: d.Title, Notifications: d.Notifications}, nil
+}
@@ -0,0 +1,96 @@
+package store
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// ErrNoCertificate is returned for a poll whose results weren't certified
+var ErrNoCertificate = errors.New("certificate not found")
+
+// CertificateStore is implemented by stores that keep the signed certificates
+// of the closed polls' results, see the certify package. A certificate is
+// never replaced, the first one saved for a poll is the one published.
+type CertificateStore interface {
+	// SaveCertificate keeps cert as the poll's certificate, false when it already had one
+	SaveCertificate(pollID string, cert []byte) (bool, error)
+	// Certificate returns the poll's certificate, or ErrNoCertificate
+	Certificate(pollID string) ([]byte, error)
+}
+
+type certificateDoc struct {
+	Poll        string    `bson:"_id"`
+	Certificate string    `bson:"certificate"`
+	Certified   time.Time `bson:"certified"`
+}
+
+// SaveCertificate inserts the certificate into the certificates collection,
+// the unique _id refuses a second one
+func (m *Mongo) SaveCertificate(pollID string, cert []byte) (bool, error) {
+	err := m.session.DB(m.db).C("certificates").Insert(certificateDoc{Poll: pollID, Certificate: string(cert), Certified: time.Now()})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// Certificate reads the poll's certificate from the certificates collection
+func (m *Mongo) Certificate(pollID string) ([]byte, error) {
+	var d certificateDoc
+	if err := m.session.DB(m.db).C("certificates").FindId(pollID).One(&d); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNoCertificate
+		}
+		return nil, err
+	}
+	return []byte(d.Certificate), nil
+}
+
+// SaveCertificate inserts the certificate, unless the poll has one
+func (s *SQL) SaveCertificate(pollID string, cert []byte) (bool, error) {
+	res, err := s.db.Exec(s.q(`INSERT INTO certificates (poll_id, certificate, certified) VALUES (?, ?, ?)
+		ON CONFLICT (poll_id) DO NOTHING`), pollID, string(cert), time.Now())
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// Certificate reads the certificate saved with SaveCertificate
+func (s *SQL) Certificate(pollID string) ([]byte, error) {
+	var cert string
+	err := s.db.QueryRow(s.q(`SELECT certificate FROM certificates WHERE poll_id = ?`), pollID).Scan(&cert)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoCertificate
+	}
+	return []byte(cert), err
+}
+
+// SaveCertificate keeps the poll's certificate, unless it has one
+func (m *Memory) SaveCertificate(pollID string, cert []byte) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.certificates == nil {
+		m.certificates = make(map[string][]byte)
+	}
+	if _, ok := m.certificates[pollID]; ok {
+		return false, nil
+	}
+	m.certificates[pollID] = cert
+	return true, nil
+}
+
+// Certificate returns the poll's certificate, or ErrNoCertificate
+func (m *Memory) Certificate(pollID string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	cert, ok := m.certificates[pollID]
+	if !ok {
+		return nil, ErrNoCertificate
+	}
+	return cert, nil
+}
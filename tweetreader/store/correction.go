@@ -0,0 +1,128 @@
+package store
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Correction actions, what is done to the votes of a disputed tweet
+const (
+	// CorrectionDecrement takes the votes out of the results
+	CorrectionDecrement = "decrement"
+	// CorrectionReassign moves the votes to another option
+	CorrectionReassign = "reassign"
+)
+
+// Correction statuses
+const (
+	CorrectionPending  = "pending"
+	CorrectionApplied  = "applied"
+	CorrectionRejected = "rejected"
+)
+
+// MetricCorrected is the metric counting the votes corrections took out of
+// each option, negative for the options reassigned votes went to, so the
+// results show what was corrected
+const MetricCorrected = "corrected"
+
+// Correction is the correction of the vote of a tweet disputed as miscounted
+// for an option of a poll. A vote is corrected once: its ID is the poll's,
+// the tweet's and the option's, poll/tweet/option.
+type Correction struct {
+	ID     string `json:"id"`
+	Poll   string `json:"poll_id"`
+	Tweet  string `json:"tweet_id"`
+	Option string `json:"option"` // the vote was counted for
+	Action string `json:"action"` // CorrectionDecrement or CorrectionReassign
+	To     string `json:"to,omitempty"`
+	// Votes is how many votes the tweet counted for, 1 unless sampled
+	Votes  int    `json:"votes"`
+	Reason string `json:"reason,omitempty"`
+	Status string `json:"status"`
+	// Problem is why a rejected correction wasn't applied
+	Problem string    `json:"problem,omitempty"`
+	Created time.Time `json:"created"`
+}
+
+// CorrectionStore is implemented by the stores keeping the corrections of
+// disputed votes, which the API records and the counters apply
+type CorrectionStore interface {
+	// PendingCorrections returns the corrections not applied yet, oldest first
+	PendingCorrections() ([]Correction, error)
+	// ApplyCorrection changes the results of c's poll as c says and marks it
+	// applied. Applying it again changes nothing, false when it was already.
+	ApplyCorrection(c Correction) (bool, error)
+	// RejectCorrection marks the correction id rejected, with the problem why
+	RejectCorrection(id, problem string) error
+}
+
+// correctionDoc is a document of the corrections collection
+type correctionDoc struct {
+	ID      string     `bson:"_id"`
+	Poll    string     `bson:"poll_id"`
+	Tweet   string     `bson:"tweet_id"`
+	Option  string     `bson:"option"`
+	Action  string     `bson:"action"`
+	To      string     `bson:"to,omitempty"`
+	Votes   int        `bson:"votes"`
+	Reason  string     `bson:"reason,omitempty"`
+	Status  string     `bson:"status"`
+	Problem string     `bson:"problem,omitempty"`
+	Created time.Time  `bson:"created"`
+	Applied *time.Time `bson:"applied,omitempty"`
+}
+
+func (m *Mongo) corrections() *mgo.Collection {
+	return m.session.DB(m.db).C("corrections")
+}
+
+// PendingCorrections reads the pending corrections from the corrections collection
+func (m *Mongo) PendingCorrections() ([]Correction, error) {
+	var docs []correctionDoc
+	if err := m.corrections().Find(bson.M{"status": CorrectionPending}).Sort("created").All(&docs); err != nil {
+		return nil, err
+	}
+	cs := make([]Correction, len(docs))
+	for i, d := range docs {
+		cs[i] = Correction{ID: d.ID, Poll: d.Poll, Tweet: d.Tweet, Option: d.Option, Action: d.Action, To: d.To,
+			Votes: d.Votes, Reason: d.Reason, Status: d.Status, Problem: d.Problem, Created: d.Created}
+	}
+	return cs, nil
+}
+
+// ApplyCorrection changes the poll's results and lists c in its corrections
+// in one update, which only matches a poll without c listed, then marks c
+// applied; a counter stopping in between finds c pending and only marks it
+func (m *Mongo) ApplyCorrection(c Correction) (bool, error) {
+	if !bson.IsObjectIdHex(c.Poll) {
+		return false, ErrNotFound
+	}
+	id := bson.ObjectIdHex(c.Poll)
+	inc := bson.M{"results." + c.Option: -c.Votes, "metrics." + MetricCorrected + "." + c.Option: float64(c.Votes)}
+	if c.Action == CorrectionReassign {
+		inc["results."+c.To] = c.Votes
+		inc["metrics."+MetricCorrected+"."+c.To] = -float64(c.Votes)
+	}
+	err := m.pollsOf(id).Update(bson.M{"_id": id, "corrections": bson.M{"$ne": c.ID}},
+		bson.M{"$inc": inc, "$push": bson.M{"corrections": c.ID}})
+	applied := err == nil
+	if err == mgo.ErrNotFound {
+		if _, err := m.Poll(c.Poll); err != nil {
+			return false, err
+		}
+	} else if err != nil {
+		return false, err
+	}
+	now := time.Now()
+	if err := m.corrections().UpdateId(c.ID, bson.M{"$set": bson.M{"status": CorrectionApplied, "applied": now}}); err != nil {
+		return applied, err
+	}
+	return applied, nil
+}
+
+// RejectCorrection marks the correction rejected in the corrections collection
+func (m *Mongo) RejectCorrection(id, problem string) error {
+	return m.corrections().UpdateId(id, bson.M{"$set": bson.M{"status": CorrectionRejected, "problem": problem}})
+}
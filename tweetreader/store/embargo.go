@@ -0,0 +1,98 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+// clockFormat is how the quiet hours are written
+const clockFormat = "15:04"
+
+// Embargo holds a poll's votes back from its results, until a time or every
+// day during its quiet hours. The votes are counted once the embargo lifts.
+type Embargo struct {
+	// Until holds every vote until then
+	Until *time.Time `json:"until,omitempty" bson:"until,omitempty"`
+	// QuietFrom and QuietTo are the daily quiet hours, as 15:04 in Zone, an
+	// IANA time zone, UTC when empty. They span midnight when QuietFrom is
+	// after QuietTo.
+	QuietFrom string `json:"quiet_from,omitempty" bson:"quiet_from,omitempty"`
+	QuietTo   string `json:"quiet_to,omitempty" bson:"quiet_to,omitempty"`
+	Zone      string `json:"zone,omitempty" bson:"zone,omitempty"`
+}
+
+// Validate checks the quiet hours and the time zone
+func (e *Embargo) Validate() error {
+	if e == nil {
+		return nil
+	}
+	if (e.QuietFrom == "") != (e.QuietTo == "") {
+		return errors.New("quiet hours need both quiet_from and quiet_to")
+	}
+	if e.QuietFrom != "" {
+		from, err := time.Parse(clockFormat, e.QuietFrom)
+		if err != nil {
+			return fmt.Errorf("invalid quiet_from %q, want 15:04", e.QuietFrom)
+		}
+		to, err := time.Parse(clockFormat, e.QuietTo)
+		if err != nil {
+			return fmt.Errorf("invalid quiet_to %q, want 15:04", e.QuietTo)
+		}
+		if from.Equal(to) {
+			return errors.New("quiet_from and quiet_to are the same time")
+		}
+	}
+	if _, err := time.LoadLocation(e.Zone); err != nil {
+		return fmt.Errorf("invalid zone %q: %v", e.Zone, err)
+	}
+	return nil
+}
+
+// Lifts returns when a vote arriving at now may be counted, now when the
+// poll isn't embargoed then. An invalid embargo holds nothing.
+func (e *Embargo) Lifts(now time.Time) time.Time {
+	if e == nil || e.Validate() != nil {
+		return now
+	}
+	t := now
+	// the end of the quiet hours may fall before Until, and Until within the quiet hours
+	for i := 0; i < 3; i++ {
+		if e.Until != nil && t.Before(*e.Until) {
+			t = *e.Until
+		}
+		end, quiet := e.quietEnd(t)
+		if !quiet {
+			break
+		}
+		t = end
+	}
+	return t
+}
+
+// quietEnd reports whether t is in the quiet hours and when they end
+func (e *Embargo) quietEnd(t time.Time) (time.Time, bool) {
+	if e.QuietFrom == "" {
+		return t, false
+	}
+	loc, _ := time.LoadLocation(e.Zone)
+	local := t.In(loc)
+	from, _ := time.Parse(clockFormat, e.QuietFrom)
+	to, _ := time.Parse(clockFormat, e.QuietTo)
+	clock := func(c time.Time) int { return c.Hour()*60 + c.Minute() }
+	m, f, u := clock(local), clock(from), clock(to)
+	var quiet bool
+	if f < u {
+		quiet = m >= f && m < u
+	} else {
+		quiet = m >= f || m < u
+	}
+	if !quiet {
+		return t, false
+	}
+	end := time.Date(local.Year(), local.Month(), local.Day(), to.Hour(), to.Minute(), 0, 0, loc)
+	if !end.After(local) {
+		end = time.Date(local.Year(), local.Month(), local.Day()+1, to.Hour(), to.Minute(), 0, 0, loc)
+	}
+	return end, true
+}
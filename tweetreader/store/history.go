@@ -0,0 +1,42 @@
+package store
+
+import (
+	"sort"
+	"time"
+)
+
+// HistoryEntry is a copy of one poll's results at one point in time
+type HistoryEntry struct {
+	Poll            string
+	Time            time.Time
+	Results         map[string]int
+	WeightedResults map[string]float64
+}
+
+// HistoryStore is implemented by stores that keep periodic copies of the results in results_history
+type HistoryStore interface {
+	// SaveHistory adds entries to the history
+	SaveHistory(entries []HistoryEntry) error
+	// CompactHistory deletes the entries from before deleteBefore, and of the entries
+	// from before thinBefore keeps only the first of every window of width every
+	CompactHistory(deleteBefore, thinBefore time.Time, every time.Duration) error
+	// History returns the entries of poll from from on and before to, oldest
+	// first; a zero from or to leaves that end open
+	History(poll string, from, to time.Time) ([]HistoryEntry, error)
+}
+
+// thin returns the times to drop so only the first of every window of width every is kept
+func thin(times []time.Time, every time.Duration) []time.Time {
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	var drop []time.Time
+	var window time.Time
+	for i, t := range times {
+		w := t.Truncate(every)
+		if i > 0 && w.Equal(window) {
+			drop = append(drop, t)
+			continue
+		}
+		window = w
+	}
+	return drop
+}
@@ -0,0 +1,209 @@
+package store
+
+import (
+	"sort"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// LeaseStore is implemented by stores that can coordinate several streamers.
+// A lease is held by a member of a group until it expires, members renew
+// theirs well before then. Expiry times come from the members' clocks,
+// which need to be roughly in sync.
+type LeaseStore interface {
+	// RenewLease claims or extends member's lease in group until expires
+	RenewLease(group, member string, expires time.Time) error
+	// Leases returns the members of group whose leases are still valid at now, sorted
+	Leases(group string, now time.Time) ([]string, error)
+	// ReleaseLease gives member's lease in group up
+	ReleaseLease(group, member string) error
+}
+
+// LeaderStore is implemented by stores that can elect a leader among
+// processes: a lease that only one holder has at a time
+type LeaderStore interface {
+	// AcquireLeadership makes holder the leader called name until expires, unless
+	// another holder's lease is still valid at now. It reports whether holder leads.
+	AcquireLeadership(name, holder string, now, expires time.Time) (bool, error)
+	// ReleaseLeadership ends holder's lease, if it still has it
+	ReleaseLeadership(name, holder string) error
+}
+
+type leaseDoc struct {
+	ID      string    `bson:"_id"` // group/member
+	Group   string    `bson:"group"`
+	Member  string    `bson:"member"`
+	Expires time.Time `bson:"expires"`
+}
+
+// RenewLease upserts the member's document in the leases collection
+func (m *Mongo) RenewLease(group, member string, expires time.Time) error {
+	_, err := m.session.DB(m.db).C("leases").UpsertId(group+"/"+member,
+		leaseDoc{ID: group + "/" + member, Group: group, Member: member, Expires: expires})
+	return err
+}
+
+// Leases returns the members of group with a lease expiring after now
+func (m *Mongo) Leases(group string, now time.Time) ([]string, error) {
+	var docs []leaseDoc
+	err := m.session.DB(m.db).C("leases").
+		Find(bson.M{"group": group, "expires": bson.M{"$gt": now}}).Sort("member").All(&docs)
+	if err != nil {
+		return nil, err
+	}
+	members := make([]string, len(docs))
+	for i, d := range docs {
+		members[i] = d.Member
+	}
+	return members, nil
+}
+
+// ReleaseLease removes the member's document from the leases collection
+func (m *Mongo) ReleaseLease(group, member string) error {
+	err := m.session.DB(m.db).C("leases").RemoveId(group + "/" + member)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// RenewLease upserts the member's row in leases
+func (s *SQL) RenewLease(group, member string, expires time.Time) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO leases (grp, member, expires) VALUES (?, ?, ?)
+		ON CONFLICT (grp, member) DO UPDATE SET expires = excluded.expires`),
+		group, member, expires.UnixNano()/int64(time.Millisecond))
+	return err
+}
+
+// Leases returns the members of group with a lease expiring after now
+func (s *SQL) Leases(group string, now time.Time) ([]string, error) {
+	rows, err := s.db.Query(s.q(`SELECT member FROM leases WHERE grp = ? AND expires > ? ORDER BY member`),
+		group, now.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var members []string
+	for rows.Next() {
+		var member string
+		if err := rows.Scan(&member); err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+	}
+	return members, rows.Err()
+}
+
+// ReleaseLease deletes the member's row from leases
+func (s *SQL) ReleaseLease(group, member string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM leases WHERE grp = ? AND member = ?`), group, member)
+	return err
+}
+
+// RenewLease claims or extends the member's lease
+func (m *Memory) RenewLease(group, member string, expires time.Time) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leases == nil {
+		m.leases = make(map[string]map[string]time.Time)
+	}
+	if m.leases[group] == nil {
+		m.leases[group] = make(map[string]time.Time)
+	}
+	m.leases[group][member] = expires
+	return nil
+}
+
+// Leases returns the members of group with a lease expiring after now
+func (m *Memory) Leases(group string, now time.Time) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var members []string
+	for member, expires := range m.leases[group] {
+		if expires.After(now) {
+			members = append(members, member)
+		}
+	}
+	sort.Strings(members)
+	return members, nil
+}
+
+// ReleaseLease gives the member's lease up
+func (m *Memory) ReleaseLease(group, member string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.leases[group], member)
+	return nil
+}
+
+type leaderDoc struct {
+	Name    string    `bson:"_id"`
+	Holder  string    `bson:"holder"`
+	Expires time.Time `bson:"expires"`
+}
+
+// AcquireLeadership upserts the lease in the leaders collection if holder has
+// it or it expired, the unique _id refuses a second leader
+func (m *Mongo) AcquireLeadership(name, holder string, now, expires time.Time) (bool, error) {
+	_, err := m.session.DB(m.db).C("leaders").Upsert(
+		bson.M{"_id": name, "$or": []bson.M{{"holder": holder}, {"expires": bson.M{"$lte": now}}}},
+		leaderDoc{Name: name, Holder: holder, Expires: expires})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// ReleaseLeadership removes the lease from the leaders collection if holder has it
+func (m *Mongo) ReleaseLeadership(name, holder string) error {
+	err := m.session.DB(m.db).C("leaders").Remove(bson.M{"_id": name, "holder": holder})
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// AcquireLeadership upserts the lease in leaders if holder has it or it expired
+func (s *SQL) AcquireLeadership(name, holder string, now, expires time.Time) (bool, error) {
+	res, err := s.db.Exec(s.q(`INSERT INTO leaders (name, holder, expires) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET holder = excluded.holder, expires = excluded.expires
+		WHERE leaders.holder = excluded.holder OR leaders.expires <= ?`),
+		name, holder, expires.UnixNano()/int64(time.Millisecond), now.UnixNano()/int64(time.Millisecond))
+	if err != nil {
+		return false, err
+	}
+	n, err := res.RowsAffected()
+	return n == 1, err
+}
+
+// ReleaseLeadership deletes the lease from leaders if holder has it
+func (s *SQL) ReleaseLeadership(name, holder string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM leaders WHERE name = ? AND holder = ?`), name, holder)
+	return err
+}
+
+// AcquireLeadership makes holder the leader if it is or the lease expired
+func (m *Memory) AcquireLeadership(name, holder string, now, expires time.Time) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leaders == nil {
+		m.leaders = make(map[string]leaderDoc)
+	}
+	if l, ok := m.leaders[name]; ok && l.Holder != holder && l.Expires.After(now) {
+		return false, nil
+	}
+	m.leaders[name] = leaderDoc{Name: name, Holder: holder, Expires: expires}
+	return true, nil
+}
+
+// ReleaseLeadership ends holder's lease
+func (m *Memory) ReleaseLeadership(name, holder string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.leaders[name].Holder == holder {
+		delete(m.leaders, name)
+	}
+	return nil
+}
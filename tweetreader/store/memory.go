@@ -0,0 +1,309 @@
+package store
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// Memory is a Backend that keeps everything in memory and loses it on exit.
+// It is used to run the pipeline without any database, e.g. by bench.
+type Memory struct {
+	mu           sync.Mutex
+	polls        []*Poll
+	nextID       int
+	snapshots    map[string][]byte
+	leases       map[string]map[string]time.Time // expiry by group and member
+	leaders      map[string]leaderDoc
+	outbox       []OutboxMessage
+	outboxID     int
+	voters       map[string]bool // by poll and voter
+	certificates map[string][]byte
+	voteLog      []LoggedVote
+}
+
+// NewMemory creates an in-memory store holding polls
+func NewMemory(polls ...Poll) *Memory {
+	m := &Memory{snapshots: make(map[string][]byte)}
+	for i := range polls {
+		m.CreatePoll(&polls[i])
+	}
+	return m
+}
+
+// LoadOptions returns the options of every active poll
+func (m *Memory) LoadOptions() ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var options []string
+	for _, p := range m.polls {
+		if p.Tracked() {
+			options = append(options, p.Options...)
+		}
+	}
+	return options, nil
+}
+
+// Close does nothing, there is no connection to release
+func (m *Memory) Close() {}
+
+// Polls returns a copy of every poll
+func (m *Memory) Polls() ([]Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	polls := make([]Poll, len(m.polls))
+	for i, p := range m.polls {
+		polls[i] = copyPoll(p)
+	}
+	return polls, nil
+}
+
+// Poll returns a copy of a single poll, or ErrNotFound
+func (m *Memory) Poll(id string) (Poll, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p := m.find(id); p != nil {
+		return copyPoll(p), nil
+	}
+	return Poll{}, ErrNotFound
+}
+
+// CreatePoll stores a new poll, keeping its ID when it has one
+func (m *Memory) CreatePoll(p *Poll) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if p.ID == "" {
+		m.nextID++
+		p.ID = strconv.Itoa(m.nextID)
+	}
+	stored := copyPoll(p)
+	m.polls = append(m.polls, &stored)
+	return nil
+}
+
+// DeletePoll removes the poll with the given ID
+func (m *Memory) DeletePoll(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for i, p := range m.polls {
+		if p.ID == id {
+			m.polls = append(m.polls[:i], m.polls[i+1:]...)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// SetPollStatus changes the poll's status if it still is from
+func (m *Memory) SetPollStatus(id, from, to string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(id)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.Status != from {
+		return ErrStatusChanged
+	}
+	p.Status = to
+	return nil
+}
+
+// AddResults increments a poll's raw counts and weighted tallies
+func (m *Memory) AddResults(pollID string, counts map[string]int, weighted map[string]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.Results == nil {
+		p.Results = make(map[string]int)
+	}
+	for option, n := range counts {
+		p.Results[option] += n
+	}
+	if len(weighted) > 0 && p.WeightedResults == nil {
+		p.WeightedResults = make(map[string]float64)
+	}
+	for option, w := range weighted {
+		p.WeightedResults[option] += w
+	}
+	return nil
+}
+
+// AddGeoResults increments a poll's counts per area and option
+func (m *Memory) AddGeoResults(pollID string, counts map[string]map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.GeoResults == nil {
+		p.GeoResults = make(map[string]map[string]int)
+	}
+	for area, options := range counts {
+		if p.GeoResults[area] == nil {
+			p.GeoResults[area] = make(map[string]int)
+		}
+		for option, n := range options {
+			p.GeoResults[area][option] += n
+		}
+	}
+	return nil
+}
+
+// AddSourceResults increments a poll's counts per source and option
+func (m *Memory) AddSourceResults(pollID string, counts map[string]map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.SourceResults == nil {
+		p.SourceResults = make(map[string]map[string]int)
+	}
+	for source, options := range counts {
+		if p.SourceResults[source] == nil {
+			p.SourceResults[source] = make(map[string]int)
+		}
+		for option, n := range options {
+			p.SourceResults[source][option] += n
+		}
+	}
+	return nil
+}
+
+// AddLanguageResults increments a poll's counts per language and option
+func (m *Memory) AddLanguageResults(pollID string, counts map[string]map[string]int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.LanguageResults == nil {
+		p.LanguageResults = make(map[string]map[string]int)
+	}
+	for language, options := range counts {
+		if p.LanguageResults[language] == nil {
+			p.LanguageResults[language] = make(map[string]int)
+		}
+		for option, n := range options {
+			p.LanguageResults[language][option] += n
+		}
+	}
+	return nil
+}
+
+// AddMetrics increments a poll's custom metrics
+func (m *Memory) AddMetrics(pollID string, metrics map[string]map[string]float64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	if p.Metrics == nil {
+		p.Metrics = make(map[string]map[string]float64)
+	}
+	for metric, options := range metrics {
+		if p.Metrics[metric] == nil {
+			p.Metrics[metric] = make(map[string]float64)
+		}
+		for option, v := range options {
+			p.Metrics[metric][option] += v
+		}
+	}
+	return nil
+}
+
+// SaveSnapshot replaces the snapshot saved under name
+func (m *Memory) SaveSnapshot(name string, data []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.snapshots[name] = data
+	return nil
+}
+
+// LoadSnapshot returns the snapshot saved under name, or ErrNoSnapshot
+func (m *Memory) LoadSnapshot(name string) ([]byte, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	data, ok := m.snapshots[name]
+	if !ok {
+		return nil, ErrNoSnapshot
+	}
+	return data, nil
+}
+
+// find returns the stored poll with the given ID, callers hold mu
+func (m *Memory) find(id string) *Poll {
+	for _, p := range m.polls {
+		if p.ID == id {
+			return p
+		}
+	}
+	return nil
+}
+
+// copyPoll copies a poll deep enough that callers can't change the stored results
+func copyPoll(p *Poll) Poll {
+	c := *p
+	c.Options = append([]string(nil), p.Options...)
+	c.Locations = append([]stream.BoundingBox(nil), p.Locations...)
+	c.Notifications = append([]Notification(nil), p.Notifications...)
+	if p.Results != nil {
+		c.Results = make(map[string]int, len(p.Results))
+		for option, n := range p.Results {
+			c.Results[option] = n
+		}
+	}
+	if p.WeightedResults != nil {
+		c.WeightedResults = make(map[string]float64, len(p.WeightedResults))
+		for option, w := range p.WeightedResults {
+			c.WeightedResults[option] = w
+		}
+	}
+	if p.GeoResults != nil {
+		c.GeoResults = make(map[string]map[string]int, len(p.GeoResults))
+		for area, options := range p.GeoResults {
+			c.GeoResults[area] = make(map[string]int, len(options))
+			for option, n := range options {
+				c.GeoResults[area][option] = n
+			}
+		}
+	}
+	if p.SourceResults != nil {
+		c.SourceResults = make(map[string]map[string]int, len(p.SourceResults))
+		for source, options := range p.SourceResults {
+			c.SourceResults[source] = make(map[string]int, len(options))
+			for option, n := range options {
+				c.SourceResults[source][option] = n
+			}
+		}
+	}
+	if p.LanguageResults != nil {
+		c.LanguageResults = make(map[string]map[string]int, len(p.LanguageResults))
+		for language, options := range p.LanguageResults {
+			c.LanguageResults[language] = make(map[string]int, len(options))
+			for option, n := range options {
+				c.LanguageResults[language][option] = n
+			}
+		}
+	}
+	if p.Metrics != nil {
+		c.Metrics = make(map[string]map[string]float64, len(p.Metrics))
+		for metric, options := range p.Metrics {
+			c.Metrics[metric] = make(map[string]float64, len(options))
+			for option, v := range options {
+				c.Metrics[metric][option] = v
+			}
+		}
+	}
+	return c
+}
@@ -0,0 +1,676 @@
+package store
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// DialOptions controls how hard DialMongo tries before giving up.
+// MongoDB is often still starting when we do (docker-compose brings everything up at once),
+// so dialing is retried with exponential backoff until Timeout runs out.
+type DialOptions struct {
+	Timeout    time.Duration // give up after this long
+	Backoff    time.Duration // wait this long after the first failure
+	MaxBackoff time.Duration // never wait longer than this between attempts
+
+	// TLS, when set, encrypts the connections to MongoDB. ssl=true or tls=true
+	// in the address does too, with the system roots.
+	TLS *tls.Config
+	// Username and Password replace the credentials in the address, so the
+	// password doesn't have to be part of it
+	Username, Password string
+
+	// Database keeps the polls and everything else, DefaultDatabase when
+	// empty, in the Polls collection, DefaultPolls when empty
+	Database, Polls string
+	// Tenants are more collections polls are read from, see Namespace
+	Tenants []Namespace
+	// WrapConn, when set, wraps the connections to MongoDB, to inject faults
+	WrapConn func(net.Conn) net.Conn
+}
+
+// Mongo keeps polls in the ballots database, and in the tenants' collections
+type Mongo struct {
+	session *mgo.Session
+	db      string
+	spaces  []Namespace // the polls collections, the database's own first
+
+	mu    sync.Mutex
+	homes map[bson.ObjectId]int // the index in spaces of the polls seen
+}
+
+// poll contains the options for a poll object
+type poll struct {
+	Options []string
+}
+
+// pollDoc is the full poll document
+type pollDoc struct {
+	ID              bson.ObjectId                 `bson:"_id"`
+	Title           string                        `bson:"title"`
+	Options         []string                      `bson:"options"`
+	Results         map[string]int                `bson:"results,omitempty"`
+	WeightedResults map[string]float64            `bson:"weighted_results,omitempty"`
+	Status          string                        `bson:"status,omitempty"`
+	Type            string                        `bson:"type,omitempty"`
+	Visibility      string                        `bson:"visibility,omitempty"`
+	DetailedMetrics bool                          `bson:"detailed_metrics,omitempty"`
+	Locations       []stream.BoundingBox          `bson:"locations,omitempty"`
+	GeoAggregation  string                        `bson:"geo_aggregation,omitempty"`
+	GeoResults      map[string]map[string]int     `bson:"geo_results,omitempty"`
+	HashtagOnly     bool                          `bson:"hashtag_only,omitempty"`
+	Metrics         map[string]map[string]float64 `bson:"metrics,omitempty"`
+	SourceResults   map[string]map[string]int     `bson:"source_results,omitempty"`
+	LanguageResults map[string]map[string]int     `bson:"language_results,omitempty"`
+	Notifications   []Notification                `bson:"notifications,omitempty"`
+	Account         string                        `bson:"account,omitempty"`
+	Private         bool                          `bson:"private,omitempty"`
+	Counting        string                        `bson:"counting,omitempty"`
+	ExcludeSuspect  bool                          `bson:"exclude_suspect,omitempty"`
+	SampleEvery     int                           `bson:"sample_every,omitempty"`
+	ExcludeRetweets bool                          `bson:"exclude_retweets,omitempty"`
+	ExcludeQuotes   bool                          `bson:"exclude_quotes,omitempty"`
+	ExcludeReplies  bool                          `bson:"exclude_replies,omitempty"`
+	EmbeddedText    string                        `bson:"embedded_text,omitempty"`
+	Matching        []OptionMatching              `bson:"matching,omitempty"`
+	Folding         string                        `bson:"folding,omitempty"`
+	Priority        string                        `bson:"priority,omitempty"`
+	Embargo         *Embargo                      `bson:"embargo,omitempty"`
+	Filter          string                        `bson:"filter,omitempty"`
+	Route           string                        `bson:"route,omitempty"`
+	MaxPerMinute    int                           `bson:"max_per_minute,omitempty"`
+	Timezone        string                        `bson:"timezone,omitempty"`
+	Campaign        string                        `bson:"campaign,omitempty"`
+	EnrichAuthors   bool                          `bson:"enrich_authors,omitempty"`
+	Version         int                           `bson:"version,omitempty"`
+	Versions        []PollVersion                 `bson:"versions,omitempty"`
+	DeletedAt       *time.Time                    `bson:"deleted_at,omitempty"`
+	Corrections     []string                      `bson:"corrections,omitempty"`
+}
+
+func (d *pollDoc) poll() Poll {
+	return Poll{
+		ID:              d.ID.Hex(),
+		Title:           d.Title,
+		Options:         d.Options,
+		Results:         d.Results,
+		WeightedResults: d.WeightedResults,
+		Status:          d.Status,
+		Type:            d.Type,
+		Visibility:      d.Visibility,
+		DetailedMetrics: d.DetailedMetrics,
+		Locations:       d.Locations,
+		GeoAggregation:  d.GeoAggregation,
+		GeoResults:      d.GeoResults,
+		HashtagOnly:     d.HashtagOnly,
+		Metrics:         d.Metrics,
+		SourceResults:   d.SourceResults,
+		LanguageResults: d.LanguageResults,
+		Notifications:   d.Notifications,
+		Account:         d.Account,
+		Private:         d.Private,
+		Counting:        d.Counting,
+		ExcludeSuspect:  d.ExcludeSuspect,
+		SampleEvery:     d.SampleEvery,
+		ExcludeRetweets: d.ExcludeRetweets,
+		ExcludeQuotes:   d.ExcludeQuotes,
+		ExcludeReplies:  d.ExcludeReplies,
+		EmbeddedText:    d.EmbeddedText,
+		Matching:        d.Matching,
+		Folding:         d.Folding,
+		Priority:        d.Priority,
+		Embargo:         d.Embargo,
+		Filter:          d.Filter,
+		Route:           d.Route,
+		MaxPerMinute:    d.MaxPerMinute,
+		Timezone:        d.Timezone,
+		Campaign:        d.Campaign,
+		EnrichAuthors:   d.EnrichAuthors,
+		Version:         d.Version,
+		DeletedAt:       d.DeletedAt,
+		Corrections:     d.Corrections,
+		Versions:        d.Versions,
+	}
+}
+
+// DialMongo connects to the MongoDB at addr, retrying as opts allow.
+// addr is a host or a mongodb:// URI, which may list the members of a replica
+// set and carry the credentials, authSource and replicaSet.
+func DialMongo(addr string, opts DialOptions) (*Mongo, error) {
+	info, err := mongoDialInfo(addr, opts)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		log.Printf("dialing mongodb: %s (attempt %d)", strings.Join(info.Addrs, ","), attempt)
+		session, err := mgo.DialWithInfo(info)
+		if err == nil {
+			return newMongo(session, opts), nil
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			return nil, err
+		}
+		log.Printf("failed to dial mongodb: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+}
+
+// mongoDialInfo parses addr and applies the TLS and credentials in opts.
+// mgo doesn't know the ssl and tls URI options, they are handled here.
+func mongoDialInfo(addr string, opts DialOptions) (*mgo.DialInfo, error) {
+	useTLS := opts.TLS != nil
+	if i := strings.Index(addr, "?"); i >= 0 {
+		query, err := url.ParseQuery(addr[i+1:])
+		if err != nil {
+			return nil, err
+		}
+		for _, k := range []string{"ssl", "tls"} {
+			if query.Get(k) == "true" {
+				useTLS = true
+			}
+			query.Del(k)
+		}
+		addr = addr[:i]
+		if len(query) > 0 {
+			addr += "?" + query.Encode()
+		}
+	}
+	info, err := mgo.ParseURL(addr)
+	if err != nil {
+		return nil, err
+	}
+	info.Timeout = 10 * time.Second
+	if opts.Username != "" {
+		info.Username = opts.Username
+	}
+	if opts.Password != "" {
+		info.Password = opts.Password
+	}
+	if useTLS {
+		cfg := opts.TLS
+		if cfg == nil {
+			cfg = &tls.Config{}
+		}
+		dialer := &net.Dialer{Timeout: info.Timeout}
+		info.DialServer = func(a *mgo.ServerAddr) (net.Conn, error) {
+			return tls.DialWithDialer(dialer, "tcp", a.String(), cfg)
+		}
+	}
+	if wrap := opts.WrapConn; wrap != nil {
+		dial := info.DialServer
+		if dial == nil {
+			dialer := &net.Dialer{Timeout: info.Timeout}
+			dial = func(a *mgo.ServerAddr) (net.Conn, error) {
+				return dialer.Dial("tcp", a.String())
+			}
+		}
+		info.DialServer = func(a *mgo.ServerAddr) (net.Conn, error) {
+			c, err := dial(a)
+			if err != nil {
+				return nil, err
+			}
+			return wrap(c), nil
+		}
+	}
+	return info, nil
+}
+
+// Session returns the underlying session for the parts of the pipeline that only run on MongoDB
+func (m *Mongo) Session() *mgo.Session {
+	return m.session
+}
+
+// LoadOptions returns the options of every active poll in the polls collections
+func (m *Mongo) LoadOptions() ([]string, error) {
+	var options []string
+	var p poll
+
+	// query the polls collections for the active polls, the ones without a
+	// status too but not the deleted ones, and go over them with an iterator
+	active := bson.M{"status": bson.M{"$in": []interface{}{nil, "", StatusActive}}, "deleted_at": nil}
+	for i := range m.spaces {
+		iter := m.pollsIn(i).Find(active).Iter()
+		// loop over the results and load the options into the options slice
+		for iter.Next(&p) {
+			options = append(options, p.Options...)
+		}
+		iter.Close()
+		if err := iter.Err(); err != nil {
+			return nil, err
+		}
+	}
+	return options, nil
+}
+
+// Polls returns every poll in the polls collections, with its tenant
+func (m *Mongo) Polls() ([]Poll, error) {
+	var polls []Poll
+	for i, ns := range m.spaces {
+		var docs []pollDoc
+		if err := m.pollsIn(i).Find(nil).All(&docs); err != nil {
+			return nil, err
+		}
+		for _, d := range docs {
+			m.found(d.ID, i)
+			p := d.poll()
+			p.Tenant = ns.Tenant
+			polls = append(polls, p)
+		}
+	}
+	if polls == nil {
+		polls = []Poll{}
+	}
+	return polls, nil
+}
+
+// Poll returns a single poll
+func (m *Mongo) Poll(id string) (Poll, error) {
+	if !bson.IsObjectIdHex(id) {
+		return Poll{}, ErrNotFound
+	}
+	var d pollDoc
+	i := m.home(bson.ObjectIdHex(id))
+	if err := m.pollsIn(i).FindId(bson.ObjectIdHex(id)).One(&d); err != nil {
+		if err == mgo.ErrNotFound {
+			return Poll{}, ErrNotFound
+		}
+		return Poll{}, err
+	}
+	p := d.poll()
+	p.Tenant = m.spaces[i].Tenant
+	return p, nil
+}
+
+// CreatePoll inserts p into the polls collection of its tenant
+func (m *Mongo) CreatePoll(p *Poll) error {
+	i, ok := m.tenant(p.Tenant)
+	if !ok {
+		return fmt.Errorf("store: unknown tenant %q", p.Tenant)
+	}
+	d := pollDoc{
+		ID:              bson.NewObjectId(),
+		Title:           p.Title,
+		Options:         p.Options,
+		Status:          p.Status,
+		Type:            p.Type,
+		Visibility:      p.Visibility,
+		DetailedMetrics: p.DetailedMetrics,
+		Locations:       p.Locations,
+		GeoAggregation:  p.GeoAggregation,
+		HashtagOnly:     p.HashtagOnly,
+		Notifications:   p.Notifications,
+		Account:         p.Account,
+		Private:         p.Private,
+		Counting:        p.Counting,
+		ExcludeSuspect:  p.ExcludeSuspect,
+		SampleEvery:     p.SampleEvery,
+		ExcludeRetweets: p.ExcludeRetweets,
+		ExcludeQuotes:   p.ExcludeQuotes,
+		ExcludeReplies:  p.ExcludeReplies,
+		EmbeddedText:    p.EmbeddedText,
+		Matching:        p.Matching,
+		Folding:         p.Folding,
+		Priority:        p.Priority,
+		Embargo:         p.Embargo,
+		Filter:          p.Filter,
+		Route:           p.Route,
+		MaxPerMinute:    p.MaxPerMinute,
+		Timezone:        p.Timezone,
+		Campaign:        p.Campaign,
+		EnrichAuthors:   p.EnrichAuthors,
+		Version:         p.Version,
+	}
+	if err := m.pollsIn(i).Insert(d); err != nil {
+		return err
+	}
+	m.found(d.ID, i)
+	p.ID = d.ID.Hex()
+	return nil
+}
+
+// DeletePoll removes a poll from the polls collection
+func (m *Mongo) DeletePoll(id string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrNotFound
+	}
+	err := m.pollsOf(bson.ObjectIdHex(id)).RemoveId(bson.ObjectIdHex(id))
+	if err == mgo.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+// SetPollStatus changes the poll's status if it still is from
+func (m *Mongo) SetPollStatus(id, from, to string) error {
+	if !bson.IsObjectIdHex(id) {
+		return ErrNotFound
+	}
+	var current interface{} = from
+	if from == "" {
+		current = bson.M{"$in": []interface{}{nil, ""}}
+	}
+	err := m.pollsOf(bson.ObjectIdHex(id)).Update(bson.M{"_id": bson.ObjectIdHex(id), "status": current}, bson.M{"$set": bson.M{"status": to}})
+	if err == mgo.ErrNotFound {
+		if _, err := m.Poll(id); err != nil {
+			return err
+		}
+		return ErrStatusChanged
+	}
+	return err
+}
+
+// AddResults increments the results (and weighted_results) of a poll
+func (m *Mongo) AddResults(pollID string, counts map[string]int, weighted map[string]float64) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	inc := bson.M{}
+	for option, n := range counts {
+		inc["results."+option] = n
+	}
+	for option, w := range weighted {
+		inc["weighted_results."+option] = w
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), bson.M{"$inc": inc})
+}
+
+// AddGeoResults increments the geo_results of a poll
+func (m *Mongo) AddGeoResults(pollID string, counts map[string]map[string]int) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	inc := bson.M{}
+	for area, options := range counts {
+		for option, n := range options {
+			inc["geo_results."+fieldKey(area)+"."+option] = n
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), bson.M{"$inc": inc})
+}
+
+// AddSourceResults increments the source_results of a poll
+func (m *Mongo) AddSourceResults(pollID string, counts map[string]map[string]int) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	inc := bson.M{}
+	for source, options := range counts {
+		for option, n := range options {
+			inc["source_results."+fieldKey(source)+"."+option] = n
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), bson.M{"$inc": inc})
+}
+
+// AddLanguageResults increments the language_results of a poll
+func (m *Mongo) AddLanguageResults(pollID string, counts map[string]map[string]int) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	inc := bson.M{}
+	for language, options := range counts {
+		for option, n := range options {
+			inc["language_results."+fieldKey(language)+"."+option] = n
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), bson.M{"$inc": inc})
+}
+
+// AddMetrics increments the metrics of a poll
+func (m *Mongo) AddMetrics(pollID string, metrics map[string]map[string]float64) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	inc := bson.M{}
+	for name, options := range metrics {
+		for option, v := range options {
+			inc["metrics."+fieldKey(name)+"."+option] = v
+		}
+	}
+	if len(inc) == 0 {
+		return nil
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), bson.M{"$inc": inc})
+}
+
+// fieldKey makes an area or metric name safe to use as a document key
+func fieldKey(s string) string {
+	return strings.NewReplacer(".", "", "$", "").Replace(s)
+}
+
+// SaveTweet keeps a counted tweet in the tweets collection
+func (m *Mongo) SaveTweet(doc interface{}) error {
+	return m.session.DB(m.db).C("tweets").Insert(doc)
+}
+
+// DeleteTweets removes the copies of the tweets with ids from the tweets collection
+func (m *Mongo) DeleteTweets(ids []string) error {
+	_, err := m.session.DB(m.db).C("tweets").RemoveAll(bson.M{"id_str": bson.M{"$in": ids}})
+	return err
+}
+
+// historyDoc is a document in the results_history collection
+type historyDoc struct {
+	ID              bson.ObjectId      `bson:"_id,omitempty"`
+	Poll            string             `bson:"poll_id"`
+	Time            time.Time          `bson:"time"`
+	Results         map[string]int     `bson:"results"`
+	WeightedResults map[string]float64 `bson:"weighted_results,omitempty"`
+}
+
+func (m *Mongo) history() *mgo.Collection {
+	return m.session.DB(m.db).C("results_history")
+}
+
+// SaveHistory inserts a document per entry into results_history
+func (m *Mongo) SaveHistory(entries []HistoryEntry) error {
+	if len(entries) == 0 {
+		return nil
+	}
+	docs := make([]interface{}, len(entries))
+	for i, e := range entries {
+		docs[i] = historyDoc{Poll: e.Poll, Time: e.Time, Results: e.Results, WeightedResults: e.WeightedResults}
+	}
+	return m.history().Insert(docs...)
+}
+
+// CompactHistory deletes the expired documents, then thins the old ones poll by poll
+func (m *Mongo) CompactHistory(deleteBefore, thinBefore time.Time, every time.Duration) error {
+	c := m.history()
+	if _, err := c.RemoveAll(bson.M{"time": bson.M{"$lt": deleteBefore}}); err != nil {
+		return err
+	}
+	var docs []historyDoc
+	if err := c.Find(bson.M{"time": bson.M{"$lt": thinBefore}}).Select(bson.M{"poll_id": 1, "time": 1}).All(&docs); err != nil {
+		return err
+	}
+	times := make(map[string][]time.Time)
+	ids := make(map[string]map[time.Time]bson.ObjectId)
+	for _, d := range docs {
+		if ids[d.Poll] == nil {
+			ids[d.Poll] = make(map[time.Time]bson.ObjectId)
+		}
+		times[d.Poll] = append(times[d.Poll], d.Time)
+		ids[d.Poll][d.Time] = d.ID
+	}
+	var drop []bson.ObjectId
+	for poll, ts := range times {
+		for _, t := range thin(ts, every) {
+			drop = append(drop, ids[poll][t])
+		}
+	}
+	if len(drop) == 0 {
+		return nil
+	}
+	_, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": drop}})
+	return err
+}
+
+// History finds the documents of poll in the time range, oldest first
+func (m *Mongo) History(poll string, from, to time.Time) ([]HistoryEntry, error) {
+	q := bson.M{"poll_id": poll}
+	window := bson.M{}
+	if !from.IsZero() {
+		window["$gte"] = from
+	}
+	if !to.IsZero() {
+		window["$lt"] = to
+	}
+	if len(window) > 0 {
+		q["time"] = window
+	}
+	var docs []historyDoc
+	if err := m.history().Find(q).Sort("time").All(&docs); err != nil {
+		return nil, err
+	}
+	entries := make([]HistoryEntry, len(docs))
+	for i, d := range docs {
+		entries[i] = HistoryEntry{Poll: d.Poll, Time: d.Time, Results: d.Results, WeightedResults: d.WeightedResults}
+	}
+	return entries, nil
+}
+
+// expireBatch is how many documents ExpireTweets reads and deletes at once
+const expireBatch = 1000
+
+// ExpireTweets deletes the tweets whose _id was generated before before,
+// the tweets collection has no other time
+func (m *Mongo) ExpireTweets(before time.Time, keep func(docs [][]byte) error) (int, error) {
+	c := m.session.DB(m.db).C("tweets")
+	q := bson.M{"_id": bson.M{"$lt": bson.NewObjectIdWithTime(before)}}
+	deleted := 0
+	for {
+		var docs []bson.M
+		if err := c.Find(q).Sort("_id").Limit(expireBatch).All(&docs); err != nil {
+			return deleted, err
+		}
+		if len(docs) == 0 {
+			return deleted, nil
+		}
+		ids := make([]interface{}, len(docs))
+		for i, d := range docs {
+			ids[i] = d["_id"]
+		}
+		if keep != nil {
+			batch := make([][]byte, len(docs))
+			for i, d := range docs {
+				b, err := json.Marshal(d)
+				if err != nil {
+					return deleted, err
+				}
+				batch[i] = b
+			}
+			if err := keep(batch); err != nil {
+				return deleted, err
+			}
+		}
+		info, err := c.RemoveAll(bson.M{"_id": bson.M{"$in": ids}})
+		if err != nil {
+			return deleted, err
+		}
+		deleted += info.Removed
+	}
+}
+
+func (m *Mongo) series() *mgo.Collection {
+	return m.session.DB(m.db).C("results_timeseries")
+}
+
+func (m *Mongo) calendar() *mgo.Collection {
+	return m.session.DB(m.db).C("results_calendar")
+}
+
+// ExpireSeries deletes the results_timeseries buckets from before before,
+// and the hours of results_calendar; the days are kept
+func (m *Mongo) ExpireSeries(before time.Time) (int, error) {
+	info, err := m.series().RemoveAll(bson.M{"bucket": bson.M{"$lt": before}})
+	if err != nil {
+		return 0, err
+	}
+	hours, err := m.calendar().RemoveAll(bson.M{"period": "hour", "start": bson.M{"$lt": before}})
+	if err != nil {
+		return info.Removed, err
+	}
+	return info.Removed + hours.Removed, nil
+}
+
+// CompactPoll keeps the poll's latest results_history document and deletes
+// the others, its results_timeseries buckets and its hours of results_calendar
+func (m *Mongo) CompactPoll(id string) error {
+	var last historyDoc
+	err := m.history().Find(bson.M{"poll_id": id}).Sort("-time").Select(bson.M{"_id": 1}).One(&last)
+	switch err {
+	case nil:
+		if _, err := m.history().RemoveAll(bson.M{"poll_id": id, "_id": bson.M{"$ne": last.ID}}); err != nil {
+			return err
+		}
+	case mgo.ErrNotFound:
+	default:
+		return err
+	}
+	if _, err = m.series().RemoveAll(bson.M{"poll": id}); err != nil {
+		return err
+	}
+	_, err = m.calendar().RemoveAll(bson.M{"poll": id, "period": "hour"})
+	return err
+}
+
+// snapshotDoc is a document in the snapshots collection
+type snapshotDoc struct {
+	Name    string    `bson:"_id"`
+	Data    []byte    `bson:"data"`
+	SavedAt time.Time `bson:"saved_at"`
+}
+
+// SaveSnapshot upserts a snapshot into the snapshots collection
+func (m *Mongo) SaveSnapshot(name string, data []byte) error {
+	_, err := m.session.DB(m.db).C("snapshots").UpsertId(name, snapshotDoc{Name: name, Data: data, SavedAt: time.Now()})
+	return err
+}
+
+// LoadSnapshot reads a snapshot from the snapshots collection
+func (m *Mongo) LoadSnapshot(name string) ([]byte, error) {
+	var d snapshotDoc
+	if err := m.session.DB(m.db).C("snapshots").FindId(name).One(&d); err != nil {
+		if err == mgo.ErrNotFound {
+			return nil, ErrNoSnapshot
+		}
+		return nil, err
+	}
+	return d.Data, nil
+}
+
+// Close disconnects from the database
+func (m *Mongo) Close() {
+	m.session.Close()
+	log.Println("closed database connection")
+}
@@ -0,0 +1,143 @@
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Where MongoDB keeps the polls unless configured otherwise
+const (
+	DefaultDatabase = "ballots"
+	DefaultPolls    = "polls"
+)
+
+// Namespace is a collection of a tenant's polls. Their options are tracked
+// with every other poll's, and the votes for them carry the tenant; the
+// tweets, results history and the rest stay in the main database.
+type Namespace struct {
+	Tenant     string
+	Database   string
+	Collection string
+}
+
+func (ns Namespace) String() string {
+	return ns.Tenant + "=" + ns.Database + "." + ns.Collection
+}
+
+// ParseTenants parses namespaces written tenant=database.collection and
+// separated by commas, the collection is DefaultPolls when left out
+func ParseTenants(s string) ([]Namespace, error) {
+	var spaces []Namespace
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("store: tenant %q isn't tenant=database.collection", part)
+		}
+		if !validTenant(part[:eq]) {
+			return nil, fmt.Errorf("store: tenant %q isn't 1 to 32 letters, digits, _ or -", part[:eq])
+		}
+		ns := Namespace{Tenant: part[:eq], Database: part[eq+1:], Collection: DefaultPolls}
+		if dot := strings.Index(ns.Database, "."); dot >= 0 {
+			ns.Database, ns.Collection = ns.Database[:dot], ns.Database[dot+1:]
+		}
+		if ns.Database == "" || ns.Collection == "" || strings.ContainsAny(ns.Database, `/\ "$`) || strings.Contains(ns.Collection, "$") {
+			return nil, fmt.Errorf("store: tenant %s has an invalid database or collection", ns.Tenant)
+		}
+		if seen[ns.Tenant] {
+			return nil, fmt.Errorf("store: tenant %s is listed twice", ns.Tenant)
+		}
+		seen[ns.Tenant] = true
+		spaces = append(spaces, ns)
+	}
+	return spaces, nil
+}
+
+// validTenant reports whether name is a valid tenant, one the NSQ topic
+// votes.<tenant> can be named after
+func validTenant(name string) bool {
+	if name == "" || len(name) > 32 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// newMongo sets up the store of the databases and collections opts names on session
+func newMongo(session *mgo.Session, opts DialOptions) *Mongo {
+	m := &Mongo{session: session, db: opts.Database, homes: map[bson.ObjectId]int{}}
+	if m.db == "" {
+		m.db = DefaultDatabase
+	}
+	polls := opts.Polls
+	if polls == "" {
+		polls = DefaultPolls
+	}
+	m.spaces = append([]Namespace{{Database: m.db, Collection: polls}}, opts.Tenants...)
+	return m
+}
+
+// Database is the name of the database everything but the tenants' polls is kept in
+func (m *Mongo) Database() string {
+	return m.db
+}
+
+func (m *Mongo) pollsIn(i int) *mgo.Collection {
+	ns := m.spaces[i]
+	return m.session.DB(ns.Database).C(ns.Collection)
+}
+
+// pollsOf returns the collection the poll id is in
+func (m *Mongo) pollsOf(id bson.ObjectId) *mgo.Collection {
+	return m.pollsIn(m.home(id))
+}
+
+// home returns the index in spaces of the collection the poll id is in,
+// looking for it when it hasn't been seen yet, 0 when it is nowhere
+func (m *Mongo) home(id bson.ObjectId) int {
+	if len(m.spaces) == 1 {
+		return 0
+	}
+	m.mu.Lock()
+	i, ok := m.homes[id]
+	m.mu.Unlock()
+	if ok {
+		return i
+	}
+	for i := range m.spaces {
+		if n, err := m.pollsIn(i).FindId(id).Count(); err == nil && n > 0 {
+			m.found(id, i)
+			return i
+		}
+	}
+	return 0
+}
+
+// found remembers the poll id is in spaces[i]
+func (m *Mongo) found(id bson.ObjectId, i int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.homes[id] = i
+}
+
+// tenant returns the index in spaces of the tenant's collection, the
+// database's own for no tenant
+func (m *Mongo) tenant(name string) (int, bool) {
+	for i, ns := range m.spaces {
+		if ns.Tenant == name {
+			return i, true
+		}
+	}
+	return 0, false
+}
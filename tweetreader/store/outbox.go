@@ -0,0 +1,123 @@
+package store
+
+import (
+	"strconv"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// OutboxStore is implemented by stores that can keep an outbox of vote
+// messages: the streamer adds each message to it instead of publishing it,
+// and a relay publishes them in the order they were added and removes them
+// once the broker has acknowledged them. A relay that dies in between
+// publishes them again, so every message is published at least once.
+type OutboxStore interface {
+	// AddToOutbox adds a message for topic, it is kept once it returns
+	AddToOutbox(topic string, b []byte) error
+	// PendingOutbox returns up to n messages, the oldest first
+	PendingOutbox(n int) ([]OutboxMessage, error)
+	// RemoveFromOutbox removes the messages with the ids, once published
+	RemoveFromOutbox(ids []string) error
+	// OutboxSize returns how many messages wait to be published
+	OutboxSize() (int, error)
+}
+
+// OutboxMessage is a message waiting in the outbox
+type OutboxMessage struct {
+	ID    string
+	Topic string
+	Body  []byte
+	Added time.Time
+}
+
+type outboxDoc struct {
+	ID    bson.ObjectId `bson:"_id"`
+	Topic string        `bson:"topic"`
+	Body  []byte        `bson:"body"`
+	Added time.Time     `bson:"added"`
+}
+
+// AddToOutbox inserts the message into the outbox collection, acknowledged by
+// the primary like every other write of the session
+func (m *Mongo) AddToOutbox(topic string, b []byte) error {
+	return m.session.DB(m.db).C("outbox").Insert(outboxDoc{ID: bson.NewObjectId(), Topic: topic, Body: b, Added: time.Now()})
+}
+
+// PendingOutbox returns the oldest messages of the outbox collection, by _id
+func (m *Mongo) PendingOutbox(n int) ([]OutboxMessage, error) {
+	var docs []outboxDoc
+	if err := m.session.DB(m.db).C("outbox").Find(nil).Sort("_id").Limit(n).All(&docs); err != nil {
+		return nil, err
+	}
+	msgs := make([]OutboxMessage, len(docs))
+	for i, d := range docs {
+		msgs[i] = OutboxMessage{ID: d.ID.Hex(), Topic: d.Topic, Body: d.Body, Added: d.Added}
+	}
+	return msgs, nil
+}
+
+// RemoveFromOutbox removes the messages from the outbox collection
+func (m *Mongo) RemoveFromOutbox(ids []string) error {
+	oids := make([]bson.ObjectId, 0, len(ids))
+	for _, id := range ids {
+		if bson.IsObjectIdHex(id) {
+			oids = append(oids, bson.ObjectIdHex(id))
+		}
+	}
+	if len(oids) == 0 {
+		return nil
+	}
+	_, err := m.session.DB(m.db).C("outbox").RemoveAll(bson.M{"_id": bson.M{"$in": oids}})
+	return err
+}
+
+// OutboxSize counts the documents of the outbox collection
+func (m *Mongo) OutboxSize() (int, error) {
+	return m.session.DB(m.db).C("outbox").Count()
+}
+
+// AddToOutbox appends the message to the outbox
+func (m *Memory) AddToOutbox(topic string, b []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.outboxID++
+	body := append([]byte(nil), b...)
+	m.outbox = append(m.outbox, OutboxMessage{ID: strconv.Itoa(m.outboxID), Topic: topic, Body: body, Added: time.Now()})
+	return nil
+}
+
+// PendingOutbox returns the first messages of the outbox
+func (m *Memory) PendingOutbox(n int) ([]OutboxMessage, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if n > len(m.outbox) {
+		n = len(m.outbox)
+	}
+	return append([]OutboxMessage(nil), m.outbox[:n]...), nil
+}
+
+// RemoveFromOutbox removes the messages from the outbox
+func (m *Memory) RemoveFromOutbox(ids []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	gone := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		gone[id] = true
+	}
+	kept := m.outbox[:0]
+	for _, msg := range m.outbox {
+		if !gone[msg.ID] {
+			kept = append(kept, msg)
+		}
+	}
+	m.outbox = kept
+	return nil
+}
+
+// OutboxSize returns how many messages the outbox has
+func (m *Memory) OutboxSize() (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.outbox), nil
+}
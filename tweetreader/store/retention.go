@@ -0,0 +1,20 @@
+package store
+
+import "time"
+
+// RetentionStore is implemented by stores that can drop what piles up besides
+// the polls: the counted tweets, the time series buckets and the history of
+// the polls that are over
+type RetentionStore interface {
+	// ExpireTweets deletes the counted tweets saved before before, oldest first
+	// in batches, and returns how many it deleted. When keep isn't nil each
+	// batch, a tweet per JSON document, is handed to it first and only deleted
+	// once keep returned nil.
+	ExpireTweets(before time.Time, keep func(docs [][]byte) error) (int, error)
+	// ExpireSeries deletes the time series buckets from before before and
+	// returns how many it deleted
+	ExpireSeries(before time.Time) (int, error)
+	// CompactPoll drops what the poll id keeps besides its results: its
+	// history but for the last entry, and its time series
+	CompactPoll(id string) error
+}
@@ -0,0 +1,50 @@
+package store
+
+import "sync"
+
+// PollsSnapshot is a PollStore whose Polls returns the polls as they were
+// read on the last options load, so the loaders wrapping that load, each
+// picking up something of the polls, share one read of them per refresh
+// rather than reading them again each. Its other methods go to the store.
+type PollsSnapshot struct {
+	PollStore
+
+	mu    sync.RWMutex
+	taken bool
+	polls []Poll
+	err   error
+}
+
+// NewPollsSnapshot creates a snapshot of the polls of s, taken by the loads Take wraps
+func NewPollsSnapshot(s PollStore) *PollsSnapshot {
+	return &PollsSnapshot{PollStore: s}
+}
+
+// Take wraps a function loading the options so every load also reads the
+// polls again, for the loaders wrapping it. A failure to read them is what
+// Polls returns until the next load, the options are returned all the same.
+func (s *PollsSnapshot) Take(load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		polls, err := s.PollStore.Polls()
+		s.mu.Lock()
+		s.taken, s.polls, s.err = true, polls, err
+		s.mu.Unlock()
+		return options, nil
+	}
+}
+
+// Polls returns the polls read on the last load, or reads them when none was
+// taken yet. They are shared by the callers, which must only read them.
+func (s *PollsSnapshot) Polls() ([]Poll, error) {
+	s.mu.RLock()
+	taken, polls, err := s.taken, s.polls, s.err
+	s.mu.RUnlock()
+	if !taken {
+		return s.PollStore.Polls()
+	}
+	return polls, err
+}
@@ -0,0 +1,80 @@
+package store
+
+import (
+	"errors"
+	"testing"
+)
+
+// countedPolls counts the reads of the polls, failing them with err
+type countedPolls struct {
+	PollStore
+	reads int
+	err   error
+}
+
+func (c *countedPolls) Polls() ([]Poll, error) {
+	c.reads++
+	if c.err != nil {
+		return nil, c.err
+	}
+	return c.PollStore.Polls()
+}
+
+func TestPollsSnapshot(t *testing.T) {
+	down := errors.New("no reachable servers")
+	tests := []struct {
+		name    string
+		loadErr error // of the options
+		readErr error // of the polls
+		loaders int   // wrapping the load, each reading the polls
+		reads   int
+		polls   int
+		err     error
+	}{
+		{"the loaders share one read", nil, nil, 15, 1, 1, nil},
+		{"a failed read is every loader's", nil, down, 3, 1, 0, down},
+		{"no read when the options can't be loaded", down, nil, 3, 0, 0, down},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := &countedPolls{PollStore: NewMemory(Poll{ID: "p", Options: []string{"a"}}), err: tt.readErr}
+			snapshot := NewPollsSnapshot(db)
+			var polls []Poll
+			var err error
+			load := snapshot.Take(func() ([]string, error) { return []string{"a"}, tt.loadErr })
+			for i := 0; i < tt.loaders; i++ {
+				inner := load
+				load = func() ([]string, error) {
+					options, loadErr := inner()
+					if loadErr != nil {
+						return nil, loadErr
+					}
+					polls, err = snapshot.Polls()
+					return options, nil
+				}
+			}
+			if _, loadErr := load(); loadErr != nil {
+				err = loadErr
+			}
+			if db.reads != tt.reads {
+				t.Errorf("read the polls %d times, want %d", db.reads, tt.reads)
+			}
+			if len(polls) != tt.polls || err != tt.err {
+				t.Errorf("loaders got %d polls and %v, want %d and %v", len(polls), err, tt.polls, tt.err)
+			}
+		})
+	}
+}
+
+func TestPollsSnapshotReadsBeforeTheFirstLoad(t *testing.T) {
+	db := &countedPolls{PollStore: NewMemory(Poll{ID: "p"})}
+	snapshot := NewPollsSnapshot(db)
+	for i := 1; i <= 2; i++ {
+		if polls, err := snapshot.Polls(); err != nil || len(polls) != 1 {
+			t.Fatalf("Polls = %v, %v", polls, err)
+		}
+		if db.reads != i {
+			t.Errorf("read %d times for %d calls, want every call read through", db.reads, i)
+		}
+	}
+}
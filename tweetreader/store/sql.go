@@ -0,0 +1,816 @@
+package store
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+)
+
+// SQL drivers aren't part of the default build, see drivers_*.go in the main package.
+
+// dialect covers the differences between the SQL databases we support
+type dialect struct {
+	driver string
+	// placeholder returns the bind parameter for the i'th argument, counting from 1
+	placeholder func(i int) string
+}
+
+var postgres = dialect{
+	driver:      "postgres",
+	placeholder: func(i int) string { return fmt.Sprintf("$%d", i) },
+}
+
+var sqlite = dialect{
+	driver:      "sqlite3",
+	placeholder: func(int) string { return "?" },
+}
+
+// migrations are applied in order, each exactly once.
+// Never edit a migration that has shipped, add a new one instead.
+// They are written in the SQL that PostgreSQL and SQLite (3.24 or later) both accept.
+var migrations = []string{
+	// 1: polls
+	`CREATE TABLE polls (
+		id               TEXT PRIMARY KEY,
+		title            TEXT NOT NULL,
+		options          TEXT NOT NULL,
+		status           TEXT NOT NULL DEFAULT 'active',
+		type             TEXT NOT NULL DEFAULT 'standard',
+		visibility       TEXT NOT NULL DEFAULT 'public',
+		detailed_metrics BOOLEAN NOT NULL DEFAULT FALSE
+	)`,
+	// 2: results
+	`CREATE TABLE results (
+		poll_id  TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		option   TEXT NOT NULL,
+		count    BIGINT NOT NULL DEFAULT 0,
+		weighted DOUBLE PRECISION NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, option)
+	)`,
+	// 3: snapshots
+	`CREATE TABLE snapshots (
+		name     TEXT PRIMARY KEY,
+		data     TEXT NOT NULL,
+		saved_at TIMESTAMP NOT NULL
+	)`,
+	// 4, 5: geo filtering and aggregation
+	`ALTER TABLE polls ADD COLUMN locations TEXT NOT NULL DEFAULT '[]'`,
+	`ALTER TABLE polls ADD COLUMN geo_aggregation TEXT NOT NULL DEFAULT ''`,
+	// 6: geo_results
+	`CREATE TABLE geo_results (
+		poll_id TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		area    TEXT NOT NULL,
+		option  TEXT NOT NULL,
+		count   BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, area, option)
+	)`,
+	// 7: metrics computed by aggregators
+	`CREATE TABLE metrics (
+		poll_id TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		metric  TEXT NOT NULL,
+		option  TEXT NOT NULL,
+		value   DOUBLE PRECISION NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, metric, option)
+	)`,
+	// 8: hashtag voting
+	`ALTER TABLE polls ADD COLUMN hashtag_only BOOLEAN NOT NULL DEFAULT FALSE`,
+	// 9: results_history, at is in unix seconds so both databases compare it the same way
+	`CREATE TABLE results_history (
+		poll_id  TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		at       BIGINT NOT NULL,
+		option   TEXT NOT NULL,
+		count    BIGINT NOT NULL DEFAULT 0,
+		weighted DOUBLE PRECISION NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, at, option)
+	)`,
+	// 10: chat notifications
+	`ALTER TABLE polls ADD COLUMN notifications TEXT NOT NULL DEFAULT '[]'`,
+	// 11: Twitter accounts
+	`ALTER TABLE polls ADD COLUMN account TEXT NOT NULL DEFAULT ''`,
+	// 12: leases coordinating streamers, expires is in unix milliseconds
+	`CREATE TABLE leases (
+		grp     TEXT NOT NULL,
+		member  TEXT NOT NULL,
+		expires BIGINT NOT NULL,
+		PRIMARY KEY (grp, member)
+	)`,
+	// 13: leader election
+	`CREATE TABLE leaders (
+		name    TEXT PRIMARY KEY,
+		holder  TEXT NOT NULL,
+		expires BIGINT NOT NULL
+	)`,
+	// 14: private polls
+	`ALTER TABLE polls ADD COLUMN private BOOLEAN NOT NULL DEFAULT FALSE`,
+	// 15: counting unique authors
+	`ALTER TABLE polls ADD COLUMN counting TEXT NOT NULL DEFAULT ''`,
+	// 16: leaving suspect votes out
+	`ALTER TABLE polls ADD COLUMN exclude_suspect BOOLEAN NOT NULL DEFAULT FALSE`,
+	// 17: sampled polls
+	`ALTER TABLE polls ADD COLUMN sample_every INTEGER NOT NULL DEFAULT 0`,
+	// 18-21: retweet, quote and reply policies
+	`ALTER TABLE polls ADD COLUMN exclude_retweets BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE polls ADD COLUMN exclude_quotes BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE polls ADD COLUMN exclude_replies BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE polls ADD COLUMN embedded_text TEXT NOT NULL DEFAULT ''`,
+	// 22: options matched strictly
+	`ALTER TABLE polls ADD COLUMN matching TEXT NOT NULL DEFAULT '[]'`,
+	// 23: embargoes, as JSON, 'null' for none
+	`ALTER TABLE polls ADD COLUMN embargo TEXT NOT NULL DEFAULT 'null'`,
+	// 24: counts per source
+	`CREATE TABLE source_results (
+		poll_id TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		source  TEXT NOT NULL,
+		option  TEXT NOT NULL,
+		count   BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, source, option)
+	)`,
+	// 25: counts per language
+	`CREATE TABLE language_results (
+		poll_id  TEXT NOT NULL REFERENCES polls (id) ON DELETE CASCADE,
+		language TEXT NOT NULL,
+		option   TEXT NOT NULL,
+		count    BIGINT NOT NULL DEFAULT 0,
+		PRIMARY KEY (poll_id, language, option)
+	)`,
+	// 26: options matched without their diacritics or transliterated
+	`ALTER TABLE polls ADD COLUMN folding TEXT NOT NULL DEFAULT ''`,
+	// 27: signed certificates of the closed polls' results
+	`CREATE TABLE certificates (
+		poll_id     TEXT PRIMARY KEY REFERENCES polls (id) ON DELETE CASCADE,
+		certificate TEXT NOT NULL,
+		certified   TIMESTAMP NOT NULL
+	)`,
+	// 28: low priority polls hibernating while idle
+	`ALTER TABLE polls ADD COLUMN priority TEXT NOT NULL DEFAULT ''`,
+	// 29: filter expressions of the polls
+	`ALTER TABLE polls ADD COLUMN filter_expression TEXT NOT NULL DEFAULT ''`,
+	// 30: caps on the votes of the polls' options a minute
+	`ALTER TABLE polls ADD COLUMN max_per_minute INTEGER NOT NULL DEFAULT 0`,
+	// 31: time zones the polls' results are bucketed in
+	`ALTER TABLE polls ADD COLUMN timezone TEXT NOT NULL DEFAULT ''`,
+	// 32: campaigns of the polls
+	`ALTER TABLE polls ADD COLUMN campaign TEXT NOT NULL DEFAULT ''`,
+	// 33: polls enriching their votes with their authors' profiles
+	`ALTER TABLE polls ADD COLUMN enrich_authors BOOLEAN NOT NULL DEFAULT FALSE`,
+	// 34: routes of the votes for the options polls share
+	`ALTER TABLE polls ADD COLUMN route_expression TEXT NOT NULL DEFAULT ''`,
+}
+
+// SQL keeps polls and results in a relational database
+type SQL struct {
+	db      *sql.DB
+	dialect dialect
+}
+
+// OpenPostgres connects to PostgreSQL at dsn, retrying with the backoff of
+// opts like DialMongo, and applies any pending migrations
+func OpenPostgres(dsn string, opts DialOptions) (*SQL, error) {
+	db, err := sql.Open(postgres.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(opts.Timeout)
+	backoff := opts.Backoff
+	for attempt := 1; ; attempt++ {
+		log.Printf("connecting to postgres (attempt %d)", attempt)
+		err := db.Ping()
+		if err == nil {
+			break
+		}
+		if time.Now().Add(backoff).After(deadline) {
+			db.Close()
+			return nil, err
+		}
+		log.Printf("failed to connect to postgres: %s, retrying in %s", err, backoff)
+		time.Sleep(backoff)
+		if backoff *= 2; backoff > opts.MaxBackoff {
+			backoff = opts.MaxBackoff
+		}
+	}
+	return newSQL(db, postgres)
+}
+
+// OpenSQLite opens, or creates, the SQLite database at path and applies any pending migrations
+func OpenSQLite(path string) (*SQL, error) {
+	s, err := openSQL(sqlite, path)
+	if err != nil {
+		return nil, err
+	}
+	// SQLite allows one writer at a time, the counter and the commands don't need more
+	s.db.SetMaxOpenConns(1)
+	return s, nil
+}
+
+func openSQL(d dialect, dsn string) (*SQL, error) {
+	db, err := sql.Open(d.driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	return newSQL(db, d)
+}
+
+// newSQL migrates db, closing it when that fails
+func newSQL(db *sql.DB, d dialect) (*SQL, error) {
+	s := &SQL{db: db, dialect: d}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("migrating %s: %v", d.driver, err)
+	}
+	return s, nil
+}
+
+// migrate applies the migrations the database hasn't seen yet
+func (s *SQL) migrate() error {
+	if _, err := s.db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (version INTEGER PRIMARY KEY)`); err != nil {
+		return err
+	}
+	var current int
+	if err := s.db.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&current); err != nil {
+		return err
+	}
+	for i := current; i < len(migrations); i++ {
+		version := i + 1
+		tx, err := s.db.Begin()
+		if err != nil {
+			return err
+		}
+		if _, err := tx.Exec(migrations[i]); err != nil {
+			tx.Rollback()
+			return fmt.Errorf("migration %d: %v", version, err)
+		}
+		if _, err := tx.Exec(s.q(`INSERT INTO schema_migrations (version) VALUES (?)`), version); err != nil {
+			tx.Rollback()
+			return err
+		}
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		log.Printf("applied %s migration %d", s.dialect.driver, version)
+	}
+	return nil
+}
+
+// q rewrites ? placeholders into the dialect's bind parameters
+func (s *SQL) q(query string) string {
+	var b strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			b.WriteString(s.dialect.placeholder(n))
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// LoadOptions returns the options of every active poll
+func (s *SQL) LoadOptions() ([]string, error) {
+	polls, err := s.Polls()
+	if err != nil {
+		return nil, err
+	}
+	var options []string
+	for _, p := range polls {
+		if p.Tracked() {
+			options = append(options, p.Options...)
+		}
+	}
+	return options, nil
+}
+
+const pollColumns = `id, title, options, status, type, visibility, detailed_metrics, locations, geo_aggregation, hashtag_only, notifications, account, private, counting, exclude_suspect, sample_every, exclude_retweets, exclude_quotes, exclude_replies, embedded_text, matching, embargo, folding, priority, filter_expression, max_per_minute, timezone, campaign, enrich_authors, route_expression`
+
+func scanPoll(row interface{ Scan(...interface{}) error }) (Poll, error) {
+	var (
+		p                                                    Poll
+		options, locations, notifications, matching, embargo string
+	)
+	if err := row.Scan(&p.ID, &p.Title, &options, &p.Status, &p.Type, &p.Visibility, &p.DetailedMetrics, &locations, &p.GeoAggregation, &p.HashtagOnly, &notifications, &p.Account, &p.Private, &p.Counting, &p.ExcludeSuspect, &p.SampleEvery, &p.ExcludeRetweets, &p.ExcludeQuotes, &p.ExcludeReplies, &p.EmbeddedText, &matching, &embargo, &p.Folding, &p.Priority, &p.Filter, &p.MaxPerMinute, &p.Timezone, &p.Campaign, &p.EnrichAuthors, &p.Route); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(options), &p.Options); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(locations), &p.Locations); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(notifications), &p.Notifications); err != nil {
+		return p, err
+	}
+	if err := json.Unmarshal([]byte(matching), &p.Matching); err != nil {
+		return p, err
+	}
+	err := json.Unmarshal([]byte(embargo), &p.Embargo)
+	return p, err
+}
+
+// Polls returns every poll with its results
+func (s *SQL) Polls() ([]Poll, error) {
+	rows, err := s.db.Query(`SELECT ` + pollColumns + ` FROM polls ORDER BY id`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var polls []Poll
+	for rows.Next() {
+		p, err := scanPoll(rows)
+		if err != nil {
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	for i := range polls {
+		if err := s.loadResults(&polls[i]); err != nil {
+			return nil, err
+		}
+	}
+	return polls, nil
+}
+
+// Poll returns a single poll with its results
+func (s *SQL) Poll(id string) (Poll, error) {
+	p, err := scanPoll(s.db.QueryRow(s.q(`SELECT `+pollColumns+` FROM polls WHERE id = ?`), id))
+	if err == sql.ErrNoRows {
+		return Poll{}, ErrNotFound
+	}
+	if err != nil {
+		return Poll{}, err
+	}
+	return p, s.loadResults(&p)
+}
+
+// loadResults fills in the results, source_results, language_results and metrics of a poll, and its geo_results when it aggregates by area
+func (s *SQL) loadResults(p *Poll) error {
+	if err := s.loadMetrics(p); err != nil {
+		return err
+	}
+	if err := s.loadSourceResults(p); err != nil {
+		return err
+	}
+	if err := s.loadLanguageResults(p); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(s.q(`SELECT option, count, weighted FROM results WHERE poll_id = ?`), p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			option   string
+			count    int
+			weighted float64
+		)
+		if err := rows.Scan(&option, &count, &weighted); err != nil {
+			return err
+		}
+		if p.Results == nil {
+			p.Results = make(map[string]int)
+		}
+		p.Results[option] = count
+		if p.Weighted() {
+			if p.WeightedResults == nil {
+				p.WeightedResults = make(map[string]float64)
+			}
+			p.WeightedResults[option] = weighted
+		}
+	}
+	if err := rows.Err(); err != nil || p.GeoAggregation == "" {
+		return err
+	}
+	geo, err := s.db.Query(s.q(`SELECT area, option, count FROM geo_results WHERE poll_id = ?`), p.ID)
+	if err != nil {
+		return err
+	}
+	defer geo.Close()
+	for geo.Next() {
+		var (
+			area, option string
+			count        int
+		)
+		if err := geo.Scan(&area, &option, &count); err != nil {
+			return err
+		}
+		if p.GeoResults == nil {
+			p.GeoResults = make(map[string]map[string]int)
+		}
+		if p.GeoResults[area] == nil {
+			p.GeoResults[area] = make(map[string]int)
+		}
+		p.GeoResults[area][option] = count
+	}
+	return geo.Err()
+}
+
+// loadSourceResults fills in the counts per source of a poll
+func (s *SQL) loadSourceResults(p *Poll) error {
+	rows, err := s.db.Query(s.q(`SELECT source, option, count FROM source_results WHERE poll_id = ?`), p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			source, option string
+			count          int
+		)
+		if err := rows.Scan(&source, &option, &count); err != nil {
+			return err
+		}
+		if p.SourceResults == nil {
+			p.SourceResults = make(map[string]map[string]int)
+		}
+		if p.SourceResults[source] == nil {
+			p.SourceResults[source] = make(map[string]int)
+		}
+		p.SourceResults[source][option] = count
+	}
+	return rows.Err()
+}
+
+// loadLanguageResults fills in the counts per language of a poll
+func (s *SQL) loadLanguageResults(p *Poll) error {
+	rows, err := s.db.Query(s.q(`SELECT language, option, count FROM language_results WHERE poll_id = ?`), p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			language, option string
+			count            int
+		)
+		if err := rows.Scan(&language, &option, &count); err != nil {
+			return err
+		}
+		if p.LanguageResults == nil {
+			p.LanguageResults = make(map[string]map[string]int)
+		}
+		if p.LanguageResults[language] == nil {
+			p.LanguageResults[language] = make(map[string]int)
+		}
+		p.LanguageResults[language][option] = count
+	}
+	return rows.Err()
+}
+
+// loadMetrics fills in the metrics computed for a poll
+func (s *SQL) loadMetrics(p *Poll) error {
+	rows, err := s.db.Query(s.q(`SELECT metric, option, value FROM metrics WHERE poll_id = ?`), p.ID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var (
+			metric, option string
+			value          float64
+		)
+		if err := rows.Scan(&metric, &option, &value); err != nil {
+			return err
+		}
+		if p.Metrics == nil {
+			p.Metrics = make(map[string]map[string]float64)
+		}
+		if p.Metrics[metric] == nil {
+			p.Metrics[metric] = make(map[string]float64)
+		}
+		p.Metrics[metric][option] = value
+	}
+	return rows.Err()
+}
+
+// CreatePoll inserts a poll, giving it an ID shaped like a MongoDB ObjectId
+// so clients don't need to care which backend they talk to
+func (s *SQL) CreatePoll(p *Poll) error {
+	id := make([]byte, 12)
+	if _, err := rand.Read(id); err != nil {
+		return err
+	}
+	options, err := json.Marshal(p.Options)
+	if err != nil {
+		return err
+	}
+	locations := []byte("[]")
+	if len(p.Locations) > 0 {
+		if locations, err = json.Marshal(p.Locations); err != nil {
+			return err
+		}
+	}
+	notifications := []byte("[]")
+	if len(p.Notifications) > 0 {
+		if notifications, err = json.Marshal(p.Notifications); err != nil {
+			return err
+		}
+	}
+	matching := []byte("[]")
+	if len(p.Matching) > 0 {
+		if matching, err = json.Marshal(p.Matching); err != nil {
+			return err
+		}
+	}
+	embargo, err := json.Marshal(p.Embargo)
+	if err != nil {
+		return err
+	}
+	if p.Status == "" {
+		p.Status = "active"
+	}
+	if p.Type == "" {
+		p.Type = "standard"
+	}
+	if p.Visibility == "" {
+		p.Visibility = "public"
+	}
+	p.ID = hex.EncodeToString(id)
+	_, err = s.db.Exec(s.q(`INSERT INTO polls (`+pollColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`),
+		p.ID, p.Title, string(options), p.Status, p.Type, p.Visibility, p.DetailedMetrics, string(locations), p.GeoAggregation, p.HashtagOnly, string(notifications), p.Account, p.Private, p.Counting, p.ExcludeSuspect, p.SampleEvery,
+		p.ExcludeRetweets, p.ExcludeQuotes, p.ExcludeReplies, p.EmbeddedText, string(matching), string(embargo), p.Folding, p.Priority, p.Filter, p.MaxPerMinute, p.Timezone, p.Campaign, p.EnrichAuthors, p.Route)
+	return err
+}
+
+// SetPollStatus changes the poll's status if it still is from
+func (s *SQL) SetPollStatus(id, from, to string) error {
+	res, err := s.db.Exec(s.q(`UPDATE polls SET status = ? WHERE id = ? AND status = ?`), to, id, from)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		if _, err := s.Poll(id); err != nil {
+			return err
+		}
+		return ErrStatusChanged
+	}
+	return nil
+}
+
+// DeletePoll removes a poll and its results in one transaction. The rows
+// referencing the poll are deleted first, SQLite doesn't cascade.
+func (s *SQL) DeletePoll(id string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	for _, table := range []string{"results", "geo_results", "source_results", "language_results", "metrics", "results_history", "certificates"} {
+		if _, err := tx.Exec(s.q(`DELETE FROM `+table+` WHERE poll_id = ?`), id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	res, err := tx.Exec(s.q(`DELETE FROM polls WHERE id = ?`), id)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		tx.Rollback()
+		return ErrNotFound
+	}
+	return tx.Commit()
+}
+
+// AddResults upserts the counts for each option in one transaction
+func (s *SQL) AddResults(pollID string, counts map[string]int, weighted map[string]float64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	upsert := s.q(`INSERT INTO results (poll_id, option, count, weighted) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, option) DO UPDATE
+		SET count = results.count + excluded.count, weighted = results.weighted + excluded.weighted`)
+	for option, n := range counts {
+		if _, err := tx.Exec(upsert, pollID, option, n, weighted[option]); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// AddGeoResults upserts the counts for each area and option in one transaction
+func (s *SQL) AddGeoResults(pollID string, counts map[string]map[string]int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	upsert := s.q(`INSERT INTO geo_results (poll_id, area, option, count) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, area, option) DO UPDATE SET count = geo_results.count + excluded.count`)
+	for area, options := range counts {
+		for option, n := range options {
+			if _, err := tx.Exec(upsert, pollID, area, option, n); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// AddSourceResults upserts the counts for each source and option in one transaction
+func (s *SQL) AddSourceResults(pollID string, counts map[string]map[string]int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	upsert := s.q(`INSERT INTO source_results (poll_id, source, option, count) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, source, option) DO UPDATE SET count = source_results.count + excluded.count`)
+	for source, options := range counts {
+		for option, n := range options {
+			if _, err := tx.Exec(upsert, pollID, source, option, n); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// AddLanguageResults upserts the counts for each language and option in one transaction
+func (s *SQL) AddLanguageResults(pollID string, counts map[string]map[string]int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	upsert := s.q(`INSERT INTO language_results (poll_id, language, option, count) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, language, option) DO UPDATE SET count = language_results.count + excluded.count`)
+	for language, options := range counts {
+		for option, n := range options {
+			if _, err := tx.Exec(upsert, pollID, language, option, n); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// AddMetrics upserts the metric values for each option in one transaction
+func (s *SQL) AddMetrics(pollID string, metrics map[string]map[string]float64) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	upsert := s.q(`INSERT INTO metrics (poll_id, metric, option, value) VALUES (?, ?, ?, ?)
+		ON CONFLICT (poll_id, metric, option) DO UPDATE SET value = metrics.value + excluded.value`)
+	for metric, options := range metrics {
+		for option, v := range options {
+			if _, err := tx.Exec(upsert, pollID, metric, option, v); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// SaveHistory inserts a row per poll and option in one transaction
+func (s *SQL) SaveHistory(entries []HistoryEntry) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	insert := s.q(`INSERT INTO results_history (poll_id, at, option, count, weighted) VALUES (?, ?, ?, ?, ?)
+		ON CONFLICT (poll_id, at, option) DO UPDATE SET count = excluded.count, weighted = excluded.weighted`)
+	for _, e := range entries {
+		for option, n := range e.Results {
+			if _, err := tx.Exec(insert, e.Poll, e.Time.Unix(), option, n, e.WeightedResults[option]); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// CompactHistory deletes the expired rows, then thins the old ones poll by poll
+func (s *SQL) CompactHistory(deleteBefore, thinBefore time.Time, every time.Duration) error {
+	if _, err := s.db.Exec(s.q(`DELETE FROM results_history WHERE at < ?`), deleteBefore.Unix()); err != nil {
+		return err
+	}
+	rows, err := s.db.Query(s.q(`SELECT DISTINCT poll_id, at FROM results_history WHERE at < ?`), thinBefore.Unix())
+	if err != nil {
+		return err
+	}
+	times := make(map[string][]time.Time)
+	for rows.Next() {
+		var poll string
+		var at int64
+		if err := rows.Scan(&poll, &at); err != nil {
+			rows.Close()
+			return err
+		}
+		times[poll] = append(times[poll], time.Unix(at, 0))
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	del := s.q(`DELETE FROM results_history WHERE poll_id = ? AND at = ?`)
+	for poll, ts := range times {
+		for _, t := range thin(ts, every) {
+			if _, err := tx.Exec(del, poll, t.Unix()); err != nil {
+				tx.Rollback()
+				return err
+			}
+		}
+	}
+	return tx.Commit()
+}
+
+// History reads the rows of poll in the time range, an entry per time, oldest first
+func (s *SQL) History(poll string, from, to time.Time) ([]HistoryEntry, error) {
+	query := `SELECT at, option, count, weighted FROM results_history WHERE poll_id = ?`
+	args := []interface{}{poll}
+	if !from.IsZero() {
+		query += ` AND at >= ?`
+		args = append(args, from.Unix())
+	}
+	if !to.IsZero() {
+		query += ` AND at < ?`
+		args = append(args, to.Unix())
+	}
+	rows, err := s.db.Query(s.q(query+` ORDER BY at, option`), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var entries []HistoryEntry
+	for rows.Next() {
+		var (
+			at       int64
+			option   string
+			count    int
+			weighted float64
+		)
+		if err := rows.Scan(&at, &option, &count, &weighted); err != nil {
+			return nil, err
+		}
+		if n := len(entries); n == 0 || entries[n-1].Time.Unix() != at {
+			entries = append(entries, HistoryEntry{Poll: poll, Time: time.Unix(at, 0), Results: make(map[string]int)})
+		}
+		e := &entries[len(entries)-1]
+		e.Results[option] = count
+		if weighted != 0 {
+			if e.WeightedResults == nil {
+				e.WeightedResults = make(map[string]float64)
+			}
+			e.WeightedResults[option] = weighted
+		}
+	}
+	return entries, rows.Err()
+}
+
+// ExpireTweets deletes nothing, SQL stores don't keep the counted tweets
+func (s *SQL) ExpireTweets(before time.Time, keep func(docs [][]byte) error) (int, error) {
+	return 0, nil
+}
+
+// ExpireSeries deletes nothing, the time series of SQL stores is kept by TimescaleDB, if at all
+func (s *SQL) ExpireSeries(before time.Time) (int, error) {
+	return 0, nil
+}
+
+// CompactPoll deletes the poll's results_history rows but for the latest time
+func (s *SQL) CompactPoll(id string) error {
+	_, err := s.db.Exec(s.q(`DELETE FROM results_history WHERE poll_id = ? AND at < (SELECT MAX(at) FROM results_history WHERE poll_id = ?)`), id, id)
+	return err
+}
+
+// SaveSnapshot upserts a snapshot, data is expected to be text such as JSON
+func (s *SQL) SaveSnapshot(name string, data []byte) error {
+	_, err := s.db.Exec(s.q(`INSERT INTO snapshots (name, data, saved_at) VALUES (?, ?, ?)
+		ON CONFLICT (name) DO UPDATE SET data = excluded.data, saved_at = excluded.saved_at`),
+		name, string(data), time.Now())
+	return err
+}
+
+// LoadSnapshot reads a snapshot saved with SaveSnapshot
+func (s *SQL) LoadSnapshot(name string) ([]byte, error) {
+	var data string
+	err := s.db.QueryRow(s.q(`SELECT data FROM snapshots WHERE name = ?`), name).Scan(&data)
+	if err == sql.ErrNoRows {
+		return nil, ErrNoSnapshot
+	}
+	return []byte(data), err
+}
+
+// Close closes the connection pool
+func (s *SQL) Close() {
+	s.db.Close()
+	log.Printf("closed %s connection", s.dialect.driver)
+}
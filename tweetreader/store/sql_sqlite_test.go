@@ -0,0 +1,59 @@
+//go:build sqlite
+// +build sqlite
+
+package store
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+func TestSQLDeletePoll(t *testing.T) {
+	s, err := OpenSQLite(filepath.Join(t.TempDir(), "polls.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	p := Poll{Title: "pets", Options: []string{"cats", "dogs"}}
+	if err := s.CreatePoll(&p); err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]map[string]int{"GB": {"cats": 2}}
+	for _, add := range []func() error{
+		func() error { return s.AddResults(p.ID, map[string]int{"cats": 2}, nil) },
+		func() error { return s.AddGeoResults(p.ID, counts) },
+		func() error { return s.AddSourceResults(p.ID, counts) },
+		func() error { return s.AddLanguageResults(p.ID, counts) },
+		func() error { return s.AddMetrics(p.ID, map[string]map[string]float64{"retracted": {"cats": 1}}) },
+		func() error {
+			return s.SaveHistory([]HistoryEntry{{Poll: p.ID, Time: time.Now(), Results: map[string]int{"cats": 2}}})
+		},
+		func() error {
+			_, err := s.SaveCertificate(p.ID, []byte("{}"))
+			return err
+		},
+	} {
+		if err := add(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := s.DeletePoll(p.ID); err != nil {
+		t.Fatalf("DeletePoll = %v", err)
+	}
+	for _, table := range []string{"polls", "results", "geo_results", "source_results", "language_results", "metrics", "results_history", "certificates"} {
+		var n int
+		if err := s.db.QueryRow(`SELECT COUNT(*) FROM ` + table).Scan(&n); err != nil {
+			t.Fatal(err)
+		}
+		if n != 0 {
+			t.Errorf("%d rows left in %s", n, table)
+		}
+	}
+	if err := s.DeletePoll(p.ID); err != ErrNotFound {
+		t.Errorf("DeletePoll of a deleted poll = %v, want ErrNotFound", err)
+	}
+}
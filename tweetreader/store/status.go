@@ -0,0 +1,104 @@
+package store
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Poll statuses. A draft is being set up and gets no votes, an active poll
+// is streamed and counted, a paused one isn't streamed but still counts the
+// votes already on their way, and closed and archived polls count nothing.
+// A poll without a status is active.
+const (
+	StatusDraft    = "draft"
+	StatusActive   = "active"
+	StatusPaused   = "paused"
+	StatusClosed   = "closed"
+	StatusArchived = "archived"
+)
+
+// StatusDeleted is the State of a poll deleted through the API, kept with its
+// results but neither streamed nor counted, whatever its status. It is no
+// status a poll can be given.
+const StatusDeleted = "deleted"
+
+// transitions are the statuses each status can change to
+var transitions = map[string][]string{
+	StatusDraft:    {StatusActive, StatusClosed},
+	StatusActive:   {StatusPaused, StatusClosed},
+	StatusPaused:   {StatusActive, StatusClosed},
+	StatusClosed:   {StatusArchived},
+	StatusArchived: nil,
+}
+
+// ErrStatusChanged is returned when a poll's status changed while it was being changed
+var ErrStatusChanged = errors.New("the poll's status changed meanwhile, try again")
+
+// TransitionError is returned for a change of status the poll's status doesn't allow
+type TransitionError struct {
+	From, To string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("a %s poll can't become %s", e.From, e.To)
+}
+
+// ValidStatus reports whether status is one of the poll statuses
+func ValidStatus(status string) bool {
+	_, ok := transitions[status]
+	return ok
+}
+
+// CanTransition returns an error unless a poll can go from status from to to
+func CanTransition(from, to string) error {
+	if from == "" {
+		from = StatusActive
+	}
+	if !ValidStatus(to) {
+		return fmt.Errorf("unknown status %q, want draft, active, paused, closed or archived", to)
+	}
+	for _, next := range transitions[from] {
+		if next == to {
+			return nil
+		}
+	}
+	return &TransitionError{From: from, To: to}
+}
+
+// State returns the poll's status, active when it has none, or StatusDeleted once deleted
+func (p *Poll) State() string {
+	if p.DeletedAt != nil {
+		return StatusDeleted
+	}
+	if p.Status == "" {
+		return StatusActive
+	}
+	return p.Status
+}
+
+// Tracked reports whether the streamers track the poll's options
+func (p *Poll) Tracked() bool {
+	return p.State() == StatusActive
+}
+
+// AcceptsVotes reports whether the poll counts the votes for its options
+func (p *Poll) AcceptsVotes() bool {
+	s := p.State()
+	return s == StatusActive || s == StatusPaused
+}
+
+// Transition moves the poll id to status to, if its current status allows
+// it, and returns the poll as it was
+func Transition(s PollStore, id, to string) (Poll, error) {
+	p, err := s.Poll(id)
+	if err != nil {
+		return Poll{}, err
+	}
+	if err := CanTransition(p.State(), to); err != nil {
+		return Poll{}, err
+	}
+	if err := s.SetPollStatus(id, p.Status, to); err != nil {
+		return Poll{}, err
+	}
+	return p, nil
+}
@@ -0,0 +1,306 @@
+// Package store persists polls and their results.
+// MongoDB is the default backend; PostgreSQL is available for teams that don't run MongoDB,
+// and SQLite for running everything on a laptop.
+package store
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// ErrNotFound is returned when a poll doesn't exist
+var ErrNotFound = errors.New("poll not found")
+
+// ErrNoSnapshot is returned when nothing was saved under a snapshot name
+var ErrNoSnapshot = errors.New("snapshot not found")
+
+// Store is where poll options are loaded from
+type Store interface {
+	// LoadOptions returns the options of every tracked poll, the active ones
+	LoadOptions() ([]string, error)
+	// Close releases the connection to the store
+	Close()
+}
+
+// Poll is a poll document as stored in the polls collection
+type Poll struct {
+	ID              string             `json:"id"`
+	Title           string             `json:"title"`
+	Options         []string           `json:"options"`
+	Results         map[string]int     `json:"results,omitempty"`
+	WeightedResults map[string]float64 `json:"weighted_results,omitempty"`
+	Status          string             `json:"status,omitempty"`
+	Type            string             `json:"type,omitempty"`
+	Visibility      string             `json:"visibility,omitempty"`
+	DetailedMetrics bool               `json:"detailed_metrics,omitempty"`
+	// Locations limits the poll to votes from inside these areas, any vote counts when empty
+	Locations []stream.BoundingBox `json:"locations,omitempty"`
+	// GeoAggregation additionally tallies the votes per country or region
+	GeoAggregation string                    `json:"geo_aggregation,omitempty"`
+	GeoResults     map[string]map[string]int `json:"geo_results,omitempty"`
+	// HashtagOnly only counts tweets that have an option as a hashtag, not just in their text
+	HashtagOnly bool `json:"hashtag_only,omitempty"`
+	// Metrics holds the custom results computed for the poll's type, per metric and option,
+	// MetricUndelivered, MetricRetracted, MetricOverflow, MetricCorrected and the MetricVersion ones
+	Metrics map[string]map[string]float64 `json:"metrics,omitempty"`
+	// SourceResults holds the raw counts per source the votes were cast on
+	// (twitter, youtube, sms...) and option, next to the totals in Results
+	SourceResults map[string]map[string]int `json:"source_results,omitempty"`
+	// LanguageResults holds the raw counts per language of the votes, "und"
+	// when it couldn't be told, and option
+	LanguageResults map[string]map[string]int `json:"language_results,omitempty"`
+	// Notifications are the chat channels told about the poll's milestones and progress
+	Notifications []Notification `json:"notifications,omitempty"`
+	// Account names the Twitter credentials the poll's options are streamed with, the default ones when empty
+	Account string `json:"account,omitempty"`
+	// Private hashes the authors of the poll's votes and drops their text before they are published
+	Private bool `json:"private,omitempty"`
+	// Counting is what the results count, CountMentions when empty
+	Counting string `json:"counting,omitempty"`
+	// ExcludeSuspect leaves the votes cast during a spike for their option out of the results
+	ExcludeSuspect bool `json:"exclude_suspect,omitempty"`
+	// SampleEvery has the streamers publish 1 in SampleEvery of the votes for the
+	// poll's options, which the counter scales back up; every vote when 0 or 1
+	SampleEvery int `json:"sample_every,omitempty"`
+	// ExcludeRetweets, ExcludeQuotes and ExcludeReplies leave the votes cast by
+	// retweets, quote tweets or replies out of the results
+	ExcludeRetweets bool `json:"exclude_retweets,omitempty"`
+	ExcludeQuotes   bool `json:"exclude_quotes,omitempty"`
+	ExcludeReplies  bool `json:"exclude_replies,omitempty"`
+	// EmbeddedText is whether the text of the tweets retweeted or quoted is
+	// scanned for the poll's options, as the streamers are set up when empty
+	EmbeddedText string `json:"embedded_text,omitempty"`
+	// Matching makes some options count more strictly than ignoring case,
+	// anywhere in the text, or also in the other forms of their words
+	Matching []OptionMatching `json:"matching,omitempty"`
+	// Folding also counts the options written without their diacritics, or
+	// in another alphabet, see FoldDiacritics; only as written when empty
+	Folding string `json:"folding,omitempty"`
+	// Priority is PriorityLow for a poll whose options may leave the stream's
+	// filter while they are idle, see the hibernate package, and PriorityHigh
+	// for one whose options keep their place in it when there are too many to
+	// track, see the tiers package; normal when empty
+	Priority string `json:"priority,omitempty"`
+	// Embargo holds the poll's votes back until a time or during quiet hours, they are counted once it lifts
+	Embargo *Embargo `json:"embargo,omitempty"`
+	// Filter is an expression the tweets of the poll's votes must meet, see
+	// the expr package: contains("go") AND NOT contains("pokemon go")
+	Filter string `json:"filter,omitempty"`
+	// Route is an expression, in the filter's language, the tweets must meet
+	// for the votes for the poll's options that another tracked poll also has
+	// to count for it; without one the poll counts them all, as the other
+	// polls sharing the option do, see expr.Filters
+	Route string `json:"route,omitempty"`
+	// MaxPerMinute caps the votes each option counts a minute, the votes
+	// over it are added up in Metrics as MetricOverflow instead; no cap when 0
+	MaxPerMinute int `json:"max_per_minute,omitempty"`
+	// Timezone is the IANA name of the time zone the poll's results are
+	// bucketed by hour and day in, e.g. Europe/Berlin; UTC when empty
+	Timezone string `json:"timezone,omitempty"`
+	// Campaign is the ID of the Campaign the poll is part of, if any
+	Campaign string `json:"campaign,omitempty"`
+	// EnrichAuthors looks up the handle, followers and verified badge of the
+	// authors of the poll's votes whose tweets came without them, for the
+	// weighting and the filter, see the enrich package
+	EnrichAuthors bool `json:"enrich_authors,omitempty"`
+	// Version is the version of the poll's options, bumped by the API every
+	// time they are edited, see OptionsVersion
+	Version int `json:"version,omitempty"`
+	// Versions are the poll's options since they were first edited, oldest first
+	Versions []PollVersion `json:"versions,omitempty"`
+	// DeletedAt is when the poll was deleted through the API, which keeps it
+	// and its results, see StatusDeleted
+	DeletedAt *time.Time `json:"deleted_at,omitempty"`
+	// Corrections are the IDs of the corrections applied to the poll's
+	// results, see CorrectionStore
+	Corrections []string `json:"corrections,omitempty"`
+	// Tenant is whose poll it is, on MongoDB the tenant of the collection it
+	// is kept in, see Namespace; it isn't stored with the poll
+	Tenant string `json:"tenant,omitempty"`
+}
+
+// MetricUndelivered is the metric estimating the votes for each option that
+// Twitter didn't deliver, over its stream limits, so the results undercount
+const MetricUndelivered = "undelivered"
+
+// MetricOverflow is the metric counting the votes for each option over the
+// poll's MaxPerMinute, not in the results
+const MetricOverflow = "overflow"
+
+// MetricRetracted is the metric counting the votes for each option taken back
+// out of the results because Twitter asked to stop showing their tweets, see
+// the retract package
+const MetricRetracted = "retracted"
+
+// OptionMatching is how strictly an option of a poll is matched
+type OptionMatching struct {
+	Option string `json:"option" bson:"option"`
+	// CaseSensitive only counts the option written in the same case, "iOS" but not "ios"
+	CaseSensitive bool `json:"case_sensitive,omitempty" bson:"case_sensitive,omitempty"`
+	// Exact only counts the option as whole words, not inside longer ones
+	Exact bool `json:"exact,omitempty" bson:"exact,omitempty"`
+	// Fuzziness also counts the other forms of the option's words, see
+	// FuzzyPlural; only as written when empty
+	Fuzziness string `json:"fuzziness,omitempty" bson:"fuzziness,omitempty"`
+	// Language is the language the words are stemmed in, English when empty, see stem.Languages
+	Language string `json:"language,omitempty" bson:"language,omitempty"`
+}
+
+// Fuzziness levels, see match.Vote.Stemmed
+const (
+	// FuzzyPlural also counts the option's words in the singular or the plural, "votes" for "vote"
+	FuzzyPlural = "plural"
+	// FuzzyStem also counts the other forms of the option's words, "voted" and "voting" for "vote"
+	FuzzyStem = "stem"
+)
+
+// Stems reports whether the option counts the votes that only had it in
+// their text stemmed at level, the ones found as written always count
+func (m OptionMatching) Stems(level string) bool {
+	switch level {
+	case "":
+		return true
+	case FuzzyPlural:
+		return m.Fuzziness == FuzzyPlural || m.Fuzziness == FuzzyStem
+	}
+	return m.Fuzziness == level
+}
+
+// Notification is a chat channel a poll posts to through an incoming webhook
+type Notification struct {
+	Kind       string `json:"kind"` // slack or discord
+	URL        string `json:"url"`
+	Milestones []int  `json:"milestones,omitempty"` // total votes announced when the poll reaches them
+	Hourly     bool   `json:"hourly,omitempty"`     // post a summary every hour the poll gets votes
+}
+
+// Geo aggregation modes
+const (
+	GeoByCountry = "country"
+	GeoByRegion  = "region"
+)
+
+// Counting modes
+const (
+	// CountMentions counts every tweet mentioning an option
+	CountMentions = "mentions"
+	// CountUniqueAuthors counts each author once per option
+	CountUniqueAuthors = "unique_authors"
+)
+
+// Embedded text modes
+const (
+	// EmbeddedScan also matches the options in the tweets retweeted or quoted
+	EmbeddedScan = "scan"
+	// EmbeddedIgnore only counts the options in a tweet's own text
+	EmbeddedIgnore = "ignore"
+)
+
+// Folding modes, see match.Vote.Folded
+const (
+	// FoldDiacritics matches the options ignoring diacritics, "München" is found in "Munchen"
+	FoldDiacritics = "diacritics"
+	// FoldTransliterate also matches Greek and Cyrillic options in the Latin alphabet and the other way round, "Москва" is found in "Moskva"
+	FoldTransliterate = "transliterate"
+)
+
+// Poll priorities
+const (
+	PriorityHigh   = "high"
+	PriorityNormal = "normal"
+	PriorityLow    = "low"
+)
+
+// Folds reports whether the poll counts the votes whose option was only found
+// in their text folded as mode, the ones found as written always count
+func (p *Poll) Folds(mode string) bool {
+	switch mode {
+	case "":
+		return true
+	case FoldDiacritics:
+		return p.Folding == FoldDiacritics || p.Folding == FoldTransliterate
+	}
+	return p.Folding == mode
+}
+
+// UniqueAuthors reports whether the poll counts each author once per option
+func (p *Poll) UniqueAuthors() bool {
+	return p.Counting == CountUniqueAuthors
+}
+
+// MatchingOf returns how strictly option is matched for the poll
+func (p *Poll) MatchingOf(option string) OptionMatching {
+	for _, m := range p.Matching {
+		if m.Option == option {
+			return m
+		}
+	}
+	return OptionMatching{Option: option}
+}
+
+// Weighted reports whether votes for this poll should also be tallied by weight
+func (p *Poll) Weighted() bool {
+	return p.Type == "weighted"
+}
+
+// PollStore manages poll documents
+type PollStore interface {
+	// Polls returns every poll
+	Polls() ([]Poll, error)
+	// Poll returns a single poll, or ErrNotFound
+	Poll(id string) (Poll, error)
+	// CreatePoll stores a new poll, setting its ID
+	CreatePoll(p *Poll) error
+	// DeletePoll removes the poll with the given ID
+	DeletePoll(id string) error
+	// SetPollStatus changes the poll's status to to if it still is from, an empty
+	// from matching polls without one, or returns ErrStatusChanged
+	SetPollStatus(id, from, to string) error
+}
+
+// ResultStore persists the tallies the counter flushes
+type ResultStore interface {
+	// AddResults increments a poll's raw counts and, when given, its weighted tallies
+	AddResults(pollID string, counts map[string]int, weighted map[string]float64) error
+	// AddGeoResults increments a poll's counts per area (a country or region) and option
+	AddGeoResults(pollID string, counts map[string]map[string]int) error
+	// AddMetrics increments a poll's custom metrics, per metric and option
+	AddMetrics(pollID string, metrics map[string]map[string]float64) error
+	// AddSourceResults increments a poll's counts per source and option
+	AddSourceResults(pollID string, counts map[string]map[string]int) error
+	// AddLanguageResults increments a poll's counts per language and option
+	AddLanguageResults(pollID string, counts map[string]map[string]int) error
+}
+
+// SnapshotStore keeps small blobs of process state across restarts
+type SnapshotStore interface {
+	// SaveSnapshot replaces the snapshot saved under name
+	SaveSnapshot(name string, data []byte) error
+	// LoadSnapshot returns the snapshot saved under name, or ErrNoSnapshot
+	LoadSnapshot(name string) ([]byte, error)
+}
+
+// Backend is everything the commands need from a store
+type Backend interface {
+	Store
+	PollStore
+	ResultStore
+	SnapshotStore
+}
+
+// Open connects to the backend named by kind (mongo, postgres or sqlite) at addr
+func Open(kind, addr string, opts DialOptions) (Backend, error) {
+	switch kind {
+	case "", "mongo":
+		return DialMongo(addr, opts)
+	case "postgres":
+		return OpenPostgres(addr, opts)
+	case "sqlite":
+		return OpenSQLite(addr)
+	default:
+		return nil, fmt.Errorf("store: unknown backend %q", kind)
+	}
+}
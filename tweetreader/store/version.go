@@ -0,0 +1,46 @@
+package store
+
+import (
+	"strconv"
+	"time"
+)
+
+// metricVersionPrefix starts the names of the MetricVersion metrics
+const metricVersionPrefix = "version_"
+
+// OptionsVersion returns the version of the poll's options, 1 for the polls
+// whose options were never edited
+func (p *Poll) OptionsVersion() int {
+	if p.Version < 1 {
+		return 1
+	}
+	return p.Version
+}
+
+// MetricVersion is the metric counting the votes for each option matched
+// against version of the poll's options, so the part of the results an edit
+// of the options changed can be told apart
+func MetricVersion(version int) string {
+	return metricVersionPrefix + strconv.Itoa(version)
+}
+
+// PollVersion is a version of a poll's options, the API keeps them in the
+// poll's Versions once they are edited
+type PollVersion struct {
+	Version int       `json:"version" bson:"version"`
+	Options []string  `json:"options" bson:"options"`
+	Since   time.Time `json:"since" bson:"since"` // when they became the poll's options
+}
+
+// OptionsAt returns the version of the options the poll had at t, its
+// options now when they were never edited
+func (p *Poll) OptionsAt(t time.Time) PollVersion {
+	at := PollVersion{Version: p.OptionsVersion(), Options: p.Options}
+	for i := len(p.Versions) - 1; i >= 0; i-- {
+		at = p.Versions[i]
+		if !t.Before(at.Since) {
+			break
+		}
+	}
+	return at
+}
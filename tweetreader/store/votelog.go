@@ -0,0 +1,172 @@
+package store
+
+import (
+	"reflect"
+	"time"
+
+	"gopkg.in/mgo.v2/bson"
+)
+
+// VoteLogStore is implemented by stores that can keep the vote log: every
+// vote message the counter counted, in the order it counted them, so the
+// results can be folded again from the votes after a bug in counting them.
+// The log is only appended to; recounting reads it and replaces the results.
+type VoteLogStore interface {
+	// AppendVotes adds votes to the end of the log, they are kept once it returns
+	AppendVotes(votes []LoggedVote) error
+	// ScanVotes calls fn with the logged votes counted for any of the polls,
+	// oldest first, and stops at the first error fn returns
+	ScanVotes(pollIDs []string, fn func(LoggedVote) error) error
+	// ReplaceResults replaces everything the counter tallied for a poll with t
+	ReplaceResults(pollID string, t Tallies) error
+}
+
+// LoggedVote is a vote message in the vote log
+type LoggedVote struct {
+	// Key is the message ID the vote was deduplicated by, empty for votes without one
+	Key string
+	// Polls are the polls with the vote's option that accepted votes when it
+	// was counted, a poll's status being history the log can't tell otherwise
+	Polls    []string
+	Received time.Time
+	// Body is the message as it was consumed, in whatever codec it was published in
+	Body []byte
+}
+
+// Tallies are the results the counter keeps for a poll, as in Poll
+type Tallies struct {
+	Results         map[string]int
+	WeightedResults map[string]float64
+	GeoResults      map[string]map[string]int
+	SourceResults   map[string]map[string]int
+	LanguageResults map[string]map[string]int
+	Metrics         map[string]map[string]float64
+}
+
+// TalliesOf returns the results of p
+func TalliesOf(p Poll) Tallies {
+	return Tallies{
+		Results:         p.Results,
+		WeightedResults: p.WeightedResults,
+		GeoResults:      p.GeoResults,
+		SourceResults:   p.SourceResults,
+		LanguageResults: p.LanguageResults,
+		Metrics:         p.Metrics,
+	}
+}
+
+type loggedVoteDoc struct {
+	ID       bson.ObjectId `bson:"_id"`
+	Key      string        `bson:"key,omitempty"`
+	Polls    []string      `bson:"polls"`
+	Received time.Time     `bson:"received"`
+	Body     []byte        `bson:"body"`
+}
+
+// AppendVotes inserts the votes into the vote_log collection, ordered by their _id
+func (m *Mongo) AppendVotes(votes []LoggedVote) error {
+	docs := make([]interface{}, len(votes))
+	for i, v := range votes {
+		docs[i] = loggedVoteDoc{ID: bson.NewObjectId(), Key: v.Key, Polls: v.Polls, Received: v.Received, Body: v.Body}
+	}
+	return m.session.DB(m.db).C("vote_log").Insert(docs...)
+}
+
+// ScanVotes iterates over the vote_log documents of the polls by _id
+func (m *Mongo) ScanVotes(pollIDs []string, fn func(LoggedVote) error) error {
+	iter := m.session.DB(m.db).C("vote_log").Find(bson.M{"polls": bson.M{"$in": pollIDs}}).Sort("_id").Iter()
+	var d loggedVoteDoc
+	for iter.Next(&d) {
+		if err := fn(LoggedVote{Key: d.Key, Polls: d.Polls, Received: d.Received, Body: d.Body}); err != nil {
+			iter.Close()
+			return err
+		}
+	}
+	return iter.Close()
+}
+
+// ReplaceResults sets the poll's result fields to t
+func (m *Mongo) ReplaceResults(pollID string, t Tallies) error {
+	if !bson.IsObjectIdHex(pollID) {
+		return ErrNotFound
+	}
+	safe := func(counts map[string]map[string]int) map[string]map[string]int {
+		out := make(map[string]map[string]int, len(counts))
+		for k, options := range counts {
+			out[fieldKey(k)] = options
+		}
+		return out
+	}
+	metrics := make(map[string]map[string]float64, len(t.Metrics))
+	for name, options := range t.Metrics {
+		metrics[fieldKey(name)] = options
+	}
+	fields := bson.M{
+		"results":          t.Results,
+		"weighted_results": t.WeightedResults,
+		"geo_results":      safe(t.GeoResults),
+		"source_results":   safe(t.SourceResults),
+		"language_results": safe(t.LanguageResults),
+		"metrics":          metrics,
+	}
+	// the empty ones are unset, $inc can't add to a field set to null
+	set, unset := bson.M{}, bson.M{}
+	for name, v := range fields {
+		if reflect.ValueOf(v).Len() == 0 {
+			unset[name] = ""
+		} else {
+			set[name] = v
+		}
+	}
+	update := bson.M{"$unset": unset}
+	if len(set) > 0 {
+		update["$set"] = set
+	}
+	return m.pollsOf(bson.ObjectIdHex(pollID)).UpdateId(bson.ObjectIdHex(pollID), update)
+}
+
+// AppendVotes adds the votes to the log
+func (m *Memory) AppendVotes(votes []LoggedVote) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.voteLog = append(m.voteLog, votes...)
+	return nil
+}
+
+// ScanVotes calls fn with the logged votes of the polls, without holding the
+// store's lock so fn may use it
+func (m *Memory) ScanVotes(pollIDs []string, fn func(LoggedVote) error) error {
+	m.mu.Lock()
+	logged := m.voteLog[:len(m.voteLog):len(m.voteLog)]
+	m.mu.Unlock()
+	wanted := make(map[string]bool, len(pollIDs))
+	for _, id := range pollIDs {
+		wanted[id] = true
+	}
+	for _, v := range logged {
+		for _, id := range v.Polls {
+			if wanted[id] {
+				if err := fn(v); err != nil {
+					return err
+				}
+				break
+			}
+		}
+	}
+	return nil
+}
+
+// ReplaceResults sets the poll's results to a copy of t
+func (m *Memory) ReplaceResults(pollID string, t Tallies) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	p := m.find(pollID)
+	if p == nil {
+		return ErrNotFound
+	}
+	c := copyPoll(&Poll{Results: t.Results, WeightedResults: t.WeightedResults, GeoResults: t.GeoResults,
+		SourceResults: t.SourceResults, LanguageResults: t.LanguageResults, Metrics: t.Metrics})
+	p.Results, p.WeightedResults, p.GeoResults = c.Results, c.WeightedResults, c.GeoResults
+	p.SourceResults, p.LanguageResults, p.Metrics = c.SourceResults, c.LanguageResults, c.Metrics
+	return nil
+}
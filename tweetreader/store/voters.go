@@ -0,0 +1,64 @@
+package store
+
+import (
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// VoterStore is implemented by stores that can remember who voted in a poll,
+// for the sources that allow a single vote per voter, like SMS
+type VoterStore interface {
+	// AddVoter records that voter voted in the poll, false when they already had
+	AddVoter(pollID, voter string) (bool, error)
+	// RemoveVoter forgets the vote of voter in the poll, as though they hadn't voted
+	RemoveVoter(pollID, voter string) error
+}
+
+type voterDoc struct {
+	ID    string    `bson:"_id"`
+	Poll  string    `bson:"poll"`
+	Voted time.Time `bson:"voted"`
+}
+
+// AddVoter inserts the voter into the voters collection, the unique _id
+// refuses a second vote
+func (m *Mongo) AddVoter(pollID, voter string) (bool, error) {
+	err := m.session.DB(m.db).C("voters").Insert(voterDoc{ID: pollID + "/" + voter, Poll: pollID, Voted: time.Now()})
+	if mgo.IsDup(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// RemoveVoter deletes the voter from the voters collection
+func (m *Mongo) RemoveVoter(pollID, voter string) error {
+	err := m.session.DB(m.db).C("voters").RemoveId(pollID + "/" + voter)
+	if err == mgo.ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// AddVoter records the voter of the poll
+func (m *Memory) AddVoter(pollID, voter string) (bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.voters == nil {
+		m.voters = make(map[string]bool)
+	}
+	key := pollID + "/" + voter
+	if m.voters[key] {
+		return false, nil
+	}
+	m.voters[key] = true
+	return true, nil
+}
+
+// RemoveVoter forgets the voter of the poll
+func (m *Memory) RemoveVoter(pollID, voter string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.voters, pollID+"/"+voter)
+	return nil
+}
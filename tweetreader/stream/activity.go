@@ -0,0 +1,241 @@
+package stream
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/tap"
+)
+
+// SourceActivity names the decode errors of the Account Activity webhook,
+// its votes are from SourceTwitter
+const SourceActivity = "activity"
+
+var activityEvents = metrics.NewCounter("tweetreader_activity_events_total",
+	"Requests to the Account Activity webhook, by result: tweets, ignored, crc, closed, rejected or failed.")
+
+// ActivityConfig describes how an Activity source takes the tweets Twitter's
+// Account Activity API posts to its webhook
+type ActivityConfig struct {
+	// ConsumerSecret of the Twitter app the webhook is registered with,
+	// answers the CRC checks and checks the signature of every request
+	ConsumerSecret string
+	// Options returns the options to vote for, it is called as the source starts and on every Reconnect
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// Timeout limits the wait for the pipeline to take the tweets of a
+	// request, 2s by default: Twitter takes an answer within 3s as delivered
+	Timeout time.Duration
+}
+
+// Activity takes the mentions and replies of the accounts subscribed to a
+// webhook of the Account Activity API, for deployments without streaming
+// access. Twitter posts the tweets of the accounts' activity to the webhook
+// as they happen, and checks it every so often with a CRC request it answers.
+// It can stand in for a Stream, the webhook is served apart with ServeHTTP.
+type Activity struct {
+	cfg        ActivityConfig
+	reconnects chan struct{}
+	tweets     chan Tweet // from the webhook to Start, never closed
+
+	mu          sync.Mutex
+	loaded      bool
+	running     bool
+	pausedUntil time.Time
+}
+
+// activityEvent is the part of an Account Activity message the votes come from
+type activityEvent struct {
+	ForUserID         string            `json:"for_user_id"`
+	TweetCreateEvents []json.RawMessage `json:"tweet_create_events"`
+}
+
+// NewActivity creates an Activity source from cfg
+func NewActivity(cfg ActivityConfig) (*Activity, error) {
+	if cfg.ConsumerSecret == "" {
+		return nil, fmt.Errorf("stream: the Account Activity webhook needs the app's consumer secret to check requests")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 2 * time.Second
+	}
+	return &Activity{
+		cfg:        cfg,
+		reconnects: make(chan struct{}, 1),
+		tweets:     make(chan Tweet),
+	}, nil
+}
+
+// Reconnect reloads the options
+func (a *Activity) Reconnect() {
+	select {
+	case a.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause refuses the tweets posted for d
+func (a *Activity) Pause(d time.Duration) {
+	a.mu.Lock()
+	a.pausedUntil = time.Now().Add(d)
+	a.mu.Unlock()
+	log.Println("Pausing the Account Activity webhook for", d)
+}
+
+// Resume lifts a pause
+func (a *Activity) Resume() {
+	a.mu.Lock()
+	a.pausedUntil = time.Time{}
+	a.mu.Unlock()
+	log.Println("Resuming the Account Activity webhook")
+}
+
+// Start passes the tweets the webhook takes on tweets until stopchan is signalled, like Stream.Start
+func (a *Activity) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	a.mu.Lock()
+	a.running = true
+	a.mu.Unlock()
+	go func() {
+		defer func() {
+			a.mu.Lock()
+			a.running = false
+			a.mu.Unlock()
+			log.Println("Stopping the Account Activity webhook...")
+			stoppedchan <- struct{}{}
+		}()
+		reload := time.After(0)
+		for {
+			select {
+			case <-stopchan:
+				return
+			case <-a.reconnects:
+				reload = time.After(0)
+			case <-reload:
+				reload = nil
+				if err := a.loadOptions(); err != nil {
+					log.Println("Failed to load options:", err)
+					reload = time.After(10 * time.Second)
+				}
+			case t := <-a.tweets:
+				select {
+				case tweets <- t:
+				case <-stopchan:
+					log.Println("Account Activity tweet lost stopping:", t.ID)
+					return
+				}
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+func (a *Activity) loadOptions() error {
+	options, err := a.cfg.Options()
+	if err != nil {
+		return err
+	}
+	log.Printf("Taking Account Activity votes for: %v", options)
+	if a.cfg.OnConnect != nil {
+		a.cfg.OnConnect(options)
+	}
+	a.mu.Lock()
+	a.loaded = true
+	a.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP answers Twitter's CRC checks, GET with a crc_token, and takes
+// the tweets of the activity it posts. A request whose tweets couldn't be
+// passed on is answered 503, for Twitter to deliver it again if it retries.
+func (a *Activity) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		token := r.URL.Query().Get("crc_token")
+		if token == "" {
+			http.Error(w, "missing crc_token", http.StatusBadRequest)
+			return
+		}
+		activityEvents.Inc("result", "crc")
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"response_token": a.sign([]byte(token))})
+		return
+	case http.MethodPost:
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxFrame))
+	if err != nil {
+		http.Error(w, "invalid body", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(a.sign(body)), []byte(r.Header.Get("X-Twitter-Webhooks-Signature"))) {
+		activityEvents.Inc("result", "rejected")
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	var e activityEvent
+	if err := json.Unmarshal(body, &e); err != nil {
+		tap.DecodeError(SourceActivity, body, err)
+		http.Error(w, "invalid event", http.StatusBadRequest)
+		return
+	}
+	// the subscribed accounts' own tweets aren't votes, nor is the rest of their activity
+	var tweets []Tweet
+	for _, raw := range e.TweetCreateEvents {
+		var t Tweet
+		if err := json.Unmarshal(raw, &t); err != nil {
+			tap.DecodeError(SourceActivity, raw, err)
+			continue
+		}
+		if t.User.ID != "" && t.User.ID == e.ForUserID {
+			continue
+		}
+		tweets = append(tweets, t)
+	}
+	if len(tweets) == 0 {
+		activityEvents.Inc("result", "ignored")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	a.mu.Lock()
+	open := a.running && a.loaded && time.Now().After(a.pausedUntil)
+	a.mu.Unlock()
+	if !open {
+		activityEvents.Inc("result", "closed")
+		http.Error(w, "not taking votes at the moment", http.StatusServiceUnavailable)
+		return
+	}
+	timeout := time.After(a.cfg.Timeout)
+	for _, t := range tweets {
+		select {
+		case a.tweets <- t:
+		case <-timeout:
+			log.Println("activity: timed out passing a tweet on:", t.ID)
+			activityEvents.Inc("result", "failed")
+			http.Error(w, "timed out", http.StatusServiceUnavailable)
+			return
+		}
+	}
+	activityEvents.Inc("result", "tweets")
+	w.WriteHeader(http.StatusOK)
+}
+
+// sign returns what Twitter signs b with: sha256= and the base64 HMAC-SHA256
+// of b, keyed by the consumer secret
+func (a *Activity) sign(b []byte) string {
+	mac := hmac.New(sha256.New, []byte(a.cfg.ConsumerSecret))
+	mac.Write(b)
+	return "sha256=" + base64.StdEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,121 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var responseErrors = metrics.NewCounter("tweetreader_stream_errors_total",
+	"Stream requests Twitter answered with something other than 200, by status.")
+
+// HTTPError is returned when Twitter answers the stream request with an error
+type HTTPError struct {
+	Status int
+	// Code is Twitter's error code, 0 when the body didn't have one
+	Code int
+	// Message is Twitter's explanation
+	Message string
+	// RetryAfter is how long Twitter asked to wait, 0 when it didn't say
+	RetryAfter time.Duration
+}
+
+func (e *HTTPError) Error() string {
+	if e.Code != 0 {
+		return fmt.Sprintf("stream: Twitter answered HTTP %d (code %d): %s", e.Status, e.Code, e.Message)
+	}
+	return fmt.Sprintf("stream: Twitter answered HTTP %d: %s", e.Status, e.Message)
+}
+
+// RateLimited reports whether Twitter asked to reconnect less often (420 Enhance Your Calm, or 429)
+func (e *HTTPError) RateLimited() bool {
+	return e.Status == 420 || e.Status == http.StatusTooManyRequests
+}
+
+// newHTTPError reads Twitter's explanation out of an error response: a JSON
+// list of errors for most endpoints, plain text or HTML for the stream
+func newHTTPError(resp *http.Response, body []byte) *HTTPError {
+	e := &HTTPError{Status: resp.StatusCode}
+	var parsed struct {
+		Errors []struct {
+			Code    int    `json:"code"`
+			Message string `json:"message"`
+		} `json:"errors"`
+	}
+	if json.Unmarshal(body, &parsed) == nil && len(parsed.Errors) > 0 {
+		e.Code, e.Message = parsed.Errors[0].Code, parsed.Errors[0].Message
+	} else if e.Message = strings.Join(strings.Fields(string(body)), " "); len(e.Message) > 200 {
+		e.Message = e.Message[:200] + "..."
+	}
+	if e.Message == "" {
+		e.Message = http.StatusText(resp.StatusCode)
+	}
+	if secs, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && secs > 0 {
+		e.RetryAfter = time.Duration(secs) * time.Second
+	}
+	return e
+}
+
+// reconnectBackoff applies the waits Twitter asks streaming clients to keep between reconnects:
+// linear for network errors, exponential for HTTP errors and longer still when rate limited.
+// Every kind starts over once a connection succeeds.
+type reconnectBackoff struct {
+	network, http, rateLimited time.Duration
+}
+
+const (
+	networkStep     = 250 * time.Millisecond
+	networkMax      = 16 * time.Second
+	httpFirst       = 5 * time.Second
+	httpMax         = 320 * time.Second
+	rateLimitFirst  = time.Minute
+	rateLimitMax    = 16 * time.Minute
+	reconnectMinGap = networkStep // even after a clean disconnect
+)
+
+// next returns how long to wait before reconnecting after err
+func (b *reconnectBackoff) next(err error) time.Duration {
+	switch e := err.(type) {
+	case nil:
+		// the stream was connected and ended, e.g. for a refresh
+		return reconnectMinGap
+	case *HTTPError:
+		if e.RateLimited() {
+			b.rateLimited = double(b.rateLimited, rateLimitFirst, rateLimitMax)
+			if e.RetryAfter > b.rateLimited {
+				return e.RetryAfter
+			}
+			return b.rateLimited
+		}
+		b.http = double(b.http, httpFirst, httpMax)
+		return b.http
+	}
+	if err == errUnauthorized || err == errForbidden {
+		b.http = double(b.http, httpFirst, httpMax)
+		return b.http
+	}
+	if b.network += networkStep; b.network > networkMax {
+		b.network = networkMax
+	}
+	return b.network
+}
+
+// reset starts every kind over, after a successful connection
+func (b *reconnectBackoff) reset() {
+	*b = reconnectBackoff{}
+}
+
+func double(d, first, max time.Duration) time.Duration {
+	if d == 0 {
+		return first
+	}
+	if d *= 2; d > max {
+		return max
+	}
+	return d
+}
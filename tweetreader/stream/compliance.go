@@ -0,0 +1,122 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var complianceMessages = metrics.NewCounter("tweetreader_stream_compliance_messages_total",
+	"Compliance messages received from Twitter, by kind.")
+
+// Compliance kinds, what Twitter asks to stop showing
+const (
+	// StatusDeleted is a tweet its author deleted
+	StatusDeleted = "status_deleted"
+	// StatusWithheld is a tweet withheld in some countries
+	StatusWithheld = "status_withheld"
+	// UserProtected is an author who protected their tweets
+	UserProtected = "user_protected"
+	// UserDeleted, UserSuspended and UserWithheld are authors whose account
+	// was deleted, suspended or withheld in some countries
+	UserDeleted   = "user_deleted"
+	UserSuspended = "user_suspended"
+	UserWithheld  = "user_withheld"
+)
+
+// Compliance is a message Twitter sends in the stream in place of a tweet,
+// asking to stop showing a tweet, TweetID, or every tweet of an author, UserID
+type Compliance struct {
+	Kind    string
+	TweetID string // empty for the kinds about authors
+	UserID  string
+}
+
+// complianceKeys are the keys the compliance messages start with, by kind.
+// Twitter also sends scrub_geo, which only asks to drop the coordinates of
+// an author's tweets: the votes keep none, it is only counted.
+var complianceKeys = map[string]string{
+	"delete":          StatusDeleted,
+	"status_withheld": StatusWithheld,
+	"user_protect":    UserProtected,
+	"user_delete":     UserDeleted,
+	"user_suspend":    UserSuspended,
+	"user_withheld":   UserWithheld,
+	"scrub_geo":       "scrub_geo",
+}
+
+// complianceMessage holds every field of the compliance messages that tells
+// the tweet or author they are about
+type complianceMessage struct {
+	Delete *struct {
+		Status struct {
+			ID     string `json:"id_str"`
+			UserID string `json:"user_id_str"`
+		} `json:"status"`
+	} `json:"delete"`
+	StatusWithheld *struct {
+		ID     json.Number `json:"id"`
+		UserID json.Number `json:"user_id"`
+	} `json:"status_withheld"`
+}
+
+// compliance reads raw when it is a compliance message, reporting whether it
+// was; the kinds that aren't tweets or authors to stop showing return ok with
+// no Kind
+func compliance(raw []byte) (c Compliance, ok bool) {
+	raw = bytes.TrimSpace(raw)
+	if !bytes.HasPrefix(raw, []byte(`{"`)) {
+		return c, false
+	}
+	end := bytes.IndexByte(raw[2:], '"')
+	if end < 0 {
+		return c, false
+	}
+	kind, ok := complianceKeys[string(raw[2:2+end])]
+	if !ok {
+		return c, false
+	}
+	complianceMessages.Inc("kind", kind)
+	switch kind {
+	case "scrub_geo":
+		return c, true
+	case StatusDeleted:
+		var m complianceMessage
+		if err := json.Unmarshal(raw, &m); err != nil || m.Delete == nil {
+			log.Println("unreadable compliance message:", err)
+			return c, true
+		}
+		return Compliance{Kind: kind, TweetID: m.Delete.Status.ID, UserID: m.Delete.Status.UserID}, true
+	case StatusWithheld:
+		var m complianceMessage
+		if err := json.Unmarshal(raw, &m); err != nil || m.StatusWithheld == nil {
+			log.Println("unreadable compliance message:", err)
+			return c, true
+		}
+		return Compliance{Kind: kind, TweetID: m.StatusWithheld.ID.String(), UserID: m.StatusWithheld.UserID.String()}, true
+	}
+	// the messages about authors have their ID under id or user_id, as a
+	// string in the _str fields on the ones that have them
+	var m map[string]struct {
+		ID        string      `json:"id_str"`
+		UserID    string      `json:"user_id_str"`
+		NumericID json.Number `json:"id"`
+		UserNum   json.Number `json:"user_id"`
+	}
+	if err := json.Unmarshal(raw, &m); err != nil {
+		log.Println("unreadable compliance message:", err)
+		return c, true
+	}
+	c.Kind = kind
+	for _, u := range m {
+		for _, id := range []string{u.ID, u.UserID, u.NumericID.String(), u.UserNum.String()} {
+			if id != "" {
+				c.UserID = id
+				break
+			}
+		}
+	}
+	return c, true
+}
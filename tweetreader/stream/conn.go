@@ -0,0 +1,77 @@
+package stream
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// streamConn tracks the connection a Stream reads from. Requests are
+// interrupted by cancelling their context, which closes the connection; dialing
+// also closes the previous connection first, so a dropped stream never leaves a
+// zombie connection behind. Every Stream has its own, so several can run side by side.
+type streamConn struct {
+	mu   sync.Mutex
+	conn net.Conn // the connection last dialed
+}
+
+// dialer returns the DialContext for the stream's transport, wrapping the
+// connections with wrap when set
+func (c *streamConn) dialer(d *net.Dialer, wrap func(net.Conn) net.Conn) func(ctx context.Context, netw, addr string) (net.Conn, error) {
+	return func(ctx context.Context, netw, addr string) (net.Conn, error) {
+		c.mu.Lock()
+		if c.conn != nil {
+			c.conn.Close()
+			c.conn = nil
+		}
+		c.mu.Unlock()
+		netc, err := d.DialContext(ctx, netw, addr)
+		if err != nil {
+			return nil, err
+		}
+		if wrap != nil {
+			netc = wrap(netc)
+		}
+		c.mu.Lock()
+		c.conn = netc
+		c.mu.Unlock()
+		return netc, nil
+	}
+}
+
+// stallReader calls cancel when nothing was read from r for timeout
+type stallReader struct {
+	r       io.Reader
+	timeout time.Duration
+	timer   *time.Timer
+	fired   int32
+}
+
+func newStallReader(r io.Reader, timeout time.Duration, cancel context.CancelFunc) *stallReader {
+	s := &stallReader{r: r, timeout: timeout}
+	s.timer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&s.fired, 1)
+		cancel()
+	})
+	return s
+}
+
+func (s *stallReader) Read(p []byte) (int, error) {
+	n, err := s.r.Read(p)
+	if n > 0 {
+		s.timer.Reset(s.timeout)
+	}
+	return n, err
+}
+
+// stalled reports whether the timeout cancelled the read
+func (s *stallReader) stalled() bool {
+	return atomic.LoadInt32(&s.fired) == 1
+}
+
+func (s *stallReader) stop() {
+	s.timer.Stop()
+}
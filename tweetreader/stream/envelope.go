@@ -0,0 +1,140 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// DefaultV2URL is the v2 filtered stream endpoint, the stream's URL with FramingV2
+const DefaultV2URL = "https://api.twitter.com/2/tweets/search/stream"
+
+// v2Fields asks the v2 stream for what a Tweet holds, which it leaves out by default
+var v2Fields = map[string]string{
+	"tweet.fields": "created_at,lang,entities,referenced_tweets,in_reply_to_user_id,author_id",
+	"expansions":   "author_id,referenced_tweets.id,referenced_tweets.id.author_id,in_reply_to_user_id",
+	"user.fields":  "name,username,verified,public_metrics",
+}
+
+// envelope is a message of the v2 filtered stream
+type envelope struct {
+	Data     *tweetV2 `json:"data"`
+	Includes struct {
+		Users  []userV2  `json:"users"`
+		Tweets []tweetV2 `json:"tweets"`
+	} `json:"includes"`
+	MatchingRules []struct {
+		ID  string `json:"id"`
+		Tag string `json:"tag"`
+	} `json:"matching_rules"`
+	// Errors come on their own, e.g. before Twitter drops the connection
+	Errors []struct {
+		Title  string `json:"title"`
+		Detail string `json:"detail"`
+	} `json:"errors"`
+}
+
+// tweetV2 is a tweet as the v2 API shapes it
+type tweetV2 struct {
+	ID              string `json:"id"`
+	Text            string `json:"text"`
+	CreatedAt       string `json:"created_at"` // RFC 3339
+	AuthorID        string `json:"author_id"`
+	Lang            string `json:"lang"`
+	InReplyToUserID string `json:"in_reply_to_user_id"`
+	Entities        *struct {
+		Hashtags []struct {
+			Tag string `json:"tag"`
+		} `json:"hashtags"`
+		Mentions []struct {
+			ID       string `json:"id"`
+			Username string `json:"username"`
+		} `json:"mentions"`
+	} `json:"entities"`
+	ReferencedTweets []struct {
+		Type string `json:"type"` // retweeted, quoted or replied_to
+		ID   string `json:"id"`
+	} `json:"referenced_tweets"`
+}
+
+// userV2 is a user as the v2 API shapes them
+type userV2 struct {
+	ID            string `json:"id"`
+	Name          string `json:"name"`
+	Username      string `json:"username"`
+	Verified      bool   `json:"verified"`
+	PublicMetrics struct {
+		FollowersCount int `json:"followers_count"`
+	} `json:"public_metrics"`
+}
+
+// decodeEnvelope reads the v2 message raw into t, shaped as the v1.1 stream
+// shapes tweets, and reports whether it held a tweet. An error message is
+// returned as an error.
+func decodeEnvelope(raw []byte, t *Tweet) (bool, error) {
+	var e envelope
+	if err := json.Unmarshal(raw, &e); err != nil {
+		return false, err
+	}
+	if e.Data == nil {
+		if len(e.Errors) > 0 {
+			return false, fmt.Errorf("the stream sent an error: %s: %s", e.Errors[0].Title, e.Errors[0].Detail)
+		}
+		return false, nil
+	}
+	users := make(map[string]*userV2, len(e.Includes.Users))
+	for i := range e.Includes.Users {
+		users[e.Includes.Users[i].ID] = &e.Includes.Users[i]
+	}
+	tweets := make(map[string]*tweetV2, len(e.Includes.Tweets))
+	for i := range e.Includes.Tweets {
+		tweets[e.Includes.Tweets[i].ID] = &e.Includes.Tweets[i]
+	}
+	*t = e.Data.tweet(users)
+	for _, ref := range e.Data.ReferencedTweets {
+		switch ref.Type {
+		case "retweeted", "quoted":
+			embedded := Tweet{ID: ref.ID}
+			if included, ok := tweets[ref.ID]; ok {
+				embedded = included.tweet(users)
+			}
+			if ref.Type == "retweeted" {
+				t.RetweetedStatus = &embedded
+			} else {
+				t.QuotedStatus = &embedded
+			}
+		case "replied_to":
+			t.InReplyToStatusID = ref.ID
+			if u, ok := users[e.Data.InReplyToUserID]; ok {
+				t.InReplyToScreenName = u.Username
+			}
+		}
+	}
+	for _, r := range e.MatchingRules {
+		t.MatchingRules = append(t.MatchingRules, r.Tag)
+	}
+	return true, nil
+}
+
+// tweet returns v as a Tweet, with its author from users
+func (v *tweetV2) tweet(users map[string]*userV2) Tweet {
+	t := Tweet{ID: v.ID, Text: v.Text, Lang: v.Lang}
+	if at, err := time.Parse(time.RFC3339, v.CreatedAt); err == nil {
+		t.CreatedAt = at.UTC().Format(time.RubyDate)
+	}
+	t.User.ID = v.AuthorID
+	if u, ok := users[v.AuthorID]; ok {
+		t.User.Name, t.User.ScreenName = u.Name, u.Username
+		t.User.Verified, t.User.FollowersCount = u.Verified, u.PublicMetrics.FollowersCount
+	}
+	if v.Entities != nil {
+		t.Entities = &Entities{}
+		for _, h := range v.Entities.Hashtags {
+			t.Entities.Hashtags = append(t.Entities.Hashtags, Hashtag{Text: h.Tag})
+		}
+		for _, m := range v.Entities.Mentions {
+			t.Entities.UserMentions = append(t.Entities.UserMentions, Mention{ID: m.ID, ScreenName: m.Username})
+		}
+	}
+	return t
+}
@@ -0,0 +1,32 @@
+package stream
+
+// Tweets longer than 140 characters arrive truncated: text is cut short and the whole text
+// is in extended_tweet on the stream, or in full_text when searching with tweet_mode=extended.
+// The v2 API always has the whole text in text.
+
+// ExtendedTweet holds the whole text of a truncated tweet and the entities found in it
+type ExtendedTweet struct {
+	FullText string    `json:"full_text"`
+	Entities *Entities `json:"entities,omitempty"`
+}
+
+// Expand replaces a truncated text and its entities with the whole ones,
+// in t and in the tweets it retweets or quotes
+func (t *Tweet) Expand() {
+	switch {
+	case t.ExtendedTweet != nil && t.ExtendedTweet.FullText != "":
+		t.Text = t.ExtendedTweet.FullText
+		if t.ExtendedTweet.Entities != nil {
+			t.Entities = t.ExtendedTweet.Entities
+		}
+	case t.FullText != "":
+		t.Text = t.FullText
+	}
+	t.Truncated, t.FullText, t.ExtendedTweet = false, "", nil
+	if t.RetweetedStatus != nil {
+		t.RetweetedStatus.Expand()
+	}
+	if t.QuotedStatus != nil {
+		t.QuotedStatus.Expand()
+	}
+}
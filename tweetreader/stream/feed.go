@@ -0,0 +1,424 @@
+package stream
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"html"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// FeedIDPrefix prefixes the IDs of the tweets made of feed items, so they
+// never collide with a tweet's
+const FeedIDPrefix = "feed:"
+
+// maxFeedSize limits how much of a feed is read
+const maxFeedSize = 10 << 20
+
+var (
+	feedItems = metrics.NewCounter("tweetreader_feed_items_total",
+		"New RSS and Atom feed items read, by feed.")
+	feedErrors = metrics.NewCounter("tweetreader_feed_errors_total",
+		"Failed fetches of an RSS or Atom feed, by feed.")
+)
+
+// FeedConfig describes the feeds a Feed source polls
+type FeedConfig struct {
+	// URLs of the RSS or Atom feeds
+	URLs []string
+	// Options returns the options to vote for, it is called every time the source (re)connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// Interval between two fetches of a feed, 5m by default
+	Interval time.Duration
+	// Backfill is how old the items in a feed when it is first fetched may be
+	// and still count, 24h by default; items without a date always count
+	Backfill time.Duration
+	// Transport configures the connections to the feeds, its SearchTimeout limits each fetch
+	Transport TransportConfig
+}
+
+// Feed polls RSS and Atom feeds, of news sites or blogs, and passes each new
+// item on as a tweet of its title and text, so polls can count media
+// mentions. The feed's host stands in for the author. It can stand in for a Stream.
+type Feed struct {
+	cfg        FeedConfig
+	client     *http.Client
+	reconnects chan struct{}
+	feeds      []*feedState
+	started    time.Time
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// feedState is what is known of a feed between fetches, set with the Feed's mu held
+type feedState struct {
+	url          string
+	host         string
+	seen         map[string]bool // the IDs of the items of the last fetch, nil before the first
+	etag         string
+	lastModified string
+}
+
+// NewFeed creates a Feed source from cfg
+func NewFeed(cfg FeedConfig) (*Feed, error) {
+	if len(cfg.URLs) == 0 {
+		return nil, fmt.Errorf("stream: no feeds to poll")
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = 5 * time.Minute
+	}
+	if cfg.Backfill <= 0 {
+		cfg.Backfill = 24 * time.Hour
+	}
+	f := &Feed{
+		cfg:        cfg,
+		client:     &http.Client{Transport: cfg.Transport.transport(nil), Timeout: cfg.Transport.searchTimeout()},
+		reconnects: make(chan struct{}, 1),
+		started:    time.Now(),
+	}
+	for _, raw := range cfg.URLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return nil, fmt.Errorf("stream: invalid feed URL %q", raw)
+		}
+		f.feeds = append(f.feeds, &feedState{url: raw, host: strings.TrimPrefix(u.Hostname(), "www.")})
+	}
+	return f, nil
+}
+
+// Reconnect reloads the options and fetches the feeds
+func (f *Feed) Reconnect() {
+	select {
+	case f.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops fetching the feeds for d, the items published meanwhile are read after
+func (f *Feed) Pause(d time.Duration) {
+	f.mu.Lock()
+	f.pausedUntil = time.Now().Add(d)
+	f.mu.Unlock()
+	log.Println("Pausing feeds for", d)
+	f.Reconnect()
+}
+
+// Resume lifts a pause
+func (f *Feed) Resume() {
+	f.mu.Lock()
+	f.pausedUntil = time.Time{}
+	f.mu.Unlock()
+	log.Println("Resuming feeds")
+	f.Reconnect()
+}
+
+func (f *Feed) pause() time.Duration {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return time.Until(f.pausedUntil)
+}
+
+// Start fetches the feeds and sends their new items on tweets until stopchan is signalled, like Stream.Start
+func (f *Feed) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		for {
+			wait := f.pause()
+			if wait <= 0 {
+				stopped, err := f.poll(stopchan, tweets)
+				if stopped {
+					log.Println("Stopping feeds...")
+					return
+				}
+				if err == nil {
+					continue
+				}
+				wait = 10 * time.Second // wait before reconnecting
+			}
+			select {
+			case <-stopchan:
+				log.Println("Stopping feeds...")
+				return
+			case <-time.After(wait):
+			case <-f.reconnects:
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// poll fetches every feed each Interval until a reconnect, a stop or a
+// failure to load the options
+func (f *Feed) poll(stopchan <-chan struct{}, tweets chan<- Tweet) (stopped bool, err error) {
+	options, err := f.cfg.Options()
+	if err != nil {
+		log.Println("Failed to load options:", err)
+		return false, err
+	}
+	log.Printf("Polling %d feeds every %v for: %v", len(f.feeds), f.cfg.Interval, options)
+	if f.cfg.OnConnect != nil {
+		f.cfg.OnConnect(options)
+	}
+	for {
+		for _, feed := range f.feeds {
+			items, err := f.fetch(feed)
+			if err != nil {
+				feedErrors.Inc("feed", feed.host)
+				log.Printf("feed: failed to fetch %s: %v", feed.url, err)
+				continue
+			}
+			for _, t := range items {
+				feedItems.Inc("feed", feed.host)
+				select {
+				case tweets <- t:
+				case <-stopchan:
+					return true, nil
+				}
+			}
+		}
+		select {
+		case <-stopchan:
+			return true, nil
+		case <-f.reconnects:
+			return false, nil
+		case <-time.After(f.cfg.Interval):
+		}
+	}
+}
+
+// fetch returns the items of the feed it hadn't seen, nothing when it didn't change
+func (f *Feed) fetch(feed *feedState) ([]Tweet, error) {
+	req, err := http.NewRequest("GET", feed.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/rss+xml, application/atom+xml, application/xml;q=0.9, */*;q=0.8")
+	if feed.etag != "" {
+		req.Header.Set("If-None-Match", feed.etag)
+	}
+	if feed.lastModified != "" {
+		req.Header.Set("If-Modified-Since", feed.lastModified)
+	}
+	resp, err := f.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, nil
+	default:
+		return nil, fmt.Errorf("%s", resp.Status)
+	}
+	title, items, err := parseFeed(io.LimitReader(resp.Body, maxFeedSize))
+	if err != nil {
+		return nil, err
+	}
+	etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+
+	first := feed.seen == nil
+	seen := make(map[string]bool, len(items))
+	var tweets []Tweet
+	for _, item := range items {
+		id := item.id()
+		seen[id] = true
+		if feed.seen[id] {
+			continue
+		}
+		published, dated := item.published()
+		if first && dated && published.Before(f.started.Add(-f.cfg.Backfill)) {
+			continue
+		}
+		if !dated {
+			published = time.Now()
+		}
+		sum := sha256.Sum256([]byte(feed.url + "\n" + id))
+		t := Tweet{
+			ID:        FeedIDPrefix + hex.EncodeToString(sum[:12]),
+			CreatedAt: published.UTC().Format(time.RubyDate),
+			Text:      item.text(),
+		}
+		t.User.Name = title
+		t.User.ScreenName = feed.host
+		tweets = append(tweets, t)
+	}
+	f.mu.Lock()
+	feed.seen, feed.etag, feed.lastModified = seen, etag, lastModified
+	f.mu.Unlock()
+	return tweets, nil
+}
+
+// feedCheckpoint is what the state of a Feed keeps of a feed
+type feedCheckpoint struct {
+	Seen         []string `json:"seen"`
+	ETag         string   `json:"etag,omitempty"`
+	LastModified string   `json:"last_modified,omitempty"`
+}
+
+// State returns the items of each feed's last fetch, by URL
+func (f *Feed) State() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	feeds := make(map[string]feedCheckpoint, len(f.feeds))
+	for _, feed := range f.feeds {
+		if feed.seen == nil {
+			continue
+		}
+		c := feedCheckpoint{Seen: make([]string, 0, len(feed.seen)), ETag: feed.etag, LastModified: feed.lastModified}
+		for id := range feed.seen {
+			c.Seen = append(c.Seen, id)
+		}
+		sort.Strings(c.Seen)
+		feeds[feed.url] = c
+	}
+	return json.Marshal(feeds)
+}
+
+// Restore makes the first fetch of each feed pass on only the items its last
+// one didn't have, rather than every item within Backfill
+func (f *Feed) Restore(state []byte) error {
+	var feeds map[string]feedCheckpoint
+	if err := json.Unmarshal(state, &feeds); err != nil {
+		return fmt.Errorf("stream: unreadable feeds state: %v", err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for _, feed := range f.feeds {
+		c, ok := feeds[feed.url]
+		if !ok {
+			continue
+		}
+		feed.seen = make(map[string]bool, len(c.Seen))
+		for _, id := range c.Seen {
+			feed.seen[id] = true
+		}
+		feed.etag, feed.lastModified = c.ETag, c.LastModified
+	}
+	return nil
+}
+
+// feedDoc is an RSS 2.0 document or an Atom feed, whichever it turns out to be
+type feedDoc struct {
+	XMLName xml.Name
+	// RSS
+	Channel struct {
+		Title string     `xml:"title"`
+		Items []feedItem `xml:"item"`
+	} `xml:"channel"`
+	// Atom
+	Title   string     `xml:"title"`
+	Entries []feedItem `xml:"entry"`
+}
+
+// feedItem is an RSS item or an Atom entry
+type feedItem struct {
+	Title string `xml:"title"`
+	// RSS
+	GUID        string `xml:"guid"`
+	Description string `xml:"description"`
+	PubDate     string `xml:"pubDate"`
+	// Atom
+	ID        string `xml:"id"`
+	Summary   string `xml:"summary"`
+	Content   string `xml:"content"`
+	Published string `xml:"published"`
+	Updated   string `xml:"updated"`
+	// Links are RSS's link, as text, or Atom's, as href
+	Links []struct {
+		Href string `xml:"href,attr"`
+		Text string `xml:",chardata"`
+	} `xml:"link"`
+}
+
+// parseFeed returns the title and items of an RSS or Atom feed
+func parseFeed(r io.Reader) (string, []feedItem, error) {
+	var doc feedDoc
+	d := xml.NewDecoder(r)
+	// feeds declaring another charset are mostly ASCII, read them as they are
+	d.CharsetReader = func(_ string, input io.Reader) (io.Reader, error) { return input, nil }
+	if err := d.Decode(&doc); err != nil {
+		return "", nil, err
+	}
+	switch doc.XMLName.Local {
+	case "rss":
+		return doc.Channel.Title, doc.Channel.Items, nil
+	case "feed":
+		return doc.Title, doc.Entries, nil
+	}
+	return "", nil, fmt.Errorf("not an RSS or Atom feed: <%s>", doc.XMLName.Local)
+}
+
+// id identifies the item within its feed: its GUID or ID, else its link or title
+func (i feedItem) id() string {
+	for _, id := range []string{i.GUID, i.ID} {
+		if id = strings.TrimSpace(id); id != "" {
+			return id
+		}
+	}
+	for _, l := range i.Links {
+		for _, id := range []string{l.Href, l.Text} {
+			if id = strings.TrimSpace(id); id != "" {
+				return id
+			}
+		}
+	}
+	return i.Title
+}
+
+// feedDates are the layouts of the dates of RSS items and Atom entries
+var feedDates = []string{time.RFC1123Z, time.RFC1123, time.RFC3339, "Mon, 2 Jan 2006 15:04:05 -0700", "Mon, 2 Jan 2006 15:04:05 MST", "2 Jan 2006 15:04:05 -0700"}
+
+// published returns when the item was published, false when it has no date that parses
+func (i feedItem) published() (time.Time, bool) {
+	for _, s := range []string{i.PubDate, i.Published, i.Updated} {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		for _, layout := range feedDates {
+			if t, err := time.Parse(layout, s); err == nil {
+				return t, true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// htmlTags matches the HTML tags of a description
+var htmlTags = regexp.MustCompile(`<[^>]*>`)
+
+// text returns the title and the text of the item, without its HTML
+func (i feedItem) text() string {
+	body := i.Description
+	if body == "" {
+		body = i.Summary
+	}
+	if body == "" {
+		body = i.Content
+	}
+	body = strings.Join(strings.Fields(html.UnescapeString(htmlTags.ReplaceAllString(body, " "))), " ")
+	title := strings.Join(strings.Fields(html.UnescapeString(i.Title)), " ")
+	if body == "" {
+		return title
+	}
+	return title + "\n" + body
+}
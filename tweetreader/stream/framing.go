@@ -0,0 +1,94 @@
+package stream
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"log"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Twitter's streams are framed by lines: every message ends with \r\n, and a
+// blank line is sent as a keep-alive when there has been nothing else to
+// send for 30s. What a message is depends on the stream's framing.
+const (
+	// FramingV1 is the v1.1 statuses/filter stream, a tweet, a limit notice
+	// or a compliance message per line
+	FramingV1 = "v1"
+	// FramingV2 is the v2 filtered stream, an envelope per line with the
+	// tweet in data, its author in includes and the rules it matched in
+	// matching_rules, see decodeEnvelope
+	FramingV2 = "v2"
+)
+
+// maxFrame caps the size of a message, the longer ones are skipped whole
+const maxFrame = 1 << 20
+
+var (
+	keepAlives = metrics.NewCounter("tweetreader_stream_keepalives_total",
+		"Blank keep-alive lines Twitter sent on the stream, by connection.")
+	oversizedFrames = metrics.NewCounter("tweetreader_stream_oversized_messages_total",
+		"Messages on the stream longer than a megabyte, skipped, by connection.")
+)
+
+// frames reads the messages of a stream a line at a time, leaving out the
+// keep-alives
+type frames struct {
+	r          *bufio.Reader
+	connection string
+	line       []byte
+}
+
+func newFrames(r io.Reader, connection string) *frames {
+	return &frames{r: bufio.NewReaderSize(r, 64*1024), connection: connection}
+}
+
+// next returns the next message without its line ending, only valid until the
+// following call; the error is io.EOF once the stream has ended
+func (f *frames) next() ([]byte, error) {
+	for {
+		line, err := f.readLine()
+		if err != nil {
+			return nil, err
+		}
+		if line = bytes.TrimSpace(line); len(line) == 0 {
+			keepAlives.Inc("connection", f.connection)
+			continue
+		}
+		return line, nil
+	}
+}
+
+// readLine returns the next line, skipping the ones over maxFrame; a last
+// line the stream ended without finishing is returned too
+func (f *frames) readLine() ([]byte, error) {
+	f.line = f.line[:0]
+	oversized := false
+	for {
+		chunk, err := f.r.ReadSlice('\n')
+		if !oversized && len(f.line)+len(chunk) > maxFrame {
+			oversized = true
+		}
+		if !oversized {
+			f.line = append(f.line, chunk...)
+		}
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case nil:
+			if oversized {
+				log.Printf("skipping a message over %d bytes on the stream", maxFrame)
+				oversizedFrames.Inc("connection", f.connection)
+				f.line, oversized = f.line[:0], false
+				continue
+			}
+			return f.line, nil
+		case io.EOF:
+			if len(f.line) > 0 && !oversized {
+				return f.line, nil
+			}
+		}
+		return nil, err
+	}
+}
@@ -0,0 +1,74 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+)
+
+// BoundingBox is an area to stream tweets from: west, south, east, north in degrees,
+// the order Twitter's locations parameter takes
+type BoundingBox [4]float64
+
+// String formats the box for the locations parameter
+func (b BoundingBox) String() string {
+	return fmt.Sprintf("%g,%g,%g,%g", b[0], b[1], b[2], b[3])
+}
+
+// Valid reports whether the box has its corners the right way round and within range
+func (b BoundingBox) Valid() bool {
+	west, south, east, north := b[0], b[1], b[2], b[3]
+	return west >= -180 && east <= 180 && south >= -90 && north <= 90 && west < east && south < north
+}
+
+// Contains reports whether the point is inside the box
+func (b BoundingBox) Contains(longitude, latitude float64) bool {
+	return longitude >= b[0] && longitude <= b[2] && latitude >= b[1] && latitude <= b[3]
+}
+
+// Point is a GeoJSON point, the exact location a tweet was sent from
+type Point struct {
+	Type        string     `json:"type"`
+	Coordinates [2]float64 `json:"coordinates"` // longitude, latitude
+}
+
+// Place is the named place a tweet is associated with
+type Place struct {
+	ID          string `json:"id"`
+	PlaceType   string `json:"place_type"` // city, admin, country, neighborhood or poi
+	Name        string `json:"name"`
+	FullName    string `json:"full_name"` // e.g. "Austin, TX" for a city, "Texas, USA" for an admin area
+	CountryCode string `json:"country_code"`
+	Country     string `json:"country"`
+	BoundingBox struct {
+		Coordinates [][][2]float64 `json:"coordinates"`
+	} `json:"bounding_box"`
+}
+
+// Location returns where the tweet was sent from: its coordinates when it has them,
+// otherwise the centre of its place
+func (t *Tweet) Location() (longitude, latitude float64, ok bool) {
+	if t.Coordinates != nil {
+		return t.Coordinates.Coordinates[0], t.Coordinates.Coordinates[1], true
+	}
+	if t.Place == nil || len(t.Place.BoundingBox.Coordinates) == 0 || len(t.Place.BoundingBox.Coordinates[0]) == 0 {
+		return 0, 0, false
+	}
+	ring := t.Place.BoundingBox.Coordinates[0]
+	for _, c := range ring {
+		longitude += c[0]
+		latitude += c[1]
+	}
+	return longitude / float64(len(ring)), latitude / float64(len(ring)), true
+}
+
+// Region returns the region of the tweet's place, e.g. "TX" for "Austin, TX", or "" when unknown
+func (p *Place) Region() string {
+	parts := strings.Split(p.FullName, ", ")
+	switch {
+	case p.PlaceType == "admin":
+		return parts[0]
+	case len(parts) > 1:
+		return parts[len(parts)-1]
+	}
+	return ""
+}
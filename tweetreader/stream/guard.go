@@ -0,0 +1,54 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Twitter only allows one standing connection per set of credentials.
+// When a second one is opened (another instance, a zombie connection, a deploy overlap)
+// it responds with an "Easy there, Turbo" or connection-limit error,
+// and reconnecting every few seconds only digs the hole deeper.
+// Instead we back off for a long cool-down and make some noise about it.
+var defaultDuplicateConnPatterns = []string{
+	"easy there, turbo",
+	"exceeded connection limit",
+	"maximum allowed connection",
+	"toomanyconnections",
+}
+
+// DuplicateConnectionError is returned when Twitter rejects the stream
+// because the credentials already have a connection open
+type DuplicateConnectionError struct {
+	Status  int
+	Message string
+}
+
+func (e *DuplicateConnectionError) Error() string {
+	return fmt.Sprintf("duplicate stream connection (HTTP %d): %s", e.Status, e.Message)
+}
+
+// checkDuplicateConnection inspects the body of a non-200 stream response for Twitter's duplicate-connection errors.
+// A cool-down of 0 disables the guard.
+func (s *Stream) checkDuplicateConnection(status int, body []byte) error {
+	if s.cfg.DuplicateCooldown <= 0 {
+		return nil
+	}
+	msg := strings.ToLower(string(body))
+	for _, pattern := range s.cfg.DuplicatePatterns {
+		if strings.Contains(msg, strings.ToLower(pattern)) {
+			return &DuplicateConnectionError{Status: status, Message: strings.TrimSpace(string(body))}
+		}
+	}
+	return nil
+}
+
+// alertDuplicateConnection logs a banner that is hard to miss in the logs
+func (s *Stream) alertDuplicateConnection(err *DuplicateConnectionError) {
+	log.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+	log.Println("!!! ALERT:", err)
+	log.Println("!!! Another connection is using these Twitter credentials.")
+	log.Println("!!! Cooling down for", s.cfg.DuplicateCooldown, "before reconnecting.")
+	log.Println("!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!!")
+}
@@ -0,0 +1,211 @@
+package stream
+
+import (
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// Reconnect causes, why a connection to Twitter ended or never started
+const (
+	CauseRefresh      = "refresh"      // reconnected to track new options
+	CausePaused       = "paused"       // paused through the admin API or the runbook
+	CauseCredentials  = "credentials"  // the credentials rotated
+	CauseStalled      = "stalled"      // nothing received for the stall timeout
+	CauseUnauthorized = "unauthorized" // the credentials were rejected
+	CauseForbidden    = "forbidden"    // the credentials may not use the endpoint
+	CauseDuplicate    = "duplicate"    // another connection uses the credentials
+	CauseHTTP         = "http_error"   // any other error status
+	CauseNetwork      = "network"      // the request or the connection failed
+	CauseClosed       = "closed"       // Twitter ended the stream
+)
+
+var (
+	reconnects = metrics.NewCounter("tweetreader_stream_reconnects_total",
+		"Connections to Twitter that ended or never started, by cause.")
+	decodeSeconds = metrics.NewHistogram("tweetreader_stream_decode_seconds",
+		"Time taken to decode a tweet from the stream.",
+		[]float64{.00001, .000025, .00005, .0001, .00025, .0005, .001, .0025, .01})
+	gapSeconds = metrics.NewHistogram("tweetreader_stream_gap_seconds",
+		"How long the streams went without every one of them connected, until they were again.",
+		[]float64{1, 5, 15, 30, 60, 120, 300, 900, 3600})
+)
+
+// Summary is how the streams of the process did over a period, for
+// reporting how complete the ingestion was
+type Summary struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// UptimeRatio is the share of the period every stream was connected
+	UptimeRatio float64 `json:"uptime_ratio"`
+	// Reconnects counts the connections that ended or never started, by cause
+	Reconnects map[string]int `json:"reconnects"`
+	// Gaps is how many times the streams went down, not all of them
+	// connected, and GapSeconds how long that lasted in all
+	Gaps              int     `json:"gaps"`
+	GapSeconds        float64 `json:"gap_seconds"`
+	LongestGapSeconds float64 `json:"longest_gap_seconds"`
+	Tweets            int     `json:"tweets"`
+	// DecodeSeconds is the average time a tweet took to decode
+	DecodeSeconds float64 `json:"decode_seconds"`
+}
+
+// health accumulates the Summary of the current period
+var health = struct {
+	sync.Mutex
+	from       time.Time
+	started    bool // any stream is running, a process not streaming has no gap
+	up         bool
+	since      time.Time // of the last change of up
+	upFor      time.Duration
+	reconnects map[string]int
+	gaps       int
+	gapFor     time.Duration
+	longest    time.Duration
+	tweets     int
+	decoding   time.Duration
+}{from: time.Now(), since: time.Now(), reconnects: make(map[string]int)}
+
+// healthUp records the streams connecting, every one of them, or going down,
+// and whether any is running
+func healthUp(up, started bool) {
+	now := time.Now()
+	health.Lock()
+	defer health.Unlock()
+	if started && !health.started && !up {
+		// the gap starts with the streaming
+		health.since = now
+	}
+	health.started = started
+	if up == health.up {
+		return
+	}
+	if health.up {
+		health.upFor += now.Sub(maxTime(health.since, health.from))
+	} else {
+		gap := now.Sub(health.since)
+		gapSeconds.Observe(gap.Seconds())
+		health.gaps++
+		health.gapFor += now.Sub(maxTime(health.since, health.from))
+		if gap > health.longest {
+			health.longest = gap
+		}
+	}
+	health.up, health.since = up, now
+}
+
+// healthReconnect records a connection ending, or failing to start, for cause
+func healthReconnect(cause string) {
+	reconnects.Inc("cause", cause)
+	health.Lock()
+	health.reconnects[cause]++
+	health.Unlock()
+}
+
+// healthDecoded records a tweet decoded in took
+func healthDecoded(took time.Duration) {
+	decodeSeconds.Observe(took.Seconds())
+	health.Lock()
+	health.tweets++
+	health.decoding += took
+	health.Unlock()
+}
+
+// DownFor returns how long the streams have gone without every one of them
+// connected, 0 while they are or while none is running
+func DownFor() time.Duration {
+	health.Lock()
+	defer health.Unlock()
+	if health.up || !health.started {
+		return 0
+	}
+	return time.Since(health.since)
+}
+
+// connectedFor returns how long every stream has been connected, 0 while they aren't
+func connectedFor() time.Duration {
+	health.Lock()
+	defer health.Unlock()
+	if !health.up {
+		return 0
+	}
+	return time.Since(health.since)
+}
+
+// CurrentSummary returns the Summary of the period so far
+func CurrentSummary() Summary {
+	health.Lock()
+	defer health.Unlock()
+	return summary(time.Now())
+}
+
+// TakeSummary returns the Summary of the period so far and starts a new one
+func TakeSummary() Summary {
+	now := time.Now()
+	health.Lock()
+	defer health.Unlock()
+	s := summary(now)
+	health.from = now
+	health.upFor, health.gapFor, health.longest, health.decoding = 0, 0, 0, 0
+	health.gaps, health.tweets = 0, 0
+	health.reconnects = make(map[string]int)
+	return s
+}
+
+// summary sums the period up as of now, must be called with health held
+func summary(now time.Time) Summary {
+	s := Summary{From: health.from, To: now, Reconnects: make(map[string]int), Gaps: health.gaps, Tweets: health.tweets}
+	for cause, n := range health.reconnects {
+		s.Reconnects[cause] = n
+	}
+	upFor, gapFor, longest := health.upFor, health.gapFor, health.longest
+	current := now.Sub(maxTime(health.since, health.from))
+	if health.up {
+		upFor += current
+	} else if health.started {
+		// the gap still going on counts too
+		s.Gaps++
+		gapFor += current
+		if gap := now.Sub(health.since); gap > longest {
+			longest = gap
+		}
+	}
+	if span := now.Sub(health.from); span > 0 {
+		s.UptimeRatio = upFor.Seconds() / span.Seconds()
+	}
+	s.GapSeconds, s.LongestGapSeconds = gapFor.Seconds(), longest.Seconds()
+	if health.tweets > 0 {
+		s.DecodeSeconds = health.decoding.Seconds() / float64(health.tweets)
+	}
+	return s
+}
+
+func maxTime(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// causeOf returns the cause of a connection that ended with err, and wasn't interrupted
+func causeOf(err error) string {
+	switch e := err.(type) {
+	case nil:
+		return CauseClosed
+	case *DuplicateConnectionError:
+		return CauseDuplicate
+	case *HTTPError:
+		return CauseHTTP
+	default:
+		switch e {
+		case errStalled:
+			return CauseStalled
+		case errUnauthorized:
+			return CauseUnauthorized
+		case errForbidden:
+			return CauseForbidden
+		}
+	}
+	return CauseNetwork
+}
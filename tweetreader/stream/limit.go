@@ -0,0 +1,100 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	undeliveredTweets = metrics.NewCounter("tweetreader_stream_undelivered_tweets_total",
+		"Tweets matching the filter Twitter didn't deliver, as its limit notices told, by connection.")
+	limitNotices = metrics.NewCounter("tweetreader_stream_limit_notices_total",
+		"Limit notices received from Twitter, by connection.")
+)
+
+// limitNotice is what Twitter sends in the stream when more tweets match the
+// filter than it delivers, Track counting the ones it withheld since the
+// connection started
+type limitNotice struct {
+	Limit struct {
+		Track int64 `json:"track"`
+	} `json:"limit"`
+}
+
+// limitPrefix starts every limit notice, tweets never start so
+var limitPrefix = []byte(`{"limit":`)
+
+// gapWindow is how long the share of undelivered tweets is measured over,
+// along with the window before it
+const gapWindow = time.Minute
+
+// gaps accounts for the tweets of one connection Twitter didn't deliver. The
+// share undelivered is measured over the current window and the one before,
+// so it follows the rate of the stream.
+type gaps struct {
+	connection string // labels the metrics
+	track      int64  // as of the last notice
+	start      time.Time
+	// of the window before and the current one
+	delivered, undelivered [2]int64
+}
+
+func newGaps(connection string, now time.Time) *gaps {
+	return &gaps{connection: connection, start: now}
+}
+
+// roll starts a new window once the current one is over
+func (g *gaps) roll(now time.Time) {
+	switch elapsed := now.Sub(g.start); {
+	case elapsed >= 2*gapWindow:
+		g.delivered, g.undelivered = [2]int64{}, [2]int64{}
+		g.start = now
+	case elapsed >= gapWindow:
+		g.delivered = [2]int64{g.delivered[1], 0}
+		g.undelivered = [2]int64{g.undelivered[1], 0}
+		g.start = g.start.Add(gapWindow)
+	}
+}
+
+// tweet counts a tweet delivered at now
+func (g *gaps) tweet(now time.Time) {
+	g.roll(now)
+	g.delivered[1]++
+}
+
+// notice reads raw when it is a limit notice, reporting whether it was
+func (g *gaps) notice(raw []byte, now time.Time) bool {
+	if !bytes.HasPrefix(bytes.TrimSpace(raw), limitPrefix) {
+		return false
+	}
+	var n limitNotice
+	if err := json.Unmarshal(raw, &n); err != nil {
+		log.Println("unreadable limit notice:", err)
+		return true
+	}
+	limitNotices.Inc("connection", g.connection)
+	// the count only grows for a connection, it is one of its own after a reconnect
+	missed := n.Limit.Track - g.track
+	if missed <= 0 {
+		return true
+	}
+	g.track = n.Limit.Track
+	g.roll(now)
+	g.undelivered[1] += missed
+	undeliveredTweets.Add(float64(missed), "connection", g.connection)
+	return true
+}
+
+// share is how much of the tweets matching the filter Twitter didn't deliver
+// lately, from 0 to 1
+func (g *gaps) share() float64 {
+	undelivered := g.undelivered[0] + g.undelivered[1]
+	if undelivered == 0 {
+		return 0
+	}
+	return float64(undelivered) / float64(undelivered+g.delivered[0]+g.delivered[1])
+}
@@ -0,0 +1,182 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Options written as a handle, @name, are votes by mentioning the account
+// rather than by naming the option. Their accounts are followed on the stream,
+// which also delivers the replies to and retweets of their tweets, and tracked
+// by screen name, which Twitter matches against the mentions in a tweet. The
+// matcher then looks for them in the mentions Twitter parsed, not in the text.
+
+// UsersLookupURL is the v1.1 endpoint handles are resolved to user IDs with
+const UsersLookupURL = "https://api.twitter.com/1.1/users/lookup.json"
+
+const (
+	// maxFollow is the most user IDs Twitter follows on one stream
+	maxFollow = 5000
+	// maxLookup is the most screen names, or user IDs, users/lookup takes at once
+	maxLookup = 100
+)
+
+// Mention is an account mentioned in a tweet
+type Mention struct {
+	ID         string `json:"id_str"`
+	ScreenName string `json:"screen_name"`
+}
+
+// IsHandle reports whether option is a handle, an @ and a valid screen name
+func IsHandle(option string) bool {
+	name := strings.TrimPrefix(option, "@")
+	if len(name) == len(option) || name == "" || len(name) > 15 {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_') {
+			return false
+		}
+	}
+	return true
+}
+
+// HandleKey is how a handle option and a mentioned screen name are compared:
+// without the @ and case
+func HandleKey(s string) string {
+	return strings.ToLower(strings.TrimPrefix(s, "@"))
+}
+
+// followIDs returns the user IDs of the handles among options, at most maxFollow,
+// looking up the ones not seen before. Handles that can't be looked up are left
+// out, their mentions are still tracked.
+func (s *Stream) followIDs(ctx context.Context, options []string) []string {
+	var handles, missing []string
+	s.mu.Lock()
+	for _, o := range options {
+		if !IsHandle(o) {
+			continue
+		}
+		key := HandleKey(o)
+		handles = append(handles, key)
+		if _, ok := s.userIDs[key]; !ok {
+			missing = append(missing, key)
+		}
+	}
+	s.mu.Unlock()
+	for len(missing) > 0 {
+		n := len(missing)
+		if n > maxLookup {
+			n = maxLookup
+		}
+		found, err := s.lookupUsers(ctx, missing[:n])
+		if err != nil {
+			log.Println("failed to look up the handles to follow:", err)
+			break
+		}
+		s.mu.Lock()
+		if s.userIDs == nil {
+			s.userIDs = make(map[string]string)
+		}
+		for _, name := range missing[:n] {
+			s.userIDs[name] = found[name] // "" for suspended and unknown accounts, so they aren't looked up again
+		}
+		s.mu.Unlock()
+		missing = missing[n:]
+	}
+	var ids []string
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, key := range handles {
+		if id := s.userIDs[key]; id != "" {
+			ids = append(ids, id)
+		}
+	}
+	if len(ids) > maxFollow {
+		log.Printf("following %d handles, Twitter only accepts %d", len(ids), maxFollow)
+		ids = ids[:maxFollow]
+	}
+	return ids
+}
+
+// lookupUsers returns the user IDs of the accounts named, by HandleKey
+func (s *Stream) lookupUsers(ctx context.Context, names []string) (map[string]string, error) {
+	params := url.Values{}
+	params.Set("screen_name", strings.Join(names, ","))
+	req, err := http.NewRequestWithContext(ctx, "GET", UsersLookupURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, "GET", params)
+	resp, err := s.searchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	ids := make(map[string]string, len(names))
+	// none of the names exist
+	if resp.StatusCode == http.StatusNotFound {
+		return ids, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("users lookup failed: %s", resp.Status)
+	}
+	var users []Mention
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		ids[HandleKey(u.ScreenName)] = u.ID
+	}
+	return ids, nil
+}
+
+// Profile is what the users lookup tells of an account, the User of its tweets
+type Profile struct {
+	ID             string `json:"id_str"`
+	Name           string `json:"name"`
+	ScreenName     string `json:"screen_name"`
+	Verified       bool   `json:"verified"`
+	FollowersCount int    `json:"followers_count"`
+}
+
+// LookupProfiles returns the profiles of the accounts ids, at most 100,
+// by ID; the unknown and suspended ones are left out
+func (s *Stream) LookupProfiles(ctx context.Context, ids []string) (map[string]Profile, error) {
+	if len(ids) > maxLookup {
+		return nil, fmt.Errorf("users lookup takes %d accounts at most, not %d", maxLookup, len(ids))
+	}
+	params := url.Values{}
+	params.Set("user_id", strings.Join(ids, ","))
+	req, err := http.NewRequestWithContext(ctx, "GET", UsersLookupURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, "GET", params)
+	resp, err := s.searchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	profiles := make(map[string]Profile, len(ids))
+	// none of the accounts exist
+	if resp.StatusCode == http.StatusNotFound {
+		return profiles, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("users lookup failed: %s", resp.Status)
+	}
+	var users []Profile
+	if err := json.NewDecoder(resp.Body).Decode(&users); err != nil {
+		return nil, err
+	}
+	for _, u := range users {
+		profiles[u.ID] = u
+	}
+	return profiles, nil
+}
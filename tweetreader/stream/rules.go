@@ -0,0 +1,254 @@
+package stream
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// RulesURL is the v2 filtered stream rules endpoint
+const RulesURL = "https://api.twitter.com/2/tweets/search/stream/rules"
+
+// RuleTag prefixes the tag of every rule tweetreader adds, followed by the option
+const RuleTag = "tweetreader:"
+
+// maxRulesPerRequest is how many rules are added or deleted with one request
+const maxRulesPerRequest = 100
+
+var (
+	rulesDrift = metrics.NewGauge("tweetreader_stream_rules_drift",
+		"Filtered stream rules missing or extra at the last reconciliation.")
+	rulesRepaired = metrics.NewCounter("tweetreader_stream_rules_repaired_total",
+		"Filtered stream rules added or deleted to match the options, by action.")
+	rulesFailed = metrics.NewCounter("tweetreader_stream_rules_failed_total",
+		"Filtered stream rules Twitter refused to add or delete.")
+)
+
+// Rule is a v2 filtered stream rule
+type Rule struct {
+	ID    string `json:"id,omitempty"`
+	Value string `json:"value"`
+	Tag   string `json:"tag,omitempty"`
+}
+
+// RuleError is a rule Twitter refused to add or delete, and why
+type RuleError struct {
+	Value string `json:"value,omitempty"`
+	ID    string `json:"id,omitempty"`
+	Error string `json:"error"`
+}
+
+// RulesConfig says where the filtered stream's rules are kept
+type RulesConfig struct {
+	// URL of the rules endpoint, RulesURL when empty
+	URL string
+	// BearerToken authenticates the requests with the app's token
+	BearerToken string
+	// Transport configures the connections to Twitter, its SearchTimeout limits each request
+	Transport TransportConfig
+}
+
+// Rules keeps the rules of a v2 filtered stream in line with the options.
+// The options are the desired state: each gets a rule tagged RuleTag and the
+// option, and every other rule, e.g. one added by hand or left over from a
+// partly failed change, is drift.
+type Rules struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewRules creates the client of the rules cfg describes
+func NewRules(cfg RulesConfig) *Rules {
+	url := cfg.URL
+	if url == "" {
+		url = RulesURL
+	}
+	return &Rules{
+		url:    url,
+		token:  cfg.BearerToken,
+		client: &http.Client{Transport: cfg.Transport.transport(nil), Timeout: cfg.Transport.searchTimeout()},
+	}
+}
+
+// RulesReport is what a reconciliation found and did
+type RulesReport struct {
+	// DryRun reconciliations only had Twitter validate the changes
+	DryRun  bool        `json:"dry_run"`
+	Remote  int         `json:"remote"`
+	Desired int         `json:"desired"`
+	Add     []Rule      `json:"add"`
+	Delete  []Rule      `json:"delete"`
+	Failed  []RuleError `json:"failed,omitempty"`
+}
+
+// Drifted reports whether the remote rules differed from the options
+func (r RulesReport) Drifted() bool {
+	return len(r.Add) > 0 || len(r.Delete) > 0
+}
+
+// RuleFor returns the rule matching the tweets the track parameter of the
+// v1.1 filter endpoint does for term: all of its words, in any order
+func RuleFor(term string) Rule {
+	term = strings.TrimSpace(term)
+	value := term
+	if strings.ContainsAny(term, `"():`) || strings.HasPrefix(term, "-") {
+		// operators and negation, taken literally
+		value = strconv.Quote(term)
+	}
+	return Rule{Value: value, Tag: RuleTag + term}
+}
+
+// diffRules returns the rules to add for the terms remote is missing and
+// the remote rules no term has, or that repeat another's
+func diffRules(remote []Rule, terms []string) (add, del []Rule) {
+	want := map[Rule]bool{}
+	for _, term := range terms {
+		if strings.TrimSpace(term) != "" {
+			want[RuleFor(term)] = true
+		}
+	}
+	have := map[Rule]bool{}
+	for _, r := range remote {
+		key := Rule{Value: r.Value, Tag: r.Tag}
+		if !want[key] || have[key] {
+			del = append(del, r)
+			continue
+		}
+		have[key] = true
+	}
+	for r := range want {
+		if !have[r] {
+			add = append(add, r)
+		}
+	}
+	sort.Slice(add, func(i, j int) bool { return add[i].Tag < add[j].Tag })
+	return add, del
+}
+
+// List returns the filtered stream's rules
+func (r *Rules) List() ([]Rule, error) {
+	req, err := http.NewRequest("GET", r.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	var result struct {
+		Data []Rule `json:"data"`
+	}
+	if err := r.do(req, &result); err != nil {
+		return nil, err
+	}
+	return result.Data, nil
+}
+
+// Sync diffs the remote rules against the rules of the terms the options are
+// tracked with, see trackTerms, and repairs the drift: the extra rules are
+// deleted, then the missing ones added. With dryRun Twitter only validates
+// the changes, which the report lists either way.
+func (r *Rules) Sync(options []string, dryRun bool) (RulesReport, error) {
+	report := RulesReport{DryRun: dryRun}
+	remote, err := r.List()
+	if err != nil {
+		return report, err
+	}
+	report.Remote = len(remote)
+	report.Add, report.Delete = diffRules(remote, trackTerms(options))
+	report.Desired = report.Remote - len(report.Delete) + len(report.Add)
+	rulesDrift.Set(float64(len(report.Add) + len(report.Delete)))
+
+	for i := 0; i < len(report.Delete); i += maxRulesPerRequest {
+		batch := report.Delete[i:minInt(i+maxRulesPerRequest, len(report.Delete))]
+		ids := make([]string, len(batch))
+		for j, rule := range batch {
+			ids[j] = rule.ID
+		}
+		failed, err := r.change(map[string]interface{}{"delete": map[string][]string{"ids": ids}}, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("deleting rules: %v", err)
+		}
+		report.Failed = append(report.Failed, failed...)
+		if !dryRun {
+			rulesRepaired.Add(float64(len(batch)-len(failed)), "action", "delete")
+		}
+	}
+	for i := 0; i < len(report.Add); i += maxRulesPerRequest {
+		batch := report.Add[i:minInt(i+maxRulesPerRequest, len(report.Add))]
+		failed, err := r.change(map[string]interface{}{"add": batch}, dryRun)
+		if err != nil {
+			return report, fmt.Errorf("adding rules: %v", err)
+		}
+		report.Failed = append(report.Failed, failed...)
+		if !dryRun {
+			rulesRepaired.Add(float64(len(batch)-len(failed)), "action", "add")
+		}
+	}
+	rulesFailed.Add(float64(len(report.Failed)))
+	return report, nil
+}
+
+// change posts a change of the rules, returning the rules Twitter refused
+func (r *Rules) change(body interface{}, dryRun bool) ([]RuleError, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, err
+	}
+	url := r.url
+	if dryRun {
+		url += "?dry_run=true"
+	}
+	req, err := http.NewRequest("POST", url, bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	var result struct {
+		Errors []struct {
+			Value  string `json:"value"`
+			ID     string `json:"id"`
+			Title  string `json:"title"`
+			Detail string `json:"detail"`
+		} `json:"errors"`
+	}
+	if err := r.do(req, &result); err != nil {
+		return nil, err
+	}
+	var failed []RuleError
+	for _, e := range result.Errors {
+		msg := e.Title
+		if e.Detail != "" {
+			msg += ": " + e.Detail
+		}
+		failed = append(failed, RuleError{Value: e.Value, ID: e.ID, Error: msg})
+	}
+	return failed, nil
+}
+
+// do sends req with the bearer token and decodes the answer into v
+func (r *Rules) do(req *http.Request, v interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+r.token)
+	resp, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("rules request failed: %s: %s", resp.Status, bytes.TrimSpace(body))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,210 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// SearchURL is the v1.1 standard search endpoint
+const SearchURL = "https://api.twitter.com/1.1/search/tweets.json"
+
+// VerifyCredentialsURL is the v1.1 endpoint returning the account of the credentials
+const VerifyCredentialsURL = "https://api.twitter.com/1.1/account/verify_credentials.json"
+
+// maxSearchQuery is the longest query the standard search API accepts
+const maxSearchQuery = 500
+
+// searchResponse is the body of a search/tweets response
+type searchResponse struct {
+	Statuses []Tweet `json:"statuses"`
+}
+
+// Search returns the recent tweets mentioning any of terms with an ID above sinceID,
+// oldest first. Terms are split over as many queries as the search API's query length needs.
+func (s *Stream) Search(terms []string, sinceID string) ([]Tweet, error) {
+	var tweets []Tweet
+	for _, q := range searchQueries(terms) {
+		found, err := s.search(q, sinceID)
+		if err != nil {
+			return tweets, err
+		}
+		tweets = append(tweets, found...)
+	}
+	// the API returns newest first
+	for i, j := 0, len(tweets)-1; i < j; i, j = i+1, j-1 {
+		tweets[i], tweets[j] = tweets[j], tweets[i]
+	}
+	return tweets, nil
+}
+
+func (s *Stream) search(q, sinceID string) ([]Tweet, error) {
+	params := url.Values{}
+	params.Set("q", q)
+	params.Set("count", "100")
+	params.Set("result_type", "recent")
+	params.Set("tweet_mode", "extended") // full_text instead of truncated text
+	if sinceID != "" {
+		params.Set("since_id", sinceID)
+	}
+	req, err := http.NewRequest("GET", SearchURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return nil, err
+	}
+	s.sign(req, "GET", params)
+	resp, err := s.searchClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("search failed: %s", resp.Status)
+	}
+	var result searchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, err
+	}
+	return result.Statuses, nil
+}
+
+// VerifyCredentials checks the credentials with the lightest authenticated
+// request there is and returns the screen name of their account
+func (s *Stream) VerifyCredentials() (string, error) {
+	params := url.Values{}
+	params.Set("skip_status", "true")
+	params.Set("include_entities", "false")
+	req, err := http.NewRequest("GET", VerifyCredentialsURL+"?"+params.Encode(), nil)
+	if err != nil {
+		return "", err
+	}
+	s.sign(req, "GET", params)
+	resp, err := s.searchClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4<<10))
+		return "", newHTTPError(resp, body)
+	}
+	var account struct {
+		ScreenName string `json:"screen_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&account); err != nil {
+		return "", err
+	}
+	return account.ScreenName, nil
+}
+
+// searchQueries ORs terms together in queries no longer than the API allows
+func searchQueries(terms []string) []string {
+	var (
+		queries []string
+		current []string
+		length  int
+	)
+	for _, term := range terms {
+		quoted := strconv.Quote(term)
+		if length > 0 && length+len(quoted)+4 > maxSearchQuery {
+			queries = append(queries, strings.Join(current, " OR "))
+			current, length = nil, 0
+		}
+		current = append(current, quoted)
+		length += len(quoted) + 4
+	}
+	if len(current) > 0 {
+		queries = append(queries, strings.Join(current, " OR "))
+	}
+	return queries
+}
+
+// startSearching looks up the Searched options every SearchEvery until ctx
+// is done, sending the tweets found on tweets; the returned channel is closed
+// once it stopped, right away without Searched
+func (s *Stream) startSearching(ctx context.Context, tweets chan<- Tweet) <-chan struct{} {
+	stopped := make(chan struct{})
+	if s.cfg.Searched == nil {
+		close(stopped)
+		return stopped
+	}
+	every := s.cfg.SearchEvery
+	if every <= 0 {
+		every = time.Minute
+	}
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(every)
+		defer ticker.Stop()
+		var cursor searchCursor
+		for {
+			if terms := s.cfg.Searched(); len(terms) > 0 && s.pause() <= 0 {
+				_, stopped, err := s.searchNew(terms, &cursor, tweets, ctx.Done())
+				if err != nil {
+					log.Println("failed to search for the options left out of the filter:", err)
+				}
+				if stopped {
+					return
+				}
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return stopped
+}
+
+// searchCursor is where the searches for new tweets go on from
+type searchCursor struct {
+	sinceID string // the newest tweet found
+	// started is false until the first search, which only finds where to go
+	// on from without a sinceID: the tweets before it were counted by an
+	// earlier streamer, or not wanted
+	started bool
+}
+
+// searchNew searches for the tweets mentioning terms newer than the cursor,
+// sends them on tweets and moves the cursor past them. After a failed query
+// the cursor stays, the next search looks through the same tweets again and
+// the counter drops the votes it already has. It returns how many tweets it
+// sent, and whether it was stopped before sending them all.
+func (s *Stream) searchNew(terms []string, cur *searchCursor, tweets chan<- Tweet, stop <-chan struct{}) (sent int, stopped bool, err error) {
+	found, err := s.Search(terms, cur.sinceID)
+	latest := cur.sinceID
+	for _, t := range found {
+		if newerID(t.ID, latest) {
+			latest = t.ID
+		}
+		if !cur.started && cur.sinceID == "" {
+			continue
+		}
+		select {
+		case tweets <- t:
+			sent++
+		case <-stop:
+			return sent, true, err
+		}
+	}
+	if err == nil {
+		cur.sinceID, cur.started = latest, true
+	}
+	return sent, false, err
+}
+
+// newerID reports whether the tweet ID a is newer than b, an empty b being older than any
+func newerID(a, b string) bool {
+	if len(a) != len(b) {
+		return len(a) > len(b)
+	}
+	return a > b
+}
@@ -0,0 +1,163 @@
+package stream
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+var (
+	searchTweets = metrics.NewCounter("tweetreader_search_tweets_total",
+		"Tweets found by the search API polling in place of the stream.")
+	searchErrors = metrics.NewCounter("tweetreader_search_errors_total",
+		"Failed searches of the search API polling in place of the stream.")
+)
+
+// SearcherConfig describes what a Searcher looks for
+type SearcherConfig struct {
+	// Options returns the terms to search for, it is called every time the searcher (re)connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the terms before any tweets for them are sent
+	OnConnect func(terms []string)
+	// Interval between two searches, 1m by default. A search returns at most
+	// 100 tweets for every query the terms take, the newest.
+	Interval time.Duration
+	// SinceID, if set, returns the newest tweet an earlier run found, "" when
+	// there was none; the searcher goes on from it. Without one the first
+	// search only finds where to go on from.
+	SinceID func() (string, error)
+	// SaveSinceID, if set, is given the newest tweet found after every search
+	SaveSinceID func(id string) error
+}
+
+// Searcher polls the recent search API on its own, for credentials without
+// access to the streaming API. It can stand in for a Stream, whose
+// credentials it signs its searches with.
+type Searcher struct {
+	stream     *Stream
+	cfg        SearcherConfig
+	reconnects chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewSearcher creates a Searcher searching with s's credentials
+func NewSearcher(s *Stream, cfg SearcherConfig) *Searcher {
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Minute
+	}
+	return &Searcher{stream: s, cfg: cfg, reconnects: make(chan struct{}, 1)}
+}
+
+// Reconnect reloads the options and searches
+func (s *Searcher) Reconnect() {
+	select {
+	case s.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops searching for d, the tweets posted meanwhile are looked for after
+func (s *Searcher) Pause(d time.Duration) {
+	s.mu.Lock()
+	s.pausedUntil = time.Now().Add(d)
+	s.mu.Unlock()
+	log.Println("Pausing the search for", d)
+	s.Reconnect()
+}
+
+// Resume lifts a pause
+func (s *Searcher) Resume() {
+	s.mu.Lock()
+	s.pausedUntil = time.Time{}
+	s.mu.Unlock()
+	log.Println("Resuming the search")
+	s.Reconnect()
+}
+
+func (s *Searcher) pause() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Until(s.pausedUntil)
+}
+
+// Start searches and sends the tweets found on tweets until stopchan is signalled, like Stream.Start
+func (s *Searcher) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		var cursor searchCursor
+		if s.cfg.SinceID != nil {
+			id, err := s.cfg.SinceID()
+			if err != nil {
+				// without it the searches go on from the newest tweet, the ones since the last run are missed
+				log.Println("failed to load where the last search left off:", err)
+			}
+			cursor.sinceID = id
+		}
+		for {
+			wait := s.pause()
+			if wait <= 0 {
+				stopped, err := s.poll(stopchan, tweets, &cursor)
+				if stopped {
+					log.Println("Stopping the search...")
+					return
+				}
+				if err == nil {
+					continue
+				}
+				wait = 10 * time.Second // wait before reconnecting
+			}
+			select {
+			case <-stopchan:
+				log.Println("Stopping the search...")
+				return
+			case <-time.After(wait):
+			case <-s.reconnects:
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// poll searches every Interval until a reconnect, a stop or a failure to load the options
+func (s *Searcher) poll(stopchan <-chan struct{}, tweets chan<- Tweet, cursor *searchCursor) (stopped bool, err error) {
+	options, err := s.cfg.Options()
+	if err != nil {
+		log.Println("Failed to load options:", err)
+		return false, err
+	}
+	log.Printf("Searching every %v for: %v", s.cfg.Interval, options)
+	if s.cfg.OnConnect != nil {
+		s.cfg.OnConnect(options)
+	}
+	for {
+		if len(options) > 0 {
+			sent, stopped, err := s.stream.searchNew(options, cursor, tweets, stopchan)
+			searchTweets.Add(float64(sent))
+			if stopped {
+				return true, nil
+			}
+			if err != nil {
+				searchErrors.Inc()
+				log.Println("search failed:", err)
+			} else if s.cfg.SaveSinceID != nil && cursor.sinceID != "" {
+				if err := s.cfg.SaveSinceID(cursor.sinceID); err != nil {
+					log.Println("failed to save where the search left off:", err)
+				}
+			}
+		}
+		select {
+		case <-stopchan:
+			return true, nil
+		case <-s.reconnects:
+			return false, nil
+		case <-time.After(s.cfg.Interval):
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package stream
+
+import (
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/slo"
+)
+
+// connected tracks whether the streams are reading from Twitter
+var (
+	connected = slo.NewUptime()
+	sloOnce   sync.Once
+)
+
+// streams counts the started streams and the ones connected, the process
+// only counts as connected while all of them are
+var streams struct {
+	sync.Mutex
+	started, up int
+}
+
+// streamStarted and streamStopped are called as Start begins and ends, streamUp
+// and streamDown as a stream connects and disconnects
+func streamStarted() { updateStreams(1, 0) }
+func streamStopped() { updateStreams(-1, 0) }
+func streamUp()      { updateStreams(0, 1) }
+func streamDown()    { updateStreams(0, -1) }
+
+func updateStreams(started, up int) {
+	streams.Lock()
+	defer streams.Unlock()
+	streams.started += started
+	streams.up += up
+	all := streams.started > 0 && streams.up == streams.started
+	connected.Set(all)
+	healthUp(all, streams.started > 0)
+}
+
+// registerSLO exports the stream availability, only in processes that stream
+func registerSLO() {
+	sloOnce.Do(func() {
+		metrics.NewGaugeFunc("tweetreader_stream_connected", "1 while every stream is connected to Twitter.", func() float64 {
+			if connected.Up() {
+				return 1
+			}
+			return 0
+		})
+		metrics.NewGaugeFunc("tweetreader_slo_stream_availability_ratio",
+			"Share of the SLO window every stream was connected to Twitter.", connected.Value)
+		metrics.NewGaugeFunc("tweetreader_stream_connection_uptime_seconds",
+			"How long every stream has been connected to Twitter, 0 while one isn't.", func() float64 {
+				return connectedFor().Seconds()
+			})
+	})
+}
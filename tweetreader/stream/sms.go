@@ -0,0 +1,290 @@
+package stream
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// SMSIDPrefix prefixes the IDs of the tweets made of text messages, so they
+// never collide with a tweet's
+const SMSIDPrefix = "sms:"
+
+// SMSKeyword starts the text messages that are votes: "VOTE <option>"
+const SMSKeyword = "VOTE"
+
+// maxListedOptions is how many options the reply to a message that isn't a vote lists
+const maxListedOptions = 10
+
+var smsMessages = metrics.NewCounter("tweetreader_sms_messages_total",
+	"Text messages received by the SMS webhook, by result: vote, repeat, unknown, closed, rejected or failed.")
+
+// SMSConfig describes how an SMS source takes votes from Twilio's webhook
+type SMSConfig struct {
+	// AuthToken of the Twilio account, checks the X-Twilio-Signature of every request
+	AuthToken string
+	// URL Twilio is configured to post to, which the signature covers;
+	// taken from the requests when empty, which a proxy rewriting them breaks
+	URL string
+	// Options returns the options to vote for, it is called as the source starts and on every Reconnect
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// Vote records the vote of voter for option, false when the one-vote rule
+	// refuses it; any number of votes are taken when nil. Undo forgets the
+	// vote again when it can't be passed on, so the voter may try again.
+	Vote func(voter, option string) (counted bool, undo func() error, err error)
+	// Timeout limits the wait for the pipeline to take a vote, 10s by default
+	Timeout time.Duration
+}
+
+// SMS takes votes texted to a Twilio number as "VOTE <option>", with a
+// webhook Twilio posts the messages to, and answers each with a text. It
+// can stand in for a Stream, the webhook is served apart with ServeHTTP.
+type SMS struct {
+	cfg        SMSConfig
+	reconnects chan struct{}
+	votes      chan Tweet // from the webhook to Start, never closed
+
+	mu          sync.Mutex
+	options     map[string]string // by lowercase option, nil until loaded
+	listed      []string          // the options, as the help lists them
+	running     bool
+	pausedUntil time.Time
+}
+
+// NewSMS creates an SMS source from cfg
+func NewSMS(cfg SMSConfig) (*SMS, error) {
+	if cfg.AuthToken == "" {
+		return nil, fmt.Errorf("stream: the SMS webhook needs the Twilio auth token to check requests")
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = 10 * time.Second
+	}
+	return &SMS{
+		cfg:        cfg,
+		reconnects: make(chan struct{}, 1),
+		votes:      make(chan Tweet),
+	}, nil
+}
+
+// Reconnect reloads the options
+func (s *SMS) Reconnect() {
+	select {
+	case s.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause refuses votes for d
+func (s *SMS) Pause(d time.Duration) {
+	s.mu.Lock()
+	s.pausedUntil = time.Now().Add(d)
+	s.mu.Unlock()
+	log.Println("Pausing SMS votes for", d)
+}
+
+// Resume lifts a pause
+func (s *SMS) Resume() {
+	s.mu.Lock()
+	s.pausedUntil = time.Time{}
+	s.mu.Unlock()
+	log.Println("Resuming SMS votes")
+}
+
+// Start passes the votes the webhook takes on tweets until stopchan is signalled, like Stream.Start
+func (s *SMS) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	s.mu.Lock()
+	s.running = true
+	s.mu.Unlock()
+	go func() {
+		defer func() {
+			s.mu.Lock()
+			s.running = false
+			s.mu.Unlock()
+			log.Println("Stopping SMS votes...")
+			stoppedchan <- struct{}{}
+		}()
+		reload := time.After(0)
+		for {
+			select {
+			case <-stopchan:
+				return
+			case <-s.reconnects:
+				reload = time.After(0)
+			case <-reload:
+				reload = nil
+				if err := s.loadOptions(); err != nil {
+					log.Println("Failed to load options:", err)
+					reload = time.After(10 * time.Second)
+				}
+			case t := <-s.votes:
+				select {
+				case tweets <- t:
+				case <-stopchan:
+					log.Println("SMS vote lost stopping:", t.ID)
+					return
+				}
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+func (s *SMS) loadOptions() error {
+	options, err := s.cfg.Options()
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string, len(options))
+	var listed []string
+	for _, o := range options {
+		key := strings.ToLower(o)
+		if _, ok := byName[key]; !ok {
+			byName[key] = o
+			listed = append(listed, o)
+		}
+	}
+	log.Printf("Taking SMS votes for: %v", listed)
+	if s.cfg.OnConnect != nil {
+		s.cfg.OnConnect(options)
+	}
+	s.mu.Lock()
+	s.options, s.listed = byName, listed
+	s.mu.Unlock()
+	return nil
+}
+
+// ServeHTTP takes a message from Twilio's webhook, answering with the text
+// sent back to the voter
+func (s *SMS) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := r.ParseForm(); err != nil {
+		http.Error(w, "invalid form", http.StatusBadRequest)
+		return
+	}
+	if !s.signed(r) {
+		smsMessages.Inc("result", "rejected")
+		http.Error(w, "invalid signature", http.StatusForbidden)
+		return
+	}
+	from, id := r.PostForm.Get("From"), r.PostForm.Get("MessageSid")
+	if from == "" || id == "" {
+		http.Error(w, "missing From or MessageSid", http.StatusBadRequest)
+		return
+	}
+	reply := func(result, text string) {
+		smsMessages.Inc("result", result)
+		w.Header().Set("Content-Type", "text/xml")
+		fmt.Fprint(w, xml.Header+"<Response><Message>")
+		xml.EscapeText(w, []byte(text))
+		fmt.Fprint(w, "</Message></Response>")
+	}
+
+	s.mu.Lock()
+	open := s.running && s.options != nil && time.Now().After(s.pausedUntil)
+	options, listed := s.options, s.listed
+	s.mu.Unlock()
+	if !open {
+		reply("closed", "Voting is closed at the moment, please try again later.")
+		return
+	}
+	option, ok := "", false
+	if words := strings.Fields(r.PostForm.Get("Body")); len(words) > 1 && strings.EqualFold(words[0], SMSKeyword) {
+		option, ok = options[strings.ToLower(strings.TrimPrefix(strings.Join(words[1:], " "), "#"))]
+	}
+	if !ok {
+		reply("unknown", smsHelp(listed))
+		return
+	}
+	// the voter is known by a hash of their number, which isn't kept
+	sum := sha256.Sum256([]byte(from))
+	voter := hex.EncodeToString(sum[:12])
+	undo := func() error { return nil }
+	if s.cfg.Vote != nil {
+		counted, recorded, err := s.cfg.Vote(voter, option)
+		if err != nil {
+			log.Println("sms: failed to record a vote:", err)
+			reply("failed", "Sorry, your vote couldn't be counted, please try again later.")
+			return
+		}
+		if !counted {
+			reply("repeat", "You have already voted, only your first vote counts.")
+			return
+		}
+		undo = recorded
+	}
+	t := Tweet{ID: SMSIDPrefix + id, CreatedAt: time.Now().UTC().Format(time.RubyDate), Text: option}
+	t.User.ScreenName = voter
+	// a vote by text names its option on purpose, like a hashtag
+	t.Entities = &Entities{Hashtags: []Hashtag{{Text: strings.Replace(option, " ", "", -1)}}}
+	select {
+	case s.votes <- t:
+		reply("vote", fmt.Sprintf("Thanks, your vote for %s is counted.", option))
+	case <-time.After(s.cfg.Timeout):
+		log.Println("sms: timed out passing a vote on:", t.ID)
+		// not counted, so not a vote the one-vote rule refuses the retry for
+		if err := undo(); err != nil {
+			log.Println("sms: failed to forget a vote not counted:", err)
+		}
+		reply("failed", "Sorry, your vote couldn't be counted, please try again later.")
+	}
+}
+
+// smsHelp tells how to vote, listing up to maxListedOptions of the options
+func smsHelp(options []string) string {
+	if len(options) == 0 {
+		return "There is nothing to vote for at the moment."
+	}
+	more := ""
+	if len(options) > maxListedOptions {
+		options, more = options[:maxListedOptions], ", ..."
+	}
+	return fmt.Sprintf("To vote, reply %s followed by one of: %s%s", SMSKeyword, strings.Join(options, ", "), more)
+}
+
+// signed checks the X-Twilio-Signature of r: the base64 HMAC-SHA1, keyed by
+// the auth token, of the URL followed by the form's keys and values sorted by key
+func (s *SMS) signed(r *http.Request) bool {
+	u := s.cfg.URL
+	if u == "" {
+		scheme := "http"
+		if r.TLS != nil {
+			scheme = "https"
+		}
+		if p := r.Header.Get("X-Forwarded-Proto"); p != "" {
+			scheme = p
+		}
+		u = scheme + "://" + r.Host + r.URL.RequestURI()
+	}
+	keys := make([]string, 0, len(r.PostForm))
+	for k := range r.PostForm {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	mac := hmac.New(sha1.New, []byte(s.cfg.AuthToken))
+	mac.Write([]byte(u))
+	for _, k := range keys {
+		for _, v := range r.PostForm[k] {
+			mac.Write([]byte(k + v))
+		}
+	}
+	want := base64.StdEncoding.EncodeToString(mac.Sum(nil))
+	return hmac.Equal([]byte(want), []byte(r.Header.Get("X-Twilio-Signature")))
+}
@@ -0,0 +1,44 @@
+package stream
+
+import "strings"
+
+// Sources of tweets, as the votes they cast name them
+const (
+	SourceTwitter   = "twitter"
+	SourceYouTube   = "youtube"
+	SourceTwitch    = "twitch"
+	SourceTelegram  = "telegram"
+	SourceFeeds     = "feeds"
+	SourceSMS       = "sms"
+	SourceSynthetic = "synthetic"
+)
+
+// sourcePrefixes are the prefixes of the IDs of the tweets that aren't from Twitter, by source
+var sourcePrefixes = map[string]string{
+	SourceYouTube:  YouTubeIDPrefix,
+	SourceTwitch:   TwitchIDPrefix,
+	SourceTelegram: TelegramIDPrefix,
+	SourceFeeds:    FeedIDPrefix,
+	SourceSMS:      SMSIDPrefix,
+}
+
+// SourceOf returns the source of the tweet with the ID, told by its prefix.
+// Tweets without one are SourceTwitter, synthetic tweets included.
+func SourceOf(id string) string {
+	for source, prefix := range sourcePrefixes {
+		if strings.HasPrefix(id, prefix) {
+			return source
+		}
+	}
+	return SourceTwitter
+}
+
+// Resumable is a source that can go on from where an earlier run left off,
+// instead of reading again what it read already or skipping what came
+// meanwhile. What it saves is its own, the caller keeps it.
+type Resumable interface {
+	// State returns where the source is
+	State() ([]byte, error)
+	// Restore makes the source go on from a State, before it starts
+	Restore(state []byte) error
+}
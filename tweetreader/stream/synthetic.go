@@ -0,0 +1,212 @@
+package stream
+
+import (
+	"fmt"
+	"log"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Distributions of the options in synthetic tweets
+const (
+	// Uniform picks every option as often as the others
+	Uniform = "uniform"
+	// Zipf makes the first option the most popular, the second half as
+	// popular and so on, like a real poll with a clear leader
+	Zipf = "zipf"
+)
+
+// SyntheticConfig describes the tweets a Synthetic source generates
+type SyntheticConfig struct {
+	// Options returns the options to vote for, it is called every time the source (re)connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// Rate is how many tweets are generated per second
+	Rate float64
+	// Distribution is Uniform (the default) or Zipf
+	Distribution string
+	// Multi is the fraction of tweets that name a second option
+	Multi float64
+}
+
+// Synthetic generates fake tweets voting for the poll options, so the rest of
+// the pipeline can be load tested without Twitter. It can stand in for a Stream.
+type Synthetic struct {
+	cfg        SyntheticConfig
+	reconnects chan struct{}
+	rand       *rand.Rand
+	lastID     int64
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewSynthetic creates a Synthetic source from cfg
+func NewSynthetic(cfg SyntheticConfig) (*Synthetic, error) {
+	if cfg.Rate <= 0 {
+		return nil, fmt.Errorf("stream: synthetic rate must be positive")
+	}
+	switch cfg.Distribution {
+	case "":
+		cfg.Distribution = Uniform
+	case Uniform, Zipf:
+	default:
+		return nil, fmt.Errorf("stream: unknown distribution %q, want %s or %s", cfg.Distribution, Uniform, Zipf)
+	}
+	return &Synthetic{
+		cfg:        cfg,
+		reconnects: make(chan struct{}, 1),
+		rand:       rand.New(rand.NewSource(time.Now().UnixNano())),
+		// unique across restarts, so the counter doesn't drop the tweets of a restarted source as duplicates
+		lastID: time.Now().UnixNano(),
+	}, nil
+}
+
+// Reconnect reloads the options
+func (s *Synthetic) Reconnect() {
+	select {
+	case s.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops generating tweets for d
+func (s *Synthetic) Pause(d time.Duration) {
+	s.mu.Lock()
+	s.pausedUntil = time.Now().Add(d)
+	s.mu.Unlock()
+	log.Println("Pausing synthetic tweets for", d)
+	s.Reconnect()
+}
+
+// Resume lifts a pause
+func (s *Synthetic) Resume() {
+	s.mu.Lock()
+	s.pausedUntil = time.Time{}
+	s.mu.Unlock()
+	log.Println("Resuming synthetic tweets")
+	s.Reconnect()
+}
+
+func (s *Synthetic) pause() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Until(s.pausedUntil)
+}
+
+// Start generates tweets on tweets until stopchan is signalled, like Stream.Start
+func (s *Synthetic) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		for {
+			wait := s.pause()
+			if wait <= 0 {
+				stopped, err := s.generate(stopchan, tweets)
+				if stopped {
+					log.Println("Stopping synthetic tweets...")
+					return
+				}
+				if err == nil {
+					continue
+				}
+				wait = 10 * time.Second // wait before reconnecting
+			}
+			select {
+			case <-stopchan:
+				log.Println("Stopping synthetic tweets...")
+				return
+			case <-time.After(wait):
+			case <-s.reconnects:
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// generate sends tweets for the current options until a reconnect, a stop
+// or a failure to load the options
+func (s *Synthetic) generate(stopchan <-chan struct{}, tweets chan<- Tweet) (stopped bool, err error) {
+	options, err := s.cfg.Options()
+	if err == nil && len(options) == 0 {
+		err = fmt.Errorf("no options")
+	}
+	if err != nil {
+		log.Println("Failed to load options:", err)
+		return false, err
+	}
+	log.Printf("Generating %.0f tweets/s for: %v", s.cfg.Rate, options)
+	if s.cfg.OnConnect != nil {
+		s.cfg.OnConnect(options)
+	}
+	pick := s.picker(len(options))
+	start := time.Now()
+	sent := 0
+	tick := time.NewTicker(10 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		select {
+		case <-stopchan:
+			return true, nil
+		case <-s.reconnects:
+			return false, nil
+		case <-tick.C:
+		}
+		// catch up on what is due, a blocked send means the matcher can't keep up
+		for due := int(s.cfg.Rate * time.Since(start).Seconds()); sent < due; sent++ {
+			select {
+			case tweets <- s.tweet(options, pick):
+			case <-stopchan:
+				return true, nil
+			}
+		}
+	}
+}
+
+// picker returns a function picking the index of an option out of n
+func (s *Synthetic) picker(n int) func() int {
+	if s.cfg.Distribution != Zipf {
+		return func() int { return s.rand.Intn(n) }
+	}
+	// the i-th option weighs 1/(i+1)
+	cumulative := make([]float64, n)
+	var total float64
+	for i := range cumulative {
+		total += 1 / float64(i+1)
+		cumulative[i] = total
+	}
+	return func() int {
+		if i := sort.SearchFloat64s(cumulative, s.rand.Float64()*total); i < n {
+			return i
+		}
+		return n - 1
+	}
+}
+
+// tweet votes for one option, or two for a Multi share of the tweets.
+// Handles are mentioned, with the entities Twitter would have parsed.
+func (s *Synthetic) tweet(options []string, pick func() int) Tweet {
+	s.lastID++
+	picked := []string{options[pick()]}
+	if s.rand.Float64() < s.cfg.Multi {
+		picked = append(picked, options[pick()])
+	}
+	t := Tweet{ID: strconv.FormatInt(s.lastID, 10), CreatedAt: time.Now().Format(time.RubyDate), Text: "voting for " + strings.Join(picked, " and ")}
+	t.User.ScreenName = "synthetic" + strconv.Itoa(s.rand.Intn(10000))
+	for _, o := range picked {
+		if IsHandle(o) {
+			if t.Entities == nil {
+				t.Entities = &Entities{}
+			}
+			t.Entities.UserMentions = append(t.Entities.UserMentions, Mention{ScreenName: o[1:]})
+		}
+	}
+	return t
+}
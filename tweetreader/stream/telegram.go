@@ -0,0 +1,345 @@
+package stream
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// TelegramURL is the base of the Telegram Bot API
+const TelegramURL = "https://api.telegram.org"
+
+// TelegramIDPrefix prefixes the IDs of the tweets made of Telegram messages,
+// so they never collide with a tweet's
+const TelegramIDPrefix = "telegram:"
+
+var telegramMessages = metrics.NewCounter("tweetreader_telegram_messages_total",
+	"Telegram messages read, by chat.")
+
+// TelegramConfig describes the chats a Telegram source listens to
+type TelegramConfig struct {
+	// URL of the Bot API, TelegramURL when empty
+	URL string
+	// Token of the bot, which has to be a member of the chats
+	Token string
+	// Chats are the groups and channels listened to, by ID or @username;
+	// the messages of other chats the bot is in are ignored
+	Chats []string
+	// Options returns the options to vote for, it is called every time the source (re)connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// PollTimeout is how long a request for updates waits for one, 50s by default
+	PollTimeout time.Duration
+	// Transport configures the connections to Telegram
+	Transport TransportConfig
+}
+
+// Telegram reads the messages posted in Telegram groups and channels as
+// tweets, through a bot polling for its updates. It can stand in for a Stream.
+type Telegram struct {
+	cfg        TelegramConfig
+	client     *http.Client
+	chats      map[string]string // the configured chat, by lowercase ID or @username
+	reconnects chan struct{}
+	offset     int64 // of the next update, so none is read twice; set with mu held
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewTelegram creates a Telegram source from cfg
+func NewTelegram(cfg TelegramConfig) (*Telegram, error) {
+	if cfg.Token == "" {
+		return nil, fmt.Errorf("stream: listening to Telegram needs a bot token")
+	}
+	if len(cfg.Chats) == 0 {
+		return nil, fmt.Errorf("stream: no Telegram chats to listen to")
+	}
+	if cfg.URL == "" {
+		cfg.URL = TelegramURL
+	}
+	if cfg.PollTimeout <= 0 {
+		cfg.PollTimeout = 50 * time.Second
+	}
+	chats := make(map[string]string, len(cfg.Chats))
+	for _, c := range cfg.Chats {
+		chats[strings.ToLower(c)] = c
+	}
+	return &Telegram{
+		cfg:        cfg,
+		client:     &http.Client{Transport: cfg.Transport.transport(nil)},
+		chats:      chats,
+		reconnects: make(chan struct{}, 1),
+	}, nil
+}
+
+// Reconnect reloads the options
+func (t *Telegram) Reconnect() {
+	select {
+	case t.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops reading the chats for d, their messages are read once it is over
+func (t *Telegram) Pause(d time.Duration) {
+	t.mu.Lock()
+	t.pausedUntil = time.Now().Add(d)
+	t.mu.Unlock()
+	log.Println("Pausing Telegram chats for", d)
+	t.Reconnect()
+}
+
+// Resume lifts a pause
+func (t *Telegram) Resume() {
+	t.mu.Lock()
+	t.pausedUntil = time.Time{}
+	t.mu.Unlock()
+	log.Println("Resuming Telegram chats")
+	t.Reconnect()
+}
+
+func (t *Telegram) pause() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Until(t.pausedUntil)
+}
+
+// Start reads the chats on tweets until stopchan is signalled, like Stream.Start
+func (t *Telegram) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		for {
+			wait := t.pause()
+			if wait <= 0 {
+				stopped, err := t.read(stopchan, tweets)
+				if stopped {
+					log.Println("Stopping Telegram chats...")
+					return
+				}
+				if err == nil {
+					continue
+				}
+				log.Println("Telegram chats failed:", err)
+				wait = 10 * time.Second // wait before reconnecting
+			}
+			select {
+			case <-stopchan:
+				log.Println("Stopping Telegram chats...")
+				return
+			case <-time.After(wait):
+			case <-t.reconnects:
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// telegramCheckpoint is the state of a Telegram source
+type telegramCheckpoint struct {
+	Offset int64 `json:"offset"`
+}
+
+// State returns the offset of the next update
+func (t *Telegram) State() ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return json.Marshal(telegramCheckpoint{Offset: t.offset})
+}
+
+// Restore makes the bot ask for the updates after the ones an earlier run read,
+// Telegram would send the last of them again otherwise
+func (t *Telegram) Restore(state []byte) error {
+	var c telegramCheckpoint
+	if err := json.Unmarshal(state, &c); err != nil {
+		return fmt.Errorf("stream: unreadable Telegram state: %v", err)
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.offset = c.Offset
+	return nil
+}
+
+// read polls for the bot's updates and sends the messages of the chats on
+// tweets until a reconnect, a stop or a failure
+func (t *Telegram) read(stopchan <-chan struct{}, tweets chan<- Tweet) (stopped bool, err error) {
+	options, err := t.cfg.Options()
+	if err != nil {
+		return false, fmt.Errorf("failed to load options: %v", err)
+	}
+	log.Printf("Reading %d Telegram chats for: %v", len(t.chats), options)
+	if t.cfg.OnConnect != nil {
+		t.cfg.OnConnect(options)
+	}
+
+	// a stop or a reconnect interrupts the request waiting for updates
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	why := make(chan bool, 1) // true for a stop
+	go func() {
+		select {
+		case <-stopchan:
+			why <- true
+		case <-t.reconnects:
+			why <- false
+		case <-ctx.Done():
+			return
+		}
+		cancel()
+	}()
+	for {
+		updates, err := t.updates(ctx)
+		if err != nil {
+			select {
+			case stopped := <-why:
+				return stopped, nil
+			default:
+				return false, err
+			}
+		}
+		for _, u := range updates {
+			t.mu.Lock()
+			t.offset = u.UpdateID + 1
+			t.mu.Unlock()
+			tweet, chat, ok := t.tweet(u)
+			if !ok {
+				continue
+			}
+			telegramMessages.Inc("chat", chat)
+			select {
+			case tweets <- tweet:
+			case <-ctx.Done():
+				return <-why, nil
+			}
+		}
+	}
+}
+
+// telegramUpdate is an update of the bot, only messages are votes
+type telegramUpdate struct {
+	UpdateID    int64            `json:"update_id"`
+	Message     *telegramMessage `json:"message"`
+	ChannelPost *telegramMessage `json:"channel_post"`
+}
+
+// telegramMessage is a message posted in a chat
+type telegramMessage struct {
+	MessageID int64  `json:"message_id"`
+	Date      int64  `json:"date"`
+	Text      string `json:"text"`
+	Caption   string `json:"caption"`
+	From      *struct {
+		ID        int64  `json:"id"`
+		FirstName string `json:"first_name"`
+		LastName  string `json:"last_name"`
+		Username  string `json:"username"`
+	} `json:"from"`
+	Chat telegramChat `json:"chat"`
+	// SenderChat posted the message on behalf of a channel or group
+	SenderChat *telegramChat `json:"sender_chat"`
+}
+
+// telegramChat is a group, channel or private chat
+type telegramChat struct {
+	ID       int64  `json:"id"`
+	Title    string `json:"title"`
+	Username string `json:"username"`
+}
+
+// tweet returns the message of u as a tweet and the configured chat it was
+// posted in, false for anything else. The author's username, or ID without
+// one, stands in for the screen name.
+func (t *Telegram) tweet(u telegramUpdate) (Tweet, string, bool) {
+	m := u.Message
+	if m == nil {
+		m = u.ChannelPost
+	}
+	if m == nil {
+		return Tweet{}, "", false
+	}
+	chat, ok := t.chats[strconv.FormatInt(m.Chat.ID, 10)]
+	if !ok && m.Chat.Username != "" {
+		chat, ok = t.chats["@"+strings.ToLower(m.Chat.Username)]
+	}
+	text := m.Text
+	if text == "" {
+		text = m.Caption
+	}
+	if !ok || text == "" {
+		return Tweet{}, "", false
+	}
+	tweet := Tweet{
+		ID:        fmt.Sprintf("%s%d:%d", TelegramIDPrefix, m.Chat.ID, m.MessageID),
+		CreatedAt: time.Unix(m.Date, 0).UTC().Format(time.RubyDate),
+		Text:      text,
+	}
+	switch {
+	case m.SenderChat != nil:
+		tweet.User.Name = m.SenderChat.Title
+		tweet.User.ScreenName = m.SenderChat.Username
+		if tweet.User.ScreenName == "" {
+			tweet.User.ScreenName = strconv.FormatInt(m.SenderChat.ID, 10)
+		}
+	case m.From != nil:
+		tweet.User.Name = strings.TrimSpace(m.From.FirstName + " " + m.From.LastName)
+		tweet.User.ScreenName = m.From.Username
+		if tweet.User.ScreenName == "" {
+			tweet.User.ScreenName = strconv.FormatInt(m.From.ID, 10)
+		}
+	default:
+		// channel posts without a signature
+		tweet.User.Name = m.Chat.Title
+		tweet.User.ScreenName = strconv.FormatInt(m.Chat.ID, 10)
+	}
+	return tweet, chat, true
+}
+
+// updates waits for the bot's updates after the offset
+func (t *Telegram) updates(ctx context.Context) ([]telegramUpdate, error) {
+	q := url.Values{
+		"offset":          {strconv.FormatInt(t.offset, 10)},
+		"timeout":         {strconv.Itoa(int(t.cfg.PollTimeout / time.Second))},
+		"allowed_updates": {`["message","channel_post"]`},
+	}
+	req, err := http.NewRequest("GET", t.cfg.URL+"/bot"+t.cfg.Token+"/getUpdates?"+q.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("telegram: invalid URL")
+	}
+	// the long poll and the time to answer it
+	ctx, cancel := context.WithTimeout(ctx, t.cfg.PollTimeout+t.cfg.Transport.searchTimeout())
+	defer cancel()
+	resp, err := t.client.Do(req.WithContext(ctx))
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			// the URL has the token in it
+			err = ue.Err
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+	var answer struct {
+		OK          bool             `json:"ok"`
+		Description string           `json:"description"`
+		Result      []telegramUpdate `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("telegram: %s: %v", resp.Status, err)
+	}
+	if !answer.OK {
+		return nil, fmt.Errorf("telegram: %s: %s", resp.Status, answer.Description)
+	}
+	return answer.Result, nil
+}
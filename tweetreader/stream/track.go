@@ -0,0 +1,93 @@
+package stream
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// Twitter's rules for the track parameter
+const (
+	// MaxTrack is the number of terms one stream tracks
+	MaxTrack = 400
+	// maxTermBytes is the longest term Twitter tracks
+	maxTermBytes = 60
+)
+
+// FitTrack splits options, in the order given, where the terms Twitter tracks
+// for them run over limit, MaxTrack when 0: the ones before are tracked, the
+// rest left over. Options Twitter can't track take no terms.
+func FitTrack(options []string, limit int) (tracked, over []string) {
+	if limit <= 0 {
+		limit = MaxTrack
+	}
+	seen := make(map[string]bool, len(options))
+	var n int
+	for i, o := range options {
+		if TermError(o) != nil {
+			continue
+		}
+		if n += len(newTerms(o, seen)); n > limit {
+			return options[:i:i], options[i:]
+		}
+	}
+	return options, nil
+}
+
+// commonWords are options so frequent they track a large share of all tweets,
+// which buries the votes under rate limited deliveries
+var commonWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "at": true, "be": true, "for": true, "i": true,
+	"in": true, "is": true, "it": true, "me": true, "my": true, "of": true, "on": true, "or": true,
+	"rt": true, "that": true, "the": true, "this": true, "to": true, "with": true, "you": true,
+}
+
+// TermError returns why Twitter can't track option, nil when it can
+func TermError(option string) error {
+	term := strings.TrimSpace(option)
+	switch {
+	case term == "":
+		return fmt.Errorf("option %q is blank", option)
+	case strings.Contains(term, ","):
+		return fmt.Errorf("option %q has a comma, which separates the terms Twitter tracks", option)
+	case len(term) > maxTermBytes:
+		return fmt.Errorf("option %q is %d bytes, Twitter tracks terms of up to %d", option, len(term), maxTermBytes)
+	case strings.HasPrefix(term, "@") && !IsHandle(term):
+		return fmt.Errorf("option %q isn't a valid handle, up to 15 letters, digits and underscores", option)
+	}
+	return nil
+}
+
+// TermWarnings returns why tracking option, though possible, is likely to
+// count more than the votes for it
+func TermWarnings(option string) []string {
+	var warnings []string
+	term := strings.ToLower(strings.TrimSpace(option))
+	if commonWords[term] {
+		warnings = append(warnings, fmt.Sprintf("option %q is a common word, tracking it streams a large share of all tweets", option))
+	} else if words := textnorm.PhraseWords(textnorm.Fold(term)); words != nil {
+		common := true
+		for _, w := range words {
+			common = common && commonWords[w]
+		}
+		if common {
+			warnings = append(warnings, fmt.Sprintf("option %q only has common words, Twitter streams every tweet with all of them in any order", option))
+		}
+	} else if utf8.RuneCountInString(term) < 3 && isWord(term) {
+		warnings = append(warnings, fmt.Sprintf("option %q is short, it also counts for the words it is part of", option))
+	}
+	return warnings
+}
+
+// isWord reports whether s only has letters and digits, emoji options are short on purpose
+func isWord(s string) bool {
+	for _, r := range s {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			return false
+		}
+	}
+	return s != ""
+}
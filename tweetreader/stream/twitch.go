@@ -0,0 +1,369 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// TwitchAddr is the address of Twitch's chat server, over TLS
+const TwitchAddr = "irc.chat.twitch.tv:6697"
+
+// TwitchIDPrefix prefixes the IDs of the tweets made of chat messages, so
+// they never collide with a tweet's
+const TwitchIDPrefix = "twitch:"
+
+var (
+	twitchMessages = metrics.NewCounter("tweetreader_twitch_messages_total",
+		"Twitch chat messages read, by channel.")
+	twitchConnected = metrics.NewGauge("tweetreader_twitch_connected",
+		"1 while connected to Twitch chat, 0 otherwise.")
+)
+
+// TwitchConfig describes the chats a Twitch source joins
+type TwitchConfig struct {
+	// Addr of the chat server, TwitchAddr when empty
+	Addr string
+	// Plain connects to Addr without TLS, for local chat servers
+	Plain bool
+	// Nick and Token log in to chat; without a Token chat is read anonymously
+	Nick  string
+	Token string
+	// Channels are the channels whose chats are read
+	Channels []string
+	// Options returns the options to vote for, it is called every time the source (re)connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options before any tweets for them are sent
+	OnConnect func(options []string)
+	// Transport configures the connection to Twitch, its StallTimeout is 6m
+	// by default as Twitch pings every 5m
+	Transport TransportConfig
+}
+
+// Twitch reads the messages of Twitch chats as tweets, joining the channels
+// over IRC. It can stand in for a Stream.
+type Twitch struct {
+	cfg        TwitchConfig
+	channels   []string
+	reconnects chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+}
+
+// NewTwitch creates a Twitch source from cfg
+func NewTwitch(cfg TwitchConfig) (*Twitch, error) {
+	if len(cfg.Channels) == 0 {
+		return nil, fmt.Errorf("stream: no Twitch channels to join")
+	}
+	if cfg.Token != "" && cfg.Nick == "" {
+		return nil, fmt.Errorf("stream: logging in to Twitch chat needs a nick")
+	}
+	if cfg.Token == "" {
+		// anonymous logins are justinfan and any number
+		cfg.Nick = fmt.Sprintf("justinfan%d", 10000+time.Now().UnixNano()%90000)
+	} else if !strings.HasPrefix(cfg.Token, "oauth:") {
+		cfg.Token = "oauth:" + cfg.Token
+	}
+	if cfg.Addr == "" {
+		cfg.Addr = TwitchAddr
+	}
+	if cfg.Transport.StallTimeout <= 0 {
+		cfg.Transport.StallTimeout = 6 * time.Minute
+	}
+	channels := make([]string, len(cfg.Channels))
+	for i, c := range cfg.Channels {
+		channels[i] = "#" + strings.ToLower(strings.TrimPrefix(c, "#"))
+	}
+	return &Twitch{
+		cfg:        cfg,
+		channels:   channels,
+		reconnects: make(chan struct{}, 1),
+	}, nil
+}
+
+// Reconnect reloads the options and joins the chats again
+func (t *Twitch) Reconnect() {
+	select {
+	case t.reconnects <- struct{}{}:
+	default:
+	}
+}
+
+// Pause leaves the chats for d
+func (t *Twitch) Pause(d time.Duration) {
+	t.mu.Lock()
+	t.pausedUntil = time.Now().Add(d)
+	t.mu.Unlock()
+	log.Println("Pausing Twitch chat for", d)
+	t.Reconnect()
+}
+
+// Resume lifts a pause
+func (t *Twitch) Resume() {
+	t.mu.Lock()
+	t.pausedUntil = time.Time{}
+	t.mu.Unlock()
+	log.Println("Resuming Twitch chat")
+	t.Reconnect()
+}
+
+func (t *Twitch) pause() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return time.Until(t.pausedUntil)
+}
+
+// Start reads the chats on tweets until stopchan is signalled, like Stream.Start
+func (t *Twitch) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	go func() {
+		defer func() {
+			stoppedchan <- struct{}{}
+		}()
+		for {
+			wait := t.pause()
+			if wait <= 0 {
+				stopped, err := t.read(stopchan, tweets)
+				if stopped {
+					log.Println("Stopping Twitch chat...")
+					return
+				}
+				if err == nil {
+					continue
+				}
+				log.Println("Twitch chat failed:", err)
+				wait = 10 * time.Second // wait before reconnecting
+			}
+			select {
+			case <-stopchan:
+				log.Println("Stopping Twitch chat...")
+				return
+			case <-time.After(wait):
+			case <-t.reconnects:
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// read joins the chats and sends their messages on tweets until a
+// reconnect, a stop or a failure
+func (t *Twitch) read(stopchan <-chan struct{}, tweets chan<- Tweet) (stopped bool, err error) {
+	options, err := t.cfg.Options()
+	if err != nil {
+		return false, fmt.Errorf("failed to load options: %v", err)
+	}
+	conn, err := t.dial()
+	if err != nil {
+		return false, err
+	}
+	defer conn.Close()
+	defer twitchConnected.Set(0)
+
+	if t.cfg.Token != "" {
+		fmt.Fprintf(conn, "PASS %s\r\n", t.cfg.Token)
+	}
+	fmt.Fprintf(conn, "NICK %s\r\n", t.cfg.Nick)
+	// the tags carry the messages' IDs and times
+	fmt.Fprint(conn, "CAP REQ :twitch.tv/tags\r\n")
+	if _, err := fmt.Fprintf(conn, "JOIN %s\r\n", strings.Join(t.channels, ",")); err != nil {
+		return false, err
+	}
+	log.Printf("Reading Twitch chat of %v for: %v", t.channels, options)
+	if t.cfg.OnConnect != nil {
+		t.cfg.OnConnect(options)
+	}
+
+	lines := make(chan ircMessage)
+	failed := make(chan error, 1)
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		r := bufio.NewReader(conn)
+		for {
+			conn.SetReadDeadline(time.Now().Add(t.cfg.Transport.StallTimeout))
+			line, err := r.ReadString('\n')
+			if err != nil {
+				failed <- err
+				return
+			}
+			m, ok := parseIRC(strings.TrimRight(line, "\r\n"))
+			if !ok {
+				continue
+			}
+			select {
+			case lines <- m:
+			case <-done:
+				return
+			}
+		}
+	}()
+	for {
+		var m ircMessage
+		select {
+		case <-stopchan:
+			return true, nil
+		case <-t.reconnects:
+			return false, nil
+		case err := <-failed:
+			if err == io.EOF {
+				err = io.ErrUnexpectedEOF
+			}
+			return false, err
+		case m = <-lines:
+		}
+		switch m.Command {
+		case "PING":
+			fmt.Fprintf(conn, "PONG :%s\r\n", m.param(0))
+		case "001":
+			twitchConnected.Set(1)
+		case "RECONNECT":
+			// Twitch is about to restart the server
+			return false, fmt.Errorf("asked to reconnect")
+		case "NOTICE":
+			if strings.Contains(strings.ToLower(m.param(1)), "authentication failed") || strings.Contains(m.param(1), "Improperly formatted auth") {
+				return false, fmt.Errorf("login rejected: %s", m.param(1))
+			}
+		case "PRIVMSG":
+			tweet := m.tweet()
+			twitchMessages.Inc("channel", strings.TrimPrefix(m.param(0), "#"))
+			select {
+			case tweets <- tweet:
+			case <-stopchan:
+				return true, nil
+			}
+		}
+	}
+}
+
+// dial connects to the chat server
+func (t *Twitch) dial() (net.Conn, error) {
+	timeout := t.cfg.Transport.DialTimeout
+	if timeout <= 0 {
+		timeout = 10 * time.Second
+	}
+	conn, err := net.DialTimeout("tcp", t.cfg.Addr, timeout)
+	if err != nil {
+		return nil, err
+	}
+	if t.cfg.Transport.WrapConn != nil {
+		conn = t.cfg.Transport.WrapConn(conn)
+	}
+	if t.cfg.Plain {
+		return conn, nil
+	}
+	cfg := &tls.Config{}
+	if t.cfg.Transport.TLS != nil {
+		cfg = t.cfg.Transport.TLS.Clone()
+	}
+	if cfg.ServerName == "" {
+		cfg.ServerName, _, _ = net.SplitHostPort(t.cfg.Addr)
+	}
+	tc := tls.Client(conn, cfg)
+	tc.SetDeadline(time.Now().Add(timeout))
+	if err := tc.Handshake(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	tc.SetDeadline(time.Time{})
+	return tc, nil
+}
+
+// ircMessage is a line from the chat server
+type ircMessage struct {
+	Tags    map[string]string
+	Prefix  string
+	Command string
+	Params  []string
+}
+
+// param returns the i-th parameter, "" when there are fewer
+func (m ircMessage) param(i int) string {
+	if i < len(m.Params) {
+		return m.Params[i]
+	}
+	return ""
+}
+
+// parseIRC parses an IRC line with its IRCv3 tags, false when it has no command
+func parseIRC(line string) (ircMessage, bool) {
+	var m ircMessage
+	if strings.HasPrefix(line, "@") {
+		var tags string
+		tags, line = cut(line[1:], " ")
+		m.Tags = make(map[string]string)
+		for _, tag := range strings.Split(tags, ";") {
+			k, v := cut(tag, "=")
+			m.Tags[k] = unescapeTag(v)
+		}
+	}
+	if strings.HasPrefix(line, ":") {
+		m.Prefix, line = cut(line[1:], " ")
+	}
+	for line != "" {
+		if strings.HasPrefix(line, ":") {
+			m.Params = append(m.Params, line[1:])
+			break
+		}
+		var p string
+		p, line = cut(line, " ")
+		if m.Command == "" {
+			m.Command = p
+		} else if p != "" {
+			m.Params = append(m.Params, p)
+		}
+	}
+	return m, m.Command != ""
+}
+
+// cut splits s around the first sep, the rest is "" without one
+func cut(s, sep string) (string, string) {
+	if i := strings.Index(s, sep); i >= 0 {
+		return s[:i], s[i+len(sep):]
+	}
+	return s, ""
+}
+
+// tagEscapes undoes the escaping of IRCv3 tag values
+var tagEscapes = strings.NewReplacer(`\:`, ";", `\s`, " ", `\\`, `\`, `\r`, "\r", `\n`, "\n")
+
+func unescapeTag(v string) string {
+	return tagEscapes.Replace(v)
+}
+
+// tweet returns a PRIVMSG as a tweet, the author's login as the screen name
+func (m ircMessage) tweet() Tweet {
+	login, _ := cut(m.Prefix, "!")
+	text := m.param(1)
+	// /me messages
+	if strings.HasPrefix(text, "\x01ACTION ") {
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "\x01ACTION "), "\x01")
+	}
+	created := time.Now()
+	if ms, err := strconv.ParseInt(m.Tags["tmi-sent-ts"], 10, 64); err == nil {
+		created = time.Unix(0, ms*int64(time.Millisecond))
+	}
+	id := m.Tags["id"]
+	if id == "" {
+		// without tags, the author and the time have to do
+		id = fmt.Sprintf("%s-%d", login, created.UnixNano())
+	}
+	t := Tweet{ID: TwitchIDPrefix + id, CreatedAt: created.UTC().Format(time.RubyDate), Text: text}
+	t.User.Name = m.Tags["display-name"]
+	if t.User.Name == "" {
+		t.User.Name = login
+	}
+	t.User.ScreenName = login
+	t.User.Verified = strings.Contains(m.Tags["badges"], "partner/")
+	return t
+}
@@ -0,0 +1,723 @@
+// Package stream reads tweets from Twitter's streaming APIs.
+package stream
+
+import (
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garyburd/go-oauth/oauth"
+
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+	"github.com/olawolu/twitter-polls/tweetreader/budget"
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/events"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/tap"
+	"github.com/olawolu/twitter-polls/tweetreader/textnorm"
+)
+
+// DefaultURL is the v1.1 statuses/filter endpoint
+const DefaultURL = "https://stream.twitter.com/1.1/statuses/filter.json"
+
+// errUnauthorized is returned when Twitter rejects the stream credentials
+var errUnauthorized = errors.New("stream: credentials rejected (HTTP 401)")
+
+// errNotConnected is what a request that never got a stream going counts as
+var errNotConnected = errors.New("stream: no connection")
+
+// errForbidden is returned when the credentials aren't allowed to use the endpoint
+var errForbidden = errors.New("stream: access forbidden (HTTP 403)")
+
+// errStalled is returned when the stream went quiet for longer than the stall timeout
+var errStalled = errors.New("stream: stalled, nothing received")
+
+// Tweet structure
+type Tweet struct {
+	ID        string `json:"id_str"`
+	CreatedAt string `json:"created_at"`
+	Text      string `json:"text"`
+	User      struct {
+		// ID is the author's, what the compliance messages about authors name
+		ID             string `json:"id_str,omitempty"`
+		Name           string `json:"name"`
+		ScreenName     string `json:"screen_name"`
+		Verified       bool   `json:"verified"`
+		FollowersCount int    `json:"followers_count"`
+	} `json:"user"`
+	Coordinates *Point    `json:"coordinates,omitempty"`
+	Place       *Place    `json:"place,omitempty"`
+	Entities    *Entities `json:"entities,omitempty"`
+	// Truncated tweets have their whole text in ExtendedTweet or FullText, see Expand
+	Truncated       bool           `json:"truncated,omitempty"`
+	FullText        string         `json:"full_text,omitempty"`
+	ExtendedTweet   *ExtendedTweet `json:"extended_tweet,omitempty"`
+	RetweetedStatus *Tweet         `json:"retweeted_status,omitempty"`
+	QuotedStatus    *Tweet         `json:"quoted_status,omitempty"`
+	// InReplyToStatusID is set on replies, to the ID of the tweet replied to
+	InReplyToStatusID string `json:"in_reply_to_status_id_str,omitempty"`
+	// InReplyToScreenName is set on replies, to the author of the tweet replied to
+	InReplyToScreenName string `json:"in_reply_to_screen_name,omitempty"`
+	// Lang is the language of the text, as Twitter detected it: a BCP 47 code or "und"
+	Lang string `json:"lang,omitempty"`
+	// Undelivered is the share of the tweets matching the filter that Twitter
+	// didn't deliver lately on the connection this one came on, as its limit
+	// notices told; 0 when it delivered them all
+	Undelivered float64 `json:"undelivered,omitempty"`
+	// MatchingRules are the tags of the v2 filtered stream rules the tweet
+	// matched, none on the v1.1 stream
+	MatchingRules []string `json:"matching_rules,omitempty"`
+}
+
+// Entities are what Twitter parsed out of a tweet's text
+type Entities struct {
+	Hashtags     []Hashtag `json:"hashtags"`
+	UserMentions []Mention `json:"user_mentions,omitempty"`
+}
+
+// Hashtag is a hashtag in a tweet, Text doesn't include the #
+type Hashtag struct {
+	Text string `json:"text"`
+}
+
+// Credentials are the Twitter app and access token keys used to sign stream requests
+type Credentials struct {
+	ConsumerKey    string
+	ConsumerSecret string
+	AccessToken    string
+	AccessSecret   string
+}
+
+// Config describes what a Stream tracks and how it behaves
+type Config struct {
+	// URL of the filter endpoint, DefaultURL when empty
+	URL         string
+	Credentials Credentials
+	// Name labels the stream's connection in the metrics, e.g. the account, default when empty
+	Name string
+	// Options returns the terms to track, it is called every time the stream connects
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the terms being tracked before any tweets for them are sent
+	OnConnect func(terms []string)
+	// Searched, if set, returns the options left out of the filter, the stream
+	// looks them up with the search API every SearchEvery instead
+	Searched func() []string
+	// SearchEvery is how often the Searched options are looked up, 1m by default
+	SearchEvery time.Duration
+	// Locations, if set, returns the areas to stream tweets from as well, every time the stream connects
+	Locations func() []BoundingBox
+	// DuplicateCooldown is how long to back off when Twitter reports a duplicate connection,
+	// 0 disables the guard
+	DuplicateCooldown time.Duration
+	// DuplicatePatterns are extra error messages that mean a duplicate connection
+	DuplicatePatterns []string
+	// Events, if set, is told about rejected credentials and duplicate connections
+	Events *events.Bus
+	// Transport configures the connections to Twitter
+	Transport TransportConfig
+	// Breaker, if set, stops reconnecting for a while after repeated failed
+	// requests and rejected credentials
+	Breaker *breaker.Breaker
+	// Gzip asks Twitter to compress the stream, it is decompressed before
+	// the tweets are decoded
+	Gzip bool
+	// Compliance, if set, is told about every tweet deleted or withheld and
+	// every author protected, deleted, suspended or withheld Twitter reports
+	// in the stream; it is called from the loop reading it, so it mustn't block
+	Compliance func(Compliance)
+	// Framing is the kind of stream URL serves, FramingV1 when empty. A
+	// FramingV2 stream, DefaultV2URL by default, tracks the rules kept on
+	// Twitter's side rather than the options, and is authenticated with
+	// BearerToken, the app's.
+	Framing     string
+	BearerToken string
+	// Clock times the waits between reconnects, clock.Real when nil
+	Clock clock.Clock
+}
+
+// TransportConfig configures the HTTP connections to Twitter.
+// Zero values keep the defaults.
+type TransportConfig struct {
+	// Proxy picks the proxy for a request, http.ProxyFromEnvironment (HTTP_PROXY, HTTPS_PROXY and NO_PROXY) when nil
+	Proxy func(*http.Request) (*url.URL, error)
+	// TLS is used to connect to Twitter, e.g. to trust a private CA bundle or
+	// require a minimum version
+	TLS *tls.Config
+	// DialTimeout limits connecting to Twitter or the proxy, 10s by default
+	DialTimeout time.Duration
+	// TLSHandshakeTimeout limits the TLS handshake, 10s by default
+	TLSHandshakeTimeout time.Duration
+	// ResponseHeaderTimeout limits the wait for Twitter to answer a request, no limit by default
+	ResponseHeaderTimeout time.Duration
+	// StallTimeout is how long the stream may send nothing before it is
+	// dropped, 90s by default. Twitter sends a blank line every 30s.
+	StallTimeout time.Duration
+	// SearchTimeout limits a whole search request, 30s by default
+	SearchTimeout time.Duration
+	// WrapConn, when set, wraps the stream's connections, to inject faults
+	WrapConn func(net.Conn) net.Conn
+}
+
+// stallTimeout is StallTimeout or its default
+func (t TransportConfig) stallTimeout() time.Duration {
+	if t.StallTimeout <= 0 {
+		return 90 * time.Second
+	}
+	return t.StallTimeout
+}
+
+// searchTimeout is SearchTimeout or its default
+func (t TransportConfig) searchTimeout() time.Duration {
+	if t.SearchTimeout <= 0 {
+		return 30 * time.Second
+	}
+	return t.SearchTimeout
+}
+
+// transport builds the http.Transport described by t, with c tracking its connections when set
+func (t TransportConfig) transport(c *streamConn) *http.Transport {
+	proxy := t.Proxy
+	if proxy == nil {
+		proxy = http.ProxyFromEnvironment
+	}
+	dialTimeout, handshakeTimeout := t.DialTimeout, t.TLSHandshakeTimeout
+	if dialTimeout <= 0 {
+		dialTimeout = 10 * time.Second
+	}
+	if handshakeTimeout <= 0 {
+		handshakeTimeout = 10 * time.Second
+	}
+	d := &net.Dialer{Timeout: dialTimeout}
+	dial := d.DialContext
+	if c != nil {
+		dial = c.dialer(d, t.WrapConn)
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dial,
+		TLSClientConfig:       t.TLS,
+		TLSHandshakeTimeout:   handshakeTimeout,
+		ResponseHeaderTimeout: t.ResponseHeaderTimeout,
+	}
+}
+
+// maxLocations is the number of bounding boxes the filter endpoint accepts
+const maxLocations = 25
+
+// Stream reads tweets mentioning the tracked terms from Twitter
+type Stream struct {
+	cfg Config
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	resumed     chan struct{}      // wakes a paused stream up early
+	cancel      context.CancelFunc // interrupts the request being read, nil between requests
+	cause       string             // why the request was interrupted, see the Cause constants
+	userIDs     map[string]string  // of the handles followed, by HandleKey, "" for unknown accounts
+
+	authMu sync.Mutex // protects cfg.Credentials, auth and token, which change when credentials rotate
+	auth   *oauth.Client
+	token  *oauth.Credentials
+
+	conn         streamConn
+	client       *http.Client // for the stream, its connections are owned by conn
+	searchClient *http.Client // for searches, which mustn't drop the stream's connection
+}
+
+// New creates a Stream from cfg
+func New(cfg Config) *Stream {
+	if cfg.URL == "" {
+		cfg.URL = DefaultURL
+		if cfg.Framing == FramingV2 {
+			cfg.URL = DefaultV2URL
+		}
+	}
+	cfg.DuplicatePatterns = append(append([]string(nil), defaultDuplicateConnPatterns...), cfg.DuplicatePatterns...)
+	s := &Stream{cfg: cfg, resumed: make(chan struct{}, 1)}
+	s.setAuth(cfg.Credentials)
+	s.client = &http.Client{Transport: cfg.Transport.transport(&s.conn)}
+	s.searchClient = &http.Client{Transport: cfg.Transport.transport(nil), Timeout: cfg.Transport.searchTimeout()}
+	return s
+}
+
+// Reconnect drops the current connection, the stream reconnects with freshly loaded options
+func (s *Stream) Reconnect() {
+	s.interrupt(CauseRefresh)
+}
+
+// interrupt cancels the request being read, if any, for cause
+func (s *Stream) interrupt(cause string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cancel != nil {
+		s.cause = cause
+		s.cancel()
+	}
+}
+
+// interruption returns why the last request was interrupted, "" when it wasn't
+func (s *Stream) interruption() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	cause := s.cause
+	s.cause = ""
+	return cause
+}
+
+// reading makes cancel the one interrupt calls
+func (s *Stream) reading(cancel context.CancelFunc) {
+	s.mu.Lock()
+	s.cancel = cancel
+	s.mu.Unlock()
+}
+
+// Pause disconnects from Twitter and doesn't reconnect for d
+func (s *Stream) Pause(d time.Duration) {
+	s.mu.Lock()
+	s.pausedUntil = time.Now().Add(d)
+	s.mu.Unlock()
+	log.Println("Pausing Twitter for", d)
+	s.interrupt(CausePaused)
+}
+
+// Resume lifts a pause, the stream reconnects right away
+func (s *Stream) Resume() {
+	s.mu.Lock()
+	s.pausedUntil = time.Time{}
+	s.mu.Unlock()
+	select {
+	case s.resumed <- struct{}{}:
+	default:
+	}
+}
+
+// pause returns how much longer the stream is paused for
+func (s *Stream) pause() time.Duration {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return time.Until(s.pausedUntil)
+}
+
+// SetCredentials replaces the credentials requests are signed with,
+// reconnecting with them when they changed
+func (s *Stream) SetCredentials(c Credentials) {
+	s.authMu.Lock()
+	changed := c != s.cfg.Credentials
+	s.authMu.Unlock()
+	if !changed {
+		return
+	}
+	log.Println("Twitter credentials changed, reconnecting")
+	s.setAuth(c)
+	s.interrupt(CauseCredentials)
+}
+
+// setAuth signs the following requests with c
+func (s *Stream) setAuth(c Credentials) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.cfg.Credentials = c
+	s.token = &oauth.Credentials{
+		Token:  c.AccessToken,
+		Secret: c.AccessSecret,
+	}
+	s.auth = &oauth.Client{
+		Credentials: oauth.Credentials{
+			Token:  c.ConsumerKey,
+			Secret: c.ConsumerSecret,
+		},
+	}
+}
+
+// readFromTwitter takes a send only channel called tweets; this is how this function
+// will inform the rest of our program that it has read a tweet from Twitter.
+// It returns once ctx is done, the stream is interrupted or it ends.
+func (s *Stream) readFromTwitter(ctx context.Context, tweets chan<- Tweet) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s.reading(cancel)
+	defer s.reading(nil)
+
+	// build request object and query
+	req, query, err := s.buildQuery(ctx)
+	if err != nil {
+		log.Println(err)
+		return err
+	}
+
+	// Pass the query and request object to makeRequest
+	resp, err := s.makeRequest(req, query)
+	if err != nil {
+		if ctx.Err() != nil {
+			// stopped or interrupted while connecting
+			return nil
+		}
+		budget.Logf("stream", "making request failed: %v", err)
+		s.cfg.Breaker.Failure(err)
+		budget.Observe("stream", false)
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		resp.Body.Close()
+		budget.Observe("stream", false)
+		return s.responseError(resp, body)
+	}
+	s.cfg.Breaker.Success()
+	budget.Observe("stream", true)
+	streamUp()
+	defer streamDown()
+
+	// read the tweets a line at a time from the body of the request
+	defer resp.Body.Close()
+	stall := newStallReader(resp.Body, s.cfg.Transport.stallTimeout(), cancel)
+	defer stall.stop()
+	body, err := decodeBody(stall, resp.Header)
+	if err != nil {
+		budget.Logf("stream", "reading the stream failed: %v", err)
+		return err
+	}
+	name := s.cfg.Name
+	if name == "" {
+		name = "default"
+	}
+	frames := newFrames(body, name)
+	gaps := newGaps(name, time.Now())
+
+	for {
+		// read the next message first, so only decoding it is timed and not waiting for it
+		raw, err := frames.next()
+		if err != nil {
+			if err != io.EOF && ctx.Err() == nil && !stall.stalled() {
+				budget.Logf("stream", "reading the stream failed: %v", err)
+			}
+			break
+		}
+		start := time.Now()
+		var t Tweet
+		if s.cfg.Framing == FramingV2 {
+			ok, err := decodeEnvelope(raw, &t)
+			if err != nil {
+				log.Println(err)
+				tap.DecodeError(SourceTwitter, raw, err)
+			}
+			if !ok {
+				continue
+			}
+		} else {
+			if gaps.notice(raw, start) {
+				continue
+			}
+			if c, ok := compliance(raw); ok {
+				if c.Kind != "" && s.cfg.Compliance != nil {
+					s.cfg.Compliance(c)
+				}
+				continue
+			}
+			if err := json.Unmarshal(raw, &t); err != nil {
+				tap.DecodeError(SourceTwitter, raw, err)
+				continue
+			}
+		}
+		healthDecoded(time.Since(start))
+		gaps.tweet(start)
+		t.Undelivered = gaps.share()
+		select {
+		case tweets <- t:
+		case <-ctx.Done():
+		}
+	}
+	if stall.stalled() {
+		log.Printf("nothing received from Twitter for %s, reconnecting", s.cfg.Transport.stallTimeout())
+		return errStalled
+	}
+	return nil
+}
+
+// responseError turns a non-200 answer to the stream request into the error Start backs off for
+func (s *Stream) responseError(resp *http.Response, body []byte) error {
+	responseErrors.Inc("status", strconv.Itoa(resp.StatusCode))
+	if err := s.checkDuplicateConnection(resp.StatusCode, body); err != nil {
+		return err
+	}
+	httpErr := newHTTPError(resp, body)
+	log.Println(httpErr)
+	switch resp.StatusCode {
+	case http.StatusUnauthorized:
+		s.cfg.Events.Emit(events.StreamAuthFailure, resp.Status)
+		s.cfg.Breaker.Failure(errUnauthorized)
+		return errUnauthorized
+	case http.StatusForbidden:
+		s.cfg.Breaker.Failure(errForbidden)
+		return errForbidden
+	}
+	return httpErr
+}
+
+// Start takes in a recieve only channel (stopchan) to recieve signals on when the goroutine should stop.
+// A send only channel (tweets)
+func (s *Stream) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	registerSLO()
+	streamStarted()
+	// cancelling ctx interrupts the request being read as well as the waits
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-stopchan
+		log.Println("Stopping Twitter...")
+		cancel()
+	}()
+	searched := s.startSearching(ctx, tweets)
+	go func() {
+		defer func() {
+			// the tweets searched for go on the same channel, it's not sent on once stopped
+			<-searched
+			streamStopped()
+			stoppedchan <- struct{}{}
+		}()
+		var backoff reconnectBackoff
+		clk := clock.Or(s.cfg.Clock)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			default:
+				if d := s.pause(); d > 0 {
+					select {
+					case <-ctx.Done():
+						return
+					case <-clk.After(d):
+					case <-s.resumed:
+						log.Println("Resuming Twitter")
+					}
+					continue
+				}
+				if !s.cfg.Breaker.Allow() {
+					select {
+					case <-ctx.Done():
+						return
+					case <-clk.After(s.cfg.Breaker.Wait() + time.Second):
+					}
+					continue
+				}
+				log.Println("Querying Twitter...")
+				err := s.readFromTwitter(ctx, tweets)
+				cause := s.interruption()
+				if ctx.Err() != nil {
+					return
+				}
+				if cause == "" {
+					cause = causeOf(err)
+				}
+				healthReconnect(cause)
+				if err != nil {
+					s.cfg.Events.Emit(events.StreamDisconnected, cause+": "+err.Error())
+				} else {
+					s.cfg.Events.Emit(events.StreamDisconnected, cause)
+				}
+				if s.cfg.Breaker.State() == breaker.HalfOpen {
+					// the probe didn't get to a connection
+					if err == nil {
+						err = errNotConnected
+					}
+					s.cfg.Breaker.Failure(err)
+				}
+				if dup, ok := err.(*DuplicateConnectionError); ok {
+					s.alertDuplicateConnection(dup)
+					s.cfg.Events.Emit(events.StreamDuplicateConnection, dup.Error())
+					select {
+					case <-ctx.Done():
+						return
+					case <-clk.After(s.cfg.DuplicateCooldown):
+					}
+					continue
+				}
+				if err == nil {
+					backoff.reset()
+				}
+				wait := backoff.next(err)
+				log.Println(" (waiting", wait, "before reconnecting)")
+				select {
+				case <-ctx.Done():
+					return
+				case <-clk.After(wait):
+				}
+			}
+		}
+	}()
+	return stoppedchan
+}
+
+// buildQuery creates a request to the url endpoint with a query string
+func (s *Stream) buildQuery(ctx context.Context) (req *http.Request, query url.Values, err error) {
+	// load options from all the polls data
+	tracked, err := s.cfg.Options()
+	if err != nil {
+		log.Println("Failed to load options:")
+		return nil, nil, err
+	}
+	log.Println("vote:", tracked)
+	if s.cfg.OnConnect != nil {
+		s.cfg.OnConnect(tracked)
+	}
+
+	// create a url object
+	u, err := url.Parse(s.cfg.URL)
+	if err != nil {
+		log.Println("Failed to parse url:")
+		return nil, nil, err
+	}
+	if s.cfg.Framing == FramingV2 {
+		// the rules, see the rules command, say what is tracked
+		query = make(url.Values)
+		for k, v := range v2Fields {
+			query.Set(k, v)
+		}
+		u.RawQuery = query.Encode()
+		req, err = http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+		return req, query, err
+	}
+
+	// builld query string
+	query = make(url.Values)
+	query.Set("track", strings.Join(trackTerms(tracked), ","))
+	if ids := s.followIDs(ctx, tracked); len(ids) > 0 {
+		query.Set("follow", strings.Join(ids, ","))
+	}
+	if s.cfg.Locations != nil {
+		// Twitter ORs locations with track, the matcher still needs an option in the text
+		var boxes []string
+		for _, b := range s.cfg.Locations() {
+			boxes = append(boxes, b.String())
+		}
+		if len(boxes) > maxLocations {
+			log.Printf("tracking %d locations, Twitter only accepts %d", len(boxes), maxLocations)
+		}
+		if len(boxes) > 0 {
+			query.Set("locations", strings.Join(boxes, ","))
+		}
+	}
+
+	// build the request object
+	req, err = http.NewRequestWithContext(ctx, "POST", u.String(), strings.NewReader(query.Encode()))
+	if err != nil {
+		log.Println("Failed to create request object:")
+		return nil, nil, err
+	}
+	return req, query, nil
+}
+
+// trackTerms returns the terms to send to Twitter for the options:
+// each option as written and, when it differs by more than case, folded,
+// so an emoji is tracked with and without its variation selector and skin tone.
+// Handles are tracked without the @, which Twitter matches against mentions.
+// Phrases are tracked as their words separated by single spaces, tweets with
+// all of them, and the matcher checks their order.
+// Options Twitter can't track are left out, see TermError.
+func trackTerms(options []string) []string {
+	seen := make(map[string]bool, len(options))
+	var terms []string
+	for _, o := range options {
+		if err := TermError(o); err != nil {
+			log.Println("not tracking:", err)
+			continue
+		}
+		terms = append(terms, newTerms(o, seen)...)
+	}
+	if len(terms) > MaxTrack {
+		log.Printf("tracking %d terms, Twitter only accepts %d", len(terms), MaxTrack)
+	}
+	return terms
+}
+
+// newTerms returns the terms tracked for option that aren't in seen yet, and adds them
+func newTerms(option string, seen map[string]bool) []string {
+	if IsHandle(option) {
+		option = option[1:]
+	}
+	if words := textnorm.PhraseWords(textnorm.Fold(option)); words != nil {
+		option = strings.Join(words, " ") // punctuation would be part of the words it touches
+	}
+	var terms []string
+	for _, term := range []string{option, textnorm.Fold(option)} {
+		// Twitter ignores case
+		if key := strings.ToLower(term); term != "" && !seen[key] {
+			seen[key] = true
+			terms = append(terms, term)
+		}
+	}
+	return terms
+}
+
+func (s *Stream) makeRequest(req *http.Request, params url.Values) (*http.Response, error) {
+	if s.cfg.Framing == FramingV2 {
+		s.acceptEncoding(req)
+		req.Header.Set("Authorization", "Bearer "+s.cfg.BearerToken)
+		return s.client.Do(req)
+	}
+	formEnc := params.Encode()
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Content-Length", strconv.Itoa(len(formEnc)))
+	s.acceptEncoding(req)
+	s.sign(req, "POST", params)
+	return s.client.Do(req)
+}
+
+// acceptEncoding asks for the stream gzipped or not, set explicitly as the
+// transport decompresses on its own only what it asked for
+func (s *Stream) acceptEncoding(req *http.Request) {
+	if s.cfg.Gzip {
+		req.Header.Set("Accept-Encoding", "gzip")
+	} else {
+		req.Header.Set("Accept-Encoding", "identity")
+	}
+}
+
+// decodeBody returns the stream read from body, decompressed when header says Twitter gzipped it
+func decodeBody(body io.Reader, header http.Header) (io.Reader, error) {
+	wire := &countingReader{r: body, encoding: "wire"}
+	if !strings.EqualFold(header.Get("Content-Encoding"), "gzip") {
+		return wire, nil
+	}
+	gz, err := gzip.NewReader(wire)
+	if err != nil {
+		return nil, err
+	}
+	return &countingReader{r: gz, encoding: "decompressed"}, nil
+}
+
+var receivedBytes = metrics.NewCounter("tweetreader_stream_received_bytes_total",
+	"Bytes read from the stream, as sent on the wire and once decompressed when gzipped.")
+
+// countingReader counts the bytes read from r in receivedBytes
+type countingReader struct {
+	r        io.Reader
+	encoding string
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	if n > 0 {
+		receivedBytes.Add(float64(n), "encoding", c.encoding)
+	}
+	return n, err
+}
+
+// sign adds the OAuth header for the current credentials
+func (s *Stream) sign(req *http.Request, method string, params url.Values) {
+	s.authMu.Lock()
+	defer s.authMu.Unlock()
+	s.auth.SetAuthorizationHeader(req.Header, s.token, method, req.URL, params)
+}
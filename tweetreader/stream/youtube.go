@@ -0,0 +1,436 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+)
+
+// YouTubeURL is the base of the YouTube Data API
+const YouTubeURL = "https://www.googleapis.com/youtube/v3"
+
+// YouTubeIDPrefix prefixes the IDs of the tweets made of chat messages, so
+// they never collide with a tweet's
+const YouTubeIDPrefix = "yt:"
+
+var (
+	chatMessages = metrics.NewCounter("tweetreader_youtube_messages_total",
+		"YouTube live chat messages read, by video.")
+	chatErrors = metrics.NewCounter("tweetreader_youtube_errors_total",
+		"Failed requests for a video's live chat, by video.")
+)
+
+// YouTubeConfig describes the live chats a YouTube source reads
+type YouTubeConfig struct {
+	// URL of the YouTube Data API, YouTubeURL when empty
+	URL string
+	// APIKey authenticates the requests
+	APIKey string
+	// Videos are the IDs of the live streams whose chats are read
+	Videos []string
+	// Options returns the options to vote for, it is called as the source
+	// starts and on every Reconnect
+	Options func() ([]string, error)
+	// OnConnect, if set, is told the options once they are loaded
+	OnConnect func(options []string)
+	// MinInterval is the shortest wait between two reads of a chat, 1s by
+	// default; YouTube says how long to wait, and that is used when longer
+	MinInterval time.Duration
+	// RetryInterval is how long a video is waited for when it has no live chat
+	// or reading it failed, 30s by default
+	RetryInterval time.Duration
+	// Transport configures the connections to YouTube, its SearchTimeout limits each request
+	Transport TransportConfig
+}
+
+// YouTube reads the messages of YouTube live chats as tweets, so livestream
+// audiences can vote in chat. It can stand in for a Stream. Chat messages
+// are polled, at the interval YouTube asks for, rather than streamed.
+type YouTube struct {
+	cfg     YouTubeConfig
+	client  *http.Client
+	reloads chan struct{}
+
+	mu          sync.Mutex
+	pausedUntil time.Time
+	wake        chan struct{}         // closed when a pause starts or ends
+	chats       map[string]chatCursor // where the chat of each video is read, by video
+}
+
+// chatCursor is where a live chat is read, the page of the messages after the ones read
+type chatCursor struct {
+	Chat string `json:"chat"`
+	Page string `json:"page,omitempty"`
+}
+
+// NewYouTube creates a YouTube source from cfg
+func NewYouTube(cfg YouTubeConfig) (*YouTube, error) {
+	if cfg.APIKey == "" {
+		return nil, fmt.Errorf("stream: reading YouTube live chats needs an API key")
+	}
+	if len(cfg.Videos) == 0 {
+		return nil, fmt.Errorf("stream: no YouTube videos to read the live chats of")
+	}
+	if cfg.URL == "" {
+		cfg.URL = YouTubeURL
+	}
+	if cfg.MinInterval <= 0 {
+		cfg.MinInterval = time.Second
+	}
+	if cfg.RetryInterval <= 0 {
+		cfg.RetryInterval = 30 * time.Second
+	}
+	return &YouTube{
+		cfg:     cfg,
+		client:  &http.Client{Transport: cfg.Transport.transport(nil), Timeout: cfg.Transport.searchTimeout()},
+		reloads: make(chan struct{}, 1),
+		wake:    make(chan struct{}),
+		chats:   make(map[string]chatCursor),
+	}, nil
+}
+
+// Reconnect reloads the options, the chats are read on
+func (y *YouTube) Reconnect() {
+	select {
+	case y.reloads <- struct{}{}:
+	default:
+	}
+}
+
+// Pause stops reading the chats for d
+func (y *YouTube) Pause(d time.Duration) {
+	y.setPause(time.Now().Add(d))
+	log.Println("Pausing YouTube live chats for", d)
+}
+
+// Resume lifts a pause
+func (y *YouTube) Resume() {
+	y.setPause(time.Time{})
+	log.Println("Resuming YouTube live chats")
+}
+
+func (y *YouTube) setPause(until time.Time) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	y.pausedUntil = until
+	close(y.wake)
+	y.wake = make(chan struct{})
+}
+
+// paused returns how long the chats are still paused for, and the channel
+// closed when that changes
+func (y *YouTube) paused() (time.Duration, <-chan struct{}) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	return time.Until(y.pausedUntil), y.wake
+}
+
+// Start reads the chats on tweets until stopchan is signalled, like Stream.Start
+func (y *YouTube) Start(stopchan <-chan struct{}, tweets chan<- Tweet) <-chan struct{} {
+	stoppedchan := make(chan struct{}, 1)
+	// stopchan is signalled once, every reader has to see it
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	wg.Add(1 + len(y.cfg.Videos))
+	go func() {
+		defer wg.Done()
+		y.loadOptions(stop)
+	}()
+	for _, video := range y.cfg.Videos {
+		go func(video string) {
+			defer wg.Done()
+			y.readChat(video, stop, tweets)
+		}(video)
+	}
+	go func() {
+		<-stopchan
+		log.Println("Stopping YouTube live chats...")
+		close(stop)
+		wg.Wait()
+		stoppedchan <- struct{}{}
+	}()
+	return stoppedchan
+}
+
+// loadOptions loads the options, and again on every Reconnect, until stop
+func (y *YouTube) loadOptions(stop <-chan struct{}) {
+	for {
+		wait := y.cfg.RetryInterval
+		options, err := y.cfg.Options()
+		if err != nil {
+			log.Println("Failed to load options:", err)
+		} else {
+			log.Printf("Reading %d YouTube live chats for: %v", len(y.cfg.Videos), options)
+			if y.cfg.OnConnect != nil {
+				y.cfg.OnConnect(options)
+			}
+			wait = -1
+		}
+		var retry <-chan time.Time
+		if wait > 0 {
+			retry = time.After(wait)
+		}
+		select {
+		case <-stop:
+			return
+		case <-y.reloads:
+		case <-retry:
+		}
+	}
+}
+
+// readChat polls the live chat of video and sends its messages on tweets until stop
+func (y *YouTube) readChat(video string, stop <-chan struct{}, tweets chan<- Tweet) {
+	y.mu.Lock()
+	chatID, page := y.chats[video].Chat, y.chats[video].Page
+	y.mu.Unlock()
+	for {
+		wait := y.cfg.MinInterval
+		if d, wake := y.paused(); d > 0 {
+			select {
+			case <-stop:
+				return
+			case <-time.After(d):
+			case <-wake:
+			}
+			continue
+		}
+		if chatID == "" {
+			id, err := y.liveChat(video)
+			switch {
+			case err != nil:
+				chatErrors.Inc("video", video)
+				log.Printf("youtube: failed to find the live chat of %s: %v", video, err)
+				wait = y.cfg.RetryInterval
+			case id == "":
+				log.Printf("youtube: %s has no live chat, checking again in %v", video, y.cfg.RetryInterval)
+				wait = y.cfg.RetryInterval
+			default:
+				log.Printf("youtube: reading the live chat of %s", video)
+				chatID, page = id, ""
+			}
+		}
+		if chatID != "" {
+			msgs, err := y.messages(chatID, page)
+			if err != nil {
+				chatErrors.Inc("video", video)
+				log.Printf("youtube: failed to read the live chat of %s: %v", video, err)
+				if e, ok := err.(*youTubeError); ok && e.ended() {
+					chatID = ""
+					y.setCursor(video, chatCursor{})
+				}
+				wait = y.cfg.RetryInterval
+			} else {
+				for _, item := range msgs.Items {
+					t, ok := item.tweet()
+					if !ok {
+						continue
+					}
+					chatMessages.Inc("video", video)
+					select {
+					case tweets <- t:
+					case <-stop:
+						return
+					}
+				}
+				page = msgs.NextPageToken
+				y.setCursor(video, chatCursor{Chat: chatID, Page: page})
+				if msgs.OfflineAt != "" {
+					log.Printf("youtube: the live stream of %s is over", video)
+					chatID = ""
+					y.setCursor(video, chatCursor{})
+					wait = y.cfg.RetryInterval
+				} else if d := time.Duration(msgs.PollingIntervalMillis) * time.Millisecond; d > wait {
+					wait = d
+				}
+			}
+		}
+		_, wake := y.paused()
+		select {
+		case <-stop:
+			return
+		case <-time.After(wait):
+		case <-wake:
+		}
+	}
+}
+
+// setCursor records where the chat of video is read, nowhere when c.Chat is empty
+func (y *YouTube) setCursor(video string, c chatCursor) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	if c.Chat == "" {
+		delete(y.chats, video)
+		return
+	}
+	y.chats[video] = c
+}
+
+// State returns where the chat of each video is read
+func (y *YouTube) State() ([]byte, error) {
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	return json.Marshal(y.chats)
+}
+
+// Restore makes the chats go on from the messages after the ones an earlier
+// run read, rather than from the latest page; a chat that ended since is
+// looked up again
+func (y *YouTube) Restore(state []byte) error {
+	var chats map[string]chatCursor
+	if err := json.Unmarshal(state, &chats); err != nil {
+		return fmt.Errorf("stream: unreadable YouTube state: %v", err)
+	}
+	y.mu.Lock()
+	defer y.mu.Unlock()
+	for _, video := range y.cfg.Videos {
+		if c, ok := chats[video]; ok && c.Chat != "" {
+			y.chats[video] = c
+		}
+	}
+	return nil
+}
+
+// liveChat returns the ID of the active live chat of video, "" when it has none
+func (y *YouTube) liveChat(video string) (string, error) {
+	var result struct {
+		Items []struct {
+			LiveStreamingDetails struct {
+				ActiveLiveChatID string `json:"activeLiveChatId"`
+			} `json:"liveStreamingDetails"`
+		} `json:"items"`
+	}
+	q := url.Values{"part": {"liveStreamingDetails"}, "id": {video}}
+	if err := y.get("/videos", q, &result); err != nil {
+		return "", err
+	}
+	if len(result.Items) == 0 {
+		return "", fmt.Errorf("no video %s", video)
+	}
+	return result.Items[0].LiveStreamingDetails.ActiveLiveChatID, nil
+}
+
+// chatPage is a page of live chat messages
+type chatPage struct {
+	NextPageToken         string        `json:"nextPageToken"`
+	PollingIntervalMillis int64         `json:"pollingIntervalMillis"`
+	OfflineAt             string        `json:"offlineAt"`
+	Items                 []chatMessage `json:"items"`
+}
+
+// chatMessage is a live chat message, only text messages are votes
+type chatMessage struct {
+	ID      string `json:"id"`
+	Snippet struct {
+		Type               string `json:"type"`
+		PublishedAt        string `json:"publishedAt"`
+		DisplayMessage     string `json:"displayMessage"`
+		TextMessageDetails struct {
+			MessageText string `json:"messageText"`
+		} `json:"textMessageDetails"`
+	} `json:"snippet"`
+	AuthorDetails struct {
+		ChannelID   string `json:"channelId"`
+		DisplayName string `json:"displayName"`
+		IsVerified  bool   `json:"isVerified"`
+	} `json:"authorDetails"`
+}
+
+// tweet returns the message as a tweet, the author's channel as the screen
+// name, false for anything but a text message
+func (m chatMessage) tweet() (Tweet, bool) {
+	if m.Snippet.Type != "textMessageEvent" {
+		return Tweet{}, false
+	}
+	text := m.Snippet.TextMessageDetails.MessageText
+	if text == "" {
+		text = m.Snippet.DisplayMessage
+	}
+	created := time.Now()
+	if at, err := time.Parse(time.RFC3339Nano, m.Snippet.PublishedAt); err == nil {
+		created = at
+	}
+	t := Tweet{ID: YouTubeIDPrefix + m.ID, CreatedAt: created.Format(time.RubyDate), Text: text}
+	t.User.Name = m.AuthorDetails.DisplayName
+	t.User.ScreenName = m.AuthorDetails.ChannelID
+	t.User.Verified = m.AuthorDetails.IsVerified
+	return t, true
+}
+
+// messages returns the page of the chat's messages after page, the first one when empty
+func (y *YouTube) messages(chatID, page string) (chatPage, error) {
+	var result chatPage
+	q := url.Values{"liveChatId": {chatID}, "part": {"snippet,authorDetails"}, "maxResults": {"2000"}}
+	if page != "" {
+		q.Set("pageToken", page)
+	}
+	err := y.get("/liveChat/messages", q, &result)
+	return result, err
+}
+
+// youTubeError is an error the YouTube Data API answered with
+type youTubeError struct {
+	Status  int
+	Reason  string
+	Message string
+}
+
+func (e *youTubeError) Error() string {
+	if e.Reason != "" {
+		return fmt.Sprintf("youtube: %d %s: %s", e.Status, e.Reason, e.Message)
+	}
+	return fmt.Sprintf("youtube: %d: %s", e.Status, e.Message)
+}
+
+// ended reports whether the chat is gone, rather than failed to be read
+func (e *youTubeError) ended() bool {
+	switch e.Reason {
+	case "liveChatEnded", "liveChatNotFound", "liveChatDisabled":
+		return true
+	}
+	return false
+}
+
+// get requests path with q and the API key, decoding the answer into v
+func (y *YouTube) get(path string, q url.Values, v interface{}) error {
+	q.Set("key", y.cfg.APIKey)
+	resp, err := y.client.Get(y.cfg.URL + path + "?" + q.Encode())
+	if err != nil {
+		if ue, ok := err.(*url.Error); ok {
+			// the URL has the API key in it
+			err = ue.Err
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		e := &youTubeError{Status: resp.StatusCode, Message: resp.Status}
+		var answer struct {
+			Error struct {
+				Message string `json:"message"`
+				Errors  []struct {
+					Reason string `json:"reason"`
+				} `json:"errors"`
+			} `json:"error"`
+		}
+		if json.Unmarshal(body, &answer) == nil {
+			if answer.Error.Message != "" {
+				e.Message = answer.Error.Message
+			}
+			if len(answer.Error.Errors) > 0 {
+				e.Reason = answer.Error.Errors[0].Reason
+			}
+		}
+		return e
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"context"
+	"errors"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/breaker"
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/deadletter"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/shutdown"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Streamer is what the stream command runs: the tweets of its sources are
+// matched into votes, which go through its stages in order and are published.
+// It holds everything it streams with, given by its options, so several can
+// run in one process and a test can run one on a memory store and publisher
+// with a fake clock.
+type Streamer struct {
+	db         store.SnapshotStore
+	pipes      []pipeline
+	stages     []Stage
+	publishers []sidePublisher // the votes are dead lettered by the first alone
+	gate       *publish.Gate
+	codec      codec.Codec
+	dead       *deadletter.Sink
+	buffer     int // votes a publisher may fall behind the others by
+	archiver   *archive.Archiver
+	workers    int
+	clock      clock.Clock
+	refresh    *clock.Interval
+	checkpoint *clock.Interval
+
+	reloads         *reloader
+	checkpointed    *checkpoints
+	stopCheckpoints chan struct{}
+	votes           chan match.Vote
+	sourcesStopped  <-chan struct{}
+	matchersDone    <-chan struct{}
+	published       <-chan struct{}
+}
+
+// Stage is a step of the votes between matching and publishing, passing on
+// the ones it keeps; the channel it returns is closed once in is
+type Stage func(in <-chan match.Vote) <-chan match.Vote
+
+// Option configures a Streamer
+type Option func(*Streamer)
+
+// WithStore keeps where the sources that can resume are in db, see WithCheckpoints
+func WithStore(db store.SnapshotStore) Option {
+	return func(s *Streamer) { s.db = db }
+}
+
+// WithPublisher publishes the votes with pub, spooling them to sp while it
+// fails or br is open; br may be nil. The first publisher is the one votes
+// that can't be encoded are dead lettered by, every other gets its own copy
+// of the votes.
+func WithPublisher(pub publish.Publisher, sp *publish.Spool, br *breaker.Breaker) Option {
+	return func(s *Streamer) {
+		s.publishers = append(s.publishers, sidePublisher{pub: pub, spool: sp, breaker: br})
+	}
+}
+
+// WithSource streams the tweets of p's source, matched by its matcher
+func WithSource(p pipeline) Option {
+	return func(s *Streamer) { s.pipes = append(s.pipes, p) }
+}
+
+// WithClock has the refreshes and checkpoints wait on c rather than the wall clock
+func WithClock(c clock.Clock) Option {
+	return func(s *Streamer) { s.clock = c }
+}
+
+// WithStages passes the votes through stages, in order, before they are published
+func WithStages(stages ...Stage) Option {
+	return func(s *Streamer) { s.stages = append(s.stages, stages...) }
+}
+
+// WithRefresh reconnects the sources every refresh, to pick up the new
+// options; without it they only reconnect when asked to, see Reload
+func WithRefresh(refresh *clock.Interval) Option {
+	return func(s *Streamer) { s.refresh = refresh }
+}
+
+// WithCheckpoints saves where the sources are every interval and once they
+// stopped. Without it they go on from the last checkpoint in the store but
+// never save one, as in a dry run.
+func WithCheckpoints(interval *clock.Interval) Option {
+	return func(s *Streamer) { s.checkpoint = interval }
+}
+
+// WithGate pauses publishing with gate, see publish.Run
+func WithGate(gate *publish.Gate) Option {
+	return func(s *Streamer) { s.gate = gate }
+}
+
+// WithCodec encodes the votes with c, JSON otherwise
+func WithCodec(c codec.Codec) Option {
+	return func(s *Streamer) { s.codec = c }
+}
+
+// WithDeadLetters sends the votes that can't be encoded to dead
+func WithDeadLetters(dead *deadletter.Sink) Option {
+	return func(s *Streamer) { s.dead = dead }
+}
+
+// WithBuffer lets every publisher fall behind the others by n votes
+func WithBuffer(n int) Option {
+	return func(s *Streamer) { s.buffer = n }
+}
+
+// WithArchiver hands the tweets that match to a, see pipeline.match
+func WithArchiver(a *archive.Archiver) Option {
+	return func(s *Streamer) { s.archiver = a }
+}
+
+// WithWorkers matches the tweets of every source on n goroutines
+func WithWorkers(n int) Option {
+	return func(s *Streamer) { s.workers = n }
+}
+
+// NewStreamer creates a Streamer with opts, it needs a publisher
+func NewStreamer(opts ...Option) (*Streamer, error) {
+	s := &Streamer{}
+	for _, opt := range opts {
+		opt(s)
+	}
+	if len(s.publishers) == 0 {
+		return nil, errors.New("a streamer needs a publisher")
+	}
+	if s.gate == nil {
+		s.gate = &publish.Gate{}
+	}
+	if s.codec == nil {
+		s.codec = codec.JSON
+	}
+	s.clock = clock.Or(s.clock)
+	s.reloads = newReloader(s.sources(), s.refresh, s.clock)
+	return s, nil
+}
+
+// sources returns the sources of the pipelines
+func (s *Streamer) sources() sources {
+	var src sources
+	for _, p := range s.pipes {
+		src = append(src, p.src)
+	}
+	return src
+}
+
+// Start starts publishing, then the sources from their last checkpoints and
+// their matchers
+func (s *Streamer) Start() {
+	s.votes = make(chan match.Vote)
+	toPublish := (<-chan match.Vote)(s.votes)
+	for _, stage := range s.stages {
+		toPublish = stage(toPublish)
+	}
+	if len(s.publishers) == 1 {
+		p := s.publishers[0]
+		s.published = publish.Run(toPublish, p.pub, s.gate, p.spool, s.codec, p.breaker, s.dead)
+	} else {
+		branches := publish.Tee(toPublish, len(s.publishers), s.buffer)
+		var stopped []<-chan struct{}
+		for i, p := range s.publishers {
+			dead := s.dead
+			if i > 0 {
+				// what can't be encoded is dead lettered once, by the first branch
+				dead = nil
+			}
+			stopped = append(stopped, publish.Run(branches[i], p.pub, s.gate, p.spool, s.codec, p.breaker, dead))
+		}
+		s.published = allStopped(stopped)
+	}
+	if s.refresh != nil {
+		s.reloads.start()
+	}
+	s.stopCheckpoints = make(chan struct{})
+	if s.db != nil {
+		s.checkpointed = restoreCheckpoints(s.db, s.pipes)
+		if s.checkpoint != nil {
+			go s.checkpointed.run(s.checkpoint, s.clock, s.stopCheckpoints)
+		}
+	}
+	var sourcesStopped, matchersStopped []<-chan struct{}
+	for _, p := range s.pipes {
+		sourcesStopped = append(sourcesStopped, p.src.Start(p.stop, p.tweets))
+		matchersStopped = append(matchersStopped, p.match(s.votes, s.archiver, s.workers))
+	}
+	s.sourcesStopped = allStopped(sourcesStopped)
+	s.matchersDone = allStopped(matchersStopped)
+	go func() {
+		<-s.matchersDone
+		close(s.votes)
+	}()
+}
+
+// Reload asks the sources to reconnect with the latest options because of
+// why, requests arriving while one is pending are merged into it
+func (s *Streamer) Reload(why string) {
+	s.reloads.request(why)
+}
+
+// StopSources stops reloading and the sources, and waits for them to stop
+func (s *Streamer) StopSources(ctx context.Context) error {
+	s.reloads.Stop()
+	// stopping interrupts the stream being read
+	for _, p := range s.pipes {
+		p.stop <- struct{}{}
+	}
+	return shutdown.Wait(s.sourcesStopped)(ctx)
+}
+
+// StopMatchers waits for the matchers to match the tweets read, only once the
+// sources stopped: they may still send tweets until then
+func (s *Streamer) StopMatchers(ctx context.Context) error {
+	for _, p := range s.pipes {
+		close(p.tweets)
+	}
+	return shutdown.Wait(s.matchersDone)(ctx)
+}
+
+// Matched returns a channel closed once the matchers stopped
+func (s *Streamer) Matched() <-chan struct{} {
+	return s.matchersDone
+}
+
+// Published returns a channel closed once every vote matched is published or
+// spooled, after the matchers stopped
+func (s *Streamer) Published() <-chan struct{} {
+	return s.published
+}
+
+// SaveCheckpoints stops saving every interval and saves where the sources
+// are, only once what they read is published or spooled or it would be
+// skipped after a restart
+func (s *Streamer) SaveCheckpoints() {
+	close(s.stopCheckpoints)
+	if s.checkpointed != nil && s.checkpoint != nil {
+		s.checkpointed.save()
+	}
+}
+
+// Stop stops the sources, the matchers and the publishers in that order, and
+// then saves the checkpoints, for the callers with nothing to stop in between
+func (s *Streamer) Stop(ctx context.Context) error {
+	if err := s.StopSources(ctx); err != nil {
+		return err
+	}
+	if err := s.StopMatchers(ctx); err != nil {
+		return err
+	}
+	if err := shutdown.Wait(s.published)(ctx); err != nil {
+		return err
+	}
+	s.SaveCheckpoints()
+	return nil
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"runtime"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/clock"
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// fakeSource sends its tweets once started, counting them and its reconnects
+type fakeSource struct {
+	texts      []string
+	reconnects chan struct{}
+
+	mu   sync.Mutex
+	sent int
+}
+
+func newFakeSource(texts ...string) *fakeSource {
+	return &fakeSource{texts: texts, reconnects: make(chan struct{}, 10)}
+}
+
+func (f *fakeSource) Start(stop <-chan struct{}, tweets chan<- stream.Tweet) <-chan struct{} {
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		for i, text := range f.texts[f.sent:] {
+			tweets <- stream.Tweet{ID: strconv.Itoa(i), Text: text}
+			f.mu.Lock()
+			f.sent++
+			f.mu.Unlock()
+		}
+		<-stop
+	}()
+	return stopped
+}
+
+func (f *fakeSource) Reconnect()            { f.reconnects <- struct{}{} }
+func (f *fakeSource) Pause(d time.Duration) {}
+func (f *fakeSource) Resume()               {}
+
+func (f *fakeSource) State() ([]byte, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return []byte(strconv.Itoa(f.sent)), nil
+}
+
+func (f *fakeSource) Restore(state []byte) error {
+	n, err := strconv.Atoi(string(state))
+	f.sent = n
+	return err
+}
+
+// waitForWaiters waits for n Afters and Tickers to be pending on clk
+func waitForWaiters(t *testing.T, clk *clock.Fake, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for clk.Waiters() < n {
+		if time.Now().After(deadline) {
+			t.Fatalf("%d waiters on the clock, want %d", clk.Waiters(), n)
+		}
+		runtime.Gosched()
+	}
+}
+
+func TestStreamer(t *testing.T) {
+	tests := []struct {
+		name    string
+		texts   []string
+		restore string // the checkpoint saved before starting
+		refresh time.Duration
+		advance time.Duration // how far the clock moves while streaming
+		votes   []string
+		// what it reconnects and the checkpoint it saves
+		reconnects int
+		checkpoint string
+	}{
+		{"votes in the order of the tweets", []string{"yes", "no", "maybe", "yes"}, "", 0, 0, []string{"yes", "no", "yes"}, 0, "4"},
+		{"goes on from the last checkpoint", []string{"yes", "no", "no"}, "2", 0, 0, []string{"no"}, 0, "3"},
+		{"reconnects every refresh", []string{"no"}, "", time.Minute, 2 * time.Minute, []string{"no"}, 2, "1"},
+		{"doesn't reconnect before the refresh", []string{"yes"}, "", time.Minute, 59 * time.Second, []string{"yes"}, 0, "1"},
+	}
+	for i, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db := store.NewMemory()
+			if tt.restore != "" {
+				db.SaveSnapshot("stream_state_test", []byte(tt.restore))
+			}
+			sp, err := publish.OpenSpool(t.TempDir(), 1<<20)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sp.Close()
+			pub := publish.NewMemory(len(tt.texts))
+			m := match.NewMatcher(nil)
+			m.Update([]string{"yes", "no"})
+			src := newFakeSource(tt.texts...)
+			clk := clock.NewFake(time.Date(2020, 3, 1, 12, 0, 0, 0, time.UTC))
+			// the checkpoints are only saved when stopping
+			checkpoints := clock.NewInterval("test-checkpoint-"+strconv.Itoa(i), 0, 0)
+			opts := []Option{
+				WithStore(db),
+				WithPublisher(pub, sp, nil),
+				WithSource(newPipeline(stream.SourceSynthetic, "test", src, m)),
+				WithClock(clk),
+				WithCheckpoints(checkpoints),
+			}
+			if tt.refresh > 0 {
+				opts = append(opts, WithRefresh(clock.NewInterval("test-refresh-"+strconv.Itoa(i), tt.refresh, 0)))
+			}
+			s, err := NewStreamer(opts...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s.Start()
+
+			var got []string
+			for range tt.votes {
+				var v match.Vote
+				if _, err := codec.Decode(<-pub.Messages(), &v); err != nil {
+					t.Fatal(err)
+				}
+				got = append(got, v.Option)
+			}
+			left := tt.advance
+			if tt.refresh > 0 {
+				waitForWaiters(t, clk, 1)
+				for i := 0; i < tt.reconnects; i++ {
+					clk.Advance(tt.refresh)
+					<-src.reconnects
+					left -= tt.refresh
+				}
+			}
+			clk.Advance(left)
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := s.Stop(ctx); err != nil {
+				t.Fatal(err)
+			}
+
+			if len(got) != len(tt.votes) {
+				t.Fatalf("votes %v, want %v", got, tt.votes)
+			}
+			for i := range got {
+				if got[i] != tt.votes[i] {
+					t.Fatalf("votes %v, want %v", got, tt.votes)
+				}
+			}
+			if n := len(src.reconnects); n != 0 {
+				t.Errorf("%d reconnects more than the %d of the refreshes", n, tt.reconnects)
+			}
+			if b, _ := db.LoadSnapshot("stream_state_test"); string(b) != tt.checkpoint {
+				t.Errorf("checkpoint %q, want %q", b, tt.checkpoint)
+			}
+		})
+	}
+}
+
+func TestStreamersInOneProcess(t *testing.T) {
+	var streamers []*Streamer
+	var pubs []*publish.Memory
+	for i, option := range []string{"yes", "no"} {
+		sp, err := publish.OpenSpool(t.TempDir(), 1<<20)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer sp.Close()
+		pub := publish.NewMemory(1)
+		m := match.NewMatcher(nil)
+		m.Update([]string{option})
+		s, err := NewStreamer(
+			WithStore(store.NewMemory()),
+			WithPublisher(pub, sp, nil),
+			WithSource(newPipeline(stream.SourceSynthetic, "test-"+strconv.Itoa(i), newFakeSource("yes", "no"), m)),
+			WithClock(clock.NewFake(time.Now())),
+		)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.Start()
+		streamers, pubs = append(streamers, s), append(pubs, pub)
+	}
+	for i, want := range []string{"yes", "no"} {
+		var v match.Vote
+		if _, err := codec.Decode(<-pubs[i].Messages(), &v); err != nil {
+			t.Fatal(err)
+		}
+		if v.Option != want {
+			t.Errorf("streamer %d published %s, want %s", i, v.Option, want)
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	for _, s := range streamers {
+		if err := s.Stop(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
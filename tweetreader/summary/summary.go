@@ -0,0 +1,279 @@
+// Package summary tweets the standings of the running polls every so often,
+// "Current standings: A 54%, B 46%", from an account of the poll's operator,
+// so the people voting on Twitter see where their poll is going.
+//
+// The tweets are rendered from a template, and rate limited three ways: a
+// poll is tweeted about at most once every Interval, only once it has
+// MinVotes, and never with the same text twice in a row, and no more than
+// MaxPerHour tweets go out an hour in all. When each poll was last tweeted
+// about is kept in the store, so a restart doesn't tweet again early.
+package summary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math"
+	"sort"
+	"strings"
+	"text/template"
+	"time"
+	"unicode/utf8"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// snapshot is the name the tweets' state is saved under
+const snapshot = "summaries"
+
+// MaxLength is the most characters a tweet can have, longer ones are cut
+const MaxLength = 280
+
+// DefaultTemplate is what the tweets say when no template is given
+const DefaultTemplate = `Current standings of {{.Title}}: {{range $i, $s := .Standings}}{{if $i}}, {{end}}{{$s.Option}} {{$s.Share}}%{{end}} ({{.Total}} votes)`
+
+var tweets = metrics.NewCounter("tweetreader_summary_tweets_total",
+	"Summary tweets, by outcome: posted, unchanged, rate_limited or failed.")
+
+// Poster posts a tweet and returns its ID, see Twitter
+type Poster interface {
+	Post(text string) (string, error)
+}
+
+// Standing is an option's place in a poll
+type Standing struct {
+	Option string
+	Votes  int
+	Share  int // the percentage of the votes, rounded
+}
+
+// Data is what the template renders
+type Data struct {
+	ID        string
+	Title     string
+	Total     int
+	Standings []Standing // the most votes first
+}
+
+// Config is what and how often to tweet
+type Config struct {
+	// Template renders a poll's Data, DefaultTemplate when nil
+	Template *template.Template
+	// Polls are the IDs of the polls tweeted about, every active poll when empty
+	Polls []string
+	// MinVotes is how many votes a poll needs before it is tweeted about
+	MinVotes int
+	// Interval is the least time between two tweets about a poll, 1h by default
+	Interval time.Duration
+	// MaxPerHour caps the tweets an hour for every poll together, 0 for no cap
+	MaxPerHour int
+}
+
+// Report is what a round did
+type Report struct {
+	Posted      int `json:"posted"`
+	Unchanged   int `json:"unchanged"`
+	RateLimited int `json:"rate_limited"`
+	Failed      int `json:"failed"`
+}
+
+// last is what was tweeted about a poll last
+type last struct {
+	At   time.Time `json:"at"`
+	Text string    `json:"text"`
+	ID   string    `json:"id"`
+}
+
+// state is what the rounds remember across runs
+type state struct {
+	Polls  map[string]last `json:"polls"`
+	Posted []time.Time     `json:"posted"` // the tweets of the last hour
+}
+
+// Summarizer tweets the polls' standings
+type Summarizer struct {
+	cfg    Config
+	db     store.Backend
+	poster Poster
+}
+
+// New creates a Summarizer tweeting about the polls of db with poster
+func New(cfg Config, db store.Backend, poster Poster) *Summarizer {
+	if cfg.Template == nil {
+		cfg.Template = template.Must(template.New("summary").Parse(DefaultTemplate))
+	}
+	if cfg.Interval <= 0 {
+		cfg.Interval = time.Hour
+	}
+	return &Summarizer{cfg: cfg, db: db, poster: poster}
+}
+
+// ParseTemplate parses a tweet's template, which renders a Data
+func ParseTemplate(text string) (*template.Template, error) {
+	t, err := template.New("summary").Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("summary: invalid template: %v", err)
+	}
+	// rendered once with a made up poll, so a template naming a field Data hasn't fails now
+	if _, err := render(t, Data{Title: "poll", Total: 2, Standings: []Standing{{"a", 1, 50}, {"b", 1, 50}}}); err != nil {
+		return nil, err
+	}
+	return t, nil
+}
+
+// Run tweets the standings of every poll due at now. A tweet that fails is
+// logged and counted, the others still go; the state is saved once all went.
+func (s *Summarizer) Run(now time.Time) (Report, error) {
+	var r Report
+	st, err := s.loadState()
+	if err != nil {
+		return r, fmt.Errorf("failed to load the summaries' state: %v", err)
+	}
+	polls, err := s.db.Polls()
+	if err != nil {
+		return r, fmt.Errorf("failed to load the polls: %v", err)
+	}
+	st.Posted = lastHour(st.Posted, now)
+	for i := range polls {
+		p := &polls[i]
+		if !s.wanted(p) {
+			continue
+		}
+		d := data(p)
+		if d.Total < s.cfg.MinVotes || d.Total == 0 {
+			continue
+		}
+		prev := st.Polls[p.ID]
+		if now.Sub(prev.At) < s.cfg.Interval {
+			continue
+		}
+		text, err := render(s.cfg.Template, d)
+		if err != nil {
+			log.Printf("summary: failed to render the tweet of poll %s: %v", p.ID, err)
+			tweets.Inc("outcome", "failed")
+			r.Failed++
+			continue
+		}
+		if text == prev.Text {
+			tweets.Inc("outcome", "unchanged")
+			r.Unchanged++
+			continue
+		}
+		if s.cfg.MaxPerHour > 0 && len(st.Posted) >= s.cfg.MaxPerHour {
+			tweets.Inc("outcome", "rate_limited")
+			r.RateLimited++
+			continue
+		}
+		id, err := s.poster.Post(text)
+		if err != nil {
+			log.Printf("summary: failed to tweet the standings of poll %s: %v", p.ID, err)
+			tweets.Inc("outcome", "failed")
+			r.Failed++
+			continue
+		}
+		log.Printf("summary: tweeted the standings of poll %s as %s", p.ID, id)
+		tweets.Inc("outcome", "posted")
+		r.Posted++
+		st.Polls[p.ID] = last{At: now, Text: text, ID: id}
+		st.Posted = append(st.Posted, now)
+	}
+	if err := s.saveState(st); err != nil {
+		// the polls tweeted about may be tweeted about again early
+		return r, fmt.Errorf("failed to save the summaries' state: %v", err)
+	}
+	return r, nil
+}
+
+// wanted reports whether p is one of the polls tweeted about and still counts
+func (s *Summarizer) wanted(p *store.Poll) bool {
+	if !p.AcceptsVotes() {
+		return false
+	}
+	if len(s.cfg.Polls) == 0 {
+		return true
+	}
+	for _, id := range s.cfg.Polls {
+		if id == p.ID {
+			return true
+		}
+	}
+	return false
+}
+
+// data returns the standings of p, the most votes first and ties by option
+func data(p *store.Poll) Data {
+	d := Data{ID: p.ID, Title: p.Title}
+	for _, o := range p.Options {
+		d.Total += p.Results[o]
+	}
+	for _, o := range p.Options {
+		st := Standing{Option: o, Votes: p.Results[o]}
+		if d.Total > 0 {
+			st.Share = int(math.Round(float64(st.Votes) / float64(d.Total) * 100))
+		}
+		d.Standings = append(d.Standings, st)
+	}
+	sort.SliceStable(d.Standings, func(i, j int) bool {
+		if d.Standings[i].Votes != d.Standings[j].Votes {
+			return d.Standings[i].Votes > d.Standings[j].Votes
+		}
+		return d.Standings[i].Option < d.Standings[j].Option
+	})
+	return d
+}
+
+// render renders d with t, cut to MaxLength characters
+func render(t *template.Template, d Data) (string, error) {
+	var b bytes.Buffer
+	if err := t.Execute(&b, d); err != nil {
+		return "", fmt.Errorf("summary: %v", err)
+	}
+	text := strings.TrimSpace(b.String())
+	if text == "" {
+		return "", fmt.Errorf("summary: the template rendered nothing")
+	}
+	if utf8.RuneCountInString(text) > MaxLength {
+		runes := []rune(text)
+		text = string(runes[:MaxLength-1]) + "…"
+	}
+	return text, nil
+}
+
+// lastHour returns the times of posted within the hour before now
+func lastHour(posted []time.Time, now time.Time) []time.Time {
+	kept := posted[:0]
+	for _, t := range posted {
+		if now.Sub(t) < time.Hour {
+			kept = append(kept, t)
+		}
+	}
+	return kept
+}
+
+func (s *Summarizer) loadState() (state, error) {
+	st := state{Polls: make(map[string]last)}
+	b, err := s.db.LoadSnapshot(snapshot)
+	if err == store.ErrNoSnapshot {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	if err := json.Unmarshal(b, &st); err != nil {
+		return st, err
+	}
+	if st.Polls == nil {
+		st.Polls = make(map[string]last)
+	}
+	return st, nil
+}
+
+func (s *Summarizer) saveState(st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return s.db.SaveSnapshot(snapshot, b)
+}
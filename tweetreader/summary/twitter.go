@@ -0,0 +1,77 @@
+package summary
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+
+	"github.com/garyburd/go-oauth/oauth"
+
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// TweetsURL is where tweets are posted, Twitter's API v2
+const TweetsURL = "https://api.twitter.com/2/tweets"
+
+// Twitter posts the tweets from the account whose access token signs them
+type Twitter struct {
+	url    string
+	client *http.Client
+	auth   *oauth.Client
+	token  *oauth.Credentials
+}
+
+// NewTwitter creates a Poster tweeting with creds to TweetsURL, or to u when set
+func NewTwitter(creds stream.Credentials, u string) (*Twitter, error) {
+	if creds.ConsumerKey == "" || creds.ConsumerSecret == "" || creds.AccessToken == "" || creds.AccessSecret == "" {
+		return nil, fmt.Errorf("summary: tweeting needs the app's key and secret and the account's access token and secret")
+	}
+	if u == "" {
+		u = TweetsURL
+	}
+	return &Twitter{
+		url:    u,
+		client: &http.Client{Timeout: 30 * time.Second},
+		auth:   &oauth.Client{Credentials: oauth.Credentials{Token: creds.ConsumerKey, Secret: creds.ConsumerSecret}},
+		token:  &oauth.Credentials{Token: creds.AccessToken, Secret: creds.AccessSecret},
+	}, nil
+}
+
+// Post tweets text and returns the tweet's ID
+func (t *Twitter) Post(text string) (string, error) {
+	body, err := json.Marshal(map[string]string{"text": text})
+	if err != nil {
+		return "", err
+	}
+	req, err := http.NewRequest("POST", t.url, bytes.NewReader(body))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	// a JSON body isn't part of the signature, only form parameters are
+	if err := t.auth.SetAuthorizationHeader(req.Header, t.token, "POST", req.URL, nil); err != nil {
+		return "", err
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("summary: posting the tweet answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var answer struct {
+		Data struct {
+			ID string `json:"id"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("summary: unreadable answer to the tweet: %v", err)
+	}
+	return answer.Data.ID, nil
+}
@@ -0,0 +1,132 @@
+// Package tap keeps the last votes a process matched and the last messages it
+// couldn't decode in memory, for operators to see what flows through it
+// without attaching a consumer to the broker, see /debug/recent.
+//
+// Both are ring buffers of Size entries: recording one is a copy under a
+// lock, and the oldest are overwritten, so a busy stream only ever shows its
+// most recent traffic.
+package tap
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultSize is how many votes, and decode errors, are kept unless SetSize says otherwise
+const DefaultSize = 200
+
+// maxRaw is the most of an undecodable message kept, the rest is cut off
+const maxRaw = 4096
+
+// Entry is a vote or a decode error, as the tap recorded it
+type Entry struct {
+	At time.Time `json:"at"`
+	// Vote is the vote matched, for the votes
+	Vote interface{} `json:"vote,omitempty"`
+	// Source, Error and Raw are where the message came from, why it couldn't
+	// be decoded and the message, cut at 4 KiB, for the decode errors
+	Source    string `json:"source,omitempty"`
+	Error     string `json:"error,omitempty"`
+	Raw       string `json:"raw,omitempty"`
+	Truncated bool   `json:"truncated,omitempty"`
+}
+
+// ring is the last entries recorded, the oldest overwritten first
+type ring struct {
+	entries []Entry
+	next    int // where the next entry goes
+	full    bool
+	total   int64 // recorded since it was created
+}
+
+var (
+	mu           sync.Mutex
+	votes        = newRing(DefaultSize)
+	decodeErrors = newRing(DefaultSize)
+)
+
+func newRing(size int) *ring {
+	return &ring{entries: make([]Entry, size)}
+}
+
+// SetSize sets how many votes and decode errors are kept, dropping the ones
+// kept so far; 0 keeps none
+func SetSize(size int) {
+	if size < 0 {
+		size = 0
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	votes, decodeErrors = newRing(size), newRing(size)
+}
+
+// add records e, mu held
+func (r *ring) add(e Entry) {
+	r.total++
+	if len(r.entries) == 0 {
+		return
+	}
+	r.entries[r.next] = e
+	r.next++
+	if r.next == len(r.entries) {
+		r.next, r.full = 0, true
+	}
+}
+
+// last returns the n newest entries, the newest first, mu held
+func (r *ring) last(n int) []Entry {
+	kept := r.next
+	if r.full {
+		kept = len(r.entries)
+	}
+	if n <= 0 || n > kept {
+		n = kept
+	}
+	out := make([]Entry, 0, n)
+	for i := 1; i <= n; i++ {
+		out = append(out, r.entries[(r.next-i+len(r.entries))%len(r.entries)])
+	}
+	return out
+}
+
+// Vote records a vote matched, v being a copy the caller no longer changes
+func Vote(v interface{}) {
+	e := Entry{At: time.Now(), Vote: v}
+	mu.Lock()
+	votes.add(e)
+	mu.Unlock()
+}
+
+// DecodeError records a message from source that couldn't be decoded
+func DecodeError(source string, raw []byte, err error) {
+	e := Entry{At: time.Now(), Source: source, Error: err.Error()}
+	if len(raw) > maxRaw {
+		raw, e.Truncated = raw[:maxRaw], true
+	}
+	e.Raw = string(raw)
+	mu.Lock()
+	decodeErrors.add(e)
+	mu.Unlock()
+}
+
+// Recent is what the tap kept, the newest first
+type Recent struct {
+	Votes        []Entry `json:"votes"`
+	DecodeErrors []Entry `json:"decode_errors"`
+	// TotalVotes and TotalDecodeErrors are how many were recorded since the
+	// start or the last SetSize, kept or overwritten since
+	TotalVotes        int64 `json:"total_votes"`
+	TotalDecodeErrors int64 `json:"total_decode_errors"`
+}
+
+// Last returns the n newest votes and decode errors, every one kept when n is 0
+func Last(n int) Recent {
+	mu.Lock()
+	defer mu.Unlock()
+	return Recent{
+		Votes:             votes.last(n),
+		DecodeErrors:      decodeErrors.last(n),
+		TotalVotes:        votes.total,
+		TotalDecodeErrors: decodeErrors.total,
+	}
+}
@@ -0,0 +1,191 @@
+// Package tenant tags the votes with the tenants whose polls they are for.
+//
+// Polls read from a tenant's own collection carry its name, see
+// store.Namespace. Their options are tracked with everybody else's, so a vote
+// is only a tweet naming an option; the Tagger adds the tenants of the
+// tracked polls having that option, for the publisher to route them to the
+// tenants' topics by. Each tenant can have a quota of votes a second: the
+// votes over it are taken from the tenant, so one tenant's viral poll can't
+// starve the others of the broker and the counters.
+package tenant
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/ratelimit"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+var (
+	tagged = metrics.NewCounter("tweetreader_tenant_votes_total",
+		"Votes tagged with a tenant, by tenant.")
+	overQuota = metrics.NewCounter("tweetreader_tenant_over_quota_votes_total",
+		"Votes over their tenant's quota, taken from the tenant, by tenant.")
+	quotaDropped = metrics.NewCounter("tweetreader_tenant_quota_dropped_votes_total",
+		"Votes dropped because every tenant they were for was over its quota.")
+)
+
+// Config sets the tenants' quotas
+type Config struct {
+	// Quota is the votes a second each tenant ingests at most, 0 for no limit
+	Quota float64
+	// Quotas overrides Quota for some tenants, by name, 0 for no limit
+	Quotas map[string]float64
+}
+
+// Tagger sets the Tenants of the votes, within their quotas
+type Tagger struct {
+	cfg Config
+
+	mu     sync.RWMutex
+	of     map[string][]string // the tenants per option, only for options of tenants' polls
+	shared map[string]bool     // the options of tracked polls without a tenant
+
+	quotaMu  sync.Mutex
+	limiters map[string]*ratelimit.Limiter // per tenant, nil for no quota
+}
+
+// New creates a Tagger tagging nothing until Update is called
+func New(cfg Config) *Tagger {
+	return &Tagger{cfg: cfg, limiters: make(map[string]*ratelimit.Limiter)}
+}
+
+// Update takes the tenants of the options from the tracked polls
+func (t *Tagger) Update(polls []store.Poll) {
+	sets := make(map[string]map[string]bool)
+	shared := make(map[string]bool)
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		if p.Tenant == "" {
+			for _, o := range p.Options {
+				shared[o] = true
+			}
+			continue
+		}
+		for _, o := range p.Options {
+			if sets[o] == nil {
+				sets[o] = make(map[string]bool)
+			}
+			sets[o][p.Tenant] = true
+		}
+	}
+	of := make(map[string][]string, len(sets))
+	for o, set := range sets {
+		for name := range set {
+			of[o] = append(of[o], name)
+		}
+		sort.Strings(of[o])
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.of, t.shared = of, shared
+}
+
+// Untenanted reports whether a tracked poll without a tenant has option
+func (t *Tagger) Untenanted(option string) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.shared[option]
+}
+
+// Options wraps a function loading the options so every load also updates
+// the tenants of the options. When the polls can't be loaded the last
+// tenants are kept.
+func (t *Tagger) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("tenant: failed to load the polls, keeping the last tenants:", err)
+			return options, nil
+		}
+		t.Update(all)
+		return options, nil
+	}
+}
+
+// Tag sets the tenants of v's option that are within their quota on v. It
+// reports false when v was only for tenants over their quotas, and is dropped.
+func (t *Tagger) Tag(v *match.Vote) bool {
+	t.mu.RLock()
+	tenants, shared := t.of[v.Option], t.shared[v.Option]
+	t.mu.RUnlock()
+	v.Tenants = nil
+	for _, name := range tenants {
+		if ok, _ := t.limiter(name).Allow(name); !ok {
+			overQuota.Inc("tenant", name)
+			continue
+		}
+		tagged.Inc("tenant", name)
+		v.Tenants = append(v.Tenants, name)
+	}
+	if len(tenants) > 0 && len(v.Tenants) == 0 && !shared {
+		quotaDropped.Inc()
+		return false
+	}
+	return true
+}
+
+// limiter returns the limiter of the tenant's quota, nil when it has none
+func (t *Tagger) limiter(name string) *ratelimit.Limiter {
+	t.quotaMu.Lock()
+	defer t.quotaMu.Unlock()
+	l, ok := t.limiters[name]
+	if !ok {
+		rate, set := t.cfg.Quotas[name]
+		if !set {
+			rate = t.cfg.Quota
+		}
+		l = ratelimit.New(rate, 0)
+		t.limiters[name] = l
+	}
+	return l
+}
+
+// Run tags the votes from in and passes on the ones kept, the returned
+// channel is closed once in is
+func (t *Tagger) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			if t.Tag(&v) {
+				out <- v
+			}
+		}
+	}()
+	return out
+}
+
+// ParseQuotas parses quotas written tenant=votes a second and separated by commas
+func ParseQuotas(s string) (map[string]float64, error) {
+	quotas := make(map[string]float64)
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		eq := strings.Index(part, "=")
+		if eq <= 0 {
+			return nil, fmt.Errorf("tenant: quota %q isn't tenant=votes a second", part)
+		}
+		rate, err := strconv.ParseFloat(part[eq+1:], 64)
+		if err != nil || rate < 0 {
+			return nil, fmt.Errorf("tenant: quota %q isn't tenant=votes a second", part)
+		}
+		quotas[part[:eq]] = rate
+	}
+	return quotas, nil
+}
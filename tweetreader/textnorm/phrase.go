@@ -0,0 +1,128 @@
+package textnorm
+
+import "strings"
+
+// MaxPhraseGap is the most words a tweet may put between the words of a phrase
+const MaxPhraseGap = 3
+
+// Word is a word of a text and where it starts in it
+type Word struct {
+	Text  string
+	Start int
+}
+
+// SplitWords returns the runs of letters, digits and marks in text, so
+// punctuation, hashtag signs and line breaks all separate words
+func SplitWords(text string) []Word {
+	var words []Word
+	start := -1
+	for i, r := range text {
+		inWord := inWord(r)
+		switch {
+		case inWord && start < 0:
+			start = i
+		case !inWord && start >= 0:
+			words = append(words, Word{text[start:i], start})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		words = append(words, Word{text[start:], start})
+	}
+	return words
+}
+
+// PhraseWords returns the words of term when it is a phrase: it has spaces,
+// which Twitter's track reads as AND, more than one word and no emoji.
+// Other terms, nil, are found anywhere in a text with Index.
+func PhraseWords(term string) []string {
+	if !strings.ContainsAny(term, " \t") || HasEmoji(term) {
+		return nil
+	}
+	split := SplitWords(term)
+	if len(split) < 2 {
+		return nil
+	}
+	words := make([]string, len(split))
+	for i, w := range split {
+		words[i] = w.Text
+	}
+	return words
+}
+
+// Phrase finds the words of a phrase in order, as whole words, with at most
+// gap other words between two of them
+type Phrase struct {
+	words []string
+	gap   int
+}
+
+// NewPhrase returns the Phrase of term, already folded, nil when it isn't one, see PhraseWords.
+// gap is bounded by 0 and MaxPhraseGap.
+func NewPhrase(term string, gap int) *Phrase {
+	words := PhraseWords(term)
+	if words == nil {
+		return nil
+	}
+	if gap < 0 {
+		gap = 0
+	} else if gap > MaxPhraseGap {
+		gap = MaxPhraseGap
+	}
+	return &Phrase{words: words, gap: gap}
+}
+
+// Index returns where p first appears in text, already folded, or -1
+func (p *Phrase) Index(text string) int {
+	return p.Find(SplitWords(text))
+}
+
+// Find is Index over the words of a text, so many phrases can share the split
+func (p *Phrase) Find(words []Word) int {
+	start, _ := p.span(words)
+	return start
+}
+
+// Span returns where p first appears in text, already folded, from the start
+// of its first word to the end of its last, or -1, -1
+func (p *Phrase) Span(text string) (start, end int) {
+	return p.span(SplitWords(text))
+}
+
+func (p *Phrase) span(words []Word) (start, end int) {
+	for i, w := range words {
+		if w.Text != p.words[0] {
+			continue
+		}
+		if last := p.follows(words, i, 1); last >= 0 {
+			return w.Start, words[last].Start + len(words[last].Text)
+		}
+	}
+	return -1, -1
+}
+
+// follows returns the index of the last word of p when the words from next on
+// come after words[at], -1 when they don't. Every place within the gap is
+// tried, since an earlier one can leave the rest out of reach.
+func (p *Phrase) follows(words []Word, at, next int) int {
+	if next == len(p.words) {
+		return at
+	}
+	for i := at + 1; i < len(words) && i <= at+1+p.gap; i++ {
+		if words[i].Text == p.words[next] {
+			if last := p.follows(words, i, next+1); last >= 0 {
+				return last
+			}
+		}
+	}
+	return -1
+}
+
+// IndexTerm returns where term first appears in text, both already folded,
+// as a phrase with up to MaxPhraseGap words in between when it is one, or with Index
+func IndexTerm(text, term string) int {
+	if p := NewPhrase(term, MaxPhraseGap); p != nil {
+		return p.Index(text)
+	}
+	return Index(text, term)
+}
@@ -0,0 +1,146 @@
+package textnorm
+
+// Set finds which of many folded terms appear in a folded text in one pass
+// over the text, however many terms there are. It is an Aho-Corasick
+// automaton over the bytes of the terms, and agrees with Index: terms with
+// emoji only count where they stand alone.
+// A Set is read only once built and can be used from several goroutines.
+type Set struct {
+	nodes []setNode
+	terms []setTerm
+	root  [256]int32 // the root's edges by byte, most bytes of a text start over from it
+}
+
+type setTerm struct {
+	size  int  // length in bytes
+	emoji bool // has to stand alone
+}
+
+type setNode struct {
+	edges []setEdge // sorted by byte
+	fail  int32     // longest proper suffix of this node that is also a prefix of a term
+	out   int32     // closest node on the fail chain that ends a term, -1 if none
+	ends  []int32   // terms ending here
+}
+
+type setEdge struct {
+	b    byte
+	next int32
+}
+
+// NewSet builds the Set of terms, which are already folded. Empty terms never match.
+func NewSet(terms []string) *Set {
+	s := &Set{nodes: []setNode{{out: -1}}, terms: make([]setTerm, len(terms))}
+	for i, term := range terms {
+		s.terms[i] = setTerm{size: len(term), emoji: HasEmoji(term)}
+		if term == "" {
+			continue
+		}
+		n := int32(0)
+		for j := 0; j < len(term); j++ {
+			next := s.child(n, term[j])
+			if next < 0 {
+				next = int32(len(s.nodes))
+				s.nodes = append(s.nodes, setNode{out: -1})
+				s.addEdge(n, term[j], next)
+			}
+			n = next
+		}
+		s.nodes[n].ends = append(s.nodes[n].ends, int32(i))
+	}
+	for b := range s.root {
+		s.root[b] = -1
+	}
+	// breadth first, so every node's fail is done before its children's
+	queue := make([]int32, 0, len(s.nodes))
+	for _, e := range s.nodes[0].edges {
+		s.root[e.b] = e.next
+		queue = append(queue, e.next)
+	}
+	for len(queue) > 0 {
+		n := queue[0]
+		queue = queue[1:]
+		for _, e := range s.nodes[n].edges {
+			f := s.nodes[n].fail
+			for f > 0 && s.child(f, e.b) < 0 {
+				f = s.nodes[f].fail
+			}
+			if c := s.child(f, e.b); c >= 0 {
+				f = c
+			}
+			s.nodes[e.next].fail = f
+			if len(s.nodes[f].ends) > 0 {
+				s.nodes[e.next].out = f
+			} else {
+				s.nodes[e.next].out = s.nodes[f].out
+			}
+			queue = append(queue, e.next)
+		}
+	}
+	return s
+}
+
+func (s *Set) child(n int32, b byte) int32 {
+	// the table is all zeros while NewSet is still adding terms
+	if n == 0 && s.root[b] != 0 {
+		return s.root[b]
+	}
+	for _, e := range s.nodes[n].edges {
+		if e.b == b {
+			return e.next
+		}
+		if e.b > b {
+			break
+		}
+	}
+	return -1
+}
+
+func (s *Set) addEdge(n int32, b byte, next int32) {
+	edges := s.nodes[n].edges
+	i := len(edges)
+	for i > 0 && edges[i-1].b > b {
+		i--
+	}
+	edges = append(edges, setEdge{})
+	copy(edges[i+1:], edges[i:])
+	edges[i] = setEdge{b: b, next: next}
+	s.nodes[n].edges = edges
+}
+
+// Len is the number of terms in the set
+func (s *Set) Len() int {
+	return len(s.terms)
+}
+
+// Find calls found with the index of every term that appears in text,
+// possibly more than once for the same term
+func (s *Set) Find(text string, found func(term int)) {
+	s.FindSpans(text, func(term, start, end int) { found(term) })
+}
+
+// FindSpans is Find, also telling where in text every term appears
+func (s *Set) FindSpans(text string, found func(term, start, end int)) {
+	n := int32(0)
+	for i := 0; i < len(text); i++ {
+		b := text[i]
+		next := s.child(n, b)
+		for next < 0 && n > 0 {
+			n = s.nodes[n].fail
+			next = s.child(n, b)
+		}
+		if next < 0 {
+			continue
+		}
+		n = next
+		end := i + 1
+		for m := n; m >= 0; m = s.nodes[m].out {
+			for _, t := range s.nodes[m].ends {
+				term := s.terms[t]
+				if start := end - term.size; !term.emoji || standsAlone(text, start, end) {
+					found(int(t), start, end)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package textnorm
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestSetAgreesWithIndex(t *testing.T) {
+	tests := []struct {
+		name  string
+		terms []string
+		texts []string
+	}{
+		{"overlapping terms", []string{"he", "she", "his", "hers"}, []string{"ushers", "his hers", "h", "", "shelter"}},
+		{"a term in another", []string{"go", "golang", "lang", "an"}, []string{"golang", "go lang", "pokemon go", "gopher"}},
+		{"the same term twice", []string{"yes", "yes", "no"}, []string{"yes or no", "nope", "eyes"}},
+		{"empty terms", []string{"", "a"}, []string{"", "a", "b"}},
+		{"accents folded", []string{Fold("Café"), Fold("naïve")}, []string{Fold("CAFÉ au lait"), Fold("naive"), Fold("Cafe")}},
+		{"emoji stand alone", []string{"👍", "🇬🇧", "🔥"}, []string{"👍", "👍🏽", "go 👍 go", "🇬🇧🇫🇷", "🇫🇷🇬🇧", "🔥🔥"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewSet(tt.terms)
+			if s.Len() != len(tt.terms) {
+				t.Errorf("Len = %d, want %d", s.Len(), len(tt.terms))
+			}
+			for _, text := range tt.texts {
+				first := make(map[int]int) // where each term was first found
+				s.FindSpans(text, func(term, start, end int) {
+					if text[start:end] != tt.terms[term] {
+						t.Errorf("%q: found %q at %d-%d, not %q", text, text[start:end], start, end, tt.terms[term])
+					}
+					if at, ok := first[term]; !ok || start < at {
+						first[term] = start
+					}
+				})
+				for i, term := range tt.terms {
+					at, ok := first[i]
+					if !ok {
+						at = -1
+					}
+					if want := Index(text, term); at != want {
+						t.Errorf("%q in %q: Set found it at %d, Index at %d", term, text, at, want)
+					}
+				}
+			}
+		})
+	}
+}
+
+func BenchmarkSetFind(b *testing.B) {
+	for _, n := range []int{10, 100, 500, 5000} {
+		b.Run(fmt.Sprintf("terms=%d", n), func(b *testing.B) {
+			terms := make([]string, n)
+			for i := range terms {
+				terms[i] = fmt.Sprintf("term%05d", i)
+			}
+			s := NewSet(terms)
+			text := Fold("Voting for " + terms[n/2] + " and not for anything else, " + strings.Repeat("really ", 10))
+			b.SetBytes(int64(len(text)))
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				s.Find(text, func(int) {})
+			}
+		})
+	}
+}
+
+// BenchmarkIndex is what finding the terms costs without a Set, one Index each
+func BenchmarkIndex(b *testing.B) {
+	for _, n := range []int{10, 100, 500} {
+		b.Run(fmt.Sprintf("terms=%d", n), func(b *testing.B) {
+			terms := make([]string, n)
+			for i := range terms {
+				terms[i] = fmt.Sprintf("term%05d", i)
+			}
+			text := Fold("Voting for " + terms[n/2] + " and not for anything else, " + strings.Repeat("really ", 10))
+			b.SetBytes(int64(len(text)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				for _, t := range terms {
+					Index(text, t)
+				}
+			}
+		})
+	}
+}
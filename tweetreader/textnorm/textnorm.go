@@ -0,0 +1,172 @@
+// Package textnorm compares tweet text and poll options the way people read them.
+//
+// Options used to be matched with a lowercase substring search, which works for words
+// but not for emoji: the same emoji is written with or without a variation selector
+// (❤ and ❤️), with skin tones, or as part of a longer sequence, so 👨 was found inside
+// 👨‍👩‍👧 and the flag 🇺🇸 inside 🇦🇺🇸🇪. Fold removes the differences that don't change
+// what is shown, and Index only finds emoji that stand on their own.
+package textnorm
+
+import (
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+const (
+	zwj    = '\u200d' // zero width joiner, glues emoji into one
+	keycap = '\u20e3' // turns a digit, # or * into a keycap emoji
+)
+
+// Fold lowercases s and drops variation selectors and skin tone modifiers
+func Fold(s string) string {
+	return strings.Map(foldRune, s)
+}
+
+func foldRune(r rune) rune {
+	if stripRune(r) < 0 {
+		return -1
+	}
+	return unicode.ToLower(r)
+}
+
+// Strip is Fold keeping the case, for options matched in the case they are written
+func Strip(s string) string {
+	return strings.Map(stripRune, s)
+}
+
+func stripRune(r rune) rune {
+	switch {
+	case r == '\ufe0e', r == '\ufe0f': // variation selectors
+		return -1
+	case r >= 0x1f3fb && r <= 0x1f3ff: // skin tones
+		return -1
+	}
+	return r
+}
+
+// FoldOffsets is Fold, also returning where every byte of the folded string
+// comes from in s, plus len(s) at the end, so matches in the folded string
+// can be mapped back to the original
+func FoldOffsets(s string) (string, []int) {
+	var b strings.Builder
+	offsets := make([]int, 0, len(s)+1)
+	for i, r := range s {
+		if r = foldRune(r); r < 0 {
+			continue
+		}
+		n, _ := b.WriteRune(r)
+		for j := 0; j < n; j++ {
+			offsets = append(offsets, i)
+		}
+	}
+	return b.String(), append(offsets, len(s))
+}
+
+// IsEmoji reports whether r is in one of the blocks emoji are drawn from
+func IsEmoji(r rune) bool {
+	switch {
+	case r >= 0x1f000 && r <= 0x1faff, // pictographs, emoticons, transport, flags...
+		r >= 0x2600 && r <= 0x27bf, // misc symbols and dingbats
+		r >= 0x2b00 && r <= 0x2bff, // arrows and stars
+		r >= 0x2300 && r <= 0x23ff, // technical, ⌚ ⏰
+		r >= 0x2190 && r <= 0x21ff, // arrows
+		r >= 0x25a0 && r <= 0x25ff, // shapes, ▶
+		r == 0x203c, r == 0x2049, r == 0x2122, r == 0x2139,
+		r == 0x3030, r == 0x303d, r == 0x3297, r == 0x3299,
+		r == 0x00a9, r == 0x00ae:
+		return true
+	}
+	return false
+}
+
+// HasEmoji reports whether s contains an emoji
+func HasEmoji(s string) bool {
+	return strings.IndexFunc(s, IsEmoji) >= 0
+}
+
+func isRegional(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+// Index returns where term first appears in text, or -1, both already folded.
+// Terms with emoji only match where the emoji isn't part of a longer one.
+func Index(text, term string) int {
+	if term == "" {
+		return -1
+	}
+	if !HasEmoji(term) {
+		return strings.Index(text, term)
+	}
+	for from := 0; from < len(text); {
+		i := strings.Index(text[from:], term)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		if standsAlone(text, i, i+len(term)) {
+			return i
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		from = i + size
+	}
+	return -1
+}
+
+// IndexWord is Index only finding term as whole words, not inside longer
+// ones: "class" has no word "ass". Emoji already have to stand alone.
+func IndexWord(text, term string) int {
+	if term == "" {
+		return -1
+	}
+	for from := 0; from < len(text); {
+		i := Index(text[from:], term)
+		if i < 0 {
+			return -1
+		}
+		i += from
+		before, _ := utf8.DecodeLastRuneInString(text[:i])
+		after, _ := utf8.DecodeRuneInString(text[i+len(term):])
+		if !inWord(before) && !inWord(after) {
+			return i
+		}
+		_, size := utf8.DecodeRuneInString(text[i:])
+		from = i + size
+	}
+	return -1
+}
+
+// inWord reports whether r is part of the words SplitWords returns
+func inWord(r rune) bool {
+	return unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// standsAlone reports whether text[start:end] isn't glued to the emoji around it
+func standsAlone(text string, start, end int) bool {
+	if before, _ := utf8.DecodeLastRuneInString(text[:start]); before == zwj {
+		return false
+	}
+	if after, _ := utf8.DecodeRuneInString(text[end:]); after == zwj || after == keycap {
+		return false
+	}
+	// flags are pairs of regional indicators, count the ones before to see if start splits a pair
+	if first, _ := utf8.DecodeRuneInString(text[start:]); isRegional(first) {
+		var n int
+		for rest := text[:start]; ; n++ {
+			r, size := utf8.DecodeLastRuneInString(rest)
+			if !isRegional(r) {
+				break
+			}
+			rest = rest[:len(rest)-size]
+		}
+		if n%2 != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Contains reports whether term appears in text, see Index
+func Contains(text, term string) bool {
+	return Index(text, term) >= 0
+}
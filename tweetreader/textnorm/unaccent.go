@@ -0,0 +1,144 @@
+package textnorm
+
+import "strings"
+
+// Some polls want "München" to also find "Munchen" and "MÜNCHEN". Fold takes
+// care of the case, Unaccent of the diacritics, the way a compatibility
+// decomposition (NFKD) dropping the combining marks would, and Transliterate
+// also reads Greek and Cyrillic in the Latin alphabet.
+
+// unaccented are the letters Unaccent rewrites, by what they become
+var unaccented = table(
+	"a", "àáâãäåāăąǎǟǡǻȁȃȧḁạảấầẩẫậắằẳẵặ",
+	"c", "çćĉċčḉ",
+	"d", "ďđðḋḍḏḑḓ",
+	"e", "èéêëēĕėęěȅȇȩḕḗḙḛḝẹẻẽếềểễệ",
+	"g", "ĝğġģǧǵḡ",
+	"h", "ĥħȟḣḥḧḩḫ",
+	"i", "ìíîïĩīĭįıǐȉȋḭḯỉị",
+	"j", "ĵǰ",
+	"k", "ķǩḱḳḵ",
+	"l", "ĺļľŀłḷḹḻḽ",
+	"n", "ñńņňŉǹṅṇṉṋ",
+	"o", "òóôõöøōŏőơǒǫǭǿȍȏȫȭȯȱṍṏṑṓọỏốồổỗộớờởỡợ",
+	"r", "ŕŗřȑȓṙṛṝṟ",
+	"s", "śŝşšșṡṣṥṧṩ",
+	"t", "ţťŧțṫṭṯṱẗ",
+	"u", "ùúûüũūŭůűųưǔǖǘǚǜȕȗṳṵṷṹṻụủứừửữự",
+	"w", "ŵẁẃẅẇẉẘ",
+	"y", "ýÿŷȳẏẙỳỵỷỹ",
+	"z", "źżžẑẓẕ",
+	"ss", "ß",
+	"ae", "æǣǽ",
+	"oe", "œ",
+	"th", "þ",
+	// Greek tonos and dialytika
+	"α", "ά",
+	"ε", "έ",
+	"η", "ή",
+	"ι", "ίϊΐ",
+	"ο", "ό",
+	"υ", "ύϋΰ",
+	"ω", "ώ",
+	// Cyrillic letters that decompose
+	"е", "ёѐ",
+	"и", "йѝ",
+	"і", "ї",
+	"у", "ў",
+	"г", "ѓ",
+	"к", "ќ",
+)
+
+// transliterated are the Greek and Cyrillic letters Transliterate writes in the Latin alphabet
+var transliterated = table(
+	"a", "αа",
+	"b", "б",
+	"v", "βв",
+	"g", "γгґѓ",
+	"d", "δд",
+	"e", "εеэ",
+	"z", "ζз",
+	"i", "ηιиі",
+	"th", "θ",
+	"k", "κкќ",
+	"l", "λл",
+	"m", "μм",
+	"n", "νн",
+	"x", "ξ",
+	"o", "οоω",
+	"p", "πп",
+	"r", "ρр",
+	"s", "σςс",
+	"t", "τт",
+	"y", "υйы",
+	"f", "φф",
+	"ch", "χч",
+	"ps", "ψ",
+	"zh", "ж",
+	"u", "уў",
+	"kh", "х",
+	"ts", "ц",
+	"sh", "ш",
+	"shch", "щ",
+	"", "ъь",
+	"yo", "ё",
+	"yu", "ю",
+	"ya", "я",
+	"yi", "ї",
+	"ye", "є",
+)
+
+// table maps every letter of each odd argument to the even one before it
+func table(pairs ...string) map[rune]string {
+	t := make(map[rune]string)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		for _, r := range pairs[i+1] {
+			t[r] = pairs[i]
+		}
+	}
+	return t
+}
+
+// combining reports whether r is a combining diacritical mark, rather than
+// the vowel signs of other scripts or the marks joining emoji
+func combining(r rune) bool {
+	return r >= 0x0300 && r <= 0x036f || r >= 0x1ab0 && r <= 0x1aff || r >= 0x1dc0 && r <= 0x1dff
+}
+
+// Unaccent drops the diacritics of s, already folded: "münchen" reads "munchen"
+func Unaccent(s string) string {
+	return rewrite(s, nil)
+}
+
+// Transliterate is Unaccent also writing Greek and Cyrillic letters in the
+// Latin alphabet, "москва" reads "moskva" and "αθήνα" "athina"
+func Transliterate(s string) string {
+	return rewrite(s, transliterated)
+}
+
+// rewrite unaccents s, first looking its letters up in scripts when it isn't nil
+func rewrite(s string, scripts map[rune]string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for _, r := range s {
+		if combining(r) {
+			continue
+		}
+		if to, ok := scripts[r]; ok {
+			b.WriteString(to)
+			continue
+		}
+		if to, ok := unaccented[r]; ok {
+			if scripts != nil {
+				// the base of an accented Greek or Cyrillic letter is transliterated too
+				if t, ok := scripts[[]rune(to)[0]]; ok && len([]rune(to)) == 1 {
+					to = t
+				}
+			}
+			b.WriteString(to)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
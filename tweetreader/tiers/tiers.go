@@ -0,0 +1,109 @@
+// Package tiers keeps a stream's filter within Twitter's limit of track terms
+// by the priority of the polls. When the options have more terms than a
+// stream tracks, the options of high priority polls keep their place in the
+// filter, then the normal ones fill what is left, then the low ones; the
+// options left over are looked up with the search API every so often
+// instead, their votes coming late and only as many as a search returns.
+// An option in several polls is as high as the highest of them.
+package tiers
+
+import (
+	"log"
+	"sort"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+var searchedOptions = metrics.NewGauge("tweetreader_searched_options",
+	"Options left out of the stream's filter for the track limit, looked up with the search API instead.")
+
+// ranks orders the priorities, the options of the lower ones leave the filter first
+var ranks = map[string]int{store.PriorityHigh: 0, "": 1, store.PriorityNormal: 1, store.PriorityLow: 2}
+
+// Tiers fits the options of one stream in its filter
+type Tiers struct {
+	name  string // the stream's, labels the metric
+	limit int
+
+	mu       sync.Mutex
+	searched []string
+}
+
+// New creates the Tiers of the stream named, fitting its options in limit
+// terms, stream.MaxTrack when 0
+func New(name string, limit int) *Tiers {
+	return &Tiers{name: name, limit: limit}
+}
+
+// Searched returns the options left out of the filter by the last load
+func (t *Tiers) Searched() []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.searched
+}
+
+// Options wraps a function loading the options so every load only returns
+// as many as the filter tracks, in the order of their polls' priority; the
+// rest are Searched. When the polls can't be loaded every option is normal.
+func (t *Tiers) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("tiers: failed to load the polls, fitting the options as loaded:", err)
+		}
+		rank := rankBy(all)
+		ranked := append([]string(nil), options...)
+		sort.SliceStable(ranked, func(i, j int) bool { return rank(ranked[i]) < rank(ranked[j]) })
+		tracked, over := stream.FitTrack(ranked, t.limit)
+		// high priority options are tracked whatever the limit
+		forced := 0
+		for forced < len(over) && rank(over[forced]) == ranks[store.PriorityHigh] {
+			forced++
+		}
+		if forced > 0 {
+			log.Printf("tiers: the high priority options alone are over the track limit, tracking %d more than it", forced)
+			tracked, over = append(tracked, over[:forced]...), over[forced:]
+		}
+		t.mu.Lock()
+		t.searched = over
+		t.mu.Unlock()
+		searchedOptions.Set(float64(len(over)), "stream", t.name)
+		if len(over) > 0 {
+			log.Printf("tiers: %d options are over the track limit, searching for them instead: %v", len(over), over)
+		}
+		return tracked, nil
+	}
+}
+
+// rankBy returns the rank of an option in polls, the highest of the polls
+// accepting votes with it, lower ranks first; normal for an option in none
+func rankBy(polls []store.Poll) func(option string) int {
+	byOption := make(map[string]int)
+	for _, p := range polls {
+		if !p.AcceptsVotes() {
+			continue
+		}
+		r, ok := ranks[p.Priority]
+		if !ok {
+			r = ranks[store.PriorityNormal]
+		}
+		for _, o := range p.Options {
+			if have, ok := byOption[o]; !ok || r < have {
+				byOption[o] = r
+			}
+		}
+	}
+	return func(option string) int {
+		if r, ok := byOption[option]; ok {
+			return r
+		}
+		return ranks[store.PriorityNormal]
+	}
+}
@@ -0,0 +1,77 @@
+package timeseries
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Influx writes points to InfluxDB using the line protocol.
+// url is the full write endpoint, e.g. http://influx:8086/write?db=polls for 1.x
+// or http://influx:8086/api/v2/write?org=o&bucket=polls for 2.x (with a token).
+type Influx struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewInflux creates a store writing to url
+func NewInflux(url, token string) *Influx {
+	return &Influx{url: url, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+var tagEscaper = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+
+// Write sends all points in one request
+func (in *Influx) Write(points []Point) error {
+	if len(points) == 0 {
+		return nil
+	}
+	var body bytes.Buffer
+	for _, p := range points {
+		fmt.Fprintf(&body, "poll_votes,poll=%s,option=%s count=%di,weighted=%v %d\n",
+			tagEscaper.Replace(p.Poll), tagEscaper.Replace(p.Option), p.Count, p.Weighted, p.Time.UnixNano())
+	}
+	return in.post(&body)
+}
+
+// PushRates writes the rates as the poll_vote_rate measurement
+func (in *Influx) PushRates(rates []Rate) error {
+	var body bytes.Buffer
+	for _, r := range rates {
+		fmt.Fprintf(&body, "poll_vote_rate,poll=%s,option=%s rate=%v,weighted=%v %d\n",
+			tagEscaper.Replace(r.Poll), tagEscaper.Replace(r.Option), r.PerSecond, r.Weighted, r.Time.UnixNano())
+	}
+	return in.post(&body)
+}
+
+// post sends a line protocol body to the write endpoint
+func (in *Influx) post(body *bytes.Buffer) error {
+	req, err := http.NewRequest("POST", in.url, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	if in.token != "" {
+		req.Header.Set("Authorization", "Token "+in.token)
+	}
+	resp, err := in.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("influx write failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// Close is a no-op, every write is its own request
+func (in *Influx) Close() error {
+	return nil
+}
@@ -0,0 +1,108 @@
+package timeseries
+
+import (
+	"log"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+	"gopkg.in/mgo.v2/bson"
+)
+
+// Periods the results are also bucketed by in results_calendar, in the time
+// zone of each poll
+const (
+	Hour = "hour"
+	Day  = "day"
+)
+
+// Mongo keeps one document per poll, option and time bucket in the ballots
+// database, and per poll, option, hour and day of the poll's time zone
+type Mongo struct {
+	session  *mgo.Session
+	database string
+	bucket   time.Duration
+
+	mu    sync.Mutex
+	zones map[string]*time.Location // loaded by name, UTC for the ones that fail to
+}
+
+// NewMongo stores points in buckets of the given width, in database
+func NewMongo(session *mgo.Session, database string, bucket time.Duration) *Mongo {
+	return &Mongo{session: session.Copy(), database: database, bucket: bucket, zones: make(map[string]*time.Location)}
+}
+
+// Write increments the bucket documents the points fall into
+func (m *Mongo) Write(points []Point) error {
+	db := m.session.DB(m.database)
+	c, calendar := db.C("results_timeseries"), db.C("results_calendar")
+	for _, p := range points {
+		sel := bson.M{
+			"poll":   p.Poll,
+			"option": p.Option,
+			"bucket": p.Time.UTC().Truncate(m.bucket),
+		}
+		up := bson.M{"$inc": bson.M{"count": p.Count, "weighted": p.Weighted}}
+		if _, err := c.Upsert(sel, up); err != nil {
+			return err
+		}
+		zone := m.zone(p.Timezone)
+		for _, period := range []string{Hour, Day} {
+			start, local := calendarBucket(p.Time.In(zone), period)
+			// a poll's time zone changing starts buckets of the new one
+			sel := bson.M{
+				"poll":     p.Poll,
+				"option":   p.Option,
+				"period":   period,
+				"timezone": zone.String(),
+				"local":    local,
+			}
+			up := bson.M{
+				"$inc": bson.M{"count": p.Count, "weighted": p.Weighted},
+				"$set": bson.M{"start": start.UTC()},
+			}
+			if _, err := calendar.Upsert(sel, up); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// zone returns the time zone named name, UTC when it is empty or unknown
+func (m *Mongo) zone(name string) *time.Location {
+	if name == "" {
+		return time.UTC
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if z, ok := m.zones[name]; ok {
+		return z
+	}
+	z, err := time.LoadLocation(name)
+	if err != nil {
+		log.Printf("timeseries: unknown time zone %q, bucketing its polls' results in UTC: %v", name, err)
+		z = time.UTC
+	}
+	m.zones[name] = z
+	return z
+}
+
+// calendarBucket returns when the hour or the day t is in began, in t's time
+// zone, and how it reads there: 2024-10-14T09+02:00 or 2024-10-14
+func calendarBucket(t time.Time, period string) (time.Time, string) {
+	y, mo, d := t.Date()
+	if period == Day {
+		start := time.Date(y, mo, d, 0, 0, 0, 0, t.Location())
+		return start, start.Format("2006-01-02")
+	}
+	// the hour started that many minutes ago, the hour repeated when the clocks go back has an offset of its own
+	start := t.Add(-time.Duration(t.Minute())*time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	return start, start.Format("2006-01-02T15Z07:00")
+}
+
+// Close releases the session copy
+func (m *Mongo) Close() error {
+	m.session.Close()
+	return nil
+}
@@ -0,0 +1,189 @@
+package timeseries
+
+import (
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+// Rate is how fast an option received votes over one push interval
+type Rate struct {
+	Poll      string
+	Option    string
+	Time      time.Time // end of the interval
+	PerSecond float64
+	Weighted  float64 // weighted votes per second
+}
+
+// RateSink is an external system rates are pushed to
+type RateSink interface {
+	PushRates(rates []Rate) error
+}
+
+// RatesConfig selects where per-option vote rates are pushed, on top of the time series
+type RatesConfig struct {
+	// Backend is influx or remote-write, rates aren't pushed when empty
+	Backend string
+	// URL is the InfluxDB write URL or the Prometheus remote-write endpoint
+	URL string
+	// Token is the InfluxDB v2 token or the remote-write bearer token
+	Token string
+	// Interval is how often rates are pushed, 15s when zero
+	Interval time.Duration
+}
+
+// OpenRates creates the rate exporter described by cfg, nil when cfg has no backend
+func OpenRates(cfg RatesConfig) (*Rates, error) {
+	var sink RateSink
+	switch cfg.Backend {
+	case "":
+		return nil, nil
+	case "influx":
+		sink = NewInflux(cfg.URL, cfg.Token)
+	case "remote-write":
+		sink = NewRemoteWrite(cfg.URL, cfg.Token)
+	default:
+		return nil, fmt.Errorf("timeseries: unknown rates backend %q", cfg.Backend)
+	}
+	if cfg.URL == "" {
+		return nil, fmt.Errorf("timeseries: %s rates need a URL", cfg.Backend)
+	}
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = 15 * time.Second
+	}
+	return NewRates(sink, interval), nil
+}
+
+type rateKey struct {
+	poll, option string
+}
+
+type rateCount struct {
+	count    int
+	weighted float64
+}
+
+// Rates is a Store that adds up the points it is given and pushes them to a sink
+// as votes per second once per interval. An option that stops receiving votes is
+// pushed once more as 0, so charts drop to zero instead of holding the last rate.
+type Rates struct {
+	sink     RateSink
+	interval time.Duration
+
+	mu     sync.Mutex
+	counts map[rateKey]rateCount
+	active map[rateKey]bool // pushed last interval
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewRates pushes to sink every interval until it is closed
+func NewRates(sink RateSink, interval time.Duration) *Rates {
+	r := &Rates{
+		sink:     sink,
+		interval: interval,
+		counts:   make(map[rateKey]rateCount),
+		active:   make(map[rateKey]bool),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go r.run()
+	return r
+}
+
+// Write adds the points to the current interval
+func (r *Rates) Write(points []Point) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, p := range points {
+		k := rateKey{p.Poll, p.Option}
+		c := r.counts[k]
+		c.count += p.Count
+		c.weighted += p.Weighted
+		r.counts[k] = c
+	}
+	return nil
+}
+
+func (r *Rates) run() {
+	defer close(r.done)
+	t := time.NewTicker(r.interval)
+	defer t.Stop()
+	start := time.Now()
+	for {
+		select {
+		case now := <-t.C:
+			r.push(now, now.Sub(start))
+			start = now
+		case <-r.stop:
+			now := time.Now()
+			r.push(now, now.Sub(start))
+			return
+		}
+	}
+}
+
+// push sends the rates over the elapsed interval and starts the next one
+func (r *Rates) push(now time.Time, elapsed time.Duration) {
+	r.mu.Lock()
+	counts, active := r.counts, r.active
+	r.counts = make(map[rateKey]rateCount, len(counts))
+	r.active = make(map[rateKey]bool, len(counts))
+	for k := range counts {
+		r.active[k] = true
+	}
+	r.mu.Unlock()
+
+	seconds := elapsed.Seconds()
+	if seconds <= 0 {
+		return
+	}
+	var rates []Rate
+	for k, c := range counts {
+		rates = append(rates, Rate{Poll: k.poll, Option: k.option, Time: now, PerSecond: float64(c.count) / seconds, Weighted: c.weighted / seconds})
+	}
+	for k := range active {
+		if _, ok := counts[k]; !ok {
+			rates = append(rates, Rate{Poll: k.poll, Option: k.option, Time: now})
+		}
+	}
+	if len(rates) == 0 {
+		return
+	}
+	if err := r.sink.PushRates(rates); err != nil {
+		log.Println("failed to push vote rates:", err)
+	}
+}
+
+// Close pushes the last interval and stops
+func (r *Rates) Close() error {
+	close(r.stop)
+	<-r.done
+	return nil
+}
+
+// tee writes points to every store in turn
+type tee []Store
+
+func (t tee) Write(points []Point) error {
+	var first error
+	for _, s := range t {
+		if err := s.Write(points); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
+
+func (t tee) Close() error {
+	var first error
+	for _, s := range t {
+		if err := s.Close(); err != nil && first == nil {
+			first = err
+		}
+	}
+	return first
+}
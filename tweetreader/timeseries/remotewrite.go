@@ -0,0 +1,91 @@
+package timeseries
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/golang/snappy"
+)
+
+// RemoteWrite pushes rates to a Prometheus remote-write endpoint (Prometheus itself,
+// Cortex, Mimir, Thanos receive...) as the twitterpoll_vote_rate and
+// twitterpoll_weighted_vote_rate series, labelled by poll and option.
+// The protobuf encoding is written by hand, like the vote codecs, to keep protobuf out of the build.
+type RemoteWrite struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+// NewRemoteWrite creates a sink writing to url, authenticating with a bearer token when given
+func NewRemoteWrite(url, token string) *RemoteWrite {
+	return &RemoteWrite{url: url, token: token, client: &http.Client{Timeout: 10 * time.Second}}
+}
+
+// PushRates sends all rates in one WriteRequest
+func (rw *RemoteWrite) PushRates(rates []Rate) error {
+	var req []byte
+	for _, r := range rates {
+		ms := r.Time.UnixNano() / int64(time.Millisecond)
+		req = pbBytes(req, 1, timeSeries("twitterpoll_vote_rate", r.Poll, r.Option, r.PerSecond, ms))
+		req = pbBytes(req, 1, timeSeries("twitterpoll_weighted_vote_rate", r.Poll, r.Option, r.Weighted, ms))
+	}
+	httpReq, err := http.NewRequest("POST", rw.url, bytes.NewReader(snappy.Encode(nil, req)))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Encoding", "snappy")
+	httpReq.Header.Set("Content-Type", "application/x-protobuf")
+	httpReq.Header.Set("X-Prometheus-Remote-Write-Version", "0.1.0")
+	if rw.token != "" {
+		httpReq.Header.Set("Authorization", "Bearer "+rw.token)
+	}
+	resp, err := rw.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 != 2 {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("remote write failed: %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	return nil
+}
+
+// timeSeries encodes a prometheus.TimeSeries with one sample
+func timeSeries(name, poll, option string, value float64, ms int64) []byte {
+	// remote write wants the labels sorted by name
+	labels := [][2]string{{"__name__", name}, {"option", option}, {"poll", poll}}
+	var ts []byte
+	for _, l := range labels {
+		var label []byte
+		label = pbBytes(label, 1, []byte(l[0]))
+		label = pbBytes(label, 2, []byte(l[1]))
+		ts = pbBytes(ts, 1, label)
+	}
+	var sample []byte
+	sample = append(pbTag(sample, 1, 1), make([]byte, 8)...)
+	binary.LittleEndian.PutUint64(sample[len(sample)-8:], math.Float64bits(value))
+	sample = appendUvarint(pbTag(sample, 2, 0), uint64(ms))
+	return pbBytes(ts, 2, sample)
+}
+
+func pbTag(b []byte, field, wire int) []byte {
+	return appendUvarint(b, uint64(field<<3|wire))
+}
+
+func pbBytes(b []byte, field int, data []byte) []byte {
+	b = appendUvarint(pbTag(b, field, 2), uint64(len(data)))
+	return append(b, data...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutUvarint(buf[:], v)]...)
+}
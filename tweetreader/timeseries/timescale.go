@@ -0,0 +1,66 @@
+package timeseries
+
+import (
+	"database/sql"
+)
+
+// The PostgreSQL driver isn't part of the default build; build with -tags postgres
+// (see drivers_postgres.go in the main package) to link it in.
+
+// timescaleSchema creates the results table and turns it into a hypertable
+var timescaleSchema = []string{
+	`CREATE TABLE IF NOT EXISTS results_timeseries (
+		time     TIMESTAMPTZ      NOT NULL,
+		poll     TEXT             NOT NULL,
+		option   TEXT             NOT NULL,
+		count    BIGINT           NOT NULL,
+		weighted DOUBLE PRECISION NOT NULL
+	)`,
+	`SELECT create_hypertable('results_timeseries', 'time', if_not_exists => TRUE)`,
+}
+
+// Timescale writes points to a TimescaleDB hypertable
+type Timescale struct {
+	db *sql.DB
+}
+
+// OpenTimescale connects to the database at dsn and makes sure the schema exists
+func OpenTimescale(dsn string) (*Timescale, error) {
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		return nil, err
+	}
+	for _, stmt := range timescaleSchema {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &Timescale{db: db}, nil
+}
+
+// Write inserts the points in a single transaction
+func (t *Timescale) Write(points []Point) error {
+	tx, err := t.db.Begin()
+	if err != nil {
+		return err
+	}
+	stmt, err := tx.Prepare(`INSERT INTO results_timeseries (time, poll, option, count, weighted) VALUES ($1, $2, $3, $4, $5)`)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	defer stmt.Close()
+	for _, p := range points {
+		if _, err := stmt.Exec(p.Time, p.Poll, p.Option, p.Count, p.Weighted); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// Close closes the database pool
+func (t *Timescale) Close() error {
+	return t.db.Close()
+}
@@ -0,0 +1,101 @@
+// Package timeseries stores how poll results evolve over time.
+// Small deployments keep the default MongoDB buckets next to the polls,
+// larger ones can point the counter at InfluxDB or TimescaleDB instead.
+package timeseries
+
+import (
+	"fmt"
+	"time"
+
+	"gopkg.in/mgo.v2"
+)
+
+// Point is the number of votes an option received in one poll during one bucket of time
+type Point struct {
+	Poll     string
+	Option   string
+	Time     time.Time // start of the bucket
+	Count    int
+	Weighted float64
+	Timezone string // of the poll, the IANA name its calendar buckets are in, UTC when empty
+}
+
+// Store receives result points as the counter flushes tallies
+type Store interface {
+	// Write adds points to the series, points for the same poll, option and time are summed
+	Write(points []Point) error
+	// Close releases the store's connections
+	Close() error
+}
+
+// Config selects and configures the backend
+type Config struct {
+	// Backend is mongo (the default), influx, timescale or none
+	Backend string
+	// URL is the InfluxDB write URL or the TimescaleDB connection string
+	URL string
+	// Token authenticates InfluxDB v2 writes
+	Token string
+	// Bucket is the width of a time bucket for the mongo backend, one minute when zero
+	Bucket time.Duration
+	// Database is where the mongo backend keeps results_timeseries, ballots when empty
+	Database string
+	// Rates optionally pushes per-option vote rates somewhere else as well
+	Rates RatesConfig
+}
+
+// Open creates the Store described by cfg, with the rate exporter when configured.
+// session is used by the mongo backend.
+func Open(cfg Config, session *mgo.Session) (Store, error) {
+	s, err := open(cfg, session)
+	if err != nil {
+		return nil, err
+	}
+	rates, err := OpenRates(cfg.Rates)
+	if err != nil {
+		s.Close()
+		return nil, err
+	}
+	if rates == nil {
+		return s, nil
+	}
+	return tee{s, rates}, nil
+}
+
+func open(cfg Config, session *mgo.Session) (Store, error) {
+	switch cfg.Backend {
+	case "", "mongo":
+		if session == nil {
+			return nil, fmt.Errorf("timeseries: mongo backend needs the mongo store, pick influx or timescale")
+		}
+		bucket := cfg.Bucket
+		if bucket <= 0 {
+			bucket = time.Minute
+		}
+		database := cfg.Database
+		if database == "" {
+			database = "ballots"
+		}
+		return NewMongo(session, database, bucket), nil
+	case "influx":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("timeseries: influx backend needs a write URL")
+		}
+		return NewInflux(cfg.URL, cfg.Token), nil
+	case "timescale":
+		if cfg.URL == "" {
+			return nil, fmt.Errorf("timeseries: timescale backend needs a connection string")
+		}
+		return OpenTimescale(cfg.URL)
+	case "none":
+		return discard{}, nil
+	default:
+		return nil, fmt.Errorf("timeseries: unknown backend %q", cfg.Backend)
+	}
+}
+
+// discard is the Store used when no time series is wanted
+type discard struct{}
+
+func (discard) Write([]Point) error { return nil }
+func (discard) Close() error        { return nil }
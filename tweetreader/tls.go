@@ -0,0 +1,104 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"time"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+)
+
+// tlsConfig builds the client TLS configuration for the service whose
+// settings start with prefix: prefix_TLS turns it on with the system roots,
+// prefix_TLS_CA trusts a private CA instead, prefix_TLS_CERT and
+// prefix_TLS_KEY present a client certificate and prefix_TLS_MIN_VERSION
+// (1.2, the default, or 1.3) refuses older versions.
+// It returns nil when none of them are set.
+func tlsConfig(prefix string) (*tls.Config, error) {
+	var (
+		ca   = os.Getenv(prefix + "_TLS_CA")
+		cert = os.Getenv(prefix + "_TLS_CERT")
+		key  = os.Getenv(prefix + "_TLS_KEY")
+		min  = os.Getenv(prefix + "_TLS_MIN_VERSION")
+	)
+	if os.Getenv(prefix+"_TLS") == "" && ca == "" && cert == "" && key == "" && min == "" {
+		return nil, nil
+	}
+	cfg := &tls.Config{MinVersion: tls.VersionTLS12}
+	switch min {
+	case "", "1.2":
+	case "1.3":
+		cfg.MinVersion = tls.VersionTLS13
+	default:
+		return nil, fmt.Errorf("invalid %s_TLS_MIN_VERSION %q, want 1.2 or 1.3", prefix, min)
+	}
+	if ca != "" {
+		pem, err := ioutil.ReadFile(ca)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_TLS_CA: %v", prefix, err)
+		}
+		cfg.RootCAs = x509.NewCertPool()
+		if !cfg.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("invalid %s_TLS_CA: no PEM certificates in %s", prefix, ca)
+		}
+	}
+	if cert != "" || key != "" {
+		pair, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s_TLS_CERT or %s_TLS_KEY: %v", prefix, prefix, err)
+		}
+		cfg.Certificates = []tls.Certificate{pair}
+	}
+	return cfg, nil
+}
+
+// newPublisher creates the publisher for topic on the nsqd in NSQD_ADDR,
+// checking its connection every NSQD_HEALTH_INTERVAL (0 to never)
+func newPublisher(topic string) (*publish.NSQ, error) {
+	cfg, err := nsqConfig()
+	if err != nil {
+		return nil, err
+	}
+	pub, err := publish.NewNSQ(nsqdAddr, topic, cfg)
+	if err != nil {
+		return nil, err
+	}
+	pub.Watch(envDuration("NSQD_HEALTH_INTERVAL", 5*time.Second))
+	return pub, nil
+}
+
+// nsqConfig returns the configuration every NSQ producer and consumer connects with:
+// TLS as set by the NSQ_TLS variables, the NSQ_AUTH_SECRET nsqd's auth server expects,
+// and the compression of the connection to nsqd, NSQ_COMPRESSION snappy or deflate
+// at NSQ_DEFLATE_LEVEL
+func nsqConfig() (*nsq.Config, error) {
+	cfg := nsq.NewConfig()
+	t, err := tlsConfig("NSQ")
+	if err != nil {
+		return nil, err
+	}
+	if t != nil {
+		cfg.TlsV1 = true
+		cfg.TlsConfig = t
+	}
+	cfg.AuthSecret = secret("NSQ_AUTH_SECRET")
+	switch c := envString("NSQ_COMPRESSION", ""); c {
+	case "":
+	case "snappy":
+		cfg.Snappy = true
+	case "deflate":
+		cfg.Deflate = true
+		cfg.DeflateLevel = int(envInt64("NSQ_DEFLATE_LEVEL", 6))
+		if cfg.DeflateLevel < 1 || cfg.DeflateLevel > 9 {
+			return nil, fmt.Errorf("invalid NSQ_DEFLATE_LEVEL %d, want 1 to 9", cfg.DeflateLevel)
+		}
+	default:
+		return nil, fmt.Errorf("invalid NSQ_COMPRESSION %q, want snappy or deflate", c)
+	}
+	return cfg, nil
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/degrade"
+	"github.com/olawolu/twitter-polls/tweetreader/publish"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// sourceNames are what -source picks from
+var sourceNames = []string{"twitter", "twitter-search", "activity", "youtube", "twitch", "telegram", "feeds", "synthetic"}
+
+// streamSettings are the settings of the stream command that depend on each
+// other or on the environment, for validate to check before anything starts
+type streamSettings struct {
+	source       string
+	publishers   []string
+	overflow     string
+	dryRun       bool
+	outbox       bool
+	sharded      bool
+	elect        bool
+	tenantTopics bool
+	partitions   int
+	grace        time.Duration
+	drainDelay   time.Duration
+	rollupWindow time.Duration
+	activityAddr string
+	degradeSteps string
+}
+
+// envStreamSettings returns the settings the stream command starts with when
+// no flags override the environment
+func envStreamSettings(source string) streamSettings {
+	return streamSettings{
+		source:       source,
+		publishers:   splitList(envString("PUBLISHERS", "nsq")),
+		overflow:     envString("VOTES_OVERFLOW", publish.Block),
+		dryRun:       getenv("DRY_RUN") != "",
+		outbox:       getenv("OUTBOX") != "",
+		sharded:      getenv("SHARD") != "",
+		elect:        getenv("LEADER_ELECTION") != "",
+		tenantTopics: getenv("TENANT_TOPICS") != "",
+		partitions:   int(envInt64("VOTE_PARTITIONS", 0)),
+		grace:        envDuration("SHUTDOWN_GRACE", 0),
+		drainDelay:   envDuration("DRAIN_DELAY", 0),
+		rollupWindow: envDuration("ROLLUP_WINDOW", 0),
+		activityAddr: envString("ACTIVITY_ADDR", ""),
+		degradeSteps: envString("DEGRADE_LADDER", ""),
+	}
+}
+
+// validate returns every setting of s that can't work, alone or with the
+// others, so the streamer refuses to start instead of failing once it streams
+func (s streamSettings) validate() error {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if s.sharded && s.elect {
+		add("-shard and -leader-election don't go together, shards already share the work")
+	}
+	if s.grace > 0 && s.drainDelay >= s.grace {
+		add("-drain-delay %s leaves nothing of -shutdown-grace %s to stop in", s.drainDelay, s.grace)
+	}
+	if s.rollupWindow != 0 && s.rollupWindow < time.Second {
+		add("-rollup-window %s is too short, the windows are whole seconds", s.rollupWindow)
+	}
+	if s.dryRun && (s.sharded || s.elect) {
+		add("-dry-run doesn't go with -shard or -leader-election, it would take work from the live streamers")
+	}
+	if s.dryRun && s.outbox {
+		add("-dry-run doesn't go with -outbox, the relay would publish the votes")
+	}
+
+	switch s.source {
+	case "twitter", "twitter-search":
+		switch framing := envString("TWITTER_STREAM_FRAMING", stream.FramingV1); {
+		case framing != stream.FramingV1 && framing != stream.FramingV2:
+			add("invalid TWITTER_STREAM_FRAMING %q, want v1 or v2", framing)
+		case framing == stream.FramingV2 && secret("TWITTER_BEARER_TOKEN") == "":
+			add("TWITTER_STREAM_FRAMING v2 authenticates with the app's bearer token, set TWITTER_BEARER_TOKEN or stream v1")
+		}
+	case "activity":
+		if s.activityAddr == "" {
+			add("-source activity takes the tweets posted to its webhook, set -activity-addr")
+		}
+	default:
+		if !contains(sourceNames, s.source) {
+			add("invalid -source %q, want %s", s.source, strings.Join(sourceNames, ", "))
+		}
+	}
+	switch s.overflow {
+	case publish.Block, publish.DropOldest, publish.DropNew:
+	default:
+		add("invalid -votes-overflow %q, want %s, %s or %s", s.overflow, publish.Block, publish.DropOldest, publish.DropNew)
+	}
+
+	if _, err := degrade.ParseLadder(s.degradeSteps); err != nil {
+		add("invalid -degrade-ladder %q, want some of %s, each once", s.degradeSteps, strings.Join(degrade.Steps, ", "))
+	}
+
+	for _, name := range s.publishers {
+		if !contains(publishers, name) {
+			add("invalid -publishers %q, want %s", name, strings.Join(publishers, ", "))
+		}
+	}
+	if !contains(s.publishers, "nsq") {
+		add("-publishers must include nsq, the counter consumes the votes there")
+	}
+	// a dry run publishes to none of them
+	if contains(s.publishers, "kafka") && !s.dryRun && envString("KAFKA_REST_URL", "") == "" {
+		add("-publishers kafka needs KAFKA_REST_URL, the URL of the Kafka REST Proxy")
+	}
+
+	switch {
+	case s.partitions < 0:
+		add("invalid -partitions %d", s.partitions)
+	case s.partitions > 0 && s.tenantTopics:
+		add("-partitions and -tenant-topics route the votes to different topics, pick one")
+	}
+	if s.tenantTopics && getenv("MONGO_TENANTS") == "" {
+		add("-tenant-topics needs the tenants' collections in MONGO_TENANTS")
+	}
+
+	switch len(problems) {
+	case 0:
+		return nil
+	case 1:
+		return fmt.Errorf("%s", problems[0])
+	}
+	return fmt.Errorf("%d problems with the configuration:\n\t%s", len(problems), strings.Join(problems, "\n\t"))
+}
+
+// contains tells whether list has s
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
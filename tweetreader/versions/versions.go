@@ -0,0 +1,82 @@
+// Package versions tags the votes with the version of the options of every
+// tracked poll having their option, as the streamer knew it when it matched
+// them. A poll's options edited mid-poll get a new version: the counter
+// tallies the votes of each version apart, so what an edit of the keywords
+// did to the results can be told.
+package versions
+
+import (
+	"log"
+	"sync"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Tagger tags the votes with the versions of the options of the polls
+type Tagger struct {
+	mu sync.RWMutex
+	of map[string][]string // the version tags of the tracked polls, per option
+}
+
+// New creates a Tagger tagging nothing until Update is called
+func New() *Tagger {
+	return &Tagger{}
+}
+
+// Update picks up the versions of the options of the tracked polls
+func (t *Tagger) Update(polls []store.Poll) {
+	of := make(map[string][]string)
+	for _, p := range polls {
+		if !p.Tracked() {
+			continue
+		}
+		tag := match.VersionTag(p.ID, p.OptionsVersion())
+		for _, o := range p.Options {
+			of[o] = append(of[o], tag)
+		}
+	}
+	t.mu.Lock()
+	t.of = of
+	t.mu.Unlock()
+}
+
+// Options wraps a function loading the options so every load also picks up
+// the versions of the polls having them. When the polls can't be loaded the
+// last versions are kept.
+func (t *Tagger) Options(polls store.PollStore, load func() ([]string, error)) func() ([]string, error) {
+	return func() ([]string, error) {
+		options, err := load()
+		if err != nil {
+			return nil, err
+		}
+		all, err := polls.Polls()
+		if err != nil {
+			log.Println("versions: failed to load the polls, keeping the last versions:", err)
+			return options, nil
+		}
+		t.Update(all)
+		return options, nil
+	}
+}
+
+// Tag sets the versions of the polls having v's option on v
+func (t *Tagger) Tag(v *match.Vote) {
+	t.mu.RLock()
+	v.Versions = t.of[v.Option]
+	t.mu.RUnlock()
+}
+
+// Run tags the votes from in and passes them on, the returned channel is
+// closed once in is
+func (t *Tagger) Run(in <-chan match.Vote) <-chan match.Vote {
+	out := make(chan match.Vote)
+	go func() {
+		defer close(out)
+		for v := range in {
+			t.Tag(&v)
+			out <- v
+		}
+	}()
+	return out
+}
@@ -0,0 +1,109 @@
+// Package votesapi serves votes and poll management over gRPC.
+//
+// The service is described in votes.proto. The generated code and the server
+// need the gRPC modules, so they are only built with the grpc tag:
+//
+//	go get google.golang.org/grpc google.golang.org/protobuf
+//	go generate ./votesapi && go build -tags grpc
+//
+// Hub, which fans votes out to subscribers, is part of the default build.
+package votesapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative votes.proto
+
+import (
+	"log"
+	"sync"
+
+	"github.com/nsqio/go-nsq"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+)
+
+// Hub fans the votes consumed from NSQ out to every subscriber.
+// A subscriber that falls behind misses votes rather than holding up the others.
+type Hub struct {
+	buffer int
+
+	mu   sync.Mutex
+	subs map[*Subscription]struct{}
+}
+
+// Subscription receives the votes for the options it asked for on C
+type Subscription struct {
+	C <-chan match.Vote
+
+	c       chan match.Vote
+	options map[string]bool // nil for every option
+
+	mu      sync.Mutex
+	dropped int
+}
+
+// NewHub creates a hub buffering up to buffer votes per subscriber
+func NewHub(buffer int) *Hub {
+	return &Hub{buffer: buffer, subs: make(map[*Subscription]struct{})}
+}
+
+// Subscribe starts receiving votes for options, or every vote when options is empty
+func (h *Hub) Subscribe(options []string) *Subscription {
+	c := make(chan match.Vote, h.buffer)
+	s := &Subscription{C: c, c: c}
+	if len(options) > 0 {
+		s.options = make(map[string]bool, len(options))
+		for _, option := range options {
+			s.options[option] = true
+		}
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.subs[s] = struct{}{}
+	return s
+}
+
+// Unsubscribe stops the subscription and closes its channel
+func (h *Hub) Unsubscribe(s *Subscription) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.subs[s]; ok {
+		delete(h.subs, s)
+		close(s.c)
+	}
+}
+
+// Dropped returns how many votes the subscriber missed because it fell behind
+func (s *Subscription) Dropped() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.dropped
+}
+
+// Publish hands v to every subscriber that wants it
+func (h *Hub) Publish(v match.Vote) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for s := range h.subs {
+		if s.options != nil && !s.options[v.Option] {
+			continue
+		}
+		select {
+		case s.c <- v:
+		default:
+			s.mu.Lock()
+			s.dropped++
+			s.mu.Unlock()
+		}
+	}
+}
+
+// HandleMessage decodes a vote published on the votes topic, it makes Hub an nsq.Handler
+func (h *Hub) HandleMessage(m *nsq.Message) error {
+	var v match.Vote
+	if _, err := codec.Decode(m.Body, &v); err != nil {
+		log.Println("Unmarshall error: ", err)
+		return nil
+	}
+	h.Publish(v)
+	return nil
+}
@@ -0,0 +1,205 @@
+//go:build grpc
+// +build grpc
+
+package votesapi
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+)
+
+// Server implements the Votes service on top of a Hub and a poll store
+type Server struct {
+	UnimplementedVotesServer
+	hub *Hub
+	db  store.Backend
+}
+
+// NewServer creates a server streaming votes from hub and managing the polls in db
+func NewServer(hub *Hub, db store.Backend) *Server {
+	return &Server{hub: hub, db: db}
+}
+
+// SubscribeVotes streams votes until the client goes away
+func (s *Server) SubscribeVotes(req *SubscribeVotesRequest, stream Votes_SubscribeVotesServer) error {
+	options := req.Options
+	if req.PollId != "" {
+		p, err := s.db.Poll(req.PollId)
+		if err != nil {
+			return storeErr(err)
+		}
+		options = intersect(p.Options, req.Options)
+	}
+	sub := s.hub.Subscribe(options)
+	defer s.hub.Unsubscribe(sub)
+	for {
+		select {
+		case v := <-sub.C:
+			if err := stream.Send(toVote(v)); err != nil {
+				return err
+			}
+		case <-stream.Context().Done():
+			return nil
+		}
+	}
+}
+
+// ListPolls returns every poll with its results
+func (s *Server) ListPolls(ctx context.Context, req *ListPollsRequest) (*ListPollsResponse, error) {
+	polls, err := s.db.Polls()
+	if err != nil {
+		return nil, storeErr(err)
+	}
+	resp := &ListPollsResponse{}
+	for i := range polls {
+		resp.Polls = append(resp.Polls, toPoll(polls[i]))
+	}
+	return resp, nil
+}
+
+// GetPoll returns a single poll
+func (s *Server) GetPoll(ctx context.Context, req *GetPollRequest) (*Poll, error) {
+	p, err := s.db.Poll(req.Id)
+	if err != nil {
+		return nil, storeErr(err)
+	}
+	return toPoll(p), nil
+}
+
+// CreatePoll stores a new active poll
+func (s *Server) CreatePoll(ctx context.Context, req *CreatePollRequest) (*Poll, error) {
+	if req.Title == "" || len(req.Options) == 0 {
+		return nil, status.Error(codes.InvalidArgument, "title and options are required")
+	}
+	p := store.Poll{
+		Title:           req.Title,
+		Options:         req.Options,
+		Status:          "active",
+		Type:            req.Type,
+		Visibility:      req.Visibility,
+		DetailedMetrics: req.DetailedMetrics,
+	}
+	if err := s.db.CreatePoll(&p); err != nil {
+		return nil, storeErr(err)
+	}
+	return toPoll(p), nil
+}
+
+// DeletePoll removes a poll
+func (s *Server) DeletePoll(ctx context.Context, req *DeletePollRequest) (*DeletePollResponse, error) {
+	if err := s.db.DeletePoll(req.Id); err != nil {
+		return nil, storeErr(err)
+	}
+	return &DeletePollResponse{}, nil
+}
+
+// storeErr maps store errors onto gRPC status codes
+func storeErr(err error) error {
+	if err == store.ErrNotFound {
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// intersect returns the poll options that are also in wanted, or all of them when wanted is empty
+func intersect(options, wanted []string) []string {
+	if len(wanted) == 0 {
+		return options
+	}
+	keep := make(map[string]bool, len(wanted))
+	for _, w := range wanted {
+		keep[w] = true
+	}
+	var out []string
+	for _, o := range options {
+		if keep[o] {
+			out = append(out, o)
+		}
+	}
+	if out == nil {
+		out = []string{""} // none of the requested options are in the poll, match nothing
+	}
+	return out
+}
+
+func toVote(v match.Vote) *Vote {
+	out := &Vote{
+		TweetId:   v.ID,
+		CreatedAt: v.CreatedAt,
+		Text:      v.Text,
+		User: &User{
+			Name:           v.User.Name,
+			ScreenName:     v.User.ScreenName,
+			Verified:       v.User.Verified,
+			FollowersCount: int64(v.User.FollowersCount),
+		},
+		Option:      v.Option,
+		Weight:      v.Weight,
+		Hashtag:     v.Hashtag,
+		MessageId:   v.MessageID,
+		Suspect:     v.Suspect,
+		Scale:       int64(v.Scale),
+		Retweet:     v.Retweet,
+		Quote:       v.Quote,
+		Reply:       v.Reply,
+		Embedded:    v.Embedded,
+		CaseFolded:  v.CaseFolded,
+		Partial:     v.Partial,
+		Tenants:     v.Tenants,
+		Source:      v.Source,
+		Lang:        v.Lang,
+		Folded:      v.Folded,
+		Stemmed:     v.Stemmed,
+		Count:       int64(v.Count),
+		Window:      int64(v.Window),
+		Instance:    v.Instance,
+		Filtered:    v.Filtered,
+		Undelivered: v.Undelivered,
+		Versions:    v.Versions,
+	}
+	if h := v.Hit; h != nil {
+		out.Hit = &Hit{
+			Term:         h.Term,
+			Start:        int64(h.Start),
+			End:          int64(h.End),
+			Snippet:      h.Snippet,
+			SnippetStart: int64(h.SnippetStart),
+		}
+	}
+	if g := v.Geo; g != nil {
+		out.Geo = &Geo{
+			Longitude:   g.Longitude,
+			Latitude:    g.Latitude,
+			Exact:       g.Exact,
+			PlaceId:     g.PlaceID,
+			Place:       g.Place,
+			CountryCode: g.CountryCode,
+			Country:     g.Country,
+			Region:      g.Region,
+		}
+	}
+	return out
+}
+
+func toPoll(p store.Poll) *Poll {
+	out := &Poll{
+		Id:              p.ID,
+		Title:           p.Title,
+		Options:         p.Options,
+		Results:         make(map[string]int64, len(p.Results)),
+		WeightedResults: p.WeightedResults,
+		Status:          p.Status,
+		Type:            p.Type,
+		Visibility:      p.Visibility,
+		DetailedMetrics: p.DetailedMetrics,
+	}
+	for option, n := range p.Results {
+		out.Results[option] = int64(n)
+	}
+	return out
+}
@@ -0,0 +1,205 @@
+package warehouse
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/export"
+)
+
+// BigQueryURL is the BigQuery API
+const BigQueryURL = "https://bigquery.googleapis.com/bigquery/v2"
+
+// insertRows is how many rows go in an insertAll request, well under its limits
+const insertRows = 500
+
+// BigQueryConfig is the dataset the tables are loaded into
+type BigQueryConfig struct {
+	Project string
+	Dataset string
+	// URL is the API, BigQueryURL by default
+	URL string
+	// Credentials is the JSON key of a service account. Without it the access
+	// tokens are asked of the metadata server of the instance it runs on.
+	Credentials []byte
+	// Timeout bounds every request, a minute by default
+	Timeout time.Duration
+}
+
+// BigQuery loads the tables with BigQuery's streaming inserts. A table that
+// doesn't exist yet is created, partitioned by day on its first time column.
+// Every row has an insert ID made of its batch and its place in it, so
+// BigQuery drops the rows of a batch loaded again, as far as it remembers them.
+type BigQuery struct {
+	cfg    BigQueryConfig
+	client *http.Client
+	tokens *tokenSource
+}
+
+// NewBigQuery creates a sink loading into the dataset of cfg
+func NewBigQuery(cfg BigQueryConfig) (*BigQuery, error) {
+	if cfg.Project == "" || cfg.Dataset == "" {
+		return nil, fmt.Errorf("warehouse: BigQuery needs a project and a dataset")
+	}
+	if cfg.URL == "" {
+		cfg.URL = BigQueryURL
+	}
+	if cfg.Timeout <= 0 {
+		cfg.Timeout = time.Minute
+	}
+	client := &http.Client{Timeout: cfg.Timeout}
+	tokens, err := newTokenSource(cfg.Credentials, client)
+	if err != nil {
+		return nil, err
+	}
+	return &BigQuery{cfg: cfg, client: client, tokens: tokens}, nil
+}
+
+// Load inserts the rows of t into table, insertRows at a time
+func (b *BigQuery) Load(table, batch string, t *export.Table) error {
+	var buf bytes.Buffer
+	if err := export.Write(&buf, export.JSON, t); err != nil {
+		return err
+	}
+	dec := json.NewDecoder(&buf)
+	type row struct {
+		InsertID string          `json:"insertId"`
+		JSON     json.RawMessage `json:"json"`
+	}
+	var rows []row
+	flush := func() error {
+		if len(rows) == 0 {
+			return nil
+		}
+		err := b.insert(table, rows)
+		if status, ok := err.(statusError); ok && status == http.StatusNotFound {
+			if err = b.createTable(table, t.Columns); err == nil {
+				err = b.insert(table, rows)
+			}
+		}
+		rows = rows[:0]
+		return err
+	}
+	for i := 0; ; i++ {
+		var raw json.RawMessage
+		if err := dec.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return err
+		}
+		rows = append(rows, row{InsertID: batch + "/" + strconv.Itoa(i), JSON: raw})
+		if len(rows) == insertRows {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	return flush()
+}
+
+// statusError is a request BigQuery answered with its status
+type statusError int
+
+func (s statusError) Error() string {
+	return "warehouse: BigQuery answered " + strconv.Itoa(int(s)) + " " + http.StatusText(int(s))
+}
+
+// insert sends rows to tabledata.insertAll, failing when any row wasn't inserted
+func (b *BigQuery) insert(table string, rows interface{}) error {
+	var answer struct {
+		InsertErrors []struct {
+			Index  int `json:"index"`
+			Errors []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"insertErrors"`
+	}
+	err := b.do("POST", "/datasets/"+url.PathEscape(b.cfg.Dataset)+"/tables/"+url.PathEscape(table)+"/insertAll",
+		map[string]interface{}{"rows": rows}, &answer)
+	if err != nil {
+		return err
+	}
+	if n := len(answer.InsertErrors); n > 0 {
+		e := answer.InsertErrors[0]
+		msg := "unknown error"
+		if len(e.Errors) > 0 {
+			msg = e.Errors[0].Reason + ": " + e.Errors[0].Message
+		}
+		return fmt.Errorf("warehouse: %d rows not inserted into %s, row %d: %s", n, table, e.Index, msg)
+	}
+	return nil
+}
+
+// createTable creates table with a column of columns' kind each
+func (b *BigQuery) createTable(table string, columns []export.Column) error {
+	types := map[export.Kind]string{
+		export.String: "STRING",
+		export.Int:    "INTEGER",
+		export.Float:  "FLOAT",
+		export.Bool:   "BOOLEAN",
+		export.Time:   "TIMESTAMP",
+	}
+	fields := make([]map[string]string, len(columns))
+	var partition string
+	for i, c := range columns {
+		fields[i] = map[string]string{"name": c.Name, "type": types[c.Kind]}
+		if c.Kind == export.Time && partition == "" {
+			partition = c.Name
+		}
+	}
+	def := map[string]interface{}{
+		"tableReference": map[string]string{"projectId": b.cfg.Project, "datasetId": b.cfg.Dataset, "tableId": table},
+		"schema":         map[string]interface{}{"fields": fields},
+	}
+	if partition != "" {
+		def["timePartitioning"] = map[string]string{"type": "DAY", "field": partition}
+	}
+	err := b.do("POST", "/datasets/"+url.PathEscape(b.cfg.Dataset)+"/tables", def, nil)
+	if status, ok := err.(statusError); ok && status == http.StatusConflict {
+		// another load created it meanwhile
+		return nil
+	}
+	return err
+}
+
+// do sends body as JSON to path of the project and decodes the answer into v, when set
+func (b *BigQuery) do(method, path string, body, v interface{}) error {
+	token, err := b.tokens.token()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(method, b.cfg.URL+"/projects/"+url.PathEscape(b.cfg.Project)+path, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+	resp, err := b.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusConflict {
+		return statusError(resp.StatusCode)
+	}
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return fmt.Errorf("warehouse: %s %s answered %s: %s", method, path, resp.Status, bytes.TrimSpace(msg))
+	}
+	if v == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,30 @@
+package warehouse
+
+import (
+	"bytes"
+	"compress/gzip"
+
+	"github.com/olawolu/twitter-polls/tweetreader/archive"
+	"github.com/olawolu/twitter-polls/tweetreader/export"
+)
+
+// Files loads every batch as a gzipped CSV file with a header, under
+// <table>/<batch>.csv.gz in a bucket or a directory, for the warehouse to load
+// from, e.g. with BigQuery's load jobs from Cloud Storage. A batch loaded again
+// replaces the file.
+type Files struct {
+	Sink archive.Sink
+}
+
+// Load writes t to the file of batch
+func (f Files) Load(table, batch string, t *export.Table) error {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if err := export.Write(zw, export.CSV, t); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	return f.Sink.Put(table+"/"+batch+".csv.gz", buf.Bytes())
+}
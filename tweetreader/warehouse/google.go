@@ -0,0 +1,153 @@
+package warehouse
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// bigQueryScope is what the access tokens are asked for
+const bigQueryScope = "https://www.googleapis.com/auth/bigquery"
+
+// metadataTokenURL hands out the access tokens of the instance's service account
+const metadataTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+
+// serviceAccount is what a service account's JSON key holds of use here
+type serviceAccount struct {
+	ClientEmail string `json:"client_email"`
+	PrivateKey  string `json:"private_key"`
+	TokenURI    string `json:"token_uri"`
+
+	key *rsa.PrivateKey
+}
+
+// tokenSource gets the OAuth access tokens, from a service account's key or
+// the metadata server, and keeps each until shortly before it expires
+type tokenSource struct {
+	account *serviceAccount // nil for the metadata server
+	client  *http.Client
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+func newTokenSource(credentials []byte, client *http.Client) (*tokenSource, error) {
+	ts := &tokenSource{client: client}
+	if len(credentials) == 0 {
+		return ts, nil
+	}
+	var sa serviceAccount
+	if err := json.Unmarshal(credentials, &sa); err != nil {
+		return nil, fmt.Errorf("warehouse: unreadable service account key: %v", err)
+	}
+	if sa.ClientEmail == "" || sa.PrivateKey == "" {
+		return nil, fmt.Errorf("warehouse: the service account key has no client_email or private_key")
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("warehouse: the service account's private_key isn't PEM")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes); err != nil {
+			return nil, fmt.Errorf("warehouse: unreadable service account private_key: %v", err)
+		}
+	}
+	var ok bool
+	if sa.key, ok = parsed.(*rsa.PrivateKey); !ok {
+		return nil, fmt.Errorf("warehouse: the service account's private_key isn't an RSA key")
+	}
+	ts.account = &sa
+	return ts, nil
+}
+
+// token returns an access token valid for a minute at least
+func (ts *tokenSource) token() (string, error) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+	if ts.current != "" && time.Until(ts.expires) > time.Minute {
+		return ts.current, nil
+	}
+	var req *http.Request
+	var err error
+	if ts.account != nil {
+		req, err = ts.account.tokenRequest(time.Now())
+	} else {
+		req, err = http.NewRequest("GET", metadataTokenURL, nil)
+		if err == nil {
+			req.Header.Set("Metadata-Flavor", "Google")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	resp, err := ts.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("warehouse: failed to get an access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(resp.Body, 4096))
+		return "", fmt.Errorf("warehouse: getting an access token answered %s: %s", resp.Status, bytes.TrimSpace(msg))
+	}
+	var answer struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return "", fmt.Errorf("warehouse: unreadable access token: %v", err)
+	}
+	ts.current = answer.AccessToken
+	ts.expires = time.Now().Add(time.Duration(answer.ExpiresIn) * time.Second)
+	return ts.current, nil
+}
+
+// tokenRequest asks for an access token with a JWT signed by the account's key
+func (sa *serviceAccount) tokenRequest(now time.Time) (*http.Request, error) {
+	enc := base64.RawURLEncoding
+	header := enc.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   sa.ClientEmail,
+		"scope": bigQueryScope,
+		"aud":   sa.TokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	signed := header + "." + enc.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(signed))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, sa.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {signed + "." + enc.EncodeToString(sig)},
+	}
+	req, err := http.NewRequest("POST", sa.TokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return req, nil
+}
@@ -0,0 +1,192 @@
+// Package warehouse loads the votes and the polls' results into a data
+// warehouse for analysts, so they don't need dumps of the ballots database.
+//
+// Every load appends the votes the vote log got since the last load to the
+// votes table, and a snapshot of every poll's results as of the load to the
+// results table. Loads go to BigQuery, through its streaming insert API, or
+// as gzipped CSV files to a bucket or a directory, for BigQuery or any other
+// warehouse to load from. The time the last load reached is kept in the
+// store, a failed load is retried from there the next time.
+package warehouse
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/olawolu/twitter-polls/tweetreader/codec"
+	"github.com/olawolu/twitter-polls/tweetreader/export"
+	"github.com/olawolu/twitter-polls/tweetreader/match"
+	"github.com/olawolu/twitter-polls/tweetreader/metrics"
+	"github.com/olawolu/twitter-polls/tweetreader/store"
+	"github.com/olawolu/twitter-polls/tweetreader/stream"
+)
+
+// snapshot is the name the loads' state is saved under
+const snapshot = "warehouse"
+
+// The tables loaded
+const (
+	Votes   = "votes"
+	Results = "results"
+)
+
+// batchFormat names a batch by the times its window starts and ends
+const batchFormat = "20060102T150405Z"
+
+var loaded = metrics.NewCounter("tweetreader_warehouse_loaded_rows_total",
+	"Rows loaded into the warehouse, by table.")
+
+// Sink is the warehouse the tables are loaded into
+type Sink interface {
+	// Load appends the rows of t to table. Loading the same batch again
+	// replaces it, or the warehouse drops the rows it already has.
+	Load(table, batch string, t *export.Table) error
+}
+
+// Report is what a load did
+type Report struct {
+	From    time.Time `json:"from"`
+	To      time.Time `json:"to"`
+	Votes   int       `json:"votes"`
+	Results int       `json:"results"`
+}
+
+// state is what the loads remember across runs
+type state struct {
+	// Votes is the time the votes were loaded up to
+	Votes time.Time `json:"votes"`
+}
+
+// Load loads the votes received since the last load and up to now, and the
+// results of every poll, from db into sink
+func Load(db store.Backend, sink Sink, now time.Time) (Report, error) {
+	now = now.UTC().Truncate(time.Second)
+	st, err := loadState(db)
+	if err != nil {
+		return Report{}, fmt.Errorf("failed to load the last load's state: %v", err)
+	}
+	r := Report{From: st.Votes, To: now}
+	batch := st.Votes.Format(batchFormat) + "_" + now.Format(batchFormat)
+	polls, err := db.Polls()
+	if err != nil {
+		return r, fmt.Errorf("failed to load the polls: %v", err)
+	}
+
+	if vl, ok := db.(store.VoteLogStore); ok {
+		votes, err := votesTable(vl, polls, st.Votes, now)
+		if err != nil {
+			return r, fmt.Errorf("reading the vote log: %v", err)
+		}
+		if err := sink.Load(Votes, batch, votes); err != nil {
+			return r, fmt.Errorf("loading the votes: %v", err)
+		}
+		r.Votes = len(votes.Rows)
+		loaded.Add(float64(r.Votes), "table", Votes)
+	} else {
+		log.Println("warehouse: this store keeps no vote log, only loading the results")
+	}
+
+	results := resultsTable(polls, now)
+	if err := sink.Load(Results, batch, results); err != nil {
+		return r, fmt.Errorf("loading the results: %v", err)
+	}
+	r.Results = len(results.Rows)
+	loaded.Add(float64(r.Results), "table", Results)
+
+	st.Votes = now
+	if err := saveState(db, st); err != nil {
+		// the next load starts over from the last saved time, the batch is loaded again
+		return r, fmt.Errorf("failed to save the load's state: %v", err)
+	}
+	return r, nil
+}
+
+// votesTable returns a row per poll of every vote logged after from and up to to
+func votesTable(vl store.VoteLogStore, polls []store.Poll, from, to time.Time) (*export.Table, error) {
+	t := &export.Table{Columns: []export.Column{
+		{Name: "received", Kind: export.Time},
+		{Name: "poll", Kind: export.String},
+		{Name: "message_id", Kind: export.String},
+		{Name: "tweet_id", Kind: export.String},
+		{Name: "option", Kind: export.String},
+		{Name: "author", Kind: export.String},
+		{Name: "source", Kind: export.String},
+		{Name: "language", Kind: export.String},
+		{Name: "country", Kind: export.String},
+		{Name: "weight", Kind: export.Float},
+		{Name: "hashtag", Kind: export.Bool},
+		{Name: "retweet", Kind: export.Bool},
+		{Name: "quote", Kind: export.Bool},
+		{Name: "reply", Kind: export.Bool},
+		{Name: "suspect", Kind: export.Bool},
+	}}
+	ids := make([]string, len(polls))
+	for i, p := range polls {
+		ids[i] = p.ID
+	}
+	err := vl.ScanVotes(ids, func(lv store.LoggedVote) error {
+		if !lv.Received.After(from) || lv.Received.After(to) {
+			return nil
+		}
+		var v match.Vote
+		if _, err := codec.Decode(lv.Body, &v); err != nil {
+			log.Printf("warehouse: skipping a logged vote that doesn't decode: %v", err)
+			return nil
+		}
+		var country string
+		if v.Geo != nil {
+			country = v.Geo.CountryCode
+		}
+		source := v.Source
+		if source == "" {
+			source = stream.SourceTwitter
+		}
+		for _, poll := range lv.Polls {
+			t.Add(lv.Received, poll, lv.Key, v.ID, v.Option, v.User.ScreenName, source, v.Lang, country,
+				v.Weight, v.Hashtag, v.Retweet, v.Quote, v.Reply, v.Suspect)
+		}
+		return nil
+	})
+	return t, err
+}
+
+// resultsTable returns a row per option of every poll's results at now
+func resultsTable(polls []store.Poll, now time.Time) *export.Table {
+	t := &export.Table{Columns: []export.Column{
+		{Name: "time", Kind: export.Time},
+		{Name: "poll", Kind: export.String},
+		{Name: "title", Kind: export.String},
+		{Name: "status", Kind: export.String},
+		{Name: "option", Kind: export.String},
+		{Name: "votes", Kind: export.Int},
+		{Name: "weighted", Kind: export.Float},
+	}}
+	for _, p := range polls {
+		for _, o := range p.Options {
+			t.Add(now, p.ID, p.Title, p.Status, o, int64(p.Results[o]), p.WeightedResults[o])
+		}
+	}
+	return t
+}
+
+func loadState(db store.SnapshotStore) (state, error) {
+	var st state
+	b, err := db.LoadSnapshot(snapshot)
+	if err == store.ErrNoSnapshot {
+		return st, nil
+	}
+	if err != nil {
+		return st, err
+	}
+	return st, json.Unmarshal(b, &st)
+}
+
+func saveState(db store.SnapshotStore, st state) error {
+	b, err := json.Marshal(st)
+	if err != nil {
+		return err
+	}
+	return db.SaveSnapshot(snapshot, b)
+}